@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/baowuhe/go-cfr2/config"
+)
+
+// builtinCommands lists the commands handled in main. Aliases cannot shadow them.
+var builtinCommands = map[string]bool{
+	"list":      true,
+	"download":  true,
+	"upload":    true,
+	"delete":    true,
+	"rename":    true,
+	"presign":   true,
+	"dbdump":    true,
+	"ship-logs": true,
+	"inventory": true,
+	"query":     true,
+	"preview":   true,
+	"sync":      true,
+	"mkdir":     true,
+	"gc":        true,
+	"protect":   true,
+	"unprotect": true,
+	"restore":   true,
+	"deploy":    true,
+	"worker":    true,
+	"get-url":   true,
+	"config":    true,
+	"scrub":     true,
+	"snapshot":  true,
+	"whoami":    true,
+	"accounts":  true,
+	"bucket":    true,
+	"scratch":   true,
+	"ci-upload": true,
+	"serve":     true,
+	"daemon":    true,
+	"queue":     true,
+	"stat":      true,
+	"cat":       true,
+	"index":     true,
+	"find":      true,
+	"metadata":  true,
+	"meta":      true,
+	"cp":        true,
+	"share":     true,
+	"dupes":     true,
+	"report":    true,
+	"head":      true,
+	"tail":      true,
+	"grep":      true,
+	"tree":      true,
+	"exists":    true,
+	"zip":       true,
+	"buckets":   true,
+	"expand":    true,
+	"rekey":     true,
+	"cors":      true,
+	"lifecycle": true,
+}
+
+// maxAliasDepth bounds the expansion of aliases defined in terms of other aliases.
+const maxAliasDepth = 10
+
+// expandAlias replaces an alias in the command position of args by the command line it stands
+// for. Arguments following the alias are appended to the expansion.
+func expandAlias(args []string, cfg *config.R2Config) ([]string, error) {
+	for depth := 0; len(args) >= 2 && !builtinCommands[args[1]]; depth++ {
+		expansion, ok := cfg.Aliases[args[1]]
+		if !ok {
+			return args, nil
+		}
+		if depth == maxAliasDepth {
+			return nil, fmt.Errorf("alias '%s' expands too deeply, check for a loop", args[1])
+		}
+		words, err := splitCommandLine(expansion)
+		if err != nil {
+			return nil, fmt.Errorf("invalid alias '%s': %w", args[1], err)
+		}
+		if len(words) == 0 {
+			return nil, fmt.Errorf("alias '%s' is empty", args[1])
+		}
+		expanded := append([]string{args[0]}, words...)
+		args = append(expanded, args[2:]...)
+	}
+	return args, nil
+}
+
+// splitCommandLine splits a command line into words like a POSIX shell does, honouring single
+// quotes, double quotes and backslash escapes. No other expansion is performed.
+func splitCommandLine(line string) ([]string, error) {
+	var (
+		words   []string
+		word    strings.Builder
+		inWord  bool
+		quote   rune
+		escaped bool
+	)
+	for _, r := range line {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\\':
+			escaped, inWord = true, true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if escaped || quote != 0 {
+		return nil, fmt.Errorf("unterminated quote or escape in '%s'", line)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/baowuhe/go-cfr2/config"
+	"github.com/baowuhe/go-cfr2/r2"
+	"github.com/baowuhe/go-cfr2/utils"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// refuseAppendOnly exits with an error if key lies below an append-only prefix, where objects
+// may never be deleted or modified. action names the refused operation, e.g. "delete".
+func refuseAppendOnly(cfg *config.R2Config, key, action string) {
+	if prefix, ok := cfg.AppendOnlyPrefix(key); ok {
+		utils.ExitWithError(fmt.Sprintf("Refusing to %s '%s': prefix '%s' is append-only.", action, key, prefix))
+	}
+}
+
+// refuseAppendOnlyOverwrite exits with an error if key lies below an append-only prefix and an
+// object already exists under it. New keys may always be written. It only refuses early, before any
+// work is done: the write itself is conditional on the key being free, which also refuses a key
+// written by someone else in the meantime.
+func refuseAppendOnlyOverwrite(ctx context.Context, client *s3.Client, cfg *config.R2Config, bucketName, key string) {
+	prefix, ok := cfg.AppendOnlyPrefix(key)
+	if !ok {
+		return
+	}
+	exists, err := r2.ObjectExists(ctx, client, bucketName, key)
+	if err != nil {
+		utils.ExitWithError(err.Error())
+	}
+	if exists {
+		utils.ExitWithError(fmt.Sprintf("Refusing to overwrite '%s': prefix '%s' is append-only, use a unique key.", key, prefix))
+	}
+}
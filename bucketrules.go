@@ -0,0 +1,556 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/baowuhe/go-cfr2/cfapi"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/pelletier/go-toml/v2"
+)
+
+// bucketRules is the desired configuration of a bucket, read from a TOML file. A section missing
+// from the file is left alone; an empty section, such as 'cors = []', removes every rule.
+type bucketRules struct {
+	CORS          []corsRuleSpec         `toml:"cors"`
+	Lifecycle     []lifecycleRuleSpec    `toml:"lifecycle"`
+	Notifications []notificationRuleSpec `toml:"notification"`
+}
+
+// corsRuleSpec is a CORS rule of a rules file, or of the JSON read and written by the cors
+// command, which uses the field names of the S3 API as the Cloudflare dashboard does.
+type corsRuleSpec struct {
+	AllowedOrigins []string `toml:"allowed_origins" json:"AllowedOrigins"`
+	AllowedMethods []string `toml:"allowed_methods" json:"AllowedMethods"`
+	AllowedHeaders []string `toml:"allowed_headers" json:"AllowedHeaders,omitempty"`
+	ExposeHeaders  []string `toml:"expose_headers" json:"ExposeHeaders,omitempty"`
+	MaxAgeSeconds  int32    `toml:"max_age_seconds" json:"MaxAgeSeconds,omitempty"`
+}
+
+// lifecycleRuleSpec is a lifecycle rule of a rules file, or of the JSON read and written by the
+// lifecycle command. The rule applies to the objects below Prefix that carry every tag of Tags
+// and whose size lies within the given bounds.
+type lifecycleRuleSpec struct {
+	ID                    string            `toml:"id" json:"ID,omitempty"`
+	Prefix                string            `toml:"prefix" json:"Prefix,omitempty"`
+	Tags                  map[string]string `toml:"tags" json:"Tags,omitempty"`
+	ObjectSizeGreaterThan int64             `toml:"object_size_greater_than" json:"ObjectSizeGreaterThan,omitempty"`
+	ObjectSizeLessThan    int64             `toml:"object_size_less_than" json:"ObjectSizeLessThan,omitempty"`
+	Disabled              bool              `toml:"disabled" json:"Disabled,omitempty"`
+	ExpireDays            int32             `toml:"expire_days" json:"ExpireDays,omitempty"`
+	ExpireDate            *time.Time        `toml:"expire_date" json:"ExpireDate,omitempty"`
+	TransitionDays        int32             `toml:"transition_days" json:"TransitionDays,omitempty"`
+	StorageClass          string            `toml:"storage_class" json:"StorageClass,omitempty"`
+	AbortMultipartDays    int32             `toml:"abort_multipart_days" json:"AbortMultipartDays,omitempty"`
+}
+
+type notificationRuleSpec struct {
+	// Queue is the ID of the queue receiving the events.
+	Queue       string   `toml:"queue"`
+	Actions     []string `toml:"actions"`
+	Prefix      string   `toml:"prefix"`
+	Suffix      string   `toml:"suffix"`
+	Description string   `toml:"description"`
+}
+
+// loadBucketRules reads and validates a bucket rules file.
+func loadBucketRules(path string) (*bucketRules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rules '%s': %w", path, err)
+	}
+	var rules bucketRules
+	if err := toml.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse rules '%s': %w", path, err)
+	}
+
+	for i, rule := range rules.CORS {
+		if !rule.valid() {
+			return nil, fmt.Errorf("CORS rule %d needs allowed_origins and allowed_methods", i+1)
+		}
+	}
+	ids := map[string]bool{}
+	for i, rule := range rules.Lifecycle {
+		if rule.ID == "" {
+			return nil, fmt.Errorf("lifecycle rule %d has no id", i+1)
+		}
+		if ids[rule.ID] {
+			return nil, fmt.Errorf("lifecycle rule id '%s' is used twice", rule.ID)
+		}
+		ids[rule.ID] = true
+		if err := rule.validate(); err != nil {
+			return nil, fmt.Errorf("lifecycle rule '%s' %v", rule.ID, err)
+		}
+	}
+	for i, rule := range rules.Notifications {
+		if rule.Queue == "" || len(rule.Actions) == 0 {
+			return nil, fmt.Errorf("notification rule %d needs a queue and actions", i+1)
+		}
+	}
+	return &rules, nil
+}
+
+func (spec corsRuleSpec) valid() bool {
+	return len(spec.AllowedOrigins) > 0 && len(spec.AllowedMethods) > 0
+}
+
+func (spec corsRuleSpec) rule() types.CORSRule {
+	rule := types.CORSRule{
+		AllowedOrigins: spec.AllowedOrigins,
+		AllowedMethods: spec.AllowedMethods,
+		AllowedHeaders: spec.AllowedHeaders,
+		ExposeHeaders:  spec.ExposeHeaders,
+	}
+	if spec.MaxAgeSeconds > 0 {
+		rule.MaxAgeSeconds = aws.Int32(spec.MaxAgeSeconds)
+	}
+	return rule
+}
+
+// corsRuleSpecOf returns the spec of an existing CORS rule.
+func corsRuleSpecOf(rule types.CORSRule) corsRuleSpec {
+	return corsRuleSpec{
+		AllowedOrigins: rule.AllowedOrigins,
+		AllowedMethods: rule.AllowedMethods,
+		AllowedHeaders: rule.AllowedHeaders,
+		ExposeHeaders:  rule.ExposeHeaders,
+		MaxAgeSeconds:  aws.ToInt32(rule.MaxAgeSeconds),
+	}
+}
+
+// validate checks that the rule has an action and a filter R2 accepts. Its error completes a
+// sentence starting with the rule.
+func (spec lifecycleRuleSpec) validate() error {
+	switch {
+	case spec.ExpireDays == 0 && spec.ExpireDate == nil && spec.TransitionDays == 0 && spec.AbortMultipartDays == 0:
+		return errors.New("needs expire_days, expire_date, transition_days or abort_multipart_days")
+	case spec.ExpireDays < 0 || spec.TransitionDays < 0 || spec.AbortMultipartDays < 0:
+		return errors.New("has a negative number of days")
+	case spec.ExpireDays > 0 && spec.ExpireDate != nil:
+		return errors.New("has both expire_days and expire_date")
+	case (spec.TransitionDays == 0) != (spec.StorageClass == ""):
+		return errors.New("needs both transition_days and storage_class")
+	case spec.ObjectSizeGreaterThan < 0 || spec.ObjectSizeLessThan < 0:
+		return errors.New("has a negative object size")
+	case spec.ObjectSizeLessThan > 0 && spec.ObjectSizeGreaterThan >= spec.ObjectSizeLessThan:
+		return errors.New("needs object_size_greater_than below object_size_less_than")
+	}
+	return nil
+}
+
+func (spec lifecycleRuleSpec) rule() types.LifecycleRule {
+	rule := types.LifecycleRule{
+		Status: types.ExpirationStatusEnabled,
+		Filter: spec.filter(),
+	}
+	if spec.ID != "" {
+		rule.ID = aws.String(spec.ID)
+	}
+	if spec.Disabled {
+		rule.Status = types.ExpirationStatusDisabled
+	}
+	if spec.ExpireDays > 0 {
+		rule.Expiration = &types.LifecycleExpiration{Days: aws.Int32(spec.ExpireDays)}
+	} else if spec.ExpireDate != nil {
+		rule.Expiration = &types.LifecycleExpiration{Date: aws.Time(spec.ExpireDate.UTC())}
+	}
+	if spec.TransitionDays > 0 {
+		rule.Transitions = []types.Transition{{Days: aws.Int32(spec.TransitionDays), StorageClass: types.TransitionStorageClass(spec.StorageClass)}}
+	}
+	if spec.AbortMultipartDays > 0 {
+		rule.AbortIncompleteMultipartUpload = &types.AbortIncompleteMultipartUpload{DaysAfterInitiation: aws.Int32(spec.AbortMultipartDays)}
+	}
+	return rule
+}
+
+// filter returns the filter of the rule. A rule with more than one condition needs the And
+// operator, as the filter itself holds only one.
+func (spec lifecycleRuleSpec) filter() *types.LifecycleRuleFilter {
+	var tags []types.Tag
+	for _, key := range slices.Sorted(maps.Keys(spec.Tags)) {
+		tags = append(tags, types.Tag{Key: aws.String(key), Value: aws.String(spec.Tags[key])})
+	}
+	var greaterThan, lessThan *int64
+	conditions := len(tags)
+	if spec.Prefix != "" {
+		conditions++
+	}
+	if spec.ObjectSizeGreaterThan > 0 {
+		greaterThan = aws.Int64(spec.ObjectSizeGreaterThan)
+		conditions++
+	}
+	if spec.ObjectSizeLessThan > 0 {
+		lessThan = aws.Int64(spec.ObjectSizeLessThan)
+		conditions++
+	}
+
+	switch {
+	case conditions > 1:
+		and := &types.LifecycleRuleAndOperator{Tags: tags, ObjectSizeGreaterThan: greaterThan, ObjectSizeLessThan: lessThan}
+		if spec.Prefix != "" {
+			and.Prefix = aws.String(spec.Prefix)
+		}
+		return &types.LifecycleRuleFilter{And: and}
+	case len(tags) == 1:
+		return &types.LifecycleRuleFilter{Tag: &tags[0]}
+	case greaterThan != nil || lessThan != nil:
+		return &types.LifecycleRuleFilter{ObjectSizeGreaterThan: greaterThan, ObjectSizeLessThan: lessThan}
+	}
+	return &types.LifecycleRuleFilter{Prefix: aws.String(spec.Prefix)}
+}
+
+// lifecycleRuleSpecOf returns the spec of an existing lifecycle rule. It fails for rules the spec
+// cannot represent, rather than returning a spec that would change the rule when set again.
+func lifecycleRuleSpecOf(rule types.LifecycleRule) (lifecycleRuleSpec, error) {
+	spec := lifecycleRuleSpec{ID: aws.ToString(rule.ID), Disabled: rule.Status == types.ExpirationStatusDisabled}
+	filter := lifecycleFilterOf(rule)
+	spec.Prefix, spec.ObjectSizeGreaterThan, spec.ObjectSizeLessThan = filter.prefix, filter.greaterThan, filter.lessThan
+	for _, tag := range filter.tags {
+		if spec.Tags == nil {
+			spec.Tags = map[string]string{}
+		}
+		spec.Tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+
+	if len(rule.NoncurrentVersionTransitions) > 0 || rule.NoncurrentVersionExpiration != nil {
+		return spec, errors.New("has actions on noncurrent versions")
+	}
+	if rule.Expiration != nil {
+		if aws.ToBool(rule.Expiration.ExpiredObjectDeleteMarker) {
+			return spec, errors.New("removes expired delete markers")
+		}
+		spec.ExpireDays, spec.ExpireDate = aws.ToInt32(rule.Expiration.Days), rule.Expiration.Date
+	}
+	switch {
+	case len(rule.Transitions) > 1:
+		return spec, errors.New("has more than one transition")
+	case len(rule.Transitions) == 1:
+		if rule.Transitions[0].Days == nil {
+			return spec, errors.New("has a transition on a date")
+		}
+		spec.TransitionDays, spec.StorageClass = *rule.Transitions[0].Days, string(rule.Transitions[0].StorageClass)
+	}
+	if rule.AbortIncompleteMultipartUpload != nil {
+		spec.AbortMultipartDays = aws.ToInt32(rule.AbortIncompleteMultipartUpload.DaysAfterInitiation)
+	}
+	return spec, nil
+}
+
+// lifecycleFilter is the filter of a lifecycle rule, whichever form the rule gives it in.
+type lifecycleFilter struct {
+	prefix                string
+	tags                  []types.Tag
+	greaterThan, lessThan int64
+}
+
+func lifecycleFilterOf(rule types.LifecycleRule) lifecycleFilter {
+	// Prefix on the rule itself is the deprecated form of the filter.
+	filter := lifecycleFilter{prefix: aws.ToString(rule.Prefix)}
+	if rule.Filter == nil {
+		return filter
+	}
+	if and := rule.Filter.And; and != nil {
+		filter.prefix, filter.tags = aws.ToString(and.Prefix), and.Tags
+		filter.greaterThan, filter.lessThan = aws.ToInt64(and.ObjectSizeGreaterThan), aws.ToInt64(and.ObjectSizeLessThan)
+		return filter
+	}
+	if rule.Filter.Prefix != nil {
+		filter.prefix = *rule.Filter.Prefix
+	}
+	if rule.Filter.Tag != nil {
+		filter.tags = []types.Tag{*rule.Filter.Tag}
+	}
+	filter.greaterThan, filter.lessThan = aws.ToInt64(rule.Filter.ObjectSizeGreaterThan), aws.ToInt64(rule.Filter.ObjectSizeLessThan)
+	return filter
+}
+
+// describeCORSRule summarizes a CORS rule on one line.
+func describeCORSRule(rule types.CORSRule) string {
+	description := fmt.Sprintf("%s from %s", strings.Join(rule.AllowedMethods, ","), strings.Join(rule.AllowedOrigins, ", "))
+	if len(rule.AllowedHeaders) > 0 {
+		description += "; headers " + strings.Join(rule.AllowedHeaders, ", ")
+	}
+	if len(rule.ExposeHeaders) > 0 {
+		description += "; exposing " + strings.Join(rule.ExposeHeaders, ", ")
+	}
+	if rule.MaxAgeSeconds != nil {
+		description += fmt.Sprintf("; cached %ds", *rule.MaxAgeSeconds)
+	}
+	return description
+}
+
+// describeNotificationRule summarizes a notification rule on one line.
+func describeNotificationRule(rule cfapi.R2NotificationRule) string {
+	description := strings.Join(rule.Actions, ",")
+	if rule.Prefix != "" {
+		description += fmt.Sprintf(" below '%s'", rule.Prefix)
+	}
+	if rule.Suffix != "" {
+		description += fmt.Sprintf(" ending in '%s'", rule.Suffix)
+	}
+	if rule.Description != "" {
+		description += fmt.Sprintf(" (%s)", rule.Description)
+	}
+	return description
+}
+
+// bucketChange is one line of a bucket plan.
+type bucketChange struct {
+	Section string
+	// Op is '+' for a rule to add, '-' for one to remove and '~' for one to change.
+	Op       byte
+	Old, New string
+}
+
+// bucketPlan holds the changes that bring a bucket in line with its rules, and the complete
+// configuration of every section that has to be written.
+type bucketPlan struct {
+	Changes []bucketChange
+
+	cors             []types.CORSRule
+	corsChanged      bool
+	lifecycle        []types.LifecycleRule
+	lifecycleChanged bool
+	// queues holds the desired rules of every queue whose rules change; no rules removes them.
+	queues map[string][]cfapi.R2NotificationRule
+	// existingQueues names the queues that have rules now, which must be removed before replacing them.
+	existingQueues map[string]bool
+}
+
+// planBucketRules compares the rules with the current configuration of a bucket. api may be nil
+// unless the rules contain notifications, which are only available through the Cloudflare API.
+func planBucketRules(ctx context.Context, client *s3.Client, api *cfapi.Client, bucketName string, rules *bucketRules) (*bucketPlan, error) {
+	plan := &bucketPlan{queues: map[string][]cfapi.R2NotificationRule{}, existingQueues: map[string]bool{}}
+
+	if rules.CORS != nil {
+		var actual []types.CORSRule
+		resp, err := client.GetBucketCors(ctx, &s3.GetBucketCorsInput{Bucket: &bucketName})
+		switch {
+		case isAPIErrorCode(err, "NoSuchCORSConfiguration"):
+		case err != nil:
+			return nil, fmt.Errorf("failed to get CORS rules of bucket '%s': %w", bucketName, err)
+		default:
+			actual = resp.CORSRules
+		}
+		for _, spec := range rules.CORS {
+			plan.cors = append(plan.cors, spec.rule())
+		}
+		plan.corsChanged = plan.diffSet("CORS", describeAll(actual, describeCORSRule), describeAll(plan.cors, describeCORSRule))
+	}
+
+	if rules.Lifecycle != nil {
+		actual := map[string]string{}
+		var unnamed []string
+		resp, err := client.GetBucketLifecycleConfiguration(ctx, &s3.GetBucketLifecycleConfigurationInput{Bucket: &bucketName})
+		switch {
+		case isAPIErrorCode(err, "NoSuchLifecycleConfiguration"):
+		case err != nil:
+			return nil, fmt.Errorf("failed to get lifecycle rules of bucket '%s': %w", bucketName, err)
+		default:
+			for _, rule := range resp.Rules {
+				if rule.ID == nil || *rule.ID == "" {
+					unnamed = append(unnamed, describeLifecycleRule(rule))
+					continue
+				}
+				actual[*rule.ID] = describeLifecycleRule(rule)
+			}
+		}
+		desired := map[string]string{}
+		for _, spec := range rules.Lifecycle {
+			rule := spec.rule()
+			plan.lifecycle = append(plan.lifecycle, rule)
+			desired[spec.ID] = describeLifecycleRule(rule)
+		}
+		changed := plan.diffKeyed("Lifecycle", actual, desired)
+		for _, description := range unnamed {
+			plan.Changes = append(plan.Changes, bucketChange{Section: "Lifecycle", Op: '-', Old: description})
+		}
+		plan.lifecycleChanged = changed || len(unnamed) > 0
+	}
+
+	if rules.Notifications != nil {
+		if api == nil {
+			return nil, fmt.Errorf("event notifications can only be managed with APIToken set in config")
+		}
+		queues, err := api.ListR2Notifications(ctx, bucketName)
+		if err != nil {
+			return nil, err
+		}
+		actual := map[string][]cfapi.R2NotificationRule{}
+		for _, queue := range queues {
+			actual[queue.QueueID] = queue.Rules
+			plan.existingQueues[queue.QueueID] = len(queue.Rules) > 0
+		}
+		desired := map[string][]cfapi.R2NotificationRule{}
+		for _, spec := range rules.Notifications {
+			desired[spec.Queue] = append(desired[spec.Queue], cfapi.R2NotificationRule{
+				Prefix:      spec.Prefix,
+				Suffix:      spec.Suffix,
+				Actions:     spec.Actions,
+				Description: spec.Description,
+			})
+		}
+		for queueID := range actual {
+			if _, ok := desired[queueID]; !ok {
+				desired[queueID] = nil
+			}
+		}
+		queueIDs := make([]string, 0, len(desired))
+		for queueID := range desired {
+			queueIDs = append(queueIDs, queueID)
+		}
+		slices.Sort(queueIDs)
+		for _, queueID := range queueIDs {
+			section := "Notifications to queue " + queueID
+			if plan.diffSet(section, describeAll(actual[queueID], describeNotificationRule), describeAll(desired[queueID], describeNotificationRule)) {
+				plan.queues[queueID] = desired[queueID]
+			}
+		}
+	}
+	return plan, nil
+}
+
+func describeAll[T any](rules []T, describe func(T) string) []string {
+	descriptions := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		descriptions = append(descriptions, describe(rule))
+	}
+	return descriptions
+}
+
+// diffSet records the rules added and removed between two sets of rule descriptions, and reports
+// whether there were any.
+func (p *bucketPlan) diffSet(section string, actual, desired []string) bool {
+	changed := false
+	for _, description := range actual {
+		if !slices.Contains(desired, description) {
+			p.Changes = append(p.Changes, bucketChange{Section: section, Op: '-', Old: description})
+			changed = true
+		}
+	}
+	for _, description := range desired {
+		if !slices.Contains(actual, description) {
+			p.Changes = append(p.Changes, bucketChange{Section: section, Op: '+', New: description})
+			changed = true
+		}
+	}
+	return changed
+}
+
+// diffKeyed records the rules added, removed and changed between two sets of rule descriptions
+// keyed by rule ID, and reports whether there were any.
+func (p *bucketPlan) diffKeyed(section string, actual, desired map[string]string) bool {
+	changed := false
+	for _, id := range sortedKeys(actual) {
+		if _, ok := desired[id]; !ok {
+			p.Changes = append(p.Changes, bucketChange{Section: section, Op: '-', Old: actual[id]})
+			changed = true
+		}
+	}
+	for _, id := range sortedKeys(desired) {
+		old, ok := actual[id]
+		switch {
+		case !ok:
+			p.Changes = append(p.Changes, bucketChange{Section: section, Op: '+', New: desired[id]})
+			changed = true
+		case old != desired[id]:
+			p.Changes = append(p.Changes, bucketChange{Section: section, Op: '~', Old: old, New: desired[id]})
+			changed = true
+		}
+	}
+	return changed
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// print writes the plan in the style of an infrastructure-as-code tool.
+func (p *bucketPlan) print() {
+	if len(p.Changes) == 0 {
+		fmt.Println("No changes. The bucket matches the rules.")
+		return
+	}
+	added, changed, removed := 0, 0, 0
+	section := ""
+	for _, change := range p.Changes {
+		if change.Section != section {
+			section = change.Section
+			fmt.Printf("%s:\n", section)
+		}
+		switch change.Op {
+		case '+':
+			fmt.Printf("  + %s\n", change.New)
+			added++
+		case '-':
+			fmt.Printf("  - %s\n", change.Old)
+			removed++
+		case '~':
+			fmt.Printf("  ~ %s\n    → %s\n", change.Old, change.New)
+			changed++
+		}
+	}
+	fmt.Printf("Plan: %d to add, %d to change, %d to remove.\n", added, changed, removed)
+}
+
+// apply writes every section of the bucket configuration that the plan changes.
+func (p *bucketPlan) apply(ctx context.Context, client *s3.Client, api *cfapi.Client, bucketName string) error {
+	if p.corsChanged {
+		var err error
+		if len(p.cors) == 0 {
+			_, err = client.DeleteBucketCors(ctx, &s3.DeleteBucketCorsInput{Bucket: &bucketName})
+		} else {
+			_, err = client.PutBucketCors(ctx, &s3.PutBucketCorsInput{
+				Bucket:            &bucketName,
+				CORSConfiguration: &types.CORSConfiguration{CORSRules: p.cors},
+			})
+		}
+		if err != nil {
+			return fmt.Errorf("failed to update CORS rules of bucket '%s': %w", bucketName, err)
+		}
+	}
+
+	if p.lifecycleChanged {
+		var err error
+		if len(p.lifecycle) == 0 {
+			_, err = client.DeleteBucketLifecycle(ctx, &s3.DeleteBucketLifecycleInput{Bucket: &bucketName})
+		} else {
+			_, err = client.PutBucketLifecycleConfiguration(ctx, &s3.PutBucketLifecycleConfigurationInput{
+				Bucket:                 &bucketName,
+				LifecycleConfiguration: &types.BucketLifecycleConfiguration{Rules: p.lifecycle},
+			})
+		}
+		if err != nil {
+			return fmt.Errorf("failed to update lifecycle rules of bucket '%s': %w", bucketName, err)
+		}
+	}
+
+	for queueID, rules := range p.queues {
+		// Rules cannot be edited in place, so the queue's rules are replaced as a whole.
+		if p.existingQueues[queueID] {
+			if err := api.DeleteR2Notifications(ctx, bucketName, queueID); err != nil {
+				return err
+			}
+		}
+		if len(rules) > 0 {
+			if err := api.PutR2Notifications(ctx, bucketName, queueID, rules); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
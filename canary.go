@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/baowuhe/go-cfr2/config"
+	"github.com/baowuhe/go-cfr2/r2"
+	"github.com/baowuhe/go-cfr2/utils"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// canaryMarkerName is the object, below the canary prefix, that tells a Worker written by
+// 'worker generate' to route a share of visitors to the canary. It holds a canaryMarker.
+const canaryMarkerName = ".cfr2-canary.json"
+
+// canaryMarker records a canary deploy that is in rotation.
+type canaryMarker struct {
+	Target  string    `json:"target"`
+	Percent int       `json:"percent"`
+	Started time.Time `json:"started"`
+}
+
+// canaryPrefixFor returns the prefix a canary of the live prefix is deployed to: a sibling such as
+// site.canary/ for site/, or _canary/ for the root of the bucket.
+func canaryPrefixFor(prefix string) string {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix == "" {
+		return "_canary/"
+	}
+	return prefix + ".canary/"
+}
+
+// parseCanaryPercent parses the share of visitors served by a canary, such as 10% or 10.
+func parseCanaryPercent(value string) (int, error) {
+	percent, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(value), "%"))
+	if err != nil || percent < 1 || percent > 100 {
+		return 0, fmt.Errorf("Invalid canary share '%s'. Use a percentage from 1%% to 100%%, e.g. 10%%.", value)
+	}
+	return percent, nil
+}
+
+// listPrefixETags returns the ETags of the objects below prefix by key relative to prefix, leaving
+// out the keys below any of skip.
+func listPrefixETags(ctx context.Context, client *s3.Client, bucketName, prefix string, skip ...string) (map[string]string, error) {
+	etags := map[string]string{}
+	err := r2.WalkObjects(ctx, client, bucketName, prefix, func(obj types.Object) error {
+		for _, skipped := range skip {
+			if strings.HasPrefix(*obj.Key, skipped) {
+				return nil
+			}
+		}
+		etags[strings.TrimPrefix(*obj.Key, prefix)] = strings.Trim(aws.ToString(obj.ETag), `"`)
+		return nil
+	})
+	return etags, err
+}
+
+// deployCanary deploys a target to its canary prefix instead of the live one, and puts it into
+// rotation with a marker. With a verify command, the canary is promoted once the command
+// succeeds; otherwise it is left for 'deploy --promote' or 'deploy --abort'.
+func deployCanary(ctx context.Context, client *s3.Client, cfg *config.R2Config, targetName string, opts r2.SyncOptions, livePrefix string, percent int, verify string, keep int) {
+	canaryPrefix := canaryPrefixFor(livePrefix)
+	existing, err := listPrefixETags(ctx, client, opts.Bucket, canaryPrefix)
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to list objects in bucket '%s': %v", opts.Bucket, err))
+	}
+	if len(existing) > 0 {
+		utils.ExitWithError(fmt.Sprintf("A canary of target '%s' is already deployed below '%s'. Promote it with 'go-cfr2 deploy -t %s --promote' or remove it with 'go-cfr2 deploy -t %s --abort'.",
+			targetName, canaryPrefix, targetName, targetName))
+	}
+
+	opts.Prefix = canaryPrefix
+	fmt.Printf("Deploying '%s' to canary prefix '%s' in bucket '%s' (target '%s')...\n", opts.LocalDir, canaryPrefix, opts.Bucket, targetName)
+	result, err := r2.Sync(ctx, client, opts)
+	if result != nil {
+		fmt.Printf("Canary deploy complete: %d uploaded, %d deleted, %d unchanged, %d failed.\n", result.Uploaded, result.Deleted, result.Unchanged, result.Failed)
+	}
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to deploy the canary of target '%s': %v. Remove it with 'go-cfr2 deploy -t %s --abort'.", targetName, err, targetName))
+	}
+
+	markerKey := canaryPrefix + canaryMarkerName
+	data, err := json.Marshal(canaryMarker{Target: targetName, Percent: percent, Started: time.Now().UTC()})
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to encode canary marker: %v", err))
+	}
+	_, err = r2.UploadStreamWithOptions(ctx, client, opts.Bucket, markerKey, bytes.NewReader(data), r2.UploadOptions{
+		ContentType:  aws.String("application/json"),
+		CacheControl: aws.String("no-store"),
+		Quiet:        true,
+	})
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to put the canary into rotation: %v", err))
+	}
+	fmt.Printf("Canary below '%s' is serving %d%% of visitors of Workers written by 'worker generate'.\n", canaryPrefix, percent)
+
+	if verify == "" {
+		fmt.Printf("Check the canary, then promote it with 'go-cfr2 deploy -t %s --promote' or remove it with 'go-cfr2 deploy -t %s --abort'.\n", targetName, targetName)
+		return
+	}
+
+	fmt.Printf("Verifying the canary with '%s'...\n", verify)
+	if err := runCanaryVerify(ctx, verify, opts.Bucket, canaryPrefix, livePrefix, percent); err != nil {
+		// Visitors go back to the live objects; the canary stays for inspection.
+		if delErr := r2.DeleteObject(ctx, client, opts.Bucket, markerKey); delErr != nil {
+			fmt.Printf("Failed to take the canary out of rotation: %v\n", delErr)
+		}
+		utils.ExitWithError(fmt.Sprintf("Canary verification failed: %v. The canary is out of rotation and left below '%s'; remove it with 'go-cfr2 deploy -t %s --abort'.",
+			err, canaryPrefix, targetName))
+	}
+	fmt.Println("Canary verification succeeded.")
+	promoteCanary(ctx, client, cfg, targetName, opts.Bucket, livePrefix, opts.Delete, opts.Concurrency, keep)
+}
+
+// runCanaryVerify runs the verify command through the shell, with the location of the canary in
+// its environment.
+func runCanaryVerify(ctx context.Context, verify, bucketName, canaryPrefix, livePrefix string, percent int) error {
+	cmd := exec.CommandContext(ctx, "sh", "-c", verify)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		"CFR2_CANARY_BUCKET="+bucketName,
+		"CFR2_CANARY_PREFIX="+canaryPrefix,
+		"CFR2_CANARY_PERCENT="+strconv.Itoa(percent),
+		"CFR2_LIVE_PREFIX="+livePrefix,
+	)
+	return cmd.Run()
+}
+
+// promoteCanary copies the canary objects that differ from the live ones over them, with
+// server-side copies, removes the canary and records the deploy. With deleteExtra, the live
+// objects the canary does not have are deleted.
+func promoteCanary(ctx context.Context, client *s3.Client, cfg *config.R2Config, targetName, bucketName, livePrefix string, deleteExtra bool, concurrency, keep int) {
+	canaryPrefix := canaryPrefixFor(livePrefix)
+	canary, err := listDeployVersions(ctx, client, bucketName, canaryPrefix, canaryPrefix+canaryMarkerName)
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to list objects in bucket '%s': %v", bucketName, err))
+	}
+	if len(canary) == 0 {
+		utils.ExitWithError(fmt.Sprintf("No canary found below '%s' in bucket '%s'.", canaryPrefix, bucketName))
+	}
+
+	fmt.Printf("Promoting canary '%s' to '%s' in bucket '%s'...\n", canaryPrefix, livePrefix, bucketName)
+	copied, deleted, err := replaceLiveFiles(ctx, client, cfg, bucketName, livePrefix, canary, func(rel string) string {
+		return canaryPrefix + rel
+	}, deleteExtra, concurrency)
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to promote the canary: %v. The canary is left below '%s'; run --promote again.", err, canaryPrefix))
+	}
+
+	removeCanary(ctx, client, bucketName, canaryPrefix)
+	fmt.Printf("Successfully promoted the canary: %d object(s) copied, %d deleted.\n", copied, deleted)
+	recordDeploy(ctx, client, bucketName, targetName, livePrefix, "", concurrency, keep)
+}
+
+// removeCanary deletes every object below the canary prefix, the marker included. The canary only
+// holds copies, so protected objects are deleted too.
+func removeCanary(ctx context.Context, client *s3.Client, bucketName, canaryPrefix string) {
+	fmt.Printf("Removing canary '%s' from bucket '%s'...\n", canaryPrefix, bucketName)
+	result, err := r2.EmptyBucket(ctx, client, r2.EmptyBucketOptions{
+		Bucket:             bucketName,
+		Prefix:             canaryPrefix,
+		OverrideProtection: true,
+	})
+	printDeleteFailures(result.Failures)
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to remove canary '%s': %v", canaryPrefix, err))
+	}
+	if result.Failed > 0 {
+		utils.ExitWithError(fmt.Sprintf("Failed to delete %d object(s) of canary '%s'.", result.Failed, canaryPrefix))
+	}
+}
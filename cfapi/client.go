@@ -0,0 +1,88 @@
+// Package cfapi is a minimal client for the parts of the Cloudflare API that go-cfr2 uses
+// besides the S3-compatible R2 endpoint.
+package cfapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const defaultBaseURL = "https://api.cloudflare.com/client/v4"
+
+// Client calls the Cloudflare API on behalf of one account.
+type Client struct {
+	AccountID  string
+	Token      string
+	BaseURL    string
+	HTTPClient *http.Client
+}
+
+// NewClient creates a client authenticating with an API token.
+func NewClient(accountID, token string) *Client {
+	return &Client{
+		AccountID:  accountID,
+		Token:      token,
+		BaseURL:    defaultBaseURL,
+		HTTPClient: http.DefaultClient,
+	}
+}
+
+// apiResponse is the envelope of every Cloudflare API response.
+type apiResponse struct {
+	Success bool `json:"success"`
+	Errors  []struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+	Result json.RawMessage `json:"result"`
+}
+
+// do sends a request to path, relative to the account, and decodes the result into out if it is non-nil.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, contentType string, body io.Reader, out any) error {
+	return c.doURL(ctx, method, c.BaseURL+"/accounts/"+url.PathEscape(c.AccountID)+path, query, contentType, body, out)
+}
+
+// doURL is do for an endpoint outside the account, such as /user.
+func (c *Client) doURL(ctx context.Context, method, endpoint string, query url.Values, contentType string, body io.Reader, out any) error {
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("cloudflare API request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var envelope apiResponse
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return fmt.Errorf("cloudflare API returned %s with an unreadable body: %w", resp.Status, err)
+	}
+	if !envelope.Success {
+		messages := make([]string, 0, len(envelope.Errors))
+		for _, e := range envelope.Errors {
+			messages = append(messages, strconv.Itoa(e.Code)+": "+e.Message)
+		}
+		return fmt.Errorf("cloudflare API returned %s: %s", resp.Status, strings.Join(messages, "; "))
+	}
+	if out != nil && len(envelope.Result) > 0 {
+		if err := json.Unmarshal(envelope.Result, out); err != nil {
+			return fmt.Errorf("failed to parse cloudflare API result: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package cfapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MinKVExpirationTTL is the shortest expiration Workers KV accepts.
+const MinKVExpirationTTL = 60 * time.Second
+
+// PutKVValue writes a value into a Workers KV namespace. A positive ttl makes the value expire,
+// and is raised to MinKVExpirationTTL if shorter.
+func (c *Client) PutKVValue(ctx context.Context, namespaceID, key, value string, ttl time.Duration) error {
+	query := url.Values{}
+	if ttl > 0 {
+		query.Set("expiration_ttl", strconv.FormatInt(int64(max(ttl, MinKVExpirationTTL).Seconds()), 10))
+	}
+	path := "/storage/kv/namespaces/" + url.PathEscape(namespaceID) + "/values/" + url.PathEscape(key)
+	if err := c.do(ctx, http.MethodPut, path, query, "text/plain", strings.NewReader(value), nil); err != nil {
+		return fmt.Errorf("failed to write key '%s' to KV namespace '%s': %w", key, namespaceID, err)
+	}
+	return nil
+}
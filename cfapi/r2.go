@@ -0,0 +1,114 @@
+package cfapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// R2Bucket is the account-level description of an R2 bucket.
+type R2Bucket struct {
+	Name         string `json:"name"`
+	CreationDate string `json:"creation_date"`
+	Location     string `json:"location"`
+	Jurisdiction string `json:"jurisdiction"`
+	StorageClass string `json:"storage_class"`
+}
+
+// R2CustomDomain is a custom domain serving an R2 bucket publicly.
+type R2CustomDomain struct {
+	Domain  string `json:"domain"`
+	Enabled bool   `json:"enabled"`
+	Status  struct {
+		Ownership string `json:"ownership"`
+		SSL       string `json:"ssl"`
+	} `json:"status"`
+}
+
+// R2ManagedDomain is the r2.dev subdomain of a bucket, which serves it publicly when enabled.
+type R2ManagedDomain struct {
+	Domain  string `json:"domain"`
+	Enabled bool   `json:"enabled"`
+}
+
+// GetR2Bucket returns the description of a bucket.
+func (c *Client) GetR2Bucket(ctx context.Context, bucketName string) (R2Bucket, error) {
+	var bucket R2Bucket
+	if err := c.do(ctx, http.MethodGet, "/r2/buckets/"+url.PathEscape(bucketName), nil, "", nil, &bucket); err != nil {
+		return R2Bucket{}, fmt.Errorf("failed to get bucket '%s': %w", bucketName, err)
+	}
+	return bucket, nil
+}
+
+// ListR2CustomDomains returns the custom domains attached to a bucket.
+func (c *Client) ListR2CustomDomains(ctx context.Context, bucketName string) ([]R2CustomDomain, error) {
+	var result struct {
+		Domains []R2CustomDomain `json:"domains"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/r2/buckets/"+url.PathEscape(bucketName)+"/domains/custom", nil, "", nil, &result); err != nil {
+		return nil, fmt.Errorf("failed to list custom domains of bucket '%s': %w", bucketName, err)
+	}
+	return result.Domains, nil
+}
+
+// GetR2ManagedDomain returns the r2.dev subdomain of a bucket and whether public access through
+// it is enabled.
+func (c *Client) GetR2ManagedDomain(ctx context.Context, bucketName string) (R2ManagedDomain, error) {
+	var domain R2ManagedDomain
+	if err := c.do(ctx, http.MethodGet, "/r2/buckets/"+url.PathEscape(bucketName)+"/domains/managed", nil, "", nil, &domain); err != nil {
+		return R2ManagedDomain{}, fmt.Errorf("failed to get r2.dev domain of bucket '%s': %w", bucketName, err)
+	}
+	return domain, nil
+}
+
+// R2NotificationRule selects the object events of a bucket that are sent to a queue.
+type R2NotificationRule struct {
+	RuleID      string   `json:"ruleId,omitempty"`
+	Prefix      string   `json:"prefix,omitempty"`
+	Suffix      string   `json:"suffix,omitempty"`
+	Actions     []string `json:"actions"`
+	Description string   `json:"description,omitempty"`
+}
+
+// R2QueueNotifications are the notification rules of a bucket that target one queue.
+type R2QueueNotifications struct {
+	QueueID   string               `json:"queueId"`
+	QueueName string               `json:"queueName"`
+	Rules     []R2NotificationRule `json:"rules"`
+}
+
+// ListR2Notifications returns the event notification rules of a bucket, grouped by queue.
+func (c *Client) ListR2Notifications(ctx context.Context, bucketName string) ([]R2QueueNotifications, error) {
+	var result struct {
+		Queues []R2QueueNotifications `json:"queues"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/event_notifications/r2/"+url.PathEscape(bucketName)+"/configuration", nil, "", nil, &result); err != nil {
+		return nil, fmt.Errorf("failed to list event notifications of bucket '%s': %w", bucketName, err)
+	}
+	return result.Queues, nil
+}
+
+// PutR2Notifications adds notification rules that send events of a bucket to a queue.
+func (c *Client) PutR2Notifications(ctx context.Context, bucketName, queueID string, rules []R2NotificationRule) error {
+	body, err := json.Marshal(map[string]any{"rules": rules})
+	if err != nil {
+		return err
+	}
+	endpoint := "/event_notifications/r2/" + url.PathEscape(bucketName) + "/configuration/queues/" + url.PathEscape(queueID)
+	if err := c.do(ctx, http.MethodPut, endpoint, nil, "application/json", bytes.NewReader(body), nil); err != nil {
+		return fmt.Errorf("failed to set event notifications of bucket '%s' for queue '%s': %w", bucketName, queueID, err)
+	}
+	return nil
+}
+
+// DeleteR2Notifications removes every notification rule that sends events of a bucket to a queue.
+func (c *Client) DeleteR2Notifications(ctx context.Context, bucketName, queueID string) error {
+	endpoint := "/event_notifications/r2/" + url.PathEscape(bucketName) + "/configuration/queues/" + url.PathEscape(queueID)
+	if err := c.do(ctx, http.MethodDelete, endpoint, nil, "", nil, nil); err != nil {
+		return fmt.Errorf("failed to delete event notifications of bucket '%s' for queue '%s': %w", bucketName, queueID, err)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package cfapi
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Permission groups granting access to the objects of R2 buckets.
+const (
+	PermissionR2ObjectRead  = "Workers R2 Storage Bucket Item Read"
+	PermissionR2ObjectWrite = "Workers R2 Storage Bucket Item Write"
+)
+
+// R2Credentials are S3-compatible credentials derived from an API token.
+type R2Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	ExpiresOn       time.Time
+}
+
+type permissionGroup struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+}
+
+type tokenPolicy struct {
+	Effect           string            `json:"effect"`
+	PermissionGroups []permissionGroup `json:"permission_groups"`
+	Resources        map[string]string `json:"resources"`
+}
+
+// CreateR2Token creates an account API token limited to the objects of the given buckets, read-only
+// or read-write, that expires at the given time, and returns the S3 credentials derived from it.
+func (c *Client) CreateR2Token(ctx context.Context, name string, buckets []string, readOnly bool, expiresOn time.Time) (R2Credentials, error) {
+	wanted := []string{PermissionR2ObjectRead}
+	if !readOnly {
+		wanted = append(wanted, PermissionR2ObjectWrite)
+	}
+	groups, err := c.permissionGroups(ctx, wanted)
+	if err != nil {
+		return R2Credentials{}, err
+	}
+
+	resources := map[string]string{}
+	for _, bucket := range buckets {
+		resources["com.cloudflare.edge.r2.bucket."+c.AccountID+"_default_"+bucket] = "*"
+	}
+	body, err := json.Marshal(map[string]any{
+		"name":       name,
+		"policies":   []tokenPolicy{{Effect: "allow", PermissionGroups: groups, Resources: resources}},
+		"expires_on": expiresOn.UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return R2Credentials{}, err
+	}
+
+	var token struct {
+		ID    string `json:"id"`
+		Value string `json:"value"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/tokens", nil, "application/json", bytes.NewReader(body), &token); err != nil {
+		return R2Credentials{}, fmt.Errorf("failed to create API token '%s': %w", name, err)
+	}
+
+	// R2 uses the token ID as access key and the SHA-256 of the token value as secret.
+	secret := sha256.Sum256([]byte(token.Value))
+	return R2Credentials{
+		AccessKeyID:     token.ID,
+		SecretAccessKey: hex.EncodeToString(secret[:]),
+		ExpiresOn:       expiresOn,
+	}, nil
+}
+
+// permissionGroups looks up the IDs of permission groups by name.
+func (c *Client) permissionGroups(ctx context.Context, names []string) ([]permissionGroup, error) {
+	var all []permissionGroup
+	if err := c.do(ctx, http.MethodGet, "/tokens/permission_groups", nil, "", nil, &all); err != nil {
+		return nil, fmt.Errorf("failed to list permission groups: %w", err)
+	}
+	byName := make(map[string]permissionGroup, len(all))
+	for _, group := range all {
+		byName[group.Name] = group
+	}
+
+	groups := make([]permissionGroup, 0, len(names))
+	for _, name := range names {
+		group, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("permission group '%s' not found", name)
+		}
+		groups = append(groups, permissionGroup{ID: group.ID})
+	}
+	return groups, nil
+}
+
+// TokenStatus describes the API token a client authenticates with.
+type TokenStatus struct {
+	ID        string    `json:"id"`
+	Status    string    `json:"status"`
+	ExpiresOn time.Time `json:"expires_on"`
+	NotBefore time.Time `json:"not_before"`
+}
+
+// VerifyToken returns the status of the client's API token, which may be owned by the account or
+// by a user.
+func (c *Client) VerifyToken(ctx context.Context) (TokenStatus, error) {
+	var status TokenStatus
+	err := c.do(ctx, http.MethodGet, "/tokens/verify", nil, "", nil, &status)
+	if err != nil {
+		if userErr := c.doURL(ctx, http.MethodGet, c.BaseURL+"/user/tokens/verify", nil, "", nil, &status); userErr == nil {
+			return status, nil
+		}
+		return TokenStatus{}, err
+	}
+	return status, nil
+}
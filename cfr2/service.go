@@ -0,0 +1,251 @@
+// Package cfr2 is the embeddable R2 access layer of go-cfr2. A Service owns the S3 client, the
+// configuration, the request rate limiter and the retry policy, and is safe for concurrent use, so
+// a program can share one Service between all its goroutines instead of shelling out to the CLI.
+package cfr2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/baowuhe/go-cfr2/config"
+	"github.com/baowuhe/go-cfr2/r2"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// ErrNotFound is returned for an object or bucket that does not exist.
+var ErrNotFound = errors.New("not found")
+
+// Options tunes a Service. The zero value keeps the SDK's retry policy and the rate limit of the
+// configuration.
+type Options struct {
+	// MaxAttempts is the number of attempts of a request before giving up, including the first.
+	MaxAttempts int
+	// MaxBackoff caps the delay between attempts.
+	MaxBackoff time.Duration
+	// MaxOpsPerSecond, when positive, replaces the configured request rate limit. The limit is
+	// shared by every request of the Service.
+	MaxOpsPerSecond float64
+	// Logger receives the warnings of the Service's operations, such as a clock skew presigned URLs
+	// are corrected for. Nil discards them.
+	Logger r2.Logger
+}
+
+// Service is a concurrency-safe handle for one R2 account.
+type Service struct {
+	cfg    *config.R2Config
+	client *s3.Client
+}
+
+// ObjectInfo describes an object.
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ETag         string
+	LastModified time.Time
+	ContentType  string
+	Metadata     map[string]string
+}
+
+// PutOptions holds optional settings for Put.
+type PutOptions struct {
+	ContentType  string
+	CacheControl string
+	Metadata     map[string]string
+}
+
+// PresignPutOptions holds optional constraints of PresignPut. The upload must send the constrained
+// headers with exactly these values.
+type PresignPutOptions struct {
+	ContentType   string
+	ContentLength int64
+}
+
+// New creates a Service for the account in cfg. cfg must not be modified afterwards.
+func New(cfg *config.R2Config, opts Options) (*Service, error) {
+	if opts.MaxOpsPerSecond > 0 {
+		copied := *cfg
+		copied.MaxOpsPerSecond = opts.MaxOpsPerSecond
+		cfg = &copied
+	}
+	client, err := r2.NewR2ClientWithOptions(cfg, r2.ClientOptions{Logger: opts.Logger})
+	if err != nil {
+		return nil, err
+	}
+	if opts.MaxAttempts > 0 || opts.MaxBackoff > 0 {
+		client = s3.New(client.Options(), func(o *s3.Options) {
+			o.Retryer = retry.NewStandard(func(so *retry.StandardOptions) {
+				if opts.MaxAttempts > 0 {
+					so.MaxAttempts = opts.MaxAttempts
+				}
+				if opts.MaxBackoff > 0 {
+					so.MaxBackoff = opts.MaxBackoff
+				}
+			})
+		})
+	}
+	return &Service{cfg: cfg, client: client}, nil
+}
+
+// NewFromConfig creates a Service from the go-cfr2 config file and environment variables, as the
+// CLI does.
+func NewFromConfig(opts Options) (*Service, error) {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+	return New(cfg, opts)
+}
+
+// Client returns the underlying S3 client, for operations the Service does not cover.
+func (s *Service) Client() *s3.Client {
+	return s.client
+}
+
+// DefaultBucket returns the bucket used when a method is given an empty bucket name.
+func (s *Service) DefaultBucket() string {
+	return s.cfg.DefaultBucket
+}
+
+func (s *Service) bucket(bucketName string) string {
+	if bucketName == "" {
+		return s.cfg.DefaultBucket
+	}
+	return bucketName
+}
+
+// Head returns the description of an object, or ErrNotFound.
+func (s *Service) Head(ctx context.Context, bucketName, key string) (ObjectInfo, error) {
+	bucketName = s.bucket(bucketName)
+	resp, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: &bucketName, Key: &key})
+	if err != nil {
+		return ObjectInfo{}, wrapError(err, "failed to get metadata of object '%s' in bucket '%s'", key, bucketName)
+	}
+	return ObjectInfo{
+		Key:          key,
+		Size:         aws.ToInt64(resp.ContentLength),
+		ETag:         aws.ToString(resp.ETag),
+		LastModified: aws.ToTime(resp.LastModified),
+		ContentType:  aws.ToString(resp.ContentType),
+		Metadata:     resp.Metadata,
+	}, nil
+}
+
+// Get opens an object for reading, or returns ErrNotFound. The caller must close the reader.
+func (s *Service) Get(ctx context.Context, bucketName, key string) (io.ReadCloser, ObjectInfo, error) {
+	bucketName = s.bucket(bucketName)
+	resp, err := s.client.GetObject(ctx, &s3.GetObjectInput{Bucket: &bucketName, Key: &key})
+	if err != nil {
+		return nil, ObjectInfo{}, wrapError(err, "failed to get object '%s' from bucket '%s'", key, bucketName)
+	}
+	return resp.Body, ObjectInfo{
+		Key:          key,
+		Size:         aws.ToInt64(resp.ContentLength),
+		ETag:         aws.ToString(resp.ETag),
+		LastModified: aws.ToTime(resp.LastModified),
+		ContentType:  aws.ToString(resp.ContentType),
+		Metadata:     resp.Metadata,
+	}, nil
+}
+
+// Put stores the content of body, of any length, under key. Large content is sent as a multipart
+// upload, which is aborted if body returns an error.
+func (s *Service) Put(ctx context.Context, bucketName, key string, body io.Reader, opts PutOptions) error {
+	bucketName = s.bucket(bucketName)
+	input := &s3.PutObjectInput{
+		Bucket:   &bucketName,
+		Key:      &key,
+		Body:     body,
+		Metadata: opts.Metadata,
+	}
+	if opts.ContentType != "" {
+		input.ContentType = &opts.ContentType
+	}
+	if opts.CacheControl != "" {
+		input.CacheControl = &opts.CacheControl
+	}
+	if _, err := manager.NewUploader(s.client).Upload(ctx, input); err != nil {
+		return wrapError(err, "failed to upload object '%s' to bucket '%s'", key, bucketName)
+	}
+	return nil
+}
+
+// Delete removes an object. Deleting an object that does not exist succeeds.
+func (s *Service) Delete(ctx context.Context, bucketName, key string) error {
+	bucketName = s.bucket(bucketName)
+	if _, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: &bucketName, Key: &key}); err != nil {
+		return wrapError(err, "failed to delete object '%s' from bucket '%s'", key, bucketName)
+	}
+	return nil
+}
+
+// Copy copies an object within or between buckets of the account.
+func (s *Service) Copy(ctx context.Context, srcBucket, srcKey, dstBucket, dstKey string) error {
+	srcBucket, dstBucket = s.bucket(srcBucket), s.bucket(dstBucket)
+	source := r2.CopySource(srcBucket, srcKey)
+	if _, err := s.client.CopyObject(ctx, &s3.CopyObjectInput{Bucket: &dstBucket, Key: &dstKey, CopySource: &source}); err != nil {
+		return wrapError(err, "failed to copy object '%s' in bucket '%s' to '%s' in bucket '%s'", srcKey, srcBucket, dstKey, dstBucket)
+	}
+	return nil
+}
+
+// Walk calls fn for every object below prefix, in key order, until fn returns an error.
+func (s *Service) Walk(ctx context.Context, bucketName, prefix string, fn func(ObjectInfo) error) error {
+	bucketName = s.bucket(bucketName)
+	err := r2.WalkObjects(ctx, s.client, bucketName, prefix, func(obj types.Object) error {
+		return fn(ObjectInfo{
+			Key:          aws.ToString(obj.Key),
+			Size:         aws.ToInt64(obj.Size),
+			ETag:         aws.ToString(obj.ETag),
+			LastModified: aws.ToTime(obj.LastModified),
+		})
+	})
+	if isNotFound(err) {
+		return fmt.Errorf("bucket '%s': %w", bucketName, ErrNotFound)
+	}
+	return err
+}
+
+// PresignGet returns a URL that allows anyone to download an object until it expires.
+func (s *Service) PresignGet(ctx context.Context, bucketName, key string, expiry time.Duration) (string, error) {
+	return r2.GeneratePresignedURLWithExpiry(ctx, s.client, s.bucket(bucketName), key, expiry)
+}
+
+// PresignPut returns a URL that allows anyone to upload an object under key with a PUT request until
+// it expires, and the headers the upload has to send.
+func (s *Service) PresignPut(ctx context.Context, bucketName, key string, expiry time.Duration, opts PresignPutOptions) (string, http.Header, error) {
+	return r2.GeneratePresignedPutURL(ctx, s.client, s.bucket(bucketName), key, expiry, r2.PresignPutOptions{
+		ContentType:   opts.ContentType,
+		ContentLength: opts.ContentLength,
+	})
+}
+
+// wrapError adds context to an API error, turning missing objects and buckets into ErrNotFound.
+func wrapError(err error, format string, args ...any) error {
+	msg := fmt.Sprintf(format, args...)
+	if isNotFound(err) {
+		return fmt.Errorf("%s: %w", msg, ErrNotFound)
+	}
+	return fmt.Errorf("%s: %w", msg, err)
+}
+
+func isNotFound(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.ErrorCode() {
+	case "NotFound", "NoSuchKey", "NoSuchBucket":
+		return true
+	}
+	return false
+}
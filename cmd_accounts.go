@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/baowuhe/go-cfr2/config"
+	"github.com/baowuhe/go-cfr2/r2"
+	"github.com/baowuhe/go-cfr2/utils"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func handleAccountsCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	if len(os.Args) < 3 {
+		utils.ExitWithError("Accounts subcommand not specified. Use 'accounts overview'.")
+	}
+
+	switch os.Args[2] {
+	case "overview":
+		handleAccountsOverviewCommand(ctx, cfg)
+	default:
+		utils.ExitWithError(fmt.Sprintf("Unknown accounts subcommand '%s'. Use 'accounts overview'.", os.Args[2]))
+	}
+}
+
+// accountsOverviewRow is one bucket, or one account that could not be listed, in the overview.
+type accountsOverviewRow struct {
+	profile string
+	bucket  string
+	objects int64
+	bytes   int64
+	err     error
+}
+
+func handleAccountsOverviewCommand(ctx context.Context, cfg *config.R2Config) {
+	overviewFlags := flag.NewFlagSet("accounts overview", flag.ExitOnError)
+	noSizes := overviewFlags.Bool("no-sizes", false, "Only list the buckets, without counting their objects (optional)")
+	parallel := overviewFlags.Int("parallel", 1, "List this many shards of each bucket's keyspace concurrently (optional)")
+	parseFlags(overviewFlags, os.Args[3:])
+
+	// The top-level configuration is the "default" profile, unless --profile replaced it.
+	profiles := map[string]*config.R2Config{"default": cfg}
+	for name := range cfg.Profiles {
+		profileCfg := *cfg
+		if err := profileCfg.UseProfile(name); err != nil {
+			utils.ExitWithError(err.Error())
+		}
+		profiles[name] = &profileCfg
+	}
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var rows []accountsOverviewRow
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "Listing buckets of profile '%s' (account %s)...\n", name, profiles[name].AccountID)
+		rows = append(rows, overviewAccount(ctx, name, profiles[name], !*noSizes, *parallel)...)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	if *noSizes {
+		fmt.Fprintln(w, "PROFILE\tBUCKET")
+	} else {
+		fmt.Fprintln(w, "PROFILE\tBUCKET\tOBJECTS\tSIZE")
+	}
+	var totalObjects, totalBytes int64
+	failed := 0
+	for _, row := range rows {
+		switch {
+		case row.err != nil:
+			failed++
+			fmt.Fprintf(w, "%s\t%s\terror: %v\n", row.profile, row.bucket, row.err)
+		case *noSizes:
+			fmt.Fprintf(w, "%s\t%s\n", row.profile, row.bucket)
+		default:
+			totalObjects += row.objects
+			totalBytes += row.bytes
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", row.profile, row.bucket, row.objects, formatSize(row.bytes))
+		}
+	}
+	if !*noSizes {
+		fmt.Fprintf(w, "TOTAL\t%d bucket(s)\t%d\t%s\n", len(rows)-failed, totalObjects, formatSize(totalBytes))
+	}
+	w.Flush()
+
+	if failed > 0 {
+		utils.ExitWithError(fmt.Sprintf("Failed to list %d account(s) or bucket(s).", failed))
+	}
+}
+
+// overviewAccount lists the buckets of one account and, with sizes, totals their objects.
+func overviewAccount(ctx context.Context, name string, cfg *config.R2Config, sizes bool, parallel int) []accountsOverviewRow {
+	client, err := r2.NewR2ClientWithOptions(cfg, r2.ClientOptions{Logger: cliLogger{}})
+	if err != nil {
+		return []accountsOverviewRow{{profile: name, bucket: "-", err: err}}
+	}
+	resp, err := client.ListBuckets(ctx, &s3.ListBucketsInput{})
+	if err != nil {
+		return []accountsOverviewRow{{profile: name, bucket: "-", err: err}}
+	}
+
+	rows := make([]accountsOverviewRow, 0, len(resp.Buckets))
+	for _, bucket := range resp.Buckets {
+		row := accountsOverviewRow{profile: name, bucket: aws.ToString(bucket.Name)}
+		if sizes {
+			row.err = r2.WalkObjectsSharded(ctx, client, row.bucket, "", r2.ShardOptions{Concurrency: parallel}, func(obj types.Object) error {
+				row.objects++
+				row.bytes += aws.ToInt64(obj.Size)
+				return nil
+			})
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// formatSize formats a byte count with a binary unit, such as 1.5 GiB.
+func formatSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/baowuhe/go-cfr2/cfapi"
+	"github.com/baowuhe/go-cfr2/config"
+	"github.com/baowuhe/go-cfr2/r2"
+	"github.com/baowuhe/go-cfr2/utils"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+func handleBucketCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	if len(os.Args) < 3 {
+		utils.ExitWithError("Bucket subcommand not specified. Use 'bucket create', 'bucket delete', 'bucket info', 'bucket empty', 'bucket plan' or 'bucket apply'.")
+	}
+
+	switch os.Args[2] {
+	case "create":
+		handleBucketCreateCommand(ctx, client, cfg)
+	case "delete":
+		handleBucketDeleteCommand(ctx, client, cfg)
+	case "info":
+		handleBucketInfoCommand(ctx, client, cfg)
+	case "empty":
+		handleBucketEmptyCommand(ctx, client, cfg)
+	case "plan":
+		handleBucketPlanCommand(ctx, client, cfg, false)
+	case "apply":
+		handleBucketPlanCommand(ctx, client, cfg, true)
+	default:
+		utils.ExitWithError(fmt.Sprintf("Unknown bucket subcommand '%s'. Use 'bucket create', 'bucket delete', 'bucket info', 'bucket empty', 'bucket plan' or 'bucket apply'.", os.Args[2]))
+	}
+}
+
+// bucketArg returns the bucket named by the only positional argument, or the default bucket.
+func bucketArg(args []string, cfg *config.R2Config, usage string) string {
+	switch {
+	case len(args) == 1:
+		return args[0]
+	case len(args) == 0 && cfg.DefaultBucket != "":
+		return cfg.DefaultBucket
+	case len(args) == 0:
+		utils.ExitWithError("Bucket name not specified. Usage: " + usage)
+	default:
+		utils.ExitWithError("Too many arguments. Usage: " + usage)
+	}
+	return ""
+}
+
+func handleBucketInfoCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	infoFlags := flag.NewFlagSet("bucket info", flag.ExitOnError)
+	noSizes := infoFlags.Bool("no-sizes", false, "Skip counting the objects of the bucket (optional)")
+	parallel := infoFlags.Int("parallel", 1, "List this many shards of the bucket's keyspace concurrently when counting (optional)")
+	args := parseInterspersed(infoFlags, os.Args[3:])
+	bucketName := bucketArg(args, cfg, "go-cfr2 bucket info [bucket]")
+
+	fmt.Printf("Bucket:         %s\n", bucketName)
+
+	// The Cloudflare API lookups only add details, so offline they are left out.
+	if cfg.APIToken != "" && !r2.Offline(client) {
+		api := cfapi.NewClient(cfg.AccountID, cfg.APIToken)
+		bucket, err := api.GetR2Bucket(ctx, bucketName)
+		if err != nil {
+			fmt.Printf("Details:        unavailable: %v\n", err)
+		} else {
+			fmt.Printf("Created:        %s\n", bucket.CreationDate)
+			fmt.Printf("Location:       %s\n", valueOr(bucket.Location, "unknown"))
+			fmt.Printf("Jurisdiction:   %s\n", valueOr(bucket.Jurisdiction, "default"))
+			fmt.Printf("Storage class:  %s\n", valueOr(bucket.StorageClass, "Standard"))
+		}
+
+		managed, err := api.GetR2ManagedDomain(ctx, bucketName)
+		switch {
+		case err != nil:
+			fmt.Printf("Public access:  unknown: %v\n", err)
+		case managed.Enabled:
+			fmt.Printf("Public access:  enabled at https://%s\n", managed.Domain)
+		default:
+			fmt.Println("Public access:  r2.dev subdomain disabled")
+		}
+
+		domains, err := api.ListR2CustomDomains(ctx, bucketName)
+		switch {
+		case err != nil:
+			fmt.Printf("Custom domains: unknown: %v\n", err)
+		case len(domains) == 0:
+			fmt.Println("Custom domains: none")
+		default:
+			fmt.Println("Custom domains:")
+			for _, domain := range domains {
+				state := "disabled"
+				if domain.Enabled {
+					state = "enabled"
+				}
+				fmt.Printf("  https://%s (%s, ownership %s, SSL %s)\n", domain.Domain, state, domain.Status.Ownership, domain.Status.SSL)
+			}
+		}
+	} else {
+		if created, ok := bucketCreationDate(ctx, client, bucketName); ok {
+			fmt.Printf("Created:        %s\n", created)
+		}
+		location, err := client.GetBucketLocation(ctx, &s3.GetBucketLocationInput{Bucket: &bucketName})
+		if err != nil {
+			utils.ExitWithError(fmt.Sprintf("Failed to get location of bucket '%s': %v", bucketName, err))
+		}
+		fmt.Printf("Location:       %s\n", valueOr(string(location.LocationConstraint), "unknown"))
+		if cfg.APIToken != "" {
+			fmt.Println("                (Offline: jurisdiction, public access and custom domains are not looked up)")
+		} else {
+			fmt.Println("                (Set APIToken in config for jurisdiction, public access and custom domains)")
+		}
+	}
+
+	if !*noSizes {
+		var objects, bytes int64
+		err := r2.WalkObjectsSharded(ctx, client, bucketName, "", r2.ShardOptions{Concurrency: *parallel}, func(obj types.Object) error {
+			objects++
+			bytes += aws.ToInt64(obj.Size)
+			return nil
+		})
+		if err != nil {
+			fmt.Printf("Objects:        unknown: %v\n", err)
+		} else {
+			fmt.Printf("Objects:        %d (%s)\n", objects, formatSize(bytes))
+		}
+	}
+
+	cors, err := client.GetBucketCors(ctx, &s3.GetBucketCorsInput{Bucket: &bucketName})
+	switch {
+	case isAPIErrorCode(err, "NoSuchCORSConfiguration"):
+		fmt.Println("CORS:           none")
+	case err != nil:
+		fmt.Printf("CORS:           unknown: %v\n", err)
+	default:
+		fmt.Printf("CORS:           %d rule(s)\n", len(cors.CORSRules))
+		for _, rule := range cors.CORSRules {
+			fmt.Printf("  %s from %s\n", strings.Join(rule.AllowedMethods, ","), strings.Join(rule.AllowedOrigins, ", "))
+		}
+	}
+
+	lifecycle, err := client.GetBucketLifecycleConfiguration(ctx, &s3.GetBucketLifecycleConfigurationInput{Bucket: &bucketName})
+	switch {
+	case isAPIErrorCode(err, "NoSuchLifecycleConfiguration"):
+		fmt.Println("Lifecycle:      none")
+	case err != nil:
+		fmt.Printf("Lifecycle:      unknown: %v\n", err)
+	default:
+		fmt.Printf("Lifecycle:      %d rule(s)\n", len(lifecycle.Rules))
+		for _, rule := range lifecycle.Rules {
+			fmt.Printf("  %s\n", describeLifecycleRule(rule))
+		}
+	}
+}
+
+// bucketCreationDate looks the bucket up in the bucket listing, which keys limited to some buckets
+// may not be allowed to see.
+func bucketCreationDate(ctx context.Context, client *s3.Client, bucketName string) (string, bool) {
+	resp, err := client.ListBuckets(ctx, &s3.ListBucketsInput{})
+	if err != nil {
+		return "", false
+	}
+	for _, bucket := range resp.Buckets {
+		if aws.ToString(bucket.Name) == bucketName && bucket.CreationDate != nil {
+			return bucket.CreationDate.UTC().Format("2006-01-02T15:04:05Z"), true
+		}
+	}
+	return "", false
+}
+
+// describeLifecycleRule summarizes a lifecycle rule on one line.
+func describeLifecycleRule(rule types.LifecycleRule) string {
+	var parts []string
+	name := aws.ToString(rule.ID)
+	if name == "" {
+		name = "(unnamed)"
+	}
+	parts = append(parts, fmt.Sprintf("%s [%s]", name, rule.Status))
+
+	filter := lifecycleFilterOf(rule)
+	if filter.prefix != "" {
+		parts = append(parts, fmt.Sprintf("below '%s'", filter.prefix))
+	}
+	if len(filter.tags) > 0 {
+		tags := make([]string, 0, len(filter.tags))
+		for _, tag := range filter.tags {
+			tags = append(tags, aws.ToString(tag.Key)+"="+aws.ToString(tag.Value))
+		}
+		slices.Sort(tags)
+		parts = append(parts, "tagged "+strings.Join(tags, ","))
+	}
+	if filter.greaterThan > 0 {
+		parts = append(parts, fmt.Sprintf("larger than %d bytes", filter.greaterThan))
+	}
+	if filter.lessThan > 0 {
+		parts = append(parts, fmt.Sprintf("smaller than %d bytes", filter.lessThan))
+	}
+	if rule.Expiration != nil {
+		switch {
+		case rule.Expiration.Days != nil:
+			parts = append(parts, fmt.Sprintf("delete after %d day(s)", *rule.Expiration.Days))
+		case rule.Expiration.Date != nil:
+			parts = append(parts, fmt.Sprintf("delete on %s", rule.Expiration.Date.Format("2006-01-02")))
+		}
+	}
+	for _, transition := range rule.Transitions {
+		if transition.Days != nil {
+			parts = append(parts, fmt.Sprintf("move to %s after %d day(s)", transition.StorageClass, *transition.Days))
+		}
+	}
+	if rule.AbortIncompleteMultipartUpload != nil && rule.AbortIncompleteMultipartUpload.DaysAfterInitiation != nil {
+		parts = append(parts, fmt.Sprintf("abort uploads after %d day(s)", *rule.AbortIncompleteMultipartUpload.DaysAfterInitiation))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// isAPIErrorCode reports whether err is an API error with the given code.
+func isAPIErrorCode(err error, code string) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == code
+}
+
+func valueOr(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+func handleBucketEmptyCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	emptyFlags := flag.NewFlagSet("bucket empty", flag.ExitOnError)
+	confirm := emptyFlags.String("confirm", "", "Confirm by repeating the bucket name instead of typing it (optional)")
+	overrideProtection := emptyFlags.Bool("override-protection", false, "Delete protected objects too (optional)")
+	args := parseInterspersed(emptyFlags, os.Args[3:])
+	bucketName := bucketArg(args, cfg, "go-cfr2 bucket empty <bucket> [--confirm <bucket>]")
+
+	confirmBucketName(bucketName, *confirm, fmt.Sprintf("This deletes every object in bucket '%s' and aborts its multipart uploads.", bucketName))
+	if kept := emptyBucket(ctx, client, cfg, bucketName, *overrideProtection); kept > 0 {
+		fmt.Printf("Bucket '%s' still holds %d append-only or protected object(s).\n", bucketName, kept)
+		return
+	}
+	fmt.Printf("Successfully emptied bucket '%s'.\n", bucketName)
+}
+
+// confirmBucketName asks for the bucket name to be typed, after printing warning, unless confirm
+// already holds it, and exits unless the confirmation matches.
+func confirmBucketName(bucketName, confirm, warning string) {
+	if confirm == "" {
+		fmt.Fprintln(os.Stderr, warning)
+		fmt.Fprint(os.Stderr, "Type the bucket name to confirm: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			utils.ExitWithError("Confirmation not provided. Type the bucket name when prompted or pass --confirm.")
+		}
+		confirm = strings.TrimRight(line, "\r\n")
+	}
+	if confirm != bucketName {
+		utils.ExitWithError(fmt.Sprintf("Confirmation '%s' does not match bucket '%s'. Nothing was deleted.", confirm, bucketName))
+	}
+}
+
+// emptyBucket deletes the objects of a bucket, except append-only ones, and aborts its multipart
+// uploads, exiting on failures. It returns the number of objects kept.
+func emptyBucket(ctx context.Context, client *s3.Client, cfg *config.R2Config, bucketName string, overrideProtection bool) int {
+	fmt.Printf("Emptying bucket '%s'...\n", bucketName)
+	result, err := r2.EmptyBucket(ctx, client, r2.EmptyBucketOptions{
+		Bucket: bucketName,
+		Keep: func(key string) bool {
+			_, ok := cfg.AppendOnlyPrefix(key)
+			return ok
+		},
+		OverrideProtection: overrideProtection,
+	})
+	printDeleteFailures(result.Failures)
+	fmt.Printf("Deleted %d object(s), kept %d, failed %d; aborted %d multipart upload(s).\n",
+		result.Deleted, result.Kept, result.Failed, result.AbortedUploads)
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to empty bucket: %v", err))
+	}
+	if result.Failed > 0 {
+		utils.ExitWithError(fmt.Sprintf("Failed to delete %d object(s) in bucket '%s'.", result.Failed, bucketName))
+	}
+	return result.Kept
+}
+
+// handleBucketCreateCommand creates a bucket, optionally with a location hint.
+func handleBucketCreateCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	createFlags := flag.NewFlagSet("bucket create", flag.ExitOnError)
+	location := createFlags.String("location", "", "Specify a location hint: wnam, enam, weur, eeur, apac or oc (optional)")
+	args := parseInterspersed(createFlags, os.Args[3:])
+
+	if len(args) != 1 {
+		utils.ExitWithError("Bucket name not specified. Usage: go-cfr2 bucket create <bucket> [--location <hint>]")
+	}
+	bucketName := args[0]
+	if !bucketNamePattern.MatchString(bucketName) {
+		utils.ExitWithError(fmt.Sprintf("Invalid bucket name '%s'. Use 3 to 63 lowercase letters, digits and hyphens, starting and ending with a letter or digit.", bucketName))
+	}
+	// Catch a mistyped hint here, with the valid ones, rather than leave it to R2.
+	if *location != "" && !slices.Contains(r2.LocationHints, *location) {
+		utils.ExitWithError(fmt.Sprintf("Invalid location hint '%s'. Use one of %s.", *location, strings.Join(r2.LocationHints, ", ")))
+	}
+
+	fmt.Printf("Creating bucket '%s'...\n", bucketName)
+	if err := r2.CreateBucket(ctx, client, bucketName, *location); err != nil {
+		if isAPIErrorCode(err, "BucketAlreadyOwnedByYou") || isAPIErrorCode(err, "BucketAlreadyExists") {
+			utils.ExitWithError(fmt.Sprintf("Bucket '%s' already exists.", bucketName))
+		}
+		utils.ExitWithError(err.Error())
+	}
+	fmt.Printf("Successfully created bucket '%s'.\n", bucketName)
+}
+
+// handleBucketDeleteCommand deletes a bucket, after emptying it with --empty-first, since R2 only
+// deletes empty buckets.
+func handleBucketDeleteCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	deleteFlags := flag.NewFlagSet("bucket delete", flag.ExitOnError)
+	confirm := deleteFlags.String("confirm", "", "Confirm by repeating the bucket name instead of typing it (optional)")
+	emptyFirst := deleteFlags.Bool("empty-first", false, "Delete the objects of the bucket before the bucket (optional)")
+	overrideProtection := deleteFlags.Bool("override-protection", false, "Delete protected objects too, with --empty-first (optional)")
+	args := parseInterspersed(deleteFlags, os.Args[3:])
+
+	// Unlike other bucket subcommands, the bucket must be named: deleting the default bucket by
+	// leaving it out would be too easy.
+	if len(args) != 1 {
+		utils.ExitWithError("Bucket name not specified. Usage: go-cfr2 bucket delete <bucket> [--empty-first] [--confirm <bucket>]")
+	}
+	bucketName := args[0]
+
+	warning := fmt.Sprintf("This deletes bucket '%s'.", bucketName)
+	if *emptyFirst {
+		warning = fmt.Sprintf("This deletes every object in bucket '%s', then the bucket itself.", bucketName)
+	}
+	confirmBucketName(bucketName, *confirm, warning)
+
+	if *emptyFirst {
+		if kept := emptyBucket(ctx, client, cfg, bucketName, *overrideProtection); kept > 0 {
+			utils.ExitWithError(fmt.Sprintf("Bucket '%s' still holds %d append-only or protected object(s) and was not deleted.", bucketName, kept))
+		}
+	}
+
+	fmt.Printf("Deleting bucket '%s'...\n", bucketName)
+	if err := r2.DeleteBucket(ctx, client, bucketName); err != nil {
+		switch {
+		case isAPIErrorCode(err, "BucketNotEmpty"):
+			utils.ExitWithError(fmt.Sprintf("Bucket '%s' is not empty. Use --empty-first to delete its objects first.", bucketName))
+		case isAPIErrorCode(err, "NoSuchBucket"):
+			utils.ExitWithError(fmt.Sprintf("Bucket '%s' does not exist.", bucketName))
+		}
+		utils.ExitWithError(err.Error())
+	}
+	fmt.Printf("Successfully deleted bucket '%s'.\n", bucketName)
+}
+
+// handleBucketPlanCommand implements 'bucket plan', and 'bucket apply' when apply is set.
+func handleBucketPlanCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config, apply bool) {
+	name := "bucket plan"
+	if apply {
+		name = "bucket apply"
+	}
+	planFlags := flag.NewFlagSet(name, flag.ExitOnError)
+	rulesPath := planFlags.String("rules", "", "Specify the TOML file with the desired CORS, lifecycle and notification rules (required)")
+	yes := planFlags.Bool("yes", false, "Apply without asking for confirmation (optional)")
+	args := parseInterspersed(planFlags, os.Args[3:])
+	bucketName := bucketArg(args, cfg, "go-cfr2 "+name+" [bucket] --rules <file>")
+
+	if *rulesPath == "" {
+		utils.ExitWithError("Rules file not specified. Use --rules flag.")
+	}
+	rules, err := loadBucketRules(*rulesPath)
+	if err != nil {
+		utils.ExitWithError(err.Error())
+	}
+	var api *cfapi.Client
+	if cfg.APIToken != "" {
+		api = cfapi.NewClient(cfg.AccountID, cfg.APIToken)
+	}
+
+	fmt.Printf("Comparing bucket '%s' with '%s'...\n", bucketName, *rulesPath)
+	plan, err := planBucketRules(ctx, client, api, bucketName, rules)
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to plan bucket '%s': %v", bucketName, err))
+	}
+	plan.print()
+	if !apply || len(plan.Changes) == 0 {
+		return
+	}
+
+	if !*yes {
+		fmt.Fprint(os.Stderr, "Type 'yes' to apply these changes: ")
+		line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if strings.TrimSpace(line) != "yes" {
+			utils.ExitWithError("Apply cancelled. Nothing was changed.")
+		}
+	}
+	if err := plan.apply(ctx, client, api, bucketName); err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to apply rules to bucket '%s': %v", bucketName, err))
+	}
+	fmt.Printf("Successfully applied %d change(s) to bucket '%s'.\n", len(plan.Changes), bucketName)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/baowuhe/go-cfr2/config"
+	"github.com/baowuhe/go-cfr2/utils"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// bucketRecord is a bucket as printed by buckets --json.
+type bucketRecord struct {
+	Name    string    `json:"name"`
+	Created time.Time `json:"created"`
+	Default bool      `json:"default,omitempty"`
+}
+
+// handleBucketsCommand lists the buckets of the account with their creation dates.
+func handleBucketsCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	bucketsFlags := flag.NewFlagSet("buckets", flag.ExitOnError)
+	jsonOutput := bucketsFlags.Bool("json", false, "Print the buckets as a JSON array (optional)")
+	parseFlags(bucketsFlags, os.Args[2:])
+
+	var records []bucketRecord
+	paginator := s3.NewListBucketsPaginator(client, &s3.ListBucketsInput{})
+	for paginator.HasMorePages() {
+		resp, err := paginator.NextPage(ctx)
+		if err != nil {
+			utils.ExitWithError(fmt.Sprintf("Failed to list buckets: %v", err))
+		}
+		for _, bucket := range resp.Buckets {
+			name := aws.ToString(bucket.Name)
+			records = append(records, bucketRecord{
+				Name:    name,
+				Created: aws.ToTime(bucket.CreationDate).UTC(),
+				Default: name == cfg.DefaultBucket,
+			})
+		}
+	}
+
+	if *jsonOutput {
+		if records == nil {
+			records = []bucketRecord{}
+		}
+		data, err := json.MarshalIndent(records, "", "  ")
+		if err != nil {
+			utils.ExitWithError(fmt.Sprintf("Failed to encode buckets: %v", err))
+		}
+		fmt.Println(string(data))
+		return
+	}
+
+	if len(records) == 0 {
+		fmt.Println("No buckets found.")
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tCREATED")
+	for _, record := range records {
+		name := record.Name
+		if record.Default {
+			name += " (default)"
+		}
+		fmt.Fprintf(w, "%s\t%s\n", name, record.Created.Format("2006-01-02 15:04:05"))
+	}
+	w.Flush()
+	fmt.Printf("%d bucket(s).\n", len(records))
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/baowuhe/go-cfr2/config"
+	"github.com/baowuhe/go-cfr2/r2"
+	"github.com/baowuhe/go-cfr2/utils"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// handleCatCommand streams an object, or with --range part of it, to stdout. Nothing but the
+// object's content is written there, so the output can be piped into other tools.
+func handleCatCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	catFlags := flag.NewFlagSet("cat", flag.ExitOnError)
+	bucketName := catFlags.String("b", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	catFlags.StringVar(bucketName, "bucket", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	objectKey := catFlags.String("k", "", "Specify the object key to print (required)")
+	catFlags.StringVar(objectKey, "key", "", "Specify the object key to print (required)")
+	rangeArg := catFlags.String("range", "", "Print only these bytes, e.g. 0-1023, 1024- or -1024 (optional)")
+	parseFlags(catFlags, os.Args[2:])
+
+	if *bucketName == "" {
+		utils.ExitWithError("Bucket name not specified. Use -b or --bucket flag, or set DefaultBucket in config.")
+	}
+	if *objectKey == "" {
+		utils.ExitWithError("Object key not specified. Use -k or --key flag.")
+	}
+	byteRange := ""
+	if *rangeArg != "" {
+		var err error
+		if byteRange, err = utils.ParseByteRange(*rangeArg); err != nil {
+			utils.ExitWithError(fmt.Sprintf("Invalid --range value '%s'. Use a range such as 0-1023, 1024- or -1024.", *rangeArg))
+		}
+	}
+
+	catObject(ctx, client, *bucketName, *objectKey, byteRange)
+}
+
+// catObject writes the content of an object to stdout and nothing else. byteRange, when set, is an
+// HTTP Range value limiting it to part of the object.
+func catObject(ctx context.Context, client *s3.Client, bucketName, objectKey, byteRange string) {
+	var body io.ReadCloser
+	var err error
+	if byteRange != "" {
+		body, err = r2.OpenObjectRange(ctx, client, bucketName, objectKey, byteRange)
+	} else {
+		body, err = r2.OpenObject(ctx, client, bucketName, objectKey)
+	}
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		utils.ExitWithError(fmt.Sprintf("Object '%s' not found in bucket '%s'.", objectKey, bucketName))
+	}
+	if r2.IsInvalidRange(err) {
+		utils.ExitWithError(fmt.Sprintf("Range '%s' starts past the end of object '%s'.", strings.TrimPrefix(byteRange, "bytes="), objectKey))
+	}
+	if err != nil {
+		utils.ExitWithError(err.Error())
+	}
+	defer body.Close()
+
+	if _, err := io.Copy(os.Stdout, body); err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to read object '%s': %v", objectKey, err))
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/baowuhe/go-cfr2/config"
+	"github.com/baowuhe/go-cfr2/r2"
+	"github.com/baowuhe/go-cfr2/utils"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// ciArtifact is one file published by ci-upload.
+type ciArtifact struct {
+	File string
+	Key  string
+	Size int64
+	URL  string
+	Err  error
+}
+
+func handleCIUploadCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	ciFlags := flag.NewFlagSet("ci-upload", flag.ExitOnError)
+	bucketName := ciFlags.String("b", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	ciFlags.StringVar(bucketName, "bucket", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	prefixTemplate := ciFlags.String("p", "ci/{{.Timestamp}}/", "Specify the key prefix, which may use key template fields (optional)")
+	ciFlags.StringVar(prefixTemplate, "prefix", "ci/{{.Timestamp}}/", "Specify the key prefix, which may use key template fields (optional)")
+	expires := ciFlags.String("expires", "7d", "Specify how long the presigned links stay valid, at most 7d (optional)")
+	format := ciFlags.String("format", "auto", "Specify the output format: auto, github, gitlab or plain (optional)")
+	summaryPath := ciFlags.String("summary", "", "Also write the Markdown artifact table to this file (optional)")
+	args := parseInterspersed(ciFlags, os.Args[2:])
+
+	if *bucketName == "" {
+		utils.ExitWithError("Bucket name not specified. Use -b or --bucket flag, or set DefaultBucket in config.")
+	}
+	if len(args) == 0 {
+		utils.ExitWithError("No files specified. Usage: go-cfr2 ci-upload [flags] <file|glob>...")
+	}
+	expiry, err := utils.ParseDuration(*expires)
+	if err != nil || expiry <= 0 || expiry > 7*24*time.Hour {
+		utils.ExitWithError(fmt.Sprintf("Invalid --expires value '%s'. Use a duration of at most 7d.", *expires))
+	}
+	if *format == "auto" {
+		*format = detectCIFormat()
+	}
+	if *format != "github" && *format != "gitlab" && *format != "plain" {
+		utils.ExitWithError(fmt.Sprintf("Unknown format '%s'. Use auto, github, gitlab or plain.", *format))
+	}
+	prefix, err := utils.ExpandKeyTemplate(*prefixTemplate, time.Now())
+	if err != nil {
+		utils.ExitWithError(err.Error())
+	}
+
+	var files []string
+	for _, arg := range args {
+		matches, err := filepath.Glob(arg)
+		if err != nil {
+			utils.ExitWithError(fmt.Sprintf("Invalid pattern '%s': %v", arg, err))
+		}
+		if len(matches) == 0 {
+			utils.ExitWithError(fmt.Sprintf("No files match '%s'.", arg))
+		}
+		files = append(files, matches...)
+	}
+
+	if *format == "gitlab" {
+		gitlabSection("start", "cfr2_upload", fmt.Sprintf("Uploading %d artifact(s) to bucket '%s'", len(files), *bucketName))
+	}
+	artifacts := make([]ciArtifact, 0, len(files))
+	failed := 0
+	for _, file := range files {
+		artifact := ciArtifact{File: file, Key: path.Join(prefix, filepath.Base(file))}
+		artifact.Err = uploadCIArtifact(ctx, client, cfg, *bucketName, &artifact, expiry)
+		if artifact.Err != nil {
+			failed++
+		}
+		reportCIArtifact(*format, artifact)
+		artifacts = append(artifacts, artifact)
+	}
+	if *format == "gitlab" {
+		gitlabSection("end", "cfr2_upload", "")
+	}
+
+	summary := ciSummary(*bucketName, artifacts, expiry)
+	if *format == "github" && os.Getenv("GITHUB_STEP_SUMMARY") != "" {
+		if err := appendFile(os.Getenv("GITHUB_STEP_SUMMARY"), summary); err != nil {
+			fmt.Printf("::warning::Failed to write step summary: %s\n", githubEscape(err.Error()))
+		}
+	} else if *format == "gitlab" {
+		// GitLab has no step summaries; the table goes into a collapsed log section instead.
+		gitlabSection("start", "cfr2_summary", "Artifact summary")
+		fmt.Print(summary)
+		gitlabSection("end", "cfr2_summary", "")
+	}
+	if *summaryPath != "" {
+		if err := os.WriteFile(*summaryPath, []byte(summary), 0o644); err != nil {
+			utils.ExitWithError(fmt.Sprintf("Failed to write summary '%s': %v", *summaryPath, err))
+		}
+	}
+
+	if failed > 0 {
+		utils.ExitWithError(fmt.Sprintf("Failed to upload %d of %d artifact(s).", failed, len(artifacts)))
+	}
+	fmt.Printf("Successfully uploaded %d artifact(s) to bucket '%s' prefix '%s'.\n", len(artifacts), *bucketName, prefix)
+}
+
+// detectCIFormat picks the output format from the environment variables CI runners set.
+func detectCIFormat() string {
+	switch {
+	case os.Getenv("GITHUB_ACTIONS") == "true":
+		return "github"
+	case os.Getenv("GITLAB_CI") == "true":
+		return "gitlab"
+	default:
+		return "plain"
+	}
+}
+
+func uploadCIArtifact(ctx context.Context, client *s3.Client, cfg *config.R2Config, bucketName string, artifact *ciArtifact, expiry time.Duration) error {
+	info, err := os.Stat(artifact.File)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("'%s' is a directory", artifact.File)
+	}
+	artifact.Size = info.Size()
+	if prefix, ok := cfg.AppendOnlyPrefix(artifact.Key); ok {
+		if exists, err := r2.ObjectExists(ctx, client, bucketName, artifact.Key); err != nil {
+			return err
+		} else if exists {
+			return fmt.Errorf("'%s' already exists and prefix '%s' is append-only", artifact.Key, prefix)
+		}
+	}
+	if err := r2.UploadObjectWithOptions(ctx, client, bucketName, artifact.Key, artifact.File, r2.UploadOptions{Quiet: true}); err != nil {
+		return err
+	}
+	artifact.URL, err = r2.GeneratePresignedURLWithExpiry(ctx, client, bucketName, artifact.Key, expiry)
+	return err
+}
+
+// reportCIArtifact prints the outcome of one upload as an annotation, or a plain line.
+func reportCIArtifact(format string, artifact ciArtifact) {
+	switch {
+	case format == "github" && artifact.Err != nil:
+		fmt.Printf("::error title=Upload failed::%s\n", githubEscape(fmt.Sprintf("%s: %v", artifact.File, artifact.Err)))
+	case format == "github":
+		fmt.Printf("::notice title=%s::%s\n", githubEscapeProperty("Uploaded "+artifact.Key), githubEscape(artifact.URL))
+	case artifact.Err != nil:
+		fmt.Printf("Failed to upload '%s': %v\n", artifact.File, artifact.Err)
+	default:
+		fmt.Printf("Uploaded '%s' as '%s' (%s): %s\n", artifact.File, artifact.Key, formatSize(artifact.Size), artifact.URL)
+	}
+}
+
+// ciSummary renders the uploaded artifacts as a Markdown table.
+func ciSummary(bucketName string, artifacts []ciArtifact, expiry time.Duration) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "### Artifacts in `%s`\n\n", bucketName)
+	b.WriteString("| Artifact | Size | Link |\n|---|---|---|\n")
+	for _, artifact := range artifacts {
+		name := strings.ReplaceAll(artifact.Key, "|", "\\|")
+		if artifact.Err != nil {
+			fmt.Fprintf(&b, "| `%s` | | upload failed |\n", name)
+			continue
+		}
+		fmt.Fprintf(&b, "| `%s` | %s | [download](%s) |\n", name, formatSize(artifact.Size), artifact.URL)
+	}
+	fmt.Fprintf(&b, "\nLinks expire at %s.\n", time.Now().Add(expiry).UTC().Format(time.RFC3339))
+	return b.String()
+}
+
+// githubEscape escapes the message of a GitHub Actions workflow command.
+func githubEscape(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+// githubEscapeProperty escapes a property value of a GitHub Actions workflow command.
+func githubEscapeProperty(s string) string {
+	return strings.NewReplacer(":", "%3A", ",", "%2C").Replace(githubEscape(s))
+}
+
+// gitlabSection starts or ends a collapsible section of a GitLab job log.
+func gitlabSection(event, name, header string) {
+	collapsed := ""
+	if event == "start" {
+		collapsed = "[collapsed=true]"
+	}
+	fmt.Printf("\x1b[0Ksection_%s:%d:%s%s\r\x1b[0K%s\n", event, time.Now().Unix(), name, collapsed, header)
+}
+
+// appendFile appends data to a file, creating it if needed.
+func appendFile(path, data string) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/baowuhe/go-cfr2/cfapi"
+	"github.com/baowuhe/go-cfr2/config"
+	"github.com/baowuhe/go-cfr2/utils"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"golang.org/x/term"
+)
+
+func handleConfigCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	if len(os.Args) < 3 {
+		utils.ExitWithError("Config subcommand not specified. Use 'config export-bundle' or 'config import-bundle'.")
+	}
+
+	switch os.Args[2] {
+	case "export-bundle":
+		handleConfigExportBundleCommand(ctx, cfg)
+	default:
+		utils.ExitWithError(fmt.Sprintf("Unknown config subcommand '%s'. Use 'config export-bundle' or 'config import-bundle'.", os.Args[2]))
+	}
+}
+
+func handleConfigExportBundleCommand(ctx context.Context, cfg *config.R2Config) {
+	exportFlags := flag.NewFlagSet("config export-bundle", flag.ExitOnError)
+	outputPath := exportFlags.String("o", "", "Specify the bundle file to write (required)")
+	exportFlags.StringVar(outputPath, "output", "", "Specify the bundle file to write (required)")
+	buckets := exportFlags.String("b", cfg.DefaultBucket, "Specify the comma-separated buckets the bundle grants access to (optional)")
+	exportFlags.StringVar(buckets, "buckets", cfg.DefaultBucket, "Specify the comma-separated buckets the bundle grants access to (optional)")
+	readOnly := exportFlags.Bool("read-only", false, "Grant read access only (optional)")
+	expires := exportFlags.String("expires", "7d", "Specify how long the bundle and its token stay valid, e.g. 7d or 12h (optional)")
+	parseFlags(exportFlags, os.Args[3:])
+
+	if *outputPath == "" {
+		utils.ExitWithError("Bundle file not specified. Use -o or --output flag.")
+	}
+	if *buckets == "" {
+		utils.ExitWithError("Buckets not specified. Use -b or --buckets flag, or set DefaultBucket in config.")
+	}
+	if cfg.APIToken == "" {
+		utils.ExitWithError("Creating scoped tokens needs an APIToken with permission to create API tokens in config.")
+	}
+	validity, err := utils.ParseDuration(*expires)
+	if err != nil || validity <= 0 {
+		utils.ExitWithError(fmt.Sprintf("Invalid --expires value '%s'. Use a duration such as 7d or 12h.", *expires))
+	}
+	bucketList := strings.Split(*buckets, ",")
+	expiresAt := time.Now().Add(validity)
+
+	passphrase := readPassphrase(true)
+
+	access := "read-write"
+	if *readOnly {
+		access = "read-only"
+	}
+	fmt.Printf("Creating %s token for bucket(s) '%s', valid until %s...\n", access, *buckets, expiresAt.Format(time.RFC3339))
+	api := cfapi.NewClient(cfg.AccountID, cfg.APIToken)
+	tokenName := fmt.Sprintf("go-cfr2 %s bundle %s", access, time.Now().Format("2006-01-02 15:04"))
+	creds, err := api.CreateR2Token(ctx, tokenName, bucketList, *readOnly, expiresAt)
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to create scoped token: %v", err))
+	}
+
+	data, err := config.SealBundle(config.Bundle{
+		AccountID:       cfg.AccountID,
+		AccessKeyID:     creds.AccessKeyID,
+		SecretAccessKey: creds.SecretAccessKey,
+		DefaultBucket:   bucketList[0],
+		ReadOnly:        *readOnly,
+		ExpiresAt:       expiresAt,
+	}, passphrase)
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to encrypt bundle: %v", err))
+	}
+	if err := os.WriteFile(*outputPath, data, 0o600); err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to write bundle '%s': %v", *outputPath, err))
+	}
+	fmt.Printf("Successfully wrote bundle '%s'. Share the passphrase separately; import it with 'go-cfr2 config import-bundle %s'.\n", *outputPath, *outputPath)
+}
+
+// handleConfigImportBundleCommand writes the configuration of a bundle. It runs without an
+// existing configuration, since setting one up is its purpose.
+func handleConfigImportBundleCommand() {
+	importFlags := flag.NewFlagSet("config import-bundle", flag.ExitOnError)
+	force := importFlags.Bool("force", false, "Replace an existing config file (optional)")
+	args := parseInterspersed(importFlags, os.Args[3:])
+
+	if len(args) != 1 {
+		utils.ExitWithError("Bundle file not specified. Usage: go-cfr2 config import-bundle <file> [--force]")
+	}
+	data, err := os.ReadFile(args[0])
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to read bundle '%s': %v", args[0], err))
+	}
+
+	bundle, err := config.OpenBundle(data, readPassphrase(false), time.Now())
+	if err != nil {
+		utils.ExitWithError(err.Error())
+	}
+	path, err := config.WriteBundleConfig(bundle, *force)
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to import bundle: %v. Use --force to replace it.", err))
+	}
+	access := "read-write"
+	if bundle.ReadOnly {
+		access = "read-only"
+	}
+	fmt.Printf("Successfully imported %s credentials into '%s', valid until %s.\n", access, path, bundle.ExpiresAt.Format(time.RFC3339))
+}
+
+// readPassphrase returns the bundle passphrase from CFR2_BUNDLE_PASSPHRASE, or prompts for it,
+// twice when confirm is set.
+func readPassphrase(confirm bool) string {
+	if passphrase := os.Getenv("CFR2_BUNDLE_PASSPHRASE"); passphrase != "" {
+		return passphrase
+	}
+	// On a terminal the passphrase is read without echo, so it stays out of the scrollback; piped
+	// input is read line by line.
+	interactive := term.IsTerminal(int(os.Stdin.Fd()))
+	input := bufio.NewReader(os.Stdin)
+	prompt := func(text string) string {
+		fmt.Fprint(os.Stderr, text)
+		if interactive {
+			line, err := term.ReadPassword(int(os.Stdin.Fd()))
+			fmt.Fprintln(os.Stderr)
+			if err != nil {
+				utils.ExitWithError(fmt.Sprintf("Failed to read passphrase: %v", err))
+			}
+			return string(line)
+		}
+		line, err := input.ReadString('\n')
+		if err != nil && line == "" {
+			utils.ExitWithError("Passphrase not provided. Set CFR2_BUNDLE_PASSPHRASE or enter it when prompted.")
+		}
+		return strings.TrimRight(line, "\r\n")
+	}
+
+	passphrase := prompt("Bundle passphrase: ")
+	if passphrase == "" {
+		utils.ExitWithError("The passphrase must not be empty.")
+	}
+	if confirm && prompt("Repeat passphrase: ") != passphrase {
+		utils.ExitWithError("The passphrases do not match.")
+	}
+	return passphrase
+}
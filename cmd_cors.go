@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/baowuhe/go-cfr2/config"
+	"github.com/baowuhe/go-cfr2/utils"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func handleCORSCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	if len(os.Args) < 3 {
+		utils.ExitWithError("CORS subcommand not specified. Use 'cors get', 'cors set' or 'cors delete'.")
+	}
+
+	switch os.Args[2] {
+	case "get":
+		handleCORSGetCommand(ctx, client, cfg)
+	case "set":
+		handleCORSSetCommand(ctx, client, cfg)
+	case "delete":
+		handleCORSDeleteCommand(ctx, client, cfg)
+	default:
+		utils.ExitWithError(fmt.Sprintf("Unknown CORS subcommand '%s'. Use 'cors get', 'cors set' or 'cors delete'.", os.Args[2]))
+	}
+}
+
+// handleCORSGetCommand prints the CORS rules of a bucket as JSON that 'cors set' accepts, so that
+// they can be kept under version control.
+func handleCORSGetCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	getFlags := flag.NewFlagSet("cors get", flag.ExitOnError)
+	args := parseInterspersed(getFlags, os.Args[3:])
+	bucketName := bucketArg(args, cfg, "go-cfr2 cors get [bucket]")
+
+	specs := []corsRuleSpec{}
+	resp, err := client.GetBucketCors(ctx, &s3.GetBucketCorsInput{Bucket: &bucketName})
+	switch {
+	case isAPIErrorCode(err, "NoSuchCORSConfiguration"):
+		// Notes go to stderr, so that the rules can be redirected into a file.
+		fmt.Fprintf(os.Stderr, "Note: bucket '%s' has no CORS rules.\n", bucketName)
+	case err != nil:
+		utils.ExitWithError(fmt.Sprintf("Failed to get CORS rules of bucket '%s': %v", bucketName, err))
+	default:
+		for _, rule := range resp.CORSRules {
+			specs = append(specs, corsRuleSpecOf(rule))
+		}
+	}
+
+	data, err := json.MarshalIndent(specs, "", "  ")
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to encode CORS rules: %v", err))
+	}
+	fmt.Println(string(data))
+}
+
+// handleCORSSetCommand replaces the CORS rules of a bucket with those of a JSON file.
+func handleCORSSetCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	setFlags := flag.NewFlagSet("cors set", flag.ExitOnError)
+	filePath := setFlags.String("f", "", "Specify the JSON file with the CORS rules, or - for stdin (required)")
+	setFlags.StringVar(filePath, "file", "", "Specify the JSON file with the CORS rules, or - for stdin (required)")
+	args := parseInterspersed(setFlags, os.Args[3:])
+	bucketName := bucketArg(args, cfg, "go-cfr2 cors set [bucket] --file <cors.json>")
+
+	if *filePath == "" {
+		utils.ExitWithError("CORS file not specified. Use -f or --file flag.")
+	}
+	rules, err := loadCORSRules(*filePath)
+	if err != nil {
+		utils.ExitWithError(err.Error())
+	}
+	if len(rules) == 0 {
+		utils.ExitWithError(fmt.Sprintf("'%s' has no CORS rules. Use 'cors delete' to remove the rules of a bucket.", *filePath))
+	}
+
+	fmt.Printf("Setting %d CORS rule(s) on bucket '%s'...\n", len(rules), bucketName)
+	for _, rule := range rules {
+		fmt.Printf("  %s\n", describeCORSRule(rule))
+	}
+	_, err = client.PutBucketCors(ctx, &s3.PutBucketCorsInput{
+		Bucket:            &bucketName,
+		CORSConfiguration: &types.CORSConfiguration{CORSRules: rules},
+	})
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to set CORS rules of bucket '%s': %v", bucketName, err))
+	}
+	fmt.Printf("Successfully set the CORS rules of bucket '%s'.\n", bucketName)
+}
+
+// handleCORSDeleteCommand removes every CORS rule of a bucket.
+func handleCORSDeleteCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	deleteFlags := flag.NewFlagSet("cors delete", flag.ExitOnError)
+	args := parseInterspersed(deleteFlags, os.Args[3:])
+	bucketName := bucketArg(args, cfg, "go-cfr2 cors delete [bucket]")
+
+	fmt.Printf("Deleting the CORS rules of bucket '%s'...\n", bucketName)
+	if _, err := client.DeleteBucketCors(ctx, &s3.DeleteBucketCorsInput{Bucket: &bucketName}); err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to delete CORS rules of bucket '%s': %v", bucketName, err))
+	}
+	fmt.Printf("Successfully deleted the CORS rules of bucket '%s'.\n", bucketName)
+}
+
+// loadCORSRules reads CORS rules from a JSON file holding an array of rules, as written by
+// 'cors get' and the Cloudflare dashboard, or an object with a CORSRules array, as used by the
+// AWS CLI.
+func loadCORSRules(path string) ([]types.CORSRule, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CORS rules '%s': %w", path, err)
+	}
+
+	var specs []corsRuleSpec
+	if err := json.Unmarshal(data, &specs); err != nil {
+		var wrapped struct {
+			CORSRules []corsRuleSpec
+		}
+		if json.Unmarshal(data, &wrapped) != nil || wrapped.CORSRules == nil {
+			return nil, fmt.Errorf("failed to parse CORS rules '%s': %w", path, err)
+		}
+		specs = wrapped.CORSRules
+	}
+
+	rules := make([]types.CORSRule, 0, len(specs))
+	for i, spec := range specs {
+		if !spec.valid() {
+			return nil, fmt.Errorf("CORS rule %d in '%s' needs AllowedOrigins and AllowedMethods", i+1, path)
+		}
+		rules = append(rules, spec.rule())
+	}
+	return rules, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/baowuhe/go-cfr2/config"
+	"github.com/baowuhe/go-cfr2/r2"
+	"github.com/baowuhe/go-cfr2/utils"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// r2URIScheme prefixes remote locations given to cp, as in r2://bucket/key.
+const r2URIScheme = "r2://"
+
+// parseR2URI splits an r2://bucket/key location. ok is false for local paths.
+func parseR2URI(location string) (bucket, key string, ok bool, err error) {
+	rest, ok := strings.CutPrefix(location, r2URIScheme)
+	if !ok {
+		return "", "", false, nil
+	}
+	bucket, key, _ = strings.Cut(rest, "/")
+	if bucket == "" {
+		return "", "", true, fmt.Errorf("no bucket name in '%s', use %sbucket/key", location, r2URIScheme)
+	}
+	return bucket, key, true, nil
+}
+
+// handleCpCommand copies between local files and objects in either direction, or between objects
+// server-side, depending on which arguments are r2:// URIs.
+func handleCpCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	cpFlags := flag.NewFlagSet("cp", flag.ExitOnError)
+	args := parseInterspersed(cpFlags, os.Args[2:])
+
+	if len(args) != 2 {
+		utils.ExitWithError("Source and destination not specified. Usage: go-cfr2 cp <source> <destination>")
+	}
+	srcBucket, srcKey, srcRemote, err := parseR2URI(args[0])
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Invalid location: %v.", err))
+	}
+	dstBucket, dstKey, dstRemote, err := parseR2URI(args[1])
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Invalid location: %v.", err))
+	}
+	if srcRemote && (srcKey == "" || strings.HasSuffix(srcKey, "/")) {
+		utils.ExitWithError(fmt.Sprintf("'%s' does not name an object.", args[0]))
+	}
+	defer waitForTransferSlot(ctx, "cp "+args[0])()
+
+	switch {
+	case srcRemote && dstRemote:
+		if dstKey == "" || strings.HasSuffix(dstKey, "/") {
+			dstKey += path.Base(srcKey)
+		}
+		refuseAppendOnlyOverwrite(ctx, client, cfg, dstBucket, dstKey)
+
+		fmt.Printf("Copying '%s' from bucket '%s' to '%s' in bucket '%s'...\n", srcKey, srcBucket, dstKey, dstBucket)
+		if err := r2.CopyObject(ctx, client, srcBucket, srcKey, dstBucket, dstKey); err != nil {
+			utils.ExitWithError(fmt.Sprintf("Failed to copy object '%s': %v", srcKey, err))
+		}
+		fmt.Printf("Successfully copied '%s' to '%s%s/%s'.\n", srcKey, r2URIScheme, dstBucket, dstKey)
+
+	case srcRemote:
+		if args[1] == "-" {
+			catObject(ctx, client, srcBucket, srcKey, "")
+			return
+		}
+		outputPath := args[1]
+		if stat, err := os.Stat(outputPath); (err == nil && stat.IsDir()) || strings.HasSuffix(outputPath, string(filepath.Separator)) {
+			outputPath = filepath.Join(outputPath, path.Base(srcKey))
+		}
+
+		fmt.Printf("Downloading '%s' from bucket '%s' to '%s'...\n", srcKey, srcBucket, outputPath)
+		if err := r2.DownloadObject(ctx, client, srcBucket, srcKey, outputPath); err != nil {
+			utils.ExitWithError(fmt.Sprintf("Failed to download object '%s': %v", srcKey, err))
+		}
+		fmt.Printf("Successfully downloaded '%s' to '%s'.\n", srcKey, outputPath)
+
+	case dstRemote:
+		if dstKey == "" || strings.HasSuffix(dstKey, "/") {
+			if args[0] == "-" {
+				utils.ExitWithError("Object key not specified. Use r2://bucket/key as the destination when copying stdin.")
+			}
+			dstKey += filepath.Base(args[0])
+		}
+		refuseAppendOnlyOverwrite(ctx, client, cfg, dstBucket, dstKey)
+
+		if args[0] == "-" {
+			fmt.Printf("Uploading stdin to bucket '%s' as '%s'...\n", dstBucket, dstKey)
+			size, err := r2.UploadStreamWithOptions(ctx, client, dstBucket, dstKey, os.Stdin, r2.UploadOptions{})
+			if err != nil {
+				utils.ExitWithError(fmt.Sprintf("Failed to upload stdin: %v", err))
+			}
+			fmt.Printf("Successfully uploaded %s from stdin to '%s'.\n", formatSize(size), dstKey)
+			return
+		}
+		fmt.Printf("Uploading '%s' to bucket '%s' as '%s'...\n", args[0], dstBucket, dstKey)
+		if err := r2.UploadObject(ctx, client, dstBucket, dstKey, args[0]); err != nil {
+			utils.ExitWithError(fmt.Sprintf("Failed to upload file '%s': %v", args[0], err))
+		}
+		fmt.Printf("Successfully uploaded '%s' to '%s'.\n", args[0], dstKey)
+
+	default:
+		utils.ExitWithError(fmt.Sprintf("Neither '%s' nor '%s' is an %s URI. Use the system cp for local files.", args[0], args[1], r2URIScheme))
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/baowuhe/go-cfr2/config"
+	"github.com/baowuhe/go-cfr2/utils"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// handleDaemonCommand runs daemon mode: a long-running process holding the transfer queue that the
+// other go-cfr2 processes of the user take their transfer slots from, so that interactive uploads
+// and downloads go ahead of the queued transfers of a large background sync.
+func handleDaemonCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	daemonFlags := flag.NewFlagSet("daemon", flag.ExitOnError)
+	slots := daemonFlags.Int("slots", 4, "Specify the number of transfers running at once across all processes (optional)")
+	parseFlags(daemonFlags, os.Args[2:])
+
+	if *slots < 1 {
+		utils.ExitWithError("--slots must be at least 1.")
+	}
+
+	path, err := daemonSocketPath()
+	if err != nil {
+		utils.ExitWithError(err.Error())
+	}
+	conn, err := dialDaemon()
+	if err != nil {
+		utils.ExitWithError(err.Error())
+	}
+	if conn != nil {
+		conn.Close()
+		utils.ExitWithError(fmt.Sprintf("A daemon is already running on '%s'.", path))
+	}
+	// Left behind by a daemon that was killed.
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		utils.ExitWithError(fmt.Sprintf("Failed to remove stale socket '%s': %v", path, err))
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to listen on '%s': %v", path, err))
+	}
+
+	// The daemon keeps no configuration, so there is nothing to reload on SIGHUP.
+	ctx, _, stop := daemonSignals(ctx)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	queue := newTransferQueue(*slots)
+	fmt.Printf("Queueing transfers on '%s' with %d slot(s)...\n", path, *slots)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			utils.ExitWithError(fmt.Sprintf("Failed to accept a connection: %v", err))
+		}
+		go queue.serveQueueConn(conn)
+	}
+	fmt.Println("Daemon stopped; transfers go ahead without queueing.")
+}
+
+func handleQueueCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	if len(os.Args) < 3 {
+		utils.ExitWithError("Queue subcommand not specified. Use 'queue status'.")
+	}
+
+	switch os.Args[2] {
+	case "status":
+		handleQueueStatusCommand(ctx, client, cfg)
+	default:
+		utils.ExitWithError(fmt.Sprintf("Unknown queue subcommand '%s'. Use 'queue status'.", os.Args[2]))
+	}
+}
+
+// handleQueueStatusCommand prints the transfers holding and waiting for a slot of the daemon.
+func handleQueueStatusCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	statusFlags := flag.NewFlagSet("queue status", flag.ExitOnError)
+	parseFlags(statusFlags, os.Args[3:])
+
+	status, err := queueStatusFromDaemon()
+	if err != nil {
+		utils.ExitWithError(err.Error())
+	}
+	if status == nil {
+		fmt.Println("No daemon is running, so transfers are not queued. Start one with 'go-cfr2 daemon'.")
+		return
+	}
+
+	fmt.Printf("%d of %d slot(s) in use, %d transfer(s) waiting.\n", len(status.Running), status.Slots, len(status.Waiting))
+	now := time.Now()
+	for _, entry := range status.Running {
+		fmt.Printf("  running  %-11s %s (pid %d, for %s)\n", entry.Priority, entry.Label, entry.PID, now.Sub(entry.Since).Round(time.Second))
+	}
+	for _, entry := range status.Waiting {
+		fmt.Printf("  waiting  %-11s %s (pid %d, for %s)\n", entry.Priority, entry.Label, entry.PID, now.Sub(entry.Since).Round(time.Second))
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"time"
+
+	"github.com/baowuhe/go-cfr2/config"
+	"github.com/baowuhe/go-cfr2/r2"
+	"github.com/baowuhe/go-cfr2/utils"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func handleDbdumpCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	dbdumpFlags := flag.NewFlagSet("dbdump", flag.ExitOnError)
+	bucketName := dbdumpFlags.String("b", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	dbdumpFlags.StringVar(bucketName, "bucket", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	dumpCmd := dbdumpFlags.String("c", "", "Specify the dump command whose stdout is uploaded (required)")
+	dbdumpFlags.StringVar(dumpCmd, "cmd", "", "Specify the dump command whose stdout is uploaded (required)")
+	keyTemplate := dbdumpFlags.String("k", "", "Specify the object key template, e.g. 'pg/{{.Date}}.sql.gz' (required)")
+	dbdumpFlags.StringVar(keyTemplate, "key-template", "", "Specify the object key template, e.g. 'pg/{{.Date}}.sql.gz' (required)")
+	keep := dbdumpFlags.Int("keep", 0, "Keep only the newest N dumps matching the key template (optional)")
+	overrideProtection := dbdumpFlags.Bool("override-protection", false, "Prune old dumps even if they are protected (optional)")
+	parseFlags(dbdumpFlags, os.Args[2:])
+
+	if *bucketName == "" {
+		utils.ExitWithError("Bucket name not specified. Use -b or --bucket flag, or set DefaultBucket in config.")
+	}
+	if *dumpCmd == "" {
+		utils.ExitWithError("Dump command not specified. Use -c or --cmd flag.")
+	}
+	if *keyTemplate == "" {
+		utils.ExitWithError("Key template not specified. Use -k or --key-template flag.")
+	}
+	if *keep < 0 {
+		utils.ExitWithError("--keep must not be negative.")
+	}
+
+	objectKey, err := utils.ExpandKeyTemplate(*keyTemplate, time.Now())
+	if err != nil {
+		utils.ExitWithError(err.Error())
+	}
+	refuseAppendOnlyOverwrite(ctx, client, cfg, *bucketName, objectKey)
+
+	fmt.Printf("Dumping '%s' to bucket '%s' as '%s'...\n", *dumpCmd, *bucketName, objectKey)
+	if err := runDump(ctx, client, *bucketName, objectKey, *dumpCmd); err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to dump to '%s': %v", objectKey, err))
+	}
+	fmt.Printf("Successfully uploaded dump to '%s'.\n", objectKey)
+
+	if *keep > 0 {
+		pruned, err := pruneDumps(ctx, client, cfg, *bucketName, *keyTemplate, *keep, *overrideProtection)
+		if err != nil {
+			utils.ExitWithError(fmt.Sprintf("Failed to prune old dumps: %v", err))
+		}
+		fmt.Printf("Pruned %d old dump(s), keeping the newest %d.\n", pruned, *keep)
+	}
+}
+
+// runDump runs the dump command through the shell and streams its stdout, compressed according to
+// the object key extension, into the bucket. A failing command aborts the upload.
+func runDump(ctx context.Context, client *s3.Client, bucketName, objectKey, dumpCmd string) error {
+	cmd := exec.CommandContext(ctx, "sh", "-c", dumpCmd)
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to attach to command output: %w", err)
+	}
+
+	pr, pw := io.Pipe()
+	compressor, err := utils.NewCompressWriter(pw, objectKey)
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start dump command: %w", err)
+	}
+
+	go func() {
+		_, copyErr := io.Copy(compressor, stdout)
+		if closeErr := compressor.Close(); copyErr == nil {
+			copyErr = closeErr
+		}
+		if waitErr := cmd.Wait(); waitErr != nil {
+			copyErr = fmt.Errorf("dump command failed: %w", waitErr)
+		}
+		pw.CloseWithError(copyErr)
+	}()
+
+	err = r2.UploadStream(ctx, client, bucketName, objectKey, pr)
+	// Unblock the copy goroutine if the upload stopped reading early.
+	pr.CloseWithError(err)
+	return err
+}
+
+// pruneDumps deletes all but the newest keep objects whose keys match the key template.
+// Dumps below an append-only prefix are always kept, protected ones unless overrideProtection is set.
+func pruneDumps(ctx context.Context, client *s3.Client, cfg *config.R2Config, bucketName, keyTemplate string, keep int, overrideProtection bool) (int, error) {
+	prefix, pattern := utils.KeyTemplatePattern(keyTemplate)
+	objects, err := r2.ListObjectsWithPrefix(ctx, client, bucketName, prefix)
+	if err != nil {
+		return 0, err
+	}
+
+	var dumps []types.Object
+	for _, obj := range objects {
+		if obj.Key != nil && pattern.MatchString(*obj.Key) {
+			dumps = append(dumps, obj)
+		}
+	}
+	if len(dumps) <= keep {
+		return 0, nil
+	}
+
+	sort.Slice(dumps, func(i, j int) bool {
+		if dumps[i].LastModified == nil || dumps[j].LastModified == nil {
+			return *dumps[i].Key > *dumps[j].Key
+		}
+		return dumps[i].LastModified.After(*dumps[j].LastModified)
+	})
+
+	pruned := 0
+	for _, obj := range dumps[keep:] {
+		if _, ok := cfg.AppendOnlyPrefix(*obj.Key); ok {
+			fmt.Printf("Keeping append-only dump '%s'.\n", *obj.Key)
+			continue
+		}
+		if !overrideProtection {
+			err := r2.CheckNotProtected(ctx, client, bucketName, *obj.Key)
+			if errors.Is(err, r2.ErrProtected) {
+				fmt.Printf("Keeping protected dump '%s'.\n", *obj.Key)
+				continue
+			}
+			if err != nil {
+				return pruned, err
+			}
+		}
+		fmt.Printf("Deleting old dump '%s'...\n", *obj.Key)
+		if err := r2.DeleteObject(ctx, client, bucketName, *obj.Key); err != nil {
+			return pruned, err
+		}
+		pruned++
+	}
+	return pruned, nil
+}
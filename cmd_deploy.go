@@ -0,0 +1,426 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/baowuhe/go-cfr2/config"
+	"github.com/baowuhe/go-cfr2/r2"
+	"github.com/baowuhe/go-cfr2/utils"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func handleDeployCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	if len(os.Args) > 2 && os.Args[2] == "rollback" {
+		handleDeployRollbackCommand(ctx, client, cfg)
+		return
+	}
+	if len(os.Args) > 2 && os.Args[2] == "switch" {
+		handleDeploySwitchCommand(ctx, client, cfg)
+		return
+	}
+
+	deployFlags := flag.NewFlagSet("deploy", flag.ExitOnError)
+	targetName := deployFlags.String("t", "", "Specify the target defined in the project's cfr2.toml (required)")
+	deployFlags.StringVar(targetName, "target", "", "Specify the target defined in the project's cfr2.toml (required)")
+	since := deployFlags.String("since", "", "Only upload files changed, and delete files removed, since this git commit (optional)")
+	precompress := deployFlags.String("precompress", "", "Upload precompressed variants of text files next to them, e.g. gzip,br (optional)")
+	dryRun := deployFlags.Bool("dry-run", false, "Print the planned actions without executing them (optional)")
+	concurrency := deployFlags.Int("c", 4, "Specify the number of parallel transfers (optional)")
+	deployFlags.IntVar(concurrency, "concurrency", 4, "Specify the number of parallel transfers (optional)")
+	canary := deployFlags.String("canary", "", "Deploy to a canary prefix served to this share of visitors, e.g. 10%, before promoting it (optional)")
+	verify := deployFlags.String("verify", "", "Run this shell command against the canary and promote it if the command succeeds (optional)")
+	promote := deployFlags.Bool("promote", false, "Promote the deployed canary to the live prefix (optional)")
+	abort := deployFlags.Bool("abort", false, "Remove the deployed canary without promoting it (optional)")
+	slotName := deployFlags.String("slot", "", "Deploy to the blue or green slot, to be made live with 'deploy switch' (optional)")
+	keep := deployFlags.Int("keep", 0, "Keep only the newest N recorded deploys of the target, deleting copies no deploy references (optional)")
+	parseFlags(deployFlags, os.Args[2:])
+
+	project, target := resolveDeployTarget(*targetName)
+	bucketName, livePrefix := deployTargetLocation(cfg, target)
+	if *keep < 0 {
+		utils.ExitWithError("--keep must not be negative.")
+	}
+	if *promote && *abort {
+		utils.ExitWithError("--promote and --abort cannot be used together.")
+	}
+	if (*promote || *abort) && (*canary != "" || *slotName != "" || *since != "" || *dryRun) {
+		utils.ExitWithError("--promote and --abort act on the deployed canary and cannot be used with --canary, --slot, --since or --dry-run.")
+	}
+	slot := ""
+	if *slotName != "" {
+		if *canary != "" {
+			utils.ExitWithError("--slot cannot be used with --canary.")
+		}
+		var err error
+		slot, err = parseSlot(*slotName)
+		if err != nil {
+			utils.ExitWithError(err.Error())
+		}
+	}
+	if slot == "" && !*abort {
+		refuseWhileSlotActive(ctx, client, bucketName, livePrefix, *targetName)
+	}
+	if *promote {
+		promoteCanary(ctx, client, cfg, *targetName, bucketName, livePrefix, target.Delete, *concurrency, *keep)
+		return
+	}
+	if *abort {
+		removeCanary(ctx, client, bucketName, canaryPrefixFor(livePrefix))
+		fmt.Printf("Successfully removed the canary of target '%s'.\n", *targetName)
+		return
+	}
+	percent := 0
+	if *canary != "" {
+		if *dryRun {
+			utils.ExitWithError("--dry-run cannot be used with --canary.")
+		}
+		var err error
+		percent, err = parseCanaryPercent(*canary)
+		if err != nil {
+			utils.ExitWithError(err.Error())
+		}
+	} else if *verify != "" {
+		utils.ExitWithError("--verify requires --canary.")
+	}
+
+	localDir := project.TargetDir(target)
+	if stat, err := os.Stat(localDir); err != nil || !stat.IsDir() {
+		utils.ExitWithError(fmt.Sprintf("'%s' is not a directory.", localDir))
+	}
+	precompressEncodings, err := parsePrecompress(*precompress)
+	if err != nil {
+		utils.ExitWithError(err.Error())
+	}
+	if precompressEncodings == nil {
+		precompressEncodings, err = parsePrecompress(strings.Join(target.Precompress, ","))
+		if err != nil {
+			utils.ExitWithError(fmt.Sprintf("Invalid precompress setting of target '%s': %v", *targetName, err))
+		}
+	}
+	exclude := target.Exclude
+	if livePrefix == "" {
+		// The canary, the slots and the deploy history are inside the root of the bucket. Keys are
+		// anchored, so that files named like the pointer further down are still deployed.
+		exclude = append(append([]string{}, exclude...), canaryPrefixFor(livePrefix), deployHistoryPrefix)
+		for _, key := range slotKeys(livePrefix) {
+			exclude = append(exclude, "/"+key)
+		}
+	}
+	cacheRules := make([]r2.CacheRule, 0, len(target.Cache))
+	for _, rule := range target.Cache {
+		cacheRules = append(cacheRules, r2.CacheRule{Pattern: rule.Pattern, CacheControl: rule.CacheControl})
+	}
+
+	var paths map[string]bool
+	if *since != "" && len(target.Fingerprint) > 0 {
+		utils.ExitWithError(fmt.Sprintf("--since cannot be used with target '%s', which fingerprints assets.", *targetName))
+	}
+	if *since != "" {
+		syncPrefix := target.Prefix
+		if *canary != "" {
+			syncPrefix = canaryPrefixFor(livePrefix)
+		}
+		if slot != "" {
+			syncPrefix = slotPrefixFor(livePrefix, slot)
+		}
+		paths, err = gitChangedKeys(ctx, localDir, *since, syncPrefix)
+		if err != nil {
+			utils.ExitWithError(err.Error())
+		}
+		fmt.Printf("%d file(s) changed since '%s'.\n", len(paths), *since)
+	}
+
+	opts := r2.SyncOptions{
+		LocalDir:            localDir,
+		Bucket:              bucketName,
+		Prefix:              target.Prefix,
+		Delete:              target.Delete,
+		AppendOnlyPrefixes:  cfg.AppendOnlyPrefixes,
+		DryRun:              *dryRun,
+		Concurrency:         *concurrency,
+		Exclude:             exclude,
+		CacheRules:          cacheRules,
+		Paths:               paths,
+		Fingerprint:         target.Fingerprint,
+		FingerprintManifest: target.Manifest,
+		Precompress:         precompressEncodings,
+	}
+	if *canary != "" {
+		deployCanary(ctx, client, cfg, *targetName, opts, livePrefix, percent, *verify, *keep)
+		return
+	}
+	if slot != "" {
+		deploySlot(ctx, client, *targetName, opts, livePrefix, slot)
+		return
+	}
+
+	// Objects restored by a rollback are newer than the local files, so modification times cannot
+	// tell the changed files of the same size apart.
+	if *since == "" {
+		latest, err := latestDeploy(ctx, client, bucketName, *targetName)
+		if err != nil {
+			utils.ExitWithError(fmt.Sprintf("Failed to read the deploy history of bucket '%s': %v", bucketName, err))
+		}
+		if latest != nil && latest.RollbackTo != "" {
+			fmt.Printf("Note: the last deploy was a rollback to '%s'; comparing files by checksum.\n", latest.RollbackTo)
+			opts.Checksum = true
+		}
+	}
+
+	fmt.Printf("Deploying '%s' to bucket '%s' prefix '%s' (target '%s')...\n", localDir, bucketName, target.Prefix, *targetName)
+	result, err := r2.Sync(ctx, client, opts)
+	if result != nil {
+		fmt.Printf("Deploy complete: %d uploaded, %d deleted, %d unchanged, %d failed.\n", result.Uploaded, result.Deleted, result.Unchanged, result.Failed)
+	}
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to deploy target '%s': %v", *targetName, err))
+	}
+	if !*dryRun {
+		recordDeploy(ctx, client, bucketName, *targetName, livePrefix, "", *concurrency, *keep)
+	}
+}
+
+// handleDeployRollbackCommand restores the objects of a target as recorded for an earlier deploy,
+// by default the one before the current deploy, with server-side copies and deletions.
+func handleDeployRollbackCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	rollbackFlags := flag.NewFlagSet("deploy rollback", flag.ExitOnError)
+	targetName := rollbackFlags.String("t", "", "Specify the target defined in the project's cfr2.toml (required)")
+	rollbackFlags.StringVar(targetName, "target", "", "Specify the target defined in the project's cfr2.toml (required)")
+	to := rollbackFlags.String("to", "", "Specify the ID of the deploy to restore, instead of the previous one (optional)")
+	list := rollbackFlags.Bool("list", false, "List the recorded deploys of the target instead of rolling back (optional)")
+	concurrency := rollbackFlags.Int("c", 4, "Specify the number of parallel copies (optional)")
+	rollbackFlags.IntVar(concurrency, "concurrency", 4, "Specify the number of parallel copies (optional)")
+	keep := rollbackFlags.Int("keep", 0, "Keep only the newest N recorded deploys of the target, deleting copies no deploy references (optional)")
+	parseFlags(rollbackFlags, os.Args[3:])
+	if *keep < 0 {
+		utils.ExitWithError("--keep must not be negative.")
+	}
+
+	_, target := resolveDeployTarget(*targetName)
+	bucketName, livePrefix := deployTargetLocation(cfg, target)
+	if !*list {
+		refuseWhileSlotActive(ctx, client, bucketName, livePrefix, *targetName)
+	}
+	manifests, err := loadDeployManifests(ctx, client, bucketName, *targetName)
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to read the deploy history of bucket '%s': %v", bucketName, err))
+	}
+	if len(manifests) == 0 {
+		utils.ExitWithError(fmt.Sprintf("No deploys of target '%s' are recorded in bucket '%s'.", *targetName, bucketName))
+	}
+
+	if *list {
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(writer, "ID\tCREATED\tOBJECTS\tNOTE")
+		for i := len(manifests) - 1; i >= 0; i-- {
+			manifest := manifests[i]
+			var notes []string
+			if i == len(manifests)-1 {
+				notes = append(notes, "current")
+			}
+			if manifest.RollbackTo != "" {
+				notes = append(notes, "rollback to "+manifest.RollbackTo)
+			}
+			fmt.Fprintf(writer, "%s\t%s\t%d\t%s\n", manifest.ID, manifest.Created.Local().Format("2006-01-02 15:04:05"), len(manifest.Files), strings.Join(notes, ", "))
+		}
+		writer.Flush()
+		return
+	}
+
+	var restore *deployManifest
+	if *to != "" {
+		for i := range manifests {
+			if manifests[i].ID == *to {
+				restore = &manifests[i]
+			}
+		}
+		if restore == nil {
+			utils.ExitWithError(fmt.Sprintf("Deploy '%s' of target '%s' is not recorded. Use --list to show the recorded deploys.", *to, *targetName))
+		}
+	} else if restore = previousDeploy(manifests); restore == nil {
+		utils.ExitWithError(fmt.Sprintf("No deploy of target '%s' before the current one is recorded.", *targetName))
+	}
+	if restore.Prefix != livePrefix {
+		utils.ExitWithError(fmt.Sprintf("Deploy '%s' was made to prefix '%s', but target '%s' now deploys to '%s'.", restore.ID, restore.Prefix, *targetName, livePrefix))
+	}
+
+	// Every copy must be available before the live objects are touched.
+	kept := map[string]bool{}
+	err = r2.WalkObjects(ctx, client, bucketName, deployObjectsPrefix, func(obj types.Object) error {
+		kept[*obj.Key] = true
+		return nil
+	})
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to list objects in bucket '%s': %v", bucketName, err))
+	}
+	missing := 0
+	for _, rel := range sortedKeys(restore.Files) {
+		if !kept[deployObjectKey(restore.Files[rel])] {
+			fmt.Printf("Missing the kept copy of '%s'.\n", livePrefix+rel)
+			missing++
+		}
+	}
+	if missing > 0 {
+		utils.ExitWithError(fmt.Sprintf("Cannot restore deploy '%s': %d object(s) are no longer kept below '%s'.", restore.ID, missing, deployObjectsPrefix))
+	}
+
+	fmt.Printf("Rolling back target '%s' in bucket '%s' to deploy '%s' of %s...\n", *targetName, bucketName, restore.ID, restore.Created.Local().Format("2006-01-02 15:04:05"))
+	copied, deleted, err := replaceLiveFiles(ctx, client, cfg, bucketName, livePrefix, restore.Files, func(rel string) string {
+		return deployObjectKey(restore.Files[rel])
+	}, true, *concurrency)
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to roll back target '%s': %v. Run the rollback again to finish it.", *targetName, err))
+	}
+	fmt.Printf("Successfully rolled back target '%s' to deploy '%s': %d object(s) copied, %d deleted.\n", *targetName, restore.ID, copied, deleted)
+	recordDeploy(ctx, client, bucketName, *targetName, livePrefix, restore.ID, *concurrency, *keep)
+}
+
+// handleDeploySwitchCommand makes a slot deployed with 'deploy --slot' the live version of a
+// target by repointing the slot pointer, which Workers written by 'worker generate' follow. With
+// --off, the pointer is deleted and the live prefix is served again.
+func handleDeploySwitchCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	switchFlags := flag.NewFlagSet("deploy switch", flag.ExitOnError)
+	targetName := switchFlags.String("t", "", "Specify the target defined in the project's cfr2.toml (required)")
+	switchFlags.StringVar(targetName, "target", "", "Specify the target defined in the project's cfr2.toml (required)")
+	off := switchFlags.Bool("off", false, "Delete the slot pointer, so that the live prefix is served again (optional)")
+	args := parseInterspersed(switchFlags, os.Args[3:])
+
+	_, target := resolveDeployTarget(*targetName)
+	bucketName, livePrefix := deployTargetLocation(cfg, target)
+	current, err := readSlotPointer(ctx, client, bucketName, livePrefix)
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to read the active slot of target '%s': %v", *targetName, err))
+	}
+	if *off {
+		if len(args) > 0 {
+			utils.ExitWithError("--off cannot be used with a slot.")
+		}
+		if current == nil {
+			fmt.Printf("No slot of target '%s' is active.\n", *targetName)
+			return
+		}
+		if err := r2.DeleteObject(ctx, client, bucketName, slotPointerKeyFor(livePrefix)); err != nil {
+			utils.ExitWithError(fmt.Sprintf("Failed to switch target '%s' off slot '%s': %v", *targetName, current.Slot, err))
+		}
+		fmt.Printf("Successfully switched target '%s' off slot '%s'.\n", *targetName, current.Slot)
+		fmt.Printf("Workers written by 'worker generate' serve '%s' within 30 seconds.\n", livePrefix)
+		return
+	}
+	if len(args) != 1 {
+		if current != nil {
+			fmt.Printf("Slot '%s' of target '%s' is active since %s.\n", current.Slot, *targetName, current.Switched.Local().Format("2006-01-02 15:04:05"))
+		}
+		utils.ExitWithError(fmt.Sprintf("Slot not specified. Usage: go-cfr2 deploy switch -t <target> <%s|--off>", strings.Join(deploySlots, "|")))
+	}
+	slot, err := parseSlot(args[0])
+	if err != nil {
+		utils.ExitWithError(err.Error())
+	}
+	if current != nil && current.Slot == slot {
+		fmt.Printf("Slot '%s' of target '%s' is already active.\n", slot, *targetName)
+		return
+	}
+
+	slotPrefix := slotPrefixFor(livePrefix, slot)
+	objects, err := listPrefixETags(ctx, client, bucketName, slotPrefix)
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to list objects in bucket '%s': %v", bucketName, err))
+	}
+	if len(objects) == 0 {
+		utils.ExitWithError(fmt.Sprintf("Slot '%s' of target '%s' is empty. Deploy it first with 'go-cfr2 deploy -t %s --slot %s'.", slot, *targetName, *targetName, slot))
+	}
+
+	err = writeSlotPointer(ctx, client, bucketName, livePrefix, slotPointer{Target: *targetName, Slot: slot, Prefix: slotPrefix, Switched: time.Now().UTC()})
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to switch target '%s' to slot '%s': %v", *targetName, slot, err))
+	}
+	if current != nil {
+		fmt.Printf("Successfully switched target '%s' from slot '%s' to slot '%s'.\n", *targetName, current.Slot, slot)
+	} else {
+		fmt.Printf("Successfully switched target '%s' to slot '%s'.\n", *targetName, slot)
+	}
+	fmt.Printf("Workers written by 'worker generate' serve '%s' within 30 seconds.\n", slotPrefix)
+}
+
+// resolveDeployTarget finds the project file and the target named in it, listing the defined
+// targets when none is named.
+func resolveDeployTarget(targetName string) (*config.Project, config.Target) {
+	project, err := config.FindProject(".")
+	if err != nil {
+		utils.ExitWithError(err.Error())
+	}
+	if targetName == "" {
+		names := make([]string, 0, len(project.Targets))
+		for name := range project.Targets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Printf("Targets defined in '%s':\n", project.Dir)
+		for _, name := range names {
+			fmt.Printf("  %s\n", name)
+		}
+		utils.ExitWithError("Target not specified. Use -t or --target flag.")
+	}
+	target, ok := project.Targets[targetName]
+	if !ok {
+		utils.ExitWithError(fmt.Sprintf("Target '%s' is not defined in '%s'.", targetName, project.Dir))
+	}
+	return project, target
+}
+
+// deployTargetLocation returns the bucket of a target and its prefix, ending in a slash unless it
+// is the root of the bucket.
+func deployTargetLocation(cfg *config.R2Config, target config.Target) (string, string) {
+	bucketName := target.Bucket
+	if bucketName == "" {
+		bucketName = cfg.DefaultBucket
+	}
+	prefix := target.Prefix
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return bucketName, prefix
+}
+
+// gitChangedKeys returns the object keys of the files below dir that were added, modified or
+// deleted since a git commit, including uncommitted changes and untracked files.
+func gitChangedKeys(ctx context.Context, dir, since, prefix string) (map[string]bool, error) {
+	// --relative makes the paths relative to dir, and limits them to files below it.
+	diff, err := exec.CommandContext(ctx, "git", "-C", dir, "diff", "--name-only", "--no-renames", "--relative", "-z", since, "--").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files changed since '%s': %w", since, gitError(err))
+	}
+	untracked, err := exec.CommandContext(ctx, "git", "-C", dir, "ls-files", "--others", "--exclude-standard", "-z").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list untracked files: %w", gitError(err))
+	}
+
+	keys := map[string]bool{}
+	for _, name := range strings.Split(string(diff)+string(untracked), "\x00") {
+		if name != "" {
+			keys[path.Join(prefix, name)] = true
+		}
+	}
+	return keys, nil
+}
+
+// gitError adds the message git printed to a failed command's error.
+func gitError(err error) error {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	return err
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/baowuhe/go-cfr2/config"
+	"github.com/baowuhe/go-cfr2/r2"
+	"github.com/baowuhe/go-cfr2/utils"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// handleDupesCommand reports sets of objects with the same content and, with --delete or --link,
+// reduces each set to its oldest object. Sets matched on sampled content only are never touched.
+func handleDupesCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	dupesFlags := flag.NewFlagSet("dupes", flag.ExitOnError)
+	bucketName := dupesFlags.String("b", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	dupesFlags.StringVar(bucketName, "bucket", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	prefix := dupesFlags.String("p", "", "Only compare objects below this key prefix (optional)")
+	dupesFlags.StringVar(prefix, "prefix", "", "Only compare objects below this key prefix (optional)")
+	concurrency := dupesFlags.Int("c", 8, "Specify the number of parallel requests (optional)")
+	dupesFlags.IntVar(concurrency, "concurrency", 8, "Specify the number of parallel requests (optional)")
+	deleteDupes := dupesFlags.Bool("delete", false, "Delete every duplicate but the oldest object of each set (optional)")
+	link := dupesFlags.Bool("link", false, "Replace every duplicate but the oldest with a hard link stub to it (optional)")
+	overrideProtection := dupesFlags.Bool("override-protection", false, "Delete or link protected duplicates too (optional)")
+	dryRun := dupesFlags.Bool("dry-run", false, "Print what --delete or --link would do without doing it (optional)")
+	parseFlags(dupesFlags, os.Args[2:])
+
+	if *bucketName == "" {
+		utils.ExitWithError("Bucket name not specified. Use -b or --bucket flag, or set DefaultBucket in config.")
+	}
+	if *deleteDupes && *link {
+		utils.ExitWithError("--delete and --link cannot be used together.")
+	}
+
+	fmt.Printf("Looking for duplicate content in bucket '%s' prefix '%s'...\n", *bucketName, *prefix)
+	sets, err := r2.FindDuplicates(ctx, client, *bucketName, *prefix, *concurrency)
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to compare objects in bucket '%s': %v", *bucketName, err))
+	}
+
+	var redundant, sampled int
+	var savings int64
+	for _, set := range sets {
+		label := ""
+		if set.Sampled() {
+			label = ", probable"
+			sampled++
+		}
+		fmt.Printf("\n%d copies of %s (%s%s), %s reclaimable:\n", len(set.Objects), formatSize(set.Size), set.Hash, label, formatSize(set.Savings()))
+		fmt.Printf("  keep  %s\n", set.Objects[0].Key)
+		for _, obj := range set.Objects[1:] {
+			fmt.Printf("        %s\n", obj.Key)
+		}
+		redundant += len(set.Objects) - 1
+		savings += set.Savings()
+	}
+	fmt.Printf("\n%d duplicate set(s), %d redundant object(s), %s reclaimable.\n", len(sets), redundant, formatSize(savings))
+	if sampled > 0 {
+		fmt.Printf("Note: %d set(s) were matched on sampled content only and are left alone by --delete and --link.\n", sampled)
+	}
+	if !*deleteDupes && !*link {
+		return
+	}
+
+	action, done := "delete", "deleted"
+	if *link {
+		action, done = "link", "linked"
+	}
+	changed, failed := 0, 0
+	for _, set := range sets {
+		if set.Sampled() {
+			continue
+		}
+		kept := set.Objects[0]
+		for _, obj := range set.Objects[1:] {
+			if prefix, ok := cfg.AppendOnlyPrefix(obj.Key); ok {
+				fmt.Printf("Keeping '%s': prefix '%s' is append-only.\n", obj.Key, prefix)
+				continue
+			}
+			if obj.Protected && !*overrideProtection {
+				fmt.Printf("Keeping '%s': %v.\n", obj.Key, r2.ErrProtected)
+				continue
+			}
+			if *dryRun {
+				if *link {
+					fmt.Printf("(dry run) link '%s' to '%s'\n", obj.Key, kept.Key)
+				} else {
+					fmt.Printf("(dry run) delete '%s'\n", obj.Key)
+				}
+				continue
+			}
+
+			// The object may have been protected since it was compared.
+			if !*overrideProtection {
+				if err := r2.CheckNotProtected(ctx, client, *bucketName, obj.Key); err != nil {
+					if errors.Is(err, r2.ErrProtected) {
+						fmt.Printf("Keeping '%s': %v.\n", obj.Key, r2.ErrProtected)
+					} else {
+						fmt.Printf("Failed: %v\n", err)
+						failed++
+					}
+					continue
+				}
+			}
+			if *link {
+				fmt.Printf("Linking '%s' to '%s'...\n", obj.Key, kept.Key)
+				err = r2.LinkDuplicate(ctx, client, *bucketName, obj.Key, kept.Key, obj.LastModified)
+			} else {
+				fmt.Printf("Deleting '%s'...\n", obj.Key)
+				err = r2.DeleteObject(ctx, client, *bucketName, obj.Key)
+			}
+			if err != nil {
+				fmt.Printf("Failed: %v\n", err)
+				failed++
+				continue
+			}
+			changed++
+		}
+	}
+	if *dryRun {
+		return
+	}
+
+	fmt.Printf("Deduplication complete: %d %s, %d failed.\n", changed, done, failed)
+	if failed > 0 {
+		utils.ExitWithError(fmt.Sprintf("Failed to %s %d duplicate(s).", action, failed))
+	}
+}
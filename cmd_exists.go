@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/baowuhe/go-cfr2/config"
+	"github.com/baowuhe/go-cfr2/r2"
+	"github.com/baowuhe/go-cfr2/utils"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const (
+	// existsMissingExitCode is the status code of exists for an object that does not exist.
+	existsMissingExitCode = 1
+	// existsErrorExitCode is the status code of exists when it cannot tell, e.g. for network or
+	// authentication errors. It matches the status code of invalid flags.
+	existsErrorExitCode = 2
+)
+
+// handleExistsCommand checks whether an object exists and reports it through the status code
+// alone, unless -v is given, for use in shell scripts.
+func handleExistsCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	// Also covers an alias expanding to exists, for errors from here on.
+	utils.SetErrorExitCode(existsErrorExitCode)
+
+	existsFlags := flag.NewFlagSet("exists", flag.ExitOnError)
+	bucketName := existsFlags.String("b", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	existsFlags.StringVar(bucketName, "bucket", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	objectKey := existsFlags.String("k", "", "Specify the object key to check (required)")
+	existsFlags.StringVar(objectKey, "key", "", "Specify the object key to check (required)")
+	verbose := existsFlags.Bool("v", false, "Print whether the object exists, and errors (optional)")
+	existsFlags.BoolVar(verbose, "verbose", false, "Print whether the object exists, and errors (optional)")
+	parseFlags(existsFlags, os.Args[2:])
+
+	if *bucketName == "" {
+		utils.ExitWithError("Bucket name not specified. Use -b or --bucket flag, or set DefaultBucket in config.")
+	}
+	if *objectKey == "" {
+		utils.ExitWithError("Object key not specified. Use -k or --key flag.")
+	}
+
+	exists, err := r2.ObjectExists(ctx, client, *bucketName, *objectKey)
+	switch {
+	case err != nil && *verbose:
+		utils.ExitWithError(err.Error())
+	case err != nil:
+		os.Exit(existsErrorExitCode)
+	case !exists:
+		if *verbose {
+			fmt.Printf("Object '%s' does not exist in bucket '%s'.\n", *objectKey, *bucketName)
+		}
+		os.Exit(existsMissingExitCode)
+	case *verbose:
+		fmt.Printf("Object '%s' exists in bucket '%s'.\n", *objectKey, *bucketName)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/baowuhe/go-cfr2/config"
+	"github.com/baowuhe/go-cfr2/r2"
+	"github.com/baowuhe/go-cfr2/utils"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// expandArchiveKind returns the archive format of a key from its extension: zip, tar or tar.gz,
+// or "" if it is not a supported archive.
+func expandArchiveKind(key string) string {
+	key = strings.ToLower(key)
+	switch {
+	case strings.HasSuffix(key, ".zip"):
+		return "zip"
+	case strings.HasSuffix(key, ".tar"):
+		return "tar"
+	case strings.HasSuffix(key, ".tar.gz"), strings.HasSuffix(key, ".tgz"):
+		return "tar.gz"
+	}
+	return ""
+}
+
+// handleExpandCommand extracts a zip or tar archive stored in the bucket and uploads each of its
+// files as an object below a prefix, without downloading the archive first. Zip archives are read
+// with ranged requests, since their directory is at the end; tar archives are streamed.
+func handleExpandCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	expandFlags := flag.NewFlagSet("expand", flag.ExitOnError)
+	bucketName := expandFlags.String("b", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	expandFlags.StringVar(bucketName, "bucket", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	objectKey := expandFlags.String("k", "", "Specify the key of the .zip, .tar, .tar.gz or .tgz archive (required)")
+	expandFlags.StringVar(objectKey, "key", "", "Specify the key of the .zip, .tar, .tar.gz or .tgz archive (required)")
+	prefix := expandFlags.String("p", "", "Specify the key prefix the files are uploaded below (required)")
+	expandFlags.StringVar(prefix, "prefix", "", "Specify the key prefix the files are uploaded below (required)")
+	dryRun := expandFlags.Bool("dry-run", false, "Print the keys that would be uploaded without uploading (optional)")
+	parseFlags(expandFlags, os.Args[2:])
+
+	if *bucketName == "" {
+		utils.ExitWithError("Bucket name not specified. Use -b or --bucket flag, or set DefaultBucket in config.")
+	}
+	if *objectKey == "" {
+		utils.ExitWithError("Object key not specified. Use -k or --key flag.")
+	}
+	if *prefix == "" {
+		utils.ExitWithError("Prefix not specified. Use -p or --prefix flag.")
+	}
+	if !strings.HasSuffix(*prefix, "/") {
+		*prefix += "/"
+	}
+	kind := expandArchiveKind(*objectKey)
+	if kind == "" {
+		utils.ExitWithError(fmt.Sprintf("Unsupported archive '%s'. Use a .zip, .tar, .tar.gz or .tgz archive.", *objectKey))
+	}
+
+	uploaded, skipped, failed := 0, 0, 0
+	extract := func(name string, size int64, body io.Reader) {
+		name = strings.TrimPrefix(name, "./")
+		if !fs.ValidPath(name) {
+			utils.Warnf("skipping '%s', which is not a valid key name.", name)
+			skipped++
+			return
+		}
+		key := *prefix + name
+		if appendOnly, ok := cfg.AppendOnlyPrefix(key); ok {
+			exists, err := r2.ObjectExists(ctx, client, *bucketName, key)
+			if err == nil && exists {
+				fmt.Printf("Keeping '%s': prefix '%s' is append-only.\n", key, appendOnly)
+				skipped++
+				return
+			}
+		}
+		if *dryRun {
+			fmt.Printf("(dry run) upload '%s' (%s)\n", key, formatSize(size))
+			uploaded++
+			return
+		}
+
+		fmt.Printf("Uploading '%s' (%s)\n", key, formatSize(size))
+		opts := r2.UploadOptions{Quiet: true}
+		if contentType := mime.TypeByExtension(path.Ext(name)); contentType != "" {
+			opts.ContentType = aws.String(contentType)
+		}
+		if _, err := r2.UploadStreamWithOptions(ctx, client, *bucketName, key, body, opts); err != nil {
+			fmt.Printf("Failed to upload '%s': %v\n", key, err)
+			failed++
+			return
+		}
+		uploaded++
+	}
+
+	fmt.Printf("Expanding '%s' into '%s' in bucket '%s'...\n", *objectKey, *prefix, *bucketName)
+	var err error
+	if kind == "zip" {
+		err = expandZip(ctx, client, *bucketName, *objectKey, extract)
+	} else {
+		err = expandTar(ctx, client, *bucketName, *objectKey, kind == "tar.gz", extract)
+	}
+	if *dryRun {
+		fmt.Printf("Would upload %d file(s), skip %d.\n", uploaded, skipped)
+	} else {
+		fmt.Printf("Uploaded %d file(s), skipped %d, failed %d.\n", uploaded, skipped, failed)
+	}
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to read archive '%s': %v", *objectKey, err))
+	}
+	if failed > 0 {
+		utils.ExitWithError(fmt.Sprintf("Failed to upload %d file(s).", failed))
+	}
+}
+
+// expandZip calls extract for every file of a zip archive, in the order of its directory.
+func expandZip(ctx context.Context, client *s3.Client, bucketName, objectKey string, extract func(name string, size int64, body io.Reader)) error {
+	stat, err := r2.StatObject(ctx, client, bucketName, objectKey)
+	if err != nil {
+		return err
+	}
+	zr, err := zip.NewReader(r2.NewObjectReaderAt(ctx, client, bucketName, objectKey, stat.Size), stat.Size)
+	if err != nil {
+		return err
+	}
+	for _, file := range zr.File {
+		if !file.Mode().IsRegular() {
+			continue
+		}
+		body, err := file.Open()
+		if err != nil {
+			return fmt.Errorf("failed to open entry '%s': %w", file.Name, err)
+		}
+		extract(file.Name, int64(file.UncompressedSize64), body)
+		body.Close()
+	}
+	return nil
+}
+
+// expandTar calls extract for every regular file of a tar archive, streaming the archive once.
+func expandTar(ctx context.Context, client *s3.Client, bucketName, objectKey string, gzipped bool, extract func(name string, size int64, body io.Reader)) error {
+	body, err := r2.OpenObject(ctx, client, bucketName, objectKey)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	var reader io.Reader = body
+	if gzipped {
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		reader = gz
+	}
+	tr := tar.NewReader(reader)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		extract(header.Name, header.Size, tr)
+	}
+}
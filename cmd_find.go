@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/baowuhe/go-cfr2/config"
+	"github.com/baowuhe/go-cfr2/r2"
+	"github.com/baowuhe/go-cfr2/utils"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// handleFindCommand prints the keys of the objects matching every filter given, and optionally
+// deletes or presigns them. The bucket is listed, so that every current object is seen; the local
+// index is searched instead with --index, or when metadata or tag filters need it.
+func handleFindCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	findFlags := flag.NewFlagSet("find", flag.ExitOnError)
+	bucketName := findFlags.String("b", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	findFlags.StringVar(bucketName, "bucket", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	prefix := findFlags.String("p", "", "Only find objects below this key prefix (optional)")
+	findFlags.StringVar(prefix, "prefix", "", "Only find objects below this key prefix (optional)")
+	name := findFlags.String("name", "", "Only find objects whose base name matches this glob, e.g. '*.log' (optional)")
+	largerThan := findFlags.String("larger-than", "", "Only find objects larger than this size, e.g. 100M (optional)")
+	smallerThan := findFlags.String("smaller-than", "", "Only find objects smaller than this size, e.g. 1K (optional)")
+	olderThan := findFlags.String("older-than", "", "Only find objects modified before this age or date, e.g. 30d or 2024-01-01 (optional)")
+	newerThan := findFlags.String("newer-than", "", "Only find objects modified after this age or date, e.g. 12h or 2024-01-01 (optional)")
+	var metaFilters, tagFilters stringListFlag
+	findFlags.Var(&metaFilters, "meta", "Only find objects with this metadata, as name=value or just name; repeatable (optional)")
+	findFlags.Var(&tagFilters, "tag", "Only find objects with this tag, as name=value or just name; repeatable (optional)")
+	useIndex := findFlags.Bool("index", false, "Search the local index instead of listing the bucket (optional)")
+	deleteMatches := findFlags.Bool("delete", false, "Delete every matching object (optional)")
+	presign := findFlags.Bool("presign", false, "Print a presigned URL next to every matching key (optional)")
+	expiry := findFlags.String("expiry", "24h", "Specify the expiry of the URLs printed with --presign, e.g. 7d (optional)")
+	parseFlags(findFlags, os.Args[2:])
+
+	if *bucketName == "" {
+		utils.ExitWithError("Bucket name not specified. Use -b or --bucket flag, or set DefaultBucket in config.")
+	}
+	if _, err := path.Match(*name, ""); err != nil {
+		utils.ExitWithError(fmt.Sprintf("Invalid --name pattern '%s': %v", *name, err))
+	}
+	if *deleteMatches && *presign {
+		utils.ExitWithError("--delete and --presign cannot be used together.")
+	}
+	minSize, maxSize := parseSizeBound("--larger-than", *largerThan), parseSizeBound("--smaller-than", *smallerThan)
+	now := time.Now()
+	before, after := parseTimeBound("--older-than", *olderThan, now), parseTimeBound("--newer-than", *newerThan, now)
+	presignExpiry, err := utils.ParseDuration(*expiry)
+	if err != nil || presignExpiry <= 0 {
+		utils.ExitWithError(fmt.Sprintf("Invalid --expiry value '%s'. Use a duration such as 24h or 7d.", *expiry))
+	}
+
+	match := func(record r2.ObjectSnapshot) bool {
+		if !strings.HasPrefix(record.Key, *prefix) {
+			return false
+		}
+		if *name != "" {
+			if ok, _ := path.Match(*name, path.Base(record.Key)); !ok {
+				return false
+			}
+		}
+		if *largerThan != "" && record.Size <= minSize || *smallerThan != "" && record.Size >= maxSize {
+			return false
+		}
+		if !before.IsZero() && !record.LastModified.Before(before) || !after.IsZero() && !record.LastModified.After(after) {
+			return false
+		}
+		// Metadata names are case-insensitive, and R2 returns them in lower case.
+		for _, filter := range metaFilters {
+			name, value, hasValue := strings.Cut(filter, "=")
+			if actual, ok := record.Metadata[strings.ToLower(name)]; !ok || hasValue && actual != value {
+				return false
+			}
+		}
+		for _, filter := range tagFilters {
+			name, value, hasValue := strings.Cut(filter, "=")
+			if actual, ok := record.Tags[name]; !ok || hasValue && actual != value {
+				return false
+			}
+		}
+		return true
+	}
+
+	var keys []string
+	// etags holds the ETag of every match, to recognize objects replaced since the index was built.
+	etags := map[string]string{}
+	found := func(record r2.ObjectSnapshot) error {
+		if !match(record) {
+			return nil
+		}
+		keys = append(keys, record.Key)
+		etags[record.Key] = record.ETag
+		if *deleteMatches {
+			// Deleting while listing could disturb the listing; the keys are deleted afterwards.
+			return nil
+		}
+		if *presign {
+			url, err := r2.GeneratePresignedURLWithExpiry(ctx, client, *bucketName, record.Key, presignExpiry)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s\t%s\n", record.Key, url)
+			return nil
+		}
+		fmt.Println(record.Key)
+		return nil
+	}
+
+	if *useIndex || len(metaFilters) > 0 || len(tagFilters) > 0 {
+		header, err := loadObjectIndex(objectIndexPath(*bucketName), found)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			utils.ExitWithError(fmt.Sprintf("Bucket '%s' has no index. Create one with 'go-cfr2 index build'.", *bucketName))
+		case err != nil:
+			utils.ExitWithError(err.Error())
+		}
+		// Notes go to stderr, so that the keys can be piped into other commands.
+		if len(metaFilters) > 0 && !header.Metadata {
+			fmt.Fprintln(os.Stderr, "Note: the index has no metadata. Rebuild it with 'go-cfr2 index build --metadata'.")
+		}
+		if len(tagFilters) > 0 && !header.Tags {
+			fmt.Fprintln(os.Stderr, "Note: the index has no tags. Rebuild it with 'go-cfr2 index build --tags'.")
+		}
+		if !strings.HasPrefix(*prefix, header.Prefix) {
+			fmt.Fprintf(os.Stderr, "Note: the index only covers prefix '%s'.\n", header.Prefix)
+		}
+		if *deleteMatches {
+			fmt.Fprintf(os.Stderr, "Note: matches come from the index built %s ago; objects changed since are skipped.\n", time.Since(header.Built).Round(time.Second))
+			keys = unchangedSinceIndex(ctx, client, *bucketName, keys, etags)
+		}
+	} else {
+		err := r2.WalkObjects(ctx, client, *bucketName, *prefix, func(obj types.Object) error {
+			if r2.IsDirectoryMarker(obj) {
+				return nil
+			}
+			return found(r2.ObjectSnapshot{
+				Key:          *obj.Key,
+				Size:         aws.ToInt64(obj.Size),
+				ETag:         strings.Trim(aws.ToString(obj.ETag), `"`),
+				LastModified: aws.ToTime(obj.LastModified),
+			})
+		})
+		if err != nil {
+			utils.ExitWithError(fmt.Sprintf("Failed to list objects in bucket '%s': %v", *bucketName, err))
+		}
+	}
+	if len(keys) == 0 {
+		fmt.Fprintln(os.Stderr, "No matching objects.")
+		return
+	}
+
+	if *deleteMatches {
+		deleted, kept, failed := deleteKeys(ctx, client, cfg, *bucketName, keys, false, nil)
+		fmt.Printf("Deleted %d of %d matching object(s), kept %d, failed %d.\n", deleted, len(keys), kept, failed)
+		if failed > 0 {
+			utils.ExitWithError(fmt.Sprintf("Failed to delete %d object(s).", failed))
+		}
+	}
+}
+
+// unchangedSinceIndex returns the keys whose objects still have the ETag recorded in the index,
+// with a HEAD request for each. The index may be old, and an object replaced since no longer
+// necessarily matches the filters.
+func unchangedSinceIndex(ctx context.Context, client *s3.Client, bucketName string, keys []string, etags map[string]string) []string {
+	current := map[string]bool{}
+	pipeline := r2.NewHeadPipeline(ctx, client, bucketName, 0, func(key string, head *s3.HeadObjectOutput, err error) error {
+		var notFound *types.NotFound
+		switch {
+		case errors.As(err, &notFound):
+			fmt.Printf("Skipping '%s': deleted since the index was built.\n", key)
+		case err != nil:
+			return err
+		case strings.Trim(aws.ToString(head.ETag), `"`) != etags[key]:
+			fmt.Printf("Skipping '%s': changed since the index was built.\n", key)
+		default:
+			current[key] = true
+		}
+		return nil
+	})
+	var err error
+	for _, key := range keys {
+		if err = pipeline.Add(key); err != nil {
+			break
+		}
+	}
+	if waitErr := pipeline.Wait(); err == nil {
+		err = waitErr
+	}
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to check the matching objects: %v", err))
+	}
+
+	unchanged := keys[:0]
+	for _, key := range keys {
+		if current[key] {
+			unchanged = append(unchanged, key)
+		}
+	}
+	return unchanged
+}
+
+// parseSizeBound parses the value of a size filter flag, which may be empty.
+func parseSizeBound(flagName, value string) int64 {
+	if value == "" {
+		return 0
+	}
+	size, err := utils.ParseSize(value)
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Invalid %s value '%s'. Use a size such as 100M or 1.5G.", flagName, value))
+	}
+	return size
+}
+
+// parseTimeBound parses the value of a time filter flag: an age such as 30d, counted back from
+// now, or a date or RFC 3339 time. An empty value gives the zero time.
+func parseTimeBound(flagName, value string, now time.Time) time.Time {
+	if value == "" {
+		return time.Time{}
+	}
+	if age, err := utils.ParseDuration(value); err == nil {
+		return now.Add(-age)
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t
+	}
+	utils.ExitWithError(fmt.Sprintf("Invalid %s value '%s'. Use an age such as 30d or a date such as 2024-01-01.", flagName, value))
+	return time.Time{}
+}
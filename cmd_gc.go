@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/baowuhe/go-cfr2/config"
+	"github.com/baowuhe/go-cfr2/r2"
+	"github.com/baowuhe/go-cfr2/utils"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func handleGcCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	gcFlags := flag.NewFlagSet("gc", flag.ExitOnError)
+	bucketName := gcFlags.String("b", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	gcFlags.StringVar(bucketName, "bucket", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	prefix := gcFlags.String("p", "", "Only collect objects below this key prefix (optional)")
+	gcFlags.StringVar(prefix, "prefix", "", "Only collect objects below this key prefix (optional)")
+	dryRun := gcFlags.Bool("dry-run", false, "Print the expired objects without deleting them (optional)")
+	concurrency := gcFlags.Int("c", 8, "Specify the number of parallel metadata requests (optional)")
+	gcFlags.IntVar(concurrency, "concurrency", 8, "Specify the number of parallel metadata requests (optional)")
+	parseFlags(gcFlags, os.Args[2:])
+
+	if *bucketName == "" {
+		utils.ExitWithError("Bucket name not specified. Use -b or --bucket flag, or set DefaultBucket in config.")
+	}
+
+	fmt.Printf("Looking for expired objects in bucket '%s' prefix '%s'...\n", *bucketName, *prefix)
+	expired, err := r2.FindExpiredObjects(ctx, client, *bucketName, *prefix, time.Now(), *concurrency)
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to find expired objects in bucket '%s': %v", *bucketName, err))
+	}
+
+	deleted, failed := 0, 0
+	for _, key := range expired {
+		if prefix, ok := cfg.AppendOnlyPrefix(key); ok {
+			fmt.Printf("Keeping '%s': prefix '%s' is append-only.\n", key, prefix)
+			continue
+		}
+		if *dryRun {
+			fmt.Printf("(dry run) delete '%s'\n", key)
+			continue
+		}
+		fmt.Printf("Deleting '%s'...\n", key)
+		if err := r2.DeleteObject(ctx, client, *bucketName, key); err != nil {
+			fmt.Printf("Failed: %v\n", err)
+			failed++
+			continue
+		}
+		deleted++
+	}
+
+	if *dryRun {
+		fmt.Printf("%d expired object(s) found.\n", len(expired))
+		return
+	}
+	fmt.Printf("Garbage collection complete: %d deleted, %d failed.\n", deleted, failed)
+	if failed > 0 {
+		utils.ExitWithError(fmt.Sprintf("Failed to delete %d expired object(s).", failed))
+	}
+}
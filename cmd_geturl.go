@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/baowuhe/go-cfr2/r2"
+	"github.com/baowuhe/go-cfr2/utils"
+)
+
+// getURLChunkSize is the size of the ranged segments a download is split into. Completed
+// segments are remembered, so an interrupted download resumes at this granularity.
+const getURLChunkSize = 8 << 20
+
+// getURLState is the resume file kept next to a partial download.
+type getURLState struct {
+	Size int64  `json:"size"`
+	ETag string `json:"etag"`
+	Done []bool `json:"done"`
+}
+
+// getURLProbe describes the object behind a URL, as reported by a one-byte ranged request.
+type getURLProbe struct {
+	size      int64
+	etag      string
+	sha256    string
+	rangeable bool
+}
+
+// handleGetURLCommand downloads a presigned URL. It runs without configuration or credentials,
+// so that recipients of shared links can use it too.
+func handleGetURLCommand(ctx context.Context) {
+	getFlags := flag.NewFlagSet("get-url", flag.ExitOnError)
+	outputPath := getFlags.String("o", "", "Specify the output file path (optional)")
+	getFlags.StringVar(outputPath, "output", "", "Specify the output file path (optional)")
+	concurrency := getFlags.Int("c", 4, "Specify the number of segments downloaded in parallel (optional)")
+	getFlags.IntVar(concurrency, "concurrency", 4, "Specify the number of segments downloaded in parallel (optional)")
+	expectedSHA256 := getFlags.String("sha256", "", "Verify the download against this hex SHA-256 digest (optional)")
+	args := parseInterspersed(getFlags, os.Args[2:])
+
+	if len(args) != 1 {
+		utils.ExitWithError("URL not specified. Usage: go-cfr2 get-url <url> [-o file]")
+	}
+	rawURL := args[0]
+	parsed, err := url.Parse(rawURL)
+	if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") {
+		utils.ExitWithError(fmt.Sprintf("'%s' is not an http or https URL.", rawURL))
+	}
+	if *concurrency < 1 {
+		utils.ExitWithError("Concurrency must be at least 1.")
+	}
+	if *outputPath == "" {
+		*outputPath = path.Base(parsed.Path)
+		if *outputPath == "/" || *outputPath == "." {
+			utils.ExitWithError("Cannot derive a file name from the URL. Use -o or --output flag.")
+		}
+	}
+
+	fmt.Printf("Downloading '%s' to '%s'...\n", parsed.Redacted(), *outputPath)
+	probe, err := probeURL(ctx, rawURL)
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to download: %v", err))
+	}
+
+	partPath := *outputPath + ".part"
+	if probe.rangeable {
+		err = downloadSegments(ctx, rawURL, partPath, probe, *concurrency)
+	} else {
+		fmt.Println("Server does not support ranged requests, downloading in one piece.")
+		err = downloadWhole(ctx, rawURL, partPath)
+	}
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to download: %v (run the command again to resume)", err))
+	}
+
+	if err := verifyDownload(partPath, probe, *expectedSHA256); err != nil {
+		os.Remove(partPath)
+		os.Remove(partPath + ".json")
+		utils.ExitWithError(err.Error())
+	}
+	if err := os.Rename(partPath, *outputPath); err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to move download into place: %v", err))
+	}
+	os.Remove(partPath + ".json")
+	fmt.Printf("Successfully downloaded %d bytes to '%s'.\n", probe.size, *outputPath)
+}
+
+// probeURL requests the first byte of the URL to learn the object's size and identity. A HEAD
+// request cannot be used, since a presigned URL is signed for GET only.
+func probeURL(ctx context.Context, rawURL string) (getURLProbe, error) {
+	resp, err := rangedGet(ctx, rawURL, "bytes=0-0")
+	if err != nil {
+		return getURLProbe{}, err
+	}
+	resp.Body.Close()
+
+	probe := getURLProbe{
+		etag:   strings.Trim(resp.Header.Get("ETag"), `"`),
+		sha256: resp.Header.Get("X-Amz-Meta-Sha256"),
+	}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		// Content-Range: bytes 0-0/12345
+		_, total, ok := strings.Cut(resp.Header.Get("Content-Range"), "/")
+		size, err := strconv.ParseInt(total, 10, 64)
+		if !ok || err != nil {
+			return getURLProbe{}, fmt.Errorf("unexpected Content-Range '%s'", resp.Header.Get("Content-Range"))
+		}
+		probe.size, probe.rangeable = size, true
+	case http.StatusRequestedRangeNotSatisfiable:
+		// An empty object has no first byte.
+		probe.rangeable = true
+	default:
+		probe.size = resp.ContentLength
+	}
+	return probe, nil
+}
+
+// rangedGet sends a GET request for a byte range and fails on any status but 200, 206 and 416.
+func rangedGet(ctx context.Context, rawURL, byteRange string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", byteRange)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent, http.StatusRequestedRangeNotSatisfiable:
+		return resp, nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	resp.Body.Close()
+	return nil, fmt.Errorf("server returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+}
+
+// downloadSegments downloads the object in ranged segments, several at a time, into partPath.
+// Completed segments are recorded in a resume file, and skipped when the same object is
+// downloaded again after an interruption.
+func downloadSegments(ctx context.Context, rawURL, partPath string, probe getURLProbe, concurrency int) error {
+	chunks := int((probe.size + getURLChunkSize - 1) / getURLChunkSize)
+	statePath := partPath + ".json"
+	state := loadGetURLState(statePath, probe, chunks)
+
+	file, err := os.OpenFile(partPath, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to create '%s': %w", partPath, err)
+	}
+	defer file.Close()
+	if err := file.Truncate(probe.size); err != nil {
+		return fmt.Errorf("failed to allocate '%s': %w", partPath, err)
+	}
+
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		errs []error
+		done int
+	)
+	for _, d := range state.Done {
+		if d {
+			done++
+		}
+	}
+	if done > 0 {
+		fmt.Printf("Resuming: %d of %d segment(s) already downloaded.\n", done, chunks)
+	}
+
+	queue := make(chan int)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for chunk := range queue {
+				err := downloadSegment(ctx, rawURL, file, probe, chunk)
+
+				mu.Lock()
+				if err != nil {
+					errs = append(errs, err)
+				} else {
+					state.Done[chunk] = true
+					done++
+					fmt.Printf("\r%d / %d segments (%.2f%%)", done, chunks, float64(done)/float64(chunks)*100)
+					saveGetURLState(statePath, state)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for chunk, d := range state.Done {
+		if !d {
+			queue <- chunk
+		}
+	}
+	close(queue)
+	wg.Wait()
+	if chunks > 0 {
+		fmt.Println()
+	}
+
+	return errors.Join(errs...)
+}
+
+// downloadSegment fetches one segment and writes it at its offset.
+func downloadSegment(ctx context.Context, rawURL string, file *os.File, probe getURLProbe, chunk int) error {
+	start := int64(chunk) * getURLChunkSize
+	end := min(start+getURLChunkSize, probe.size) - 1
+	resp, err := rangedGet(ctx, rawURL, fmt.Sprintf("bytes=%d-%d", start, end))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("server ignored the range of segment %d", chunk)
+	}
+	if etag := strings.Trim(resp.Header.Get("ETag"), `"`); etag != probe.etag {
+		return fmt.Errorf("the object changed during the download")
+	}
+
+	n, err := io.Copy(io.NewOffsetWriter(file, start), resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to download segment %d: %w", chunk, err)
+	}
+	if n != end-start+1 {
+		return fmt.Errorf("segment %d is truncated", chunk)
+	}
+	return nil
+}
+
+// downloadWhole downloads the object in a single request.
+func downloadWhole(ctx context.Context, rawURL, partPath string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("server returned %s", resp.Status)
+	}
+
+	file, err := os.Create(partPath)
+	if err != nil {
+		return fmt.Errorf("failed to create '%s': %w", partPath, err)
+	}
+	defer file.Close()
+	if _, err := io.Copy(file, resp.Body); err != nil {
+		return fmt.Errorf("failed to write '%s': %w", partPath, err)
+	}
+	return nil
+}
+
+// loadGetURLState returns the resume state of a partial download, or a fresh one if there is
+// none or it belongs to a different version of the object.
+func loadGetURLState(path string, probe getURLProbe, chunks int) *getURLState {
+	state := &getURLState{}
+	if data, err := os.ReadFile(path); err == nil && json.Unmarshal(data, state) == nil &&
+		state.Size == probe.size && state.ETag == probe.etag && len(state.Done) == chunks {
+		return state
+	}
+	return &getURLState{Size: probe.size, ETag: probe.etag, Done: make([]bool, chunks)}
+}
+
+func saveGetURLState(path string, state *getURLState) {
+	if data, err := json.Marshal(state); err == nil {
+		os.WriteFile(path, data, 0o644)
+	}
+}
+
+// verifyDownload checks the downloaded file against the expected SHA-256 digest, the digest
+// recorded in the object's metadata at upload, or an MD5 ETag, whichever is available first.
+func verifyDownload(path string, probe getURLProbe, expectedSHA256 string) error {
+	var (
+		h        hash.Hash
+		expected string
+		name     string
+	)
+	switch {
+	case expectedSHA256 != "":
+		h, expected, name = sha256.New(), strings.ToLower(expectedSHA256), "SHA-256"
+	case probe.sha256 != "":
+		h, expected, name = sha256.New(), probe.sha256, "SHA-256"
+	case r2.IsMD5ETag(probe.etag):
+		h, expected, name = md5.New(), probe.etag, "MD5"
+	default:
+		utils.Warnf("no checksum available, the download was not verified.")
+		return nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open '%s': %w", path, err)
+	}
+	defer file.Close()
+	if _, err := io.Copy(h, file); err != nil {
+		return fmt.Errorf("failed to hash '%s': %w", path, err)
+	}
+	if actual := hex.EncodeToString(h.Sum(nil)); actual != expected {
+		return fmt.Errorf("%s mismatch: expected %s, got %s; the download was discarded", name, expected, actual)
+	}
+	fmt.Printf("Verified %s checksum.\n", name)
+	return nil
+}
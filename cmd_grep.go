@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"sync"
+
+	"github.com/baowuhe/go-cfr2/config"
+	"github.com/baowuhe/go-cfr2/r2"
+	"github.com/baowuhe/go-cfr2/utils"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// grepMaxLine is the longest line grep handles; longer lines fail the search of their object.
+const grepMaxLine = 16 * 1024 * 1024
+
+// grepOptions holds the flags of grep that apply to every object searched.
+type grepOptions struct {
+	re          *regexp.Regexp
+	lineNumbers bool
+	count       bool
+}
+
+// grepDefaultConcurrency is the number of objects grep searches at once by default.
+const grepDefaultConcurrency = 8
+
+// handleGrepCommand searches objects for lines matching a regular expression, streaming each one
+// and printing key:line for every match, so that logs can be searched without downloading them.
+// Several objects are searched at once; the lines of each object are printed together.
+func handleGrepCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	grepFlags := flag.NewFlagSet("grep", flag.ExitOnError)
+	bucketName := grepFlags.String("b", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	grepFlags.StringVar(bucketName, "bucket", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	objectKey := grepFlags.String("k", "", "Specify the object key, or a glob such as 'logs/2025-01-*.log', to search")
+	grepFlags.StringVar(objectKey, "key", "", "Specify the object key, or a glob such as 'logs/2025-01-*.log', to search")
+	prefix := grepFlags.String("p", "", "Search every object below this key prefix")
+	grepFlags.StringVar(prefix, "prefix", "", "Search every object below this key prefix")
+	ignoreCase := grepFlags.Bool("i", false, "Ignore case when matching (optional)")
+	lineNumbers := grepFlags.Bool("n", false, "Print the line number of every match (optional)")
+	count := grepFlags.Bool("c", false, "Print the number of matching lines of each object instead of the lines (optional)")
+	grepFlags.BoolVar(count, "count", false, "Print the number of matching lines of each object instead of the lines (optional)")
+	concurrency := grepFlags.Int("concurrency", grepDefaultConcurrency, "Specify the number of objects searched at once (optional)")
+	args := parseInterspersed(grepFlags, os.Args[2:])
+
+	if *bucketName == "" {
+		utils.ExitWithError("Bucket name not specified. Use -b or --bucket flag, or set DefaultBucket in config.")
+	}
+	if (*objectKey == "") == (*prefix == "") {
+		utils.ExitWithError("Specify either an object key with -k or a prefix with -p.")
+	}
+	if *concurrency <= 0 {
+		utils.ExitWithError("The --concurrency must be positive.")
+	}
+	if len(args) != 1 {
+		utils.ExitWithError("Pattern not specified. Usage: go-cfr2 grep (-k <key> | -p <prefix>) <pattern> [flags]")
+	}
+	expr := args[0]
+	if *ignoreCase {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Invalid pattern '%s': %v", args[0], err))
+	}
+	opts := grepOptions{re: re, lineNumbers: *lineNumbers, count: *count}
+
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		counts = make(map[string]int)
+		failed int
+	)
+	queue := make(chan string)
+	for i := 0; i < *concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range queue {
+				// Each object's lines are buffered, so that the output of objects searched at once
+				// does not interleave.
+				var buf bytes.Buffer
+				n, err := grepObject(ctx, client, *bucketName, key, opts, &buf)
+
+				mu.Lock()
+				os.Stdout.Write(buf.Bytes())
+				if err != nil {
+					// Errors go to stderr, so that the matches can be piped into other commands.
+					fmt.Fprintf(os.Stderr, "Failed to search '%s': %v\n", key, err)
+					failed++
+				} else {
+					counts[key] = n
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	if *prefix != "" {
+		err = r2.WalkObjects(ctx, client, *bucketName, *prefix, func(obj types.Object) error {
+			if !r2.IsDirectoryMarker(obj) {
+				queue <- *obj.Key
+			}
+			return nil
+		})
+	} else {
+		for _, key := range expandKeyArg(ctx, client, *bucketName, *objectKey) {
+			queue <- key
+		}
+	}
+	close(queue)
+	wg.Wait()
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to list objects in bucket '%s': %v", *bucketName, err))
+	}
+
+	keys := make([]string, 0, len(counts))
+	matches, matched := 0, 0
+	for key, n := range counts {
+		keys = append(keys, key)
+		matches += n
+		if n > 0 {
+			matched++
+		}
+	}
+	if *count {
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Printf("%s:%d\n", key, counts[key])
+		}
+	}
+
+	if failed > 0 {
+		utils.ExitWithError(fmt.Sprintf("Failed to search %d object(s).", failed))
+	}
+	switch {
+	case matches == 0:
+		fmt.Fprintln(os.Stderr, "No matching lines.")
+	case len(counts) > 1:
+		fmt.Fprintf(os.Stderr, "Found %d matching line(s) in %d of %d object(s).\n", matches, matched, len(counts))
+	}
+}
+
+// grepObject streams an object, decompressing it according to the extension of its key, and writes
+// its matching lines to w, unless only counting. It returns the number of matching lines.
+func grepObject(ctx context.Context, client *s3.Client, bucketName, objectKey string, opts grepOptions, w io.Writer) (int, error) {
+	body, err := r2.OpenObject(ctx, client, bucketName, objectKey)
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+	reader, err := utils.NewDecompressReader(body, objectKey)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+
+	matches := 0
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), grepMaxLine)
+	for line := 1; scanner.Scan(); line++ {
+		if !opts.re.Match(scanner.Bytes()) {
+			continue
+		}
+		matches++
+		switch {
+		case opts.count:
+		case opts.lineNumbers:
+			fmt.Fprintf(w, "%s:%d:%s\n", objectKey, line, scanner.Bytes())
+		default:
+			fmt.Fprintf(w, "%s:%s\n", objectKey, scanner.Bytes())
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return matches, fmt.Errorf("failed to read object '%s': %w", objectKey, err)
+	}
+	return matches, nil
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/baowuhe/go-cfr2/config"
+	"github.com/baowuhe/go-cfr2/r2"
+	"github.com/baowuhe/go-cfr2/utils"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	// headTailChunk is the size of the first ranged read of head and tail. Each further read of the
+	// same object doubles, up to headTailMaxChunk, so that long lines need few requests.
+	headTailChunk    = 64 * 1024
+	headTailMaxChunk = 8 * 1024 * 1024
+)
+
+// parseHeadTailFlags parses the flags shared by head and tail, plus any that extra defines, and
+// returns the bucket, key and line count.
+func parseHeadTailFlags(name string, cfg *config.R2Config, extra func(*flag.FlagSet)) (string, string, int) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	bucketName := fs.String("b", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	fs.StringVar(bucketName, "bucket", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	objectKey := fs.String("k", "", "Specify the object key to read (required)")
+	fs.StringVar(objectKey, "key", "", "Specify the object key to read (required)")
+	lines := fs.Int("n", 10, "Specify the number of lines to print (optional)")
+	fs.IntVar(lines, "lines", 10, "Specify the number of lines to print (optional)")
+	if extra != nil {
+		extra(fs)
+	}
+	parseFlags(fs, os.Args[2:])
+
+	if *bucketName == "" {
+		utils.ExitWithError("Bucket name not specified. Use -b or --bucket flag, or set DefaultBucket in config.")
+	}
+	if *objectKey == "" {
+		utils.ExitWithError("Object key not specified. Use -k or --key flag.")
+	}
+	if *lines < 0 {
+		utils.ExitWithError("The number of lines cannot be negative.")
+	}
+	return *bucketName, *objectKey, *lines
+}
+
+// statForHeadTail returns the size of an object, exiting with an error if it does not exist.
+func statForHeadTail(ctx context.Context, client *s3.Client, bucketName, objectKey string) int64 {
+	stat, err := r2.StatObject(ctx, client, bucketName, objectKey)
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		utils.ExitWithError(fmt.Sprintf("Object '%s' not found in bucket '%s'.", objectKey, bucketName))
+	}
+	if err != nil {
+		utils.ExitWithError(err.Error())
+	}
+	return stat.Size
+}
+
+// readObjectBytes reads the bytes from start up to, but not including, end of an object.
+func readObjectBytes(ctx context.Context, client *s3.Client, bucketName, objectKey string, start, end int64) ([]byte, error) {
+	body, err := r2.OpenObjectRange(ctx, client, bucketName, objectKey, fmt.Sprintf("bytes=%d-%d", start, end-1))
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read object '%s': %w", objectKey, err)
+	}
+	return data, nil
+}
+
+// handleHeadCommand prints the first lines of an object, fetching only as much of it as needed.
+func handleHeadCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	bucketName, objectKey, lines := parseHeadTailFlags("head", cfg, nil)
+	size := statForHeadTail(ctx, client, bucketName, objectKey)
+
+	var data []byte
+	chunk := int64(headTailChunk)
+	for offset := int64(0); offset < size && bytes.Count(data, []byte("\n")) < lines; {
+		end := min(offset+chunk, size)
+		part, err := readObjectBytes(ctx, client, bucketName, objectKey, offset, end)
+		if err != nil {
+			utils.ExitWithError(err.Error())
+		}
+		data = append(data, part...)
+		offset = end
+		chunk = min(chunk*2, headTailMaxChunk)
+	}
+
+	os.Stdout.Write(firstLines(data, lines))
+}
+
+// handleTailCommand prints the last lines of an object, reading it backwards from the end. With
+// --follow it then keeps polling the object and prints whatever is appended to it.
+func handleTailCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	var follow bool
+	var interval time.Duration
+	bucketName, objectKey, lines := parseHeadTailFlags("tail", cfg, func(fs *flag.FlagSet) {
+		fs.BoolVar(&follow, "f", false, "Keep printing data appended to the object (optional)")
+		fs.BoolVar(&follow, "follow", false, "Keep printing data appended to the object (optional)")
+		fs.DurationVar(&interval, "interval", 5*time.Second, "Specify how often --follow checks the object for new data (optional)")
+	})
+	if follow && interval <= 0 {
+		utils.ExitWithError("The --interval must be positive.")
+	}
+	size := statForHeadTail(ctx, client, bucketName, objectKey)
+
+	var data []byte
+	chunk := int64(headTailChunk)
+	for start := size; start > 0; {
+		if _, ok := lastLinesStart(data, lines); ok {
+			break
+		}
+		end := start
+		start = max(end-chunk, 0)
+		part, err := readObjectBytes(ctx, client, bucketName, objectKey, start, end)
+		if err != nil {
+			utils.ExitWithError(err.Error())
+		}
+		data = append(part, data...)
+		chunk = min(chunk*2, headTailMaxChunk)
+	}
+
+	i, _ := lastLinesStart(data, lines)
+	os.Stdout.Write(data[i:])
+
+	if follow {
+		followObject(ctx, client, bucketName, objectKey, size, interval)
+	}
+}
+
+// followObject polls the size of an object and prints the bytes beyond offset whenever it grows,
+// until ctx is done. Objects cannot be appended to in place, so this suits logs that are rewritten
+// with more data each time. An object that shrinks is taken to be replaced and printed from the
+// start; notes go to stderr so that stdout carries only the object's content.
+func followObject(ctx context.Context, client *s3.Client, bucketName, objectKey string, offset int64, interval time.Duration) {
+	missing := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+
+		stat, err := r2.StatObject(ctx, client, bucketName, objectKey)
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			if !missing {
+				fmt.Fprintf(os.Stderr, "Note: '%s' no longer exists, waiting for it to reappear.\n", objectKey)
+				missing = true
+			}
+			continue
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Note: %v\n", err)
+			continue
+		}
+		missing = false
+
+		if stat.Size < offset {
+			fmt.Fprintf(os.Stderr, "Note: '%s' was truncated, printing it from the start.\n", objectKey)
+			offset = 0
+		}
+		if stat.Size == offset {
+			continue
+		}
+		data, err := readObjectBytes(ctx, client, bucketName, objectKey, offset, stat.Size)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Note: %v\n", err)
+			continue
+		}
+		os.Stdout.Write(data)
+		offset += int64(len(data))
+	}
+}
+
+// firstLines returns the first n lines of data, each with its newline.
+func firstLines(data []byte, n int) []byte {
+	end := 0
+	for ; n > 0; n-- {
+		i := bytes.IndexByte(data[end:], '\n')
+		if i < 0 {
+			return data
+		}
+		end += i + 1
+	}
+	return data[:end]
+}
+
+// lastLinesStart returns the offset in data where its last n lines start. ok is false if data
+// holds fewer than n complete lines, so that more of the object must be read before it. A newline
+// ending data does not start another line.
+func lastLinesStart(data []byte, n int) (int, bool) {
+	if n == 0 {
+		return len(data), true
+	}
+	end := len(data)
+	if end > 0 && data[end-1] == '\n' {
+		end--
+	}
+	for ; n > 0; n-- {
+		i := bytes.LastIndexByte(data[:end], '\n')
+		if i < 0 {
+			return 0, false
+		}
+		end = i
+	}
+	return end + 1, true
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/baowuhe/go-cfr2/config"
+	"github.com/baowuhe/go-cfr2/r2"
+	"github.com/baowuhe/go-cfr2/utils"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// objectIndexHeader is the first line of a local object index, followed by one r2.ObjectSnapshot
+// line per object in key order.
+type objectIndexHeader struct {
+	Bucket string    `json:"bucket"`
+	Prefix string    `json:"prefix"`
+	Built  time.Time `json:"built"`
+	// Metadata and Tags tell whether the records carry metadata and content headers, and tags.
+	Metadata bool `json:"metadata"`
+	Tags     bool `json:"tags"`
+}
+
+// objectIndexPath returns the path of the local index of a bucket.
+func objectIndexPath(bucketName string) string {
+	indexPath, err := config.StatePath("index-" + bucketName + ".jsonl.gz")
+	if err != nil {
+		utils.ExitWithError(err.Error())
+	}
+	return indexPath
+}
+
+// loadObjectIndex reads a local object index, calling fn for every record. The error wraps
+// os.ErrNotExist if the bucket has no index.
+func loadObjectIndex(indexPath string, fn func(r2.ObjectSnapshot) error) (objectIndexHeader, error) {
+	var header objectIndexHeader
+	file, err := os.Open(indexPath)
+	if err != nil {
+		return header, err
+	}
+	defer file.Close()
+	reader, err := utils.NewDecompressReader(file, indexPath)
+	if err != nil {
+		return header, err
+	}
+	defer reader.Close()
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	if !scanner.Scan() {
+		return header, fmt.Errorf("index '%s' is empty", indexPath)
+	}
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		return header, fmt.Errorf("failed to parse index '%s': %w", indexPath, err)
+	}
+	for scanner.Scan() {
+		var record r2.ObjectSnapshot
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return header, fmt.Errorf("failed to parse index '%s': %w", indexPath, err)
+		}
+		if err := fn(record); err != nil {
+			return header, err
+		}
+	}
+	return header, scanner.Err()
+}
+
+// saveObjectIndex writes a local object index atomically.
+func saveObjectIndex(indexPath string, header objectIndexHeader, records []r2.ObjectSnapshot) error {
+	tmpPath := indexPath + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to write index '%s': %w", indexPath, err)
+	}
+	defer os.Remove(tmpPath)
+	defer file.Close()
+	compressor, err := utils.NewCompressWriter(file, indexPath)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(compressor)
+	if err := encoder.Encode(header); err != nil {
+		return err
+	}
+	for _, record := range records {
+		if err := encoder.Encode(record); err != nil {
+			return err
+		}
+	}
+	if err := compressor.Close(); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, indexPath)
+}
+
+func handleIndexCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	if len(os.Args) < 3 {
+		utils.ExitWithError("Index subcommand not specified. Use 'index build' or 'index status'.")
+	}
+
+	switch os.Args[2] {
+	case "build":
+		handleIndexBuildCommand(ctx, client, cfg)
+	case "status":
+		handleIndexStatusCommand(cfg)
+	default:
+		utils.ExitWithError(fmt.Sprintf("Unknown index subcommand '%s'. Use 'index build' or 'index status'.", os.Args[2]))
+	}
+}
+
+func handleIndexBuildCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	buildFlags := flag.NewFlagSet("index build", flag.ExitOnError)
+	bucketName := buildFlags.String("b", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	buildFlags.StringVar(bucketName, "bucket", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	prefix := buildFlags.String("p", "", "Only index objects below this key prefix (optional)")
+	buildFlags.StringVar(prefix, "prefix", "", "Only index objects below this key prefix (optional)")
+	withMetadata := buildFlags.Bool("metadata", false, "Store the metadata and content headers of every object, fetched with HEAD requests (optional)")
+	withTags := buildFlags.Bool("tags", false, "Store the tags of every object (optional)")
+	parallel := buildFlags.Int("parallel", 8, "Fetch the metadata or tags of this many objects concurrently (optional)")
+	parseFlags(buildFlags, os.Args[3:])
+
+	if *bucketName == "" {
+		utils.ExitWithError("Bucket name not specified. Use -b or --bucket flag, or set DefaultBucket in config.")
+	}
+	if *parallel < 1 {
+		utils.ExitWithError("--parallel must be at least 1.")
+	}
+
+	indexPath := objectIndexPath(*bucketName)
+	fmt.Printf("Indexing bucket '%s' prefix '%s'...\n", *bucketName, *prefix)
+	header := objectIndexHeader{Bucket: *bucketName, Prefix: *prefix, Built: time.Now().UTC(), Metadata: *withMetadata, Tags: *withTags}
+	records, fetched, err := buildObjectIndex(ctx, client, indexPath, &header, *parallel)
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to index bucket '%s': %v", *bucketName, err))
+	}
+	if err := saveObjectIndex(indexPath, header, records); err != nil {
+		utils.ExitWithError(err.Error())
+	}
+	fmt.Printf("Successfully indexed %d object(s), fetching the details of %d, to '%s'.\n", len(records), fetched, indexPath)
+}
+
+// buildObjectIndex lists the objects below the header's prefix and, if the header asks for them,
+// fetches their metadata and tags. Details of objects whose ETag is unchanged since the previous
+// index are reused, so that refreshing an index only sends HEAD requests for new or changed
+// objects. Tags are dropped from the header if the endpoint does not implement tagging.
+func buildObjectIndex(ctx context.Context, client *s3.Client, indexPath string, header *objectIndexHeader, parallel int) ([]r2.ObjectSnapshot, int, error) {
+	previous := map[string]r2.ObjectSnapshot{}
+	previousHeader, err := loadObjectIndex(indexPath, func(record r2.ObjectSnapshot) error {
+		previous[record.Key] = record
+		return nil
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		fmt.Printf("Note: ignoring the previous index: %v\n", err)
+	}
+	reusable := (previousHeader.Metadata || !header.Metadata) && (previousHeader.Tags || !header.Tags)
+
+	var (
+		mu      sync.Mutex
+		records []r2.ObjectSnapshot
+		fetched int
+		jobs    = make(chan types.Object)
+		wg      sync.WaitGroup
+		failure error
+		tags    atomic.Bool
+	)
+	tags.Store(header.Tags)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for range parallel {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for obj := range jobs {
+				record, err := r2.SnapshotObject(ctx, client, header.Bucket, *obj.Key, tags.Load())
+				if errors.Is(err, r2.ErrTaggingUnsupported) {
+					if tags.Swap(false) {
+						fmt.Println("Note: the bucket does not support object tagging, tags are not indexed.")
+					}
+					err = nil
+				}
+				var notFound *types.NotFound
+				if errors.As(err, &notFound) {
+					// Deleted since it was listed.
+					continue
+				}
+				mu.Lock()
+				if err != nil && failure == nil {
+					failure = err
+					cancel()
+				}
+				if err == nil {
+					records = append(records, record)
+					fetched++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	err = r2.WalkObjects(ctx, client, header.Bucket, header.Prefix, func(obj types.Object) error {
+		record := r2.ObjectSnapshot{
+			Key:          aws.ToString(obj.Key),
+			Size:         aws.ToInt64(obj.Size),
+			ETag:         strings.Trim(aws.ToString(obj.ETag), `"`),
+			LastModified: aws.ToTime(obj.LastModified).UTC(),
+		}
+		if old, ok := previous[record.Key]; header.Metadata || header.Tags {
+			if !ok || !reusable || old.ETag != record.ETag {
+				select {
+				case jobs <- obj:
+					return nil
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			record = old
+			record.Size, record.LastModified = aws.ToInt64(obj.Size), aws.ToTime(obj.LastModified).UTC()
+		}
+		mu.Lock()
+		records = append(records, record)
+		mu.Unlock()
+		return nil
+	})
+	close(jobs)
+	wg.Wait()
+	if failure != nil {
+		return nil, 0, failure
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	header.Tags = tags.Load()
+	if !header.Tags {
+		for i := range records {
+			records[i].Tags = nil
+		}
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Key < records[j].Key })
+	return records, fetched, nil
+}
+
+func handleIndexStatusCommand(cfg *config.R2Config) {
+	statusFlags := flag.NewFlagSet("index status", flag.ExitOnError)
+	bucketName := statusFlags.String("b", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	statusFlags.StringVar(bucketName, "bucket", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	parseFlags(statusFlags, os.Args[3:])
+
+	if *bucketName == "" {
+		utils.ExitWithError("Bucket name not specified. Use -b or --bucket flag, or set DefaultBucket in config.")
+	}
+
+	indexPath := objectIndexPath(*bucketName)
+	count, size := 0, int64(0)
+	header, err := loadObjectIndex(indexPath, func(record r2.ObjectSnapshot) error {
+		count++
+		size += record.Size
+		return nil
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		utils.ExitWithError(fmt.Sprintf("Bucket '%s' has no index. Create one with 'go-cfr2 index build'.", *bucketName))
+	}
+	if err != nil {
+		utils.ExitWithError(err.Error())
+	}
+	var details []string
+	if header.Metadata {
+		details = append(details, "metadata")
+	}
+	if header.Tags {
+		details = append(details, "tags")
+	}
+	if len(details) == 0 {
+		details = append(details, "listing only")
+	}
+	fmt.Printf("Index of bucket '%s' prefix '%s': %d object(s), %s, built %s ago (%s).\n",
+		header.Bucket, header.Prefix, count, formatSize(size), time.Since(header.Built).Round(time.Second), strings.Join(details, " and "))
+}
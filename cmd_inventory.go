@@ -0,0 +1,442 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/baowuhe/go-cfr2/config"
+	"github.com/baowuhe/go-cfr2/r2"
+	"github.com/baowuhe/go-cfr2/utils"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// inventoryRecord is one row of a bucket inventory.
+type inventoryRecord struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	ETag         string    `json:"etag"`
+	LastModified time.Time `json:"last_modified"`
+	StorageClass string    `json:"storage_class"`
+	// ContentType and Metadata are only filled in by 'inventory export --metadata'.
+	ContentType string            `json:"content_type,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+var inventoryCSVHeader = []string{"key", "size", "etag", "last_modified", "storage_class"}
+
+// inventoryParquetColumns are the columns of a Parquet inventory, named as those of a CSV one.
+var inventoryParquetColumns = []utils.ParquetColumn{
+	{Name: "key", Type: utils.ParquetString},
+	{Name: "size", Type: utils.ParquetInt64},
+	{Name: "etag", Type: utils.ParquetString},
+	{Name: "last_modified", Type: utils.ParquetTimestamp},
+	{Name: "storage_class", Type: utils.ParquetString},
+}
+
+func newInventoryRecord(obj types.Object) inventoryRecord {
+	record := inventoryRecord{
+		Key:          *obj.Key,
+		StorageClass: string(obj.StorageClass),
+	}
+	if obj.Size != nil {
+		record.Size = *obj.Size
+	}
+	if obj.ETag != nil {
+		record.ETag = strings.Trim(*obj.ETag, `"`)
+	}
+	if obj.LastModified != nil {
+		record.LastModified = obj.LastModified.UTC()
+	}
+	return record
+}
+
+func handleInventoryCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	if len(os.Args) < 3 {
+		utils.ExitWithError("Inventory subcommand not specified. Use 'inventory export' or 'inventory diff'.")
+	}
+
+	switch os.Args[2] {
+	case "export":
+		handleInventoryExportCommand(ctx, client, cfg)
+	case "diff":
+		handleInventoryDiffCommand(ctx, client, cfg)
+	default:
+		utils.ExitWithError(fmt.Sprintf("Unknown inventory subcommand '%s'. Use 'inventory export' or 'inventory diff'.", os.Args[2]))
+	}
+}
+
+func handleInventoryExportCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	exportFlags := flag.NewFlagSet("inventory export", flag.ExitOnError)
+	bucketName := exportFlags.String("b", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	exportFlags.StringVar(bucketName, "bucket", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	format := exportFlags.String("format", "jsonl", "Specify the inventory format: jsonl, csv or parquet (optional)")
+	outputPrefix := exportFlags.String("o", "inventory/", "Specify the key prefix the inventory is written under (optional)")
+	exportFlags.StringVar(outputPrefix, "output", "inventory/", "Specify the key prefix the inventory is written under (optional)")
+	parallel := exportFlags.Int("parallel", 1, "List this many shards of the keyspace concurrently (optional)")
+	withMetadata := exportFlags.Bool("metadata", false, "Include the content type and user-defined metadata of every object, fetched with concurrent HEAD requests (optional)")
+	parseFlags(exportFlags, os.Args[3:])
+
+	if *bucketName == "" {
+		utils.ExitWithError("Bucket name not specified. Use -b or --bucket flag, or set DefaultBucket in config.")
+	}
+	switch *format {
+	case "jsonl", "csv", "parquet":
+	default:
+		utils.ExitWithError(fmt.Sprintf("Unknown inventory format '%s'. Use jsonl, csv or parquet.", *format))
+	}
+	if *withMetadata && *format != "jsonl" {
+		utils.ExitWithError("--metadata requires --format jsonl.")
+	}
+
+	// Parquet compresses its pages itself.
+	ext := "." + *format + ".gz"
+	if *format == "parquet" {
+		ext = ".parquet"
+	}
+	objectKey := path.Join(*outputPrefix, *bucketName, time.Now().UTC().Format("20060102T150405Z")+ext)
+
+	fmt.Printf("Exporting inventory of bucket '%s' to '%s'...\n", *bucketName, objectKey)
+	count, err := exportInventory(ctx, client, *bucketName, *outputPrefix, objectKey, *format, *parallel, *withMetadata)
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to export inventory of bucket '%s': %v", *bucketName, err))
+	}
+	fmt.Printf("Successfully exported %d object(s) to '%s'.\n", count, objectKey)
+}
+
+// exportInventory streams the bucket listing into a compressed inventory object in the same bucket.
+// Objects under the inventory prefix itself are left out of the listing. With parallel > 1 the
+// listing is sharded and records are written in no particular order, as they are with withMetadata,
+// which fetches the metadata of every object through a HEAD pipeline.
+func exportInventory(ctx context.Context, client *s3.Client, bucketName, outputPrefix, objectKey, format string, parallel int, withMetadata bool) (int, error) {
+	pr, pw := io.Pipe()
+	compressor, err := utils.NewCompressWriter(pw, objectKey)
+	if err != nil {
+		return 0, err
+	}
+	// Compared on a path segment boundary, so that an inventory prefix 'out' leaves 'output/' listed.
+	excludePrefix := ""
+	if outputPrefix != "" {
+		excludePrefix = strings.TrimSuffix(outputPrefix, "/") + "/"
+	}
+
+	count := 0
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		write := inventoryWriter(compressor, format)
+		visit := func(obj types.Object) error {
+			if obj.Key == nil || (excludePrefix != "" && strings.HasPrefix(*obj.Key, excludePrefix)) {
+				return nil
+			}
+			count++
+			return write(newInventoryRecord(obj))
+		}
+
+		var pipeline *r2.HeadPipeline
+		if withMetadata {
+			var mu sync.Mutex
+			listed := map[string]inventoryRecord{}
+			pipeline = r2.NewHeadPipeline(ctx, client, bucketName, 0, func(key string, head *s3.HeadObjectOutput, err error) error {
+				mu.Lock()
+				record := listed[key]
+				delete(listed, key)
+				mu.Unlock()
+				var notFound *types.NotFound
+				if errors.As(err, &notFound) {
+					// Deleted since it was listed.
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+				record.ContentType = aws.ToString(head.ContentType)
+				record.Metadata = head.Metadata
+				count++
+				return write(record)
+			})
+			visit = func(obj types.Object) error {
+				if obj.Key == nil || (excludePrefix != "" && strings.HasPrefix(*obj.Key, excludePrefix)) {
+					return nil
+				}
+				mu.Lock()
+				listed[*obj.Key] = newInventoryRecord(obj)
+				mu.Unlock()
+				return pipeline.Add(*obj.Key)
+			}
+		}
+
+		var err error
+		if parallel > 1 {
+			err = r2.WalkObjectsSharded(ctx, client, bucketName, "", r2.ShardOptions{Concurrency: parallel}, visit)
+		} else {
+			err = r2.WalkObjects(ctx, client, bucketName, "", visit)
+		}
+		if pipeline != nil {
+			if waitErr := pipeline.Wait(); err == nil {
+				err = waitErr
+			}
+		}
+		if err == nil {
+			err = write(inventoryRecord{})
+		}
+		if closeErr := compressor.Close(); err == nil {
+			err = closeErr
+		}
+		pw.CloseWithError(err)
+	}()
+
+	err = r2.UploadStream(ctx, client, bucketName, objectKey, pr)
+	pr.CloseWithError(err)
+	<-done
+	return count, err
+}
+
+// inventoryWriter returns a function that encodes records in the given format.
+// Calling it with a zero record flushes any buffered output.
+func inventoryWriter(w io.Writer, format string) func(inventoryRecord) error {
+	if format == "parquet" {
+		parquetWriter := utils.NewParquetWriter(w, inventoryParquetColumns)
+		return func(record inventoryRecord) error {
+			if record.Key == "" {
+				return parquetWriter.Close()
+			}
+			return parquetWriter.Write(record.Key, record.Size, record.ETag, record.LastModified, record.StorageClass)
+		}
+	}
+	if format == "csv" {
+		csvWriter := csv.NewWriter(w)
+		headerWritten := false
+		return func(record inventoryRecord) error {
+			if !headerWritten {
+				headerWritten = true
+				if err := csvWriter.Write(inventoryCSVHeader); err != nil {
+					return err
+				}
+			}
+			if record.Key == "" {
+				csvWriter.Flush()
+				return csvWriter.Error()
+			}
+			return csvWriter.Write([]string{
+				record.Key,
+				strconv.FormatInt(record.Size, 10),
+				record.ETag,
+				record.LastModified.Format(time.RFC3339),
+				record.StorageClass,
+			})
+		}
+	}
+
+	encoder := json.NewEncoder(w)
+	return func(record inventoryRecord) error {
+		if record.Key == "" {
+			return nil
+		}
+		return encoder.Encode(record)
+	}
+}
+
+func handleInventoryDiffCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	diffFlags := flag.NewFlagSet("inventory diff", flag.ExitOnError)
+	bucketName := diffFlags.String("b", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	diffFlags.StringVar(bucketName, "bucket", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	inventoryPrefix := diffFlags.String("o", "inventory/", "Specify the key prefix inventories were written under (optional)")
+	diffFlags.StringVar(inventoryPrefix, "output", "inventory/", "Specify the key prefix inventories were written under (optional)")
+	summary := diffFlags.Bool("summary", false, "Print only the totals, not every object (optional)")
+	args := parseInterspersed(diffFlags, os.Args[3:])
+
+	if *bucketName == "" {
+		utils.ExitWithError("Bucket name not specified. Use -b or --bucket flag, or set DefaultBucket in config.")
+	}
+	if len(args) != 2 {
+		utils.ExitWithError("Two inventory snapshots required. Usage: go-cfr2 inventory diff <snapshot> <snapshot>")
+	}
+
+	var snapshots [2]map[string]inventoryRecord
+	for i, arg := range args {
+		name, records, err := loadInventorySnapshot(ctx, client, *bucketName, *inventoryPrefix, arg)
+		if err != nil {
+			utils.ExitWithError(fmt.Sprintf("Failed to load inventory snapshot '%s': %v", arg, err))
+		}
+		fmt.Printf("Loaded %d record(s) from '%s'.\n", len(records), name)
+		snapshots[i] = records
+	}
+
+	diff := diffInventories(snapshots[0], snapshots[1])
+	if !*summary {
+		for _, change := range diff.changes {
+			switch {
+			case change.before == nil:
+				fmt.Printf("+ %s (%d bytes)\n", change.after.Key, change.after.Size)
+			case change.after == nil:
+				fmt.Printf("- %s (%d bytes)\n", change.before.Key, change.before.Size)
+			default:
+				fmt.Printf("~ %s (%d -> %d bytes)\n", change.after.Key, change.before.Size, change.after.Size)
+			}
+		}
+	}
+	fmt.Printf("%d added (%d bytes), %d removed (%d bytes), %d changed (%+d bytes), net %+d bytes.\n",
+		diff.added, diff.addedBytes, diff.removed, diff.removedBytes, diff.changed, diff.changedBytes,
+		diff.addedBytes-diff.removedBytes+diff.changedBytes)
+}
+
+// loadInventorySnapshot reads an inventory written by 'inventory export'. arg names a local file,
+// an object key, or the start of a snapshot timestamp such as 20250115, which picks the latest
+// snapshot of the bucket under inventoryPrefix whose timestamp starts with it.
+func loadInventorySnapshot(ctx context.Context, client *s3.Client, bucketName, inventoryPrefix, arg string) (string, map[string]inventoryRecord, error) {
+	if file, err := os.Open(arg); err == nil {
+		defer file.Close()
+		records, err := readInventory(file, arg)
+		return arg, records, err
+	}
+
+	objectKey := arg
+	if !strings.Contains(arg, "/") {
+		var err error
+		objectKey, err = findInventorySnapshot(ctx, client, bucketName, inventoryPrefix, arg)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	body, err := r2.OpenObject(ctx, client, bucketName, objectKey)
+	if err != nil {
+		return "", nil, err
+	}
+	defer body.Close()
+	records, err := readInventory(body, objectKey)
+	return objectKey, records, err
+}
+
+// findInventorySnapshot returns the latest inventory of a bucket whose timestamp starts with stamp.
+func findInventorySnapshot(ctx context.Context, client *s3.Client, bucketName, inventoryPrefix, stamp string) (string, error) {
+	snapshotPrefix := path.Join(inventoryPrefix, bucketName) + "/"
+	latest := ""
+	err := r2.WalkObjects(ctx, client, bucketName, snapshotPrefix+stamp, func(obj types.Object) error {
+		if key := *obj.Key; key > latest && !strings.Contains(strings.TrimPrefix(key, snapshotPrefix), "/") {
+			latest = key
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if latest == "" {
+		return "", fmt.Errorf("no inventory of bucket '%s' under '%s' matches '%s'", bucketName, snapshotPrefix, stamp)
+	}
+	return latest, nil
+}
+
+// readInventory parses an inventory in the JSON Lines or CSV format, chosen by the extension of
+// name, and returns its records by key.
+func readInventory(r io.Reader, name string) (map[string]inventoryRecord, error) {
+	reader, err := utils.NewDecompressReader(r, name)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	records := map[string]inventoryRecord{}
+	if strings.HasSuffix(name, ".parquet") {
+		return nil, fmt.Errorf("inventory '%s' is in the Parquet format, which diff cannot read; compare jsonl or csv inventories", name)
+	}
+	if !strings.HasSuffix(utils.TrimCompressionExt(name), ".csv") {
+		decoder := json.NewDecoder(reader)
+		for {
+			var record inventoryRecord
+			err := decoder.Decode(&record)
+			if errors.Is(err, io.EOF) {
+				return records, nil
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse inventory '%s': %w", name, err)
+			}
+			records[record.Key] = record
+		}
+	}
+
+	csvReader := csv.NewReader(reader)
+	csvReader.FieldsPerRecord = len(inventoryCSVHeader)
+	if _, err := csvReader.Read(); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse inventory '%s': %w", name, err)
+	}
+	for {
+		row, err := csvReader.Read()
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse inventory '%s': %w", name, err)
+		}
+		size, err := strconv.ParseInt(row[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse inventory '%s': invalid size '%s'", name, row[1])
+		}
+		lastModified, _ := time.Parse(time.RFC3339, row[3])
+		records[row[0]] = inventoryRecord{Key: row[0], Size: size, ETag: row[2], LastModified: lastModified, StorageClass: row[4]}
+	}
+}
+
+// inventoryChange is an object that was added (before is nil), removed (after is nil) or changed
+// between two inventories.
+type inventoryChange struct {
+	before *inventoryRecord
+	after  *inventoryRecord
+}
+
+// inventoryDiff lists the changes between two inventories, sorted by key, with totals.
+type inventoryDiff struct {
+	changes                                []inventoryChange
+	added, removed, changed                int
+	addedBytes, removedBytes, changedBytes int64
+}
+
+// diffInventories compares two inventories. An object changed if its ETag or size differs.
+func diffInventories(before, after map[string]inventoryRecord) inventoryDiff {
+	var diff inventoryDiff
+	for key, old := range before {
+		old := old
+		current, ok := after[key]
+		switch {
+		case !ok:
+			diff.changes = append(diff.changes, inventoryChange{before: &old})
+			diff.removed++
+			diff.removedBytes += old.Size
+		case current.ETag != old.ETag || current.Size != old.Size:
+			diff.changes = append(diff.changes, inventoryChange{before: &old, after: &current})
+			diff.changed++
+			diff.changedBytes += current.Size - old.Size
+		}
+	}
+	for key, current := range after {
+		current := current
+		if _, ok := before[key]; !ok {
+			diff.changes = append(diff.changes, inventoryChange{after: &current})
+			diff.added++
+			diff.addedBytes += current.Size
+		}
+	}
+	sort.Slice(diff.changes, func(i, j int) bool { return diff.changes[i].key() < diff.changes[j].key() })
+	return diff
+}
+
+func (c inventoryChange) key() string {
+	if c.after != nil {
+		return c.after.Key
+	}
+	return c.before.Key
+}
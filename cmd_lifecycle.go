@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/baowuhe/go-cfr2/config"
+	"github.com/baowuhe/go-cfr2/utils"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func handleLifecycleCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	if len(os.Args) < 3 {
+		utils.ExitWithError("Lifecycle subcommand not specified. Use 'lifecycle get', 'lifecycle set' or 'lifecycle delete'.")
+	}
+
+	switch os.Args[2] {
+	case "get":
+		handleLifecycleGetCommand(ctx, client, cfg)
+	case "set":
+		handleLifecycleSetCommand(ctx, client, cfg)
+	case "delete":
+		handleLifecycleDeleteCommand(ctx, client, cfg)
+	default:
+		utils.ExitWithError(fmt.Sprintf("Unknown lifecycle subcommand '%s'. Use 'lifecycle get', 'lifecycle set' or 'lifecycle delete'.", os.Args[2]))
+	}
+}
+
+// handleLifecycleGetCommand prints the lifecycle rules of a bucket as JSON that 'lifecycle set'
+// accepts.
+func handleLifecycleGetCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	getFlags := flag.NewFlagSet("lifecycle get", flag.ExitOnError)
+	args := parseInterspersed(getFlags, os.Args[3:])
+	bucketName := bucketArg(args, cfg, "go-cfr2 lifecycle get [bucket]")
+
+	specs := []lifecycleRuleSpec{}
+	resp, err := client.GetBucketLifecycleConfiguration(ctx, &s3.GetBucketLifecycleConfigurationInput{Bucket: &bucketName})
+	switch {
+	case isAPIErrorCode(err, "NoSuchLifecycleConfiguration"):
+		// Notes go to stderr, so that the rules can be redirected into a file.
+		fmt.Fprintf(os.Stderr, "Note: bucket '%s' has no lifecycle rules.\n", bucketName)
+	case err != nil:
+		utils.ExitWithError(fmt.Sprintf("Failed to get lifecycle rules of bucket '%s': %v", bucketName, err))
+	default:
+		for _, rule := range resp.Rules {
+			spec, err := lifecycleRuleSpecOf(rule)
+			if err != nil {
+				utils.ExitWithError(fmt.Sprintf("Lifecycle rule '%s' of bucket '%s' %v, which 'lifecycle get' cannot represent. Manage it in the Cloudflare dashboard instead.", aws.ToString(rule.ID), bucketName, err))
+			}
+			specs = append(specs, spec)
+		}
+	}
+
+	data, err := json.MarshalIndent(specs, "", "  ")
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to encode lifecycle rules: %v", err))
+	}
+	fmt.Println(string(data))
+}
+
+// handleLifecycleSetCommand replaces the lifecycle rules of a bucket with those of a JSON file.
+func handleLifecycleSetCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	setFlags := flag.NewFlagSet("lifecycle set", flag.ExitOnError)
+	filePath := setFlags.String("f", "", "Specify the JSON file with the lifecycle rules, or - for stdin (required)")
+	setFlags.StringVar(filePath, "file", "", "Specify the JSON file with the lifecycle rules, or - for stdin (required)")
+	args := parseInterspersed(setFlags, os.Args[3:])
+	bucketName := bucketArg(args, cfg, "go-cfr2 lifecycle set [bucket] --file <lifecycle.json>")
+
+	if *filePath == "" {
+		utils.ExitWithError("Lifecycle file not specified. Use -f or --file flag.")
+	}
+	rules, err := loadLifecycleRules(*filePath)
+	if err != nil {
+		utils.ExitWithError(err.Error())
+	}
+	if len(rules) == 0 {
+		utils.ExitWithError(fmt.Sprintf("'%s' has no lifecycle rules. Use 'lifecycle delete' to remove the rules of a bucket.", *filePath))
+	}
+
+	fmt.Printf("Setting %d lifecycle rule(s) on bucket '%s'...\n", len(rules), bucketName)
+	for _, rule := range rules {
+		fmt.Printf("  %s\n", describeLifecycleRule(rule))
+	}
+	_, err = client.PutBucketLifecycleConfiguration(ctx, &s3.PutBucketLifecycleConfigurationInput{
+		Bucket:                 &bucketName,
+		LifecycleConfiguration: &types.BucketLifecycleConfiguration{Rules: rules},
+	})
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to set lifecycle rules of bucket '%s': %v", bucketName, err))
+	}
+	fmt.Printf("Successfully set the lifecycle rules of bucket '%s'.\n", bucketName)
+}
+
+// handleLifecycleDeleteCommand removes every lifecycle rule of a bucket.
+func handleLifecycleDeleteCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	deleteFlags := flag.NewFlagSet("lifecycle delete", flag.ExitOnError)
+	args := parseInterspersed(deleteFlags, os.Args[3:])
+	bucketName := bucketArg(args, cfg, "go-cfr2 lifecycle delete [bucket]")
+
+	fmt.Printf("Deleting the lifecycle rules of bucket '%s'...\n", bucketName)
+	if _, err := client.DeleteBucketLifecycle(ctx, &s3.DeleteBucketLifecycleInput{Bucket: &bucketName}); err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to delete lifecycle rules of bucket '%s': %v", bucketName, err))
+	}
+	fmt.Printf("Successfully deleted the lifecycle rules of bucket '%s'.\n", bucketName)
+}
+
+// loadLifecycleRules reads lifecycle rules from a JSON file with an array of rules, as written by
+// 'lifecycle get', or with an object holding the array as Rules.
+func loadLifecycleRules(path string) ([]types.LifecycleRule, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read lifecycle rules '%s': %w", path, err)
+	}
+
+	var specs []lifecycleRuleSpec
+	if err := json.Unmarshal(data, &specs); err != nil {
+		var wrapped struct {
+			Rules []lifecycleRuleSpec
+		}
+		if json.Unmarshal(data, &wrapped) != nil || wrapped.Rules == nil {
+			return nil, fmt.Errorf("failed to parse lifecycle rules '%s': %w", path, err)
+		}
+		specs = wrapped.Rules
+	}
+
+	rules := make([]types.LifecycleRule, 0, len(specs))
+	for i, spec := range specs {
+		if err := spec.validate(); err != nil {
+			return nil, fmt.Errorf("lifecycle rule %d in '%s' %v", i+1, path, err)
+		}
+		rules = append(rules, spec.rule())
+	}
+	return rules, nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func TestLifecycleRuleSpecRoundTrip(t *testing.T) {
+	date := time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)
+	rules := []types.LifecycleRule{
+		{
+			ID:         aws.String("expire-logs"),
+			Status:     types.ExpirationStatusEnabled,
+			Filter:     &types.LifecycleRuleFilter{Prefix: aws.String("logs/")},
+			Expiration: &types.LifecycleExpiration{Days: aws.Int32(30)},
+		},
+		{
+			Status:     types.ExpirationStatusDisabled,
+			Filter:     &types.LifecycleRuleFilter{Prefix: aws.String("")},
+			Expiration: &types.LifecycleExpiration{Date: &date},
+		},
+		{
+			ID:     aws.String("archive"),
+			Status: types.ExpirationStatusEnabled,
+			Filter: &types.LifecycleRuleFilter{Prefix: aws.String("data/")},
+			Transitions: []types.Transition{
+				{Days: aws.Int32(90), StorageClass: types.TransitionStorageClass("STANDARD_IA")},
+			},
+			AbortIncompleteMultipartUpload: &types.AbortIncompleteMultipartUpload{DaysAfterInitiation: aws.Int32(7)},
+		},
+		{
+			ID:         aws.String("tagged"),
+			Status:     types.ExpirationStatusEnabled,
+			Filter:     &types.LifecycleRuleFilter{Tag: &types.Tag{Key: aws.String("tmp"), Value: aws.String("yes")}},
+			Expiration: &types.LifecycleExpiration{Days: aws.Int32(1)},
+		},
+		{
+			ID:         aws.String("large"),
+			Status:     types.ExpirationStatusEnabled,
+			Filter:     &types.LifecycleRuleFilter{ObjectSizeGreaterThan: aws.Int64(1 << 30)},
+			Expiration: &types.LifecycleExpiration{Days: aws.Int32(14)},
+		},
+		{
+			ID:     aws.String("combined"),
+			Status: types.ExpirationStatusEnabled,
+			Filter: &types.LifecycleRuleFilter{And: &types.LifecycleRuleAndOperator{
+				Prefix: aws.String("uploads/"),
+				Tags: []types.Tag{
+					{Key: aws.String("a"), Value: aws.String("1")},
+					{Key: aws.String("b"), Value: aws.String("2")},
+				},
+				ObjectSizeGreaterThan: aws.Int64(1024),
+				ObjectSizeLessThan:    aws.Int64(1 << 20),
+			}},
+			Expiration: &types.LifecycleExpiration{Days: aws.Int32(3)},
+		},
+	}
+	for _, want := range rules {
+		spec, err := lifecycleRuleSpecOf(want)
+		if err != nil {
+			t.Fatalf("rule %q: %v", aws.ToString(want.ID), err)
+		}
+		if err := spec.validate(); err != nil {
+			t.Fatalf("rule %q: %v", aws.ToString(want.ID), err)
+		}
+		if got := spec.rule(); !reflect.DeepEqual(got, want) {
+			t.Errorf("rule %q did not survive the round trip:\ngot  %+v\nwant %+v", aws.ToString(want.ID), got, want)
+		}
+	}
+}
+
+func TestLifecycleRuleSpecOfUnrepresentable(t *testing.T) {
+	days := aws.Int32(30)
+	tests := []struct {
+		name string
+		rule types.LifecycleRule
+	}{
+		{"two transitions", types.LifecycleRule{Transitions: []types.Transition{{Days: days}, {Days: days}}}},
+		{"dated transition", types.LifecycleRule{Transitions: []types.Transition{{Date: aws.Time(time.Now())}}}},
+		{"delete markers", types.LifecycleRule{Expiration: &types.LifecycleExpiration{ExpiredObjectDeleteMarker: aws.Bool(true)}}},
+		{"noncurrent versions", types.LifecycleRule{NoncurrentVersionExpiration: &types.NoncurrentVersionExpiration{NoncurrentDays: days}}},
+	}
+	for _, tt := range tests {
+		if _, err := lifecycleRuleSpecOf(tt.rule); err == nil {
+			t.Errorf("%s: lifecycleRuleSpecOf accepted a rule it cannot represent", tt.name)
+		}
+	}
+}
+
+func TestLifecycleRuleSpecErrors(t *testing.T) {
+	date := time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		spec lifecycleRuleSpec
+	}{
+		{"no action", lifecycleRuleSpec{Prefix: "logs/"}},
+		{"days and date", lifecycleRuleSpec{ExpireDays: 1, ExpireDate: &date}},
+		{"transition without class", lifecycleRuleSpec{TransitionDays: 1}},
+		{"class without transition", lifecycleRuleSpec{ExpireDays: 1, StorageClass: "STANDARD_IA"}},
+		{"empty size range", lifecycleRuleSpec{ExpireDays: 1, ObjectSizeGreaterThan: 10, ObjectSizeLessThan: 10}},
+		{"negative days", lifecycleRuleSpec{ExpireDays: -1}},
+	}
+	for _, tt := range tests {
+		if err := tt.spec.validate(); err == nil {
+			t.Errorf("%s: validate() accepted an invalid rule", tt.name)
+		}
+	}
+}
+
+func TestLoadLifecycleRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lifecycle.json")
+	data := `{"Rules": [{"ID": "tmp", "Prefix": "tmp/", "Tags": {"tmp": "yes"}, "ExpireDays": 7}]}`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	rules, err := loadLifecycleRules(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 1 || rules[0].Filter.And == nil || aws.ToString(rules[0].Filter.And.Prefix) != "tmp/" || len(rules[0].Filter.And.Tags) != 1 {
+		t.Errorf("unexpected rules %+v", rules)
+	}
+}
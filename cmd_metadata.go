@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/baowuhe/go-cfr2/config"
+	"github.com/baowuhe/go-cfr2/r2"
+	"github.com/baowuhe/go-cfr2/utils"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func handleMetadataCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	if len(os.Args) < 3 {
+		utils.ExitWithError("Metadata subcommand not specified. Use 'metadata get', 'metadata set', 'metadata export' or 'metadata import'.")
+	}
+
+	switch os.Args[2] {
+	case "get":
+		handleMetadataGetCommand(ctx, client, cfg)
+	case "set":
+		handleMetadataSetCommand(ctx, client, cfg)
+	case "export":
+		handleMetadataExportCommand(ctx, client, cfg)
+	case "import":
+		handleMetadataImportCommand(ctx, client, cfg)
+	default:
+		utils.ExitWithError(fmt.Sprintf("Unknown metadata subcommand '%s'. Use 'metadata get', 'metadata set', 'metadata export' or 'metadata import'.", os.Args[2]))
+	}
+}
+
+// handleMetadataGetCommand prints the content headers and user-defined metadata of objects, or
+// with --json the lines 'metadata export' writes for them.
+func handleMetadataGetCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	getFlags := flag.NewFlagSet("metadata get", flag.ExitOnError)
+	bucketName := getFlags.String("b", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	getFlags.StringVar(bucketName, "bucket", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	objectKey := getFlags.String("k", "", "Specify the object key, or a glob such as 'assets/*.js' (required)")
+	getFlags.StringVar(objectKey, "key", "", "Specify the object key, or a glob such as 'assets/*.js' (required)")
+	asJSON := getFlags.Bool("json", false, "Print one JSON object per line in the format of 'metadata export' (optional)")
+	parseFlags(getFlags, os.Args[3:])
+
+	if *bucketName == "" {
+		utils.ExitWithError("Bucket name not specified. Use -b or --bucket flag, or set DefaultBucket in config.")
+	}
+	if *objectKey == "" {
+		utils.ExitWithError("Object key not specified. Use -k or --key flag.")
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	for i, key := range expandKeyArg(ctx, client, *bucketName, *objectKey) {
+		if *asJSON {
+			snapshot, err := r2.SnapshotObject(ctx, client, *bucketName, key, false)
+			if err != nil {
+				exitObjectError(err, *bucketName, key)
+			}
+			encoder.Encode(snapshot)
+			continue
+		}
+
+		stat, err := r2.StatObject(ctx, client, *bucketName, key)
+		if err != nil {
+			exitObjectError(err, *bucketName, key)
+		}
+		if i > 0 {
+			fmt.Println()
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "Key:\t%s\n", stat.Key)
+		writeObjectMetadata(w, stat)
+		w.Flush()
+	}
+}
+
+// handleMetadataSetCommand changes the user-defined metadata and content headers of objects in
+// place, by copying each object onto itself with the new metadata. Their content is left alone.
+func handleMetadataSetCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	setFlags := flag.NewFlagSet("metadata set", flag.ExitOnError)
+	bucketName := setFlags.String("b", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	setFlags.StringVar(bucketName, "bucket", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	objectKey := setFlags.String("k", "", "Specify the object key, or a glob such as 'assets/*.js' (required)")
+	setFlags.StringVar(objectKey, "key", "", "Specify the object key, or a glob such as 'assets/*.js' (required)")
+	var setMeta, removeMeta stringListFlag
+	setFlags.Var(&setMeta, "meta", "Set user-defined metadata, as name=value; repeatable (optional)")
+	setFlags.Var(&removeMeta, "remove", "Remove this user-defined metadata; repeatable (optional)")
+	setFlags.String("content-type", "", "Set the Content-Type header, or remove it with an empty value (optional)")
+	setFlags.String("cache-control", "", "Set the Cache-Control header, or remove it with an empty value (optional)")
+	setFlags.String("content-disposition", "", "Set the Content-Disposition header, or remove it with an empty value (optional)")
+	setFlags.String("content-encoding", "", "Set the Content-Encoding header, or remove it with an empty value (optional)")
+	setFlags.String("content-language", "", "Set the Content-Language header, or remove it with an empty value (optional)")
+	parseFlags(setFlags, os.Args[3:])
+
+	if *bucketName == "" {
+		utils.ExitWithError("Bucket name not specified. Use -b or --bucket flag, or set DefaultBucket in config.")
+	}
+	if *objectKey == "" {
+		utils.ExitWithError("Object key not specified. Use -k or --key flag.")
+	}
+
+	// Header flags are only applied when given, so that an empty value can remove a header.
+	var headers r2.HeaderUpdate
+	var changes []string
+	setFlags.Visit(func(f *flag.Flag) {
+		value := f.Value.String()
+		var header **string
+		switch f.Name {
+		case "content-type":
+			header = &headers.ContentType
+		case "cache-control":
+			header = &headers.CacheControl
+		case "content-disposition":
+			header = &headers.ContentDisposition
+		case "content-encoding":
+			header = &headers.ContentEncoding
+		case "content-language":
+			header = &headers.ContentLanguage
+		default:
+			return
+		}
+		*header = &value
+		if value == "" {
+			changes = append(changes, fmt.Sprintf("remove header %s", f.Name))
+		} else {
+			changes = append(changes, fmt.Sprintf("set header %s: %s", f.Name, value))
+		}
+	})
+
+	values := map[string]string{}
+	for _, entry := range setMeta {
+		name, value, err := parseMetaPair(entry)
+		if err != nil {
+			utils.ExitWithError(err.Error())
+		}
+		values[name] = value
+		changes = append(changes, fmt.Sprintf("set %s=%s", name, value))
+	}
+	removed := make([]string, 0, len(removeMeta))
+	for _, name := range removeMeta {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if _, ok := values[name]; ok {
+			utils.ExitWithError(fmt.Sprintf("Metadata '%s' is both set and removed.", name))
+		}
+		removed = append(removed, name)
+		changes = append(changes, fmt.Sprintf("remove %s", name))
+	}
+	if slices.Contains(removed, r2.MetadataProtected) {
+		utils.ExitWithError(fmt.Sprintf("Metadata '%s' marks protected objects. Use 'protect' or 'unprotect' instead.", r2.MetadataProtected))
+	}
+	if len(changes) == 0 {
+		utils.ExitWithError("Nothing to change. Use --meta, --remove or a header flag such as --content-type.")
+	}
+
+	keys := expandKeyArg(ctx, client, *bucketName, *objectKey)
+	fmt.Printf("Updating the metadata of %d object(s) in bucket '%s':\n", len(keys), *bucketName)
+	for _, change := range changes {
+		fmt.Printf("  %s\n", change)
+	}
+	failed := 0
+	for _, key := range keys {
+		if appendOnly, ok := cfg.AppendOnlyPrefix(key); ok {
+			fmt.Printf("Refusing to modify '%s': prefix '%s' is append-only.\n", key, appendOnly)
+			failed++
+			continue
+		}
+		err := r2.UpdateObjectMetadata(ctx, client, *bucketName, key, headers, func(metadata map[string]string) {
+			for name, value := range values {
+				metadata[name] = value
+			}
+			for _, name := range removed {
+				delete(metadata, name)
+			}
+		})
+		if err != nil {
+			fmt.Printf("Failed to update '%s': %v\n", key, err)
+			failed++
+			continue
+		}
+		fmt.Printf("Updated '%s'\n", key)
+	}
+	if failed > 0 {
+		utils.ExitWithError(fmt.Sprintf("Failed to update the metadata of %d object(s).", failed))
+	}
+	fmt.Printf("Successfully updated the metadata of %d object(s).\n", len(keys))
+}
+
+// exitObjectError exits with a message for a failed request on an object, naming missing
+// objects plainly.
+func exitObjectError(err error, bucketName, key string) {
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		utils.ExitWithError(fmt.Sprintf("Object '%s' not found in bucket '%s'.", key, bucketName))
+	}
+	utils.ExitWithError(err.Error())
+}
+
+// handleMetadataExportCommand writes the metadata of every object below a prefix in the snapshot
+// format, one JSON object per line, so it can be edited and fed back to 'metadata import'.
+func handleMetadataExportCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	exportFlags := flag.NewFlagSet("metadata export", flag.ExitOnError)
+	bucketName := exportFlags.String("b", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	exportFlags.StringVar(bucketName, "bucket", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	prefix := exportFlags.String("p", "", "Only export objects below this key prefix (optional)")
+	exportFlags.StringVar(prefix, "prefix", "", "Only export objects below this key prefix (optional)")
+	outputPath := exportFlags.String("o", "", "Specify the local file the metadata is written to, e.g. meta.jsonl (required)")
+	exportFlags.StringVar(outputPath, "output", "", "Specify the local file the metadata is written to, e.g. meta.jsonl (required)")
+	noTags := exportFlags.Bool("no-tags", false, "Do not export object tags (optional)")
+	parseFlags(exportFlags, os.Args[3:])
+
+	if *bucketName == "" {
+		utils.ExitWithError("Bucket name not specified. Use -b or --bucket flag, or set DefaultBucket in config.")
+	}
+	if *outputPath == "" {
+		utils.ExitWithError("Output file not specified. Use -o or --output flag.")
+	}
+
+	fmt.Printf("Exporting metadata of bucket '%s' prefix '%s' to '%s'...\n", *bucketName, *prefix, *outputPath)
+	count, err := createSnapshot(ctx, client, *bucketName, *prefix, *outputPath, !*noTags)
+	if err != nil {
+		os.Remove(*outputPath)
+		utils.ExitWithError(fmt.Sprintf("Failed to export metadata of bucket '%s': %v", *bucketName, err))
+	}
+	fmt.Printf("Successfully exported the metadata of %d object(s) to '%s'.\n", count, *outputPath)
+}
+
+// handleMetadataImportCommand applies an edited export. Objects whose content changed since the
+// export are skipped unless --force is given, so a stale file cannot clobber newer uploads.
+func handleMetadataImportCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	importFlags := flag.NewFlagSet("metadata import", flag.ExitOnError)
+	bucketName := importFlags.String("b", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	importFlags.StringVar(bucketName, "bucket", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	prefix := importFlags.String("p", "", "Only import objects below this key prefix (optional)")
+	importFlags.StringVar(prefix, "prefix", "", "Only import objects below this key prefix (optional)")
+	dryRun := importFlags.Bool("dry-run", false, "Show the changes without making them (optional)")
+	force := importFlags.Bool("force", false, "Also update objects whose content changed since the export (optional)")
+	args := parseInterspersed(importFlags, os.Args[3:])
+
+	if *bucketName == "" {
+		utils.ExitWithError("Bucket name not specified. Use -b or --bucket flag, or set DefaultBucket in config.")
+	}
+	if len(args) != 1 {
+		utils.ExitWithError("Metadata file not specified. Usage: go-cfr2 metadata import <file> [flags]")
+	}
+
+	if *dryRun {
+		fmt.Printf("Checking metadata from '%s' against bucket '%s' (dry run)...\n", args[0], *bucketName)
+	} else {
+		fmt.Printf("Importing metadata from '%s' to bucket '%s'...\n", args[0], *bucketName)
+	}
+	opts := r2.MetadataRestoreOptions{DryRun: *dryRun, RequireETag: !*force}
+	replayMetadata(ctx, client, cfg, *bucketName, *prefix, args[0], opts, "updated")
+}
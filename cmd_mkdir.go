@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/baowuhe/go-cfr2/config"
+	"github.com/baowuhe/go-cfr2/r2"
+	"github.com/baowuhe/go-cfr2/utils"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func handleMkdirCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	mkdirFlags := flag.NewFlagSet("mkdir", flag.ExitOnError)
+	bucketName := mkdirFlags.String("b", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	mkdirFlags.StringVar(bucketName, "bucket", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	objectKey := mkdirFlags.String("k", "", "Specify the directory key, a trailing slash is added if missing (required)")
+	mkdirFlags.StringVar(objectKey, "key", "", "Specify the directory key, a trailing slash is added if missing (required)")
+	parseFlags(mkdirFlags, os.Args[2:])
+
+	if *bucketName == "" {
+		utils.ExitWithError("Bucket name not specified. Use -b or --bucket flag, or set DefaultBucket in config.")
+	}
+	if *objectKey == "" {
+		utils.ExitWithError("Directory key not specified. Use -k or --key flag.")
+	}
+
+	refuseAppendOnlyOverwrite(ctx, client, cfg, *bucketName, strings.TrimSuffix(*objectKey, "/")+"/")
+
+	fmt.Printf("Creating directory marker '%s' in bucket '%s'...\n", *objectKey, *bucketName)
+	err := r2.CreateDirectoryMarker(ctx, client, *bucketName, *objectKey)
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to create directory marker '%s': %v", *objectKey, err))
+	}
+	fmt.Printf("Successfully created directory marker '%s'.\n", *objectKey)
+}
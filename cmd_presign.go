@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/baowuhe/go-cfr2/config"
+	"github.com/baowuhe/go-cfr2/r2"
+	"github.com/baowuhe/go-cfr2/utils"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// defaultPresignPartSize is the part size of 'presign multipart --size' without --parts or
+// --part-size, the part size of uploads of unknown length.
+const defaultPresignPartSize = 16 * 1024 * 1024
+
+// handlePresignMultipartCommand starts a multipart upload and prints presigned URLs for its parts
+// and for completing or aborting it, so a client without credentials can upload a huge file.
+func handlePresignMultipartCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	multipartFlags := flag.NewFlagSet("presign multipart", flag.ExitOnError)
+	bucketName := multipartFlags.String("b", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	multipartFlags.StringVar(bucketName, "bucket", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	objectKey := multipartFlags.String("k", "", "Specify the object key (required)")
+	multipartFlags.StringVar(objectKey, "key", "", "Specify the object key (required)")
+	expiryHours := multipartFlags.Int64("e", 24, "Specify the URL expiry time in hours (optional)")
+	multipartFlags.Int64Var(expiryHours, "expiry", 24, "Specify the URL expiry time in hours (optional)")
+	parts := multipartFlags.Int("parts", 0, "Specify the number of parts (optional)")
+	partSizeArg := multipartFlags.String("part-size", "", "Specify the size of every part but the last, e.g. 64MB (optional)")
+	sizeArg := multipartFlags.String("size", "", "Specify the size of the file, which sets the number of parts (optional)")
+	contentType := multipartFlags.String("content-type", "", "Specify the Content-Type of the object (optional)")
+	curl := multipartFlags.Bool("curl", false, "Print a shell script uploading $FILE with curl (optional)")
+	parseFlags(multipartFlags, os.Args[3:])
+
+	if *bucketName == "" {
+		utils.ExitWithError("Bucket name not specified. Use -b or --bucket flag, or set DefaultBucket in config.")
+	}
+	if *objectKey == "" {
+		utils.ExitWithError("Object key not specified. Use -k or --key flag.")
+	}
+	if isKeyGlob(*objectKey) {
+		utils.ExitWithError("presign multipart presigns a single key and cannot be used with a glob.")
+	}
+	expiry, err := presignExpiry(*expiryHours)
+	if err != nil {
+		utils.ExitWithError(err.Error())
+	}
+	var size, partSize int64
+	if *sizeArg != "" {
+		if size, err = utils.ParseSize(*sizeArg); err != nil || size <= 0 {
+			utils.ExitWithError(fmt.Sprintf("Invalid --size value '%s'. Use a size such as 1073741824 or 1GB.", *sizeArg))
+		}
+	}
+	if *partSizeArg != "" {
+		if partSize, err = utils.ParseSize(*partSizeArg); err != nil || partSize <= 0 {
+			utils.ExitWithError(fmt.Sprintf("Invalid --part-size value '%s'. Use a size such as 67108864 or 64MB.", *partSizeArg))
+		}
+	}
+	n, partSize, err := planPresignedParts(*parts, partSize, size)
+	if err != nil {
+		utils.ExitWithError(err.Error())
+	}
+	if *curl && partSize == 0 {
+		utils.ExitWithError("--curl needs the part size. Use --part-size, or --size together with --parts.")
+	}
+
+	fmt.Printf("Starting multipart upload of '%s' to bucket '%s' in %d part(s) with %s expiry...\n", *objectKey, *bucketName, n, expiry)
+	upload, err := r2.PresignMultipartUpload(ctx, client, *bucketName, *objectKey, n, expiry, *contentType)
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to presign multipart upload of '%s': %v", *objectKey, err))
+	}
+
+	fmt.Printf("Upload ID: %s\n", upload.UploadID)
+	if partSize > 0 {
+		fmt.Printf("Part size: %d bytes, the last part holds the rest\n", partSize)
+	}
+	if *curl {
+		fmt.Println()
+		fmt.Print(presignedUploadScript(upload, partSize))
+		return
+	}
+	for i, url := range upload.PartURLs {
+		fmt.Printf("Part %d URL (PUT): %s\n", i+1, url)
+	}
+	fmt.Printf("Complete URL (POST): %s\n", upload.CompleteURL)
+	fmt.Printf("Abort URL (DELETE): %s\n", upload.AbortURL)
+	fmt.Println("Upload every part with a PUT request and keep the ETag header of its response, then complete the upload with a POST of:")
+	fmt.Print(completeMultipartBody(len(upload.PartURLs), func(part int) string { return fmt.Sprintf("ETAG_OF_PART_%d", part) }))
+	fmt.Println("Note: until it is completed or aborted, the upload's parts are stored and billed but do not show up in listings.")
+}
+
+// planPresignedParts returns the number of parts and, if known, the part size of a presigned
+// multipart upload given at least two of its number of parts, part size and file size.
+func planPresignedParts(parts int, partSize, size int64) (int, int64, error) {
+	switch {
+	case parts > 0 && partSize > 0 && size > 0:
+		return 0, 0, fmt.Errorf("--parts, --part-size and --size cannot all be used; give two of them, or --size alone")
+	case parts > 0 && size > 0:
+		partSize = (size + int64(parts) - 1) / int64(parts)
+		if int64(parts-1)*partSize >= size {
+			return 0, 0, fmt.Errorf("%d parts are too many for %d bytes", parts, size)
+		}
+	case size > 0:
+		if partSize == 0 {
+			partSize = defaultPresignPartSize
+			for (size+partSize-1)/partSize > r2.MaxUploadParts {
+				partSize *= 2
+			}
+		}
+		parts = int((size + partSize - 1) / partSize)
+	case parts > 0:
+	case partSize > 0:
+		return 0, 0, fmt.Errorf("--part-size needs --parts or --size")
+	default:
+		return 0, 0, fmt.Errorf("number of parts not specified, use --parts, or --size to derive it")
+	}
+
+	if parts > r2.MaxUploadParts {
+		return 0, 0, fmt.Errorf("the upload would have %d parts, more than the limit of %d; use a larger --part-size", parts, r2.MaxUploadParts)
+	}
+	if parts > 1 && partSize > 0 && partSize < r2.MinPartSize {
+		return 0, 0, fmt.Errorf("parts of %d bytes are smaller than the minimum of %d bytes; use fewer parts", partSize, r2.MinPartSize)
+	}
+	if partSize > r2.MaxPartSize {
+		return 0, 0, fmt.Errorf("parts of %d bytes are larger than the maximum of %d bytes; use more parts", partSize, int64(r2.MaxPartSize))
+	}
+	return parts, partSize, nil
+}
+
+// presignExpiry checks the expiry of presigned URLs given in hours, which R2 limits to a week.
+func presignExpiry(hours int64) (time.Duration, error) {
+	if maxHours := int64(r2.MaxPresignExpiry / time.Hour); hours < 1 || hours > maxHours {
+		return 0, fmt.Errorf("Invalid expiry of %d hour(s). Presigned URLs expire after 1 to %d hours.", hours, maxHours)
+	}
+	return time.Duration(hours) * time.Hour, nil
+}
+
+// completeMultipartBody returns the CompleteMultipartUpload XML document listing parts with the
+// ETags returned by etag.
+func completeMultipartBody(parts int, etag func(part int) string) string {
+	var b strings.Builder
+	b.WriteString("<CompleteMultipartUpload>\n")
+	for part := 1; part <= parts; part++ {
+		fmt.Fprintf(&b, "  <Part><PartNumber>%d</PartNumber><ETag>%s</ETag></Part>\n", part, etag(part))
+	}
+	b.WriteString("</CompleteMultipartUpload>\n")
+	return b.String()
+}
+
+// presignedUploadScript returns a shell script uploading $FILE with curl through the presigned
+// URLs of upload, collecting the ETag of every part for the complete request.
+func presignedUploadScript(upload *r2.PresignedMultipartUpload, partSize int64) string {
+	var b strings.Builder
+	b.WriteString("#!/bin/sh\n")
+	b.WriteString("set -e\n")
+	b.WriteString(": \"${FILE:?set FILE to the file to upload}\"\n\n")
+	b.WriteString("# Uploads part $1, counted from 0, of $FILE to URL $2 and prints the ETag R2 returns.\n")
+	b.WriteString("part() {\n")
+	fmt.Fprintf(&b, "\tdd if=\"$FILE\" bs=%d skip=\"$1\" count=1 2>/dev/null |\n", partSize)
+	b.WriteString("\t\tcurl -fsS -X PUT --data-binary @- -D - -o /dev/null \"$2\" |\n")
+	b.WriteString("\t\ttr -d '\\r' | sed -n 's/^[Ee][Tt][Aa][Gg]: *//p'\n")
+	b.WriteString("}\n\n")
+	for i, url := range upload.PartURLs {
+		fmt.Fprintf(&b, "etag%d=$(part %d %s)\n", i+1, i, shellQuote(url))
+	}
+	b.WriteString("\ncurl -fsS -X POST -H 'Content-Type: application/xml' --data-binary \"")
+	body := completeMultipartBody(len(upload.PartURLs), func(part int) string { return fmt.Sprintf("$etag%d", part) })
+	b.WriteString(strings.NewReplacer("\n  ", "", "\n", "").Replace(body))
+	fmt.Fprintf(&b, "\" %s\n", shellQuote(upload.CompleteURL))
+	b.WriteString("echo\n\n")
+	fmt.Fprintf(&b, "# To give up instead: curl -fsS -X DELETE %s\n", shellQuote(upload.AbortURL))
+	return b.String()
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/baowuhe/go-cfr2/r2"
+)
+
+func TestPresignExpiry(t *testing.T) {
+	for _, hours := range []int64{-1, 0, 169} {
+		if _, err := presignExpiry(hours); err == nil {
+			t.Errorf("presignExpiry(%d) accepted an expiry R2 refuses", hours)
+		}
+	}
+	for _, hours := range []int64{1, 24, 168} {
+		if expiry, err := presignExpiry(hours); err != nil || expiry != time.Duration(hours)*time.Hour {
+			t.Errorf("presignExpiry(%d) = %v, %v", hours, expiry, err)
+		}
+	}
+}
+
+func TestPlanPresignedParts(t *testing.T) {
+	tests := []struct {
+		name         string
+		parts        int
+		partSize     int64
+		size         int64
+		wantParts    int
+		wantPartSize int64
+		wantErr      bool
+	}{
+		{"parts only", 3, 0, 0, 3, 0, false},
+		{"parts and size", 4, 0, 100 << 20, 4, 25 << 20, false},
+		{"size alone", 0, 0, 40 << 20, 3, defaultPresignPartSize, false},
+		{"size and part size", 0, 10 << 20, 25 << 20, 3, 10 << 20, false},
+		{"size alone doubles the part size", 0, 0, int64(r2.MaxUploadParts+1) * defaultPresignPartSize, 5001, 2 * defaultPresignPartSize, false},
+		{"all three", 2, 10 << 20, 20 << 20, 0, 0, true},
+		{"part size alone", 0, 10 << 20, 0, 0, 0, true},
+		{"nothing", 0, 0, 0, 0, 0, true},
+		{"too many parts for the size", 10, 0, 5, 0, 0, true},
+		{"more than the part limit", r2.MaxUploadParts + 1, 0, 0, 0, 0, true},
+		{"parts below the minimum size", 2, 0, 2 << 20, 0, 0, true},
+		{"single small part", 1, 0, 1 << 20, 1, 1 << 20, false},
+		{"part size above the maximum", 0, 6 << 30, 20 << 30, 0, 0, true},
+		{"parts above the maximum size", 2, 0, 12 << 30, 0, 0, true},
+		{"single part above the maximum size", 1, 0, 6 << 30, 0, 0, true},
+		{"parts at the maximum size", 2, 0, 10 << 30, 2, 5 << 30, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parts, partSize, err := planPresignedParts(tt.parts, tt.partSize, tt.size)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("planPresignedParts() error = %v, want error %v", err, tt.wantErr)
+			}
+			if parts != tt.wantParts || partSize != tt.wantPartSize {
+				t.Fatalf("planPresignedParts() = %d, %d, want %d, %d", parts, partSize, tt.wantParts, tt.wantPartSize)
+			}
+		})
+	}
+}
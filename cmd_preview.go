@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/baowuhe/go-cfr2/config"
+	"github.com/baowuhe/go-cfr2/r2"
+	"github.com/baowuhe/go-cfr2/utils"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// previewSniffBytes is how much of an object is fetched to decide how to preview it.
+const previewSniffBytes = 64 * 1024
+
+func handlePreviewCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	previewFlags := flag.NewFlagSet("preview", flag.ExitOnError)
+	bucketName := previewFlags.String("b", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	previewFlags.StringVar(bucketName, "bucket", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	objectKey := previewFlags.String("k", "", "Specify the object key to preview (required)")
+	previewFlags.StringVar(objectKey, "key", "", "Specify the object key to preview (required)")
+	lines := previewFlags.Int("n", 20, "Specify the number of lines shown for text objects (optional)")
+	previewFlags.IntVar(lines, "lines", 20, "Specify the number of lines shown for text objects (optional)")
+	width := previewFlags.Int("width", 480, "Specify the image preview width in pixels (optional)")
+	protocol := previewFlags.String("protocol", "auto", "Specify the terminal graphics protocol: auto, kitty, iterm2, sixel or blocks (optional)")
+	maxBytes := previewFlags.Int64("max-bytes", 20*1024*1024, "Refuse to preview images larger than this many bytes (optional)")
+	parseFlags(previewFlags, os.Args[2:])
+
+	if *bucketName == "" {
+		utils.ExitWithError("Bucket name not specified. Use -b or --bucket flag, or set DefaultBucket in config.")
+	}
+	if *objectKey == "" {
+		utils.ExitWithError("Object key not specified. Use -k or --key flag.")
+	}
+	if *protocol == "auto" {
+		*protocol = detectGraphicsProtocol()
+	}
+	switch *protocol {
+	case "kitty", "iterm2", "sixel", "blocks":
+	default:
+		utils.ExitWithError(fmt.Sprintf("Unknown protocol '%s'. Use auto, kitty, iterm2, sixel or blocks.", *protocol))
+	}
+
+	head, err := readObjectHead(ctx, client, *bucketName, *objectKey, previewSniffBytes)
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to preview object '%s': %v", *objectKey, err))
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(*objectKey))
+	if contentType == "" {
+		contentType = http.DetectContentType(head)
+	}
+
+	switch {
+	case strings.HasPrefix(contentType, "image/"):
+		err = previewImage(ctx, client, *bucketName, *objectKey, *protocol, *width, *maxBytes)
+	case isText(head):
+		err = previewText(head, *lines)
+	default:
+		fmt.Printf("'%s' is a binary object (%s), no preview available.\n", *objectKey, contentType)
+	}
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to preview object '%s': %v", *objectKey, err))
+	}
+}
+
+// readObjectHead fetches at most n leading bytes of an object with a ranged GET.
+func readObjectHead(ctx context.Context, client *s3.Client, bucketName, objectKey string, n int64) ([]byte, error) {
+	body, err := r2.OpenObjectRange(ctx, client, bucketName, objectKey, fmt.Sprintf("bytes=0-%d", n-1))
+	if err != nil {
+		// Empty objects cannot satisfy any range, fall back to a plain GET.
+		body, err = r2.OpenObject(ctx, client, bucketName, objectKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+	defer body.Close()
+
+	return io.ReadAll(io.LimitReader(body, n))
+}
+
+// isText reports whether the data looks like UTF-8 text. A trailing partial rune is tolerated
+// because the data may have been cut off by a ranged read.
+func isText(data []byte) bool {
+	if bytes.IndexByte(data, 0) >= 0 {
+		return false
+	}
+	for len(data) > 0 {
+		r, size := utf8.DecodeRune(data)
+		if r == utf8.RuneError && size == 1 {
+			return len(data) < utf8.UTFMax
+		}
+		data = data[size:]
+	}
+	return true
+}
+
+func previewText(data []byte, lines int) error {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, len(data)+1), len(data)+1)
+	for i := 0; i < lines && scanner.Scan(); i++ {
+		if _, err := fmt.Println(scanner.Text()); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func previewImage(ctx context.Context, client *s3.Client, bucketName, objectKey, protocol string, width int, maxBytes int64) error {
+	body, err := r2.OpenObject(ctx, client, bucketName, objectKey)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(body, maxBytes+1))
+	if err != nil {
+		return fmt.Errorf("failed to read image: %w", err)
+	}
+	if int64(len(data)) > maxBytes {
+		return fmt.Errorf("image is larger than %d bytes, raise --max-bytes to preview it", maxBytes)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	if protocol == "blocks" {
+		// Each character cell shows one pixel column and two pixel rows.
+		renderBlocks(os.Stdout, scaleImage(img, width/8))
+		return nil
+	}
+
+	scaled := scaleImage(img, width)
+	switch protocol {
+	case "sixel":
+		return renderSixel(os.Stdout, scaled)
+	default:
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, scaled); err != nil {
+			return fmt.Errorf("failed to encode preview: %w", err)
+		}
+		if protocol == "kitty" {
+			renderKitty(os.Stdout, buf.Bytes())
+		} else {
+			renderITerm2(os.Stdout, buf.Bytes())
+		}
+		return nil
+	}
+}
+
+// detectGraphicsProtocol guesses the best graphics protocol from the terminal environment.
+func detectGraphicsProtocol() string {
+	term := os.Getenv("TERM")
+	termProgram := os.Getenv("TERM_PROGRAM")
+	switch {
+	case os.Getenv("KITTY_WINDOW_ID") != "" || term == "xterm-kitty" || termProgram == "ghostty":
+		return "kitty"
+	case termProgram == "iTerm.app" || termProgram == "WezTerm" || os.Getenv("LC_TERMINAL") == "iTerm2":
+		return "iterm2"
+	case strings.Contains(term, "sixel") || termProgram == "mlterm" || strings.HasPrefix(term, "foot"):
+		return "sixel"
+	default:
+		return "blocks"
+	}
+}
+
+// scaleImage downscales an image to the given width with nearest-neighbour sampling,
+// keeping its aspect ratio. Images that are already narrow enough are returned unchanged.
+func scaleImage(img image.Image, width int) image.Image {
+	bounds := img.Bounds()
+	if width <= 0 || bounds.Dx() <= width {
+		return img
+	}
+	height := bounds.Dy() * width / bounds.Dx()
+	if height < 1 {
+		height = 1
+	}
+
+	scaled := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		srcY := bounds.Min.Y + y*bounds.Dy()/height
+		for x := 0; x < width; x++ {
+			srcX := bounds.Min.X + x*bounds.Dx()/width
+			scaled.Set(x, y, img.At(srcX, srcY))
+		}
+	}
+	return scaled
+}
+
+// renderKitty writes a PNG using the kitty graphics protocol, in chunks of at most 4096 base64 bytes.
+func renderKitty(w io.Writer, pngData []byte) {
+	encoded := base64.StdEncoding.EncodeToString(pngData)
+	first := true
+	for len(encoded) > 0 {
+		chunk := encoded
+		if len(chunk) > 4096 {
+			chunk = chunk[:4096]
+		}
+		encoded = encoded[len(chunk):]
+
+		more := 0
+		if len(encoded) > 0 {
+			more = 1
+		}
+		if first {
+			fmt.Fprintf(w, "\x1b_Ga=T,f=100,m=%d;%s\x1b\\", more, chunk)
+			first = false
+		} else {
+			fmt.Fprintf(w, "\x1b_Gm=%d;%s\x1b\\", more, chunk)
+		}
+	}
+	fmt.Fprintln(w)
+}
+
+// renderITerm2 writes a PNG using the iTerm2 inline image protocol.
+func renderITerm2(w io.Writer, pngData []byte) {
+	fmt.Fprintf(w, "\x1b]1337;File=inline=1;size=%d:%s\a\n", len(pngData), base64.StdEncoding.EncodeToString(pngData))
+}
+
+// renderSixel writes an image as sixel graphics using a fixed 6x6x6 colour cube palette.
+func renderSixel(w io.Writer, img image.Image) error {
+	bounds := img.Bounds()
+	out := bufio.NewWriter(w)
+
+	fmt.Fprintf(out, "\x1bPq\"1;1;%d;%d", bounds.Dx(), bounds.Dy())
+	for i := 0; i < 216; i++ {
+		r, g, b := i/36, (i/6)%6, i%6
+		fmt.Fprintf(out, "#%d;2;%d;%d;%d", i, r*20, g*20, b*20)
+	}
+
+	paletteIndex := func(x, y int) int {
+		r, g, b, _ := img.At(x, y).RGBA()
+		return int(r*5/0xffff)*36 + int(g*5/0xffff)*6 + int(b*5/0xffff)
+	}
+
+	for bandY := bounds.Min.Y; bandY < bounds.Max.Y; bandY += 6 {
+		// Collect, per colour, the sixel bits of every column in this band.
+		bands := map[int][]byte{}
+		var order []int
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			for bit := 0; bit < 6 && bandY+bit < bounds.Max.Y; bit++ {
+				idx := paletteIndex(x, bandY+bit)
+				row, ok := bands[idx]
+				if !ok {
+					row = make([]byte, bounds.Dx())
+					bands[idx] = row
+					order = append(order, idx)
+				}
+				row[x-bounds.Min.X] |= 1 << bit
+			}
+		}
+
+		for i, idx := range order {
+			if i > 0 {
+				out.WriteByte('$')
+			}
+			fmt.Fprintf(out, "#%d", idx)
+			writeSixelRun(out, bands[idx])
+		}
+		out.WriteByte('-')
+	}
+
+	out.WriteString("\x1b\\\n")
+	return out.Flush()
+}
+
+// writeSixelRun writes one colour row of sixels, run-length encoding repeated characters.
+func writeSixelRun(out *bufio.Writer, row []byte) {
+	for i := 0; i < len(row); {
+		j := i
+		for j < len(row) && row[j] == row[i] {
+			j++
+		}
+		char := byte(63 + row[i])
+		if count := j - i; count > 3 {
+			fmt.Fprintf(out, "!%d%c", count, char)
+		} else {
+			for k := 0; k < count; k++ {
+				out.WriteByte(char)
+			}
+		}
+		i = j
+	}
+}
+
+// renderBlocks draws an image with Unicode upper half blocks and 24-bit colour escapes,
+// which works in any truecolour terminal without a graphics protocol.
+func renderBlocks(w io.Writer, img image.Image) {
+	bounds := img.Bounds()
+	out := bufio.NewWriter(w)
+	defer out.Flush()
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += 2 {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			tr, tg, tb, _ := img.At(x, y).RGBA()
+			br, bg, bb := tr, tg, tb
+			if y+1 < bounds.Max.Y {
+				br, bg, bb, _ = img.At(x, y+1).RGBA()
+			}
+			fmt.Fprintf(out, "\x1b[38;2;%d;%d;%dm\x1b[48;2;%d;%d;%dm▀", tr>>8, tg>>8, tb>>8, br>>8, bg>>8, bb>>8)
+		}
+		out.WriteString("\x1b[0m\n")
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/baowuhe/go-cfr2/config"
+	"github.com/baowuhe/go-cfr2/r2"
+	"github.com/baowuhe/go-cfr2/utils"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func handleProtectCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	protectFlags := flag.NewFlagSet("protect", flag.ExitOnError)
+	bucketName := protectFlags.String("b", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	protectFlags.StringVar(bucketName, "bucket", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	objectKey := protectFlags.String("k", "", "Specify the object key to protect (required)")
+	protectFlags.StringVar(objectKey, "key", "", "Specify the object key to protect (required)")
+	parseFlags(protectFlags, os.Args[2:])
+
+	if *bucketName == "" {
+		utils.ExitWithError("Bucket name not specified. Use -b or --bucket flag, or set DefaultBucket in config.")
+	}
+	if *objectKey == "" {
+		utils.ExitWithError("Object key not specified. Use -k or --key flag.")
+	}
+
+	refuseAppendOnly(cfg, *objectKey, "modify")
+
+	fmt.Printf("Protecting '%s' in bucket '%s'...\n", *objectKey, *bucketName)
+	err := r2.ProtectObject(ctx, client, *bucketName, *objectKey)
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to protect object '%s': %v", *objectKey, err))
+	}
+	fmt.Printf("Successfully protected '%s'.\n", *objectKey)
+}
+
+func handleUnprotectCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	unprotectFlags := flag.NewFlagSet("unprotect", flag.ExitOnError)
+	bucketName := unprotectFlags.String("b", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	unprotectFlags.StringVar(bucketName, "bucket", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	objectKey := unprotectFlags.String("k", "", "Specify the object key to unprotect (required)")
+	unprotectFlags.StringVar(objectKey, "key", "", "Specify the object key to unprotect (required)")
+	parseFlags(unprotectFlags, os.Args[2:])
+
+	if *bucketName == "" {
+		utils.ExitWithError("Bucket name not specified. Use -b or --bucket flag, or set DefaultBucket in config.")
+	}
+	if *objectKey == "" {
+		utils.ExitWithError("Object key not specified. Use -k or --key flag.")
+	}
+
+	refuseAppendOnly(cfg, *objectKey, "modify")
+
+	fmt.Printf("Removing protection from '%s' in bucket '%s'...\n", *objectKey, *bucketName)
+	err := r2.UnprotectObject(ctx, client, *bucketName, *objectKey)
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to unprotect object '%s': %v", *objectKey, err))
+	}
+	fmt.Printf("Successfully removed protection from '%s'.\n", *objectKey)
+}
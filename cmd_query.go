@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/baowuhe/go-cfr2/config"
+	"github.com/baowuhe/go-cfr2/r2"
+	"github.com/baowuhe/go-cfr2/utils"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// queryOperators lists the supported comparison operators, two-character operators first
+// so that '>=' is not mistaken for '>'.
+var queryOperators = []string{"==", "!=", ">=", "<=", "~=", ">", "<"}
+
+// queryFilter is a single 'field op value' condition from a --where flag.
+type queryFilter struct {
+	field string
+	op    string
+	value string
+	regex *regexp.Regexp
+}
+
+func parseQueryFilter(expr string) (queryFilter, error) {
+	for i := 0; i < len(expr); i++ {
+		for _, op := range queryOperators {
+			if strings.HasPrefix(expr[i:], op) {
+				filter := queryFilter{
+					field: strings.TrimSpace(expr[:i]),
+					op:    op,
+					value: strings.Trim(strings.TrimSpace(expr[i+len(op):]), `"'`),
+				}
+				if filter.field == "" {
+					return queryFilter{}, fmt.Errorf("missing field name in '%s'", expr)
+				}
+				if op == "~=" {
+					re, err := regexp.Compile(filter.value)
+					if err != nil {
+						return queryFilter{}, fmt.Errorf("invalid regular expression in '%s': %w", expr, err)
+					}
+					filter.regex = re
+				}
+				return filter, nil
+			}
+		}
+	}
+	return queryFilter{}, fmt.Errorf("no operator found in '%s' (use one of %s)", expr, strings.Join(queryOperators, " "))
+}
+
+// match reports whether a field value satisfies the filter. Values that both parse as numbers are
+// compared numerically, everything else is compared as strings.
+func (f queryFilter) match(value string, present bool) bool {
+	if !present {
+		return false
+	}
+	if f.op == "~=" {
+		return f.regex.MatchString(value)
+	}
+
+	cmp := strings.Compare(value, f.value)
+	if a, err := strconv.ParseFloat(value, 64); err == nil {
+		if b, err := strconv.ParseFloat(f.value, 64); err == nil {
+			switch {
+			case a < b:
+				cmp = -1
+			case a > b:
+				cmp = 1
+			default:
+				cmp = 0
+			}
+		}
+	}
+
+	switch f.op {
+	case "==":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case ">":
+		return cmp > 0
+	case "<":
+		return cmp < 0
+	case ">=":
+		return cmp >= 0
+	case "<=":
+		return cmp <= 0
+	}
+	return false
+}
+
+func handleQueryCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	queryFlags := flag.NewFlagSet("query", flag.ExitOnError)
+	bucketName := queryFlags.String("b", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	queryFlags.StringVar(bucketName, "bucket", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	objectKey := queryFlags.String("k", "", "Specify the object key to query (required)")
+	queryFlags.StringVar(objectKey, "key", "", "Specify the object key to query (required)")
+	var whereExprs stringListFlag
+	queryFlags.Var(&whereExprs, "w", "Specify a field filter such as 'status==500' (repeatable)")
+	queryFlags.Var(&whereExprs, "where", "Specify a field filter such as 'status==500' (repeatable)")
+	limit := queryFlags.Int("limit", 0, "Stop after this many matching records (optional)")
+	format := queryFlags.String("format", "", "Specify the record format: jsonl or csv (optional, detected from the key)")
+	parseFlags(queryFlags, os.Args[2:])
+
+	if *bucketName == "" {
+		utils.ExitWithError("Bucket name not specified. Use -b or --bucket flag, or set DefaultBucket in config.")
+	}
+	if *objectKey == "" {
+		utils.ExitWithError("Object key not specified. Use -k or --key flag.")
+	}
+
+	var filters []queryFilter
+	for _, expr := range whereExprs {
+		filter, err := parseQueryFilter(expr)
+		if err != nil {
+			utils.ExitWithError(fmt.Sprintf("Invalid --where filter: %v", err))
+		}
+		filters = append(filters, filter)
+	}
+
+	if *format == "" {
+		*format = "jsonl"
+		if strings.HasSuffix(utils.TrimCompressionExt(*objectKey), ".csv") {
+			*format = "csv"
+		}
+	}
+
+	body, err := r2.OpenObject(ctx, client, *bucketName, *objectKey)
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to query object '%s': %v", *objectKey, err))
+	}
+	defer body.Close()
+
+	reader, err := utils.NewDecompressReader(body, *objectKey)
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to query object '%s': %v", *objectKey, err))
+	}
+	defer reader.Close()
+
+	switch *format {
+	case "jsonl":
+		err = queryJSONLines(reader, os.Stdout, filters, *limit)
+	case "csv":
+		err = queryCSV(reader, os.Stdout, filters, *limit)
+	default:
+		utils.ExitWithError(fmt.Sprintf("Unknown record format '%s'. Use jsonl or csv.", *format))
+	}
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to query object '%s': %v", *objectKey, err))
+	}
+}
+
+// queryJSONLines prints every JSON line whose fields match all filters. Nested fields are addressed
+// with dots, e.g. 'request.method==GET'.
+func queryJSONLines(r io.Reader, w io.Writer, filters []queryFilter, limit int) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	matched := 0
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		var record map[string]any
+		decoder := json.NewDecoder(bytes.NewReader(line))
+		decoder.UseNumber()
+		if err := decoder.Decode(&record); err != nil {
+			continue
+		}
+
+		ok := true
+		for _, filter := range filters {
+			value, present := jsonField(record, filter.field)
+			if !filter.match(value, present) {
+				ok = false
+				break
+			}
+		}
+		if !ok {
+			continue
+		}
+
+		if _, err := fmt.Fprintf(w, "%s\n", line); err != nil {
+			return err
+		}
+		matched++
+		if limit > 0 && matched >= limit {
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+// jsonField looks up a dotted field path in a decoded JSON object and returns it as a string.
+func jsonField(record map[string]any, field string) (string, bool) {
+	var current any = record
+	for _, part := range strings.Split(field, ".") {
+		obj, ok := current.(map[string]any)
+		if !ok {
+			return "", false
+		}
+		current, ok = obj[part]
+		if !ok {
+			return "", false
+		}
+	}
+
+	switch v := current.(type) {
+	case string:
+		return v, true
+	case nil:
+		return "null", true
+	case json.Number, bool:
+		return fmt.Sprint(v), true
+	default:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return "", false
+		}
+		return string(encoded), true
+	}
+}
+
+// queryCSV prints the header and every CSV row whose columns match all filters.
+func queryCSV(r io.Reader, w io.Writer, filters []queryFilter, limit int) error {
+	csvReader := csv.NewReader(r)
+	csvReader.FieldsPerRecord = -1
+	header, err := csvReader.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read CSV header: %w", err)
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	for _, filter := range filters {
+		if _, ok := columns[filter.field]; !ok {
+			return fmt.Errorf("column '%s' not found in CSV header", filter.field)
+		}
+	}
+
+	csvWriter := csv.NewWriter(w)
+	defer csvWriter.Flush()
+	if err := csvWriter.Write(header); err != nil {
+		return err
+	}
+
+	matched := 0
+	for {
+		row, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read CSV row: %w", err)
+		}
+
+		ok := true
+		for _, filter := range filters {
+			idx := columns[filter.field]
+			if !filter.match(valueAt(row, idx), idx < len(row)) {
+				ok = false
+				break
+			}
+		}
+		if !ok {
+			continue
+		}
+
+		if err := csvWriter.Write(row); err != nil {
+			return err
+		}
+		matched++
+		if limit > 0 && matched >= limit {
+			break
+		}
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+func valueAt(row []string, idx int) string {
+	if idx < len(row) {
+		return row[idx]
+	}
+	return ""
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/baowuhe/go-cfr2/config"
+	"github.com/baowuhe/go-cfr2/r2"
+	"github.com/baowuhe/go-cfr2/utils"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"golang.org/x/text/unicode/norm"
+)
+
+// keyTransforms are the transforms rekey applies to the part of a key below the prefix.
+var keyTransforms = map[string]func(string) string{
+	"lower":                 strings.ToLower,
+	"upper":                 strings.ToUpper,
+	"nfc":                   norm.NFC.String,
+	"spaces-to-dashes":      func(s string) string { return strings.ReplaceAll(s, " ", "-") },
+	"spaces-to-underscores": func(s string) string { return strings.ReplaceAll(s, " ", "_") },
+	"trim": func(s string) string {
+		elems := strings.Split(s, "/")
+		for i, elem := range elems {
+			elems[i] = strings.TrimSpace(elem)
+		}
+		return strings.Join(elems, "/")
+	},
+}
+
+// parseKeyTransforms parses a comma-separated list of transform names into one function applying
+// them in order.
+func parseKeyTransforms(list string) (func(string) string, error) {
+	var fns []func(string) string
+	for _, name := range strings.Split(list, ",") {
+		fn, ok := keyTransforms[strings.TrimSpace(name)]
+		if !ok {
+			names := make([]string, 0, len(keyTransforms))
+			for name := range keyTransforms {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return nil, fmt.Errorf("unknown transform '%s', use %s", name, strings.Join(names, ", "))
+		}
+		fns = append(fns, fn)
+	}
+	return func(s string) string {
+		for _, fn := range fns {
+			s = fn(s)
+		}
+		return s
+	}, nil
+}
+
+// handleRekeyCommand renames objects by applying transforms to the keys below a prefix, to clean
+// up names imported from other systems, or as listed in a mapping file, to migrate key schemes.
+// With --rollback, the inverse of every completed rename is recorded in a mapping file that
+// undoes the run when passed to --map.
+func handleRekeyCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	rekeyFlags := flag.NewFlagSet("rekey", flag.ExitOnError)
+	bucketName := rekeyFlags.String("b", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	rekeyFlags.StringVar(bucketName, "bucket", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	prefix := rekeyFlags.String("p", "", "Only rename objects below this key prefix, which is kept as it is (optional)")
+	rekeyFlags.StringVar(prefix, "prefix", "", "Only rename objects below this key prefix, which is kept as it is (optional)")
+	transform := rekeyFlags.String("t", "", "Specify the comma-separated transforms to apply, e.g. 'lower,spaces-to-dashes,nfc'")
+	rekeyFlags.StringVar(transform, "transform", "", "Specify the comma-separated transforms to apply, e.g. 'lower,spaces-to-dashes,nfc'")
+	mapPath := rekeyFlags.String("map", "", "Specify a CSV file of old_key,new_key rows to rename instead")
+	rollbackPath := rekeyFlags.String("rollback", "", "Record the completed renames in this CSV file, in reverse (optional, default <map>.rollback.csv with --map)")
+	dryRun := rekeyFlags.Bool("dry-run", false, "Print the renames without renaming (optional)")
+	concurrency := rekeyFlags.Int("c", 8, "Specify the number of objects renamed at once (optional)")
+	rekeyFlags.IntVar(concurrency, "concurrency", 8, "Specify the number of objects renamed at once (optional)")
+	overrideProtection := rekeyFlags.Bool("override-protection", false, "Rename protected objects too (optional)")
+	parseFlags(rekeyFlags, os.Args[2:])
+
+	if *bucketName == "" {
+		utils.ExitWithError("Bucket name not specified. Use -b or --bucket flag, or set DefaultBucket in config.")
+	}
+	if (*transform == "") == (*mapPath == "") {
+		utils.ExitWithError("Specify either transforms with -t or a mapping file with --map.")
+	}
+	if *mapPath != "" && *prefix != "" {
+		utils.ExitWithError("--prefix cannot be used with --map, whose keys are complete.")
+	}
+	if *mapPath != "" && *rollbackPath == "" {
+		*rollbackPath = strings.TrimSuffix(*mapPath, filepath.Ext(*mapPath)) + ".rollback.csv"
+	}
+
+	var renames []r2.KeyRename
+	var skipped int
+	if *mapPath != "" {
+		renames = planMappedRenames(ctx, client, cfg, *bucketName, *mapPath)
+	} else {
+		renames, skipped = planTransformRenames(ctx, client, cfg, *bucketName, *prefix, *transform)
+	}
+
+	if *dryRun {
+		for _, rename := range renames {
+			fmt.Printf("(dry run) rename '%s' to '%s'\n", rename.From, rename.To)
+		}
+		fmt.Printf("Would rename %d object(s), skip %d.\n", len(renames), skipped)
+		return
+	}
+	if len(renames) == 0 {
+		fmt.Printf("Nothing to rename; %d object(s) skipped.\n", skipped)
+		return
+	}
+
+	// The rollback file is created up front, so that a run never starts without one, and written
+	// as renames complete, so that it covers an interrupted run too.
+	var rollback *csv.Writer
+	if *rollbackPath != "" {
+		file, err := os.OpenFile(*rollbackPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if errors.Is(err, os.ErrExist) {
+			utils.ExitWithError(fmt.Sprintf("Rollback file '%s' already exists. Choose another one with --rollback.", *rollbackPath))
+		}
+		if err != nil {
+			utils.ExitWithError(fmt.Sprintf("Failed to create rollback file: %v", err))
+		}
+		defer file.Close()
+		rollback = csv.NewWriter(file)
+		rollback.Write([]string{"old_key", "new_key"})
+		rollback.Flush()
+	}
+
+	fmt.Printf("Renaming %d object(s)...\n", len(renames))
+	start := time.Now()
+	done := 0
+	failed := r2.RenameObjects(ctx, client, *bucketName, renames, *concurrency, *overrideProtection, func(rename r2.KeyRename, err error) {
+		done++
+		if err != nil {
+			fmt.Printf("[%d/%d] Failed: %v\n", done, len(renames), err)
+			return
+		}
+		if rollback != nil {
+			rollback.Write([]string{rename.To, rename.From})
+			rollback.Flush()
+			if err := rollback.Error(); err != nil {
+				fmt.Printf("Note: failed to record '%s' in the rollback file: %v\n", rename.To, err)
+			}
+		}
+		fmt.Printf("[%d/%d] Renamed '%s' to '%s'.\n", done, len(renames), rename.From, rename.To)
+	})
+
+	fmt.Printf("Renamed %d of %d object(s) in %s, %d failed, %d skipped.\n", len(renames)-failed, len(renames), time.Since(start).Round(time.Millisecond), failed, skipped)
+	if rollback != nil {
+		fmt.Printf("Undo with: go-cfr2 rekey -b %s --map %s\n", *bucketName, *rollbackPath)
+	}
+	if failed > 0 {
+		utils.ExitWithError(fmt.Sprintf("Failed to rename %d object(s).", failed))
+	}
+}
+
+// planTransformRenames lists the objects below prefix and returns the renames that the transforms
+// call for, and the number skipped because their new key exists already, or is the new key of
+// another object too.
+func planTransformRenames(ctx context.Context, client *s3.Client, cfg *config.R2Config, bucketName, prefix, transform string) ([]r2.KeyRename, int) {
+	apply, err := parseKeyTransforms(transform)
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Invalid --transform: %v.", err))
+	}
+
+	fmt.Printf("Listing objects below '%s' in bucket '%s'...\n", prefix, bucketName)
+	existing := make(map[string]bool)
+	var candidates []r2.KeyRename
+	err = r2.WalkObjects(ctx, client, bucketName, prefix, func(obj types.Object) error {
+		existing[*obj.Key] = true
+		to := prefix + apply(strings.TrimPrefix(*obj.Key, prefix))
+		if to != *obj.Key {
+			candidates = append(candidates, r2.KeyRename{From: *obj.Key, To: to})
+		}
+		return nil
+	})
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to list objects in bucket '%s': %v", bucketName, err))
+	}
+
+	targets := make(map[string]int)
+	for _, rename := range candidates {
+		targets[rename.To]++
+	}
+	var renames []r2.KeyRename
+	skipped := 0
+	for _, rename := range candidates {
+		reason := ""
+		switch appendOnly, ok := cfg.AppendOnlyPrefix(rename.From); {
+		case ok:
+			reason = fmt.Sprintf("prefix '%s' is append-only", appendOnly)
+		case rename.To == prefix:
+			reason = "its new key would be empty"
+		case existing[rename.To]:
+			reason = fmt.Sprintf("'%s' already exists", rename.To)
+		case targets[rename.To] > 1:
+			reason = fmt.Sprintf("%d objects would become '%s'", targets[rename.To], rename.To)
+		}
+		if reason != "" {
+			fmt.Printf("Skipping '%s': %s.\n", rename.From, reason)
+			skipped++
+			continue
+		}
+		renames = append(renames, rename)
+	}
+	fmt.Printf("%d of %d object(s) already match the transforms.\n", len(existing)-len(candidates), len(existing))
+	return renames, skipped
+}
+
+// planMappedRenames reads a mapping file and checks that every source exists and no target does,
+// that no key appears twice, and that no key is both a source and a target, so that the renames
+// can run in any order. Any problem exits before anything is renamed.
+func planMappedRenames(ctx context.Context, client *s3.Client, cfg *config.R2Config, bucketName, mapPath string) []r2.KeyRename {
+	renames, err := loadRekeyMap(mapPath)
+	if err != nil {
+		utils.ExitWithError(err.Error())
+	}
+
+	var problems []string
+	sources := make(map[string]bool)
+	targets := make(map[string]bool)
+	for _, rename := range renames {
+		if sources[rename.From] {
+			problems = append(problems, fmt.Sprintf("'%s' is renamed twice", rename.From))
+		}
+		if targets[rename.To] {
+			problems = append(problems, fmt.Sprintf("'%s' is the new key of more than one object", rename.To))
+		}
+		sources[rename.From], targets[rename.To] = true, true
+		if appendOnly, ok := cfg.AppendOnlyPrefix(rename.From); ok {
+			problems = append(problems, fmt.Sprintf("'%s' is below append-only prefix '%s'", rename.From, appendOnly))
+		}
+	}
+	for _, rename := range renames {
+		if sources[rename.To] {
+			problems = append(problems, fmt.Sprintf("'%s' is both renamed and the new key of '%s'", rename.To, rename.From))
+		}
+	}
+
+	fmt.Printf("Checking %d rename(s) from '%s' in bucket '%s'...\n", len(renames), mapPath, bucketName)
+	pipeline := r2.NewHeadPipeline(ctx, client, bucketName, 0, func(key string, head *s3.HeadObjectOutput, err error) error {
+		var notFound *types.NotFound
+		exists := !errors.As(err, &notFound)
+		if exists && err != nil {
+			return err
+		}
+		switch {
+		case sources[key] && !exists:
+			problems = append(problems, fmt.Sprintf("'%s' does not exist", key))
+		case targets[key] && !sources[key] && exists:
+			problems = append(problems, fmt.Sprintf("'%s' already exists", key))
+		}
+		return nil
+	})
+	checked := make(map[string]bool)
+	for _, rename := range renames {
+		for _, key := range []string{rename.From, rename.To} {
+			if !checked[key] {
+				checked[key] = true
+				pipeline.Add(key)
+			}
+		}
+	}
+	if err := pipeline.Wait(); err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to check the renames: %v", err))
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		for _, problem := range problems {
+			fmt.Printf("Problem: %s.\n", problem)
+		}
+		utils.ExitWithError(fmt.Sprintf("'%s' has %d problem(s). Nothing was renamed.", mapPath, len(problems)))
+	}
+	return renames
+}
+
+// loadRekeyMap reads a CSV file of old_key,new_key rows. A first row of exactly old_key,new_key is
+// taken as a header.
+func loadRekeyMap(path string) ([]r2.KeyRename, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mapping '%s': %w", path, err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = 2
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse mapping '%s': %w", path, err)
+	}
+	if len(records) > 0 && records[0][0] == "old_key" && records[0][1] == "new_key" {
+		records = records[1:]
+	}
+
+	renames := make([]r2.KeyRename, 0, len(records))
+	for i, record := range records {
+		if record[0] == "" || record[1] == "" {
+			return nil, fmt.Errorf("row %d of mapping '%s' has an empty key", i+1, path)
+		}
+		if record[0] == record[1] {
+			continue
+		}
+		renames = append(renames, r2.KeyRename{From: record[0], To: record[1]})
+	}
+	return renames, nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/baowuhe/go-cfr2/config"
+	"github.com/baowuhe/go-cfr2/r2"
+	"github.com/baowuhe/go-cfr2/utils"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func handleReportCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	if len(os.Args) < 3 {
+		utils.ExitWithError("Report subcommand not specified. Use 'report types'.")
+	}
+
+	switch os.Args[2] {
+	case "types":
+		handleReportTypesCommand(ctx, client, cfg)
+	default:
+		utils.ExitWithError(fmt.Sprintf("Unknown report subcommand '%s'. Use 'report types'.", os.Args[2]))
+	}
+}
+
+// objectTypeGroup aggregates the objects sharing an extension or content type.
+type objectTypeGroup struct {
+	name        string
+	count       int
+	size        int64
+	largestKey  string
+	largestSize int64
+}
+
+func (g *objectTypeGroup) add(key string, size int64) {
+	g.count++
+	g.size += size
+	if g.largestKey == "" || size > g.largestSize {
+		g.largestKey, g.largestSize = key, size
+	}
+}
+
+// handleReportTypesCommand breaks the objects below a prefix down by file extension, from the
+// listing alone, or by content type, which takes a HEAD request per object.
+func handleReportTypesCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	typesFlags := flag.NewFlagSet("report types", flag.ExitOnError)
+	bucketName := typesFlags.String("b", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	typesFlags.StringVar(bucketName, "bucket", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	prefix := typesFlags.String("p", "", "Only count objects below this key prefix (optional)")
+	typesFlags.StringVar(prefix, "prefix", "", "Only count objects below this key prefix (optional)")
+	by := typesFlags.String("by", "extension", "Group objects by 'extension' or 'content-type' (optional)")
+	concurrency := typesFlags.Int("c", r2.DefaultHeadConcurrency, "Specify the number of parallel metadata requests with --by content-type (optional)")
+	typesFlags.IntVar(concurrency, "concurrency", r2.DefaultHeadConcurrency, "Specify the number of parallel metadata requests with --by content-type (optional)")
+	parseFlags(typesFlags, os.Args[3:])
+
+	if *bucketName == "" {
+		utils.ExitWithError("Bucket name not specified. Use -b or --bucket flag, or set DefaultBucket in config.")
+	}
+	if *by != "extension" && *by != "content-type" {
+		utils.ExitWithError(fmt.Sprintf("Invalid --by value '%s'. Use 'extension' or 'content-type'.", *by))
+	}
+
+	groups := make(map[string]*objectTypeGroup)
+	add := func(name, key string, size int64) {
+		if groups[name] == nil {
+			groups[name] = &objectTypeGroup{name: name}
+		}
+		groups[name].add(key, size)
+	}
+
+	fmt.Printf("Counting objects in bucket '%s' prefix '%s' by %s...\n", *bucketName, *prefix, *by)
+	var err error
+	if *by == "extension" {
+		err = r2.WalkObjects(ctx, client, *bucketName, *prefix, func(obj types.Object) error {
+			if !r2.IsDirectoryMarker(obj) {
+				add(keyExtension(*obj.Key), *obj.Key, aws.ToInt64(obj.Size))
+			}
+			return nil
+		})
+	} else {
+		pipeline := r2.NewHeadPipeline(ctx, client, *bucketName, *concurrency, func(key string, head *s3.HeadObjectOutput, err error) error {
+			if err != nil {
+				return err
+			}
+			add(cmp.Or(aws.ToString(head.ContentType), "(none)"), key, aws.ToInt64(head.ContentLength))
+			return nil
+		})
+		err = r2.WalkObjects(ctx, client, *bucketName, *prefix, func(obj types.Object) error {
+			if r2.IsDirectoryMarker(obj) {
+				return nil
+			}
+			return pipeline.Add(*obj.Key)
+		})
+		if waitErr := pipeline.Wait(); err == nil {
+			err = waitErr
+		}
+	}
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to count objects in bucket '%s': %v", *bucketName, err))
+	}
+
+	sorted := make([]*objectTypeGroup, 0, len(groups))
+	var totalCount int
+	var totalSize int64
+	for _, g := range groups {
+		sorted = append(sorted, g)
+		totalCount += g.count
+		totalSize += g.size
+	}
+	slices.SortFunc(sorted, func(a, b *objectTypeGroup) int {
+		return cmp.Or(cmp.Compare(b.size, a.size), cmp.Compare(b.count, a.count), strings.Compare(a.name, b.name))
+	})
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	header := "EXTENSION"
+	if *by == "content-type" {
+		header = "CONTENT-TYPE"
+	}
+	fmt.Fprintf(w, "%s\tOBJECTS\tSIZE\tSHARE\tLARGEST\n", header)
+	for _, g := range sorted {
+		share := 0.0
+		if totalSize > 0 {
+			share = float64(g.size) / float64(totalSize) * 100
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%.1f%%\t%s (%s)\n", g.name, g.count, formatSize(g.size), share, g.largestKey, formatSize(g.largestSize))
+	}
+	w.Flush()
+	fmt.Printf("%d object(s) of %d type(s), %s in total.\n", totalCount, len(groups), formatSize(totalSize))
+}
+
+// keyExtension returns the lower-cased extension of the last element of a key, or "(none)".
+func keyExtension(key string) string {
+	ext := strings.ToLower(path.Ext(path.Base(key)))
+	if ext == "" || ext == "." {
+		return "(none)"
+	}
+	return ext
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/baowuhe/go-cfr2/config"
+	"github.com/baowuhe/go-cfr2/r2"
+	"github.com/baowuhe/go-cfr2/utils"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/pelletier/go-toml/v2"
+)
+
+// restorePlan is the priorities file read by 'restore --plan'.
+type restorePlan struct {
+	Classes []r2.RestoreClass `toml:"class"`
+}
+
+func handleRestoreCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	restoreFlags := flag.NewFlagSet("restore", flag.ExitOnError)
+	bucketName := restoreFlags.String("b", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	restoreFlags.StringVar(bucketName, "bucket", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	prefix := restoreFlags.String("p", "", "Only restore objects below this key prefix (optional)")
+	restoreFlags.StringVar(prefix, "prefix", "", "Only restore objects below this key prefix (optional)")
+	localDir := restoreFlags.String("d", "", "Specify the local directory to restore into (required)")
+	restoreFlags.StringVar(localDir, "dir", "", "Specify the local directory to restore into (required)")
+	planPath := restoreFlags.String("plan", "", "Restore classes of objects in the order and with the concurrency of this TOML file (optional)")
+	concurrency := restoreFlags.Int("c", 8, "Specify the number of parallel downloads for classes without their own (optional)")
+	restoreFlags.IntVar(concurrency, "concurrency", 8, "Specify the number of parallel downloads for classes without their own (optional)")
+	matchFileNames := restoreFlags.Bool("match-file-names", false, "Match plan patterns without a '/' against the file name at any depth (optional)")
+	preservePerms := restoreFlags.Bool("preserve-perms", false, "Restore the mode, owner and extended attributes recorded at upload (optional)")
+	dryRun := restoreFlags.Bool("dry-run", false, "Print what would be downloaded, class by class, without downloading (optional)")
+	parseFlags(restoreFlags, os.Args[2:])
+
+	if *bucketName == "" {
+		utils.ExitWithError("Bucket name not specified. Use -b or --bucket flag, or set DefaultBucket in config.")
+	}
+	if *localDir == "" {
+		utils.ExitWithError("Local directory not specified. Use -d or --dir flag.")
+	}
+	if *concurrency < 1 {
+		utils.ExitWithError("Concurrency must be at least 1.")
+	}
+
+	var plan restorePlan
+	if *planPath != "" {
+		data, err := os.ReadFile(*planPath)
+		if err != nil {
+			utils.ExitWithError(fmt.Sprintf("Failed to read restore plan '%s': %v", *planPath, err))
+		}
+		if err := toml.Unmarshal(data, &plan); err != nil {
+			utils.ExitWithError(fmt.Sprintf("Failed to parse restore plan '%s': %v", *planPath, err))
+		}
+		for i, class := range plan.Classes {
+			if class.Name == "" {
+				plan.Classes[i].Name = fmt.Sprintf("class %d", i+1)
+			}
+			if len(class.Patterns) == 0 {
+				utils.ExitWithError(fmt.Sprintf("Restore plan class '%s' has no patterns.", plan.Classes[i].Name))
+			}
+			if class.Concurrency < 0 {
+				utils.ExitWithError(fmt.Sprintf("Restore plan class '%s' has a negative concurrency.", plan.Classes[i].Name))
+			}
+		}
+	}
+
+	fmt.Printf("Restoring bucket '%s' prefix '%s' to '%s'...\n", *bucketName, *prefix, *localDir)
+	result, err := r2.Restore(ctx, client, r2.RestoreOptions{
+		LocalDir:       *localDir,
+		Bucket:         *bucketName,
+		Prefix:         *prefix,
+		Classes:        plan.Classes,
+		Concurrency:    *concurrency,
+		MatchFileNames: *matchFileNames,
+		PreservePerms:  *preservePerms,
+		DryRun:         *dryRun,
+	})
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Restore finished with errors: %d downloaded, %d failed: %v", result.Downloaded, result.Failed, err))
+	}
+	if *dryRun {
+		return
+	}
+	fmt.Printf("Restore complete: %d downloaded.\n", result.Downloaded)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/baowuhe/go-cfr2/config"
+	"github.com/baowuhe/go-cfr2/r2"
+	"github.com/baowuhe/go-cfr2/utils"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func handleScratchCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	if len(os.Args) < 3 {
+		utils.ExitWithError("Scratch subcommand not specified. Use 'scratch create', 'scratch list' or 'scratch gc'.")
+	}
+
+	switch os.Args[2] {
+	case "create":
+		handleScratchCreateCommand(ctx, client)
+	case "list":
+		handleScratchListCommand(ctx, client, cfg)
+	case "gc":
+		handleScratchGcCommand(ctx, client, cfg)
+	default:
+		utils.ExitWithError(fmt.Sprintf("Unknown scratch subcommand '%s'. Use 'scratch create', 'scratch list' or 'scratch gc'.", os.Args[2]))
+	}
+}
+
+// scratchBucketsFlag adds the --bucket flag naming the buckets searched for scratch prefixes.
+func scratchBucketsFlag(fs *flag.FlagSet, cfg *config.R2Config) *string {
+	buckets := fs.String("b", cfg.DefaultBucket, "Specify the comma-separated buckets to look for scratch prefixes in (optional)")
+	fs.StringVar(buckets, "bucket", cfg.DefaultBucket, "Specify the comma-separated buckets to look for scratch prefixes in (optional)")
+	return buckets
+}
+
+// listScratchSpaces reads the scratch buckets of the account and the scratch prefixes of the
+// comma-separated buckets from R2.
+func listScratchSpaces(ctx context.Context, client *s3.Client, buckets string) []r2.ScratchSpace {
+	var names []string
+	for _, name := range strings.Split(buckets, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	spaces, err := r2.ListScratchSpaces(ctx, client, names)
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to list scratch spaces: %v", err))
+	}
+	return spaces
+}
+
+func handleScratchCreateCommand(ctx context.Context, client *s3.Client) {
+	createFlags := flag.NewFlagSet("scratch create", flag.ExitOnError)
+	bucketName := createFlags.String("b", "", "Create a prefix in this bucket instead of a new bucket (optional)")
+	createFlags.StringVar(bucketName, "bucket", "", "Create a prefix in this bucket instead of a new bucket (optional)")
+	ttlValue := createFlags.String("ttl", "24h", "Specify how long the scratch space lives, e.g. 2h, 24h or 7d (optional)")
+	parseFlags(createFlags, os.Args[3:])
+
+	ttl, err := utils.ParseDuration(*ttlValue)
+	if err != nil || ttl <= 0 {
+		utils.ExitWithError(fmt.Sprintf("Invalid --ttl value '%s', use a duration such as 24h or 7d", *ttlValue))
+	}
+
+	space, err := r2.CreateScratchSpace(ctx, client, *bucketName, ttl)
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to create scratch space: %v", err))
+	}
+	// Only the name goes to stdout, so scripts can capture it.
+	fmt.Fprintf(os.Stderr, "Successfully created scratch space '%s', expiring at %s.\n", space, space.Expires.Local().Format(time.RFC3339))
+	fmt.Println(space)
+}
+
+func handleScratchListCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	listFlags := flag.NewFlagSet("scratch list", flag.ExitOnError)
+	buckets := scratchBucketsFlag(listFlags, cfg)
+	parseFlags(listFlags, os.Args[3:])
+
+	spaces := listScratchSpaces(ctx, client, *buckets)
+	if len(spaces) == 0 {
+		fmt.Println("No scratch spaces.")
+		return
+	}
+
+	now := time.Now()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SCRATCH SPACE\tCREATED\tEXPIRES")
+	for _, space := range spaces {
+		created, expires := "-", "unknown (no marker)"
+		if !space.Created.IsZero() {
+			created = space.Created.Local().Format(time.RFC3339)
+		}
+		if !space.Expires.IsZero() {
+			expires = space.Expires.Local().Format(time.RFC3339)
+			if space.Expires.Before(now) {
+				expires += " (expired)"
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", space, created, expires)
+	}
+	w.Flush()
+}
+
+func handleScratchGcCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	gcFlags := flag.NewFlagSet("scratch gc", flag.ExitOnError)
+	buckets := scratchBucketsFlag(gcFlags, cfg)
+	all := gcFlags.Bool("all", false, "Remove every scratch space, including those not yet expired or without a marker (optional)")
+	dryRun := gcFlags.Bool("dry-run", false, "Print the scratch spaces without removing them (optional)")
+	parseFlags(gcFlags, os.Args[3:])
+
+	now := time.Now()
+	var expired []r2.ScratchSpace
+	for _, space := range listScratchSpaces(ctx, client, *buckets) {
+		// A space without a marker may be one being created right now.
+		if *all || (!space.Expires.IsZero() && space.Expires.Before(now)) {
+			expired = append(expired, space)
+		}
+	}
+
+	removed, failed := 0, 0
+	for _, space := range expired {
+		if *dryRun {
+			fmt.Printf("(dry run) remove '%s'\n", space)
+			continue
+		}
+		fmt.Printf("Removing scratch space '%s'...\n", space)
+		if err := r2.RemoveScratchSpace(ctx, client, space); err != nil {
+			fmt.Printf("Failed: %v\n", err)
+			failed++
+			continue
+		}
+		removed++
+	}
+
+	if *dryRun {
+		fmt.Printf("%d scratch space(s) to remove.\n", len(expired))
+		return
+	}
+	if failed > 0 {
+		utils.ExitWithError(fmt.Sprintf("Removed %d scratch space(s), %d failed.", removed, failed))
+	}
+	fmt.Printf("Successfully removed %d scratch space(s).\n", removed)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/baowuhe/go-cfr2/config"
+	"github.com/baowuhe/go-cfr2/r2"
+	"github.com/baowuhe/go-cfr2/utils"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func handleScrubCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	scrubFlags := flag.NewFlagSet("scrub", flag.ExitOnError)
+	bucketName := scrubFlags.String("b", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	scrubFlags.StringVar(bucketName, "bucket", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	prefix := scrubFlags.String("p", "", "Only check objects below this key prefix (optional)")
+	scrubFlags.StringVar(prefix, "prefix", "", "Only check objects below this key prefix (optional)")
+	sample := scrubFlags.String("sample", "100%", "Check this share of objects, picked at random, such as 5% (optional)")
+	concurrency := scrubFlags.Int("c", 4, "Specify the number of parallel downloads (optional)")
+	scrubFlags.IntVar(concurrency, "concurrency", 4, "Specify the number of parallel downloads (optional)")
+	parseFlags(scrubFlags, os.Args[2:])
+
+	if *bucketName == "" {
+		utils.ExitWithError("Bucket name not specified. Use -b or --bucket flag, or set DefaultBucket in config.")
+	}
+	if *concurrency < 1 {
+		utils.ExitWithError("Concurrency must be at least 1.")
+	}
+	share, err := parseSample(*sample)
+	if err != nil {
+		utils.ExitWithError(err.Error())
+	}
+
+	fmt.Printf("Scrubbing %s of bucket '%s' prefix '%s'...\n", *sample, *bucketName, *prefix)
+	result, err := r2.Scrub(ctx, client, r2.ScrubOptions{
+		Bucket:      *bucketName,
+		Prefix:      *prefix,
+		Sample:      share,
+		Concurrency: *concurrency,
+	})
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to list objects in bucket '%s': %v", *bucketName, err))
+	}
+
+	fmt.Printf("Checked %d object(s): %d verified, %d without checksum, %d corrupt, %d unreadable, %d link(s) skipped.\n",
+		result.Checked, result.Verified, result.Unverifiable, len(result.Corrupt), len(result.Failed), result.Skipped)
+	for _, failure := range result.Corrupt {
+		fmt.Printf("  corrupt     %s: %s\n", failure.Key, failure.Message)
+	}
+	for _, failure := range result.Failed {
+		fmt.Printf("  unreadable  %s: %s\n", failure.Key, failure.Message)
+	}
+	if len(result.Corrupt) > 0 {
+		utils.ExitWithError(fmt.Sprintf("Found %d corrupt object(s) in bucket '%s'.", len(result.Corrupt), *bucketName))
+	}
+	if len(result.Failed) > 0 {
+		utils.ExitWithError(fmt.Sprintf("Could not read %d object(s) in bucket '%s'.", len(result.Failed), *bucketName))
+	}
+}
+
+// parseSample parses a --sample value, a percentage such as "5%" or a fraction such as "0.05".
+func parseSample(value string) (float64, error) {
+	number, percent := strings.CutSuffix(value, "%")
+	share, err := strconv.ParseFloat(number, 64)
+	if err == nil && percent {
+		share /= 100
+	}
+	if err != nil || share <= 0 || share > 1 {
+		return 0, fmt.Errorf("invalid --sample value '%s', expected a percentage between 0%% and 100%%", value)
+	}
+	return share, nil
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/baowuhe/go-cfr2/config"
+	"github.com/baowuhe/go-cfr2/r2"
+	"github.com/baowuhe/go-cfr2/storage"
+	"github.com/baowuhe/go-cfr2/utils"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func handleServeCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	serveFlags := flag.NewFlagSet("serve", flag.ExitOnError)
+	bucketName := serveFlags.String("b", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	serveFlags.StringVar(bucketName, "bucket", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	prefix := serveFlags.String("p", "", "Serve only the objects under this prefix (optional)")
+	serveFlags.StringVar(prefix, "prefix", "", "Serve only the objects under this prefix (optional)")
+	dir := serveFlags.String("dir", "", "Serve a local directory instead of a bucket (optional)")
+	multiBucket := serveFlags.Bool("multi-bucket", false, "Serve every bucket, with the bucket name as the first path segment (optional)")
+	listen := serveFlags.String("listen", "127.0.0.1:8080", "Specify the address to listen on (optional)")
+	index := serveFlags.String("index", "index.html", "Specify the document served for paths ending in a slash, empty to disable (optional)")
+	cacheControl := serveFlags.String("cache-control", "", "Specify the Cache-Control sent for objects without their own (optional)")
+	cacheTTL := serveFlags.Duration("cache-ttl", 0, "Cache objects in memory and revalidate them after this long, e.g. 1m (optional)")
+	cacheDir := serveFlags.String("cache-dir", "", "Keep objects evicted from the memory cache in this directory (optional)")
+	shutdownTimeout := serveFlags.Duration("shutdown-timeout", 30*time.Second, "Specify how long in-flight requests may take to finish on SIGTERM (optional)")
+	healthPrefix := serveFlags.String("health-prefix", defaultHealthPrefix, "Specify the path prefix of the health and readiness endpoints, off to disable (optional)")
+	parseFlags(serveFlags, os.Args[2:])
+
+	if *multiBucket {
+		serveFlags.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "b", "bucket", "p", "prefix", "dir", "cache-ttl", "cache-dir":
+				name := "--" + f.Name
+				if len(f.Name) == 1 {
+					name = "-" + f.Name
+				}
+				utils.ExitWithError(fmt.Sprintf("%s cannot be used with --multi-bucket.", name))
+			}
+		})
+	} else if *dir != "" {
+		if stat, err := os.Stat(*dir); err != nil || !stat.IsDir() {
+			utils.ExitWithError(fmt.Sprintf("'%s' is not a directory.", *dir))
+		}
+	} else if *bucketName == "" {
+		utils.ExitWithError("Bucket name not specified. Use -b or --bucket flag, or set DefaultBucket in config.")
+	}
+	if *cacheDir != "" && *cacheTTL <= 0 {
+		utils.ExitWithError("--cache-dir is only used together with --cache-ttl.")
+	}
+	if *healthPrefix == "off" {
+		*healthPrefix = ""
+	} else if *healthPrefix = "/" + strings.Trim(*healthPrefix, "/") + "/"; *healthPrefix == "//" {
+		utils.ExitWithError("--health-prefix cannot be the root, as the endpoints would shadow objects; use off to disable them.")
+	}
+
+	source := fmt.Sprintf("bucket '%s'", *bucketName)
+	switch {
+	case *multiBucket:
+		source = "all buckets"
+	case *dir != "":
+		source = fmt.Sprintf("directory '%s'", *dir)
+	case *prefix != "":
+		source = fmt.Sprintf("bucket '%s' prefix '%s'", *bucketName, *prefix)
+	}
+	// resolve maps a request path to the bucket and key it reads, for tokens scoped to them.
+	resolve := func(requestPath string) (string, string) {
+		key := strings.TrimPrefix(requestPath, "/")
+		switch {
+		case *multiBucket:
+			bucket, key, _ := strings.Cut(key, "/")
+			return bucket, key
+		case *dir != "":
+			return "", key
+		}
+		return *bucketName, *prefix + key
+	}
+	handlerOptions := storage.HandlerOptions{IndexDocument: *index, CacheControl: *cacheControl}
+
+	// newHandler builds the guarded handler from a configuration, on startup and on every reload.
+	var cache *storage.Cache
+	newHandler := func(cfg *config.R2Config, client *s3.Client) (http.Handler, *serverGuard, error) {
+		guard, err := newServerGuard(cfg.Server, resolve)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid [server] configuration: %w", err)
+		}
+		if *multiBucket {
+			ready := func(ctx context.Context) error {
+				// Offline, the server is ready without asking R2.
+				if r2.Offline(client) {
+					return nil
+				}
+				_, err := client.ListBuckets(ctx, &s3.ListBucketsInput{MaxBuckets: aws.Int32(1)})
+				return err
+			}
+			return withHealthEndpoints(*healthPrefix, ready, guard.Wrap(multiBucketHandler(client, handlerOptions))), guard, nil
+		}
+
+		var backend storage.Backend
+		if *dir != "" {
+			backend = storage.NewLocal(*dir)
+		} else {
+			backend = storage.NewR2(client, *bucketName, *prefix)
+		}
+		if *cacheTTL > 0 {
+			// The cache settings are flags, which a reload does not change, so a reload keeps
+			// the cache and only points it at the backend with the new credentials.
+			if cache != nil {
+				cache.SetBackend(backend)
+			} else if cache, err = storage.NewCache(backend, storage.CacheOptions{TTL: *cacheTTL, DiskDir: *cacheDir}); err != nil {
+				return nil, nil, fmt.Errorf("failed to create cache: %w", err)
+			}
+			backend = cache
+		}
+		ready := func(ctx context.Context) error {
+			if *dir != "" {
+				_, err := os.Stat(*dir)
+				return err
+			}
+			if r2.Offline(client) {
+				return nil
+			}
+			_, err := client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: bucketName})
+			return err
+		}
+		handler := storage.NewHandler(backend, handlerOptions)
+		return withHealthEndpoints(*healthPrefix, ready, guard.Wrap(handler)), guard, nil
+	}
+
+	handler, guard, err := newHandler(cfg, client)
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to start server: %v", err))
+	}
+	var current atomic.Pointer[guardedHandler]
+	current.Store(&guardedHandler{handler, guard})
+	server := &http.Server{
+		Addr: *listen,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// A request that picked up a handler just as a reload closed its guard retries with
+			// the new one.
+			for {
+				served := current.Load()
+				if served.guard.acquire() {
+					defer served.guard.release()
+					served.ServeHTTP(w, r)
+					return
+				}
+			}
+		}),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ctx, reload, stop := daemonSignals(ctx)
+	defer stop()
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		for {
+			select {
+			case <-ctx.Done():
+				fmt.Printf("Shutting down, waiting up to %s for in-flight requests...\n", *shutdownTimeout)
+				shutdownCtx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+				defer cancel()
+				if err := server.Shutdown(shutdownCtx); err != nil {
+					fmt.Fprintf(os.Stderr, "Failed to drain in-flight requests: %v\n", err)
+				}
+				guard.Close()
+				return
+			case <-reload:
+				// Requests in flight finish with the handler they started with, and the old
+				// access log is closed after the last of them.
+				reloadedCfg, reloadedClient, err := reloadConfig()
+				if err == nil {
+					var reloaded http.Handler
+					var reloadedGuard *serverGuard
+					if reloaded, reloadedGuard, err = newHandler(reloadedCfg, reloadedClient); err == nil {
+						current.Store(&guardedHandler{reloaded, reloadedGuard})
+						guard.Close()
+						guard = reloadedGuard
+					}
+				}
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Failed to reload configuration, keeping the previous one: %v\n", err)
+				} else {
+					fmt.Println("Reloaded configuration.")
+				}
+			}
+		}
+	}()
+
+	fmt.Printf("Serving %s on http://%s/...\n", source, *listen)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		utils.ExitWithError(fmt.Sprintf("Failed to serve: %v", err))
+	}
+	<-drained
+}
+
+// bucketNamePattern matches valid R2 bucket names.
+var bucketNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,61}[a-z0-9]$`)
+
+// multiBucketHandler serves the objects of every bucket at /<bucket>/<key>.
+func multiBucketHandler(client *s3.Client, opts storage.HandlerOptions) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bucket, _, found := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		if !found || !bucketNamePattern.MatchString(bucket) {
+			http.NotFound(w, r)
+			return
+		}
+		handler := storage.NewHandler(storage.NewR2(client, bucket, ""), opts)
+		http.StripPrefix("/"+bucket, handler).ServeHTTP(w, r)
+	})
+}
+
+// guardedHandler is the handler a server currently serves with, and the guard inside it.
+type guardedHandler struct {
+	http.Handler
+	guard *serverGuard
+}
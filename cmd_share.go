@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/baowuhe/go-cfr2/config"
+	"github.com/baowuhe/go-cfr2/r2"
+	"github.com/baowuhe/go-cfr2/utils"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// handleShareCommand prints a link to a path served by a Worker written by 'worker generate
+// --signed', signed with ShareSecret. Unlike presigned URLs, the links point at the Worker's custom
+// domain rather than the R2 endpoint, and no request is made. With --presigned it prints a presigned
+// URL of an object instead, and with --short also a short link to either, stored in Workers KV.
+func handleShareCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	shareFlags := flag.NewFlagSet("share", flag.ExitOnError)
+	bucketName := shareFlags.String("b", cfg.DefaultBucket, "Specify the R2 bucket name for --presigned (optional)")
+	shareFlags.StringVar(bucketName, "bucket", cfg.DefaultBucket, "Specify the R2 bucket name for --presigned (optional)")
+	path := shareFlags.String("k", "", "Specify the path below the Worker's prefix (required, unless --signed-cookie is given)")
+	shareFlags.StringVar(path, "key", "", "Specify the path below the Worker's prefix (required, unless --signed-cookie is given)")
+	expiryHours := shareFlags.Int64("e", 24, "Specify the link expiry time in hours (optional)")
+	shareFlags.Int64Var(expiryHours, "expiry", 24, "Specify the link expiry time in hours (optional)")
+	token := shareFlags.Bool("token", false, "Sign the link for this path only (optional, the default)")
+	signedCookie := shareFlags.Bool("signed-cookie", false, "Sign the link for every path below --key, which must end in /, and keep it in a cookie (optional)")
+	presigned := shareFlags.Bool("presigned", false, "Share a presigned URL of the object --key instead of a Worker link (optional)")
+	short := shareFlags.Bool("short", false, "Also print a short link redirecting to the link, stored in Workers KV (optional)")
+	parseFlags(shareFlags, os.Args[2:])
+
+	if *expiryHours <= 0 {
+		utils.ExitWithError("Expiry must be at least one hour.")
+	}
+	expiry := time.Duration(*expiryHours) * time.Hour
+	if *presigned {
+		if *token || *signedCookie {
+			utils.ExitWithError("--presigned cannot be used with --token or --signed-cookie.")
+		}
+		if *bucketName == "" {
+			utils.ExitWithError("Bucket name not specified. Use -b or --bucket flag, or set DefaultBucket in config.")
+		}
+		if *path == "" {
+			utils.ExitWithError("Object key not specified. Use -k or --key flag.")
+		}
+		link, err := r2.GeneratePresignedURLWithExpiry(ctx, client, *bucketName, *path, expiry)
+		if err != nil {
+			utils.ExitWithError(fmt.Sprintf("Failed to generate presigned URL for object '%s': %v", *path, err))
+		}
+		fmt.Printf("Presigned URL valid until %s: %s\n", time.Now().Add(expiry).Format(time.RFC3339), link)
+		printShortLink(ctx, cfg, link, expiry, *short)
+		return
+	}
+
+	if cfg.ShareBaseURL == "" || cfg.ShareSecret == "" {
+		utils.ExitWithError("Signed links need ShareBaseURL and ShareSecret to be set in config.")
+	}
+	if *token && *signedCookie {
+		utils.ExitWithError("--token and --signed-cookie cannot be used together.")
+	}
+	*path = strings.TrimPrefix(*path, "/")
+	if *signedCookie {
+		if *path != "" && !strings.HasSuffix(*path, "/") {
+			utils.ExitWithError(fmt.Sprintf("--signed-cookie shares every path below a prefix; use '%s/'.", *path))
+		}
+	} else if *path == "" {
+		utils.ExitWithError("Path not specified. Use -k or --key flag.")
+	}
+
+	expires := time.Now().Add(expiry)
+	link, err := signShareLink(cfg.ShareBaseURL, cfg.ShareSecret, *path, *signedCookie, expires)
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to sign link: %v", err))
+	}
+	if *signedCookie {
+		fmt.Printf("Link to everything below '%s', valid until %s: %s\n", "/"+*path, expires.Format(time.RFC3339), link)
+		fmt.Println("The Worker keeps the signature in a cookie, so pages below the prefix can load their assets.")
+	} else {
+		fmt.Printf("Link valid until %s: %s\n", expires.Format(time.RFC3339), link)
+	}
+	printShortLink(ctx, cfg, link, expiry, *short)
+}
+
+// printShortLink stores link under a short code expiring with it and prints the short link, if
+// short is set.
+func printShortLink(ctx context.Context, cfg *config.R2Config, link string, expiry time.Duration, short bool) {
+	if !short {
+		return
+	}
+	shortURL, err := shortenURL(ctx, cfg, link, expiry)
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to create short link: %v", err))
+	}
+	fmt.Printf("Short link: %s\n", shortURL)
+}
+
+// signShareLink returns a link to path on the Worker at baseURL, with an HMAC-SHA256 signature over
+// the path, or with prefix set over every path below it, and the expiry. The Worker computes the
+// same signature in checkShare.
+func signShareLink(baseURL, secret, path string, prefix bool, expires time.Time) (string, error) {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	link, err := url.Parse(strings.TrimSuffix(baseURL, "/") + "/" + strings.Join(segments, "/"))
+	if err != nil {
+		return "", fmt.Errorf("invalid ShareBaseURL '%s': %w", baseURL, err)
+	}
+
+	expiry := strconv.FormatInt(expires.Unix(), 10)
+	kind := "path"
+	if prefix {
+		kind = "prefix"
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(kind + "\n" + path + "\n" + expiry))
+
+	query := url.Values{}
+	query.Set("expires", expiry)
+	if prefix {
+		query.Set("scope", path)
+	}
+	query.Set("sig", hex.EncodeToString(mac.Sum(nil)))
+	link.RawQuery = query.Encode()
+	return link.String(), nil
+}
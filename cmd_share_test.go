@@ -0,0 +1,58 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSignShareLink(t *testing.T) {
+	expires := time.Unix(1700000000, 0)
+	tests := []struct {
+		name     string
+		baseURL  string
+		path     string
+		prefix   bool
+		wantPath string
+		message  string
+	}{
+		{"path", "https://files.example.com/", "docs/a b.pdf", false, "/docs/a%20b.pdf", "path\ndocs/a b.pdf\n1700000000"},
+		{"base without slash", "https://files.example.com", "x?y#z", false, "/x%3Fy%23z", "path\nx?y#z\n1700000000"},
+		{"prefix", "https://files.example.com/", "reports/", true, "/reports/", "prefix\nreports/\n1700000000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			link, err := signShareLink(tt.baseURL, "secret", tt.path, tt.prefix, expires)
+			if err != nil {
+				t.Fatal(err)
+			}
+			u, err := url.Parse(link)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if u.EscapedPath() != tt.wantPath {
+				t.Errorf("path = %q, want %q", u.EscapedPath(), tt.wantPath)
+			}
+			query := u.Query()
+			if query.Get("expires") != strconv.FormatInt(expires.Unix(), 10) {
+				t.Errorf("expires = %q", query.Get("expires"))
+			}
+			if tt.prefix != (query.Get("scope") == tt.path) {
+				t.Errorf("scope = %q, want it set only for prefixes", query.Get("scope"))
+			}
+			mac := hmac.New(sha256.New, []byte("secret"))
+			mac.Write([]byte(tt.message))
+			if query.Get("sig") != hex.EncodeToString(mac.Sum(nil)) {
+				t.Errorf("sig = %q does not sign %q", query.Get("sig"), tt.message)
+			}
+		})
+	}
+
+	if _, err := signShareLink("://bad", "secret", "a", false, expires); err == nil {
+		t.Error("signShareLink accepted an invalid base URL")
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/baowuhe/go-cfr2/config"
+	"github.com/baowuhe/go-cfr2/r2"
+	"github.com/baowuhe/go-cfr2/utils"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// shippedLog records how much of a log file has already been shipped.
+type shippedLog struct {
+	Offset    int64  `json:"offset"`
+	Partition string `json:"partition"`
+	Seq       int    `json:"seq"`
+	// Dev and Inode identify the file the offset belongs to, so that a rotated file is noticed
+	// whatever its size. They are zero where the platform has no inode numbers.
+	Dev   uint64 `json:"dev,omitempty"`
+	Inode uint64 `json:"inode,omitempty"`
+}
+
+// rotated reports whether the file at a log path is no longer the one entry was recorded for, so
+// that it has to be shipped from its start. Without an identity, a file smaller than the shipped
+// part is taken as rotated.
+func (entry shippedLog) rotated(info fs.FileInfo) bool {
+	if dev, ino, ok := fileIdentity(info); ok && entry.Inode != 0 && (dev != entry.Dev || ino != entry.Inode) {
+		return true
+	}
+	return info.Size() < entry.Offset
+}
+
+func handleShipLogsCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	shipFlags := flag.NewFlagSet("ship-logs", flag.ExitOnError)
+	bucketName := shipFlags.String("b", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	shipFlags.StringVar(bucketName, "bucket", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	logDir := shipFlags.String("d", "", "Specify the directory containing the log files (required)")
+	shipFlags.StringVar(logDir, "dir", "", "Specify the directory containing the log files (required)")
+	pattern := shipFlags.String("pattern", "*.log", "Specify the glob pattern of log files to ship (optional)")
+	partition := shipFlags.String("partition", "hourly", "Specify the key partitioning: hourly or daily (optional)")
+	prefix := shipFlags.String("p", "logs/", "Specify the key prefix for shipped logs (optional)")
+	shipFlags.StringVar(prefix, "prefix", "logs/", "Specify the key prefix for shipped logs (optional)")
+	compression := shipFlags.String("compression", "zstd", "Specify the compression of shipped chunks: zstd or gzip (optional)")
+	interval := shipFlags.Duration("interval", 0, "Keep running and ship new log data at this interval, e.g. 5m (optional)")
+	parseFlags(shipFlags, os.Args[2:])
+
+	if *bucketName == "" {
+		utils.ExitWithError("Bucket name not specified. Use -b or --bucket flag, or set DefaultBucket in config.")
+	}
+	if *logDir == "" {
+		utils.ExitWithError("Log directory not specified. Use -d or --dir flag.")
+	}
+	if _, err := filepath.Match(*pattern, ""); err != nil {
+		utils.ExitWithError(fmt.Sprintf("Invalid pattern '%s': %v", *pattern, err))
+	}
+	var partitionLayout string
+	switch *partition {
+	case "hourly":
+		partitionLayout = "2006/01/02/15"
+	case "daily":
+		partitionLayout = "2006/01/02"
+	default:
+		utils.ExitWithError(fmt.Sprintf("Unknown partition '%s'. Use hourly or daily.", *partition))
+	}
+	var ext string
+	switch *compression {
+	case "zstd":
+		ext = ".zst"
+	case "gzip":
+		ext = ".gz"
+	default:
+		utils.ExitWithError(fmt.Sprintf("Unknown compression '%s'. Use zstd or gzip.", *compression))
+	}
+
+	absDir, err := filepath.Abs(*logDir)
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to resolve log directory '%s': %v", *logDir, err))
+	}
+	dirHash := sha256.Sum256([]byte(*bucketName + ":" + absDir))
+	statePath, err := config.StatePath("ship-logs-" + hex.EncodeToString(dirHash[:8]) + ".json")
+	if err != nil {
+		utils.ExitWithError(err.Error())
+	}
+
+	ctx, reload, stop := daemonSignals(ctx)
+	defer stop()
+
+	for {
+		shipped, err := shipLogs(ctx, client, *bucketName, absDir, *pattern, *prefix, partitionLayout, ext, statePath)
+		if err != nil {
+			utils.ExitWithError(fmt.Sprintf("Failed to ship logs from '%s': %v", absDir, err))
+		}
+		fmt.Printf("Shipped %d log chunk(s) from '%s' to bucket '%s'.\n", shipped, absDir, *bucketName)
+
+		if *interval <= 0 {
+			return
+		}
+		next := time.After(*interval)
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-reload:
+				// The bucket and directory stay those of the command line.
+				_, reloadedClient, err := reloadConfig()
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Failed to reload configuration, keeping the previous one: %v\n", err)
+					continue
+				}
+				client = reloadedClient
+				fmt.Println("Reloaded configuration.")
+			case <-next:
+				break wait
+			}
+		}
+	}
+}
+
+// shipLogs uploads the data appended to each matching log file since the previous run.
+// A file replaced since the last run, by rotation, is shipped from the start.
+// Chunks are compressed according to ext, ".zst" or ".gz", which ends their keys.
+// Once ctx is canceled, the chunk being uploaded is finished and no further chunk is started.
+func shipLogs(ctx context.Context, client *s3.Client, bucketName, dir, pattern, prefix, partitionLayout, ext, statePath string) (int, error) {
+	state := map[string]shippedLog{}
+	if data, err := os.ReadFile(statePath); err == nil {
+		if err := json.Unmarshal(data, &state); err != nil {
+			return 0, fmt.Errorf("failed to parse state file '%s': %w", statePath, err)
+		}
+	}
+
+	matches, err := filepath.Glob(filepath.Join(dir, pattern))
+	if err != nil {
+		return 0, err
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		host = "localhost"
+	}
+	currentPartition := time.Now().UTC().Format(partitionLayout)
+
+	shipped := 0
+	for _, logPath := range matches {
+		if ctx.Err() != nil {
+			break
+		}
+		info, err := os.Stat(logPath)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+
+		entry := state[logPath]
+		if entry.rotated(info) {
+			entry.Offset = 0
+		}
+		entry.Dev, entry.Inode, _ = fileIdentity(info)
+		if info.Size() == entry.Offset {
+			continue
+		}
+
+		if entry.Partition == currentPartition {
+			entry.Seq++
+		} else {
+			entry.Partition = currentPartition
+			entry.Seq = 0
+		}
+		name := host + "-" + filepath.Base(logPath)
+		if entry.Seq > 0 {
+			name = fmt.Sprintf("%s.%d", name, entry.Seq)
+		}
+		objectKey := path.Join(prefix, currentPartition, name+ext)
+
+		fmt.Printf("Shipping '%s' (%d bytes) as '%s'...\n", logPath, info.Size()-entry.Offset, objectKey)
+		if err := shipLogChunk(context.WithoutCancel(ctx), client, bucketName, objectKey, logPath, entry.Offset, info.Size()); err != nil {
+			return shipped, err
+		}
+
+		entry.Offset = info.Size()
+		state[logPath] = entry
+		shipped++
+
+		// Persist progress after every chunk so an interrupted run does not ship data twice.
+		if err := saveShipState(statePath, state); err != nil {
+			return shipped, err
+		}
+	}
+
+	return shipped, nil
+}
+
+// shipLogChunk compresses, according to the extension of objectKey, and uploads the byte range
+// [from, to) of a log file.
+func shipLogChunk(ctx context.Context, client *s3.Client, bucketName, objectKey, logPath string, from, to int64) error {
+	file, err := os.Open(logPath)
+	if err != nil {
+		return fmt.Errorf("failed to open log file '%s': %w", logPath, err)
+	}
+	defer file.Close()
+
+	pr, pw := io.Pipe()
+	go func() {
+		compressor, err := utils.NewCompressWriter(pw, objectKey)
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		_, err = io.Copy(compressor, io.NewSectionReader(file, from, to-from))
+		if closeErr := compressor.Close(); err == nil {
+			err = closeErr
+		}
+		pw.CloseWithError(err)
+	}()
+
+	err = r2.UploadStream(ctx, client, bucketName, objectKey, pr)
+	pr.CloseWithError(err)
+	return err
+}
+
+func saveShipState(statePath string, state map[string]shippedLog) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(statePath, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write state file '%s': %w", statePath, err)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestShippedLogRotated(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "app.log")
+	if err := os.WriteFile(logPath, []byte("first line\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := shippedLog{Offset: info.Size()}
+	entry.Dev, entry.Inode, _ = fileIdentity(info)
+
+	// The file is appended to.
+	if err := os.WriteFile(logPath, []byte("first line\nsecond line\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Stat(logPath); entry.rotated(info) {
+		t.Error("a file appended to is taken as rotated")
+	}
+
+	// The file is rotated, and the new one grows past the shipped part before the next run.
+	if err := os.Rename(logPath, logPath+".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(logPath, []byte("a longer first line of the new file\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	info, err = os.Stat(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := fileIdentity(info); !ok {
+		t.Skip("no inode numbers on this platform")
+	}
+	if !entry.rotated(info) {
+		t.Error("a rotated file larger than the shipped part is not taken as rotated")
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/baowuhe/go-cfr2/config"
+	"github.com/baowuhe/go-cfr2/r2"
+	"github.com/baowuhe/go-cfr2/utils"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func handleSnapshotCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	if len(os.Args) < 3 {
+		utils.ExitWithError("Snapshot subcommand not specified. Use 'snapshot create' or 'snapshot restore-metadata'.")
+	}
+
+	switch os.Args[2] {
+	case "create":
+		handleSnapshotCreateCommand(ctx, client, cfg)
+	case "restore-metadata":
+		handleSnapshotRestoreMetadataCommand(ctx, client, cfg)
+	default:
+		utils.ExitWithError(fmt.Sprintf("Unknown snapshot subcommand '%s'. Use 'snapshot create' or 'snapshot restore-metadata'.", os.Args[2]))
+	}
+}
+
+func handleSnapshotCreateCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	createFlags := flag.NewFlagSet("snapshot create", flag.ExitOnError)
+	bucketName := createFlags.String("b", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	createFlags.StringVar(bucketName, "bucket", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	prefix := createFlags.String("p", "", "Only capture objects below this key prefix (optional)")
+	createFlags.StringVar(prefix, "prefix", "", "Only capture objects below this key prefix (optional)")
+	outputPath := createFlags.String("o", "", "Specify the local file the snapshot is written to, e.g. bucket.manifest.gz (required)")
+	createFlags.StringVar(outputPath, "output", "", "Specify the local file the snapshot is written to, e.g. bucket.manifest.gz (required)")
+	noTags := createFlags.Bool("no-tags", false, "Do not capture object tags (optional)")
+	parseFlags(createFlags, os.Args[3:])
+
+	if *bucketName == "" {
+		utils.ExitWithError("Bucket name not specified. Use -b or --bucket flag, or set DefaultBucket in config.")
+	}
+	if *outputPath == "" {
+		utils.ExitWithError("Output file not specified. Use -o or --output flag.")
+	}
+
+	fmt.Printf("Capturing snapshot of bucket '%s' prefix '%s' to '%s'...\n", *bucketName, *prefix, *outputPath)
+	count, err := createSnapshot(ctx, client, *bucketName, *prefix, *outputPath, !*noTags)
+	if err != nil {
+		os.Remove(*outputPath)
+		utils.ExitWithError(fmt.Sprintf("Failed to capture snapshot of bucket '%s': %v", *bucketName, err))
+	}
+	fmt.Printf("Successfully captured %d object(s) to '%s'.\n", count, *outputPath)
+}
+
+// createSnapshot writes one JSON line per object, compressed according to the extension of
+// outputPath. Tags are dropped with a note if the endpoint does not implement tagging.
+func createSnapshot(ctx context.Context, client *s3.Client, bucketName, prefix, outputPath string, withTags bool) (int, error) {
+	file, err := os.Create(outputPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create '%s': %w", outputPath, err)
+	}
+	defer file.Close()
+	compressor, err := utils.NewCompressWriter(file, outputPath)
+	if err != nil {
+		return 0, err
+	}
+
+	encoder := json.NewEncoder(compressor)
+	count := 0
+	err = r2.WalkObjects(ctx, client, bucketName, prefix, func(obj types.Object) error {
+		snapshot, err := r2.SnapshotObject(ctx, client, bucketName, *obj.Key, withTags)
+		if errors.Is(err, r2.ErrTaggingUnsupported) {
+			fmt.Println("Note: the bucket does not support object tagging, tags are not captured.")
+			withTags = false
+			err = nil
+		}
+		if err != nil {
+			return err
+		}
+		count++
+		return encoder.Encode(snapshot)
+	})
+	if closeErr := compressor.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return count, err
+}
+
+func handleSnapshotRestoreMetadataCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	restoreFlags := flag.NewFlagSet("snapshot restore-metadata", flag.ExitOnError)
+	bucketName := restoreFlags.String("b", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	restoreFlags.StringVar(bucketName, "bucket", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	prefix := restoreFlags.String("p", "", "Only restore objects below this key prefix (optional)")
+	restoreFlags.StringVar(prefix, "prefix", "", "Only restore objects below this key prefix (optional)")
+	args := parseInterspersed(restoreFlags, os.Args[3:])
+
+	if *bucketName == "" {
+		utils.ExitWithError("Bucket name not specified. Use -b or --bucket flag, or set DefaultBucket in config.")
+	}
+	if len(args) != 1 {
+		utils.ExitWithError("Snapshot file not specified. Usage: go-cfr2 snapshot restore-metadata <file> [flags]")
+	}
+	snapshotPath := args[0]
+
+	fmt.Printf("Restoring metadata from '%s' to bucket '%s'...\n", snapshotPath, *bucketName)
+	replayMetadata(ctx, client, cfg, *bucketName, *prefix, snapshotPath, r2.MetadataRestoreOptions{}, "restored")
+}
+
+// replayMetadata reapplies the metadata and tags of every object in a snapshot file below prefix,
+// printing one line per object touched and a summary. verb names the action in the summary.
+func replayMetadata(ctx context.Context, client *s3.Client, cfg *config.R2Config, bucketName, prefix, snapshotPath string, opts r2.MetadataRestoreOptions, verb string) {
+	file, err := os.Open(snapshotPath)
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to open '%s': %v", snapshotPath, err))
+	}
+	defer file.Close()
+	reader, err := utils.NewDecompressReader(file, snapshotPath)
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to read '%s': %v", snapshotPath, err))
+	}
+	defer reader.Close()
+
+	var updated, unchanged, skipped, missing, failed int
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var snapshot r2.ObjectSnapshot
+		if err := json.Unmarshal(scanner.Bytes(), &snapshot); err != nil {
+			utils.ExitWithError(fmt.Sprintf("Failed to parse '%s': %v", snapshotPath, err))
+		}
+		if !strings.HasPrefix(snapshot.Key, prefix) {
+			continue
+		}
+		if prefix, ok := cfg.AppendOnlyPrefix(snapshot.Key); ok {
+			// Rewriting the metadata copies the object onto itself, which counts as a modification.
+			skipped++
+			fmt.Printf("Skipped '%s': prefix '%s' is append-only.\n", snapshot.Key, prefix)
+			continue
+		}
+
+		changes, err := r2.RestoreObjectMetadataWithOptions(ctx, client, bucketName, snapshot, opts)
+		var notFound *types.NotFound
+		switch {
+		case errors.As(err, &notFound):
+			missing++
+			fmt.Printf("Missing: '%s'\n", snapshot.Key)
+		case errors.Is(err, r2.ErrObjectChanged):
+			skipped++
+			fmt.Printf("Skipped '%s': its content changed since the export.\n", snapshot.Key)
+		case err != nil:
+			failed++
+			fmt.Printf("Failed: %v\n", err)
+		case len(changes) > 0:
+			updated++
+			if opts.DryRun {
+				fmt.Printf("Would update '%s':\n", snapshot.Key)
+			} else {
+				fmt.Printf("Updated '%s':\n", snapshot.Key)
+			}
+			for _, change := range changes {
+				fmt.Printf("  %s\n", change)
+			}
+		default:
+			unchanged++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to read '%s': %v", snapshotPath, err))
+	}
+
+	if opts.DryRun {
+		verb = "to be " + verb
+	}
+	fmt.Printf("%d %s, %d already up to date, %d skipped, %d missing, %d failed.\n", updated, verb, unchanged, skipped, missing, failed)
+	if failed > 0 {
+		utils.ExitWithError(fmt.Sprintf("Failed to update metadata of %d object(s).", failed))
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/baowuhe/go-cfr2/config"
+	"github.com/baowuhe/go-cfr2/r2"
+	"github.com/baowuhe/go-cfr2/utils"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func handleStatCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	statFlags := flag.NewFlagSet("stat", flag.ExitOnError)
+	bucketName := statFlags.String("b", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	statFlags.StringVar(bucketName, "bucket", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	objectKey := statFlags.String("k", "", "Specify the object key to describe (required)")
+	statFlags.StringVar(objectKey, "key", "", "Specify the object key to describe (required)")
+	recursive := statFlags.Bool("r", false, "Describe every object below --key, taken as a prefix (optional)")
+	statFlags.BoolVar(recursive, "recursive", false, "Describe every object below --key, taken as a prefix (optional)")
+	concurrency := statFlags.Int("c", r2.DefaultHeadConcurrency, "Specify the number of parallel HEAD requests with --recursive (optional)")
+	statFlags.IntVar(concurrency, "concurrency", r2.DefaultHeadConcurrency, "Specify the number of parallel HEAD requests with --recursive (optional)")
+	parseFlags(statFlags, os.Args[2:])
+
+	if *bucketName == "" {
+		utils.ExitWithError("Bucket name not specified. Use -b or --bucket flag, or set DefaultBucket in config.")
+	}
+	if *concurrency < 1 {
+		utils.ExitWithError("Concurrency must be at least 1.")
+	}
+	if *recursive {
+		if isKeyGlob(*objectKey) {
+			utils.ExitWithError("--recursive takes a prefix and cannot be used with a glob.")
+		}
+		statPrefix(ctx, client, *bucketName, *objectKey, *concurrency)
+		return
+	}
+	if *objectKey == "" {
+		utils.ExitWithError("Object key not specified. Use -k or --key flag.")
+	}
+
+	for i, key := range expandKeyArg(ctx, client, *bucketName, *objectKey) {
+		if i > 0 {
+			fmt.Println()
+		}
+		stat, err := r2.StatObject(ctx, client, *bucketName, key)
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			utils.ExitWithError(fmt.Sprintf("Object '%s' not found in bucket '%s'.", key, *bucketName))
+		}
+		if err != nil {
+			utils.ExitWithError(err.Error())
+		}
+		printObjectStat(stat)
+	}
+}
+
+// statPrefix describes every object below a prefix in key order, fetching their metadata with
+// concurrent HEAD requests.
+func statPrefix(ctx context.Context, client *s3.Client, bucketName, prefix string, concurrency int) {
+	var stats []*r2.ObjectStat
+	pipeline := r2.NewHeadPipeline(ctx, client, bucketName, concurrency, func(key string, head *s3.HeadObjectOutput, err error) error {
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			// Deleted since it was listed.
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		stats = append(stats, r2.ObjectStatOf(key, head))
+		return nil
+	})
+	err := r2.WalkObjects(ctx, client, bucketName, prefix, func(obj types.Object) error {
+		if r2.IsDirectoryMarker(obj) {
+			return nil
+		}
+		return pipeline.Add(*obj.Key)
+	})
+	if waitErr := pipeline.Wait(); err == nil {
+		err = waitErr
+	}
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to describe the objects below '%s': %v", prefix, err))
+	}
+	if len(stats) == 0 {
+		utils.ExitWithError(fmt.Sprintf("No objects below '%s' in bucket '%s'.", prefix, bucketName))
+	}
+
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Key < stats[j].Key })
+	for i, stat := range stats {
+		if i > 0 {
+			fmt.Println()
+		}
+		printObjectStat(stat)
+	}
+}
+
+// printObjectStat prints the properties and metadata of an object as aligned name: value lines.
+func printObjectStat(stat *r2.ObjectStat) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Key:\t%s\n", stat.Key)
+	fmt.Fprintf(w, "Size:\t%s (%d bytes)\n", formatSize(stat.Size), stat.Size)
+	fmt.Fprintf(w, "ETag:\t%s\n", stat.ETag)
+	fmt.Fprintf(w, "Last-Modified:\t%s\n", stat.LastModified.Local().Format(time.RFC3339))
+	writeObjectMetadata(w, stat)
+	w.Flush()
+}
+
+// writeObjectMetadata writes the content headers and user-defined metadata of an object as
+// name: value lines.
+func writeObjectMetadata(w *tabwriter.Writer, stat *r2.ObjectStat) {
+	for _, field := range []struct{ name, value string }{
+		{"Content-Type", stat.ContentType},
+		{"Content-Encoding", stat.ContentEncoding},
+		{"Content-Disposition", stat.ContentDisposition},
+		{"Content-Language", stat.ContentLanguage},
+		{"Cache-Control", stat.CacheControl},
+		{"Expires", stat.Expires},
+		{"Storage-Class", stat.StorageClass},
+	} {
+		if field.value != "" {
+			fmt.Fprintf(w, "%s:\t%s\n", field.name, field.value)
+		}
+	}
+	names := make([]string, 0, len(stat.Metadata))
+	for name := range stat.Metadata {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "Meta %s:\t%s\n", name, stat.Metadata[name])
+	}
+}
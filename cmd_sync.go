@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/baowuhe/go-cfr2/config"
+	"github.com/baowuhe/go-cfr2/r2"
+	"github.com/baowuhe/go-cfr2/utils"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func handleSyncCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	syncFlags := flag.NewFlagSet("sync", flag.ExitOnError)
+	bucketName := syncFlags.String("b", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	syncFlags.StringVar(bucketName, "bucket", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	prefix := syncFlags.String("p", "", "Specify the key prefix to sync into (optional)")
+	syncFlags.StringVar(prefix, "prefix", "", "Specify the key prefix to sync into (optional)")
+	deleteRemote := syncFlags.Bool("delete", false, "Delete remote objects that no longer exist locally (optional)")
+	archiveTo := syncFlags.String("archive-to", "", "Move deleted objects below this key template instead of deleting them (optional)")
+	restoreManifestPath := syncFlags.String("restore-manifest", "", "List the deleted or archived objects in this JSON file, next to a script restoring them (optional)")
+	maxDelete := syncFlags.String("max-delete", "", "Abort if more than this many files, or this percentage such as 10%, would be deleted (optional)")
+	cleanMarkers := syncFlags.Bool("clean-markers", false, "Delete zero-byte directory marker objects below the prefix (optional)")
+	overrideProtection := syncFlags.Bool("override-protection", false, "Delete and overwrite protected objects too (optional)")
+	dedup := syncFlags.Bool("dedup", false, "Copy server-side instead of uploading content already in the bucket (optional)")
+	preserveHardlinks := syncFlags.Bool("preserve-hardlinks", false, "Upload hard-linked content once and restore the links on download (optional)")
+	preservePerms := syncFlags.Bool("preserve-perms", false, "Record mode, owner and extended attributes on upload and restore them on download (optional)")
+	checksum := syncFlags.Bool("checksum", false, "Compare SHA-256 content hashes instead of size and modification time (optional)")
+	dryRun := syncFlags.Bool("dry-run", false, "Print the planned actions without executing them (optional)")
+	concurrency := syncFlags.Int("c", 4, "Specify the number of parallel transfers (optional)")
+	syncFlags.IntVar(concurrency, "concurrency", 4, "Specify the number of parallel transfers (optional)")
+	incremental := syncFlags.Bool("incremental", false, "Compare against the state saved by the previous run instead of listing the bucket (optional)")
+	rescan := syncFlags.Bool("rescan", false, "List the bucket anyway and rebuild the saved state (optional)")
+	bidirectional := syncFlags.Bool("bidirectional", false, "Propagate changes and deletions in both directions (optional)")
+	prefer := syncFlags.String("prefer", "", "Resolve bidirectional conflicts: newer, local, remote or ask (optional)")
+	precompress := syncFlags.String("precompress", "", "Upload precompressed variants of text files next to them, e.g. gzip,br (optional)")
+	maxDuration := syncFlags.String("max-duration", "", "Stop starting transfers after this duration, e.g. 55m, and record the rest for the next run (optional)")
+	args := parseInterspersed(syncFlags, os.Args[2:])
+
+	if *bucketName == "" {
+		utils.ExitWithError("Bucket name not specified. Use -b or --bucket flag, or set DefaultBucket in config.")
+	}
+	if len(args) != 1 {
+		utils.ExitWithError("Local directory not specified. Usage: go-cfr2 sync <dir> [flags]")
+	}
+	localDir := args[0]
+	if stat, err := os.Stat(localDir); err != nil || !stat.IsDir() {
+		utils.ExitWithError(fmt.Sprintf("'%s' is not a directory.", localDir))
+	}
+	switch *prefer {
+	case "", "newer", r2.ResolveLocal, r2.ResolveRemote, "ask":
+	default:
+		utils.ExitWithError(fmt.Sprintf("Unknown --prefer value '%s'. Use newer, local, remote or ask.", *prefer))
+	}
+	if *prefer != "" && !*bidirectional {
+		utils.ExitWithError("--prefer is only used together with --bidirectional.")
+	}
+	if *incremental && *bidirectional {
+		utils.ExitWithError("--incremental cannot be combined with --bidirectional.")
+	}
+	if *rescan && !*incremental {
+		utils.ExitWithError("--rescan is only used together with --incremental.")
+	}
+	if *archiveTo != "" && !*deleteRemote && !*bidirectional {
+		utils.ExitWithError("--archive-to is only used together with --delete or --bidirectional.")
+	}
+	if *restoreManifestPath != "" && !*deleteRemote && !*bidirectional && !*cleanMarkers {
+		utils.ExitWithError("--restore-manifest is only used together with --delete, --bidirectional or --clean-markers.")
+	}
+
+	maxDeleteCount, maxDeletePercent, err := parseMaxDelete(*maxDelete)
+	if err != nil {
+		utils.ExitWithError(err.Error())
+	}
+
+	var archivePrefix string
+	var exclude []string
+	if *archiveTo != "" {
+		archivePrefix, err = utils.ExpandKeyTemplate(*archiveTo, time.Now())
+		if err != nil {
+			utils.ExitWithError(err.Error())
+		}
+		if *prefix == "" {
+			// Syncing the whole bucket puts the archive among the synced keys, where a later sync
+			// would delete it, so the directory the template starts with is left out of the sync.
+			base, _ := utils.KeyTemplatePattern(*archiveTo)
+			base = base[:strings.LastIndex(base, "/")+1]
+			if base == "" {
+				utils.ExitWithError("Syncing the whole bucket needs an --archive-to template below a fixed directory, such as 'archive/{{.Date}}/'.")
+			}
+			exclude = []string{base}
+		}
+	}
+
+	precompressEncodings, err := parsePrecompress(*precompress)
+	if err != nil {
+		utils.ExitWithError(err.Error())
+	}
+	if precompressEncodings != nil && *bidirectional {
+		utils.ExitWithError("--precompress cannot be combined with --bidirectional.")
+	}
+
+	var deadline time.Time
+	var resumePath string
+	if *maxDuration != "" {
+		budget, err := utils.ParseDuration(*maxDuration)
+		if err != nil || budget <= 0 {
+			utils.ExitWithError(fmt.Sprintf("Invalid --max-duration value '%s'. Use a duration such as 55m or 2h.", *maxDuration))
+		}
+		deadline = time.Now().Add(budget)
+		resumePath, err = syncResumePath(localDir, *bucketName, *prefix)
+		if err != nil {
+			utils.ExitWithError(err.Error())
+		}
+	}
+
+	var statePath string
+	if *incremental || *bidirectional {
+		mode := "incremental"
+		if *bidirectional {
+			mode = "bidirectional"
+		}
+		statePath, err = syncStatePath(localDir, *bucketName, *prefix, mode)
+		if err != nil {
+			utils.ExitWithError(err.Error())
+		}
+	}
+
+	var manifest *r2.HashManifest
+	if *dedup {
+		manifest, err = loadHashManifest(*bucketName)
+		if err != nil {
+			utils.ExitWithError(err.Error())
+		}
+	}
+
+	var deleted *restoreManifest
+	var recordDeleted func(key, archivedTo string)
+	if *restoreManifestPath != "" && !*dryRun {
+		if err := checkRestoreManifestPath(*restoreManifestPath); err != nil {
+			utils.ExitWithError(fmt.Sprintf("Invalid --restore-manifest: %v.", err))
+		}
+		deleted = newRestoreManifest(*bucketName)
+		recordDeleted = func(key, archivedTo string) {
+			// The local copies of deleted objects are found below the synced directory.
+			deleted.add(deletedObject{Key: key, Path: syncRelativePath(*prefix, key), ArchivedTo: archivedTo})
+		}
+	}
+
+	// With a daemon running, every transfer waits behind those of interactive commands.
+	acquireSyncSlot := func(ctx context.Context, key string) (func(), error) {
+		return acquireTransferSlot(ctx, priorityBackground, "sync "+key)
+	}
+
+	fmt.Printf("Syncing '%s' to bucket '%s' prefix '%s'...\n", localDir, *bucketName, *prefix)
+	result, err := r2.Sync(ctx, client, r2.SyncOptions{
+		LocalDir:           localDir,
+		Bucket:             *bucketName,
+		Prefix:             *prefix,
+		Delete:             *deleteRemote,
+		ArchivePrefix:      archivePrefix,
+		Exclude:            exclude,
+		Deleted:            recordDeleted,
+		AcquireSlot:        acquireSyncSlot,
+		CleanMarkers:       *cleanMarkers,
+		OverrideProtection: *overrideProtection,
+		AppendOnlyPrefixes: cfg.AppendOnlyPrefixes,
+		MaxDelete:          maxDeleteCount,
+		MaxDeletePercent:   maxDeletePercent,
+		Checksum:           *checksum,
+		DryRun:             *dryRun,
+		Concurrency:        *concurrency,
+		Deadline:           deadline,
+		ResumePath:         resumePath,
+		Incremental:        *incremental,
+		Rescan:             *rescan,
+		Bidirectional:      *bidirectional,
+		StatePath:          statePath,
+		Prefer:             *prefer,
+		AskConflict:        askSyncConflict,
+		Manifest:           manifest,
+		PreserveHardlinks:  *preserveHardlinks,
+		PreservePerms:      *preservePerms,
+		Precompress:        precompressEncodings,
+	})
+	if manifest != nil && !*dryRun {
+		if saveErr := manifest.Save(); saveErr != nil {
+			utils.Warnf("%v", saveErr)
+		}
+	}
+	if deleted != nil {
+		saveRestoreManifest(deleted, *restoreManifestPath)
+	}
+	if result != nil {
+		fmt.Printf("Sync complete: %d uploaded, %d deleted, %d unchanged, %d failed.\n", result.Uploaded, result.Deleted, result.Unchanged, result.Failed)
+		if *bidirectional {
+			fmt.Printf("Bidirectional: %d downloaded, %d deleted locally, %d conflict(s).\n", result.Downloaded, result.DeletedLocal, result.Conflicts)
+		}
+		if result.Remaining > 0 {
+			fmt.Printf("Stopped after %s: %d action(s) left for the next run, recorded in '%s'.\n", *maxDuration, result.Remaining, resumePath)
+		}
+	}
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to sync '%s': %v", localDir, err))
+	}
+}
+
+// parseMaxDelete parses a --max-delete value, either a file count such as '100' or a percentage such as '10%'.
+// A limit of 0 or 0% allows no deletions at all; without a value the count is nil and nothing is limited.
+func parseMaxDelete(value string) (*int, float64, error) {
+	if value == "" {
+		return nil, 0, nil
+	}
+	if percent, ok := strings.CutSuffix(value, "%"); ok {
+		p, err := strconv.ParseFloat(percent, 64)
+		if err != nil || p < 0 || p > 100 {
+			return nil, 0, fmt.Errorf("invalid --max-delete percentage '%s', use a value between 0%% and 100%%", value)
+		}
+		if p == 0 {
+			return new(int), 0, nil
+		}
+		return nil, p, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return nil, 0, fmt.Errorf("invalid --max-delete value '%s', use a file count or a percentage such as 10%%", value)
+	}
+	return &n, 0, nil
+}
+
+// syncRelativePath returns the path, relative to the synced directory, of a key synced below
+// prefix. Like r2.Sync, it takes a prefix without a trailing slash as a directory.
+func syncRelativePath(prefix, key string) string {
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return strings.TrimPrefix(key, prefix)
+}
+
+// loadHashManifest loads the hash manifest used by --dedup uploads into a bucket.
+func loadHashManifest(bucketName string) (*r2.HashManifest, error) {
+	path, err := config.StatePath("manifest-" + bucketName + ".json")
+	if err != nil {
+		return nil, err
+	}
+	return r2.LoadHashManifest(path)
+}
+
+// syncStatePath returns the state file shared by syncs of the same directory, bucket, prefix and
+// mode. Incremental and bidirectional syncs record different things, so each mode has its own.
+func syncStatePath(localDir, bucketName, prefix, mode string) (string, error) {
+	id, err := syncID(localDir, bucketName, prefix, mode)
+	if err != nil {
+		return "", err
+	}
+	return config.StatePath("sync-" + id + ".json")
+}
+
+// syncResumePath returns the file recording the work a time-boxed sync did not get to.
+func syncResumePath(localDir, bucketName, prefix string) (string, error) {
+	id, err := syncID(localDir, bucketName, prefix, "")
+	if err != nil {
+		return "", err
+	}
+	return config.StatePath("sync-" + id + "-resume.json")
+}
+
+// syncID identifies the syncs of the same directory, bucket and prefix and, unless it is empty,
+// mode.
+func syncID(localDir, bucketName, prefix, mode string) (string, error) {
+	absDir, err := filepath.Abs(localDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve directory '%s': %w", localDir, err)
+	}
+	key := absDir + "\x00" + bucketName + "\x00" + prefix
+	if mode != "" {
+		key += "\x00" + mode
+	}
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:8]), nil
+}
+
+// syncConflictInput is shared by all prompts so buffered answers are not lost between them.
+var syncConflictInput = bufio.NewReader(os.Stdin)
+
+// askSyncConflict prompts on the terminal for how to resolve a conflicting file.
+func askSyncConflict(key string) string {
+	for {
+		fmt.Printf("'%s' changed on both sides. Keep [l]ocal, [r]emote or [s]kip? ", key)
+		answer, err := syncConflictInput.ReadString('\n')
+		if err != nil {
+			return r2.ResolveSkip
+		}
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "l", "local":
+			return r2.ResolveLocal
+		case "r", "remote":
+			return r2.ResolveRemote
+		case "s", "skip":
+			return r2.ResolveSkip
+		}
+	}
+}
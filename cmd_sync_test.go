@@ -0,0 +1,52 @@
+package main
+
+import "testing"
+
+func TestParseMaxDelete(t *testing.T) {
+	tests := []struct {
+		in          string
+		wantCount   int
+		wantLimited bool
+		wantPercent float64
+		wantErr     bool
+	}{
+		{"", 0, false, 0, false},
+		{"100", 100, true, 0, false},
+		{"0", 0, true, 0, false},
+		{"0%", 0, true, 0, false},
+		{"10%", 0, false, 10, false},
+		{"100%", 0, false, 100, false},
+		{"2.5%", 0, false, 2.5, false},
+		{"-1", 0, false, 0, true},
+		{"101%", 0, false, 0, true},
+		{"-5%", 0, false, 0, true},
+		{"ten", 0, false, 0, true},
+		{"%", 0, false, 0, true},
+	}
+	for _, tt := range tests {
+		count, percent, err := parseMaxDelete(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseMaxDelete(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if (count != nil) != tt.wantLimited || (count != nil && *count != tt.wantCount) || percent != tt.wantPercent {
+			t.Errorf("parseMaxDelete(%q) = %v, %v, want count %d (limited %v), percent %v", tt.in, count, percent, tt.wantCount, tt.wantLimited, tt.wantPercent)
+		}
+	}
+}
+
+func TestSyncRelativePath(t *testing.T) {
+	tests := []struct {
+		prefix, key, want string
+	}{
+		{"", "a.html", "a.html"},
+		{"site/", "site/a.html", "a.html"},
+		{"site", "site/a.html", "a.html"},
+		{"site", "site/docs/b.html", "docs/b.html"},
+	}
+	for _, tt := range tests {
+		if got := syncRelativePath(tt.prefix, tt.key); got != tt.want {
+			t.Errorf("syncRelativePath(%q, %q) = %q, want %q", tt.prefix, tt.key, got, tt.want)
+		}
+	}
+}
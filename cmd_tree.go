@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/baowuhe/go-cfr2/config"
+	"github.com/baowuhe/go-cfr2/r2"
+	"github.com/baowuhe/go-cfr2/utils"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// treeNode is a directory or object shown by tree. Directories carry the total size and number
+// of the objects anywhere below them.
+type treeNode struct {
+	name     string
+	dir      bool
+	size     int64
+	count    int
+	children []*treeNode
+}
+
+// handleTreeCommand lists a bucket one directory level at a time and renders it as a tree with
+// the size of every directory rolled up.
+func handleTreeCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	treeFlags := flag.NewFlagSet("tree", flag.ExitOnError)
+	bucketName := treeFlags.String("b", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	treeFlags.StringVar(bucketName, "bucket", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	prefix := treeFlags.String("p", "", "Show the tree below this directory prefix (optional)")
+	treeFlags.StringVar(prefix, "prefix", "", "Show the tree below this directory prefix (optional)")
+	depth := treeFlags.Int("L", 0, "Descend at most this many directory levels, 0 for all (optional)")
+	treeFlags.IntVar(depth, "depth", 0, "Descend at most this many directory levels, 0 for all (optional)")
+	dirsOnly := treeFlags.Bool("d", false, "Show directories only (optional)")
+	treeFlags.BoolVar(dirsOnly, "dirs-only", false, "Show directories only (optional)")
+	parseFlags(treeFlags, os.Args[2:])
+
+	if *bucketName == "" {
+		utils.ExitWithError("Bucket name not specified. Use -b or --bucket flag, or set DefaultBucket in config.")
+	}
+	if *prefix != "" && !strings.HasSuffix(*prefix, "/") {
+		*prefix += "/"
+	}
+
+	root, err := buildTree(ctx, client, *bucketName, *prefix, *depth)
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to list bucket '%s': %v", *bucketName, err))
+	}
+
+	fmt.Printf("%s (%d objects, %s)\n", *bucketName+"/"+*prefix, root.count, formatSize(root.size))
+	printTree(root.children, "", *dirsOnly)
+	fmt.Printf("\n%d object(s), %s.\n", root.count, formatSize(root.size))
+}
+
+// buildTree lists the directory prefix and its subdirectories. Below depth levels, when depth is
+// positive, subdirectories are not listed by level but walked flat to total their size.
+func buildTree(ctx context.Context, client *s3.Client, bucketName, prefix string, depth int) (*treeNode, error) {
+	node := &treeNode{name: prefix, dir: true}
+	dirs, objects, err := r2.ListDirectory(ctx, client, bucketName, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, dir := range dirs {
+		var child *treeNode
+		if depth == 1 {
+			child = &treeNode{dir: true}
+			err = r2.WalkObjects(ctx, client, bucketName, dir, func(obj types.Object) error {
+				child.size += aws.ToInt64(obj.Size)
+				if !r2.IsDirectoryMarker(obj) {
+					child.count++
+				}
+				return nil
+			})
+		} else {
+			child, err = buildTree(ctx, client, bucketName, dir, depth-1)
+		}
+		if err != nil {
+			return nil, err
+		}
+		child.name = strings.TrimPrefix(dir, prefix)
+		node.children = append(node.children, child)
+		node.size += child.size
+		node.count += child.count
+	}
+	for _, obj := range objects {
+		if *obj.Key == prefix {
+			// The directory marker of the directory itself.
+			continue
+		}
+		size := aws.ToInt64(obj.Size)
+		node.children = append(node.children, &treeNode{name: strings.TrimPrefix(*obj.Key, prefix), size: size})
+		node.size += size
+		node.count++
+	}
+
+	sort.Slice(node.children, func(i, j int) bool { return node.children[i].name < node.children[j].name })
+	return node, nil
+}
+
+// printTree prints nodes with box-drawing branches, each line starting with indent.
+func printTree(nodes []*treeNode, indent string, dirsOnly bool) {
+	if dirsOnly {
+		var dirs []*treeNode
+		for _, node := range nodes {
+			if node.dir {
+				dirs = append(dirs, node)
+			}
+		}
+		nodes = dirs
+	}
+
+	for i, node := range nodes {
+		branch, nextIndent := "├── ", indent+"│   "
+		if i == len(nodes)-1 {
+			branch, nextIndent = "└── ", indent+"    "
+		}
+		if node.dir {
+			fmt.Printf("%s%s%s (%d objects, %s)\n", indent, branch, node.name, node.count, formatSize(node.size))
+			printTree(node.children, nextIndent, dirsOnly)
+		} else {
+			fmt.Printf("%s%s%s (%s)\n", indent, branch, node.name, formatSize(node.size))
+		}
+	}
+}
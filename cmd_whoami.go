@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/baowuhe/go-cfr2/cfapi"
+	"github.com/baowuhe/go-cfr2/config"
+	"github.com/baowuhe/go-cfr2/r2"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// tokenExpiryWarning is how long before its expiry an API token is reported as expiring soon.
+const tokenExpiryWarning = 7 * 24 * time.Hour
+
+func handleWhoamiCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	whoamiFlags := flag.NewFlagSet("whoami", flag.ExitOnError)
+	bucketName := whoamiFlags.String("b", cfg.DefaultBucket, "Check access to this bucket (optional)")
+	whoamiFlags.StringVar(bucketName, "bucket", cfg.DefaultBucket, "Check access to this bucket (optional)")
+	checkWrite := whoamiFlags.Bool("write", false, "Also check write access by uploading and deleting a probe object (optional)")
+	parseFlags(whoamiFlags, os.Args[2:])
+
+	fmt.Printf("Account ID:     %s\n", cfg.AccountID)
+	fmt.Printf("Endpoint:       %s\n", strings.TrimSuffix(r2.GetR2BucketURL(cfg.AccountID, ""), "/"))
+	fmt.Printf("Access key ID:  %s\n", cfg.AccessKeyID)
+	fmt.Printf("Default bucket: %s\n", cfg.DefaultBucket)
+	if cfg.APIToken == "" {
+		fmt.Println("API token:      not configured")
+	} else {
+		fmt.Printf("API token:      %s\n", describeAPIToken(ctx, cfg))
+	}
+
+	buckets := []string{}
+	resp, err := client.ListBuckets(ctx, &s3.ListBucketsInput{})
+	if err != nil {
+		fmt.Printf("\nListing buckets failed: %v\n", err)
+		if hint := r2.AuthErrorHint(err); hint != "" {
+			fmt.Printf("Hint: %s\n", hint)
+		}
+	} else {
+		for _, bucket := range resp.Buckets {
+			buckets = append(buckets, aws.ToString(bucket.Name))
+		}
+		fmt.Printf("\nThe credentials can list %d bucket(s).\n", len(buckets))
+	}
+	if *bucketName != "" && !slices.Contains(buckets, *bucketName) {
+		// Keys limited to some buckets cannot list any, but may still reach this one.
+		buckets = append(buckets, *bucketName)
+	}
+	if len(buckets) == 0 {
+		return
+	}
+
+	fmt.Println("\nAccess:")
+	failed := false
+	for _, bucket := range buckets {
+		access := checkBucketAccess(ctx, client, bucket, *checkWrite)
+		fmt.Printf("  %-40s %s\n", bucket, access)
+		if access != "read ok" && access != "read ok, write ok" {
+			failed = true
+		}
+	}
+	if failed {
+		fmt.Println("\nBuckets the credentials cannot access need a token with permissions for them, see the Cloudflare dashboard under R2 > Manage API tokens.")
+	}
+}
+
+// describeAPIToken reports the status and expiry of the configured Cloudflare API token.
+func describeAPIToken(ctx context.Context, cfg *config.R2Config) string {
+	status, err := cfapi.NewClient(cfg.AccountID, cfg.APIToken).VerifyToken(ctx)
+	if err != nil {
+		return fmt.Sprintf("could not be verified: %v", err)
+	}
+	description := status.Status
+	if !status.ExpiresOn.IsZero() {
+		remaining := time.Until(status.ExpiresOn)
+		switch {
+		case remaining <= 0:
+			description += fmt.Sprintf(", expired on %s", status.ExpiresOn.Format(time.RFC3339))
+		case remaining < tokenExpiryWarning:
+			description += fmt.Sprintf(", EXPIRES SOON on %s (in %s)", status.ExpiresOn.Format(time.RFC3339), remaining.Round(time.Minute))
+		default:
+			description += fmt.Sprintf(", expires on %s", status.ExpiresOn.Format(time.RFC3339))
+		}
+	}
+	return description
+}
+
+// checkBucketAccess tries to read, and optionally write, a bucket and describes the outcome.
+func checkBucketAccess(ctx context.Context, client *s3.Client, bucketName string, checkWrite bool) string {
+	_, err := client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{Bucket: &bucketName, MaxKeys: aws.Int32(1)})
+	if err != nil {
+		return "read " + describeAccessError(err)
+	}
+	if !checkWrite {
+		return "read ok"
+	}
+
+	suffix := make([]byte, 8)
+	rand.Read(suffix)
+	probeKey := ".go-cfr2-whoami-" + hex.EncodeToString(suffix)
+	_, err = client.PutObject(ctx, &s3.PutObjectInput{Bucket: &bucketName, Key: &probeKey, Body: strings.NewReader("")})
+	if err != nil {
+		return "read ok, write " + describeAccessError(err)
+	}
+	if err := r2.DeleteObject(ctx, client, bucketName, probeKey); err != nil {
+		return fmt.Sprintf("read ok, write ok, delete %s (remove '%s' by hand)", describeAccessError(err), probeKey)
+	}
+	return "read ok, write ok"
+}
+
+func describeAccessError(err error) string {
+	switch {
+	case isAPIErrorCode(err, "NoSuchBucket"):
+		return "failed, no such bucket"
+	case r2.AuthErrorHint(err) != "":
+		return "denied"
+	default:
+		return fmt.Sprintf("error: %v", err)
+	}
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/baowuhe/go-cfr2/config"
+	"github.com/baowuhe/go-cfr2/utils"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// workerTemplateData holds the settings baked into a generated Worker.
+type workerTemplateData struct {
+	Name         string
+	Bucket       string
+	Prefix       string
+	CanaryPrefix string
+	CanaryMarker string
+	SlotPointer  string
+	Binding      string
+	Index        string
+	NotFound     string
+	CacheControl string
+	Signed       bool
+	// LinksNamespace is the Workers KV namespace of short links, for the redirect Worker.
+	LinksNamespace string
+	Date           string
+}
+
+var workerBindingPattern = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+// workerScriptTemplate serves objects below a prefix with directory indexes, precompressed
+// variants uploaded by --precompress, long-lived caching of fingerprinted assets and the
+// Workers cache in front of R2. Once 'deploy switch' made a blue/green slot active, the objects are
+// served from the slot instead. While a canary deployed with 'deploy --canary' is in rotation, a
+// sticky share of visitors is served from the canary prefix instead. With --signed, only requests
+// carrying a signature made by 'share' are served.
+var workerScriptTemplate = template.Must(template.New("worker.js").Parse(`// Generated by go-cfr2 worker generate on {{.Date}}.
+// Serves the objects of R2 bucket '{{js .Bucket}}' below prefix '{{js .Prefix}}'.
+
+const PREFIX = "{{js .Prefix}}";
+const INDEX = "{{js .Index}}";
+const NOT_FOUND = "{{js .NotFound}}";
+const DEFAULT_CACHE_CONTROL = "{{js .CacheControl}}";
+const IMMUTABLE_CACHE_CONTROL = "public, max-age=31536000, immutable";
+// Names such as app.3f9a2c1d.js, as written by deploy fingerprinting, never change content.
+const FINGERPRINTED = /\.[0-9a-f]{8}\.[^./]+$/;
+// Precompressed variants uploaded next to their originals, in order of preference.
+const ENCODINGS = [["br", ".br"], ["gzip", ".gz"]];
+// Written by deploy --canary; holds the share of visitors served from the canary prefix.
+const CANARY_PREFIX = "{{js .CanaryPrefix}}";
+const CANARY_MARKER_NAME = "{{js .CanaryMarker}}";
+const CANARY_MARKER = CANARY_PREFIX + CANARY_MARKER_NAME;
+const CANARY_COOKIE = "cfr2-canary";
+// Written by deploy switch; holds the prefix of the active blue/green slot.
+const SLOT_POINTER = "{{js .SlotPointer}}";
+// How long each isolate caches the canary marker and the slot pointer, in milliseconds.
+const CHECK_INTERVAL = 30000;
+let canary = { percent: 0, checked: 0 };
+let slot = { prefix: PREFIX, checked: 0 };
+{{- if .Signed}}
+// Holds a signature made by go-cfr2 share --signed-cookie, so that pages can load their assets.
+const SHARE_COOKIE = "cfr2-share";
+{{- end}}
+
+export default {
+  async fetch(request, env, ctx) {
+    if (request.method !== "GET" && request.method !== "HEAD") {
+      return new Response("Method Not Allowed", { status: 405, headers: { allow: "GET, HEAD" } });
+    }
+{{- if .Signed}}
+    if (!env.SHARE_SECRET) {
+      return new Response("SHARE_SECRET is not set, see 'npx wrangler secret put SHARE_SECRET'", { status: 500 });
+    }
+    const share = await checkShare(request, env);
+    if (share === null) {
+      return new Response("Forbidden", { status: 403 });
+    }
+    const response = await handle(request, env, ctx);
+    return share.cookie === null ? response : withCookie(response, share.cookie);
+{{- else}}
+    return handle(request, env, ctx);
+{{- end}}
+  },
+};
+{{- if .Signed}}
+
+// checkShare returns the grant of a valid signature in the query or the share cookie, or null. A
+// signature covers a single path or, made with --signed-cookie, every path below a prefix, which is
+// then kept in a cookie.
+async function checkShare(request, env) {
+  const url = new URL(request.url);
+  const path = decodeURIComponent(url.pathname.slice(1));
+  let expires = url.searchParams.get("expires");
+  let sig = url.searchParams.get("sig");
+  let scope = url.searchParams.get("scope");
+  const fromQuery = sig !== null;
+  if (!fromQuery) {
+    const cookie = (request.headers.get("cookie") || "").match(new RegExp("(?:^|;\\s*)" + SHARE_COOKIE + "=(\\d+)\\.([0-9a-f]+)\\.([^;]*)"));
+    if (cookie === null) {
+      return null;
+    }
+    [, expires, sig, scope] = cookie;
+    scope = decodeURIComponent(scope);
+  }
+  const remaining = Number(expires) - Math.floor(Date.now() / 1000);
+  if (!(remaining > 0) || !/^[0-9a-f]{64}$/.test(sig)) {
+    return null;
+  }
+  if (scope !== null && !((scope === "" || scope.endsWith("/")) && path.startsWith(scope))) {
+    return null;
+  }
+  const message = scope === null ? "path\n" + path + "\n" + expires : "prefix\n" + scope + "\n" + expires;
+  const key = await crypto.subtle.importKey("raw", new TextEncoder().encode(env.SHARE_SECRET), { name: "HMAC", hash: "SHA-256" }, false, ["verify"]);
+  const signature = new Uint8Array(sig.match(/../g).map((byte) => parseInt(byte, 16)));
+  if (!(await crypto.subtle.verify("HMAC", key, signature, new TextEncoder().encode(message)))) {
+    return null;
+  }
+  if (!fromQuery || scope === null) {
+    return { cookie: null };
+  }
+  return { cookie: SHARE_COOKIE + "=" + expires + "." + sig + "." + encodeURIComponent(scope) + "; Path=/; Max-Age=" + remaining + "; Secure; HttpOnly; SameSite=Lax" };
+}
+{{- end}}
+
+// handle serves a request from the bucket.
+async function handle(request, env, ctx) {
+  const url = new URL(request.url);
+  let path = decodeURIComponent(url.pathname.slice(1));
+  if (path === "" || path.endsWith("/")) {
+    path += INDEX;
+  }
+  // The canary marker configures the Worker and is not part of the site.
+  if (path.split("/").pop() === CANARY_MARKER_NAME) {
+    return new Response("Not Found", { status: 404 });
+  }
+
+  let prefix = await slotPrefix(env);
+  let assigned = null;
+  const percent = await canaryPercent(env);
+  if (percent > 0) {
+    // Visitors stay on the side they were first assigned to.
+    const cookie = (request.headers.get("cookie") || "").match(new RegExp("(?:^|;\\s*)" + CANARY_COOKIE + "=([01])"));
+    const inCanary = cookie !== null ? cookie[1] === "1" : Math.random() * 100 < percent;
+    if (cookie === null) {
+      assigned = inCanary ? "1" : "0";
+    }
+    if (inCanary) {
+      prefix = CANARY_PREFIX;
+    }
+  }
+
+  const accept = request.headers.get("accept-encoding") || "";
+  const encodings = ENCODINGS.filter(([name]) => accept.includes(name));
+  const cacheKey = new Request(url.toString() + "#" + prefix + "#" + encodings.map(([name]) => name).join(","), request);
+  const cache = caches.default;
+  if (request.method === "GET") {
+    const cached = await cache.match(cacheKey);
+    if (cached) {
+      return withCanaryCookie(cached, assigned);
+    }
+  }
+
+  let response = await serve(request, env, prefix, path, encodings);
+  if (response === null && !url.pathname.endsWith("/")) {
+    // A directory requested without its trailing slash.
+    if (await env.{{.Binding}}.head(prefix + path + "/" + INDEX)) {
+      url.pathname += "/";
+      return withCanaryCookie(Response.redirect(url.toString(), 301), assigned);
+    }
+  }
+  if (response === null && NOT_FOUND !== "") {
+    response = await serve(request, env, prefix, NOT_FOUND, encodings, 404);
+  }
+  if (response === null) {
+    return withCanaryCookie(new Response("Not Found", { status: 404 }), assigned);
+  }
+
+  if (request.method === "GET" && response.status === 200) {
+    ctx.waitUntil(cache.put(cacheKey, response.clone()));
+  }
+  return withCanaryCookie(response, assigned);
+}
+
+// canaryPercent returns the share of visitors served from CANARY_PREFIX, or 0 without a canary.
+async function canaryPercent(env) {
+  if (Date.now() - canary.checked > CHECK_INTERVAL) {
+    const marker = await env.{{.Binding}}.get(CANARY_MARKER);
+    const percent = marker === null ? 0 : Number((await marker.json()).percent) || 0;
+    canary = { percent, checked: Date.now() };
+  }
+  return canary.percent;
+}
+
+// slotPrefix returns the prefix of the active slot, or PREFIX if no slot was switched to.
+async function slotPrefix(env) {
+  if (Date.now() - slot.checked > CHECK_INTERVAL) {
+    const pointer = await env.{{.Binding}}.get(SLOT_POINTER);
+    const prefix = pointer === null ? PREFIX : (await pointer.json()).prefix;
+    slot = { prefix: typeof prefix === "string" ? prefix : PREFIX, checked: Date.now() };
+  }
+  return slot.prefix;
+}
+
+// withCanaryCookie remembers the side a new visitor was assigned to, if any.
+function withCanaryCookie(response, assigned) {
+  if (assigned === null) {
+    return response;
+  }
+  return withCookie(response, CANARY_COOKIE + "=" + assigned + "; Path=/; Max-Age=86400; SameSite=Lax");
+}
+
+// withCookie returns a copy of response setting a cookie, as responses from the cache are immutable.
+function withCookie(response, cookie) {
+  response = new Response(response.body, response);
+  response.headers.append("set-cookie", cookie);
+  return response;
+}
+
+// serve returns the object at path below prefix, preferring an accepted precompressed variant, or null if it does not exist.
+async function serve(request, env, prefix, path, encodings, status = 200) {
+  const candidates = encodings.map(([name, suffix]) => [name, path + suffix]).concat([[null, path]]);
+  for (const [encoding, key] of candidates) {
+    const object = request.method === "HEAD"
+      ? await env.{{.Binding}}.head(prefix + key)
+      : await env.{{.Binding}}.get(prefix + key, { onlyIf: request.headers });
+    if (object === null) {
+      continue;
+    }
+
+    const headers = new Headers();
+    object.writeHttpMetadata(headers);
+    headers.set("etag", object.httpEtag);
+    headers.set("vary", "accept-encoding");
+    if (!headers.has("cache-control")) {
+      headers.set("cache-control", FINGERPRINTED.test(path) ? IMMUTABLE_CACHE_CONTROL : DEFAULT_CACHE_CONTROL);
+    }
+    if (encoding !== null) {
+      headers.set("content-encoding", encoding);
+    }
+
+    if (!("body" in object) || request.method === "HEAD") {
+      // HEAD, or a GET whose If-None-Match or If-Modified-Since precondition matched.
+      return new Response(null, { status: request.method === "HEAD" ? status : 304, headers });
+    }
+    // The variant is already compressed, the runtime must not compress it again.
+    return new Response(object.body, { status, headers, encodeBody: encoding !== null ? "manual" : "automatic" });
+  }
+  return null;
+}
+`))
+
+var wranglerTemplate = template.Must(template.New("wrangler.toml").Parse(`name = "{{.Name}}"
+main = "worker.js"
+compatibility_date = "{{.Date}}"
+
+[[r2_buckets]]
+binding = "{{.Binding}}"
+bucket_name = "{{.Bucket}}"
+`))
+
+// shortLinkScriptTemplate redirects the short links stored in Workers KV by 'share --short'.
+var shortLinkScriptTemplate = template.Must(template.New("worker.js").Parse(`// Generated by go-cfr2 worker generate --short-links on {{.Date}}.
+// Redirects the short links created by 'go-cfr2 share --short'.
+
+export default {
+  async fetch(request, env) {
+    const code = new URL(request.url).pathname.slice(1);
+    const target = code && (await env.LINKS.get(code));
+    if (!target) {
+      return new Response("Not Found", { status: 404 });
+    }
+    return new Response(null, { status: 302, headers: { location: target, "cache-control": "no-store" } });
+  },
+};
+`))
+
+var shortLinkWranglerTemplate = template.Must(template.New("wrangler.toml").Parse(`name = "{{.Name}}"
+main = "worker.js"
+compatibility_date = "{{.Date}}"
+
+[[kv_namespaces]]
+binding = "LINKS"
+id = "{{.LinksNamespace}}"
+`))
+
+func handleWorkerCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	if len(os.Args) < 3 {
+		utils.ExitWithError("Worker subcommand not specified. Use 'worker generate'.")
+	}
+
+	switch os.Args[2] {
+	case "generate":
+		handleWorkerGenerateCommand(ctx, client, cfg)
+	default:
+		utils.ExitWithError(fmt.Sprintf("Unknown worker subcommand '%s'. Use 'worker generate'.", os.Args[2]))
+	}
+}
+
+func handleWorkerGenerateCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	generateFlags := flag.NewFlagSet("worker generate", flag.ExitOnError)
+	bucketName := generateFlags.String("b", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	generateFlags.StringVar(bucketName, "bucket", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	prefix := generateFlags.String("p", "", "Serve the objects below this key prefix (optional)")
+	generateFlags.StringVar(prefix, "prefix", "", "Serve the objects below this key prefix (optional)")
+	outputDir := generateFlags.String("o", "worker", "Specify the directory worker.js and wrangler.toml are written to (optional)")
+	generateFlags.StringVar(outputDir, "output", "worker", "Specify the directory worker.js and wrangler.toml are written to (optional)")
+	name := generateFlags.String("name", "", "Specify the Worker name (optional)")
+	binding := generateFlags.String("binding", "BUCKET", "Specify the name of the R2 binding (optional)")
+	index := generateFlags.String("index", "index.html", "Specify the object served for directory paths (optional)")
+	notFound := generateFlags.String("not-found", "", "Specify the key, relative to the prefix, served with status 404 for missing objects (optional)")
+	cacheControl := generateFlags.String("cache-control", "public, max-age=3600", "Specify the Cache-Control header of objects without their own (optional)")
+	signed := generateFlags.Bool("signed", false, "Only serve requests signed by 'go-cfr2 share' with the SHARE_SECRET secret (optional)")
+	shortLinks := generateFlags.Bool("short-links", false, "Write the Worker redirecting the short links of 'go-cfr2 share --short' instead (optional)")
+	force := generateFlags.Bool("force", false, "Overwrite existing files (optional)")
+	parseFlags(generateFlags, os.Args[3:])
+
+	if *shortLinks {
+		if *signed {
+			utils.ExitWithError("--short-links writes the redirect Worker and cannot be used with --signed.")
+		}
+		if cfg.ShortLinkNamespaceID == "" {
+			utils.ExitWithError("ShortLinkNamespaceID not set in config. Create a Workers KV namespace for the short links first.")
+		}
+		if *name == "" {
+			*name = "cfr2-links"
+		}
+		data := workerTemplateData{Name: *name, LinksNamespace: cfg.ShortLinkNamespaceID, Date: time.Now().Format("2006-01-02")}
+		writeWorkerFiles(*outputDir, []*template.Template{shortLinkScriptTemplate, shortLinkWranglerTemplate}, data, *force)
+		fmt.Printf("Successfully generated short link Worker '%s'. Deploy it with 'npx wrangler deploy' in '%s' on the domain of ShortLinkBaseURL.\n", *name, *outputDir)
+		return
+	}
+
+	if *bucketName == "" {
+		utils.ExitWithError("Bucket name not specified. Use -b or --bucket flag, or set DefaultBucket in config.")
+	}
+	if !workerBindingPattern.MatchString(*binding) {
+		utils.ExitWithError(fmt.Sprintf("Invalid binding name '%s'. Use a JavaScript identifier such as BUCKET.", *binding))
+	}
+	if *index == "" {
+		utils.ExitWithError("Index object name must not be empty.")
+	}
+	// The Worker appends request paths to the prefix, so it has to end at a directory.
+	if *prefix != "" && !strings.HasSuffix(*prefix, "/") {
+		*prefix += "/"
+	}
+	if *name == "" {
+		*name = "cfr2-" + *bucketName
+	}
+
+	data := workerTemplateData{
+		Name:         *name,
+		Bucket:       *bucketName,
+		Prefix:       *prefix,
+		CanaryPrefix: canaryPrefixFor(*prefix),
+		CanaryMarker: canaryMarkerName,
+		SlotPointer:  slotPointerKeyFor(*prefix),
+		Binding:      *binding,
+		Index:        *index,
+		NotFound:     *notFound,
+		CacheControl: *cacheControl,
+		Signed:       *signed,
+		Date:         time.Now().Format("2006-01-02"),
+	}
+
+	writeWorkerFiles(*outputDir, []*template.Template{workerScriptTemplate, wranglerTemplate}, data, *force)
+	fmt.Printf("Successfully generated Worker '%s' for bucket '%s'. Deploy it with 'npx wrangler deploy' in '%s'.\n", *name, *bucketName, *outputDir)
+	if *signed {
+		fmt.Println("Note: set the ShareSecret of your config as its secret with 'npx wrangler secret put SHARE_SECRET', and its custom domain as ShareBaseURL.")
+	}
+}
+
+// writeWorkerFiles renders the templates into outputDir, exiting on the first failure.
+func writeWorkerFiles(outputDir string, templates []*template.Template, data workerTemplateData, force bool) {
+	if err := os.MkdirAll(outputDir, 0o755); err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to create directory '%s': %v", outputDir, err))
+	}
+	for _, tmpl := range templates {
+		path := filepath.Join(outputDir, tmpl.Name())
+		if err := writeWorkerFile(path, tmpl, data, force); err != nil {
+			utils.ExitWithError(err.Error())
+		}
+		fmt.Printf("Wrote '%s'.\n", path)
+	}
+}
+
+// writeWorkerFile renders a template into path, refusing to replace an existing file unless force is set.
+func writeWorkerFile(path string, tmpl *template.Template, data workerTemplateData, force bool) error {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if !force {
+		flags |= os.O_EXCL
+	}
+	file, err := os.OpenFile(path, flags, 0o644)
+	if errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("'%s' already exists. Use --force to overwrite it.", path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create '%s': %w", path, err)
+	}
+	if err := tmpl.Execute(file, data); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write '%s': %w", path, err)
+	}
+	return file.Close()
+}
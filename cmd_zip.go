@@ -0,0 +1,139 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/baowuhe/go-cfr2/config"
+	"github.com/baowuhe/go-cfr2/r2"
+	"github.com/baowuhe/go-cfr2/utils"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// handleZipCommand streams the objects below a prefix into a zip archive, written to a local file
+// or uploaded back to R2, so that a whole directory can be handed over as a single file.
+func handleZipCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	zipFlags := flag.NewFlagSet("zip", flag.ExitOnError)
+	bucketName := zipFlags.String("b", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	zipFlags.StringVar(bucketName, "bucket", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
+	prefix := zipFlags.String("p", "", "Specify the key prefix of the objects to archive (required)")
+	zipFlags.StringVar(prefix, "prefix", "", "Specify the key prefix of the objects to archive (required)")
+	output := zipFlags.String("o", "", "Specify the local archive path, or r2://bucket/key to upload it (required)")
+	zipFlags.StringVar(output, "output", "", "Specify the local archive path, or r2://bucket/key to upload it (required)")
+	store := zipFlags.Bool("store", false, "Store objects without compressing them, e.g. for images or archives (optional)")
+	parseFlags(zipFlags, os.Args[2:])
+
+	if *bucketName == "" {
+		utils.ExitWithError("Bucket name not specified. Use -b or --bucket flag, or set DefaultBucket in config.")
+	}
+	if *prefix == "" {
+		utils.ExitWithError("Prefix not specified. Use -p or --prefix flag.")
+	}
+	if *output == "" {
+		utils.ExitWithError("Output not specified. Use -o or --output flag.")
+	}
+	dstBucket, dstKey, dstRemote, err := parseR2URI(*output)
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Invalid output: %v.", err))
+	}
+	if dstRemote && (dstKey == "" || strings.HasSuffix(dstKey, "/")) {
+		utils.ExitWithError(fmt.Sprintf("'%s' does not name an object.", *output))
+	}
+
+	// Entries are named relative to the directory of the prefix, so that -p reports/2024/ gives
+	// entries such as jan.pdf and -p reports/2024 entries such as 2024/jan.pdf.
+	base := (*prefix)[:strings.LastIndex(*prefix, "/")+1]
+	var objects []types.Object
+	err = r2.WalkObjects(ctx, client, *bucketName, *prefix, func(obj types.Object) error {
+		if r2.IsDirectoryMarker(obj) {
+			return nil
+		}
+		if name := strings.TrimPrefix(*obj.Key, base); !fs.ValidPath(name) {
+			utils.Warnf("skipping '%s', which is not a valid archive entry name.", *obj.Key)
+			return nil
+		}
+		objects = append(objects, obj)
+		return nil
+	})
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to list objects in bucket '%s': %v", *bucketName, err))
+	}
+	if len(objects) == 0 {
+		utils.ExitWithError(fmt.Sprintf("No objects found below prefix '%s' in bucket '%s'.", *prefix, *bucketName))
+	}
+
+	method := zip.Deflate
+	if *store {
+		method = zip.Store
+	}
+	write := func(w io.Writer) error {
+		zw := zip.NewWriter(w)
+		for i, obj := range objects {
+			fmt.Printf("[%d/%d] Adding '%s' (%s)\n", i+1, len(objects), *obj.Key, formatSize(aws.ToInt64(obj.Size)))
+			entry, err := zw.CreateHeader(&zip.FileHeader{
+				Name:     strings.TrimPrefix(*obj.Key, base),
+				Method:   method,
+				Modified: aws.ToTime(obj.LastModified),
+			})
+			if err != nil {
+				return err
+			}
+			body, err := r2.OpenObject(ctx, client, *bucketName, *obj.Key)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(entry, body)
+			body.Close()
+			if err != nil {
+				return fmt.Errorf("failed to read object '%s': %w", *obj.Key, err)
+			}
+		}
+		return zw.Close()
+	}
+
+	if dstRemote {
+		refuseAppendOnlyOverwrite(ctx, client, cfg, dstBucket, dstKey)
+		fmt.Printf("Archiving %d object(s) below '%s' to '%s' in bucket '%s'...\n", len(objects), *prefix, dstKey, dstBucket)
+
+		pr, pw := io.Pipe()
+		go func() {
+			pw.CloseWithError(write(pw))
+		}()
+		size, err := r2.UploadStreamWithOptions(ctx, client, dstBucket, dstKey, pr, r2.UploadOptions{ContentType: aws.String("application/zip"), Quiet: true})
+		// Unblocks the writer if the upload failed before reading all of the archive.
+		pr.CloseWithError(err)
+		if err != nil {
+			utils.ExitWithError(fmt.Sprintf("Failed to create archive '%s': %v", *output, err))
+		}
+		fmt.Printf("Successfully archived %d object(s) to '%s' (%s).\n", len(objects), *output, formatSize(size))
+		return
+	}
+
+	fmt.Printf("Archiving %d object(s) below '%s' to '%s'...\n", len(objects), *prefix, *output)
+	file, err := os.Create(*output)
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to create archive '%s': %v", *output, err))
+	}
+	err = write(file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(*output)
+		utils.ExitWithError(fmt.Sprintf("Failed to create archive '%s': %v", *output, err))
+	}
+	stat, err := os.Stat(*output)
+	if err != nil {
+		utils.ExitWithError(err.Error())
+	}
+	fmt.Printf("Successfully archived %d object(s) to '%s' (%s).\n", len(objects), *output, formatSize(stat.Size()))
+}
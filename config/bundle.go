@@ -0,0 +1,132 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+// bundleVersion identifies the format of encrypted config bundles.
+const bundleVersion = 1
+
+// bundleKDFIterations is the PBKDF2 work factor protecting bundle passphrases.
+const bundleKDFIterations = 600000
+
+// ErrBundleExpired is returned when importing a bundle past its expiry.
+var ErrBundleExpired = errors.New("config bundle has expired")
+
+// Bundle is the content of a config bundle shared with teammates.
+type Bundle struct {
+	AccountID       string    `json:"account_id"`
+	AccessKeyID     string    `json:"access_key_id"`
+	SecretAccessKey string    `json:"secret_access_key"`
+	DefaultBucket   string    `json:"default_bucket"`
+	ReadOnly        bool      `json:"read_only"`
+	ExpiresAt       time.Time `json:"expires_at"`
+}
+
+// sealedBundle is the encrypted form of a Bundle written to disk.
+type sealedBundle struct {
+	Version    int    `json:"version"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// SealBundle encrypts a bundle with a key derived from passphrase, using AES-256-GCM.
+func SealBundle(bundle Bundle, passphrase string) ([]byte, error) {
+	plaintext, err := json.Marshal(bundle)
+	if err != nil {
+		return nil, err
+	}
+	sealed := sealedBundle{Version: bundleVersion, Salt: make([]byte, 16)}
+	rand.Read(sealed.Salt)
+	aead, err := bundleAEAD(passphrase, sealed.Salt)
+	if err != nil {
+		return nil, err
+	}
+	sealed.Nonce = make([]byte, aead.NonceSize())
+	rand.Read(sealed.Nonce)
+	sealed.Ciphertext = aead.Seal(nil, sealed.Nonce, plaintext, nil)
+	return json.MarshalIndent(sealed, "", "  ")
+}
+
+// OpenBundle decrypts a bundle and checks that it has not expired.
+func OpenBundle(data []byte, passphrase string, now time.Time) (Bundle, error) {
+	var sealed sealedBundle
+	if err := json.Unmarshal(data, &sealed); err != nil {
+		return Bundle{}, fmt.Errorf("not a config bundle: %w", err)
+	}
+	if sealed.Version != bundleVersion {
+		return Bundle{}, fmt.Errorf("unsupported config bundle version %d", sealed.Version)
+	}
+	aead, err := bundleAEAD(passphrase, sealed.Salt)
+	if err != nil {
+		return Bundle{}, err
+	}
+	plaintext, err := aead.Open(nil, sealed.Nonce, sealed.Ciphertext, nil)
+	if err != nil {
+		return Bundle{}, fmt.Errorf("failed to decrypt config bundle, check the passphrase")
+	}
+
+	var bundle Bundle
+	if err := json.Unmarshal(plaintext, &bundle); err != nil {
+		return Bundle{}, fmt.Errorf("failed to parse config bundle: %w", err)
+	}
+	if !bundle.ExpiresAt.IsZero() && now.After(bundle.ExpiresAt) {
+		return Bundle{}, fmt.Errorf("%w on %s", ErrBundleExpired, bundle.ExpiresAt.Format(time.RFC3339))
+	}
+	return bundle, nil
+}
+
+func bundleAEAD(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := pbkdf2.Key(sha256.New, passphrase, salt, bundleKDFIterations, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// FilePath returns the path of the user configuration file.
+func FilePath() string {
+	return expandPath(configFilePath)
+}
+
+// WriteBundleConfig writes the credentials of a bundle as the user configuration file. An existing
+// file is only replaced when force is set.
+func WriteBundleConfig(bundle Bundle, force bool) (string, error) {
+	path := FilePath()
+	if _, err := os.Stat(path); err == nil && !force {
+		return "", fmt.Errorf("config file %s already exists", path)
+	}
+	data, err := toml.Marshal(struct {
+		AccountID       string `toml:"AccountID"`
+		AccessKeyID     string `toml:"AccessKeyID"`
+		SecretAccessKey string `toml:"SecretAccessKey"`
+		DefaultBucket   string `toml:"DefaultBucket"`
+	}{bundle.AccountID, bundle.AccessKeyID, bundle.SecretAccessKey, bundle.DefaultBucket})
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return "", fmt.Errorf("failed to create config directory for %s: %w", path, err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return "", fmt.Errorf("failed to write config file %s: %w", path, err)
+	}
+	return path, nil
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/pelletier/go-toml/v2"
 )
@@ -14,10 +16,118 @@ type R2Config struct {
 	AccessKeyID     string `toml:"AccessKeyID"`
 	SecretAccessKey string `toml:"SecretAccessKey"`
 	DefaultBucket   string `toml:"DefaultBucket"`
+	// MaxOpsPerSecond limits the rate of API requests. Zero means unlimited.
+	MaxOpsPerSecond float64 `toml:"MaxOpsPerSecond"`
+	// AppendOnlyPrefixes lists key prefixes, in any bucket, whose objects must never be deleted or overwritten.
+	AppendOnlyPrefixes []string `toml:"AppendOnlyPrefixes"`
+	// Aliases maps names to command lines, so that 'go-cfr2 <name> [args]' runs the command line
+	// with args appended. They are written as alias.<name> = '<command line>'.
+	Aliases map[string]string `toml:"alias"`
+	// Defaults holds flag values applied before the command line is parsed, by command, written as
+	// [defaults.<command>] tables such as [defaults.upload] or [defaults."deploy rollback"].
+	Defaults map[string]map[string]any `toml:"defaults"`
+	// APIToken authenticates calls to the Cloudflare API, which features beyond object storage use.
+	APIToken string `toml:"APIToken"`
+	// ShortLinkNamespaceID is the Workers KV namespace short links are stored in.
+	ShortLinkNamespaceID string `toml:"ShortLinkNamespaceID"`
+	// ShortLinkBaseURL is the URL of the Worker redirecting short links, such as https://s.example.com/.
+	ShortLinkBaseURL string `toml:"ShortLinkBaseURL"`
+	// ShareBaseURL is the custom domain of a Worker written by 'worker generate --signed', such as
+	// https://files.example.com/, which 'share' links point at.
+	ShareBaseURL string `toml:"ShareBaseURL"`
+	// ShareSecret is the key 'share' signs links with, set as the SHARE_SECRET secret of the Worker.
+	ShareSecret string `toml:"ShareSecret"`
+	// Profiles holds the credentials of further accounts, written as [profile.<name>] tables. They are
+	// selected with the --profile flag and all listed by 'accounts overview'.
+	Profiles map[string]Profile `toml:"profile"`
+	// PolicyFile is a TOML file of guardrails checked before every request, see Policy.
+	PolicyFile string `toml:"PolicyFile"`
+	// Offline stops requests beyond the operation of the command, such as measuring the clock skew
+	// before presigning. The --offline flag sets it for a single run.
+	Offline bool `toml:"Offline"`
+	// UserAgentSuffix is appended to the User-Agent of every request, e.g. 'team/infra', to attribute
+	// traffic on the Cloudflare side.
+	UserAgentSuffix string `toml:"UserAgentSuffix"`
+	// Policy is loaded from PolicyFile.
+	Policy *Policy `toml:"-"`
+	// Server configures access logging and authentication of the server modes, written as a
+	// [server] table.
+	Server ServerConfig `toml:"server"`
+	// CaptureSessionPath, set by the --capture-session flag, is the file a record of the run's
+	// requests is written to.
+	CaptureSessionPath string `toml:"-"`
+	// Command is the command being run, reported in the User-Agent of requests.
+	Command string `toml:"-"`
+	// RequestID, set by the --request-id flag or CFR2_REQUEST_ID, correlates the run's requests and
+	// log lines with whatever triggered them. See r2.WithRequestID.
+	RequestID string `toml:"-"`
+	// Strict, set by the --strict flag or CFR2_STRICT, makes conditions that are normally warnings
+	// fail the command. See utils.Warnf.
+	Strict bool `toml:"-"`
+	// InjectFaults, set by the hidden --inject-faults flag or CFR2_INJECT_FAULTS, makes a share of
+	// requests fail or stall, for testing retry and resume settings. See r2.ParseFaultSpec.
+	InjectFaults string `toml:"-"`
+	// LiteralKeys, set by the --literal flag, takes every -k value as a key, never as a glob.
+	LiteralKeys bool `toml:"-"`
+}
+
+// ServerConfig configures the server modes, such as 'serve'. Without any of Users, BearerTokens,
+// Tokens and AllowIPs, every client is served.
+type ServerConfig struct {
+	// AccessLog is the file JSON access log lines are appended to. Empty logs to stdout, "off" disables logging.
+	AccessLog string `toml:"AccessLog"`
+	// Users maps user names to passwords accepted with basic auth. A password may be given as
+	// "sha256:<hex digest>" instead of in plain text.
+	Users map[string]string `toml:"Users"`
+	// BearerTokens lists the tokens accepted in an "Authorization: Bearer" header.
+	BearerTokens []string `toml:"BearerTokens"`
+	// Tokens lists bearer tokens restricted to some buckets and a key prefix, written as
+	// [[server.token]] tables, so that one server can be shared by several teams.
+	Tokens []ServerToken `toml:"token"`
+	// AllowIPs lists the addresses and CIDR ranges clients may connect from.
+	AllowIPs []string `toml:"AllowIPs"`
+	// TrustedProxies lists the addresses and CIDR ranges of the proxies in front of the server.
+	// X-Forwarded-For is only read from these, and the client is its rightmost untrusted hop.
+	TrustedProxies []string `toml:"TrustedProxies"`
+}
+
+// ServerToken is a bearer token that may only read keys below Prefix in buckets matching Bucket.
+type ServerToken struct {
+	// Name identifies the token in the access log.
+	Name  string `toml:"Name"`
+	Token string `toml:"Token"`
+	// Bucket is a glob of the bucket names the token may read, empty for any bucket.
+	Bucket string `toml:"Bucket"`
+	// Prefix is the directory the token may read below, e.g. "team-a/"; a missing trailing slash
+	// is implied, so that "team-a" does not cover "team-ab/".
+	Prefix string `toml:"Prefix"`
+	// Operations lists the request methods the token may use, such as "GET" and "HEAD", empty
+	// for all of them.
+	Operations []string `toml:"Operations"`
+}
+
+// Profile is the configuration of another account.
+type Profile struct {
+	AccountID       string `toml:"AccountID"`
+	AccessKeyID     string `toml:"AccessKeyID"`
+	SecretAccessKey string `toml:"SecretAccessKey"`
+	// DefaultBucket replaces the top-level DefaultBucket when set.
+	DefaultBucket string `toml:"DefaultBucket"`
 }
 
 const configFilePath = "~/.local/cfg/cfr2.toml"
 
+const stateDirPath = "~/.local/state/cfr2"
+
+// StatePath returns the path of a state file kept between runs, creating the state directory if needed.
+func StatePath(name string) (string, error) {
+	dir := expandPath(stateDirPath)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", fmt.Errorf("failed to create state directory %s: %w", dir, err)
+	}
+	return filepath.Join(dir, name), nil
+}
+
 // LoadConfig loads the R2 configuration from a TOML file or environment variables.
 // TOML file takes precedence over environment variables.
 func LoadConfig() (*R2Config, error) {
@@ -36,36 +146,143 @@ func LoadConfig() (*R2Config, error) {
 	}
 
 	// 2. Override with environment variables (if set and TOML didn't provide them)
-	if os.Getenv("CFR2_ACCOUNT_ID") != "" {
-		cfg.AccountID = os.Getenv("CFR2_ACCOUNT_ID")
+	if value, ok, err := envOrFile("CFR2_ACCOUNT_ID"); err != nil {
+		return nil, err
+	} else if ok {
+		cfg.AccountID = value
 	}
-	if os.Getenv("CFR2_ACCESS_KEY_ID") != "" {
-		cfg.AccessKeyID = os.Getenv("CFR2_ACCESS_KEY_ID")
+	if value, ok, err := envOrFile("CFR2_ACCESS_KEY_ID"); err != nil {
+		return nil, err
+	} else if ok {
+		cfg.AccessKeyID = value
 	}
-	if os.Getenv("CFR2_SECRET_ACCESS_KEY") != "" {
-		cfg.SecretAccessKey = os.Getenv("CFR2_SECRET_ACCESS_KEY")
+	if value, ok, err := envOrFile("CFR2_SECRET_ACCESS_KEY"); err != nil {
+		return nil, err
+	} else if ok {
+		cfg.SecretAccessKey = value
 	}
 	if os.Getenv("CFR2_DEFAULT_BUCKET") != "" {
 		cfg.DefaultBucket = os.Getenv("CFR2_DEFAULT_BUCKET")
 	}
+	if os.Getenv("CFR2_MAX_OPS_PER_SECOND") != "" {
+		rate, err := strconv.ParseFloat(os.Getenv("CFR2_MAX_OPS_PER_SECOND"), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CFR2_MAX_OPS_PER_SECOND value: %w", err)
+		}
+		cfg.MaxOpsPerSecond = rate
+	}
+	if os.Getenv("CFR2_APPEND_ONLY_PREFIXES") != "" {
+		cfg.AppendOnlyPrefixes = strings.Split(os.Getenv("CFR2_APPEND_ONLY_PREFIXES"), ",")
+	}
+	if value, ok, err := envOrFile("CFR2_API_TOKEN"); err != nil {
+		return nil, err
+	} else if ok {
+		cfg.APIToken = value
+	}
+	if os.Getenv("CFR2_SHORT_LINK_NAMESPACE_ID") != "" {
+		cfg.ShortLinkNamespaceID = os.Getenv("CFR2_SHORT_LINK_NAMESPACE_ID")
+	}
+	if os.Getenv("CFR2_SHORT_LINK_BASE_URL") != "" {
+		cfg.ShortLinkBaseURL = os.Getenv("CFR2_SHORT_LINK_BASE_URL")
+	}
+	if os.Getenv("CFR2_SHARE_BASE_URL") != "" {
+		cfg.ShareBaseURL = os.Getenv("CFR2_SHARE_BASE_URL")
+	}
+	if value, ok, err := envOrFile("CFR2_SHARE_SECRET"); err != nil {
+		return nil, err
+	} else if ok {
+		cfg.ShareSecret = value
+	}
+	if os.Getenv("CFR2_POLICY_FILE") != "" {
+		cfg.PolicyFile = os.Getenv("CFR2_POLICY_FILE")
+	}
+	if os.Getenv("CFR2_USER_AGENT_SUFFIX") != "" {
+		cfg.UserAgentSuffix = os.Getenv("CFR2_USER_AGENT_SUFFIX")
+	}
+	if os.Getenv("CFR2_REQUEST_ID") != "" {
+		cfg.RequestID = os.Getenv("CFR2_REQUEST_ID")
+	}
+	if offline, err := strconv.ParseBool(os.Getenv("CFR2_OFFLINE")); err == nil {
+		cfg.Offline = offline
+	}
+	if strict, err := strconv.ParseBool(os.Getenv("CFR2_STRICT")); err == nil {
+		cfg.Strict = strict
+	}
+	if os.Getenv("CFR2_INJECT_FAULTS") != "" {
+		cfg.InjectFaults = os.Getenv("CFR2_INJECT_FAULTS")
+	}
 
 	// 3. Validate required fields
 	if cfg.AccountID == "" {
 		return nil, fmt.Errorf("AccountID is not set. Please provide it in %s or via CFR2_ACCOUNT_ID environment variable", expandedPath)
 	}
 	if cfg.AccessKeyID == "" {
-		return nil, fmt.Errorf("AccessKeyID is not set. Please provide it in %s or via CFR2_ACCESS_KEY_ID environment variable", expandedPath)
+		return nil, fmt.Errorf("AccessKeyID is not set. Please provide it in %s or via CFR2_ACCESS_KEY_ID or CFR2_ACCESS_KEY_ID_FILE environment variable", expandedPath)
 	}
 	if cfg.SecretAccessKey == "" {
-		return nil, fmt.Errorf("SecretAccessKey is not set. Please provide it in %s or via CFR2_SECRET_ACCESS_KEY environment variable", expandedPath)
+		return nil, fmt.Errorf("SecretAccessKey is not set. Please provide it in %s or via CFR2_SECRET_ACCESS_KEY or CFR2_SECRET_ACCESS_KEY_FILE environment variable", expandedPath)
 	}
 	if cfg.DefaultBucket == "" {
 		return nil, fmt.Errorf("DefaultBucket is not set. Please provide it in %s or via CFR2_DEFAULT_BUCKET environment variable", expandedPath)
 	}
 
+	// 4. A policy that cannot be loaded must not be silently ignored.
+	if cfg.PolicyFile != "" {
+		policy, err := LoadPolicy(cfg.PolicyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Policy = policy
+	}
+
 	return cfg, nil
 }
 
+// envOrFile returns the value of an environment variable or, for secrets mounted into containers,
+// the trimmed content of the file named by the same variable with a _FILE suffix.
+func envOrFile(name string) (string, bool, error) {
+	value, path := os.Getenv(name), os.Getenv(name+"_FILE")
+	switch {
+	case value != "" && path != "":
+		return "", false, fmt.Errorf("both %s and %s_FILE are set, use only one of them", name, name)
+	case path != "":
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", false, fmt.Errorf("failed to read %s_FILE: %w", name, err)
+		}
+		return strings.TrimSpace(string(data)), true, nil
+	}
+	return value, value != "", nil
+}
+
+// UseProfile replaces the account, credentials and default bucket with those of a profile.
+func (cfg *R2Config) UseProfile(name string) error {
+	profile, ok := cfg.Profiles[name]
+	if !ok {
+		return fmt.Errorf("profile '%s' is not defined in %s", name, expandPath(configFilePath))
+	}
+	if profile.AccountID == "" || profile.AccessKeyID == "" || profile.SecretAccessKey == "" {
+		return fmt.Errorf("profile '%s' needs AccountID, AccessKeyID and SecretAccessKey", name)
+	}
+	cfg.AccountID = profile.AccountID
+	cfg.AccessKeyID = profile.AccessKeyID
+	cfg.SecretAccessKey = profile.SecretAccessKey
+	if profile.DefaultBucket != "" {
+		cfg.DefaultBucket = profile.DefaultBucket
+	}
+	return nil
+}
+
+// AppendOnlyPrefix returns the configured append-only prefix that key lies below, if any.
+func (cfg *R2Config) AppendOnlyPrefix(key string) (string, bool) {
+	for _, prefix := range cfg.AppendOnlyPrefixes {
+		if prefix != "" && strings.HasPrefix(key, prefix) {
+			return prefix, true
+		}
+	}
+	return "", false
+}
+
 // expandPath expands a path that might contain a leading tilde (~).
 func expandPath(path string) string {
 	if len(path) > 0 && path[0] == '~' {
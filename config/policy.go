@@ -0,0 +1,139 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+// Actions a policy rule can deny.
+const (
+	PolicyActionRead      = "read"
+	PolicyActionList      = "list"
+	PolicyActionWrite     = "write"
+	PolicyActionDelete    = "delete"
+	PolicyActionConfigure = "configure"
+)
+
+var policyActions = []string{PolicyActionRead, PolicyActionList, PolicyActionWrite, PolicyActionDelete, PolicyActionConfigure}
+
+// Effects of a policy rule.
+const (
+	// PolicyEffectDeny refuses the rule's actions.
+	PolicyEffectDeny = "deny"
+	// PolicyEffectRequireEncryption refuses writes that are not encrypted with a customer key.
+	PolicyEffectRequireEncryption = "require-encryption"
+)
+
+// PolicyRule restricts the operations on the keys below a prefix.
+type PolicyRule struct {
+	Effect string `toml:"effect"`
+	// Actions lists the actions a deny rule refuses.
+	Actions []string `toml:"actions"`
+	// Bucket is a glob restricting the rule to matching buckets. Empty matches every bucket.
+	Bucket string `toml:"bucket"`
+	// Prefix restricts the rule to keys below it. Bucket-level operations, such as changing CORS
+	// rules, only match rules without a prefix.
+	Prefix string `toml:"prefix"`
+	// Message is shown when the rule refuses an operation.
+	Message string `toml:"message"`
+}
+
+// Policy is a set of guardrails evaluated before every request, typically shipped as a shared
+// file to everyone using go-cfr2 in a team.
+type Policy struct {
+	Path  string       `toml:"-"`
+	Rules []PolicyRule `toml:"rule"`
+}
+
+// PolicyRequest describes an operation on one key, or on a bucket when Key is empty. For listings,
+// Key is the listed prefix, and a rule applies if the listing can return any key below its prefix.
+type PolicyRequest struct {
+	Action string
+	Bucket string
+	Key    string
+	// Encrypted reports whether a write uses a customer-provided encryption key.
+	Encrypted bool
+}
+
+// PolicyViolation is the error returned for an operation a policy refuses.
+type PolicyViolation struct {
+	Rule    PolicyRule
+	Request PolicyRequest
+	Path    string
+}
+
+func (v *PolicyViolation) Error() string {
+	target := fmt.Sprintf("bucket '%s'", v.Request.Bucket)
+	if v.Request.Key != "" {
+		target = fmt.Sprintf("'%s' in bucket '%s'", v.Request.Key, v.Request.Bucket)
+	}
+	var msg string
+	if v.Rule.Effect == PolicyEffectRequireEncryption {
+		msg = fmt.Sprintf("policy %s requires encryption for writes to %s (use --sse-key-file)", v.Path, target)
+	} else {
+		msg = fmt.Sprintf("policy %s denies %s on %s", v.Path, v.Request.Action, target)
+	}
+	if v.Rule.Message != "" {
+		msg += ": " + v.Rule.Message
+	}
+	return msg
+}
+
+// LoadPolicy reads and validates a policy file.
+func LoadPolicy(policyPath string) (*Policy, error) {
+	policyPath = expandPath(policyPath)
+	data, err := os.ReadFile(policyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy file %s: %w", policyPath, err)
+	}
+	policy := &Policy{Path: policyPath}
+	if err := toml.Unmarshal(data, policy); err != nil {
+		return nil, fmt.Errorf("failed to parse policy file %s: %w", policyPath, err)
+	}
+
+	for i, rule := range policy.Rules {
+		switch rule.Effect {
+		case PolicyEffectDeny:
+			if len(rule.Actions) == 0 {
+				return nil, fmt.Errorf("policy rule %d in %s denies no actions", i+1, policyPath)
+			}
+			for _, action := range rule.Actions {
+				if !slices.Contains(policyActions, action) {
+					return nil, fmt.Errorf("policy rule %d in %s has unknown action '%s', use %s", i+1, policyPath, action, strings.Join(policyActions, ", "))
+				}
+			}
+		case PolicyEffectRequireEncryption:
+		default:
+			return nil, fmt.Errorf("policy rule %d in %s has unknown effect '%s', use deny or require-encryption", i+1, policyPath, rule.Effect)
+		}
+		if _, err := path.Match(rule.Bucket, ""); err != nil {
+			return nil, fmt.Errorf("policy rule %d in %s has an invalid bucket pattern '%s'", i+1, policyPath, rule.Bucket)
+		}
+	}
+	return policy, nil
+}
+
+// Check returns a *PolicyViolation if any rule refuses the request.
+func (p *Policy) Check(req PolicyRequest) error {
+	for _, rule := range p.Rules {
+		if rule.Bucket != "" {
+			if ok, _ := path.Match(rule.Bucket, req.Bucket); !ok {
+				continue
+			}
+		}
+		if !strings.HasPrefix(req.Key, rule.Prefix) && (req.Action != PolicyActionList || !strings.HasPrefix(rule.Prefix, req.Key)) {
+			continue
+		}
+		switch {
+		case rule.Effect == PolicyEffectDeny && slices.Contains(rule.Actions, req.Action),
+			rule.Effect == PolicyEffectRequireEncryption && req.Action == PolicyActionWrite && !req.Encrypted:
+			return &PolicyViolation{Rule: rule, Request: req, Path: p.Path}
+		}
+	}
+	return nil
+}
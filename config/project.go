@@ -0,0 +1,87 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+// ProjectFileName is the name of the project-local file defining deploy targets.
+const ProjectFileName = "cfr2.toml"
+
+// ErrNoProject is returned by FindProject when no project file exists above the start directory.
+var ErrNoProject = errors.New("no " + ProjectFileName + " found in this directory or any parent")
+
+// Project is a project-local file defining named deploy targets, shared by everyone working on the project.
+type Project struct {
+	// Dir is the directory containing the project file. Target directories are relative to it.
+	Dir     string            `toml:"-"`
+	Targets map[string]Target `toml:"targets"`
+}
+
+// Target is one named deploy destination of a project.
+type Target struct {
+	// LocalDir is the directory to deploy, relative to the project directory. Defaults to the project directory.
+	LocalDir string `toml:"dir"`
+	// Bucket defaults to DefaultBucket from the user configuration.
+	Bucket string `toml:"bucket"`
+	Prefix string `toml:"prefix"`
+	// Exclude lists patterns of files that are neither uploaded nor deleted remotely.
+	Exclude []string `toml:"exclude"`
+	// Delete removes remote objects that no longer exist locally.
+	Delete bool `toml:"delete"`
+	// Cache sets the Cache-Control header of matching uploads. The first matching rule applies.
+	Cache []CacheRule `toml:"cache"`
+	// Fingerprint lists patterns of assets uploaded under names carrying a hash of their content.
+	Fingerprint []string `toml:"fingerprint"`
+	// Manifest is the name, relative to the prefix, of the JSON manifest mapping original to
+	// fingerprinted asset names. Defaults to asset-manifest.json.
+	Manifest string `toml:"manifest"`
+	// Precompress lists the encodings of precompressed variants uploaded next to text files.
+	Precompress []string `toml:"precompress"`
+}
+
+// CacheRule maps a file pattern to a Cache-Control header.
+type CacheRule struct {
+	Pattern      string `toml:"pattern"`
+	CacheControl string `toml:"cache_control"`
+}
+
+// FindProject looks for a project file in start and each of its parent directories, and loads the first one found.
+func FindProject(start string) (*Project, error) {
+	dir, err := filepath.Abs(start)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve directory %s: %w", start, err)
+	}
+	for {
+		path := filepath.Join(dir, ProjectFileName)
+		if _, err := os.Stat(path); err == nil {
+			return loadProject(path)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, ErrNoProject
+		}
+		dir = parent
+	}
+}
+
+func loadProject(path string) (*Project, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read project file %s: %w", path, err)
+	}
+	project := &Project{Dir: filepath.Dir(path)}
+	if err := toml.Unmarshal(data, project); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal project file %s: %w", path, err)
+	}
+	return project, nil
+}
+
+// TargetDir returns the local directory of a target.
+func (p *Project) TargetDir(target Target) string {
+	return filepath.Join(p.Dir, target.LocalDir)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/baowuhe/go-cfr2/config"
+	"github.com/baowuhe/go-cfr2/r2"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// invocationArgs and invocationSession are the original command line and session capture, so that
+// long-running modes can reload the configuration with the same global flags.
+var (
+	invocationArgs    []string
+	invocationSession *r2.SessionCapture
+)
+
+// daemonSignals returns a context canceled on SIGINT or SIGTERM, after which long-running modes
+// finish their in-flight work and exit, and a channel receiving SIGHUP, on which they reload the
+// configuration. A second SIGINT or SIGTERM terminates the process at once.
+func daemonSignals(ctx context.Context) (context.Context, <-chan os.Signal, func()) {
+	ctx, stopTerm := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stopTerm()
+	}()
+
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	return ctx, reload, func() {
+		signal.Stop(reload)
+		stopTerm()
+	}
+}
+
+// reloadConfig loads the configuration again as on startup, with the global flags and alias of the
+// original command line, and creates a client from it.
+func reloadConfig() (*config.R2Config, *s3.Client, error) {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	args, err := extractGlobalFlags(invocationArgs, cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	args, err = expandAlias(args, cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	if args, err = extractGlobalFlags(args, cfg); err != nil {
+		return nil, nil, err
+	}
+	if len(args) > 1 {
+		cfg.Command = args[1]
+	}
+	client, err := r2.NewR2ClientWithOptions(cfg, r2.ClientOptions{Session: invocationSession, Logger: cliLogger{}})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create R2 client: %w", err)
+	}
+	return cfg, client, nil
+}
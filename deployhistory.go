@@ -0,0 +1,412 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/baowuhe/go-cfr2/config"
+	"github.com/baowuhe/go-cfr2/r2"
+	"github.com/baowuhe/go-cfr2/utils"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// deployHistoryPrefix holds a manifest of every deploy, named <id>.json, and below
+// deployObjectsPrefix a copy of every deployed content and headers, so that 'deploy rollback' can
+// restore an earlier release with server-side copies after later deploys overwrote it.
+const (
+	deployHistoryPrefix = "_deploys/"
+	deployObjectsPrefix = deployHistoryPrefix + "objects/"
+)
+
+// deployCopyGrace is how old a kept copy must be before pruning deletes it for being unreferenced,
+// so that the copies of a deploy still being recorded by another run are left alone.
+const deployCopyGrace = time.Hour
+
+// deployManifest records the objects below the live prefix of a target after a deploy.
+type deployManifest struct {
+	ID      string    `json:"id"`
+	Target  string    `json:"target"`
+	Prefix  string    `json:"prefix"`
+	Created time.Time `json:"created"`
+	// RollbackTo is the deploy restored by a rollback.
+	RollbackTo string `json:"rollback_to,omitempty"`
+	// Files maps keys relative to Prefix to their versions, which name their copies below
+	// deployObjectsPrefix.
+	Files map[string]string `json:"files"`
+}
+
+// deployVersion identifies the content and the headers of a deployed object: its ETag, followed
+// by a hash of the headers a rollback restores along with the content. Objects with the same
+// bytes but, say, a different Cache-Control get different versions, and so different copies.
+func deployVersion(etag string, head *s3.HeadObjectOutput) string {
+	headers := map[string]string{
+		"Cache-Control":       aws.ToString(head.CacheControl),
+		"Content-Disposition": aws.ToString(head.ContentDisposition),
+		"Content-Encoding":    aws.ToString(head.ContentEncoding),
+		"Content-Language":    aws.ToString(head.ContentLanguage),
+		"Content-Type":        aws.ToString(head.ContentType),
+	}
+	for name, value := range head.Metadata {
+		headers["x-amz-meta-"+strings.ToLower(name)] = value
+	}
+	// Maps are encoded with sorted keys, so equal headers always hash the same.
+	data, _ := json.Marshal(headers)
+	sum := sha256.Sum256(data)
+	return etag + "." + hex.EncodeToString(sum[:8])
+}
+
+// deployObjectKey returns the key the object with a version is kept under.
+func deployObjectKey(version string) string {
+	return deployObjectsPrefix + version
+}
+
+// listDeployVersions returns the versions of the objects below prefix by key relative to prefix,
+// leaving out the keys below any of skip. Their headers take a HEAD request per object.
+func listDeployVersions(ctx context.Context, client *s3.Client, bucketName, prefix string, skip ...string) (map[string]string, error) {
+	etags, err := listPrefixETags(ctx, client, bucketName, prefix, skip...)
+	if err != nil {
+		return nil, err
+	}
+	versions := map[string]string{}
+	pipeline := r2.NewHeadPipeline(ctx, client, bucketName, 0, func(key string, head *s3.HeadObjectOutput, err error) error {
+		var notFound *types.NotFound
+		switch {
+		case errors.As(err, &notFound):
+			// Deleted since the listing.
+		case err != nil:
+			return err
+		default:
+			versions[strings.TrimPrefix(key, prefix)] = deployVersion(strings.Trim(aws.ToString(head.ETag), `"`), head)
+		}
+		return nil
+	})
+	for _, rel := range sortedKeys(etags) {
+		if err = pipeline.Add(prefix + rel); err != nil {
+			break
+		}
+	}
+	if waitErr := pipeline.Wait(); err == nil {
+		err = waitErr
+	}
+	return versions, err
+}
+
+// listLiveVersions lists the versions of the objects below a live prefix, leaving out the canary,
+// the slots and the deploy history, which are inside the root of the bucket.
+func listLiveVersions(ctx context.Context, client *s3.Client, bucketName, livePrefix string) (map[string]string, error) {
+	skip := append([]string{canaryPrefixFor(livePrefix), deployHistoryPrefix}, slotKeys(livePrefix)...)
+	return listDeployVersions(ctx, client, bucketName, livePrefix, skip...)
+}
+
+// recordDeploy keeps a copy of every live object whose version is not kept yet and writes the
+// manifest of the deploy. With keep above zero, only the newest keep deploys of the target stay
+// recorded.
+func recordDeploy(ctx context.Context, client *s3.Client, bucketName, targetName, livePrefix, rollbackTo string, concurrency, keep int) {
+	live, err := listLiveVersions(ctx, client, bucketName, livePrefix)
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to record the deploy: %v", err))
+	}
+	kept := map[string]bool{}
+	err = r2.WalkObjects(ctx, client, bucketName, deployObjectsPrefix, func(obj types.Object) error {
+		kept[*obj.Key] = true
+		return nil
+	})
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to record the deploy: %v", err))
+	}
+
+	var copies []r2.KeyRename
+	for _, rel := range sortedKeys(live) {
+		key := deployObjectKey(live[rel])
+		if !kept[key] {
+			kept[key] = true
+			copies = append(copies, r2.KeyRename{From: livePrefix + rel, To: key})
+		}
+	}
+	failed := r2.CopyObjects(ctx, client, bucketName, copies, concurrency, func(cp r2.KeyRename, err error) {
+		if err != nil {
+			fmt.Printf("Failed to keep a copy of '%s': %v\n", cp.From, err)
+		}
+	})
+	if failed > 0 {
+		utils.ExitWithError(fmt.Sprintf("Failed to record the deploy: failed to keep a copy of %d object(s).", failed))
+	}
+
+	now := time.Now().UTC()
+	id := now.Format("20060102T150405Z")
+	// Deploys within the same second get a suffix, which keeps the IDs in order.
+	for n := 2; ; n++ {
+		exists, err := r2.ObjectExists(ctx, client, bucketName, deployHistoryPrefix+id+".json")
+		if err != nil {
+			utils.ExitWithError(fmt.Sprintf("Failed to record the deploy: %v", err))
+		}
+		if !exists {
+			break
+		}
+		id = fmt.Sprintf("%s-%d", now.Format("20060102T150405Z"), n)
+	}
+	manifest := deployManifest{
+		ID:         id,
+		Target:     targetName,
+		Prefix:     livePrefix,
+		Created:    now,
+		RollbackTo: rollbackTo,
+		Files:      live,
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to encode deploy manifest: %v", err))
+	}
+	key := deployHistoryPrefix + manifest.ID + ".json"
+	_, err = r2.UploadStreamWithOptions(ctx, client, bucketName, key, bytes.NewReader(data), r2.UploadOptions{ContentType: aws.String("application/json"), Quiet: true})
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to record the deploy: %v", err))
+	}
+	fmt.Printf("Recorded deploy '%s' of target '%s' (%d object(s), %d newly kept).\n", manifest.ID, targetName, len(live), len(copies))
+
+	if keep > 0 {
+		if err := pruneDeploys(ctx, client, bucketName, targetName, keep); err != nil {
+			utils.ExitWithError(fmt.Sprintf("Failed to prune the deploy history: %v", err))
+		}
+	}
+}
+
+// pruneDeploys deletes the manifests of all but the newest keep deploys of a target, and then the
+// kept copies that no remaining deploy of any target references.
+func pruneDeploys(ctx context.Context, client *s3.Client, bucketName, targetName string, keep int) error {
+	keys, err := listDeployManifestKeys(ctx, client, bucketName)
+	if err != nil {
+		return err
+	}
+	var own []*deployManifest
+	referenced := map[string]bool{}
+	for _, key := range keys {
+		manifest, err := readDeployManifest(ctx, client, bucketName, key)
+		if err != nil {
+			return err
+		}
+		if manifest.Target == targetName {
+			own = append(own, manifest)
+			continue
+		}
+		for _, version := range manifest.Files {
+			referenced[deployObjectKey(version)] = true
+		}
+	}
+	if len(own) <= keep {
+		return nil
+	}
+	pruned := own[:len(own)-keep]
+	for _, manifest := range pruned {
+		if err := r2.DeleteObject(ctx, client, bucketName, deployHistoryPrefix+manifest.ID+".json"); err != nil {
+			return err
+		}
+	}
+	for _, manifest := range own[len(own)-keep:] {
+		for _, version := range manifest.Files {
+			referenced[deployObjectKey(version)] = true
+		}
+	}
+	unreferenced := map[string]bool{}
+	cutoff := time.Now().Add(-deployCopyGrace)
+	err = r2.WalkObjects(ctx, client, bucketName, deployObjectsPrefix, func(obj types.Object) error {
+		if !referenced[*obj.Key] && aws.ToTime(obj.LastModified).Before(cutoff) {
+			unreferenced[*obj.Key] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	result, err := r2.EmptyBucket(ctx, client, r2.EmptyBucketOptions{
+		Bucket:             bucketName,
+		Prefix:             deployObjectsPrefix,
+		Keep:               func(key string) bool { return !unreferenced[key] },
+		OverrideProtection: true,
+		KeepUploads:        true,
+	})
+	if err != nil {
+		return err
+	}
+	if result.Failed > 0 {
+		return fmt.Errorf("failed to delete %d unreferenced copies", result.Failed)
+	}
+	fmt.Printf("Pruned %d old deploy(s) of target '%s' and %d unreferenced copies.\n", len(pruned), targetName, result.Deleted)
+	return nil
+}
+
+// loadDeployManifests returns the recorded deploys of a target, oldest first.
+func loadDeployManifests(ctx context.Context, client *s3.Client, bucketName, targetName string) ([]deployManifest, error) {
+	keys, err := listDeployManifestKeys(ctx, client, bucketName)
+	if err != nil {
+		return nil, err
+	}
+	var manifests []deployManifest
+	for _, key := range keys {
+		manifest, err := readDeployManifest(ctx, client, bucketName, key)
+		if err != nil {
+			return nil, err
+		}
+		if manifest.Target == targetName {
+			manifests = append(manifests, *manifest)
+		}
+	}
+	return manifests, nil
+}
+
+// latestDeploy returns the last recorded deploy of a target, or nil if there is none.
+func latestDeploy(ctx context.Context, client *s3.Client, bucketName, targetName string) (*deployManifest, error) {
+	keys, err := listDeployManifestKeys(ctx, client, bucketName)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(keys) - 1; i >= 0; i-- {
+		manifest, err := readDeployManifest(ctx, client, bucketName, keys[i])
+		if err != nil {
+			return nil, err
+		}
+		if manifest.Target == targetName {
+			return manifest, nil
+		}
+	}
+	return nil, nil
+}
+
+// listDeployManifestKeys returns the keys of the recorded deploys of every target, oldest first.
+func listDeployManifestKeys(ctx context.Context, client *s3.Client, bucketName string) ([]string, error) {
+	_, objects, err := r2.ListDirectory(ctx, client, bucketName, deployHistoryPrefix)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, obj := range objects {
+		if strings.HasSuffix(*obj.Key, ".json") {
+			keys = append(keys, *obj.Key)
+		}
+	}
+	// By ID, since the suffix of a key such as 20261016T120000Z-2.json sorts before the .json of
+	// the first deploy of that second.
+	sort.Slice(keys, func(i, j int) bool {
+		return strings.TrimSuffix(keys[i], ".json") < strings.TrimSuffix(keys[j], ".json")
+	})
+	return keys, nil
+}
+
+// readDeployManifest reads the recorded deploy stored under key.
+func readDeployManifest(ctx context.Context, client *s3.Client, bucketName, key string) (*deployManifest, error) {
+	body, err := r2.OpenObject(ctx, client, bucketName, key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	var manifest deployManifest
+	if err := json.NewDecoder(body).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse deploy manifest '%s': %w", key, err)
+	}
+	return &manifest, nil
+}
+
+// previousDeploy returns the deploy before the current, last one, or nil if there is none. A
+// rollback stands for the deploy it restored, so that repeated rollbacks keep going back.
+func previousDeploy(manifests []deployManifest) *deployManifest {
+	byID := map[string]int{}
+	for i, manifest := range manifests {
+		byID[manifest.ID] = i
+	}
+	current := len(manifests) - 1
+	for current >= 0 && manifests[current].RollbackTo != "" {
+		restored, ok := byID[manifests[current].RollbackTo]
+		if !ok || restored >= current {
+			break
+		}
+		current = restored
+	}
+	if current <= 0 {
+		return nil
+	}
+	return &manifests[current-1]
+}
+
+// replaceLiveFiles makes the objects below a live prefix those of want, which holds versions by key
+// relative to the prefix, by copying each differing object from the key source returns for it.
+// Objects whose headers alone differ are copied too, since the version covers the headers.
+// Assets are copied before pages, so that a live page never references an asset that is not live
+// yet. With deleteExtra, the live objects missing from want are deleted.
+func replaceLiveFiles(ctx context.Context, client *s3.Client, cfg *config.R2Config, bucketName, livePrefix string, want map[string]string, source func(rel string) string, deleteExtra bool, concurrency int) (copied, deleted int, err error) {
+	live, err := listLiveVersions(ctx, client, bucketName, livePrefix)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var assets, pages []r2.KeyRename
+	for _, rel := range sortedKeys(want) {
+		// Copies of multipart objects may get a new ETag and are then copied again unchanged.
+		liveVersion, exists := live[rel]
+		if exists && liveVersion == want[rel] {
+			continue
+		}
+		key := livePrefix + rel
+		if appendOnly, ok := cfg.AppendOnlyPrefix(key); ok && exists {
+			fmt.Printf("Keeping '%s': prefix '%s' is append-only.\n", key, appendOnly)
+			continue
+		}
+		cp := r2.KeyRename{From: source(rel), To: key}
+		if lower := strings.ToLower(rel); strings.HasSuffix(lower, ".html") || strings.HasSuffix(lower, ".htm") {
+			pages = append(pages, cp)
+		} else {
+			assets = append(assets, cp)
+		}
+	}
+	var deletes []string
+	if deleteExtra {
+		for _, rel := range sortedKeys(live) {
+			if _, ok := want[rel]; ok {
+				continue
+			}
+			key := livePrefix + rel
+			if appendOnly, ok := cfg.AppendOnlyPrefix(key); ok {
+				fmt.Printf("Keeping '%s': prefix '%s' is append-only.\n", key, appendOnly)
+				continue
+			}
+			deletes = append(deletes, key)
+		}
+	}
+
+	fmt.Printf("%d object(s) to copy, %d to delete.\n", len(assets)+len(pages), len(deletes))
+	report := func(cp r2.KeyRename, err error) {
+		if err != nil {
+			fmt.Printf("Failed to update '%s': %v\n", cp.To, err)
+			return
+		}
+		fmt.Printf("Updated '%s'\n", cp.To)
+	}
+	for _, batch := range [][]r2.KeyRename{assets, pages} {
+		if failed := r2.CopyObjects(ctx, client, bucketName, batch, concurrency, report); failed > 0 {
+			return copied, 0, fmt.Errorf("failed to copy %d object(s)", failed)
+		}
+		copied += len(batch)
+	}
+	failed := 0
+	for _, key := range deletes {
+		fmt.Printf("Deleting '%s'\n", key)
+		if err := r2.DeleteObject(ctx, client, bucketName, key); err != nil {
+			fmt.Printf("Failed: %v\n", err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return copied, len(deletes) - failed, fmt.Errorf("failed to delete %d object(s)", failed)
+	}
+	return copied, len(deletes), nil
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestDeployVersion(t *testing.T) {
+	page := &s3.HeadObjectOutput{ContentType: aws.String("text/html"), CacheControl: aws.String("no-cache")}
+	same := &s3.HeadObjectOutput{ContentType: aws.String("text/html"), CacheControl: aws.String("no-cache")}
+	cached := &s3.HeadObjectOutput{ContentType: aws.String("text/html"), CacheControl: aws.String("max-age=3600")}
+	tagged := &s3.HeadObjectOutput{ContentType: aws.String("text/html"), CacheControl: aws.String("no-cache"), Metadata: map[string]string{"build": "42"}}
+
+	if deployVersion("abc", page) != deployVersion("abc", same) {
+		t.Error("equal content and headers got different versions")
+	}
+	for name, head := range map[string]*s3.HeadObjectOutput{"Cache-Control": cached, "metadata": tagged} {
+		if deployVersion("abc", page) == deployVersion("abc", head) {
+			t.Errorf("a different %s got the same version", name)
+		}
+	}
+	if deployVersion("abc", page) == deployVersion("abd", page) {
+		t.Error("different content got the same version")
+	}
+}
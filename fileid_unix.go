@@ -0,0 +1,18 @@
+//go:build unix
+
+package main
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// fileIdentity returns the device and inode number of a file, which stay the same while the file
+// is appended to and change when another file takes its path.
+func fileIdentity(info fs.FileInfo) (dev, ino uint64, ok bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return uint64(stat.Dev), uint64(stat.Ino), true
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/baowuhe/go-cfr2/config"
+	"github.com/baowuhe/go-cfr2/r2"
+	"github.com/baowuhe/go-cfr2/utils"
+)
+
+// stringListFlag is a flag.Value collecting every occurrence of a repeatable flag.
+type stringListFlag []string
+
+func (f *stringListFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringListFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// parseMetaPair splits a --meta flag of the form name=value into the metadata name, lowercased as
+// R2 stores it, and its value.
+func parseMetaPair(entry string) (string, string, error) {
+	name, value, ok := strings.Cut(entry, "=")
+	name = strings.ToLower(strings.TrimSpace(name))
+	if !ok || name == "" {
+		return "", "", fmt.Errorf("Invalid metadata '%s'. Use --meta name=value.", entry)
+	}
+	if name == r2.MetadataProtected {
+		return "", "", fmt.Errorf("Metadata '%s' marks protected objects. Use 'protect' or 'unprotect' instead.", r2.MetadataProtected)
+	}
+	return name, value, nil
+}
+
+// commandDefaults holds the flag defaults of the config by command, see config.R2Config.Defaults.
+// It is set once the config is loaded.
+var commandDefaults map[string]map[string]any
+
+// parseFlags parses the flags of a command and then applies the defaults configured for it to the
+// flags not given on the command line, so that those given take precedence.
+func parseFlags(fs *flag.FlagSet, args []string) {
+	fs.Parse(args)
+	applyCommandDefaults(fs)
+}
+
+// applyCommandDefaults sets the flags configured in the [defaults.<command>] table named like the
+// flag set, such as [defaults.upload] or [defaults."deploy rollback"]. Keys are flag names, with
+// underscores standing for dashes, and arrays set a repeatable flag once per element. It runs after
+// parsing and leaves out flags given on the command line, under any of their names, so that a
+// repeatable flag given there replaces the configured values instead of adding to them.
+func applyCommandDefaults(fs *flag.FlagSet) {
+	// Aliases such as -b and --bucket share their value.
+	given := map[flag.Value]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Value] = true
+	})
+	defaults := commandDefaults[fs.Name()]
+	names := make([]string, 0, len(defaults))
+	for name := range defaults {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		flagName := strings.ReplaceAll(name, "_", "-")
+		if fs.Lookup(flagName) == nil {
+			utils.ExitWithError(fmt.Sprintf("Invalid default '%s' in [defaults.%s] of the config: '%s' has no --%s flag.", name, tableKey(fs.Name()), fs.Name(), flagName))
+		}
+		if given[fs.Lookup(flagName).Value] {
+			continue
+		}
+		values, ok := defaults[name].([]any)
+		if !ok {
+			values = []any{defaults[name]}
+		}
+		for _, value := range values {
+			if err := fs.Set(flagName, fmt.Sprint(value)); err != nil {
+				utils.ExitWithError(fmt.Sprintf("Invalid default '%s' in [defaults.%s] of the config: %v", name, tableKey(fs.Name()), err))
+			}
+		}
+	}
+}
+
+// tableKey quotes a command name for use as a TOML key if it has a subcommand.
+func tableKey(command string) string {
+	if strings.Contains(command, " ") {
+		return strconv.Quote(command)
+	}
+	return command
+}
+
+// parseInterspersed parses flags that may appear before, between or after positional arguments,
+// which flag.FlagSet.Parse alone does not allow, and returns the positional arguments in order.
+// The defaults configured for the command are applied to the flags not given.
+func parseInterspersed(fs *flag.FlagSet, args []string) []string {
+	defer applyCommandDefaults(fs)
+	var positional []string
+	for {
+		fs.Parse(args)
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		if args[0] == "--" {
+			return append(positional, args[1:]...)
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// globalFlags lists the flags shared by every command. inject-faults is deliberately left out of
+// the usage text.
+var globalFlags = map[string]bool{
+	"max-ops-per-second": true,
+	"capture-session":    true,
+	"inject-faults":      true,
+	"profile":            true,
+	"request-id":         true,
+	"strict":             true,
+	"offline":            true,
+	"literal":            true,
+}
+
+// booleanGlobalFlags lists the global flags that take no value. They still accept one written as
+// --name=value, like --strict=false.
+var booleanGlobalFlags = map[string]bool{
+	"strict":  true,
+	"offline": true,
+	"literal": true,
+}
+
+// extractGlobalFlags removes the flags shared by every command, given between the program name and
+// the command, from args, applying them to cfg, and returns the remaining arguments. Scanning stops
+// at the command, since the values of its flags, as in 'grep -e --strict', may look like global
+// flags.
+func extractGlobalFlags(args []string, cfg *config.R2Config) ([]string, error) {
+	if len(args) == 0 {
+		return args, nil
+	}
+	i := 1
+	for ; i < len(args); i++ {
+		arg := args[i]
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") || !globalFlags[name] {
+			break
+		}
+		if !hasValue && booleanGlobalFlags[name] {
+			value = "true"
+		} else if !hasValue {
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("flag --%s needs a value", name)
+			}
+			i++
+			value = args[i]
+		}
+
+		switch name {
+		case "max-ops-per-second":
+			rate, err := strconv.ParseFloat(value, 64)
+			if err != nil || rate < 0 {
+				return nil, fmt.Errorf("invalid --%s value '%s'", name, value)
+			}
+			cfg.MaxOpsPerSecond = rate
+		case "capture-session":
+			cfg.CaptureSessionPath = value
+		case "profile":
+			if err := cfg.UseProfile(value); err != nil {
+				return nil, err
+			}
+		case "request-id":
+			cfg.RequestID = value
+		case "strict":
+			enabled, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid --%s value '%s'", name, value)
+			}
+			cfg.Strict = enabled
+		case "offline":
+			enabled, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid --%s value '%s'", name, value)
+			}
+			cfg.Offline = enabled
+		case "literal":
+			enabled, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid --%s value '%s'", name, value)
+			}
+			cfg.LiteralKeys = enabled
+		case "inject-faults":
+			if _, err := r2.ParseFaultSpec(value); err != nil {
+				return nil, fmt.Errorf("invalid --%s value: %w", name, err)
+			}
+			cfg.InjectFaults = value
+		}
+	}
+	return append([]string{args[0]}, args[i:]...), nil
+}
+
+// parsePrecompress parses a --precompress value, a comma-separated list of encodings.
+func parsePrecompress(value string) ([]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var encodings []string
+	for _, name := range strings.Split(value, ",") {
+		switch strings.TrimSpace(name) {
+		case "gzip", "gz":
+			if !slices.Contains(encodings, r2.PrecompressGzip) {
+				encodings = append(encodings, r2.PrecompressGzip)
+			}
+		case "br", "brotli":
+			if !slices.Contains(encodings, r2.PrecompressBrotli) {
+				encodings = append(encodings, r2.PrecompressBrotli)
+			}
+		default:
+			return nil, fmt.Errorf("unknown --precompress encoding '%s', use gzip or br", name)
+		}
+	}
+	return encodings, nil
+}
+
+// shardOptions builds the sharded listing options from the --parallel, --shard-prefix and
+// --shard-boundaries flags.
+func shardOptions(parallel int, prefixes []string, boundaries string) r2.ShardOptions {
+	opts := r2.ShardOptions{Prefixes: prefixes, Concurrency: parallel}
+	if boundaries != "" {
+		opts.Boundaries = strings.Split(boundaries, ",")
+	}
+	return opts
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"flag"
+	"reflect"
+	"testing"
+
+	"github.com/baowuhe/go-cfr2/config"
+)
+
+func TestParseFlagsCommandDefaults(t *testing.T) {
+	defer func(saved map[string]map[string]any) { commandDefaults = saved }(commandDefaults)
+	commandDefaults = map[string]map[string]any{
+		"upload": {"meta": []any{"team=web", "tier=1"}, "bucket": "assets"},
+	}
+
+	tests := []struct {
+		name       string
+		args       []string
+		wantMeta   []string
+		wantBucket string
+	}{
+		{"defaults apply", nil, []string{"team=web", "tier=1"}, "assets"},
+		{"repeatable flag replaces the defaults", []string{"--meta", "team=api"}, []string{"team=api"}, "assets"},
+		{"alias of a flag overrides its default", []string{"-b", "logs"}, []string{"team=web", "tier=1"}, "logs"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("upload", flag.ContinueOnError)
+			var meta stringListFlag
+			fs.Var(&meta, "meta", "")
+			bucket := fs.String("b", "", "")
+			fs.StringVar(bucket, "bucket", "", "")
+			parseFlags(fs, tt.args)
+
+			if !reflect.DeepEqual([]string(meta), tt.wantMeta) {
+				t.Errorf("--meta = %q, want %q", meta, tt.wantMeta)
+			}
+			if *bucket != tt.wantBucket {
+				t.Errorf("--bucket = %q, want %q", *bucket, tt.wantBucket)
+			}
+		})
+	}
+}
+
+func TestExtractGlobalFlags(t *testing.T) {
+	tests := []struct {
+		name        string
+		args        []string
+		want        []string
+		wantStrict  bool
+		wantOffline bool
+	}{
+		{"before the command", []string{"go-cfr2", "--strict", "--offline=true", "list"}, []string{"go-cfr2", "list"}, true, true},
+		{"value of a command flag", []string{"go-cfr2", "grep", "-e", "--strict"}, []string{"go-cfr2", "grep", "-e", "--strict"}, false, false},
+		{"after the command", []string{"go-cfr2", "meta", "--set", "x", "--offline"}, []string{"go-cfr2", "meta", "--set", "x", "--offline"}, false, false},
+		{"terminator", []string{"go-cfr2", "--", "--strict"}, []string{"go-cfr2", "--", "--strict"}, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.R2Config{}
+			got, err := extractGlobalFlags(tt.args, cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("extractGlobalFlags(%q) = %q, want %q", tt.args, got, tt.want)
+			}
+			if cfg.Strict != tt.wantStrict || cfg.Offline != tt.wantOffline {
+				t.Errorf("extractGlobalFlags(%q) set strict %v and offline %v, want %v and %v", tt.args, cfg.Strict, cfg.Offline, tt.wantStrict, tt.wantOffline)
+			}
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/baowuhe/go-cfr2/r2"
+	"github.com/baowuhe/go-cfr2/utils"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// literalKeys, set by the --literal flag, takes every -k value as a key, never as a glob.
+var literalKeys bool
+
+// isKeyGlob reports whether a -k value is a glob pattern rather than a literal key.
+func isKeyGlob(key string) bool {
+	return r2.IsKeyGlob(key, literalKeys)
+}
+
+// expandKeyArg resolves the -k value of a command. A literal key is returned as is; a glob such as
+// "images/*.png" is expanded against a listing of the bucket, and must match at least one object.
+func expandKeyArg(ctx context.Context, client *s3.Client, bucketName, key string) []string {
+	if !isKeyGlob(key) {
+		return []string{key}
+	}
+	keys, err := r2.ExpandKeyGlob(ctx, client, bucketName, key)
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to expand '%s': %v", key, err))
+	}
+	if len(keys) == 0 {
+		utils.ExitWithError(fmt.Sprintf("No objects in bucket '%s' match '%s'.", bucketName, key))
+	}
+	return keys
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/baowuhe/go-cfr2/utils"
+)
+
+// cliLogger is the r2.Logger of the CLI's clients. It prints progress on stdout and warnings
+// through utils.Warnf, so that --strict counts them.
+type cliLogger struct{}
+
+func (cliLogger) Printf(format string, args ...any) {
+	fmt.Printf(format, args...)
+}
+
+func (cliLogger) Warnf(format string, args ...any) {
+	utils.Warnf(format, args...)
+}
@@ -2,10 +2,13 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -15,6 +18,7 @@ import (
 	"github.com/baowuhe/go-cfr2/utils"
 
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 )
 
 func main() {
@@ -22,18 +26,88 @@ func main() {
 		printUsage()
 		os.Exit(1)
 	}
-
-	command := os.Args[1]
+	// get-url needs no configuration, so it works for recipients of shared links.
+	if os.Args[1] == "get-url" {
+		handleGetURLCommand(context.Background())
+		return
+	}
+	// Importing a bundle sets up the configuration, so it cannot require one.
+	if os.Args[1] == "config" && len(os.Args) > 2 && os.Args[2] == "import-bundle" {
+		handleConfigImportBundleCommand()
+		return
+	}
+	// exists reports a missing object with status code 1, so that errors, including configuration
+	// errors, must exit with another one.
+	if os.Args[1] == "exists" {
+		utils.SetErrorExitCode(existsErrorExitCode)
+	}
 
 	cfg, err := config.LoadConfig()
 	if err != nil {
 	utils.ExitWithError(fmt.Sprintf("Configuration error: %v", err))
 	}
 
-	client, err := r2.NewR2Client(cfg)
+	invocationArgs = os.Args
+	os.Args, err = extractGlobalFlags(os.Args, cfg)
+	if err != nil {
+		utils.ExitWithError(err.Error())
+	}
+	os.Args, err = expandAlias(os.Args, cfg)
+	if err != nil {
+		utils.ExitWithError(err.Error())
+	}
+	// An alias may start with global flags of its own.
+	os.Args, err = extractGlobalFlags(os.Args, cfg)
+	if err != nil {
+		utils.ExitWithError(err.Error())
+	}
+	if len(os.Args) < 2 {
+		printUsage()
+		os.Exit(1)
+	}
+	command := os.Args[1]
+	cfg.Command = command
+	commandDefaults = cfg.Defaults
+	utils.SetStrict(cfg.Strict)
+	literalKeys = cfg.LiteralKeys
+
+	utils.OnExitWithError(func(msg string) {
+		if hint := r2.AuthErrorHintFromMessage(msg); hint != "" {
+			fmt.Fprintf(os.Stderr, "Hint: %s\n", hint)
+		}
+	})
+
+	if cfg.RequestID != "" && !r2.ValidRequestID(cfg.RequestID) {
+		utils.ExitWithError(fmt.Sprintf("Invalid request ID '%s': use 1 to 128 letters, digits, '.', '_', ':' and '-'.", cfg.RequestID))
+	}
+	if cfg.RequestID != "" {
+		utils.OnExitWithError(func(string) {
+			fmt.Fprintf(os.Stderr, "Request ID: %s\n", cfg.RequestID)
+		})
+	}
+
+	var session *r2.SessionCapture
+	if cfg.CaptureSessionPath != "" {
+		session = r2.NewSessionCapture(os.Args[1:])
+		utils.OnExitWithError(func(msg string) {
+			saveSession(session, cfg.CaptureSessionPath, msg)
+		})
+	}
+
+	if cfg.InjectFaults != "" {
+		fmt.Fprintf(os.Stderr, "Warning: injecting faults into requests (%s)\n", cfg.InjectFaults)
+	}
+
+	invocationSession = session
+	client, err := r2.NewR2ClientWithOptions(cfg, r2.ClientOptions{Session: session, Logger: cliLogger{}})
 	if err != nil {
 		utils.ExitWithError(fmt.Sprintf("Failed to create R2 client: %v", err))
 	}
+	utils.OnExitWithError(func(string) {
+		if skew := r2.ObservedClockSkew(client); skew > r2.ClockSkewTolerance || skew < -r2.ClockSkewTolerance {
+			fmt.Fprintf(os.Stderr, "Note: the local clock is off by %s compared to R2, which can make R2 reject signed requests. Synchronize it, e.g. with 'timedatectl set-ntp true'.\n", skew.Round(time.Second))
+		}
+	})
 
 	switch command {
 	case "list":
@@ -48,23 +122,139 @@ func main() {
 		handleRenameCommand(context.Background(), client, cfg)
 	case "presign":
 		handlePresignCommand(context.Background(), client, cfg)
+	case "dbdump":
+		handleDbdumpCommand(context.Background(), client, cfg)
+	case "ship-logs":
+		handleShipLogsCommand(context.Background(), client, cfg)
+	case "inventory":
+		handleInventoryCommand(context.Background(), client, cfg)
+	case "query":
+		handleQueryCommand(context.Background(), client, cfg)
+	case "preview":
+		handlePreviewCommand(context.Background(), client, cfg)
+	case "sync":
+		handleSyncCommand(context.Background(), client, cfg)
+	case "mkdir":
+		handleMkdirCommand(context.Background(), client, cfg)
+	case "gc":
+		handleGcCommand(context.Background(), client, cfg)
+	case "protect":
+		handleProtectCommand(context.Background(), client, cfg)
+	case "unprotect":
+		handleUnprotectCommand(context.Background(), client, cfg)
+	case "restore":
+		handleRestoreCommand(context.Background(), client, cfg)
+	case "deploy":
+		handleDeployCommand(context.Background(), client, cfg)
+	case "worker":
+		handleWorkerCommand(context.Background(), client, cfg)
+	case "config":
+		handleConfigCommand(context.Background(), client, cfg)
+	case "scrub":
+		handleScrubCommand(context.Background(), client, cfg)
+	case "snapshot":
+		handleSnapshotCommand(context.Background(), client, cfg)
+	case "whoami":
+		handleWhoamiCommand(context.Background(), client, cfg)
+	case "accounts":
+		handleAccountsCommand(context.Background(), client, cfg)
+	case "bucket":
+		handleBucketCommand(context.Background(), client, cfg)
+	case "scratch":
+		handleScratchCommand(context.Background(), client, cfg)
+	case "ci-upload":
+		handleCIUploadCommand(context.Background(), client, cfg)
+	case "serve":
+		handleServeCommand(context.Background(), client, cfg)
+	case "daemon":
+		handleDaemonCommand(context.Background(), client, cfg)
+	case "queue":
+		handleQueueCommand(context.Background(), client, cfg)
+	case "stat":
+		handleStatCommand(context.Background(), client, cfg)
+	case "cat":
+		handleCatCommand(context.Background(), client, cfg)
+	case "index":
+		handleIndexCommand(context.Background(), client, cfg)
+	case "find":
+		handleFindCommand(context.Background(), client, cfg)
+	case "metadata", "meta":
+		handleMetadataCommand(context.Background(), client, cfg)
+	case "cp":
+		handleCpCommand(context.Background(), client, cfg)
+	case "share":
+		handleShareCommand(context.Background(), client, cfg)
+	case "dupes":
+		handleDupesCommand(context.Background(), client, cfg)
+	case "report":
+		handleReportCommand(context.Background(), client, cfg)
+	case "head":
+		handleHeadCommand(context.Background(), client, cfg)
+	case "tail":
+		handleTailCommand(context.Background(), client, cfg)
+	case "grep":
+		handleGrepCommand(context.Background(), client, cfg)
+	case "tree":
+		handleTreeCommand(context.Background(), client, cfg)
+	case "exists":
+		handleExistsCommand(context.Background(), client, cfg)
+	case "zip":
+		handleZipCommand(context.Background(), client, cfg)
+	case "buckets":
+		handleBucketsCommand(context.Background(), client, cfg)
+	case "expand":
+		handleExpandCommand(context.Background(), client, cfg)
+	case "rekey":
+		handleRekeyCommand(context.Background(), client, cfg)
+	case "cors":
+		handleCORSCommand(context.Background(), client, cfg)
+	case "lifecycle":
+		handleLifecycleCommand(context.Background(), client, cfg)
 	default:
 		printUsage()
 		os.Exit(1)
 	}
+
+	if session != nil {
+		saveSession(session, cfg.CaptureSessionPath, "")
+	}
+	utils.ExitOnWarnings()
+}
+
+// saveSession writes a session capture, reporting but not failing on errors, since it runs
+// while the command is already finishing.
+func saveSession(session *r2.SessionCapture, path, errMsg string) {
+	if err := session.Save(path, errMsg); err != nil {
+		utils.Warnf("%v", err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Session captured in '%s'\n", path)
 }
 
 func handleListCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
 	listFlags := flag.NewFlagSet("list", flag.ExitOnError)
 	bucketName := listFlags.String("b", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
 	listFlags.StringVar(bucketName, "bucket", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
-	listFlags.Parse(os.Args[2:])
+	parallel := listFlags.Int("parallel", 1, "List this many shards of the keyspace concurrently (optional)")
+	var shardPrefixes stringListFlag
+	listFlags.Var(&shardPrefixes, "shard-prefix", "List only below this prefix, one shard per prefix (repeatable)")
+	shardBoundaries := listFlags.String("shard-boundaries", "", "Split the keyspace at these comma-separated keys instead of at every letter and digit (optional)")
+	hideMarkers := listFlags.Bool("hide-markers", false, "Hide zero-byte directory marker objects (optional)")
+	parseFlags(listFlags, os.Args[2:])
 
 	if *bucketName == "" {
 		utils.ExitWithError("Bucket name not specified. Use -b or --bucket flag, or set DefaultBucket in config.")
 	}
 
-	objects, err := r2.ListObjects(ctx, client, *bucketName)
+	var (
+		objects []types.Object
+		err     error
+	)
+	if *parallel > 1 || len(shardPrefixes) > 0 || *shardBoundaries != "" {
+		objects, err = r2.ListObjectsSharded(ctx, client, *bucketName, "", shardOptions(*parallel, shardPrefixes, *shardBoundaries))
+	} else {
+		objects, err = r2.ListObjects(ctx, client, *bucketName)
+	}
 	if err != nil {
 	utils.ExitWithError(fmt.Sprintf("Failed to list objects in bucket '%s': %v", *bucketName, err))
 	}
@@ -75,6 +265,9 @@ func handleListCommand(ctx context.Context, client *s3.Client, cfg *config.R2Con
 	}
 
 	for _, obj := range objects {
+		if *hideMarkers && r2.IsDirectoryMarker(obj) {
+			continue
+		}
 	sizeStr := "N/A"
 		if obj.Size != nil {
 			sizeStr = strconv.FormatInt(*obj.Size, 10)
@@ -91,7 +284,10 @@ func handleDownloadCommand(ctx context.Context, client *s3.Client, cfg *config.R
 	downloadFlags.StringVar(objectKey, "key", "", "Specify the object key to download (required)")
 	outputPath := downloadFlags.String("o", "", "Specify the output file path or directory (optional)")
 	downloadFlags.StringVar(outputPath, "output", "", "Specify the output file path or directory (optional)")
-	downloadFlags.Parse(os.Args[2:])
+	preservePerms := downloadFlags.Bool("preserve-perms", false, "Restore the mode, owner and extended attributes recorded at upload (optional)")
+	sseKeyFile := downloadFlags.String("sse-key-file", "", "Decrypt an object uploaded with the 256-bit key in this file (optional)")
+	rangeArg := downloadFlags.String("range", "", "Download only these bytes, e.g. 0-1023, 1024- or -1024 (optional)")
+	parseFlags(downloadFlags, os.Args[2:])
 
 	if *bucketName == "" {
 		utils.ExitWithError("Bucket name not specified. Use -b or --bucket flag, or set DefaultBucket in config.")
@@ -99,6 +295,33 @@ func handleDownloadCommand(ctx context.Context, client *s3.Client, cfg *config.R
 	if *objectKey == "" {
 		utils.ExitWithError("Object key not specified. Use -k or --key flag.")
 	}
+	if strings.HasSuffix(*objectKey, "/") {
+		utils.ExitWithError(fmt.Sprintf("'%s' is a directory marker, not a file.", *objectKey))
+	}
+
+	opts := r2.DownloadOptions{PreservePerms: *preservePerms}
+	if *rangeArg != "" {
+		if *preservePerms {
+			utils.ExitWithError("--range and --preserve-perms cannot be used together.")
+		}
+		byteRange, err := utils.ParseByteRange(*rangeArg)
+		if err != nil {
+			utils.ExitWithError(fmt.Sprintf("Invalid --range value '%s'. Use a range such as 0-1023, 1024- or -1024.", *rangeArg))
+		}
+		opts.Range = byteRange
+	}
+	if *sseKeyFile != "" {
+		key, err := r2.LoadSSECustomerKey(*sseKeyFile)
+		if err != nil {
+			utils.ExitWithError(err.Error())
+		}
+		opts.SSEKey = key
+	}
+
+	if isKeyGlob(*objectKey) {
+		downloadMatches(ctx, client, *bucketName, *objectKey, *outputPath, opts)
+		return
+	}
 
 	finalOutputPath := *outputPath
 	if finalOutputPath == "" {
@@ -113,23 +336,82 @@ func handleDownloadCommand(ctx context.Context, client *s3.Client, cfg *config.R
 		}
 	}
 
-	fmt.Printf("Downloading '%s' from bucket '%s' to '%s'...\n", *objectKey, *bucketName, finalOutputPath)
-	err := r2.DownloadObject(ctx, client, *bucketName, *objectKey, finalOutputPath)
+	if opts.Range != "" {
+		fmt.Printf("Downloading bytes %s of '%s' from bucket '%s' to '%s'...\n", *rangeArg, *objectKey, *bucketName, finalOutputPath)
+	} else {
+		fmt.Printf("Downloading '%s' from bucket '%s' to '%s'...\n", *objectKey, *bucketName, finalOutputPath)
+	}
+	defer waitForTransferSlot(ctx, "download "+*objectKey)()
+	err := r2.DownloadObjectWithOptions(ctx, client, *bucketName, *objectKey, finalOutputPath, opts)
+	if r2.IsInvalidRange(err) {
+		utils.ExitWithError(fmt.Sprintf("Range '%s' starts past the end of object '%s'.", *rangeArg, *objectKey))
+	}
 	if err != nil {
 	utils.ExitWithError(fmt.Sprintf("Failed to download object '%s': %v", *objectKey, err))
 	}
 	fmt.Printf("Successfully downloaded '%s' to '%s'.\n", *objectKey, finalOutputPath)
 }
 
+// downloadMatches downloads every object matching a key glob into outputDir, keeping the path of
+// each key below the literal directory of the pattern.
+func downloadMatches(ctx context.Context, client *s3.Client, bucketName, pattern, outputDir string, opts r2.DownloadOptions) {
+	keys := expandKeyArg(ctx, client, bucketName, pattern)
+	if outputDir == "" {
+		outputDir = "."
+	}
+	base := r2.KeyGlobBase(pattern)
+
+	failed := 0
+	for _, key := range keys {
+		rel := filepath.FromSlash(strings.TrimPrefix(key, base))
+		if !filepath.IsLocal(rel) {
+			fmt.Printf("Failed: '%s' would be written outside '%s'.\n", key, outputDir)
+			failed++
+			continue
+		}
+		localPath := filepath.Join(outputDir, rel)
+		if err := os.MkdirAll(filepath.Dir(localPath), 0o755); err != nil {
+			utils.ExitWithError(fmt.Sprintf("Failed to create directory for '%s': %v", localPath, err))
+		}
+		fmt.Printf("Downloading '%s' from bucket '%s' to '%s'...\n", key, bucketName, localPath)
+		release := waitForTransferSlot(ctx, "download "+key)
+		err := r2.DownloadObjectWithOptions(ctx, client, bucketName, key, localPath, opts)
+		release()
+		if err != nil {
+			fmt.Printf("Failed: %v\n", err)
+			failed++
+		}
+	}
+
+	fmt.Printf("Downloaded %d of %d object(s) matching '%s' to '%s'.\n", len(keys)-failed, len(keys), pattern, outputDir)
+	if failed > 0 {
+		utils.ExitWithError(fmt.Sprintf("Failed to download %d object(s).", failed))
+	}
+}
+
 func handleUploadCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
 	uploadFlags := flag.NewFlagSet("upload", flag.ExitOnError)
 	bucketName := uploadFlags.String("b", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
 	uploadFlags.StringVar(bucketName, "bucket", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
-	filePath := uploadFlags.String("f", "", "Specify the local file to upload (required)")
-	uploadFlags.StringVar(filePath, "file", "", "Specify the local file to upload (required)")
+	filePath := uploadFlags.String("f", "", "Specify the local file to upload, or - for stdin (required)")
+	uploadFlags.StringVar(filePath, "file", "", "Specify the local file to upload, or - for stdin (required)")
 	objectKey := uploadFlags.String("k", "", "Specify the object key for the uploaded file (required)")
 	uploadFlags.StringVar(objectKey, "key", "", "Specify the object key for the uploaded file (required)")
-	uploadFlags.Parse(os.Args[2:])
+	sparse := uploadFlags.Bool("sparse", false, "Upload only the data of a sparse file and record its holes for download (optional)")
+	dedup := uploadFlags.Bool("dedup", false, "Copy server-side instead of uploading if the same content is already in the bucket (optional)")
+	expiresIn := uploadFlags.String("expires-in", "", "Mark the object for deletion by 'gc' after this duration, e.g. 7d or 12h (optional)")
+	preservePerms := uploadFlags.Bool("preserve-perms", false, "Record the file's mode, owner and extended attributes in metadata (optional)")
+	sseKeyFile := uploadFlags.String("sse-key-file", "", "Have R2 encrypt the object with the 256-bit key in this file (optional)")
+	contentType := uploadFlags.String("content-type", "", "Store this Content-Type instead of the detected one (optional)")
+	cacheControl := uploadFlags.String("cache-control", "", "Set the Cache-Control header of the object (optional)")
+	contentDisposition := uploadFlags.String("content-disposition", "", "Set the Content-Disposition header of the object (optional)")
+	contentLanguage := uploadFlags.String("content-language", "", "Set the Content-Language header of the object (optional)")
+	var meta stringListFlag
+	uploadFlags.Var(&meta, "meta", "Set user-defined metadata, as name=value; repeatable (optional)")
+	storageClass := uploadFlags.String("storage-class", "", "Store the object in this storage class, STANDARD or STANDARD_IA (optional)")
+	concurrency := uploadFlags.Int("c", 0, "Specify the number of parts of a large file uploaded in parallel (optional)")
+	uploadFlags.IntVar(concurrency, "concurrency", 0, "Specify the number of parts of a large file uploaded in parallel (optional)")
+	parseFlags(uploadFlags, os.Args[2:])
 
 	if *bucketName == "" {
 		utils.ExitWithError("Bucket name not specified. Use -b or --bucket flag, or set DefaultBucket in config.")
@@ -141,11 +423,94 @@ func handleUploadCommand(ctx context.Context, client *s3.Client, cfg *config.R2C
 		utils.ExitWithError("Object key not specified. Use -k or --key flag.")
 	}
 
+	opts := r2.UploadOptions{Sparse: *sparse, PreservePerms: *preservePerms, Concurrency: *concurrency}
+	switch class := types.StorageClass(strings.ToUpper(*storageClass)); class {
+	case "":
+	case types.StorageClassStandard, types.StorageClassStandardIa:
+		opts.StorageClass = class
+	default:
+		utils.ExitWithError(fmt.Sprintf("Invalid storage class '%s'. Use STANDARD or STANDARD_IA.", *storageClass))
+	}
+	if *concurrency < 0 {
+		utils.ExitWithError("Concurrency must not be negative.")
+	}
+	if *contentType != "" {
+		opts.ContentType = contentType
+	}
+	if *cacheControl != "" {
+		opts.CacheControl = cacheControl
+	}
+	if *contentDisposition != "" {
+		opts.ContentDisposition = contentDisposition
+	}
+	if *contentLanguage != "" {
+		opts.ContentLanguage = contentLanguage
+	}
+	metadata := map[string]string{}
+	for _, entry := range meta {
+		name, value, err := parseMetaPair(entry)
+		if err != nil {
+			utils.ExitWithError(err.Error())
+		}
+		metadata[name] = value
+	}
+	if *expiresIn != "" {
+		ttl, err := utils.ParseDuration(*expiresIn)
+		if err != nil || ttl <= 0 {
+			utils.ExitWithError(fmt.Sprintf("Invalid --expires-in value '%s'. Use a duration such as 7d or 12h.", *expiresIn))
+		}
+		metadata[r2.MetadataExpires] = r2.ExpiresMetadata(time.Now().Add(ttl))
+	}
+	if len(metadata) > 0 {
+		opts.Metadata = metadata
+	}
+
+	if *sseKeyFile != "" {
+		if *dedup {
+			utils.ExitWithError("--dedup cannot be used with --sse-key-file.")
+		}
+		key, err := r2.LoadSSECustomerKey(*sseKeyFile)
+		if err != nil {
+			utils.ExitWithError(err.Error())
+		}
+		opts.SSEKey = key
+	}
+
+	if *dedup {
+		manifest, err := loadHashManifest(*bucketName)
+		if err != nil {
+			utils.ExitWithError(err.Error())
+		}
+		opts.Manifest = manifest
+	}
+
+	refuseAppendOnlyOverwrite(ctx, client, cfg, *bucketName, *objectKey)
+	defer waitForTransferSlot(ctx, "upload "+*objectKey)()
+
+	if *filePath == "-" {
+		// A stream can be neither inspected for holes or permissions nor hashed before uploading.
+		if *sparse || *dedup || *preservePerms {
+			utils.ExitWithError("--sparse, --dedup and --preserve-perms cannot be used when uploading from stdin.")
+		}
+		fmt.Printf("Uploading stdin to bucket '%s' as '%s'...\n", *bucketName, *objectKey)
+		size, err := r2.UploadStreamWithOptions(ctx, client, *bucketName, *objectKey, os.Stdin, opts)
+		if err != nil {
+			utils.ExitWithError(fmt.Sprintf("Failed to upload stdin: %v", err))
+		}
+		fmt.Printf("Successfully uploaded %s from stdin to '%s'.\n", formatSize(size), *objectKey)
+		return
+	}
+
 	fmt.Printf("Uploading '%s' to bucket '%s' as '%s'...\n", *filePath, *bucketName, *objectKey)
-	err := r2.UploadObject(ctx, client, *bucketName, *objectKey, *filePath)
+	err := r2.UploadObjectWithOptions(ctx, client, *bucketName, *objectKey, *filePath, opts)
 	if err != nil {
 		utils.ExitWithError(fmt.Sprintf("Failed to upload file '%s': %v", *filePath, err))
 	}
+	if opts.Manifest != nil {
+		if err := opts.Manifest.Save(); err != nil {
+			utils.ExitWithError(err.Error())
+		}
+	}
 	fmt.Printf("Successfully uploaded '%s' to '%s'.\n", *filePath, *objectKey)
 }
 
@@ -155,23 +520,201 @@ func handleDeleteCommand(ctx context.Context, client *s3.Client, cfg *config.R2C
 	deleteFlags.StringVar(bucketName, "bucket", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
 	objectKey := deleteFlags.String("k", "", "Specify the object key to delete (required)")
 	deleteFlags.StringVar(objectKey, "key", "", "Specify the object key to delete (required)")
-	deleteFlags.Parse(os.Args[2:])
+	overrideProtection := deleteFlags.Bool("override-protection", false, "Delete the object even if it is protected (optional)")
+	prefix := deleteFlags.String("p", "", "Delete every object below this key prefix, together with -r (optional)")
+	deleteFlags.StringVar(prefix, "prefix", "", "Delete every object below this key prefix, together with -r (optional)")
+	recursive := deleteFlags.Bool("r", false, "Delete the objects below --prefix in batches (optional)")
+	deleteFlags.BoolVar(recursive, "recursive", false, "Delete the objects below --prefix in batches (optional)")
+	restoreManifestPath := deleteFlags.String("restore-manifest", "", "List the deleted objects in this JSON file, next to a script restoring them (optional)")
+	abortUploads := deleteFlags.Bool("abort-uploads", false, "Also abort the incomplete multipart uploads below --prefix (optional)")
+	dryRun := deleteFlags.Bool("dry-run", false, "List the objects below --prefix that would be deleted, without deleting them (optional)")
+	parseFlags(deleteFlags, os.Args[2:])
 
 	if *bucketName == "" {
 		utils.ExitWithError("Bucket name not specified. Use -b or --bucket flag, or set DefaultBucket in config.")
 	}
+	var manifest *restoreManifest
+	if *restoreManifestPath != "" {
+		if err := checkRestoreManifestPath(*restoreManifestPath); err != nil {
+			utils.ExitWithError(fmt.Sprintf("Invalid --restore-manifest: %v.", err))
+		}
+		manifest = newRestoreManifest(*bucketName)
+		utils.OnExitWithError(func(string) {
+			saveRestoreManifest(manifest, *restoreManifestPath)
+		})
+		defer saveRestoreManifest(manifest, *restoreManifestPath)
+	}
+	if *recursive || *prefix != "" {
+		if *objectKey != "" {
+			utils.ExitWithError("-k cannot be used with --prefix or -r.")
+		}
+		if !*recursive {
+			utils.ExitWithError("Deleting a prefix requires -r or --recursive.")
+		}
+		if *prefix == "" {
+			utils.ExitWithError("Prefix not specified. Use -p or --prefix flag, or 'bucket empty' to delete every object.")
+		}
+		if *dryRun && manifest != nil {
+			utils.ExitWithError("--restore-manifest cannot be used with --dry-run.")
+		}
+		deletePrefix(ctx, client, cfg, *bucketName, *prefix, *overrideProtection, *abortUploads, *dryRun, manifest)
+		return
+	}
+	if *abortUploads || *dryRun {
+		utils.ExitWithError("--abort-uploads and --dry-run are only used together with -r.")
+	}
 	if *objectKey == "" {
 		utils.ExitWithError("Object key not specified. Use -k or --key flag.")
 	}
 
+	if isKeyGlob(*objectKey) {
+		deleteMatches(ctx, client, cfg, *bucketName, *objectKey, *overrideProtection, manifest)
+		return
+	}
+
+	refuseAppendOnly(cfg, *objectKey, "delete")
+	if !*overrideProtection {
+		if err := r2.CheckNotProtected(ctx, client, *bucketName, *objectKey); err != nil {
+			utils.ExitWithError(fmt.Sprintf("Failed to delete object '%s': %v (use --override-protection to delete it anyway)", *objectKey, err))
+		}
+	}
+
 	fmt.Printf("Deleting '%s' from bucket '%s'...\n", *objectKey, *bucketName)
-	err := r2.DeleteObject(ctx, client, *bucketName, *objectKey)
+	err := deleteRecorded(ctx, client, *bucketName, *objectKey, manifest)
 	if err != nil {
 	utils.ExitWithError(fmt.Sprintf("Failed to delete object '%s': %v", *objectKey, err))
 	}
 	fmt.Printf("Successfully deleted '%s' from '%s'.\n", *objectKey, *bucketName)
 }
 
+// deleteMatches deletes every object matching a key glob, keeping append-only and, unless
+// overrideProtection is set, protected objects. Deleted objects are recorded in manifest, if set.
+func deleteMatches(ctx context.Context, client *s3.Client, cfg *config.R2Config, bucketName, pattern string, overrideProtection bool, manifest *restoreManifest) {
+	keys := expandKeyArg(ctx, client, bucketName, pattern)
+	deleted, kept, failed := deleteKeys(ctx, client, cfg, bucketName, keys, overrideProtection, manifest)
+
+	fmt.Printf("Deleted %d of %d object(s) matching '%s', kept %d, failed %d.\n", deleted, len(keys), pattern, kept, failed)
+	if failed > 0 {
+		utils.ExitWithError(fmt.Sprintf("Failed to delete %d object(s).", failed))
+	}
+}
+
+// deleteKeys deletes objects one by one, printing a line for each, and returns how many were
+// deleted, kept because they are append-only or protected, and failed. Deleted objects are
+// recorded in manifest, if set.
+func deleteKeys(ctx context.Context, client *s3.Client, cfg *config.R2Config, bucketName string, keys []string, overrideProtection bool, manifest *restoreManifest) (deleted, kept, failed int) {
+	for _, key := range keys {
+		if prefix, ok := cfg.AppendOnlyPrefix(key); ok {
+			fmt.Printf("Keeping '%s': prefix '%s' is append-only.\n", key, prefix)
+			kept++
+			continue
+		}
+		if !overrideProtection {
+			err := r2.CheckNotProtected(ctx, client, bucketName, key)
+			if errors.Is(err, r2.ErrProtected) {
+				fmt.Printf("Keeping '%s': object is protected.\n", key)
+				kept++
+				continue
+			}
+			if err != nil {
+				fmt.Printf("Failed: %v\n", err)
+				failed++
+				continue
+			}
+		}
+		fmt.Printf("Deleting '%s'...\n", key)
+		if err := deleteRecorded(ctx, client, bucketName, key, manifest); err != nil {
+			fmt.Printf("Failed: %v\n", err)
+			failed++
+			continue
+		}
+		deleted++
+	}
+	return deleted, kept, failed
+}
+
+// deleteRecorded deletes an object, first fetching its size, ETag and modification time for
+// manifest if set.
+func deleteRecorded(ctx context.Context, client *s3.Client, bucketName, key string, manifest *restoreManifest) error {
+	if manifest == nil {
+		return r2.DeleteObject(ctx, client, bucketName, key)
+	}
+	head, err := client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: &bucketName, Key: &key})
+	if err != nil {
+		return fmt.Errorf("failed to get metadata of object '%s' for the restore manifest: %w", key, err)
+	}
+	if err := r2.DeleteObject(ctx, client, bucketName, key); err != nil {
+		return err
+	}
+	manifest.addObject(types.Object{Key: &key, Size: head.ContentLength, ETag: head.ETag, LastModified: head.LastModified})
+	return nil
+}
+
+// saveRestoreManifest writes a restore manifest, reporting but not failing on errors, since it
+// runs while the command is already finishing.
+func saveRestoreManifest(manifest *restoreManifest, path string) {
+	if err := manifest.save(path); err != nil {
+		utils.Warnf("%v", err)
+	}
+}
+
+// deletePrefix deletes every object below prefix with DeleteObjects batches, keeping append-only
+// and, unless overrideProtection is set, protected objects. Multipart uploads below the prefix
+// may belong to transfers still running, so they are only aborted with abortUploads. Failures
+// are listed once it is done, and deleted objects are recorded in manifest, if set.
+func deletePrefix(ctx context.Context, client *s3.Client, cfg *config.R2Config, bucketName, prefix string, overrideProtection, abortUploads, dryRun bool, manifest *restoreManifest) {
+	if dryRun {
+		fmt.Printf("Listing the objects below '%s' in bucket '%s' that would be deleted...\n", prefix, bucketName)
+	} else {
+		fmt.Printf("Deleting every object below '%s' in bucket '%s'...\n", prefix, bucketName)
+	}
+	opts := r2.EmptyBucketOptions{
+		Bucket: bucketName,
+		Prefix: prefix,
+		Keep: func(key string) bool {
+			_, ok := cfg.AppendOnlyPrefix(key)
+			return ok
+		},
+		OverrideProtection: overrideProtection,
+		KeepUploads:        !abortUploads,
+		DryRun:             dryRun,
+	}
+	if manifest != nil {
+		opts.Deleted = manifest.addObject
+	}
+	result, err := r2.EmptyBucket(ctx, client, opts)
+	printDeleteFailures(result.Failures)
+	switch {
+	case dryRun:
+		fmt.Printf("Would delete %d object(s) and keep %d.\n", result.Deleted, result.Kept)
+	case abortUploads:
+		fmt.Printf("Deleted %d object(s), kept %d, failed %d; aborted %d multipart upload(s).\n",
+			result.Deleted, result.Kept, result.Failed, result.AbortedUploads)
+	default:
+		fmt.Printf("Deleted %d object(s), kept %d, failed %d.\n", result.Deleted, result.Kept, result.Failed)
+	}
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to delete objects below '%s': %v", prefix, err))
+	}
+	if result.Failed > 0 {
+		utils.ExitWithError(fmt.Sprintf("Failed to delete %d object(s) below '%s'.", result.Failed, prefix))
+	}
+	if result.Kept > 0 && !dryRun {
+		fmt.Printf("Kept %d append-only or protected object(s) below '%s'.\n", result.Kept, prefix)
+	}
+}
+
+// printDeleteFailures lists the objects a batch deletion failed to delete.
+func printDeleteFailures(failures []r2.DeleteFailure) {
+	if len(failures) == 0 {
+		return
+	}
+	fmt.Printf("Failed to delete %d object(s):\n", len(failures))
+	for _, failure := range failures {
+		fmt.Printf("  %s: %s\n", failure.Key, failure.Message)
+	}
+}
+
 func handleRenameCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
 	renameFlags := flag.NewFlagSet("rename", flag.ExitOnError)
 	bucketName := renameFlags.String("b", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
@@ -180,7 +723,12 @@ func handleRenameCommand(ctx context.Context, client *s3.Client, cfg *config.R2C
 	renameFlags.StringVar(oldObjectKey, "old-key", "", "Specify the old object key to rename (required)")
 	newObjectKey := renameFlags.String("n", "", "Specify the new object key (required)")
 	renameFlags.StringVar(newObjectKey, "new-key", "", "Specify the new object key (required)")
-	renameFlags.Parse(os.Args[2:])
+	recursive := renameFlags.Bool("r", false, "Rename every object below the old key, taken as a prefix (optional)")
+	renameFlags.BoolVar(recursive, "recursive", false, "Rename every object below the old key, taken as a prefix (optional)")
+	concurrency := renameFlags.Int("c", 8, "Specify the number of parallel renames with -r (optional)")
+	renameFlags.IntVar(concurrency, "concurrency", 8, "Specify the number of parallel renames with -r (optional)")
+	overrideProtection := renameFlags.Bool("override-protection", false, "Rename the object even if it is protected (optional)")
+	parseFlags(renameFlags, os.Args[2:])
 
 	if *bucketName == "" {
 		utils.ExitWithError("Bucket name not specified. Use -b or --bucket flag, or set DefaultBucket in config.")
@@ -192,6 +740,20 @@ func handleRenameCommand(ctx context.Context, client *s3.Client, cfg *config.R2C
 		utils.ExitWithError("New object key not specified. Use -new or --new-key flag.")
 	}
 
+	if *recursive {
+		renamePrefix(ctx, client, cfg, *bucketName, *oldObjectKey, *newObjectKey, *concurrency, *overrideProtection)
+		return
+	}
+
+	refuseAppendOnly(cfg, *oldObjectKey, "rename")
+	refuseAppendOnlyOverwrite(ctx, client, cfg, *bucketName, *newObjectKey)
+	// A rename deletes the original, so a protected one stays where it is.
+	if !*overrideProtection {
+		if err := r2.CheckNotProtected(ctx, client, *bucketName, *oldObjectKey); err != nil {
+			utils.ExitWithError(fmt.Sprintf("Failed to rename object '%s': %v (use --override-protection to rename it anyway)", *oldObjectKey, err))
+		}
+	}
+
 	fmt.Printf("Renaming '%s' to '%s' in bucket '%s'...\n", *oldObjectKey, *newObjectKey, *bucketName)
 	err := r2.RenameObject(ctx, client, *bucketName, *oldObjectKey, *newObjectKey)
 	if err != nil {
@@ -200,47 +762,756 @@ func handleRenameCommand(ctx context.Context, client *s3.Client, cfg *config.R2C
 	fmt.Printf("Successfully renamed '%s' to '%s' in '%s'.\n", *oldObjectKey, *newObjectKey, *bucketName)
 }
 
+// renamePrefix moves every object below oldPrefix to the same key below newPrefix. All keys are
+// listed and checked against the append-only prefixes before the first object is moved, so that
+// a prefix nested in the old one is not renamed twice and a refusal leaves the bucket untouched.
+// Protected objects fail to be renamed unless overrideProtection is set.
+func renamePrefix(ctx context.Context, client *s3.Client, cfg *config.R2Config, bucketName, oldPrefix, newPrefix string, concurrency int, overrideProtection bool) {
+	if !strings.HasSuffix(oldPrefix, "/") {
+		oldPrefix += "/"
+	}
+	if newPrefix != "" && !strings.HasSuffix(newPrefix, "/") {
+		newPrefix += "/"
+	}
+	if oldPrefix == newPrefix {
+		utils.ExitWithError("The old and new prefixes are the same.")
+	}
+
+	fmt.Printf("Listing objects below '%s' in bucket '%s'...\n", oldPrefix, bucketName)
+	var renames []r2.KeyRename
+	err := r2.WalkObjects(ctx, client, bucketName, oldPrefix, func(obj types.Object) error {
+		renames = append(renames, r2.KeyRename{From: *obj.Key, To: newPrefix + strings.TrimPrefix(*obj.Key, oldPrefix)})
+		return nil
+	})
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to list objects in bucket '%s': %v", bucketName, err))
+	}
+	if len(renames) == 0 {
+		fmt.Printf("No objects found below '%s'.\n", oldPrefix)
+		return
+	}
+	for _, rename := range renames {
+		refuseAppendOnly(cfg, rename.From, "rename")
+		refuseAppendOnlyOverwrite(ctx, client, cfg, bucketName, rename.To)
+	}
+
+	fmt.Printf("Renaming %d object(s) from '%s' to '%s'...\n", len(renames), oldPrefix, newPrefix)
+	start := time.Now()
+	done := 0
+	failed := r2.RenameObjects(ctx, client, bucketName, renames, concurrency, overrideProtection, func(rename r2.KeyRename, err error) {
+		done++
+		if err != nil {
+			fmt.Printf("[%d/%d] Failed: %v\n", done, len(renames), err)
+			return
+		}
+		fmt.Printf("[%d/%d] Renamed '%s' to '%s'.\n", done, len(renames), rename.From, rename.To)
+	})
+
+	fmt.Printf("Renamed %d of %d object(s) in %s, %d failed.\n", len(renames)-failed, len(renames), time.Since(start).Round(time.Millisecond), failed)
+	if failed > 0 {
+		utils.ExitWithError(fmt.Sprintf("Failed to rename %d object(s) below '%s'.", failed, oldPrefix))
+	}
+}
+
 func printUsage() {
-	fmt.Println("Usage: go-cfr2 <command> [flags]")
+	fmt.Println("Usage: go-cfr2 [global flags] <command> [flags]")
+	fmt.Println("\nGlobal flags (accepted by every command, given before it):")
+	fmt.Println("  --max-ops-per-second <n> Limit the rate of API requests, including retries and multipart parts (optional)")
+	fmt.Println("                           (Defaults to MaxOpsPerSecond in config, unlimited if unset)")
+	fmt.Println("  --capture-session <file> Record the run's requests, timings and errors, with credentials redacted, for bug reports (optional)")
+	fmt.Println("  --profile <name>         Use the account and credentials of a [profile.<name>] table in config (optional)")
+	fmt.Println("  --request-id <id>        Tag the run's requests, uploaded objects and error output with a correlation ID (optional)")
+	fmt.Println("                           (Defaults to CFR2_REQUEST_ID; R2 sees it as X-Request-ID and objects get request-id metadata)")
+	fmt.Println("  --strict                 Fail on conditions that are otherwise warnings, such as skipped files, partially")
+	fmt.Println("                           preserved metadata or a missing download size, once the command has finished (Defaults to CFR2_STRICT)")
+	fmt.Println("  --offline                Make no requests beyond the command's own operation, such as a clock check before presigning")
+	fmt.Println("                           (Defaults to Offline in config or CFR2_OFFLINE)")
+	fmt.Println("  --literal                Take -k values as keys even if they contain *, ? or [, instead of expanding them as globs")
+	fmt.Println("                           (Without it, escape such a character with \\, e.g. -k 'report\\[1\\].pdf')")
+	fmt.Println("\nAliases defined in config as alias.<name> = '<command line>' run as: go-cfr2 <name> [flags]")
+	fmt.Println("Flag defaults defined in config as [defaults.<command>] tables apply unless the flag is given, e.g. [defaults.upload] concurrency = 8")
 	fmt.Println("\nCommands:")
 	fmt.Println("  list      List all objects in the default R2 bucket")
 	fmt.Println("            Flags:")
 	fmt.Println("              -b, --bucket <name> Specify the R2 bucket name (optional)")
 	fmt.Println("                                   (Defaults to DefaultBucket in config)")
+	fmt.Println("              --parallel <n>       List this many shards of the keyspace concurrently (optional)")
+	fmt.Println("                                   (Shards split at every letter and digit by default)")
+	fmt.Println("              --shard-prefix <prefix> List only below this prefix, one shard per prefix (repeatable)")
+	fmt.Println("              --shard-boundaries <keys> Split the keyspace at these comma-separated keys (optional)")
+	fmt.Println("              --hide-markers       Hide zero-byte directory marker objects (optional)")
 	fmt.Println("\n download  Download an object from the default R2 bucket")
 	fmt.Println("            Flags:")
 	fmt.Println("              -b, --bucket <name> Specify the R2 bucket name (optional)")
 	fmt.Println("                                   (Defaults to DefaultBucket in config)")
 	fmt.Println("              -k, --key <key>      Specify the object key to download (required)")
+	fmt.Println("                                   (A glob such as 'images/*.png' downloads every match into the -o directory)")
 	fmt.Println("              -o, --output <path> Specify the output file path or directory (optional)")
 	fmt.Println("                                   (Defaults to current directory, filename from key)")
+	fmt.Println("              --preserve-perms     Restore the mode, owner and extended attributes recorded at upload (optional)")
+	fmt.Println("              --sse-key-file <f>   Decrypt an object uploaded with the 256-bit key in this file (optional)")
+	fmt.Println("              --range <range>      Download only these bytes, e.g. 0-1023, 1024- or -1024 (optional)")
+	fmt.Println("                                   (Offsets are inclusive; useful to inspect the header of a huge file or resume a transfer)")
 	fmt.Println("\n  upload    Upload a file to the default R2 bucket")
 	fmt.Println("            Flags:")
 	fmt.Println("              -b, --bucket <name> Specify the R2 bucket name (optional)")
 	fmt.Println("                                   (Defaults to DefaultBucket in config)")
-	fmt.Println("              -f, --file <path>    Specify the local file to upload (required)")
+	fmt.Println("              -f, --file <path>    Specify the local file to upload, or - for stdin, e.g. pg_dump | go-cfr2 upload -f - -k db.sql (required)")
 	fmt.Println("              -k, --key <key>      Specify the object key for the uploaded file (required)")
+	fmt.Println("              --sparse             Upload only the data of a sparse file and record its holes for download (optional)")
+	fmt.Println("                                   (download and sync recreate the holes automatically)")
+	fmt.Println("              --dedup              Copy server-side instead of uploading if the same content is already in the bucket (optional)")
+	fmt.Println("                                   (Uses a per-bucket hash manifest under ~/.local/state/cfr2)")
+	fmt.Println("              --expires-in <duration> Mark the object for deletion by 'gc' after this duration, e.g. 7d or 12h (optional)")
+	fmt.Println("              --preserve-perms     Record the file's mode, owner and extended attributes in metadata (optional)")
+	fmt.Println("              --sse-key-file <f>   Have R2 encrypt the object with the 256-bit key in this file (optional)")
+	fmt.Println("              --content-type <type> Store this Content-Type instead of the detected one, e.g. text/html (optional)")
+	fmt.Println("                                   (Detected from the extension of the key or file, or else from the first bytes)")
+	fmt.Println("              --cache-control <value> Set the Cache-Control header, e.g. 'public, max-age=31536000, immutable' (optional)")
+	fmt.Println("              --content-disposition <value> Set the Content-Disposition header, e.g. 'attachment; filename=\"report.pdf\"' (optional)")
+	fmt.Println("              --content-language <lang> Set the Content-Language header, e.g. en-US (optional)")
+	fmt.Println("              --meta <name=value>  Set user-defined metadata; repeatable (optional)")
+	fmt.Println("              --storage-class <class> Store the object in this storage class, STANDARD or STANDARD_IA (optional)")
+	fmt.Println("              -c, --concurrency <n> Specify the number of parts of a large file uploaded in parallel (optional, default 5)")
 	fmt.Println("\n  delete    Delete an object from the default R2 bucket")
 	fmt.Println("            Flags:")
 	fmt.Println("              -b, --bucket <name> Specify the R2 bucket name (optional)")
 	fmt.Println("                                   (Defaults to DefaultBucket in config)")
-	fmt.Println("              -k, --key <key>      Specify the object key to delete (required)")
+	fmt.Println("              -k, --key <key>      Specify the object key to delete (required, unless -r is given)")
+	fmt.Println("                                   (A glob such as 'images/*.png' deletes every match)")
+	fmt.Println("              --override-protection Delete the object even if it is protected (optional)")
+	fmt.Println("              -p, --prefix <prefix> Delete every object below this key prefix, together with -r (optional)")
+	fmt.Println("              -r, --recursive      Delete the objects below --prefix in batches of 1000 (optional)")
+	fmt.Println("              --abort-uploads      Also abort the incomplete multipart uploads below --prefix (optional)")
+	fmt.Println("              --dry-run            List the objects below --prefix that would be deleted, without deleting them (optional)")
+	fmt.Println("              --restore-manifest <file> List the deleted objects in this JSON file, next to a script restoring them (optional)")
+	fmt.Println("                                   (e.g. restore.json and restore.sh; run the script with a directory holding copies)")
 	fmt.Println("\n rename    Rename an object in the default R2 bucket")
 	fmt.Println("            Flags:")
 	fmt.Println("              -b, --bucket <name> Specify the R2 bucket name (optional)")
 	fmt.Println("                                   (Defaults to DefaultBucket in config)")
 	fmt.Println("              -o, --old-key <key>   Specify the old object key to rename (required)")
 	fmt.Println("              -n, --new-key <key>   Specify the new object key (required)")
+	fmt.Println("              -r, --recursive      Rename every object below the old key, taken as a prefix (optional)")
+	fmt.Println("              -c, --concurrency <n> Specify the number of parallel renames with -r (optional, default 8)")
+	fmt.Println("              --override-protection Rename protected objects too (optional)")
 	fmt.Println("\n presign   Generate a presigned URL for an object with default 24-hour expiration")
 	fmt.Println("            Flags:")
 	fmt.Println("              -b, --bucket <name> Specify the R2 bucket name (optional)")
 	fmt.Println("                                   (Defaults to DefaultBucket in config)")
 	fmt.Println("              -k, --key <key>      Specify the object key (required)")
-	fmt.Println("              -e, --expiry <hours> Specify the URL expiry time in hours (optional)")
+	fmt.Println("                                   (A glob such as 'images/*.png' presigns every match)")
+	fmt.Println("              -e, --expiry <hours> Specify the URL expiry time in hours, from 1 to 168 (optional)")
 	fmt.Println("                                   (Defaults to 24 hours)")
+	fmt.Println("              --method <get|put>   Specify the request the URL allows, get to download or put to upload (optional, default get)")
+	fmt.Println("              --content-type <type> Require uploads with --method put to have this Content-Type (optional)")
+	fmt.Println("              --content-length <size> Require uploads with --method put to have exactly this size, e.g. 10MB (optional)")
+	fmt.Println("                                   (--method put prints the headers the upload must send and a curl command)")
+	fmt.Println("\n  presign multipart")
+	fmt.Println("            Start a multipart upload and print presigned URLs for its parts and for completing or aborting it")
+	fmt.Println("            Usage: go-cfr2 presign multipart -k <key> [flags]")
+	fmt.Println("            Flags:")
+	fmt.Println("              -b, --bucket <name> Specify the R2 bucket name (optional)")
+	fmt.Println("                                   (Defaults to DefaultBucket in config)")
+	fmt.Println("              -k, --key <key>      Specify the object key (required)")
+	fmt.Println("              -e, --expiry <hours> Specify the URL expiry time in hours, from 1 to 168 (optional, default 24)")
+	fmt.Println("              --parts <n>          Specify the number of parts (optional)")
+	fmt.Println("              --part-size <size>   Specify the size of every part but the last, e.g. 64MB (optional)")
+	fmt.Println("              --size <size>        Specify the size of the file, which sets the number of parts (optional)")
+	fmt.Println("                                   (Give --parts, --size, or two of the three; parts but the last are at least 5MB)")
+	fmt.Println("              --content-type <type> Specify the Content-Type of the object (optional)")
+	fmt.Println("              --curl               Print a shell script uploading $FILE with curl and completing the upload (optional)")
+	fmt.Println("                                   (Needs the part size, from --part-size or --size)")
+	fmt.Println("\n  dbdump    Stream the output of a dump command into the default R2 bucket")
+	fmt.Println("            Flags:")
+	fmt.Println("              -b, --bucket <name> Specify the R2 bucket name (optional)")
+	fmt.Println("                                   (Defaults to DefaultBucket in config)")
+	fmt.Println("              -c, --cmd <command>  Specify the dump command whose stdout is uploaded (required)")
+	fmt.Println("              -k, --key-template <template>")
+	fmt.Println("                                   Specify the object key template, e.g. 'pg/{{.Date}}.sql.gz' (required)")
+	fmt.Println("                                   (A .gz or .zst extension compresses the dump with gzip or zstd)")
+	fmt.Println("              --keep <count>       Keep only the newest N dumps matching the template (optional)")
+	fmt.Println("              --override-protection Prune old dumps even if they are protected (optional)")
+	fmt.Println("\n  ship-logs Ship new data from local log files into time-partitioned keys")
+	fmt.Println("            Flags:")
+	fmt.Println("              -b, --bucket <name> Specify the R2 bucket name (optional)")
+	fmt.Println("                                   (Defaults to DefaultBucket in config)")
+	fmt.Println("              -d, --dir <path>     Specify the directory containing the log files (required)")
+	fmt.Println("              --pattern <glob>     Specify the glob pattern of log files to ship (optional)")
+	fmt.Println("                                   (Defaults to *.log)")
+	fmt.Println("              --partition <mode>   Specify the key partitioning: hourly or daily (optional)")
+	fmt.Println("                                   (Defaults to hourly, e.g. logs/2025/01/15/14/host-app.log.zst)")
+	fmt.Println("              -p, --prefix <prefix> Specify the key prefix for shipped logs (optional)")
+	fmt.Println("                                   (Defaults to logs/)")
+	fmt.Println("              --compression <codec> Specify the compression of shipped chunks: zstd or gzip (optional)")
+	fmt.Println("                                   (Defaults to zstd, stored as .zst; gzip is stored as .gz)")
+	fmt.Println("              --interval <duration> Keep running and ship new log data at this interval (optional)")
+	fmt.Println("\n  inventory export")
+	fmt.Println("            Write the full bucket listing (key, size, etag, mtime, storage class) back into the bucket")
+	fmt.Println("            Flags:")
+	fmt.Println("              -b, --bucket <name> Specify the R2 bucket name (optional)")
+	fmt.Println("                                   (Defaults to DefaultBucket in config)")
+	fmt.Println("              --format <format>    Specify the inventory format: jsonl, csv or parquet (optional)")
+	fmt.Println("                                   (Defaults to jsonl)")
+	fmt.Println("              -o, --output <prefix> Specify the key prefix the inventory is written under (optional)")
+	fmt.Println("                                   (Defaults to inventory/, e.g. inventory/<bucket>/<timestamp>.jsonl.gz)")
+	fmt.Println("                                   (Parquet inventories compress their pages themselves and end in .parquet)")
+	fmt.Println("              --parallel <n>       List this many shards of the keyspace concurrently (optional)")
+	fmt.Println("                                   (Records are then written in no particular order)")
+	fmt.Println("              --metadata           Include the content type and user-defined metadata of every object (optional)")
+	fmt.Println("                                   (Fetched with concurrent HEAD requests; jsonl only)")
+	fmt.Println("\n  inventory diff")
+	fmt.Println("            Report the objects added, removed and changed between two inventory snapshots")
+	fmt.Println("            Usage: go-cfr2 inventory diff <snapshot> <snapshot> [flags]")
+	fmt.Println("                                   (A snapshot is a local file, an object key, or a timestamp prefix such as 20250115)")
+	fmt.Println("                                   (Only jsonl and csv inventories can be compared)")
+	fmt.Println("            Flags:")
+	fmt.Println("              -b, --bucket <name> Specify the R2 bucket name (optional)")
+	fmt.Println("                                   (Defaults to DefaultBucket in config)")
+	fmt.Println("              -o, --output <prefix> Specify the key prefix inventories were written under (optional)")
+	fmt.Println("                                   (Defaults to inventory/)")
+	fmt.Println("              --summary            Print only the totals, not every object (optional)")
+	fmt.Println("\n  query     Stream a JSON Lines or CSV object and print the records matching field filters")
+	fmt.Println("            Flags:")
+	fmt.Println("              -b, --bucket <name> Specify the R2 bucket name (optional)")
+	fmt.Println("                                   (Defaults to DefaultBucket in config)")
+	fmt.Println("              -k, --key <key>      Specify the object key to query (required)")
+	fmt.Println("                                   (.gz and .zst objects are decompressed on the fly)")
+	fmt.Println("              -w, --where <filter> Specify a field filter such as 'status==500' (repeatable)")
+	fmt.Println("                                   (Operators: == != > < >= <= and ~= for regular expressions)")
+	fmt.Println("              --limit <count>      Stop after this many matching records (optional)")
+	fmt.Println("              --format <format>    Specify the record format: jsonl or csv (optional)")
+	fmt.Println("                                   (Defaults to csv for .csv keys, jsonl otherwise)")
+	fmt.Println("\n  preview   Preview an object in the terminal without downloading it to disk")
+	fmt.Println("            Flags:")
+	fmt.Println("              -b, --bucket <name> Specify the R2 bucket name (optional)")
+	fmt.Println("                                   (Defaults to DefaultBucket in config)")
+	fmt.Println("              -k, --key <key>      Specify the object key to preview (required)")
+	fmt.Println("              -n, --lines <count>  Specify the number of lines shown for text objects (optional)")
+	fmt.Println("                                   (Defaults to 20)")
+	fmt.Println("              --width <pixels>     Specify the image preview width in pixels (optional)")
+	fmt.Println("                                   (Defaults to 480)")
+	fmt.Println("              --protocol <name>    Specify the terminal graphics protocol: auto, kitty, iterm2, sixel or blocks (optional)")
+	fmt.Println("                                   (Defaults to auto, detected from the terminal environment)")
+	fmt.Println("              --max-bytes <bytes>  Refuse to preview images larger than this many bytes (optional)")
+	fmt.Println("                                   (Defaults to 20 MiB)")
+	fmt.Println("\n  sync <dir> Synchronize a local directory with the default R2 bucket")
+	fmt.Println("            Flags:")
+	fmt.Println("              -b, --bucket <name> Specify the R2 bucket name (optional)")
+	fmt.Println("                                   (Defaults to DefaultBucket in config)")
+	fmt.Println("              -p, --prefix <prefix> Specify the key prefix to sync into (optional)")
+	fmt.Println("              --delete             Delete remote objects that no longer exist locally (optional)")
+	fmt.Println("              --archive-to <template> Move deleted objects below this key template instead of deleting them (optional)")
+	fmt.Println("                                   (e.g. 'deleted/{{.Date}}/', must be outside the sync prefix)")
+	fmt.Println("              --restore-manifest <file> List the deleted or archived objects in this JSON file, next to a script restoring them (optional)")
+	fmt.Println("                                   (Archived objects are copied back, deleted ones uploaded from the directory given to the script)")
+	fmt.Println("              --max-delete <n|p%>  Abort if more than this many files, or this percentage such as 10%, would be deleted (optional)")
+	fmt.Println("                                   (0 or 0% refuses any deletion)")
+	fmt.Println("                                   (Guards against an empty or unmounted source directory)")
+	fmt.Println("              --clean-markers      Delete zero-byte directory marker objects below the prefix (optional)")
+	fmt.Println("                                   (Markers are otherwise ignored by sync)")
+	fmt.Println("              --override-protection Delete and overwrite protected objects too (optional)")
+	fmt.Println("              --dedup              Copy server-side instead of uploading content already in the bucket (optional)")
+	fmt.Println("              --preserve-hardlinks Upload hard-linked content once and restore the links on download (optional)")
+	fmt.Println("                                   (Further links are stored as empty stubs pointing at the first one)")
+	fmt.Println("              --preserve-perms     Record mode, owner and extended attributes on upload and restore them on download (optional)")
+	fmt.Println("              --precompress <encodings> Upload precompressed variants of text files next to them, e.g. gzip,br (optional)")
+	fmt.Println("                                   (Stored as <key>.gz or <key>.br with Content-Encoding)")
+	fmt.Println("              --checksum           Compare SHA-256 content hashes instead of size and modification time (optional)")
+	fmt.Println("                                   (Hashes are stored in object metadata by every sync upload)")
+	fmt.Println("              --dry-run            Print the planned actions without executing them (optional)")
+	fmt.Println("              -c, --concurrency <n> Specify the number of parallel transfers (optional)")
+	fmt.Println("                                   (Defaults to 4)")
+	fmt.Println("              --incremental        Compare against the state saved by the previous run instead of listing the bucket (optional)")
+	fmt.Println("                                   (The first run, and runs with --rescan, list the bucket and save the state)")
+	fmt.Println("              --rescan             List the bucket anyway and rebuild the saved state (optional)")
+	fmt.Println("              --bidirectional      Propagate changes and deletions in both directions (optional)")
+	fmt.Println("                                   (Uses a state file from the previous run under ~/.local/state/cfr2)")
+	fmt.Println("                                   (On the first run, files on both sides are compared by content; differing ones are conflicts)")
+	fmt.Println("              --prefer <mode>      Resolve bidirectional conflicts: newer, local, remote or ask (optional)")
+	fmt.Println("                                   (Conflicts are skipped and reported by default)")
+	fmt.Println("              --max-duration <duration> Stop starting transfers after this duration, e.g. 55m, and record the rest for the next run (optional)")
+	fmt.Println("                                   (Transfers in progress are finished; the next run does the recorded work first)")
+	fmt.Println("\n  mkdir     Create a zero-byte directory marker object, for tools that expect folder objects")
+	fmt.Println("            Flags:")
+	fmt.Println("              -b, --bucket <name> Specify the R2 bucket name (optional)")
+	fmt.Println("                                   (Defaults to DefaultBucket in config)")
+	fmt.Println("              -k, --key <key>      Specify the directory key, a trailing slash is added if missing (required)")
+	fmt.Println("\n  gc        Delete objects whose expiry time, set with 'upload --expires-in', has passed")
+	fmt.Println("            Flags:")
+	fmt.Println("              -b, --bucket <name> Specify the R2 bucket name (optional)")
+	fmt.Println("                                   (Defaults to DefaultBucket in config)")
+	fmt.Println("              -p, --prefix <prefix> Only collect objects below this key prefix (optional)")
+	fmt.Println("              --dry-run            Print the expired objects without deleting them (optional)")
+	fmt.Println("              -c, --concurrency <n> Specify the number of parallel metadata requests (optional)")
+	fmt.Println("                                   (Defaults to 8)")
+	fmt.Println("\n  protect   Mark an object as protected so that delete, rename, rekey, dupes, dbdump --keep and sync leave it alone")
+	fmt.Println("            Flags:")
+	fmt.Println("              -b, --bucket <name> Specify the R2 bucket name (optional)")
+	fmt.Println("                                   (Defaults to DefaultBucket in config)")
+	fmt.Println("              -k, --key <key>      Specify the object key to protect (required)")
+	fmt.Println("\n  unprotect Remove the protection mark from an object")
+	fmt.Println("            Flags:")
+	fmt.Println("              -b, --bucket <name> Specify the R2 bucket name (optional)")
+	fmt.Println("                                   (Defaults to DefaultBucket in config)")
+	fmt.Println("              -k, --key <key>      Specify the object key to unprotect (required)")
+	fmt.Println("\n  restore   Download every object below a prefix, most important classes first")
+	fmt.Println("            Flags:")
+	fmt.Println("              -b, --bucket <name> Specify the R2 bucket name (optional)")
+	fmt.Println("                                   (Defaults to DefaultBucket in config)")
+	fmt.Println("              -p, --prefix <prefix> Only restore objects below this key prefix (optional)")
+	fmt.Println("              -d, --dir <path>     Specify the local directory to restore into (required)")
+	fmt.Println("              --plan <file>        Restore classes of objects in the order and with the concurrency of this TOML file (optional)")
+	fmt.Println("                                   (Objects matching no class are restored last)")
+	fmt.Println("              -c, --concurrency <n> Specify the number of parallel downloads for classes without their own (optional, default 8)")
+	fmt.Println("              --match-file-names   Match plan patterns without a '/' against the file name at any depth (optional)")
+	fmt.Println("              --preserve-perms     Restore the mode, owner and extended attributes recorded at upload (optional)")
+	fmt.Println("              --dry-run            Print what would be downloaded, class by class, without downloading (optional)")
+	fmt.Println("\n  deploy    Sync a target defined in the project's cfr2.toml, found in this or a parent directory")
+	fmt.Println("            Flags:")
+	fmt.Println("              -t, --target <name>  Specify the target defined in the project's cfr2.toml (required)")
+	fmt.Println("                                   (Lists the defined targets when omitted)")
+	fmt.Println("              --since <commit>     Only upload files changed, and delete files removed, since this git commit (optional)")
+	fmt.Println("                                   (Includes uncommitted changes and untracked files; the bucket is not listed)")
+	fmt.Println("              --precompress <encodings> Upload precompressed variants of text files next to them, e.g. gzip,br (optional)")
+	fmt.Println("                                   (Stored as <key>.gz or <key>.br with Content-Encoding)")
+	fmt.Println("              --dry-run            Print the planned actions without executing them (optional)")
+	fmt.Println("              -c, --concurrency <n> Specify the number of parallel transfers (optional, default 4)")
+	fmt.Println("              --canary <percent>   Deploy to a canary prefix served to this share of visitors, e.g. 10%, before promoting it (optional)")
+	fmt.Println("                                   (Uploads the site to <prefix>.canary/; promoting copies only the changed files over the live prefix)")
+	fmt.Println("              --verify <command>   Run this shell command against the canary and promote it if the command succeeds (optional)")
+	fmt.Println("                                   (Gets CFR2_CANARY_BUCKET, CFR2_CANARY_PREFIX and CFR2_CANARY_PERCENT; a failure takes the canary out of rotation)")
+	fmt.Println("              --promote            Copy the deployed canary over the live prefix, assets before pages, and remove it (optional)")
+	fmt.Println("              --abort              Remove the deployed canary without promoting it (optional)")
+	fmt.Println("              --slot <blue|green>  Deploy to this slot instead of the live prefix, to be made live with 'deploy switch' (optional)")
+	fmt.Println("                                   (Slots are <prefix>.blue/ and <prefix>.green/; the active slot is refused)")
+	fmt.Println("              --keep <count>       Keep only the newest N recorded deploys of the target, deleting copies no deploy references (optional)")
+	fmt.Println("\n  deploy rollback")
+	fmt.Println("            Restore the objects of a target as recorded for an earlier deploy, with server-side copies and deletions")
+	fmt.Println("            Flags:")
+	fmt.Println("              -t, --target <name>  Specify the target defined in the project's cfr2.toml (required)")
+	fmt.Println("              --to <id>            Specify the ID of the deploy to restore, instead of the previous one (optional)")
+	fmt.Println("              --list               List the recorded deploys of the target instead of rolling back (optional)")
+	fmt.Println("              -c, --concurrency <n> Specify the number of parallel copies (optional, default 4)")
+	fmt.Println("              --keep <count>       Keep only the newest N recorded deploys of the target, deleting copies no deploy references (optional)")
+	fmt.Println("\n  deploy switch")
+	fmt.Println("            Make a slot deployed with 'deploy --slot' live by repointing the <prefix>.slot.json pointer object")
+	fmt.Println("            Usage: go-cfr2 deploy switch -t <target> <blue|green>")
+	fmt.Println("            Flags:")
+	fmt.Println("              -t, --target <name>  Specify the target defined in the project's cfr2.toml (required)")
+	fmt.Println("                                   (Workers written by 'worker generate' follow the pointer within 30 seconds)")
+	fmt.Println("              --off                Delete the slot pointer, so that the live prefix is served again (optional)")
+	fmt.Println("\n  worker generate")
+	fmt.Println("            Write a Cloudflare Worker serving the bucket, with directory indexes, caching and precompressed variants")
+	fmt.Println("            Flags:")
+	fmt.Println("              -b, --bucket <name> Specify the R2 bucket name (optional)")
+	fmt.Println("                                   (Defaults to DefaultBucket in config)")
+	fmt.Println("              -p, --prefix <prefix> Serve the objects below this key prefix (optional)")
+	fmt.Println("              -o, --output <dir>   Specify the directory worker.js and wrangler.toml are written to (optional, default worker)")
+	fmt.Println("              --name <name>        Specify the Worker name (optional, default cfr2-<bucket>)")
+	fmt.Println("              --binding <name>     Specify the name of the R2 binding (optional, default BUCKET)")
+	fmt.Println("              --index <name>       Specify the object served for directory paths (optional, default index.html)")
+	fmt.Println("              --not-found <key>    Specify the key, relative to the prefix, served with status 404 for missing objects (optional)")
+	fmt.Println("              --cache-control <value> Specify the Cache-Control header of objects without their own (optional)")
+	fmt.Println("                                   (Defaults to public, max-age=3600; fingerprinted names are cached as immutable)")
+	fmt.Println("              --signed             Only serve requests signed by 'go-cfr2 share' with the SHARE_SECRET secret (optional)")
+	fmt.Println("              --short-links        Write the Worker redirecting the short links of 'go-cfr2 share --short' instead (optional)")
+	fmt.Println("                                   (Binds ShortLinkNamespaceID as LINKS; deploy it on the domain of ShortLinkBaseURL)")
+	fmt.Println("              --force              Overwrite existing files (optional)")
+	fmt.Println("\n  get-url   Download a presigned or public URL in parallel segments, without configuration or credentials")
+	fmt.Println("            Usage: go-cfr2 get-url <url> [flags]")
+	fmt.Println("            Flags:")
+	fmt.Println("              -o, --output <path> Specify the output file path (optional)")
+	fmt.Println("                                   (Defaults to the file name in the URL)")
+	fmt.Println("              -c, --concurrency <n> Specify the number of segments downloaded in parallel (optional, default 4)")
+	fmt.Println("              --sha256 <digest>    Verify the download against this hex SHA-256 digest (optional)")
+	fmt.Println("                                   (Otherwise verified against the sha256 metadata or an MD5 ETag when present)")
+	fmt.Println("                                   (Interrupted downloads resume when the command is run again)")
+	fmt.Println("\n  config export-bundle")
+	fmt.Println("            Write an encrypted, expiring config bundle with a newly created token scoped to some buckets")
+	fmt.Println("            Flags:")
+	fmt.Println("              -o, --output <path> Specify the bundle file to write (required)")
+	fmt.Println("              -b, --buckets <names> Specify the comma-separated buckets the bundle grants access to (optional)")
+	fmt.Println("                                   (Defaults to DefaultBucket in config)")
+	fmt.Println("              --read-only          Grant read access only (optional)")
+	fmt.Println("              --expires <duration> Specify how long the bundle and its token stay valid, e.g. 7d or 12h (optional, default 7d)")
+	fmt.Println("                                   (Needs APIToken in config with permission to create API tokens)")
+	fmt.Println("")
+	fmt.Println("  config import-bundle <file>")
+	fmt.Println("            Decrypt a bundle and write it as the config file, no existing config needed")
+	fmt.Println("            Flags:")
+	fmt.Println("              --force              Replace an existing config file (optional)")
+	fmt.Println("                                   (The passphrase is read from CFR2_BUNDLE_PASSPHRASE or prompted for)")
+	fmt.Println("\n  scrub     Re-download objects and verify them against their stored checksums")
+	fmt.Println("            Usage: go-cfr2 scrub [flags]")
+	fmt.Println("            Flags:")
+	fmt.Println("              -b, --bucket <name>  Specify the R2 bucket name (optional, uses DefaultBucket from config)")
+	fmt.Println("              -p, --prefix <prefix> Only check objects below this key prefix (optional)")
+	fmt.Println("              --sample <share>     Check this share of objects, picked at random, such as 5% (optional, default 100%)")
+	fmt.Println("              -c, --concurrency <n> Specify the number of parallel downloads (optional, default 4)")
+	fmt.Println("                                   (Objects are checked against the SHA-256 recorded at upload, or their MD5 ETag)")
+	fmt.Println("\n  snapshot create")
+	fmt.Println("            Capture the listing, metadata, content headers and tags of every object into a local file")
+	fmt.Println("            Usage: go-cfr2 snapshot create -o <file> [flags]")
+	fmt.Println("            Flags:")
+	fmt.Println("              -b, --bucket <name>  Specify the R2 bucket name (optional, uses DefaultBucket from config)")
+	fmt.Println("              -p, --prefix <prefix> Only capture objects below this key prefix (optional)")
+	fmt.Println("              -o, --output <file>  Specify the local file the snapshot is written to, e.g. bucket.manifest.gz (required)")
+	fmt.Println("                                   (A .gz or .zst extension compresses the snapshot with gzip or zstd)")
+	fmt.Println("              --no-tags            Do not capture object tags (optional)")
+	fmt.Println("\n  snapshot restore-metadata")
+	fmt.Println("            Reapply the metadata, content headers and tags of a snapshot, e.g. after a migration")
+	fmt.Println("            Usage: go-cfr2 snapshot restore-metadata <file> [flags]")
+	fmt.Println("            Flags:")
+	fmt.Println("              -b, --bucket <name>  Specify the R2 bucket name (optional, uses DefaultBucket from config)")
+	fmt.Println("              -p, --prefix <prefix> Only restore objects below this key prefix (optional)")
+	fmt.Println("\n  whoami    Show the account and key in use, and which buckets they can access")
+	fmt.Println("            Usage: go-cfr2 whoami [flags]")
+	fmt.Println("            Flags:")
+	fmt.Println("              -b, --bucket <name>  Check access to this bucket, even if the key cannot list buckets (optional, uses DefaultBucket from config)")
+	fmt.Println("              --write              Also check write access by uploading and deleting a probe object (optional)")
+	fmt.Println("                                   (The expiry of APIToken is reported when it is configured)")
+	fmt.Println("\n  accounts overview")
+	fmt.Println("            List the buckets of every configured profile with their object counts and sizes")
+	fmt.Println("            Usage: go-cfr2 accounts overview [flags]")
+	fmt.Println("            Flags:")
+	fmt.Println("              --no-sizes           Only list the buckets, without counting their objects (optional)")
+	fmt.Println("              --parallel <n>       List this many shards of each bucket's keyspace concurrently (optional)")
+	fmt.Println("                                   (Profiles are written as [profile.<name>] tables in config, see below)")
+	fmt.Println("\n  bucket create")
+	fmt.Println("            Create a bucket")
+	fmt.Println("            Usage: go-cfr2 bucket create <bucket> [flags]")
+	fmt.Println("            Flags:")
+	fmt.Println("              --location <hint>    Specify a location hint: wnam, enam, weur, eeur, apac or oc (optional)")
+	fmt.Println("\n  bucket delete")
+	fmt.Println("            Delete a bucket, which must be empty unless --empty-first is given")
+	fmt.Println("            Usage: go-cfr2 bucket delete <bucket> [flags]")
+	fmt.Println("            Flags:")
+	fmt.Println("              --empty-first        Delete the objects of the bucket before the bucket (optional)")
+	fmt.Println("              --confirm <bucket>   Confirm by repeating the bucket name instead of typing it (optional)")
+	fmt.Println("              --override-protection Delete protected objects too, with --empty-first (optional)")
+	fmt.Println("                                   (Append-only keys are always kept, which leaves the bucket in place)")
+	fmt.Println("\n  bucket info")
+	fmt.Println("            Show the creation date, location, public access, custom domains, object count and size, CORS and lifecycle rules of a bucket")
+	fmt.Println("            Usage: go-cfr2 bucket info [bucket] [flags]")
+	fmt.Println("            Flags:")
+	fmt.Println("              --no-sizes           Skip counting the objects of the bucket (optional)")
+	fmt.Println("              --parallel <n>       List this many shards of the bucket's keyspace concurrently when counting (optional)")
+	fmt.Println("                                   (Defaults to DefaultBucket in config; APIToken adds the Cloudflare API details)")
+	fmt.Println("\n  bucket empty")
+	fmt.Println("            Delete every object in a bucket in batches and abort its incomplete multipart uploads")
+	fmt.Println("            Usage: go-cfr2 bucket empty <bucket> [flags]")
+	fmt.Println("            Flags:")
+	fmt.Println("              --confirm <bucket>   Confirm by repeating the bucket name instead of typing it (optional)")
+	fmt.Println("              --override-protection Delete protected objects too (optional)")
+	fmt.Println("                                   (Append-only keys are always kept)")
+	fmt.Println("\n  bucket plan")
+	fmt.Println("            Compare the CORS, lifecycle and event notification rules of a bucket with a TOML rules file")
+	fmt.Println("            Usage: go-cfr2 bucket plan [bucket] --rules <file>")
+	fmt.Println("\n  bucket apply")
+	fmt.Println("            Show the plan, then change the bucket to match the rules file")
+	fmt.Println("            Usage: go-cfr2 bucket apply [bucket] --rules <file> [--yes]")
+	fmt.Println("            Flags:")
+	fmt.Println("              --yes                Apply without asking for confirmation (optional)")
+	fmt.Println("                                   (Sections missing from the file are left alone; notifications need APIToken)")
+	fmt.Println("\n  scratch create")
+	fmt.Println("            Create a uniquely named throwaway bucket, or a prefix in an existing bucket, that expires after a TTL")
+	fmt.Println("            Usage: go-cfr2 scratch create [flags]")
+	fmt.Println("            Flags:")
+	fmt.Println("              -b, --bucket <name>  Create a prefix in this bucket instead of a new bucket (optional)")
+	fmt.Println("              --ttl <duration>     Specify how long the scratch space lives, e.g. 2h, 24h or 7d (default 24h)")
+	fmt.Println("                                   (Prints only the bucket, or bucket/prefix, on stdout, e.g. BUCKET=$(go-cfr2 scratch create))")
+	fmt.Println("                                   (The expiry is stored in the object .cfr2-scratch.json of the space, so any machine can remove it)")
+	fmt.Println("\n  scratch list")
+	fmt.Println("            List the scratch buckets of the account, and the scratch prefixes of some buckets, and when they expire")
+	fmt.Println("            Usage: go-cfr2 scratch list [flags]")
+	fmt.Println("            Flags:")
+	fmt.Println("              -b, --bucket <names> Specify the comma-separated buckets to look for scratch prefixes in (optional)")
+	fmt.Println("                                   (Defaults to DefaultBucket in config)")
+	fmt.Println("\n  scratch gc")
+	fmt.Println("            Delete expired scratch spaces with all their objects and multipart uploads")
+	fmt.Println("            Usage: go-cfr2 scratch gc [flags]")
+	fmt.Println("            Flags:")
+	fmt.Println("              -b, --bucket <names> Specify the comma-separated buckets to look for scratch prefixes in (optional)")
+	fmt.Println("                                   (Defaults to DefaultBucket in config)")
+	fmt.Println("              --all                Remove every scratch space, including those not yet expired or without a marker (optional)")
+	fmt.Println("              --dry-run            Print the scratch spaces without removing them (optional)")
+	fmt.Println("\n  ci-upload")
+	fmt.Println("            Upload build artifacts with presigned links, reported as CI annotations and a step summary")
+	fmt.Println("            Usage: go-cfr2 ci-upload [flags] <file|glob>...")
+	fmt.Println("            Flags:")
+	fmt.Println("              -b, --bucket <name>  Specify the R2 bucket name (optional)")
+	fmt.Println("              -p, --prefix <tmpl>  Specify the key prefix, which may use key template fields (default ci/{{.Timestamp}}/)")
+	fmt.Println("              --expires <duration> Specify how long the presigned links stay valid, at most 7d (default 7d)")
+	fmt.Println("              --format <format>    Specify the output format: auto, github, gitlab or plain (default auto)")
+	fmt.Println("              --summary <file>     Also write the Markdown artifact table to this file (optional)")
+	fmt.Println("                                   (GitHub Actions gets ::notice annotations and $GITHUB_STEP_SUMMARY;")
+	fmt.Println("                                   GitLab gets collapsible log sections)")
+	fmt.Println("\n  serve")
+	fmt.Println("            Serve a bucket or local directory read-only over HTTP, answering conditional and range requests")
+	fmt.Println("            Usage: go-cfr2 serve [flags]")
+	fmt.Println("            Flags:")
+	fmt.Println("              -b, --bucket <name>  Specify the R2 bucket name (optional)")
+	fmt.Println("              -p, --prefix <prefix> Serve only the objects under this prefix (optional)")
+	fmt.Println("              --dir <dir>          Serve a local directory instead of a bucket (optional)")
+	fmt.Println("              --multi-bucket       Serve every bucket at /<bucket>/<key>, e.g. for tokens scoped per team (optional)")
+	fmt.Println("              --listen <addr>      Specify the address to listen on (default 127.0.0.1:8080)")
+	fmt.Println("              --index <name>       Specify the document served for paths ending in a slash (default index.html)")
+	fmt.Println("              --cache-control <value> Specify the Cache-Control sent for objects without their own (optional)")
+	fmt.Println("              --cache-ttl <duration> Cache objects in memory and revalidate them after this long (optional)")
+	fmt.Println("              --cache-dir <dir>    Keep objects evicted from the memory cache in this directory (optional)")
+	fmt.Println("              --shutdown-timeout <duration> Specify how long in-flight requests may take to finish on SIGTERM (default 30s)")
+	fmt.Println("              --health-prefix <path> Specify the path prefix of the health endpoints, off to disable (default /_cfr2/)")
+	fmt.Println("                                   (<prefix>healthz and <prefix>readyz answer health and readiness probes without authentication)")
+	fmt.Println("\n  daemon")
+	fmt.Println("            Run the transfer queue: uploads, downloads and copies run by hand go ahead of the transfers of a sync")
+	fmt.Println("            Usage: go-cfr2 daemon [flags]")
+	fmt.Println("            Flags:")
+	fmt.Println("              --slots <n>          Specify the number of transfers running at once across all processes (default 4)")
+	fmt.Println("                                   (Without a running daemon every transfer goes ahead at once)")
+	fmt.Println("\n  queue status")
+	fmt.Println("            Show the transfers running and waiting in the daemon's queue")
+	fmt.Println("            Usage: go-cfr2 queue status")
+	fmt.Println("\n  stat")
+	fmt.Println("            Show an object's size, ETag, content type, last-modified time and custom metadata without downloading it")
+	fmt.Println("            Usage: go-cfr2 stat -k <key> [flags]")
+	fmt.Println("            Flags:")
+	fmt.Println("              -b, --bucket <name>  Specify the R2 bucket name (optional)")
+	fmt.Println("              -k, --key <key>      Specify the object key to describe (required)")
+	fmt.Println("                                   (A glob such as 'images/*.png' describes every match)")
+	fmt.Println("              -r, --recursive      Describe every object below --key, taken as a prefix (optional)")
+	fmt.Println("              -c, --concurrency <n> Specify the number of parallel HEAD requests with --recursive (optional, default 16)")
+	fmt.Println("\n  cat")
+	fmt.Println("            Stream an object's content to stdout, e.g. go-cfr2 cat -k logs/app.log | grep ERROR")
+	fmt.Println("            Usage: go-cfr2 cat -k <key> [flags]")
+	fmt.Println("            Flags:")
+	fmt.Println("              -b, --bucket <name>  Specify the R2 bucket name (optional)")
+	fmt.Println("              -k, --key <key>      Specify the object key to print (required)")
+	fmt.Println("              --range <range>      Print only these bytes, e.g. 0-1023, 1024- or -1024 (optional)")
+	fmt.Println("\n  index build")
+	fmt.Println("            List a bucket into a local index, optionally with the metadata and tags of every object, for 'find'")
+	fmt.Println("            Rebuilding only fetches the details of objects that are new or changed since the last build")
+	fmt.Println("            Usage: go-cfr2 index build [flags]")
+	fmt.Println("            Flags:")
+	fmt.Println("              -b, --bucket <name>  Specify the R2 bucket name (optional)")
+	fmt.Println("              -p, --prefix <prefix> Only index objects below this key prefix (optional)")
+	fmt.Println("              --metadata           Store the metadata and content headers of every object (optional)")
+	fmt.Println("              --tags               Store the tags of every object (optional)")
+	fmt.Println("              --parallel <n>       Fetch the details of this many objects concurrently (default 8)")
+	fmt.Println("\n  index status")
+	fmt.Println("            Show how many objects the local index of a bucket holds, what it stores and when it was built")
+	fmt.Println("            Usage: go-cfr2 index status [-b bucket]")
+	fmt.Println("\n  find")
+	fmt.Println("            Print the keys matching all filters, e.g. go-cfr2 find --name '*.log' --older-than 30d")
+	fmt.Println("            Usage: go-cfr2 find [flags]")
+	fmt.Println("            Flags:")
+	fmt.Println("              -b, --bucket <name>  Specify the R2 bucket name (optional)")
+	fmt.Println("              -p, --prefix <prefix> Only find objects below this key prefix (optional)")
+	fmt.Println("              --name <glob>        Only find objects whose base name matches this glob (optional)")
+	fmt.Println("              --meta <name[=value]> Only find objects with this metadata; repeatable (optional)")
+	fmt.Println("              --tag <name[=value]> Only find objects with this tag; repeatable (optional)")
+	fmt.Println("              --larger-than <size> Only find objects larger than this size, e.g. 100M (optional)")
+	fmt.Println("              --smaller-than <size> Only find objects smaller than this size, e.g. 1K (optional)")
+	fmt.Println("              --older-than <age|date> Only find objects modified before this age or date, e.g. 30d or 2024-01-01 (optional)")
+	fmt.Println("              --newer-than <age|date> Only find objects modified after this age or date, e.g. 12h or 2024-01-01 (optional)")
+	fmt.Println("              --index              Search the local index instead of listing the bucket; implied by --meta and --tag (optional)")
+	fmt.Println("              --delete             Delete every matching object, except append-only and protected ones (optional)")
+	fmt.Println("                                   (Matches from the index are checked first; objects changed since it was built are skipped)")
+	fmt.Println("              --presign            Print a presigned URL next to every matching key (optional)")
+	fmt.Println("              --expiry <duration>  Specify the expiry of the URLs printed with --presign (optional, default 24h)")
+	fmt.Println("                                   (The bucket is listed; --meta and --tag need an index built with 'go-cfr2 index build')")
+	fmt.Println("\n  metadata get")
+	fmt.Println("            Print the content headers and user-defined metadata of objects ('meta' is short for 'metadata')")
+	fmt.Println("            Usage: go-cfr2 metadata get -k <key> [flags]")
+	fmt.Println("            Flags:")
+	fmt.Println("              -b, --bucket <name>  Specify the R2 bucket name (optional, uses DefaultBucket from config)")
+	fmt.Println("              -k, --key <key>      Specify the object key, or a glob such as 'assets/*.js' (required)")
+	fmt.Println("              --json               Print one JSON object per line, as 'metadata export' writes (optional)")
+	fmt.Println("\n  metadata set")
+	fmt.Println("            Change the user-defined metadata and content headers of objects by copying each one onto itself")
+	fmt.Println("            Usage: go-cfr2 metadata set -k <key> [flags]")
+	fmt.Println("            Flags:")
+	fmt.Println("              -b, --bucket <name>  Specify the R2 bucket name (optional, uses DefaultBucket from config)")
+	fmt.Println("              -k, --key <key>      Specify the object key, or a glob such as 'assets/*.js' (required)")
+	fmt.Println("              --meta <name=value>  Set user-defined metadata; repeatable (optional)")
+	fmt.Println("              --remove <name>      Remove user-defined metadata; repeatable (optional)")
+	fmt.Println("              --content-type <type> Set the Content-Type header (optional)")
+	fmt.Println("              --cache-control <value> Set the Cache-Control header (optional)")
+	fmt.Println("              --content-disposition <value> Set the Content-Disposition header (optional)")
+	fmt.Println("              --content-encoding <value> Set the Content-Encoding header (optional)")
+	fmt.Println("              --content-language <value> Set the Content-Language header (optional)")
+	fmt.Println("                                   (An empty header value removes the header; the content is not changed)")
+	fmt.Println("\n  metadata export")
+	fmt.Println("            Write the metadata, content headers and tags of every object to a file that can be reviewed and edited")
+	fmt.Println("            Usage: go-cfr2 metadata export -o <file> [flags]")
+	fmt.Println("            Flags:")
+	fmt.Println("              -b, --bucket <name>  Specify the R2 bucket name (optional, uses DefaultBucket from config)")
+	fmt.Println("              -p, --prefix <prefix> Only export objects below this key prefix (optional)")
+	fmt.Println("              -o, --output <file>  Specify the local file the metadata is written to, e.g. meta.jsonl (required)")
+	fmt.Println("              --no-tags            Do not export object tags (optional)")
+	fmt.Println("\n  metadata import")
+	fmt.Println("            Apply the metadata, content headers and tags of an exported file by copying each changed object onto itself")
+	fmt.Println("            Usage: go-cfr2 metadata import <file> [flags]")
+	fmt.Println("            Flags:")
+	fmt.Println("              -b, --bucket <name>  Specify the R2 bucket name (optional, uses DefaultBucket from config)")
+	fmt.Println("              -p, --prefix <prefix> Only import objects below this key prefix (optional)")
+	fmt.Println("              --dry-run            Show the changes without making them (optional)")
+	fmt.Println("              --force              Also update objects whose content changed since the export (optional)")
+	fmt.Println("                                   (Such objects are skipped by default, as the file may be stale)")
+	fmt.Println("                                   (\"tags\": {} removes the tags of an object; without a tags entry they are kept)")
+	fmt.Println("\n  cp        Upload, download or copy server-side, depending on which side is an r2://bucket/key URI")
+	fmt.Println("            Usage: go-cfr2 cp <source> <destination>")
+	fmt.Println("                                   (A key ending in / or left out takes the name of the source; - is stdin or stdout)")
+	fmt.Println("\n  share     Print a signed link to a path served by a Worker written by 'worker generate --signed', or a presigned URL")
+	fmt.Println("            Usage: go-cfr2 share -k <path> [flags]")
+	fmt.Println("            Flags:")
+	fmt.Println("              -k, --key <path>     Specify the path below the Worker's prefix (required, unless --signed-cookie is given)")
+	fmt.Println("              -e, --expiry <hours> Specify the link expiry time in hours (optional, default 24)")
+	fmt.Println("              --token              Sign the link for this path only (optional, the default)")
+	fmt.Println("              --signed-cookie      Sign the link for every path below --key, which must end in /, and keep it in a cookie (optional)")
+	fmt.Println("                                   (Needs ShareBaseURL and ShareSecret in config; links point at the Worker's custom domain)")
+	fmt.Println("              --presigned          Share a presigned URL of the object --key instead of a Worker link (optional)")
+	fmt.Println("              -b, --bucket <name>  Specify the R2 bucket name for --presigned (optional, uses DefaultBucket from config)")
+	fmt.Println("              --short              Also print a short link redirecting to the link, stored in Workers KV (optional)")
+	fmt.Println("                                   (Needs APIToken, ShortLinkNamespaceID and ShortLinkBaseURL in config)")
+	fmt.Println("\n  dupes     Report sets of objects with the same content and the space a single copy of each would save")
+	fmt.Println("            Usage: go-cfr2 dupes [flags]")
+	fmt.Println("            Flags:")
+	fmt.Println("              -b, --bucket <name>  Specify the R2 bucket name (optional, uses DefaultBucket from config)")
+	fmt.Println("              -p, --prefix <prefix> Only compare objects below this key prefix (optional)")
+	fmt.Println("              -c, --concurrency <n> Specify the number of parallel requests (optional, default 8)")
+	fmt.Println("              --delete             Delete every duplicate but the oldest object of each set (optional)")
+	fmt.Println("              --link               Replace every duplicate but the oldest with a hard link stub to it (optional)")
+	fmt.Println("              --override-protection Delete or link protected duplicates too (optional)")
+	fmt.Println("              --dry-run            Print what --delete or --link would do without doing it (optional)")
+	fmt.Println("                                   (Content is matched on the SHA-256 recorded at upload, an MD5 ETag, or sampled")
+	fmt.Println("                                   ranged reads; sets matched on samples only are never deleted or linked)")
+	fmt.Println("\n  report types")
+	fmt.Println("            Break the objects below a prefix down by file extension or content type, with their sizes and largest object")
+	fmt.Println("            Usage: go-cfr2 report types [flags]")
+	fmt.Println("            Flags:")
+	fmt.Println("              -b, --bucket <name>  Specify the R2 bucket name (optional, uses DefaultBucket from config)")
+	fmt.Println("              -p, --prefix <prefix> Only count objects below this key prefix (optional)")
+	fmt.Println("              --by <grouping>      Group objects by 'extension' or 'content-type' (optional, default extension)")
+	fmt.Println("              -c, --concurrency <n> Specify the number of parallel metadata requests with --by content-type (optional, default 16)")
+	fmt.Println("                                   (Grouping by content type takes a HEAD request per object)")
+	fmt.Println("\n  head      Print the first lines of an object, fetching only as much of it as needed")
+	fmt.Println("            Usage: go-cfr2 head -k <key> [flags]")
+	fmt.Println("            Flags:")
+	fmt.Println("              -b, --bucket <name>  Specify the R2 bucket name (optional, uses DefaultBucket from config)")
+	fmt.Println("              -k, --key <key>      Specify the object key to read (required)")
+	fmt.Println("              -n, --lines <count>  Specify the number of lines to print (optional, default 10)")
+	fmt.Println("\n  tail      Print the last lines of an object, reading it backwards from the end with ranged requests")
+	fmt.Println("            Usage: go-cfr2 tail -k <key> [flags]")
+	fmt.Println("            Flags:")
+	fmt.Println("              -b, --bucket <name>  Specify the R2 bucket name (optional, uses DefaultBucket from config)")
+	fmt.Println("              -k, --key <key>      Specify the object key to read (required)")
+	fmt.Println("              -n, --lines <count>  Specify the number of lines to print (optional, default 10)")
+	fmt.Println("              -f, --follow         Keep printing data appended to the object (optional)")
+	fmt.Println("              --interval <duration> Specify how often --follow checks the object for new data (optional, default 5s)")
+	fmt.Println("                                   (An object that shrinks is taken to be replaced and printed from the start)")
+	fmt.Println("\n  grep      Search objects for lines matching a regular expression and print them as key:line, without downloading them")
+	fmt.Println("            Usage: go-cfr2 grep (-k <key> | -p <prefix>) <pattern> [flags]")
+	fmt.Println("            Flags:")
+	fmt.Println("              -b, --bucket <name>  Specify the R2 bucket name (optional, uses DefaultBucket from config)")
+	fmt.Println("              -k, --key <key>      Specify the object key, or a glob such as 'logs/2025-01-*.log', to search")
+	fmt.Println("              -p, --prefix <prefix> Search every object below this key prefix")
+	fmt.Println("              -i                   Ignore case when matching (optional)")
+	fmt.Println("              -n                   Print the line number of every match (optional)")
+	fmt.Println("              -c, --count          Print the number of matching lines of each object instead of the lines (optional)")
+	fmt.Println("              --concurrency <n>    Specify the number of objects searched at once (optional, default 8)")
+	fmt.Println("                                   (Either -k or -p is required; objects ending in .gz or .zst are decompressed on the fly)")
+	fmt.Println("\n  tree")
+	fmt.Println("            Show a bucket as a directory tree with the size of every directory, e.g. go-cfr2 tree -p logs/ -L 2")
+	fmt.Println("            Usage: go-cfr2 tree [flags]")
+	fmt.Println("            Flags:")
+	fmt.Println("              -b, --bucket <name>  Specify the R2 bucket name (optional)")
+	fmt.Println("              -p, --prefix <prefix> Show the tree below this directory prefix (optional)")
+	fmt.Println("              -L, --depth <n>      Descend at most this many directory levels, 0 for all (optional, default 0)")
+	fmt.Println("              -d, --dirs-only      Show directories only (optional)")
+	fmt.Println("                                   (Keys are split into directories at '/')")
+	fmt.Println("\n  exists    Check whether an object exists, for shell scripts, e.g. go-cfr2 exists -k backups/db.sql && echo found")
+	fmt.Println("            Usage: go-cfr2 exists -k <key> [flags]")
+	fmt.Println("            Flags:")
+	fmt.Println("              -b, --bucket <name>  Specify the R2 bucket name (optional, uses DefaultBucket from config)")
+	fmt.Println("              -k, --key <key>      Specify the object key to check (required)")
+	fmt.Println("              -v, --verbose        Print whether the object exists, and errors (optional)")
+	fmt.Println("                                   (Exits with status 0 if the object exists, 1 if it does not, and 2 on errors)")
+	fmt.Println("\n  zip       Archive the objects below a prefix into a zip file, e.g. go-cfr2 zip -p reports/2024/ -o reports-2024.zip")
+	fmt.Println("            Usage: go-cfr2 zip -p <prefix> -o <path|r2://bucket/key> [flags]")
+	fmt.Println("            Flags:")
+	fmt.Println("              -b, --bucket <name>  Specify the R2 bucket name (optional, uses DefaultBucket from config)")
+	fmt.Println("              -p, --prefix <prefix> Specify the key prefix of the objects to archive (required)")
+	fmt.Println("              -o, --output <path>  Specify the local archive path, or r2://bucket/key to upload it (required)")
+	fmt.Println("              --store              Store objects without compressing them, e.g. for images or archives (optional)")
+	fmt.Println("                                   (Objects are streamed into the archive; entries are named relative to the prefix's directory)")
+	fmt.Println("\n  buckets   List the buckets of the account with their creation dates")
+	fmt.Println("            Usage: go-cfr2 buckets [flags]")
+	fmt.Println("            Flags:")
+	fmt.Println("              --json               Print the buckets as a JSON array (optional)")
+	fmt.Println("\n  expand    Extract an archive in the bucket into one object per file, e.g. go-cfr2 expand -k uploads/site.zip -p site/")
+	fmt.Println("            Usage: go-cfr2 expand -k <key> -p <prefix> [flags]")
+	fmt.Println("            Flags:")
+	fmt.Println("              -b, --bucket <name>  Specify the R2 bucket name (optional, uses DefaultBucket from config)")
+	fmt.Println("              -k, --key <key>      Specify the key of the .zip, .tar, .tar.gz or .tgz archive (required)")
+	fmt.Println("              -p, --prefix <prefix> Specify the key prefix the files are uploaded below (required)")
+	fmt.Println("              --dry-run            Print the keys that would be uploaded without uploading (optional)")
+	fmt.Println("                                   (Content types are set from the file extensions; append-only keys are kept)")
+	fmt.Println("\n  rekey     Rename objects by transforming their keys or from a mapping file, e.g. go-cfr2 rekey -p photos/ -t 'lower,spaces-to-dashes,nfc' --dry-run")
+	fmt.Println("            Usage: go-cfr2 rekey (-t <transforms> | --map <file>) [flags]")
+	fmt.Println("            Flags:")
+	fmt.Println("              -b, --bucket <name>  Specify the R2 bucket name (optional, uses DefaultBucket from config)")
+	fmt.Println("              -p, --prefix <prefix> Only rename objects below this key prefix, which is kept as it is (optional)")
+	fmt.Println("              -t, --transform <list> Specify the comma-separated transforms to apply")
+	fmt.Println("                                   (lower, upper, nfc, trim, spaces-to-dashes, spaces-to-underscores; applied in order)")
+	fmt.Println("              --map <file>         Specify a CSV file of old_key,new_key rows to rename instead")
+	fmt.Println("                                   (Sources must exist and targets must not; any problem stops the run before renaming)")
+	fmt.Println("              --rollback <file>    Record the completed renames in this CSV file, in reverse, for use with --map (optional)")
+	fmt.Println("                                   (Defaults to <map>.rollback.csv with --map)")
+	fmt.Println("              --dry-run            Print the renames without renaming (optional)")
+	fmt.Println("              -c, --concurrency <n> Specify the number of objects renamed at once (optional, default 8)")
+	fmt.Println("                                   (With -t, objects whose new key exists already or is shared with another object are skipped)")
+	fmt.Println("              --override-protection Rename protected objects too (optional)")
+	fmt.Println("\n  cors get")
+	fmt.Println("            Print the CORS rules of a bucket as JSON, e.g. go-cfr2 cors get > cors.json")
+	fmt.Println("            Usage: go-cfr2 cors get [bucket]")
+	fmt.Println("                                   (Defaults to DefaultBucket in config)")
+	fmt.Println("")
+	fmt.Println("  cors set")
+	fmt.Println("            Replace the CORS rules of a bucket with those of a JSON file")
+	fmt.Println("            Usage: go-cfr2 cors set [bucket] --file <cors.json>")
+	fmt.Println("            Flags:")
+	fmt.Println("              -f, --file <path>    Specify the JSON file with the CORS rules, or - for stdin (required)")
+	fmt.Println("                                   (An array of rules with AllowedOrigins, AllowedMethods, AllowedHeaders, ExposeHeaders and MaxAgeSeconds)")
+	fmt.Println("")
+	fmt.Println("  cors delete")
+	fmt.Println("            Remove every CORS rule of a bucket")
+	fmt.Println("            Usage: go-cfr2 cors delete [bucket]")
+	fmt.Println("\n  lifecycle get")
+	fmt.Println("            Print the lifecycle rules of a bucket as JSON, e.g. go-cfr2 lifecycle get > lifecycle.json")
+	fmt.Println("            Usage: go-cfr2 lifecycle get [bucket]")
+	fmt.Println("                                   (Defaults to DefaultBucket in config)")
+	fmt.Println("            (Fails for rules with more than one transition or with actions on noncurrent versions, which it cannot represent)")
+	fmt.Println("")
+	fmt.Println("  lifecycle set")
+	fmt.Println("            Replace the lifecycle rules of a bucket with those of a JSON file")
+	fmt.Println("            Usage: go-cfr2 lifecycle set [bucket] --file <lifecycle.json>")
+	fmt.Println("            Flags:")
+	fmt.Println("              -f, --file <path>    Specify the JSON file with the lifecycle rules, or - for stdin (required)")
+	fmt.Println("                                   (An array of rules as printed by lifecycle get, with the fields of the [[lifecycle]] rules of bucket plan,")
+	fmt.Println("                                   e.g. [{\"ID\": \"tmp\", \"Prefix\": \"tmp/\", \"Tags\": {\"tmp\": \"yes\"}, \"ExpireDays\": 7}])")
+	fmt.Println("")
+	fmt.Println("  lifecycle delete")
+	fmt.Println("            Remove every lifecycle rule of a bucket")
+	fmt.Println("            Usage: go-cfr2 lifecycle delete [bucket]")
 }
 
 func handlePresignCommand(ctx context.Context, client *s3.Client, cfg *config.R2Config) {
+	if len(os.Args) > 2 && os.Args[2] == "multipart" {
+		handlePresignMultipartCommand(ctx, client, cfg)
+		return
+	}
+
 	presignFlags := flag.NewFlagSet("presign", flag.ExitOnError)
 	bucketName := presignFlags.String("b", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
 	presignFlags.StringVar(bucketName, "bucket", cfg.DefaultBucket, "Specify the R2 bucket name (optional)")
@@ -248,7 +1519,10 @@ func handlePresignCommand(ctx context.Context, client *s3.Client, cfg *config.R2
 	presignFlags.StringVar(objectKey, "key", "", "Specify the object key (required)")
 	expiryHours := presignFlags.Int64("e", 24, "Specify the URL expiry time in hours (optional)")
 	presignFlags.Int64Var(expiryHours, "expiry", 24, "Specify the URL expiry time in hours (optional)")
-	presignFlags.Parse(os.Args[2:])
+	method := presignFlags.String("method", "get", "Specify the request the URL allows, get to download or put to upload (optional)")
+	contentType := presignFlags.String("content-type", "", "Require uploads with --method put to have this Content-Type (optional)")
+	contentLength := presignFlags.String("content-length", "", "Require uploads with --method put to have exactly this size, e.g. 10MB (optional)")
+	parseFlags(presignFlags, os.Args[2:])
 
 	if *bucketName == "" {
 	utils.ExitWithError("Bucket name not specified. Use -b or --bucket flag, or set DefaultBucket in config.")
@@ -256,6 +1530,37 @@ func handlePresignCommand(ctx context.Context, client *s3.Client, cfg *config.R2
 	if *objectKey == "" {
 		utils.ExitWithError("Object key not specified. Use -k or --key flag.")
 	}
+	if _, err := presignExpiry(*expiryHours); err != nil {
+		utils.ExitWithError(err.Error())
+	}
+
+	switch strings.ToLower(*method) {
+	case "get":
+		if *contentType != "" || *contentLength != "" {
+			utils.ExitWithError("--content-type and --content-length constrain uploads and require --method put.")
+		}
+	case "put":
+		if isKeyGlob(*objectKey) {
+			utils.ExitWithError("--method put presigns a single key and cannot be used with a glob.")
+		}
+		size := int64(0)
+		if *contentLength != "" {
+			var err error
+			size, err = utils.ParseSize(*contentLength)
+			if err != nil || size <= 0 {
+				utils.ExitWithError(fmt.Sprintf("Invalid --content-length value '%s'. Use a size such as 1048576 or 10MB.", *contentLength))
+			}
+		}
+		presignUpload(ctx, client, *bucketName, *objectKey, time.Duration(*expiryHours)*time.Hour, r2.PresignPutOptions{ContentType: *contentType, ContentLength: size})
+		return
+	default:
+		utils.ExitWithError(fmt.Sprintf("Invalid method '%s'. Use get or put.", *method))
+	}
+
+	if isKeyGlob(*objectKey) {
+		presignMatches(ctx, client, *bucketName, *objectKey, time.Duration(*expiryHours)*time.Hour)
+		return
+	}
 
 	fmt.Printf("Generating presigned URL for '%s' in bucket '%s' with %d-hour expiry...\n", *objectKey, *bucketName, *expiryHours)
 	url, err := r2.GeneratePresignedURLWithExpiry(ctx, client, *bucketName, *objectKey, time.Duration(*expiryHours)*time.Hour)
@@ -264,3 +1569,38 @@ func handlePresignCommand(ctx context.Context, client *s3.Client, cfg *config.R2
 	}
 	fmt.Printf("Presigned URL: %s\n", url)
 }
+
+// presignUpload prints a presigned URL that uploads an object, with the headers the upload must
+// carry and an example curl command.
+func presignUpload(ctx context.Context, client *s3.Client, bucketName, objectKey string, expiry time.Duration, opts r2.PresignPutOptions) {
+	fmt.Printf("Generating presigned upload URL for '%s' in bucket '%s' with %s expiry...\n", objectKey, bucketName, expiry)
+	url, headers, err := r2.GeneratePresignedPutURL(ctx, client, bucketName, objectKey, expiry, opts)
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to generate presigned upload URL for object '%s': %v", objectKey, err))
+	}
+	fmt.Printf("Presigned upload URL: %s\n", url)
+
+	curl := "curl -X PUT"
+	for _, name := range slices.Sorted(maps.Keys(headers)) {
+		fmt.Printf("Required header: %s: %s\n", name, headers.Get(name))
+		// curl sets Content-Length from the file itself.
+		if name != "Content-Length" {
+			curl += fmt.Sprintf(" -H '%s: %s'", name, headers.Get(name))
+		}
+	}
+	fmt.Printf("Upload with: %s --upload-file <file> '%s'\n", curl, url)
+}
+
+// presignMatches prints a presigned URL for every object matching a key glob.
+func presignMatches(ctx context.Context, client *s3.Client, bucketName, pattern string, expiry time.Duration) {
+	keys := expandKeyArg(ctx, client, bucketName, pattern)
+
+	fmt.Printf("Generating presigned URLs for %d object(s) matching '%s' in bucket '%s' with %s expiry...\n", len(keys), pattern, bucketName, expiry)
+	for _, key := range keys {
+		url, err := r2.GeneratePresignedURLWithExpiry(ctx, client, bucketName, key, expiry)
+		if err != nil {
+			utils.ExitWithError(fmt.Sprintf("Failed to generate presigned URL for object '%s': %v", key, err))
+		}
+		fmt.Printf("Presigned URL for '%s': %s\n", key, url)
+	}
+}
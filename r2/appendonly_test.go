@@ -0,0 +1,56 @@
+package r2
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/baowuhe/go-cfr2/config"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestAppendOnlyWritesAreConditional(t *testing.T) {
+	conditions := map[string]string{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.URL.Path, "/b/")
+		conditions[key] = r.Header.Get("If-None-Match")
+		// Another writer got there first.
+		if key == "audit/taken" && r.Header.Get("If-None-Match") == "*" {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			w.Write([]byte(`<Error><Code>PreconditionFailed</Code></Error>`))
+		}
+	}))
+	defer ts.Close()
+
+	cfg := &config.R2Config{AppendOnlyPrefixes: []string{"audit/"}}
+	client := s3.New(newTestS3Client(ts.URL).Options(), func(o *s3.Options) {
+		o.APIOptions = append(o.APIOptions, appendOnlyMiddleware(cfg))
+	})
+	put := func(key string) error {
+		_, err := client.PutObject(context.Background(), &s3.PutObjectInput{Bucket: aws.String("b"), Key: aws.String(key), Body: bytes.NewReader(nil)})
+		return err
+	}
+
+	if err := put("audit/new"); err != nil {
+		t.Fatal(err)
+	}
+	if err := put("site/index.html"); err != nil {
+		t.Fatal(err)
+	}
+	if got := conditions["audit/new"]; got != "*" {
+		t.Errorf("If-None-Match of an append-only write = %q, want %q", got, "*")
+	}
+	if got := conditions["site/index.html"]; got != "" {
+		t.Errorf("If-None-Match of another write = %q, want none", got)
+	}
+
+	err := put("audit/taken")
+	if err == nil || !strings.Contains(err.Error(), "refusing to overwrite 'audit/taken'") {
+		t.Errorf("overwriting an append-only key returned %v, want a refusal", err)
+	}
+}
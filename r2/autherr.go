@@ -0,0 +1,49 @@
+package r2
+
+import (
+	"errors"
+	"regexp"
+
+	"github.com/aws/smithy-go"
+)
+
+// authErrorHints explains the error codes R2 returns for problems with the credentials or the
+// account they are used with.
+var authErrorHints = map[string]string{
+	"InvalidAccessKeyId": "R2 does not know this access key ID. Check AccessKeyID, and that AccountID is the account " +
+		"the R2 API token was created in; the token may also have been deleted.",
+	"SignatureDoesNotMatch": "The secret access key does not match the access key ID. Check SecretAccessKey; " +
+		"Cloudflare shows it only once, when the R2 API token is created.",
+	"AccessDenied": "The credentials are valid but not allowed to do this. R2 API tokens can be limited to " +
+		"specific buckets and to read-only access; run 'go-cfr2 whoami' to see what they can access.",
+	"Forbidden": "The credentials are valid but not allowed to do this. R2 API tokens can be limited to " +
+		"specific buckets and to read-only access; run 'go-cfr2 whoami' to see what they can access.",
+	"Unauthorized": "R2 rejected the credentials. Check AccountID, AccessKeyID and SecretAccessKey, " +
+		"or run 'go-cfr2 whoami'.",
+	"ExpiredToken": "The credentials have expired. Create a new R2 API token and update AccessKeyID and SecretAccessKey.",
+	"NoSuchBucket": "The bucket does not exist in this account. Check the bucket name, and that AccountID is " +
+		"the account owning it.",
+}
+
+// apiErrorCodePattern finds the error code in the message of an SDK error that was formatted into
+// a string.
+var apiErrorCodePattern = regexp.MustCompile(`api error (\w+):`)
+
+// AuthErrorHint returns guidance for an error caused by the credentials or the account
+// configuration, or an empty string for other errors.
+func AuthErrorHint(err error) string {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return authErrorHints[apiErr.ErrorCode()]
+	}
+	return ""
+}
+
+// AuthErrorHintFromMessage is AuthErrorHint for an error that was already formatted into msg.
+func AuthErrorHintFromMessage(msg string) string {
+	match := apiErrorCodePattern.FindStringSubmatch(msg)
+	if match == nil {
+		return ""
+	}
+	return authErrorHints[match[1]]
+}
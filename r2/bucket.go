@@ -0,0 +1,35 @@
+package r2
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// LocationHints lists the location hints R2 accepts when creating a bucket.
+var LocationHints = []string{"wnam", "enam", "weur", "eeur", "apac", "oc"}
+
+// CreateBucket creates a bucket. locationHint, if not empty, asks R2 to place it near a region,
+// such as weur or apac.
+func CreateBucket(ctx context.Context, client *s3.Client, bucketName, locationHint string) error {
+	input := &s3.CreateBucketInput{Bucket: &bucketName}
+	if locationHint != "" {
+		input.CreateBucketConfiguration = &types.CreateBucketConfiguration{
+			LocationConstraint: types.BucketLocationConstraint(locationHint),
+		}
+	}
+	if _, err := client.CreateBucket(ctx, input); err != nil {
+		return fmt.Errorf("failed to create bucket '%s': %w", bucketName, err)
+	}
+	return nil
+}
+
+// DeleteBucket deletes a bucket, which must be empty.
+func DeleteBucket(ctx context.Context, client *s3.Client, bucketName string) error {
+	if _, err := client.DeleteBucket(ctx, &s3.DeleteBucketInput{Bucket: &bucketName}); err != nil {
+		return fmt.Errorf("failed to delete bucket '%s': %w", bucketName, err)
+	}
+	return nil
+}
@@ -3,11 +3,13 @@ package r2
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"net/url"
 
 	"github.com/baowuhe/go-cfr2/config"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	awsConfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
@@ -15,6 +17,21 @@ import (
 
 // NewR2Client creates a new S3 client configured for Cloudflare R2.
 func NewR2Client(cfg *config.R2Config) (*s3.Client, error) {
+	return NewR2ClientWithOptions(cfg, ClientOptions{})
+}
+
+// ClientOptions holds optional settings of NewR2ClientWithOptions.
+type ClientOptions struct {
+	// Session, when set, records every request the client sends.
+	Session *SessionCapture
+	// Logger receives the progress messages and warnings of the client's operations. Nil
+	// discards them.
+	Logger Logger
+}
+
+// NewR2ClientWithOptions creates a new S3 client configured for Cloudflare R2.
+func NewR2ClientWithOptions(cfg *config.R2Config, opts ClientOptions) (*s3.Client, error) {
+	session := opts.Session
 	// Cloudflare R2 endpoint format
 	r2Endpoint := fmt.Sprintf("https://%s.r2.cloudflarestorage.com", cfg.AccountID)
 
@@ -28,18 +45,60 @@ func NewR2Client(cfg *config.R2Config) (*s3.Client, error) {
 		}, nil
 	})
 
-	awsCfg, err := awsConfig.LoadDefaultConfig(context.TODO(),
+	loadOptions := []func(*awsConfig.LoadOptions) error{
 		awsConfig.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(cfg.AccessKeyID, cfg.SecretAccessKey, "")),
 		awsConfig.WithEndpointResolverWithOptions(r2Resolver),
 		// R2 does not use a specific region, but the SDK requires one.
 		// "auto" is a common placeholder for S3-compatible storage that doesn't have regions.
 		awsConfig.WithRegion("auto"), 
-	)
+	}
+	// The wrappers are applied once the config is loaded, since settings such as AWS_CA_BUNDLE
+	// are applied to the buildable client and cannot be applied through a wrapper.
+	loadOptions = append(loadOptions, awsConfig.WithHTTPClient(awshttp.NewBuildableClient().WithTransportOptions(func(tr *http.Transport) {
+		tr.MaxIdleConnsPerHost = maxIdleConnsPerHost
+	})))
+
+	awsCfg, err := awsConfig.LoadDefaultConfig(context.TODO(), loadOptions...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load AWS SDK config: %w", err)
 	}
 
-	client := s3.NewFromConfig(awsCfg)
+	httpClient := awsCfg.HTTPClient
+	if cfg.InjectFaults != "" {
+		faults, err := ParseFaultSpec(cfg.InjectFaults)
+		if err != nil {
+			return nil, fmt.Errorf("invalid fault injection setting: %w", err)
+		}
+		httpClient = NewFaultInjector(httpClient, faults)
+	}
+	if session != nil {
+		// Wrapped inside the rate limiter, so recorded durations exclude the time spent waiting,
+		// and outside fault injection, so injected faults are recorded.
+		httpClient = &capturingHTTPClient{client: httpClient, session: session}
+	}
+	if cfg.MaxOpsPerSecond > 0 {
+		httpClient = &rateLimitedHTTPClient{
+			client:  httpClient,
+			limiter: newRateLimiter(cfg.MaxOpsPerSecond),
+		}
+	}
+	state := &clientState{offline: cfg.Offline, log: opts.Logger}
+	if state.log == nil {
+		state.log = NewWriterLogger(nil)
+	}
+	awsCfg.HTTPClient = &stateHTTPClient{client: httpClient, state: state}
+
+	client := s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		if cfg.Policy != nil {
+			o.APIOptions = append(o.APIOptions, policyMiddleware(cfg.Policy))
+		}
+		if len(cfg.AppendOnlyPrefixes) > 0 {
+			o.APIOptions = append(o.APIOptions, appendOnlyMiddleware(cfg))
+		}
+		// Always added, since servers set request IDs per request through the context.
+		o.APIOptions = append(o.APIOptions, requestIDMiddleware(cfg.RequestID))
+		o.APIOptions = append(o.APIOptions, userAgentOptions(cfg.Command, cfg.UserAgentSuffix)...)
+	})
 	return client, nil
 }
 
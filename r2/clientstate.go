@@ -0,0 +1,69 @@
+package r2
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// clientState is what a client created by NewR2Client keeps between requests. It belongs to the
+// client, so that several clients in one process, e.g. of different cfr2.Services, do not affect
+// each other.
+type clientState struct {
+	// offline stops requests beyond the operation asked for, as set by the Offline setting.
+	offline bool
+	log     Logger
+	// observedSkew is the latest difference, in nanoseconds, between the Date header of a response
+	// and the local clock.
+	observedSkew atomic.Int64
+	// presignSkewMeasure measures the clock skew at most once, so that a batch of presigned URLs
+	// sends a single request even when the measurement fails or finds no skew.
+	presignSkewMeasure sync.Once
+	// presignSkewWarning reports the skew presigned URLs are corrected for once.
+	presignSkewWarning sync.Once
+}
+
+// stateHTTPClient is the outermost HTTP client of a client created by NewR2Client. It carries the
+// client's state and records the clock skew seen in the Date header of every response. The SDK
+// already corrects signing times and retries when R2 rejects a request as skewed; the recorded
+// skew explains failures that remain and corrects presigned URLs, which the SDK signs with the
+// local clock.
+type stateHTTPClient struct {
+	client aws.HTTPClient
+	state  *clientState
+}
+
+func (c *stateHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	resp, err := c.client.Do(req)
+	if err == nil {
+		if serverTime, parseErr := http.ParseTime(resp.Header.Get("Date")); parseErr == nil {
+			c.state.observedSkew.Store(int64(serverTime.Sub(time.Now())))
+		}
+	}
+	return resp, err
+}
+
+// stateOf returns the state of a client. A client not created by NewR2Client, such as one of
+// tests, gets a fresh state on every call, which keeps nothing and logs nothing.
+func stateOf(client *s3.Client) *clientState {
+	if c, ok := client.Options().HTTPClient.(*stateHTTPClient); ok {
+		return c.state
+	}
+	return &clientState{log: NewWriterLogger(nil)}
+}
+
+// logger returns the Logger of a client.
+func logger(client *s3.Client) Logger {
+	return stateOf(client).log
+}
+
+// Offline reports whether the client was created with the Offline setting, which stops requests
+// beyond the operation asked for, such as measuring the clock skew before presigning, for
+// environments whose outbound traffic must be predictable.
+func Offline(client *s3.Client) bool {
+	return stateOf(client).offline
+}
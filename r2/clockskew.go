@@ -0,0 +1,92 @@
+package r2
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// ClockSkewTolerance is the difference between the local clock and R2's above which signing times
+// are corrected and skew is reported. The Date header R2 sends has a resolution of one second.
+const ClockSkewTolerance = time.Minute
+
+// ObservedClockSkew returns how far R2's clock was ahead of the local clock in the latest response
+// received by client, or zero if there was none or client was not created by NewR2Client.
+func ObservedClockSkew(client *s3.Client) time.Duration {
+	return time.Duration(stateOf(client).observedSkew.Load())
+}
+
+// MeasureClockSkew returns how far R2's clock is ahead of the local clock, measured with a
+// HeadBucket request. Any response carries the server time, so a rejected request still yields a
+// measurement.
+func MeasureClockSkew(ctx context.Context, client *s3.Client, bucketName string) (time.Duration, error) {
+	out, err := client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: &bucketName})
+	if err == nil {
+		serverTime, ok1 := awsmiddleware.GetServerTime(out.ResultMetadata)
+		responseAt, ok2 := awsmiddleware.GetResponseAt(out.ResultMetadata)
+		if ok1 && ok2 {
+			return serverTime.Sub(responseAt), nil
+		}
+		return 0, errors.New("response carries no server time")
+	}
+
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) && respErr.Response != nil {
+		if serverTime, parseErr := http.ParseTime(respErr.Response.Header.Get("Date")); parseErr == nil {
+			return serverTime.Sub(time.Now()), nil
+		}
+	}
+	return 0, err
+}
+
+// presignClockSkew returns the clock skew presigned URLs have to be corrected for, or zero if it is
+// within ClockSkewTolerance. Without a skew seen in earlier responses, it is measured once per
+// client; offline, it is not measured at all. The correction is reported once per client, as a
+// warning, which the CLI prints on stderr since the URLs on stdout are often captured by a script.
+func presignClockSkew(ctx context.Context, client *s3.Client, bucketName string) time.Duration {
+	state := stateOf(client)
+	if state.observedSkew.Load() == 0 && !state.offline {
+		state.presignSkewMeasure.Do(func() {
+			if skew, err := MeasureClockSkew(ctx, client, bucketName); err == nil {
+				state.observedSkew.Store(int64(skew))
+			}
+		})
+	}
+	skew := time.Duration(state.observedSkew.Load())
+	if skew <= ClockSkewTolerance && skew >= -ClockSkewTolerance {
+		return 0
+	}
+	state.presignSkewWarning.Do(func() {
+		state.log.Warnf("the local clock is off by %s, signing with R2's time instead.", skew.Round(time.Second))
+	})
+	return skew
+}
+
+// skewCorrectedPresigner signs presigned URLs with the local time shifted by the clock skew, so
+// that URLs created on a machine with a drifting clock are valid from now until their expiry.
+type skewCorrectedPresigner struct {
+	presigner s3.HTTPPresignerV4
+	skew      time.Duration
+}
+
+func (p skewCorrectedPresigner) PresignHTTP(ctx context.Context, credentials aws.Credentials, r *http.Request, payloadHash, service, region string, signingTime time.Time, optFns ...func(*v4.SignerOptions)) (string, http.Header, error) {
+	return p.presigner.PresignHTTP(ctx, credentials, r, payloadHash, service, region, signingTime.Add(p.skew), optFns...)
+}
+
+// newSkewCorrectedPresigner returns a presigner correcting for skew, configured like the default
+// presigner of the S3 client.
+func newSkewCorrectedPresigner(skew time.Duration) skewCorrectedPresigner {
+	return skewCorrectedPresigner{
+		presigner: v4.NewSigner(func(so *v4.SignerOptions) {
+			so.DisableURIPathEscaping = true
+		}),
+		skew: skew,
+	}
+}
@@ -0,0 +1,40 @@
+package r2
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// A measurement that yields no skew is not repeated for every presigned URL of a batch, and is
+// kept per client.
+func TestPresignMeasuresClockSkewOnce(t *testing.T) {
+	var heads atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		heads.Add(1)
+		// Without a Date header the measurement fails.
+		w.Header()["Date"] = nil
+	}))
+	defer ts.Close()
+
+	client := newTestS3Client(ts.URL)
+	for _, key := range []string{"a", "b", "c"} {
+		if _, err := GeneratePresignedURLWithExpiry(context.Background(), client, "b", key, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := heads.Load(); n != 1 {
+		t.Errorf("presigning 3 URLs sent %d requests, want 1", n)
+	}
+
+	// Another client keeps its own measurement.
+	if _, err := GeneratePresignedURLWithExpiry(context.Background(), newTestS3Client(ts.URL), "b", "a", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if n := heads.Load(); n != 2 {
+		t.Errorf("presigning with a second client sent %d requests in total, want 2", n)
+	}
+}
@@ -0,0 +1,192 @@
+package r2
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// dupesSampleSize is the length of each of the three ranges read from an object that carries no
+// usable checksum. Objects up to three times this size are read in full.
+const dupesSampleSize = 64 * 1024
+
+// DuplicateObject is a member of a DuplicateSet.
+type DuplicateObject struct {
+	Key          string
+	LastModified time.Time
+	Protected    bool
+}
+
+// DuplicateSet is a group of objects with the same size and content hash.
+type DuplicateSet struct {
+	Size int64
+	// Hash identifies the content, prefixed with how it was obtained: "sha256:" from the metadata
+	// recorded at upload or a full read of a small object, "md5:" from a single-part ETag, or
+	// "sample:" from ranged reads.
+	Hash string
+	// Objects are ordered oldest first.
+	Objects []DuplicateObject
+}
+
+// Sampled reports whether the set was matched on a sample of the content only, so its members
+// are probably but not certainly identical.
+func (s DuplicateSet) Sampled() bool {
+	return strings.HasPrefix(s.Hash, "sample:")
+}
+
+// Savings is the storage freed by keeping a single copy of the set.
+func (s DuplicateSet) Savings() int64 {
+	return s.Size * int64(len(s.Objects)-1)
+}
+
+// FindDuplicates groups the objects below prefix by content and returns the groups with more than
+// one member, largest savings first. Only objects sharing their size with another are inspected:
+// each gets a HEAD request for its SHA-256 metadata, falling back to an MD5 ETag and then to
+// sampled ranged reads. Objects are only matched on the same kind of hash. Empty objects, directory
+// markers and hard link stubs are ignored. concurrency bounds the parallel requests.
+func FindDuplicates(ctx context.Context, client *s3.Client, bucketName, prefix string, concurrency int) ([]DuplicateSet, error) {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	bySize := make(map[int64][]types.Object)
+	err := WalkObjects(ctx, client, bucketName, prefix, func(obj types.Object) error {
+		if size := aws.ToInt64(obj.Size); size > 0 && !IsDirectoryMarker(obj) {
+			bySize[size] = append(bySize[size], obj)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		groups = make(map[string]*DuplicateSet)
+		errs   []error
+	)
+	queue := make(chan types.Object)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for obj := range queue {
+				hash, protected, err := contentHash(ctx, client, bucketName, obj)
+
+				mu.Lock()
+				switch {
+				case err != nil:
+					errs = append(errs, err)
+				case hash != "":
+					size := aws.ToInt64(obj.Size)
+					group := fmt.Sprintf("%d/%s", size, hash)
+					if groups[group] == nil {
+						groups[group] = &DuplicateSet{Size: size, Hash: hash}
+					}
+					groups[group].Objects = append(groups[group].Objects, DuplicateObject{
+						Key:          *obj.Key,
+						LastModified: aws.ToTime(obj.LastModified),
+						Protected:    protected,
+					})
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, objs := range bySize {
+		if len(objs) < 2 {
+			continue
+		}
+		for _, obj := range objs {
+			queue <- obj
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	var sets []DuplicateSet
+	for _, set := range groups {
+		if len(set.Objects) < 2 {
+			continue
+		}
+		slices.SortFunc(set.Objects, func(a, b DuplicateObject) int {
+			return cmp.Or(a.LastModified.Compare(b.LastModified), strings.Compare(a.Key, b.Key))
+		})
+		sets = append(sets, *set)
+	}
+	slices.SortFunc(sets, func(a, b DuplicateSet) int {
+		return cmp.Or(cmp.Compare(b.Savings(), a.Savings()), strings.Compare(a.Objects[0].Key, b.Objects[0].Key))
+	})
+	return sets, errors.Join(errs...)
+}
+
+// contentHash identifies the content of an object, as described for DuplicateSet.Hash. It returns
+// an empty hash for hard link stubs.
+func contentHash(ctx context.Context, client *s3.Client, bucketName string, obj types.Object) (string, bool, error) {
+	head, err := client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: &bucketName,
+		Key:    obj.Key,
+	})
+	if err != nil {
+		return "", false, fmt.Errorf("failed to get metadata of object '%s' in bucket '%s': %w", *obj.Key, bucketName, err)
+	}
+	protected := IsProtected(head.Metadata)
+	if head.Metadata[MetadataHardlink] != "" {
+		return "", protected, nil
+	}
+	if sum := head.Metadata[MetadataSHA256]; sum != "" {
+		return "sha256:" + sum, protected, nil
+	}
+	if etag := strings.Trim(aws.ToString(head.ETag), `"`); md5ETagPattern.MatchString(etag) {
+		return "md5:" + etag, protected, nil
+	}
+
+	size := aws.ToInt64(obj.Size)
+	ranges := []string{"bytes=0-"}
+	if size > 3*dupesSampleSize {
+		middle := size/2 - dupesSampleSize/2
+		ranges = []string{
+			fmt.Sprintf("bytes=0-%d", dupesSampleSize-1),
+			fmt.Sprintf("bytes=%d-%d", middle, middle+dupesSampleSize-1),
+			fmt.Sprintf("bytes=%d-%d", size-dupesSampleSize, size-1),
+		}
+	}
+	h := sha256.New()
+	for _, byteRange := range ranges {
+		body, err := OpenObjectRange(ctx, client, bucketName, *obj.Key, byteRange)
+		if err != nil {
+			return "", protected, err
+		}
+		_, err = io.Copy(h, body)
+		body.Close()
+		if err != nil {
+			return "", protected, fmt.Errorf("failed to read object '%s' in bucket '%s': %w", *obj.Key, bucketName, err)
+		}
+	}
+	if len(ranges) == 1 {
+		// The whole object was read, so the digest is as good as one recorded at upload.
+		return "sha256:" + hex.EncodeToString(h.Sum(nil)), protected, nil
+	}
+	return "sample:" + hex.EncodeToString(h.Sum(nil)), protected, nil
+}
+
+// LinkDuplicate replaces an object with a hard link stub pointing at target, which must hold the
+// same content. Downloads and sync follow the stub to target. mtime is recorded as the modification
+// time of the link.
+func LinkDuplicate(ctx context.Context, client *s3.Client, bucketName, objectKey, target string, mtime time.Time) error {
+	_, err := putHardLinkStub(ctx, client, bucketName, objectKey, target, mtime)
+	return err
+}
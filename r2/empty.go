@@ -0,0 +1,193 @@
+package r2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// maxDeleteBatch is the number of keys a single DeleteObjects request accepts.
+const maxDeleteBatch = 1000
+
+// EmptyBucketOptions configures EmptyBucket.
+type EmptyBucketOptions struct {
+	Bucket string
+	// Prefix limits the deletion to the keys and multipart uploads below it.
+	Prefix string
+	// Keep, when set, names keys that must not be deleted, such as those below append-only prefixes.
+	Keep func(key string) bool
+	// OverrideProtection deletes protected objects too. Otherwise every object is checked with a
+	// HEAD request first, which the deletion does not need.
+	OverrideProtection bool
+	// Deleted, when set, is called with every object R2 confirmed deleting.
+	Deleted func(obj types.Object)
+	// KeepUploads leaves incomplete multipart uploads alone, e.g. when deleting a prefix that
+	// transfers still in progress write below.
+	KeepUploads bool
+	// DryRun prints the objects that would be deleted, and counts them as deleted, without
+	// deleting anything or aborting uploads.
+	DryRun bool
+}
+
+// DeleteFailure is an object that a batch deletion failed to delete.
+type DeleteFailure struct {
+	Key     string
+	Message string
+}
+
+// EmptyBucketResult summarizes EmptyBucket.
+type EmptyBucketResult struct {
+	Deleted int
+	// Kept counts the objects left alone because of Keep or their protection.
+	Kept int
+	// Failed counts the objects R2 refused to delete.
+	Failed int
+	// Failures lists the objects R2 refused to delete, with the reason.
+	Failures []DeleteFailure
+	// AbortedUploads counts the incomplete multipart uploads that were aborted.
+	AbortedUploads int
+}
+
+// EmptyBucket deletes every object in a bucket in batches, and, unless KeepUploads is set, aborts
+// its incomplete multipart uploads, whose parts are stored and billed but do not show up in listings. Progress is printed
+// after every batch; the keys that could not be deleted are collected in the result.
+func EmptyBucket(ctx context.Context, client *s3.Client, opts EmptyBucketOptions) (EmptyBucketResult, error) {
+	log := logger(client)
+	var result EmptyBucketResult
+	batch := make([]types.ObjectIdentifier, 0, maxDeleteBatch)
+	// objects holds the objects of the batch, for Deleted.
+	objects := make([]types.Object, 0, maxDeleteBatch)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if opts.DryRun {
+			for _, obj := range objects {
+				log.Printf("Would delete '%s'\n", aws.ToString(obj.Key))
+			}
+			result.Deleted += len(batch)
+			batch = batch[:0]
+			objects = objects[:0]
+			return nil
+		}
+		resp, err := client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: &opts.Bucket,
+			Delete: &types.Delete{Objects: batch, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to delete objects in bucket '%s': %w", opts.Bucket, err)
+		}
+		failed := map[string]bool{}
+		for _, e := range resp.Errors {
+			result.Failures = append(result.Failures, DeleteFailure{Key: aws.ToString(e.Key), Message: aws.ToString(e.Message)})
+			failed[aws.ToString(e.Key)] = true
+		}
+		result.Failed += len(resp.Errors)
+		result.Deleted += len(batch) - len(resp.Errors)
+		log.Printf("Deleted %d object(s)...\n", result.Deleted)
+		if opts.Deleted != nil {
+			for _, obj := range objects {
+				if !failed[aws.ToString(obj.Key)] {
+					opts.Deleted(obj)
+				}
+			}
+		}
+		batch = batch[:0]
+		objects = objects[:0]
+		return nil
+	}
+	add := func(obj types.Object) error {
+		batch = append(batch, types.ObjectIdentifier{Key: obj.Key})
+		objects = append(objects, obj)
+		if len(batch) == maxDeleteBatch {
+			return flush()
+		}
+		return nil
+	}
+
+	// The listing and the HEAD pipeline both keep objects, on different goroutines.
+	var keptMu sync.Mutex
+	keep := func() {
+		keptMu.Lock()
+		result.Kept++
+		keptMu.Unlock()
+	}
+
+	var pipeline *HeadPipeline
+	if !opts.OverrideProtection {
+		pipeline = NewHeadPipeline(ctx, client, opts.Bucket, 0, func(key string, head *s3.HeadObjectOutput, err error) error {
+			var notFound *types.NotFound
+			if errors.As(err, &notFound) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if IsProtected(head.Metadata) {
+				log.Printf("Keeping '%s': object is protected.\n", key)
+				keep()
+				return nil
+			}
+			return add(types.Object{Key: aws.String(key), Size: head.ContentLength, ETag: head.ETag, LastModified: head.LastModified})
+		})
+	}
+
+	err := WalkObjects(ctx, client, opts.Bucket, opts.Prefix, func(obj types.Object) error {
+		key := aws.ToString(obj.Key)
+		if opts.Keep != nil && opts.Keep(key) {
+			keep()
+			return nil
+		}
+		if pipeline != nil {
+			return pipeline.Add(key)
+		}
+		return add(obj)
+	})
+	if pipeline != nil {
+		if waitErr := pipeline.Wait(); err == nil {
+			err = waitErr
+		}
+	}
+	if err == nil {
+		err = flush()
+	}
+	if err != nil || opts.KeepUploads || opts.DryRun {
+		return result, err
+	}
+
+	result.AbortedUploads, err = abortMultipartUploads(ctx, client, opts.Bucket, opts.Prefix)
+	return result, err
+}
+
+// abortMultipartUploads aborts every incomplete multipart upload below a prefix.
+func abortMultipartUploads(ctx context.Context, client *s3.Client, bucketName, prefix string) (int, error) {
+	aborted := 0
+	input := &s3.ListMultipartUploadsInput{Bucket: &bucketName, Prefix: &prefix}
+	for {
+		resp, err := client.ListMultipartUploads(ctx, input)
+		if err != nil {
+			return aborted, fmt.Errorf("failed to list multipart uploads in bucket '%s': %w", bucketName, err)
+		}
+		for _, upload := range resp.Uploads {
+			_, err := client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+				Bucket:   &bucketName,
+				Key:      upload.Key,
+				UploadId: upload.UploadId,
+			})
+			if err != nil {
+				return aborted, fmt.Errorf("failed to abort multipart upload of '%s' in bucket '%s': %w", aws.ToString(upload.Key), bucketName, err)
+			}
+			aborted++
+		}
+		if !aws.ToBool(resp.IsTruncated) {
+			return aborted, nil
+		}
+		input.KeyMarker = resp.NextKeyMarker
+		input.UploadIdMarker = resp.NextUploadIdMarker
+	}
+}
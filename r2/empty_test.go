@@ -0,0 +1,77 @@
+package r2
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// prefixServer lists two objects below any prefix, answers deletions and has no multipart
+// uploads, and records which of these requests it receives.
+type prefixServer struct {
+	mu       sync.Mutex
+	requests []string
+}
+
+func (s *prefixServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	var kind string
+	switch {
+	case query.Get("list-type") == "2":
+		kind = "list"
+		w.Write([]byte(`<ListBucketResult><IsTruncated>false</IsTruncated>` +
+			`<Contents><Key>logs/a</Key><Size>1</Size></Contents>` +
+			`<Contents><Key>logs/b</Key><Size>1</Size></Contents></ListBucketResult>`))
+	case query.Has("delete"):
+		kind = "delete"
+		w.Write([]byte(`<DeleteResult></DeleteResult>`))
+	case query.Has("uploads"):
+		kind = "uploads"
+		w.Write([]byte(`<ListMultipartUploadsResult><IsTruncated>false</IsTruncated></ListMultipartUploadsResult>`))
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+	s.mu.Lock()
+	s.requests = append(s.requests, kind)
+	s.mu.Unlock()
+}
+
+func TestEmptyBucketOptions(t *testing.T) {
+	tests := []struct {
+		name         string
+		opts         EmptyBucketOptions
+		wantRequests []string
+	}{
+		{"default", EmptyBucketOptions{}, []string{"list", "delete", "uploads"}},
+		{"keep uploads", EmptyBucketOptions{KeepUploads: true}, []string{"list", "delete"}},
+		{"dry run", EmptyBucketOptions{DryRun: true}, []string{"list"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := &prefixServer{}
+			ts := httptest.NewServer(srv)
+			defer ts.Close()
+
+			opts := tt.opts
+			opts.Bucket, opts.Prefix, opts.OverrideProtection = "b", "logs/", true
+			result, err := EmptyBucket(context.Background(), newTestS3Client(ts.URL), opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Deleted != 2 {
+				t.Errorf("Deleted = %d, want 2", result.Deleted)
+			}
+			if len(srv.requests) != len(tt.wantRequests) {
+				t.Fatalf("requests %v, want %v", srv.requests, tt.wantRequests)
+			}
+			for i := range srv.requests {
+				if srv.requests[i] != tt.wantRequests[i] {
+					t.Fatalf("requests %v, want %v", srv.requests, tt.wantRequests)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package r2
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// MetadataExpires holds the time after which an object may be garbage collected, in Unix seconds.
+const MetadataExpires = "expires"
+
+// ExpiresMetadata returns the metadata value marking an object as expiring at t.
+func ExpiresMetadata(t time.Time) string {
+	return strconv.FormatInt(t.Unix(), 10)
+}
+
+// FindExpiredObjects returns the keys below prefix whose expiry metadata lies before now.
+// Listings carry no metadata, so every object is inspected with a HEAD request; concurrency
+// bounds how many of those run in parallel. Objects without expiry metadata, and protected objects,
+// never expire.
+func FindExpiredObjects(ctx context.Context, client *s3.Client, bucketName, prefix string, now time.Time, concurrency int) ([]string, error) {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		expired []string
+		errs    []error
+	)
+	queue := make(chan string)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range queue {
+				metadata, err := GetObjectMetadata(ctx, client, bucketName, key)
+
+				mu.Lock()
+				if err != nil {
+					errs = append(errs, err)
+				} else if expires, err := strconv.ParseInt(metadata[MetadataExpires], 10, 64); err == nil && expires <= now.Unix() && !IsProtected(metadata) {
+					expired = append(expired, key)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	walkErr := WalkObjects(ctx, client, bucketName, prefix, func(obj types.Object) error {
+		queue <- *obj.Key
+		return nil
+	})
+	close(queue)
+	wg.Wait()
+
+	sort.Strings(expired)
+	return expired, errors.Join(append(errs, walkErr)...)
+}
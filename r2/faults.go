@@ -0,0 +1,152 @@
+package r2
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// DefaultFaultMaxDelay is the longest delay an injected fault adds to a request unless configured.
+const DefaultFaultMaxDelay = 2 * time.Second
+
+// FaultOptions configures fault injection, which makes a share of requests fail or stall so that
+// retry and resume settings can be tested against a flaky network.
+type FaultOptions struct {
+	// Probability is the chance, between 0 and 1, that a request is hit by a fault.
+	Probability float64
+	// MaxDelay is the longest delay added to a request hit by a delay fault.
+	MaxDelay time.Duration
+	// Seed makes the sequence of faults reproducible. Zero picks a random seed.
+	Seed int64
+}
+
+// ParseFaultSpec parses a fault specification of comma-separated settings such as
+// "p=0.05,delay=5s,seed=42". Only p is required.
+func ParseFaultSpec(spec string) (FaultOptions, error) {
+	opts := FaultOptions{MaxDelay: DefaultFaultMaxDelay}
+	hasProbability := false
+	for _, setting := range strings.Split(spec, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(setting), "=")
+		if !ok {
+			return opts, fmt.Errorf("invalid fault setting '%s', expected name=value", setting)
+		}
+		var err error
+		switch name {
+		case "p":
+			opts.Probability, err = strconv.ParseFloat(value, 64)
+			if err == nil && (opts.Probability < 0 || opts.Probability > 1) {
+				err = errors.New("must be between 0 and 1")
+			}
+			hasProbability = true
+		case "delay":
+			opts.MaxDelay, err = time.ParseDuration(value)
+			if err == nil && opts.MaxDelay < 0 {
+				err = errors.New("must not be negative")
+			}
+		case "seed":
+			opts.Seed, err = strconv.ParseInt(value, 10, 64)
+		default:
+			return opts, fmt.Errorf("unknown fault setting '%s'", name)
+		}
+		if err != nil {
+			return opts, fmt.Errorf("invalid fault setting '%s': %w", setting, err)
+		}
+	}
+	if !hasProbability {
+		return opts, errors.New("fault specification needs a probability, such as p=0.05")
+	}
+	return opts, nil
+}
+
+// FaultInjector is an HTTP client that passes requests on to another client, except that some of
+// them, chosen at random, fail with a connection error, get a 503 SlowDown response or are delayed.
+// It is safe for concurrent use.
+type FaultInjector struct {
+	client aws.HTTPClient
+	opts   FaultOptions
+	mu     sync.Mutex
+	rand   *rand.Rand
+}
+
+// NewFaultInjector wraps client with fault injection. It can be passed to the AWS SDK as the HTTP
+// client of any S3 client.
+func NewFaultInjector(client aws.HTTPClient, opts FaultOptions) *FaultInjector {
+	seed := opts.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &FaultInjector{client: client, opts: opts, rand: rand.New(rand.NewSource(seed))}
+}
+
+type faultKind int
+
+const (
+	faultNone faultKind = iota
+	faultConnection
+	faultSlowDown
+	faultDelay
+)
+
+// pick decides the fault for the next request and, for delay faults, how long to wait.
+func (f *FaultInjector) pick() (faultKind, time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.rand.Float64() >= f.opts.Probability {
+		return faultNone, 0
+	}
+	kind := faultKind(1 + f.rand.Intn(3))
+	var delay time.Duration
+	if kind == faultDelay && f.opts.MaxDelay > 0 {
+		delay = time.Duration(f.rand.Int63n(int64(f.opts.MaxDelay)))
+	}
+	return kind, delay
+}
+
+func (f *FaultInjector) Do(req *http.Request) (*http.Response, error) {
+	kind, delay := f.pick()
+	switch kind {
+	case faultConnection:
+		closeRequestBody(req)
+		return nil, &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer (injected fault)")}
+	case faultSlowDown:
+		closeRequestBody(req)
+		body := "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<Error><Code>SlowDown</Code><Message>Injected fault</Message></Error>"
+		return &http.Response{
+			Status:        "503 Service Unavailable",
+			StatusCode:    http.StatusServiceUnavailable,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        http.Header{"Content-Type": {"application/xml"}},
+			Body:          io.NopCloser(strings.NewReader(body)),
+			ContentLength: int64(len(body)),
+			Request:       req,
+		}, nil
+	case faultDelay:
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			closeRequestBody(req)
+			return nil, req.Context().Err()
+		}
+	}
+	return f.client.Do(req)
+}
+
+// closeRequestBody closes the body of a request that is not sent, as a transport would.
+func closeRequestBody(req *http.Request) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+}
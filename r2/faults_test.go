@@ -0,0 +1,114 @@
+package r2
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFaultSpec(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    FaultOptions
+		wantErr bool
+	}{
+		{"p=0.05", FaultOptions{Probability: 0.05, MaxDelay: DefaultFaultMaxDelay}, false},
+		{"p=1,delay=5s,seed=42", FaultOptions{Probability: 1, MaxDelay: 5 * time.Second, Seed: 42}, false},
+		{" p=0 , delay=0s", FaultOptions{Probability: 0, MaxDelay: 0}, false},
+		{"delay=5s", FaultOptions{}, true},
+		{"p=1.5", FaultOptions{}, true},
+		{"p=-0.1", FaultOptions{}, true},
+		{"p=0.1,delay=-1s", FaultOptions{}, true},
+		{"p=0.1,seed=x", FaultOptions{}, true},
+		{"p=0.1,rate=2", FaultOptions{}, true},
+		{"p", FaultOptions{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseFaultSpec(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseFaultSpec(%q) error = %v, want error %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("ParseFaultSpec(%q) = %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+// countingClient answers every request with 200 and counts them.
+type countingClient struct{ requests int }
+
+func (c *countingClient) Do(req *http.Request) (*http.Response, error) {
+	c.requests++
+	return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("")), Request: req}, nil
+}
+
+func TestFaultInjector(t *testing.T) {
+	t.Run("never", func(t *testing.T) {
+		next := &countingClient{}
+		injector := NewFaultInjector(next, FaultOptions{Probability: 0, Seed: 1})
+		for range 100 {
+			resp, err := injector.Do(httptestRequest(t))
+			if err != nil || resp.StatusCode != http.StatusOK {
+				t.Fatalf("got %v, %v without faults", resp, err)
+			}
+		}
+		if next.requests != 100 {
+			t.Fatalf("%d of 100 requests were passed on", next.requests)
+		}
+	})
+
+	t.Run("always", func(t *testing.T) {
+		next := &countingClient{}
+		injector := NewFaultInjector(next, FaultOptions{Probability: 1, MaxDelay: time.Millisecond, Seed: 1})
+		var connection, slowDown int
+		for range 300 {
+			resp, err := injector.Do(httptestRequest(t))
+			var opErr *net.OpError
+			switch {
+			case errors.As(err, &opErr):
+				connection++
+			case err == nil && resp.StatusCode == http.StatusServiceUnavailable:
+				slowDown++
+			case err == nil && resp.StatusCode == http.StatusOK:
+			default:
+				t.Fatalf("unexpected result %v, %v", resp, err)
+			}
+		}
+		// Only delayed requests reach the client.
+		if connection == 0 || slowDown == 0 || next.requests == 0 || connection+slowDown+next.requests != 300 {
+			t.Fatalf("got %d connection errors, %d SlowDown responses and %d delayed requests", connection, slowDown, next.requests)
+		}
+	})
+
+	t.Run("seeded", func(t *testing.T) {
+		sequence := func() []faultKind {
+			injector := NewFaultInjector(&countingClient{}, FaultOptions{Probability: 0.5, Seed: 42})
+			var kinds []faultKind
+			for range 50 {
+				kind, _ := injector.pick()
+				kinds = append(kinds, kind)
+			}
+			return kinds
+		}
+		first, second := sequence(), sequence()
+		for i := range first {
+			if first[i] != second[i] {
+				t.Fatalf("fault %d differs between runs with the same seed", i)
+			}
+		}
+	})
+}
+
+func httptestRequest(t *testing.T) *http.Request {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, "https://example.com/bucket/key", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
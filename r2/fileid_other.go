@@ -0,0 +1,11 @@
+//go:build !unix
+
+package r2
+
+import "io/fs"
+
+// hardLinkID always returns "" on platforms without inode numbers; hard links are uploaded as
+// independent files there.
+func hardLinkID(info fs.FileInfo) string {
+	return ""
+}
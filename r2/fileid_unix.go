@@ -0,0 +1,19 @@
+//go:build unix
+
+package r2
+
+import (
+	"fmt"
+	"io/fs"
+	"syscall"
+)
+
+// hardLinkID returns an identifier shared by all hard links to the same file, or "" if the file
+// has a single link or the platform does not expose inode numbers.
+func hardLinkID(info fs.FileInfo) string {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || stat.Nlink < 2 {
+		return ""
+	}
+	return fmt.Sprintf("%d:%d", stat.Dev, stat.Ino)
+}
@@ -0,0 +1,85 @@
+package r2
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/baowuhe/go-cfr2/utils"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// DefaultFingerprintManifest is the key, relative to the sync prefix, of the asset manifest.
+const DefaultFingerprintManifest = "asset-manifest.json"
+
+// fingerprintLen is the number of hex digits of the content hash put into fingerprinted names.
+const fingerprintLen = 8
+
+// fingerprintLocal renames the local files matching opts.Fingerprint to keys carrying a hash of
+// their content, such as app.3f9a2c1d.js, and returns the mapping from original to fingerprinted
+// names, relative to the prefix.
+func fingerprintLocal(opts SyncOptions, local map[string]localFile) (map[string]string, error) {
+	names := map[string]string{}
+	for key, file := range local {
+		rel := strings.TrimPrefix(key, opts.Prefix)
+		if !matchAnyKeyPattern(opts.Fingerprint, rel) {
+			continue
+		}
+		hash, err := utils.FileSHA256(file.path)
+		if err != nil {
+			return nil, err
+		}
+		hashed := fingerprintName(rel, hash[:fingerprintLen])
+		names[rel] = hashed
+		delete(local, key)
+		local[opts.Prefix+hashed] = file
+	}
+	return names, nil
+}
+
+// fingerprintName inserts a fingerprint before the extension of a file name.
+func fingerprintName(rel, fingerprint string) string {
+	dir, base := path.Split(rel)
+	ext := path.Ext(base)
+	if ext == base {
+		// Dot files like .htaccess have no extension to keep.
+		ext = ""
+	}
+	return dir + strings.TrimSuffix(base, ext) + "." + fingerprint + ext
+}
+
+// fingerprintManifestKey returns the key the asset manifest is uploaded to.
+func fingerprintManifestKey(opts SyncOptions) string {
+	name := opts.FingerprintManifest
+	if name == "" {
+		name = DefaultFingerprintManifest
+	}
+	return opts.Prefix + name
+}
+
+// uploadFingerprintManifest uploads the JSON manifest mapping original to fingerprinted names.
+func uploadFingerprintManifest(ctx context.Context, client *s3.Client, opts SyncOptions, names map[string]string) error {
+	log := logger(client)
+	data, err := json.MarshalIndent(names, "", "  ")
+	if err != nil {
+		return err
+	}
+	key := fingerprintManifestKey(opts)
+	log.Printf("Uploading asset manifest '%s'...\n", key)
+	_, err = client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:       &opts.Bucket,
+		Key:          &key,
+		Body:         bytes.NewReader(data),
+		ContentType:  aws.String("application/json"),
+		CacheControl: opts.cacheControl(key),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload asset manifest '%s' to bucket '%s': %w", key, opts.Bucket, err)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package r2
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// DefaultHeadConcurrency is the number of HEAD requests a HeadPipeline keeps in flight by default.
+const DefaultHeadConcurrency = 16
+
+// HeadFunc receives the metadata of one object fetched by a HeadPipeline, or the error of its HEAD
+// request. Returning an error stops the pipeline.
+type HeadFunc func(key string, head *s3.HeadObjectOutput, err error) error
+
+// HeadPipeline fetches object metadata with a bounded number of concurrent HEAD requests, since
+// one request at a time makes metadata-heavy operations on large prefixes very slow. Keys are fed
+// with Add, typically from a listing callback, and results are handed to a HeadFunc that is never
+// called concurrently but sees keys in no particular order.
+type HeadPipeline struct {
+	parent context.Context
+	ctx    context.Context
+	cancel context.CancelFunc
+	queue  chan string
+	wg     sync.WaitGroup
+
+	mu  sync.Mutex
+	fn  HeadFunc
+	err error
+}
+
+// NewHeadPipeline starts a pipeline fetching metadata from a bucket. A concurrency of zero or less
+// uses DefaultHeadConcurrency. Wait must be called once all keys are added.
+func NewHeadPipeline(ctx context.Context, client *s3.Client, bucketName string, concurrency int, fn HeadFunc) *HeadPipeline {
+	if concurrency <= 0 {
+		concurrency = DefaultHeadConcurrency
+	}
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	p := &HeadPipeline{parent: parent, ctx: ctx, cancel: cancel, queue: make(chan string), fn: fn}
+
+	for i := 0; i < concurrency; i++ {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for key := range p.queue {
+				if ctx.Err() != nil {
+					// The pipeline was stopped; drain the queue.
+					continue
+				}
+				head, err := client.HeadObject(ctx, &s3.HeadObjectInput{
+					Bucket: &bucketName,
+					Key:    &key,
+				})
+				if err != nil {
+					err = fmt.Errorf("failed to get metadata of object '%s' in bucket '%s': %w", key, bucketName, err)
+				}
+				p.deliver(key, head, err)
+			}
+		}()
+	}
+	return p
+}
+
+func (p *HeadPipeline) deliver(key string, head *s3.HeadObjectOutput, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return
+	}
+	if err := p.fn(key, head, err); err != nil {
+		p.err = err
+		p.cancel()
+	}
+}
+
+// Add queues the metadata of key to be fetched, blocking while all workers are busy. It returns
+// the error that stopped the pipeline, if any, so that a listing feeding it can stop as well.
+func (p *HeadPipeline) Add(key string) error {
+	select {
+	case p.queue <- key:
+		return nil
+	case <-p.ctx.Done():
+		return p.stopError()
+	}
+}
+
+// Wait waits for every queued key to be handled and returns the error that stopped the pipeline.
+func (p *HeadPipeline) Wait() error {
+	close(p.queue)
+	p.wg.Wait()
+	p.cancel()
+	return p.stopError()
+}
+
+func (p *HeadPipeline) stopError() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	return p.parent.Err()
+}
@@ -0,0 +1,36 @@
+package r2
+
+import (
+	"fmt"
+	"io"
+)
+
+// Logger receives the progress messages and warnings of a client's operations, such as the lines
+// Sync and EmptyBucket print for every object. Implementations are safe for concurrent use.
+type Logger interface {
+	// Printf reports progress. The message carries its own line breaks.
+	Printf(format string, args ...any)
+	// Warnf reports a condition the operation continued despite. The message has no line break.
+	Warnf(format string, args ...any)
+}
+
+// NewWriterLogger returns a Logger writing progress to w and warnings to it as well, prefixed with
+// "Warning: ". A nil w discards everything.
+func NewWriterLogger(w io.Writer) Logger {
+	if w == nil {
+		w = io.Discard
+	}
+	return writerLogger{w: w}
+}
+
+type writerLogger struct {
+	w io.Writer
+}
+
+func (l writerLogger) Printf(format string, args ...any) {
+	fmt.Fprintf(l.w, format, args...)
+}
+
+func (l writerLogger) Warnf(format string, args ...any) {
+	fmt.Fprintf(l.w, "Warning: "+format+"\n", args...)
+}
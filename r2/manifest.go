@@ -0,0 +1,140 @@
+package r2
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// HashManifest remembers, for one bucket, which key holds the content with a given SHA-256 hash,
+// so that uploads of duplicate content can be replaced by a server-side copy.
+// It is safe for concurrent use.
+type HashManifest struct {
+	path   string
+	mu     sync.Mutex
+	Hashes map[string]string `json:"hashes"`
+}
+
+// LoadHashManifest reads a hash manifest. A missing file yields an empty manifest that is
+// created on the first Save.
+func LoadHashManifest(path string) (*HashManifest, error) {
+	m := &HashManifest{path: path, Hashes: map[string]string{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read hash manifest '%s': %w", path, err)
+	}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("failed to parse hash manifest '%s': %w", path, err)
+	}
+	if m.Hashes == nil {
+		m.Hashes = map[string]string{}
+	}
+	return m, nil
+}
+
+// Save writes the manifest back to its file atomically.
+func (m *HashManifest) Save() error {
+	m.mu.Lock()
+	data, err := json.Marshal(m)
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp := m.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write hash manifest '%s': %w", m.path, err)
+	}
+	if err := os.Rename(tmp, m.path); err != nil {
+		return fmt.Errorf("failed to write hash manifest '%s': %w", m.path, err)
+	}
+	return nil
+}
+
+// Record notes that objectKey holds content with the given hash.
+func (m *HashManifest) Record(hash, objectKey string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Hashes[hash] = objectKey
+}
+
+func (m *HashManifest) lookup(hash string) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key, ok := m.Hashes[hash]
+	return key, ok
+}
+
+func (m *HashManifest) forget(hash string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.Hashes, hash)
+}
+
+// CopyDuplicate creates objectKey as a server-side copy of an object already holding content with
+// the given hash, according to the manifest. The source is checked with a HEAD request first, and
+// stale manifest entries are dropped. The metadata and content headers of opts replace those of
+// the copy. It returns the ETag of the copy and false if no usable duplicate is known.
+func CopyDuplicate(ctx context.Context, client *s3.Client, bucketName, objectKey, hash string, m *HashManifest, opts UploadOptions) (string, bool, error) {
+	sourceKey, ok := m.lookup(hash)
+	if !ok {
+		return "", false, nil
+	}
+	if sourceKey == objectKey {
+		return "", false, nil
+	}
+
+	sourceMetadata, err := GetObjectMetadata(ctx, client, bucketName, sourceKey)
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) || (err == nil && sourceMetadata[MetadataSHA256] != hash) {
+		// The source was deleted or overwritten since it was recorded.
+		m.forget(hash)
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	metadata := opts.Metadata
+	if sparseMap := sourceMetadata[MetadataSparse]; sparseMap != "" {
+		// The copy shares the compacted content, so it needs the same hole map.
+		withSparse := map[string]string{MetadataSparse: sparseMap}
+		for k, v := range metadata {
+			withSparse[k] = v
+		}
+		metadata = withSparse
+	}
+
+	resp, err := client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:             &bucketName,
+		CopySource:         aws.String(CopySource(bucketName, sourceKey)),
+		Key:                &objectKey,
+		Metadata:           metadata,
+		MetadataDirective:  types.MetadataDirectiveReplace,
+		CacheControl:       opts.CacheControl,
+		ContentType:        opts.ContentType,
+		ContentDisposition: opts.ContentDisposition,
+		ContentLanguage:    opts.ContentLanguage,
+		StorageClass:       opts.StorageClass,
+	})
+	if err != nil {
+		return "", false, fmt.Errorf("failed to copy object from '%s' to '%s' in bucket '%s': %w", sourceKey, objectKey, bucketName, err)
+	}
+
+	etag := ""
+	if resp.CopyObjectResult != nil && resp.CopyObjectResult.ETag != nil {
+		etag = strings.Trim(*resp.CopyObjectResult.ETag, `"`)
+	}
+	return etag, true, nil
+}
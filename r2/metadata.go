@@ -0,0 +1,73 @@
+package r2
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// HeaderUpdate lists the content headers UpdateObjectMetadata replaces. Nil fields keep the
+// stored header, and empty strings remove it.
+type HeaderUpdate struct {
+	ContentType        *string
+	CacheControl       *string
+	ContentDisposition *string
+	ContentEncoding    *string
+	ContentLanguage    *string
+}
+
+// UpdateObjectMetadata rewrites the user-defined metadata and content headers of an object with a
+// copy onto itself, which leaves its content alone. update is called with the current metadata and
+// changes it in place. The copy only succeeds while the object still has the ETag it was read with,
+// so that an object overwritten meanwhile neither gets the old metadata nor loses its new content.
+func UpdateObjectMetadata(ctx context.Context, client *s3.Client, bucketName, objectKey string, headers HeaderUpdate, update func(map[string]string)) error {
+	head, err := client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: &bucketName,
+		Key:    &objectKey,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get metadata of object '%s' in bucket '%s': %w", objectKey, bucketName, err)
+	}
+
+	metadata := make(map[string]string, len(head.Metadata)+1)
+	for k, v := range head.Metadata {
+		metadata[k] = v
+	}
+	update(metadata)
+
+	_, err = client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:             &bucketName,
+		CopySource:         aws.String(CopySource(bucketName, objectKey)),
+		CopySourceIfMatch:  head.ETag,
+		Key:                &objectKey,
+		Metadata:           metadata,
+		MetadataDirective:  types.MetadataDirectiveReplace,
+		ContentType:        replaceHeader(head.ContentType, headers.ContentType),
+		CacheControl:       replaceHeader(head.CacheControl, headers.CacheControl),
+		ContentDisposition: replaceHeader(head.ContentDisposition, headers.ContentDisposition),
+		ContentEncoding:    replaceHeader(head.ContentEncoding, headers.ContentEncoding),
+		ContentLanguage:    replaceHeader(head.ContentLanguage, headers.ContentLanguage),
+	})
+	if IsPreconditionFailed(err) {
+		return fmt.Errorf("object '%s' in bucket '%s' was overwritten while its metadata was updated; try again", objectKey, bucketName)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update metadata of object '%s' in bucket '%s': %w", objectKey, bucketName, err)
+	}
+	return nil
+}
+
+// replaceHeader returns the new value of a header, or the stored one if there is none. An empty
+// new value removes the header.
+func replaceHeader(stored, replacement *string) *string {
+	if replacement == nil {
+		return stored
+	}
+	if *replacement == "" {
+		return nil
+	}
+	return replacement
+}
@@ -0,0 +1,67 @@
+package r2
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// copyOntoServer serves one object with the ETag "abc" and records the copy source and the
+// precondition of the copy it receives. With replaced set, the precondition always fails.
+type copyOntoServer struct {
+	replaced   bool
+	copySource string
+	ifMatch    string
+}
+
+func (s *copyOntoServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodHead:
+		w.Header().Set("ETag", `"abc"`)
+		w.Header().Set("Content-Type", "text/plain")
+	case http.MethodPut:
+		s.copySource = r.Header.Get("X-Amz-Copy-Source")
+		s.ifMatch = r.Header.Get("X-Amz-Copy-Source-If-Match")
+		if s.replaced {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			w.Write([]byte(`<Error><Code>PreconditionFailed</Code></Error>`))
+			return
+		}
+		w.Write([]byte(`<CopyObjectResult><ETag>"abc"</ETag></CopyObjectResult>`))
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func TestUpdateObjectMetadataCopy(t *testing.T) {
+	srv := &copyOntoServer{}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	key := "reports/q3 final+v2%.txt"
+	err := UpdateObjectMetadata(context.Background(), newTestS3Client(ts.URL), "b", key, HeaderUpdate{}, func(metadata map[string]string) {
+		metadata["team"] = "a"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := CopySource("b", key); srv.copySource != want {
+		t.Errorf("copy source %q, want %q", srv.copySource, want)
+	}
+	if srv.ifMatch != `"abc"` {
+		t.Errorf("copy precondition %q, want the ETag read by HEAD", srv.ifMatch)
+	}
+}
+
+func TestUpdateObjectMetadataOverwritten(t *testing.T) {
+	srv := &copyOntoServer{replaced: true}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	err := UpdateObjectMetadata(context.Background(), newTestS3Client(ts.URL), "b", "k", HeaderUpdate{}, func(map[string]string) {})
+	if err == nil || !strings.Contains(err.Error(), "overwritten") {
+		t.Errorf("got error %v, want the object reported as overwritten", err)
+	}
+}
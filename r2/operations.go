@@ -1,37 +1,144 @@
 package r2
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
+	"net/url"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/baowuhe/go-cfr2/utils"
+
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
 )
 
 // ListObjects lists all objects in the specified R2 bucket.
 func ListObjects(ctx context.Context, client *s3.Client, bucketName string) ([]types.Object, error) {
+	return ListObjectsWithPrefix(ctx, client, bucketName, "")
+}
+
+// ListObjectsWithPrefix lists all objects in the specified R2 bucket whose keys start with prefix.
+func ListObjectsWithPrefix(ctx context.Context, client *s3.Client, bucketName, prefix string) ([]types.Object, error) {
 	var allObjects []types.Object
+	err := WalkObjects(ctx, client, bucketName, prefix, func(obj types.Object) error {
+		allObjects = append(allObjects, obj)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return allObjects, nil
+}
+
+// WalkObjects calls fn for every object in the specified R2 bucket whose key starts with prefix,
+// one listing page at a time, without holding the whole listing in memory.
+// Iteration stops at the first error returned by fn.
+func WalkObjects(ctx context.Context, client *s3.Client, bucketName, prefix string, fn func(types.Object) error) error {
 	input := &s3.ListObjectsV2Input{
 		Bucket: &bucketName,
 	}
+	if prefix != "" {
+		input.Prefix = &prefix
+	}
 
 	paginator := s3.NewListObjectsV2Paginator(client, input)
 
 	for paginator.HasMorePages() {
 		output, err := paginator.NextPage(ctx)
 		if err != nil {
-			return nil, fmt.Errorf("failed to list objects: %w", err)
+			return fmt.Errorf("failed to list objects: %w", err)
+		}
+		for _, obj := range output.Contents {
+			if err := fn(obj); err != nil {
+				return err
+			}
 		}
-		allObjects = append(allObjects, output.Contents...)
 	}
 
-	return allObjects, nil
+	return nil
+}
+
+// ListDirectory lists one level of the specified R2 bucket below prefix, using "/" as delimiter.
+// It returns the common prefixes, each ending in "/", and the objects directly below prefix.
+func ListDirectory(ctx context.Context, client *s3.Client, bucketName, prefix string) ([]string, []types.Object, error) {
+	input := &s3.ListObjectsV2Input{
+		Bucket:    &bucketName,
+		Delimiter: aws.String("/"),
+	}
+	if prefix != "" {
+		input.Prefix = &prefix
+	}
+
+	var dirs []string
+	var objects []types.Object
+	paginator := s3.NewListObjectsV2Paginator(client, input)
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to list objects: %w", err)
+		}
+		for _, common := range output.CommonPrefixes {
+			dirs = append(dirs, aws.ToString(common.Prefix))
+		}
+		objects = append(objects, output.Contents...)
+	}
+
+	return dirs, objects, nil
+}
+
+// ObjectExists reports whether an object exists in the specified R2 bucket.
+func ObjectExists(ctx context.Context, client *s3.Client, bucketName, objectKey string) (bool, error) {
+	_, err := client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: &bucketName,
+		Key:    &objectKey,
+	})
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to check object '%s' in bucket '%s': %w", objectKey, bucketName, err)
+	}
+	return true, nil
+}
+
+// IsDirectoryMarker reports whether obj is a zero-byte "folder" object whose key ends in a slash,
+// as created by some consoles and tools to represent a directory.
+func IsDirectoryMarker(obj types.Object) bool {
+	return obj.Key != nil && strings.HasSuffix(*obj.Key, "/") && aws.ToInt64(obj.Size) == 0
+}
+
+// CreateDirectoryMarker creates a zero-byte directory marker object in the specified R2 bucket.
+// A trailing slash is added to the key if it is missing.
+func CreateDirectoryMarker(ctx context.Context, client *s3.Client, bucketName, objectKey string) error {
+	if !strings.HasSuffix(objectKey, "/") {
+		objectKey += "/"
+	}
+
+	_, err := client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:        &bucketName,
+		Key:           &objectKey,
+		Body:          bytes.NewReader(nil),
+		ContentLength: aws.Int64(0),
+		ContentType:   aws.String("application/x-directory"),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create directory marker '%s' in bucket '%s': %w", objectKey, bucketName, err)
+	}
+
+	return nil
 }
 
 // DeleteObject deletes an object from the specified R2 bucket.
@@ -49,12 +156,23 @@ func DeleteObject(ctx context.Context, client *s3.Client, bucketName, objectKey
 	return nil
 }
 
+// CopySource returns the x-amz-copy-source value naming key in bucketName. Each segment of the key
+// is escaped on its own, so keys containing spaces, '?', '#', '%' or non-ASCII characters copy
+// the right object while the slashes between segments stay as they are.
+func CopySource(bucketName, key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return bucketName + "/" + strings.Join(segments, "/")
+}
+
 // RenameObject renames an object in the specified R2 bucket by copying it to a new key and deleting the original.
 func RenameObject(ctx context.Context, client *s3.Client, bucketName, oldObjectKey, newObjectKey string) error {
 	// First, copy the object to the new key
 	copyInput := &s3.CopyObjectInput{
 		Bucket:     &bucketName,
-		CopySource: aws.String(bucketName + "/" + oldObjectKey),
+		CopySource: aws.String(CopySource(bucketName, oldObjectKey)),
 		Key:        &newObjectKey,
 	}
 
@@ -73,6 +191,88 @@ func RenameObject(ctx context.Context, client *s3.Client, bucketName, oldObjectK
 	return nil
 }
 
+// KeyRename is one object to move in RenameObjects, or to copy in CopyObjects.
+type KeyRename struct {
+	From string
+	To   string
+}
+
+// RenameObjects renames many objects, running up to concurrency renames at a time. Unless
+// overrideProtection is set, a protected object is not renamed and fails with ErrProtected.
+// report, if not nil, is called once per object with its outcome; calls are serialized. It returns
+// the number of objects that failed.
+func RenameObjects(ctx context.Context, client *s3.Client, bucketName string, renames []KeyRename, concurrency int, overrideProtection bool, report func(KeyRename, error)) int {
+	return forEachKeyRename(renames, concurrency, func(rename KeyRename) error {
+		if !overrideProtection {
+			if err := CheckNotProtected(ctx, client, bucketName, rename.From); err != nil {
+				return err
+			}
+		}
+		return RenameObject(ctx, client, bucketName, rename.From, rename.To)
+	}, report)
+}
+
+// CopyObjects copies many objects server-side within a bucket, running up to concurrency copies at
+// a time. report works as for RenameObjects. It returns the number of objects that failed.
+func CopyObjects(ctx context.Context, client *s3.Client, bucketName string, copies []KeyRename, concurrency int, report func(KeyRename, error)) int {
+	return forEachKeyRename(copies, concurrency, func(cp KeyRename) error {
+		return CopyObject(ctx, client, bucketName, cp.From, bucketName, cp.To)
+	}, report)
+}
+
+// forEachKeyRename runs op for every rename on up to concurrency workers, reporting each outcome
+// under a lock, and returns the number of failures.
+func forEachKeyRename(renames []KeyRename, concurrency int, op func(KeyRename) error, report func(KeyRename, error)) int {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		failed int
+	)
+	queue := make(chan KeyRename)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for rename := range queue {
+				err := op(rename)
+
+				mu.Lock()
+				if err != nil {
+					failed++
+				}
+				if report != nil {
+					report(rename, err)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, rename := range renames {
+		queue <- rename
+	}
+	close(queue)
+	wg.Wait()
+	return failed
+}
+
+// CopyObject copies an object server-side, possibly into another bucket of the same account. The
+// metadata and content headers of the source are kept.
+func CopyObject(ctx context.Context, client *s3.Client, srcBucket, srcKey, dstBucket, dstKey string) error {
+	_, err := client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:     &dstBucket,
+		CopySource: aws.String(CopySource(srcBucket, srcKey)),
+		Key:        &dstKey,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to copy object '%s/%s' to '%s/%s': %w", srcBucket, srcKey, dstBucket, dstKey, err)
+	}
+	return nil
+}
+
 // progressWriter is a custom io.Writer that reports progress for downloads.
 type progressWriter struct {
 	io.Writer
@@ -117,24 +317,98 @@ func (pr *progressReader) Read(p []byte) (int, error) {
 	pr.mu.Unlock()
 
 	// Print progress on a single line
+	if pr.total <= 0 {
+		// The size of a stream is unknown.
+		fmt.Fprintf(os.Stdout, "\r%d bytes", pr.transferred)
+		os.Stdout.Sync()
+		return n, nil
+	}
 	percentage := float64(pr.transferred) / float64(pr.total) * 10
 	fmt.Fprintf(os.Stdout, "\r%d / %d (%.2f%%)", pr.transferred, pr.total, percentage)
 	os.Stdout.Sync() // Ensure immediate flush
 	return n, nil
 }
 
+// DownloadOptions holds optional settings for DownloadObjectWithOptions.
+type DownloadOptions struct {
+	// PreservePerms restores the mode, ownership and extended attributes recorded at upload.
+	PreservePerms bool
+	// SSEKey decrypts an object uploaded with a customer-provided key.
+	SSEKey *SSECustomerKey
+	// Range, when set, downloads only part of the object. It is an HTTP Range value such as
+	// "bytes=0-1023" or "bytes=-1024".
+	Range string
+}
+
 // DownloadObject downloads an object from the specified R2 bucket to a local file.
 func DownloadObject(ctx context.Context, client *s3.Client, bucketName, objectKey, localFilePath string) error {
+	return DownloadObjectWithOptions(ctx, client, bucketName, objectKey, localFilePath, DownloadOptions{})
+}
+
+// DownloadObjectWithOptions downloads an object from the specified R2 bucket to a local file with custom download options.
+func DownloadObjectWithOptions(ctx context.Context, client *s3.Client, bucketName, objectKey, localFilePath string, opts DownloadOptions) error {
+	// A range does not fit the empty stub of a hard link, so with a range the object is looked at
+	// first, and the range applies to the object holding the content.
+	contentKey := objectKey
+	var permsMetadata map[string]string
+	if opts.Range != "" {
+		headInput := &s3.HeadObjectInput{
+			Bucket: &bucketName,
+			Key:    &objectKey,
+		}
+		headInput.SSECustomerAlgorithm, headInput.SSECustomerKey, headInput.SSECustomerKeyMD5 = opts.SSEKey.params()
+		head, err := client.HeadObject(ctx, headInput)
+		if err != nil {
+			return fmt.Errorf("failed to get metadata of object '%s' in bucket '%s': %w", objectKey, bucketName, err)
+		}
+		permsMetadata = head.Metadata
+		if target := head.Metadata[MetadataHardlink]; target != "" {
+			contentKey = target
+		}
+	}
+
 	input := &s3.GetObjectInput{
 		Bucket: &bucketName,
-		Key:    &objectKey,
+		Key:    &contentKey,
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = opts.SSEKey.params()
+	if opts.Range != "" {
+		input.Range = &opts.Range
 	}
 
 	resp, err := client.GetObject(ctx, input)
+	if err != nil && contentKey != objectKey {
+		return fmt.Errorf("failed to get object '%s' linked from '%s' in bucket '%s': %w", contentKey, objectKey, bucketName, err)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to get object '%s' from bucket '%s': %w", objectKey, bucketName, err)
 	}
 	defer resp.Body.Close()
+	if permsMetadata == nil {
+		permsMetadata = resp.Metadata
+	}
+
+	// A hard link stub written by sync has no content of its own, fetch the linked object instead.
+	// Only a download without a range gets here with the stub. The linked object is encrypted with
+	// the same customer key as the stub.
+	if target := resp.Metadata[MetadataHardlink]; target != "" {
+		linkInput := &s3.GetObjectInput{
+			Bucket: &bucketName,
+			Key:    &target,
+		}
+		linkInput.SSECustomerAlgorithm, linkInput.SSECustomerKey, linkInput.SSECustomerKeyMD5 = opts.SSEKey.params()
+		resp, err = client.GetObject(ctx, linkInput)
+		if err != nil {
+			return fmt.Errorf("failed to get object '%s' linked from '%s' in bucket '%s': %w", target, objectKey, bucketName, err)
+		}
+		defer resp.Body.Close()
+	}
+
+	// The stored content of a sparse file leaves out its holes, so a range of it is no range of the
+	// file.
+	if opts.Range != "" && resp.Metadata[MetadataSparse] != "" {
+		return fmt.Errorf("object '%s' holds a sparse file, whose ranges cannot be downloaded", objectKey)
+	}
 
 	file, err := os.Create(localFilePath)
 	if err != nil {
@@ -142,12 +416,28 @@ func DownloadObject(ctx context.Context, client *s3.Client, bucketName, objectKe
 	}
 	defer file.Close()
 
+	if sparseMap := resp.Metadata[MetadataSparse]; sparseMap != "" {
+		if err := restoreSparse(file, resp.Body, sparseMap); err != nil {
+			return fmt.Errorf("failed to write object content to file '%s': %w", localFilePath, err)
+		}
+	} else if err := copyWithProgress(logger(client), file, resp, localFilePath); err != nil {
+		return err
+	}
+
+	if opts.PreservePerms {
+		return restorePerms(logger(client), localFilePath, permsMetadata)
+	}
+	return nil
+}
+
+// copyWithProgress writes the body of a GetObject response to file while reporting progress.
+func copyWithProgress(log Logger, file *os.File, resp *s3.GetObjectOutput, localFilePath string) error {
 	// Get total size for progress tracking
 	var totalSize int64
 	if resp.ContentLength != nil {
 		totalSize = *resp.ContentLength
 	} else {
-		fmt.Println("Warning: ContentLength not available, download progress percentage will not be shown.")
+		log.Warnf("ContentLength not available, download progress percentage will not be shown.")
 	}
 
 	pw := &progressWriter{
@@ -155,7 +445,7 @@ func DownloadObject(ctx context.Context, client *s3.Client, bucketName, objectKe
 		total:  totalSize,
 	}
 
-	_, err = io.Copy(pw, resp.Body)
+	_, err := io.Copy(pw, resp.Body)
 	if err != nil {
 		return fmt.Errorf("failed to write object content to file '%s': %w", localFilePath, err)
 	}
@@ -164,37 +454,372 @@ func DownloadObject(ctx context.Context, client *s3.Client, bucketName, objectKe
 	return nil
 }
 
+// OpenObject returns a reader streaming the body of an object in the specified R2 bucket.
+// The caller must close the returned reader.
+func OpenObject(ctx context.Context, client *s3.Client, bucketName, objectKey string) (io.ReadCloser, error) {
+	resp, err := client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: &bucketName,
+		Key:    &objectKey,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get object '%s' from bucket '%s': %w", objectKey, bucketName, err)
+	}
+
+	return resp.Body, nil
+}
+
+// OpenObjectRange returns a reader streaming part of an object in the specified R2 bucket.
+// byteRange is an HTTP Range value such as "bytes=0-1023" or "bytes=-1024".
+// The caller must close the returned reader.
+func OpenObjectRange(ctx context.Context, client *s3.Client, bucketName, objectKey, byteRange string) (io.ReadCloser, error) {
+	resp, err := client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: &bucketName,
+		Key:    &objectKey,
+		Range:  &byteRange,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get range '%s' of object '%s' from bucket '%s': %w", byteRange, objectKey, bucketName, err)
+	}
+
+	return resp.Body, nil
+}
+
+// IsInvalidRange reports whether a ranged request failed because the range starts past the end of
+// the object.
+func IsInvalidRange(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidRange"
+}
+
+// IsPreconditionFailed reports whether err is R2's answer to a request whose If-Match or
+// x-amz-copy-source-if-match condition did not hold, because the object changed in the meantime.
+func IsPreconditionFailed(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "PreconditionFailed"
+}
+
+// UploadOptions holds optional settings for UploadObjectWithOptions.
+type UploadOptions struct {
+	// Metadata is stored as user-defined metadata on the uploaded object.
+	Metadata map[string]string
+	// Quiet disables the progress output, e.g. for uploads running concurrently.
+	Quiet bool
+	// Sparse uploads only the data extents of a sparse file and records its holes in metadata,
+	// so that downloads can recreate the file with its holes.
+	Sparse bool
+	// Manifest, when set, turns the upload into a server-side copy if the same content is already
+	// stored under another key, and records the upload otherwise. The caller saves it.
+	Manifest *HashManifest
+	// PreservePerms records the mode, ownership and extended attributes of the file in metadata.
+	PreservePerms bool
+	// CacheControl, when set, is stored as the Cache-Control header of the object.
+	CacheControl *string
+	// ContentType, when set, is stored as the Content-Type header of the object. Otherwise the
+	// type is detected from the extension of the key or file, or from the first bytes of the content.
+	ContentType *string
+	// ContentDisposition, when set, is stored as the Content-Disposition header of the object.
+	ContentDisposition *string
+	// ContentLanguage, when set, is stored as the Content-Language header of the object.
+	ContentLanguage *string
+	// StorageClass, when set, is the storage class of the object, STANDARD or STANDARD_IA.
+	StorageClass types.StorageClass
+	// Concurrency is the number of parts of a multipart upload sent in parallel. Zero uses the
+	// default of the SDK.
+	Concurrency int
+	// SSEKey, when set, has R2 encrypt the object with this customer-provided key.
+	SSEKey *SSECustomerKey
+}
+
 // UploadObject uploads a local file to the specified R2 bucket.
 func UploadObject(ctx context.Context, client *s3.Client, bucketName, objectKey, localFilePath string) error {
+	return UploadObjectWithOptions(ctx, client, bucketName, objectKey, localFilePath, UploadOptions{})
+}
+
+// UploadObjectWithOptions uploads a local file to the specified R2 bucket with custom upload options.
+func UploadObjectWithOptions(ctx context.Context, client *s3.Client, bucketName, objectKey, localFilePath string, opts UploadOptions) error {
+	if opts.PreservePerms {
+		info, err := os.Stat(localFilePath)
+		if err != nil {
+			return fmt.Errorf("failed to get file info for '%s': %w", localFilePath, err)
+		}
+		metadata := capturePerms(logger(client), localFilePath, info)
+		for k, v := range opts.Metadata {
+			metadata[k] = v
+		}
+		opts.Metadata = metadata
+	}
+	if opts.ContentType == nil {
+		contentType, err := fileContentType(objectKey, localFilePath)
+		if err != nil {
+			return err
+		}
+		opts.ContentType = &contentType
+	}
+
+	if opts.Manifest == nil {
+		_, err := uploadFile(ctx, client, bucketName, objectKey, localFilePath, opts)
+		return err
+	}
+
+	hash, err := utils.FileSHA256(localFilePath)
+	if err != nil {
+		return err
+	}
+	metadata := map[string]string{MetadataSHA256: hash}
+	for k, v := range opts.Metadata {
+		metadata[k] = v
+	}
+	opts.Metadata = metadata
+
+	_, copied, err := CopyDuplicate(ctx, client, bucketName, objectKey, hash, opts.Manifest, opts)
+	if err != nil || copied {
+		return err
+	}
+	if _, err := uploadFile(ctx, client, bucketName, objectKey, localFilePath, opts); err != nil {
+		return err
+	}
+	opts.Manifest.Record(hash, objectKey)
+	return nil
+}
+
+// uploadFile uploads a local file and returns the upload result, which carries the new ETag.
+func uploadFile(ctx context.Context, client *s3.Client, bucketName, objectKey, localFilePath string, opts UploadOptions) (*manager.UploadOutput, error) {
 	file, err := os.Open(localFilePath)
 	if err != nil {
-		return fmt.Errorf("failed to open local file '%s': %w", localFilePath, err)
+		return nil, fmt.Errorf("failed to open local file '%s': %w", localFilePath, err)
 	}
 	defer file.Close()
 
 	fileInfo, err := file.Stat()
 	if err != nil {
-		return fmt.Errorf("failed to get file info for '%s': %w", localFilePath, err)
+		return nil, fmt.Errorf("failed to get file info for '%s': %w", localFilePath, err)
 	}
 	fileSize := fileInfo.Size()
 
-	pr := &progressReader{
-		Reader: file,
-		total:  fileSize,
+	if opts.ContentType == nil {
+		contentType, err := fileContentType(objectKey, localFilePath)
+		if err != nil {
+			return nil, err
+		}
+		opts.ContentType = &contentType
+	}
+
+	var source io.Reader = file
+	metadata := opts.Metadata
+	if opts.Sparse {
+		extents, err := dataExtents(file, fileSize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to locate holes in '%s': %w", localFilePath, err)
+		}
+		if sparseMap, ok := encodeSparseMap(fileSize, extents); ok {
+			source = newExtentReader(file, extents)
+			fileSize = extentsLength(extents)
+			metadata = map[string]string{MetadataSparse: sparseMap}
+			for k, v := range opts.Metadata {
+				metadata[k] = v
+			}
+		}
+	}
+
+	body := source
+	if !opts.Quiet {
+		body = &progressReader{
+			Reader: source,
+			total:  fileSize,
+		}
+	}
+
+	input := &s3.PutObjectInput{
+		Bucket:             &bucketName,
+		Key:                &objectKey,
+		Body:               body, // Use progressReader as the Body unless quiet
+		Metadata:           metadata,
+		CacheControl:       opts.CacheControl,
+		ContentType:        opts.ContentType,
+		ContentDisposition: opts.ContentDisposition,
+		ContentLanguage:    opts.ContentLanguage,
+		StorageClass:       opts.StorageClass,
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = opts.SSEKey.params()
+
+	uploader := manager.NewUploader(client, func(u *manager.Uploader) {
+		if opts.Concurrency > 0 {
+			u.Concurrency = opts.Concurrency
+		}
+	})
+	output, err := uploader.Upload(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload object '%s' to bucket '%s': %w", objectKey, bucketName, err)
+	}
+	if !opts.Quiet {
+		fmt.Println() // Newline after upload completes
 	}
 
-	uploader := manager.NewUploader(client)
-	_, err = uploader.Upload(ctx, &s3.PutObjectInput{
+	return output, nil
+}
+
+// sniffLen is the number of leading bytes http.DetectContentType looks at.
+const sniffLen = 512
+
+// fileContentType returns the media type of a file uploaded as objectKey: that of the extension of
+// the key, else that of the file name, else the type sniffed from the first bytes of the file.
+func fileContentType(objectKey, localFilePath string) (string, error) {
+	if contentType := contentTypeFor(objectKey); contentType != "" {
+		return contentType, nil
+	}
+	if contentType := contentTypeFor(localFilePath); contentType != "" {
+		return contentType, nil
+	}
+
+	file, err := os.Open(localFilePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open local file '%s': %w", localFilePath, err)
+	}
+	defer file.Close()
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", fmt.Errorf("failed to read local file '%s': %w", localFilePath, err)
+	}
+	return http.DetectContentType(head[:n]), nil
+}
+
+// GetObjectMetadata returns the user-defined metadata of an object in the specified R2 bucket.
+func GetObjectMetadata(ctx context.Context, client *s3.Client, bucketName, objectKey string) (map[string]string, error) {
+	resp, err := client.HeadObject(ctx, &s3.HeadObjectInput{
 		Bucket: &bucketName,
 		Key:    &objectKey,
-		Body:   pr, // Use progressReader as the Body
 	})
 	if err != nil {
-		return fmt.Errorf("failed to upload object '%s' to bucket '%s': %w", objectKey, bucketName, err)
+		return nil, fmt.Errorf("failed to get metadata of object '%s' in bucket '%s': %w", objectKey, bucketName, err)
 	}
-	fmt.Println() // Newline after upload completes
 
-	return nil
+	return resp.Metadata, nil
+}
+
+// ObjectStat is the metadata of an object, as returned by StatObject.
+type ObjectStat struct {
+	Key                string
+	Size               int64
+	ETag               string
+	LastModified       time.Time
+	ContentType        string
+	ContentEncoding    string
+	ContentDisposition string
+	ContentLanguage    string
+	CacheControl       string
+	Expires            string
+	StorageClass       string
+	// Metadata holds the user-defined metadata, keyed by name without the x-amz-meta- prefix.
+	Metadata map[string]string
+}
+
+// StatObject returns the metadata of an object in the specified R2 bucket without downloading it.
+// The error wraps *types.NotFound if the object does not exist.
+func StatObject(ctx context.Context, client *s3.Client, bucketName, objectKey string) (*ObjectStat, error) {
+	resp, err := client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: &bucketName,
+		Key:    &objectKey,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get metadata of object '%s' in bucket '%s': %w", objectKey, bucketName, err)
+	}
+	return ObjectStatOf(objectKey, resp), nil
+}
+
+// ObjectStatOf returns the properties of an object from the response to its HEAD request, such as
+// one delivered by a HeadPipeline.
+func ObjectStatOf(objectKey string, resp *s3.HeadObjectOutput) *ObjectStat {
+	return &ObjectStat{
+		Key:                objectKey,
+		Size:               aws.ToInt64(resp.ContentLength),
+		ETag:               aws.ToString(resp.ETag),
+		LastModified:       aws.ToTime(resp.LastModified),
+		ContentType:        aws.ToString(resp.ContentType),
+		ContentEncoding:    aws.ToString(resp.ContentEncoding),
+		ContentDisposition: aws.ToString(resp.ContentDisposition),
+		ContentLanguage:    aws.ToString(resp.ContentLanguage),
+		CacheControl:       aws.ToString(resp.CacheControl),
+		Expires:            aws.ToString(resp.ExpiresString),
+		StorageClass:       string(resp.StorageClass),
+		Metadata:           resp.Metadata,
+	}
+}
+
+// streamPartSize is the part size of uploads of unknown length. The 10,000 parts of a multipart
+// upload then hold streams of up to about 160 GiB.
+const streamPartSize = 16 * 1024 * 1024
+
+// UploadStream uploads the contents of a reader of unknown length to the specified R2 bucket.
+// The data is sent as a multipart upload when it exceeds a single part, and the upload is aborted
+// if the reader returns an error.
+func UploadStream(ctx context.Context, client *s3.Client, bucketName, objectKey string, body io.Reader) error {
+	_, err := UploadStreamWithOptions(ctx, client, bucketName, objectKey, body, UploadOptions{Quiet: true})
+	return err
+}
+
+// UploadStreamWithOptions uploads the contents of a reader of unknown length, such as stdin, with
+// custom upload options, and returns the number of bytes uploaded. Sparse, Manifest and
+// PreservePerms need a file and are rejected.
+func UploadStreamWithOptions(ctx context.Context, client *s3.Client, bucketName, objectKey string, body io.Reader, opts UploadOptions) (int64, error) {
+	if opts.Sparse || opts.Manifest != nil || opts.PreservePerms {
+		return 0, fmt.Errorf("sparse, deduplicated and permission-preserving uploads need a file")
+	}
+	if opts.ContentType == nil {
+		contentType := contentTypeFor(objectKey)
+		if contentType == "" {
+			// Peeking leaves the sniffed bytes in the buffer for the upload.
+			buffered := bufio.NewReaderSize(body, sniffLen)
+			head, _ := buffered.Peek(sniffLen)
+			contentType = http.DetectContentType(head)
+			body = buffered
+		}
+		opts.ContentType = &contentType
+	}
+
+	counter := &countingReader{Reader: body}
+	body = counter
+	if !opts.Quiet {
+		body = &progressReader{Reader: counter}
+	}
+	input := &s3.PutObjectInput{
+		Bucket:             &bucketName,
+		Key:                &objectKey,
+		Body:               body,
+		Metadata:           opts.Metadata,
+		CacheControl:       opts.CacheControl,
+		ContentType:        opts.ContentType,
+		ContentDisposition: opts.ContentDisposition,
+		ContentLanguage:    opts.ContentLanguage,
+		StorageClass:       opts.StorageClass,
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = opts.SSEKey.params()
+
+	uploader := manager.NewUploader(client, func(u *manager.Uploader) {
+		u.PartSize = streamPartSize
+		if opts.Concurrency > 0 {
+			u.Concurrency = opts.Concurrency
+		}
+	})
+	if _, err := uploader.Upload(ctx, input); err != nil {
+		return 0, fmt.Errorf("failed to upload object '%s' to bucket '%s': %w", objectKey, bucketName, err)
+	}
+	if !opts.Quiet {
+		fmt.Println() // Newline after upload completes
+	}
+	return counter.n, nil
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n += int64(n)
+	return n, err
 }
 
 // GeneratePresignedURL generates a presigned URL for an object in the specified R2 bucket with a default expiration of 24 hours.
@@ -211,12 +836,66 @@ func GeneratePresignedURLWithExpiry(ctx context.Context, client *s3.Client, buck
 		Key:    &objectKey,
 	}
 
-	result, err := presignClient.PresignGetObject(ctx, input, func(opts *s3.PresignOptions) {
-		opts.Expires = expiry
-	})
+	result, err := presignClient.PresignGetObject(ctx, input, presignOptions(ctx, client, bucketName, expiry))
 	if err != nil {
 		return "", fmt.Errorf("failed to generate presigned URL for object '%s' in bucket '%s': %w", objectKey, bucketName, err)
 	}
 
 	return result.URL, nil
 }
+
+// PresignPutOptions holds optional constraints of a presigned upload URL. The uploader must send
+// the constrained headers with exactly these values, or R2 rejects the signature.
+type PresignPutOptions struct {
+	// ContentType, when set, is the Content-Type the object must be uploaded with.
+	ContentType string
+	// ContentLength, when positive, is the size in bytes the upload must have.
+	ContentLength int64
+}
+
+// GeneratePresignedPutURL generates a presigned URL that uploads an object to the specified R2
+// bucket with a PUT request, for clients without credentials. It also returns the headers the
+// client has to send, as required by opts.
+func GeneratePresignedPutURL(ctx context.Context, client *s3.Client, bucketName, objectKey string, expiry time.Duration, opts PresignPutOptions) (string, http.Header, error) {
+	presignClient := s3.NewPresignClient(client)
+
+	input := &s3.PutObjectInput{
+		Bucket: &bucketName,
+		Key:    &objectKey,
+	}
+	if opts.ContentType != "" {
+		input.ContentType = &opts.ContentType
+	}
+	if opts.ContentLength > 0 {
+		input.ContentLength = &opts.ContentLength
+	}
+
+	result, err := presignClient.PresignPutObject(ctx, input, presignOptions(ctx, client, bucketName, expiry))
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to generate presigned upload URL for object '%s' in bucket '%s': %w", objectKey, bucketName, err)
+	}
+
+	headers := http.Header{}
+	for name, values := range result.SignedHeader {
+		if !strings.EqualFold(name, "host") {
+			headers[http.CanonicalHeaderKey(name)] = values
+		}
+	}
+	return result.URL, headers, nil
+}
+
+// MaxPresignExpiry is the longest expiry of a presigned URL that R2 accepts.
+const MaxPresignExpiry = 7 * 24 * time.Hour
+
+// presignOptions sets the expiry of a presigned URL. The SDK signs presigned URLs with the local
+// clock, so a URL from a machine whose clock is off would be rejected as not yet valid or expire
+// early; R2's time is used instead then, as found by presignClockSkew.
+func presignOptions(ctx context.Context, client *s3.Client, bucketName string, expiry time.Duration) func(*s3.PresignOptions) {
+	skew := presignClockSkew(ctx, client, bucketName)
+	return func(opts *s3.PresignOptions) {
+		opts.Expires = expiry
+		if skew != 0 {
+			opts.Presigner = newSkewCorrectedPresigner(skew)
+		}
+	}
+}
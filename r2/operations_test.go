@@ -0,0 +1,104 @@
+package r2
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestCopySource(t *testing.T) {
+	tests := []struct {
+		bucket, key string
+		want        string
+	}{
+		{"site", "index.html", "site/index.html"},
+		{"site", "docs/a b.html", "site/docs/a%20b.html"},
+		{"site", "what?/100%.txt", "site/what%3F/100%25.txt"},
+		{"site", "tag#1/é.png", "site/tag%231/%C3%A9.png"},
+		{"site", "dir//x", "site/dir//x"},
+	}
+	for _, tt := range tests {
+		got := CopySource(tt.bucket, tt.key)
+		if got != tt.want {
+			t.Errorf("CopySource(%q, %q) = %q, want %q", tt.bucket, tt.key, got, tt.want)
+		}
+		// The policy check must see the same key the copy names.
+		req := copySourceRead(aws.String(got))
+		if len(req) != 1 || req[0].Bucket != tt.bucket || req[0].Key != tt.key {
+			t.Errorf("copySourceRead(%q) = %+v, want bucket %q key %q", got, req, tt.bucket, tt.key)
+		}
+	}
+}
+
+func TestDownloadHardLinkRange(t *testing.T) {
+	content := "0123456789"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.URL.Path, "/b/")
+		switch {
+		case key == "link":
+			// The stub is empty, so R2 refuses any range of it.
+			if r.Header.Get("Range") != "" {
+				w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+				w.Write([]byte(`<Error><Code>InvalidRange</Code></Error>`))
+				return
+			}
+			w.Header().Set("X-Amz-Meta-"+MetadataHardlink, "target")
+			w.Header().Set("Content-Length", "0")
+		case key == "target" && r.Header.Get("Range") == "bytes=2-4":
+			w.Header().Set("Content-Range", "bytes 2-4/10")
+			w.Header().Set("Content-Length", "3")
+			w.WriteHeader(http.StatusPartialContent)
+			if r.Method == http.MethodGet {
+				w.Write([]byte(content[2:5]))
+			}
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer ts.Close()
+
+	path := filepath.Join(t.TempDir(), "out")
+	err := DownloadObjectWithOptions(context.Background(), newTestS3Client(ts.URL), "b", "link", path, DownloadOptions{Range: "bytes=2-4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "234" {
+		t.Errorf("downloaded %q, want %q", data, "234")
+	}
+}
+
+func TestDownloadHardLinkSSECustomerKey(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Both the stub and the linked object are encrypted with the customer key.
+		if r.Header.Get("X-Amz-Server-Side-Encryption-Customer-Key") != "a2V5" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch strings.TrimPrefix(r.URL.Path, "/b/") {
+		case "link":
+			w.Header().Set("X-Amz-Meta-"+MetadataHardlink, "target")
+			w.Header().Set("Content-Length", "0")
+		case "target":
+			w.Write([]byte("content"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	path := filepath.Join(t.TempDir(), "out")
+	sseKey := &SSECustomerKey{algorithm: "AES256", key: "a2V5", keyMD5: "bWQ1"}
+	err := DownloadObjectWithOptions(context.Background(), newTestS3Client(ts.URL), "b", "link", path, DownloadOptions{SSEKey: sseKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "content" {
+		t.Errorf("downloaded %q, want %q", data, "content")
+	}
+}
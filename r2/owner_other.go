@@ -0,0 +1,10 @@
+//go:build !unix
+
+package r2
+
+import "io/fs"
+
+// fileOwner reports no ownership on platforms without Unix owners.
+func fileOwner(info fs.FileInfo) (int, int, bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package r2
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// fileOwner returns the numeric owner and group of a file.
+func fileOwner(info fs.FileInfo) (int, int, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return int(stat.Uid), int(stat.Gid), true
+}
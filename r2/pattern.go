@@ -0,0 +1,84 @@
+package r2
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// matchKeyPattern reports whether a key, relative to a sync or restore prefix, matches a pattern.
+// A pattern ending in "/" matches every key below that directory, a pattern containing "/" is a
+// path.Match glob against the whole relative key, and any other pattern is a glob against the
+// last path element, so "*.html" matches HTML files at any depth. A leading "/" anchors a pattern
+// to the prefix, so "/robots.txt" only matches the file at the top.
+func matchKeyPattern(pattern, rel string) bool {
+	if anchored, ok := strings.CutPrefix(pattern, "/"); ok {
+		if strings.HasSuffix(anchored, "/") {
+			return strings.HasPrefix(rel, anchored)
+		}
+		ok, _ := path.Match(anchored, rel)
+		return ok
+	}
+	if strings.HasSuffix(pattern, "/") {
+		return strings.HasPrefix(rel, pattern)
+	}
+	if !strings.Contains(pattern, "/") {
+		rel = path.Base(rel)
+	}
+	ok, _ := path.Match(pattern, rel)
+	return ok
+}
+
+// matchAnyKeyPattern reports whether a relative key matches any of the patterns.
+func matchAnyKeyPattern(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		if matchKeyPattern(pattern, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsKeyGlob reports whether an object key given on the command line is a glob pattern rather than
+// a literal key. Keys containing a metacharacter can be given literally by escaping it with "\",
+// which matches the key in a listing, or, without a listing, with literal, which takes every key
+// as literal, for keys with many metacharacters.
+func IsKeyGlob(key string, literal bool) bool {
+	return !literal && strings.ContainsAny(key, `*?[\`)
+}
+
+// ExpandKeyGlob returns the keys of a bucket matching a path.Match glob against the whole key, so
+// "*" does not cross a "/". Only the keys below the literal start of the pattern are listed, and
+// directory markers never match.
+func ExpandKeyGlob(ctx context.Context, client *s3.Client, bucketName, pattern string) ([]string, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid pattern '%s': %w", pattern, err)
+	}
+	prefix := pattern
+	if i := strings.IndexAny(pattern, `*?[\`); i >= 0 {
+		prefix = pattern[:i]
+	}
+
+	var keys []string
+	err := WalkObjects(ctx, client, bucketName, prefix, func(obj types.Object) error {
+		if ok, _ := path.Match(pattern, *obj.Key); ok && !IsDirectoryMarker(obj) {
+			keys = append(keys, *obj.Key)
+		}
+		return nil
+	})
+	return keys, err
+}
+
+// KeyGlobBase returns the directory part of the literal start of a glob, such as "images/" for
+// "images/*.png". Keys matching the pattern can be shortened to their path below it.
+func KeyGlobBase(pattern string) string {
+	prefix := pattern
+	if i := strings.IndexAny(pattern, `*?[\`); i >= 0 {
+		prefix = pattern[:i]
+	}
+	return prefix[:strings.LastIndex(prefix, "/")+1]
+}
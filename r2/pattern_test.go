@@ -0,0 +1,87 @@
+package r2
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestMatchKeyPattern(t *testing.T) {
+	tests := []struct {
+		pattern, rel string
+		want         bool
+	}{
+		{"*.html", "docs/index.html", true},
+		{"_slot.json", "docs/_slot.json", true},
+		{"/_slot.json", "_slot.json", true},
+		{"/_slot.json", "docs/_slot.json", false},
+		{"/docs/*.html", "docs/index.html", true},
+		{"/drafts/", "drafts/a.md", true},
+		{"/drafts/", "docs/drafts/a.md", false},
+		{"drafts/", "drafts/a.md", true},
+	}
+	for _, tt := range tests {
+		if got := matchKeyPattern(tt.pattern, tt.rel); got != tt.want {
+			t.Errorf("matchKeyPattern(%q, %q) = %v, want %v", tt.pattern, tt.rel, got, tt.want)
+		}
+	}
+}
+
+func TestIsKeyGlob(t *testing.T) {
+	tests := []struct {
+		key     string
+		literal bool
+		want    bool
+	}{
+		{"docs/a.pdf", false, false},
+		{"docs/*.pdf", false, true},
+		{`report\[1\].pdf`, false, true},
+		{"report[1].pdf", true, false},
+		{"docs/*.pdf", true, false},
+	}
+	for _, tt := range tests {
+		if got := IsKeyGlob(tt.key, tt.literal); got != tt.want {
+			t.Errorf("IsKeyGlob(%q) with literal keys %v = %v, want %v", tt.key, tt.literal, got, tt.want)
+		}
+	}
+}
+
+func TestExpandKeyGlobEscapes(t *testing.T) {
+	keys := []string{"report[1].pdf", "report1.pdf", "what?.txt", "whatX.txt", `back\slash`}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var b strings.Builder
+		b.WriteString(`<ListBucketResult><IsTruncated>false</IsTruncated>`)
+		for _, key := range keys {
+			if strings.HasPrefix(key, r.URL.Query().Get("prefix")) {
+				fmt.Fprintf(&b, `<Contents><Key>%s</Key><Size>1</Size></Contents>`, key)
+			}
+		}
+		b.WriteString(`</ListBucketResult>`)
+		w.Write([]byte(b.String()))
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{`report\[1\].pdf`, []string{"report[1].pdf"}},
+		{`report[1].pdf`, []string{"report1.pdf"}},
+		{`what\?.txt`, []string{"what?.txt"}},
+		{`what?.txt`, []string{"what?.txt", "whatX.txt"}},
+		{`back\\slash`, []string{`back\slash`}},
+	}
+	for _, tt := range tests {
+		got, err := ExpandKeyGlob(context.Background(), newTestS3Client(ts.URL), "b", tt.pattern)
+		if err != nil {
+			t.Fatalf("ExpandKeyGlob(%q): %v", tt.pattern, err)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("ExpandKeyGlob(%q) = %q, want %q", tt.pattern, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package r2
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+)
+
+// Metadata keys written when permissions are preserved.
+const (
+	// MetadataMode holds the permission bits of the source file, including setuid, setgid and sticky, in octal.
+	MetadataMode = "mode"
+	// MetadataUID and MetadataGID hold the numeric owner and group of the source file.
+	MetadataUID = "uid"
+	MetadataGID = "gid"
+	// MetadataXattrs holds the extended attributes of the source file as base64-encoded JSON.
+	MetadataXattrs = "xattrs"
+)
+
+// maxXattrsLen keeps the extended attributes well inside the metadata size limit.
+const maxXattrsLen = 4096
+
+// capturePerms returns the metadata describing the mode, ownership and extended attributes of a file.
+func capturePerms(log Logger, path string, info fs.FileInfo) map[string]string {
+	metadata := map[string]string{
+		MetadataMode: strconv.FormatUint(uint64(unixMode(info.Mode())), 8),
+	}
+	if uid, gid, ok := fileOwner(info); ok {
+		metadata[MetadataUID] = strconv.Itoa(uid)
+		metadata[MetadataGID] = strconv.Itoa(gid)
+	}
+
+	xattrs, err := readXattrs(path)
+	if err != nil {
+		log.Warnf("failed to read extended attributes of '%s': %v", path, err)
+	} else if len(xattrs) > 0 {
+		data, err := json.Marshal(xattrs)
+		if err == nil {
+			encoded := base64.StdEncoding.EncodeToString(data)
+			if len(encoded) <= maxXattrsLen {
+				metadata[MetadataXattrs] = encoded
+			} else {
+				log.Warnf("extended attributes of '%s' are too large to preserve.", path)
+			}
+		}
+	}
+	return metadata
+}
+
+// restorePerms applies the mode, ownership and extended attributes recorded in metadata to a file.
+// Ownership can usually only be changed by root; failures to do so are reported as warnings.
+func restorePerms(log Logger, path string, metadata map[string]string) error {
+	// The owner is changed first, since a change of owner clears the setuid and setgid bits.
+	uid, uidErr := strconv.Atoi(metadata[MetadataUID])
+	gid, gidErr := strconv.Atoi(metadata[MetadataGID])
+	if uidErr == nil && gidErr == nil {
+		if err := os.Lchown(path, uid, gid); err != nil {
+			log.Warnf("failed to restore owner of '%s': %v", path, err)
+		}
+	}
+
+	if value, ok := metadata[MetadataMode]; ok {
+		mode, err := strconv.ParseUint(value, 8, 32)
+		if err != nil {
+			return fmt.Errorf("invalid mode '%s' in metadata", value)
+		}
+		if err := os.Chmod(path, fileMode(uint32(mode))); err != nil {
+			return fmt.Errorf("failed to restore mode of '%s': %w", path, err)
+		}
+	}
+
+	if value, ok := metadata[MetadataXattrs]; ok {
+		data, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return fmt.Errorf("invalid extended attributes in metadata: %w", err)
+		}
+		var xattrs map[string][]byte
+		if err := json.Unmarshal(data, &xattrs); err != nil {
+			return fmt.Errorf("invalid extended attributes in metadata: %w", err)
+		}
+		if err := writeXattrs(path, xattrs); err != nil {
+			log.Warnf("failed to restore extended attributes of '%s': %v", path, err)
+		}
+	}
+	return nil
+}
+
+// unixMode converts a Go file mode to the traditional Unix permission bits.
+func unixMode(mode fs.FileMode) uint32 {
+	bits := uint32(mode.Perm())
+	if mode&fs.ModeSetuid != 0 {
+		bits |= 0o4000
+	}
+	if mode&fs.ModeSetgid != 0 {
+		bits |= 0o2000
+	}
+	if mode&fs.ModeSticky != 0 {
+		bits |= 0o1000
+	}
+	return bits
+}
+
+// fileMode converts traditional Unix permission bits to a Go file mode.
+func fileMode(bits uint32) fs.FileMode {
+	mode := fs.FileMode(bits & 0o777)
+	if bits&0o4000 != 0 {
+		mode |= fs.ModeSetuid
+	}
+	if bits&0o2000 != 0 {
+		mode |= fs.ModeSetgid
+	}
+	if bits&0o1000 != 0 {
+		mode |= fs.ModeSticky
+	}
+	return mode
+}
@@ -0,0 +1,108 @@
+package r2
+
+import (
+	"context"
+	"net/url"
+	"strings"
+
+	"github.com/baowuhe/go-cfr2/config"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// policyMiddleware checks every request against a policy before it is signed or sent, so that
+// every command, present and future, is covered without checks of its own.
+func policyMiddleware(policy *config.Policy) func(*middleware.Stack) error {
+	check := middleware.InitializeMiddlewareFunc("CheckPolicy", func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+		for _, req := range policyRequests(in.Parameters) {
+			if err := policy.Check(req); err != nil {
+				return middleware.InitializeOutput{}, middleware.Metadata{}, err
+			}
+		}
+		return next.HandleInitialize(ctx, in)
+	})
+	return func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(check, middleware.Before)
+	}
+}
+
+// policyRequests describes the object or bucket operations of an API call for policy checks.
+// Multipart parts and other calls within an operation that was already checked describe nothing.
+func policyRequests(params any) []config.PolicyRequest {
+	object := func(action string, bucket, key *string) []config.PolicyRequest {
+		return []config.PolicyRequest{{Action: action, Bucket: aws.ToString(bucket), Key: aws.ToString(key)}}
+	}
+	write := func(bucket, key, sseAlgorithm *string) []config.PolicyRequest {
+		return []config.PolicyRequest{{Action: config.PolicyActionWrite, Bucket: aws.ToString(bucket), Key: aws.ToString(key), Encrypted: sseAlgorithm != nil}}
+	}
+	bucket := func(bucket *string) []config.PolicyRequest {
+		return []config.PolicyRequest{{Action: config.PolicyActionConfigure, Bucket: aws.ToString(bucket)}}
+	}
+
+	switch p := params.(type) {
+	case *s3.GetObjectInput:
+		return object(config.PolicyActionRead, p.Bucket, p.Key)
+	case *s3.HeadObjectInput:
+		return object(config.PolicyActionRead, p.Bucket, p.Key)
+	case *s3.GetObjectTaggingInput:
+		return object(config.PolicyActionRead, p.Bucket, p.Key)
+	case *s3.ListObjectsV2Input:
+		return object(config.PolicyActionList, p.Bucket, p.Prefix)
+	case *s3.ListObjectsInput:
+		return object(config.PolicyActionList, p.Bucket, p.Prefix)
+	case *s3.ListMultipartUploadsInput:
+		return object(config.PolicyActionList, p.Bucket, p.Prefix)
+	case *s3.PutObjectInput:
+		return write(p.Bucket, p.Key, p.SSECustomerAlgorithm)
+	case *s3.CreateMultipartUploadInput:
+		return write(p.Bucket, p.Key, p.SSECustomerAlgorithm)
+	case *s3.CopyObjectInput:
+		return append(copySourceRead(p.CopySource), write(p.Bucket, p.Key, p.SSECustomerAlgorithm)...)
+	case *s3.UploadPartCopyInput:
+		// The part's own write was checked with its CreateMultipartUpload, but not its source.
+		return copySourceRead(p.CopySource)
+	case *s3.PutObjectTaggingInput:
+		return object(config.PolicyActionWrite, p.Bucket, p.Key)
+	case *s3.DeleteObjectTaggingInput:
+		return object(config.PolicyActionWrite, p.Bucket, p.Key)
+	case *s3.DeleteObjectInput:
+		return object(config.PolicyActionDelete, p.Bucket, p.Key)
+	case *s3.AbortMultipartUploadInput:
+		return object(config.PolicyActionDelete, p.Bucket, p.Key)
+	case *s3.DeleteObjectsInput:
+		if p.Delete == nil {
+			return nil
+		}
+		reqs := make([]config.PolicyRequest, 0, len(p.Delete.Objects))
+		for _, obj := range p.Delete.Objects {
+			reqs = append(reqs, object(config.PolicyActionDelete, p.Bucket, obj.Key)...)
+		}
+		return reqs
+	case *s3.CreateBucketInput:
+		return bucket(p.Bucket)
+	case *s3.DeleteBucketInput:
+		return bucket(p.Bucket)
+	case *s3.PutBucketCorsInput:
+		return bucket(p.Bucket)
+	case *s3.DeleteBucketCorsInput:
+		return bucket(p.Bucket)
+	case *s3.PutBucketLifecycleConfigurationInput:
+		return bucket(p.Bucket)
+	case *s3.DeleteBucketLifecycleInput:
+		return bucket(p.Bucket)
+	}
+	return nil
+}
+
+// copySourceRead describes reading the source of a copy, given as "bucket/key" with the key
+// URL-encoded and an optional "?versionId=" suffix.
+func copySourceRead(copySource *string) []config.PolicyRequest {
+	source, _, _ := strings.Cut(strings.TrimPrefix(aws.ToString(copySource), "/"), "?")
+	bucket, key, _ := strings.Cut(source, "/")
+	if unescaped, err := url.PathUnescape(key); err == nil {
+		key = unescaped
+	}
+	return []config.PolicyRequest{{Action: config.PolicyActionRead, Bucket: bucket, Key: key}}
+}
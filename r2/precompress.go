@@ -0,0 +1,126 @@
+package r2
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Content-Encodings of precompressed variants, stored under the original key plus the suffix in
+// precompressSuffixes.
+const (
+	PrecompressGzip   = "gzip"
+	PrecompressBrotli = "br"
+)
+
+// precompressSuffixes maps the supported encodings to the suffix of their variant keys.
+var precompressSuffixes = map[string]string{
+	PrecompressGzip:   ".gz",
+	PrecompressBrotli: ".br",
+}
+
+// compressibleTypes lists the non-text media types worth precompressing.
+var compressibleTypes = map[string]bool{
+	"application/javascript":    true,
+	"application/json":          true,
+	"application/manifest+json": true,
+	"application/wasm":          true,
+	"application/xml":           true,
+	"image/svg+xml":             true,
+}
+
+// contentTypeFor returns the media type of a key derived from its extension, or "" if unknown.
+func contentTypeFor(key string) string {
+	return mime.TypeByExtension(path.Ext(key))
+}
+
+// compressible reports whether a key holds content that compresses well, such as HTML, CSS or JavaScript.
+func compressible(key string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentTypeFor(key))
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mediaType, "text/") || compressibleTypes[mediaType]
+}
+
+// newPrecompressWriter returns a writer compressing to w with the best compression of an encoding.
+func newPrecompressWriter(w io.Writer, encoding string) io.WriteCloser {
+	if encoding == PrecompressBrotli {
+		return brotli.NewWriterLevel(w, brotli.BestCompression)
+	}
+	gz, _ := gzip.NewWriterLevel(w, gzip.BestCompression)
+	return gz
+}
+
+// isPrecompressedVariant reports whether key is a precompressed variant of a file in local.
+func isPrecompressedVariant(opts SyncOptions, key string, local map[string]localFile) bool {
+	for _, encoding := range opts.Precompress {
+		original, ok := strings.CutSuffix(key, precompressSuffixes[encoding])
+		if !ok {
+			continue
+		}
+		if _, ok := local[original]; ok && compressible(original) {
+			return true
+		}
+	}
+	return false
+}
+
+// uploadPrecompressed uploads the precompressed variants of a file next to its object, with the
+// Content-Encoding and Content-Type a server needs to hand them out by content negotiation.
+// Variants that would not be smaller than the original are skipped.
+func uploadPrecompressed(ctx context.Context, client *s3.Client, opts SyncOptions, objectKey, localFilePath string) error {
+	if !compressible(objectKey) {
+		return nil
+	}
+	file, err := os.Open(localFilePath)
+	if err != nil {
+		return fmt.Errorf("failed to open local file '%s': %w", localFilePath, err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to get file info for '%s': %w", localFilePath, err)
+	}
+
+	for _, encoding := range opts.Precompress {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		zw := newPrecompressWriter(&buf, encoding)
+		if _, err := io.Copy(zw, file); err != nil {
+			return fmt.Errorf("failed to compress '%s': %w", localFilePath, err)
+		}
+		if err := zw.Close(); err != nil {
+			return fmt.Errorf("failed to compress '%s': %w", localFilePath, err)
+		}
+		if int64(buf.Len()) >= info.Size() {
+			continue
+		}
+
+		variantKey := objectKey + precompressSuffixes[encoding]
+		_, err := client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:          &opts.Bucket,
+			Key:             &variantKey,
+			Body:            bytes.NewReader(buf.Bytes()),
+			ContentType:     aws.String(contentTypeFor(objectKey)),
+			ContentEncoding: aws.String(encoding),
+			CacheControl:    opts.cacheControl(objectKey),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to upload precompressed variant '%s' to bucket '%s': %w", variantKey, opts.Bucket, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package r2
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// MaxUploadParts is the largest number of parts a multipart upload can have.
+const MaxUploadParts = 10000
+
+// MinPartSize is the smallest size of a part of a multipart upload other than the last one.
+const MinPartSize = 5 * 1024 * 1024
+
+// MaxPartSize is the largest size of a part of a multipart upload.
+const MaxPartSize = 5 * 1024 * 1024 * 1024
+
+// PresignedMultipartUpload is a multipart upload started on behalf of a client without
+// credentials, with the presigned URLs it needs to finish it.
+type PresignedMultipartUpload struct {
+	UploadID string
+	// PartURLs holds the URL of every part, in order, uploaded with a PUT request. The client keeps
+	// the ETag header of each response for the complete request.
+	PartURLs []string
+	// CompleteURL assembles the parts with a POST request whose body lists their numbers and
+	// ETags, as a CompleteMultipartUpload XML document.
+	CompleteURL string
+	// AbortURL discards the parts uploaded so far with a DELETE request.
+	AbortURL string
+}
+
+// PresignMultipartUpload starts a multipart upload of an object and presigns the requests that
+// upload its parts and complete or abort it. contentType, when set, is the Content-Type of the
+// object; it is fixed when the upload starts, so clients send no headers of their own.
+func PresignMultipartUpload(ctx context.Context, client *s3.Client, bucketName, objectKey string, parts int, expiry time.Duration, contentType string) (*PresignedMultipartUpload, error) {
+	if parts < 1 || parts > MaxUploadParts {
+		return nil, fmt.Errorf("a multipart upload has 1 to %d parts, not %d", MaxUploadParts, parts)
+	}
+
+	input := &s3.CreateMultipartUploadInput{Bucket: &bucketName, Key: &objectKey}
+	if contentType != "" {
+		input.ContentType = &contentType
+	}
+	created, err := client.CreateMultipartUpload(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start multipart upload of '%s' in bucket '%s': %w", objectKey, bucketName, err)
+	}
+	upload := &PresignedMultipartUpload{UploadID: aws.ToString(created.UploadId)}
+	if err := presignUploadRequests(ctx, client, bucketName, objectKey, parts, expiry, upload); err != nil {
+		// Nobody could finish the upload, so its parts would only be billed.
+		_, _ = client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{Bucket: &bucketName, Key: &objectKey, UploadId: created.UploadId})
+		return nil, err
+	}
+	return upload, nil
+}
+
+// presignUploadRequests fills in the URLs of a started multipart upload.
+func presignUploadRequests(ctx context.Context, client *s3.Client, bucketName, objectKey string, parts int, expiry time.Duration, upload *PresignedMultipartUpload) error {
+	presignClient := s3.NewPresignClient(client)
+	withExpiry := presignOptions(ctx, client, bucketName, expiry)
+	for part := int32(1); part <= int32(parts); part++ {
+		result, err := presignClient.PresignUploadPart(ctx, &s3.UploadPartInput{
+			Bucket:     &bucketName,
+			Key:        &objectKey,
+			UploadId:   &upload.UploadID,
+			PartNumber: aws.Int32(part),
+		}, withExpiry)
+		if err != nil {
+			return fmt.Errorf("failed to presign part %d of '%s' in bucket '%s': %w", part, objectKey, bucketName, err)
+		}
+		upload.PartURLs = append(upload.PartURLs, result.URL)
+	}
+
+	// The SDK cannot presign completing or aborting an upload, so those requests are signed
+	// directly, against the object URL of the first part.
+	objectURL, err := url.Parse(upload.PartURLs[0])
+	if err != nil {
+		return err
+	}
+	objectURL.RawQuery = ""
+	var opts s3.PresignOptions
+	withExpiry(&opts)
+	if upload.CompleteURL, err = presignUploadRequest(ctx, client, opts, http.MethodPost, objectURL, upload.UploadID); err != nil {
+		return fmt.Errorf("failed to presign completing the upload of '%s' in bucket '%s': %w", objectKey, bucketName, err)
+	}
+	if upload.AbortURL, err = presignUploadRequest(ctx, client, opts, http.MethodDelete, objectURL, upload.UploadID); err != nil {
+		return fmt.Errorf("failed to presign aborting the upload of '%s' in bucket '%s': %w", objectKey, bucketName, err)
+	}
+	return nil
+}
+
+// presignUploadRequest presigns a request on a multipart upload with the presigner and expiry of
+// opts, the way the S3 presign client does for the requests it supports.
+func presignUploadRequest(ctx context.Context, client *s3.Client, opts s3.PresignOptions, method string, objectURL *url.URL, uploadID string) (string, error) {
+	creds, err := client.Options().Credentials.Retrieve(ctx)
+	if err != nil {
+		return "", err
+	}
+	presigner := opts.Presigner
+	if presigner == nil {
+		presigner = newSkewCorrectedPresigner(0)
+	}
+
+	target := *objectURL
+	query := url.Values{}
+	query.Set("uploadId", uploadID)
+	query.Set("X-Amz-Expires", strconv.FormatInt(int64(opts.Expires/time.Second), 10))
+	target.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	signed, _, err := presigner.PresignHTTP(ctx, creds, req, "UNSIGNED-PAYLOAD", "s3", client.Options().Region, time.Now().UTC())
+	return signed, err
+}
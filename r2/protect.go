@@ -0,0 +1,58 @@
+package r2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// MetadataProtected marks an object that delete, prune and sync must leave alone.
+const MetadataProtected = "protected"
+
+// ErrProtected is returned when an operation would delete or overwrite a protected object.
+var ErrProtected = errors.New("object is protected")
+
+// ProtectObject marks an object in the specified R2 bucket as protected.
+func ProtectObject(ctx context.Context, client *s3.Client, bucketName, objectKey string) error {
+	return updateObjectMetadata(ctx, client, bucketName, objectKey, func(metadata map[string]string) {
+		metadata[MetadataProtected] = "true"
+	})
+}
+
+// UnprotectObject removes the protection mark from an object in the specified R2 bucket.
+func UnprotectObject(ctx context.Context, client *s3.Client, bucketName, objectKey string) error {
+	return updateObjectMetadata(ctx, client, bucketName, objectKey, func(metadata map[string]string) {
+		delete(metadata, MetadataProtected)
+	})
+}
+
+// IsProtected reports whether the metadata of an object carries the protection mark.
+func IsProtected(metadata map[string]string) bool {
+	return metadata[MetadataProtected] == "true"
+}
+
+// CheckNotProtected returns an error wrapping ErrProtected if the object exists and is protected.
+// A missing object is not protected.
+func CheckNotProtected(ctx context.Context, client *s3.Client, bucketName, objectKey string) error {
+	metadata, err := GetObjectMetadata(ctx, client, bucketName, objectKey)
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if IsProtected(metadata) {
+		return fmt.Errorf("refusing to modify '%s' in bucket '%s': %w", objectKey, bucketName, ErrProtected)
+	}
+	return nil
+}
+
+// updateObjectMetadata rewrites the user-defined metadata of an object by copying it onto itself.
+// The content headers are carried over, since a metadata replacement would otherwise drop them.
+func updateObjectMetadata(ctx context.Context, client *s3.Client, bucketName, objectKey string, update func(map[string]string)) error {
+	return UpdateObjectMetadata(ctx, client, bucketName, objectKey, HeaderUpdate{}, update)
+}
@@ -0,0 +1,69 @@
+package r2
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// readerAtBlock is the least an ObjectReaderAt fetches per request, so that the small reads of
+// decompressors do not each become a request.
+const readerAtBlock = 1024 * 1024
+
+// ObjectReaderAt reads an object at arbitrary offsets with ranged requests, e.g. for archive/zip,
+// which needs the directory at the end of an archive before its entries.
+type ObjectReaderAt struct {
+	ctx        context.Context
+	client     *s3.Client
+	bucketName string
+	objectKey  string
+	size       int64
+
+	mu       sync.Mutex
+	blockOff int64
+	block    []byte
+}
+
+// NewObjectReaderAt returns an ObjectReaderAt for an object of the given size.
+func NewObjectReaderAt(ctx context.Context, client *s3.Client, bucketName, objectKey string, size int64) *ObjectReaderAt {
+	return &ObjectReaderAt{ctx: ctx, client: client, bucketName: bucketName, objectKey: objectKey, size: size}
+}
+
+// Size returns the size of the object.
+func (r *ObjectReaderAt) Size() int64 {
+	return r.size
+}
+
+// ReadAt implements io.ReaderAt. Reads within the block fetched last are served from memory.
+func (r *ObjectReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	end := min(off+int64(len(p)), r.size)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if off < r.blockOff || end > r.blockOff+int64(len(r.block)) {
+		blockEnd := min(off+max(int64(len(p)), readerAtBlock), r.size)
+		body, err := OpenObjectRange(r.ctx, r.client, r.bucketName, r.objectKey, fmt.Sprintf("bytes=%d-%d", off, blockEnd-1))
+		if err != nil {
+			return 0, err
+		}
+		block := make([]byte, blockEnd-off)
+		_, err = io.ReadFull(body, block)
+		body.Close()
+		if err != nil {
+			return 0, fmt.Errorf("failed to read object '%s': %w", r.objectKey, err)
+		}
+		r.blockOff, r.block = off, block
+	}
+
+	n := copy(p, r.block[off-r.blockOff:end-r.blockOff])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
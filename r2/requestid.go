@@ -0,0 +1,109 @@
+package r2
+
+import (
+	"context"
+	"maps"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// RequestIDMetadataKey is the user-defined metadata that objects written under a request ID carry it in.
+const RequestIDMetadataKey = "request-id"
+
+type requestIDKey struct{}
+
+// requestIDPattern matches valid request IDs: they end up in headers and metadata, so they are kept
+// short and to characters that need no escaping.
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// ValidRequestID reports whether id is usable as a request ID: 1 to 128 letters, digits, '.', '_',
+// ':' and '-'.
+func ValidRequestID(id string) bool {
+	return requestIDPattern.MatchString(id)
+}
+
+// WithRequestID returns a context whose requests are tagged with a correlation ID, replacing the
+// ID of the configuration, e.g. for a server handling requests of upstream systems.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the correlation ID set with WithRequestID, if any.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestIDMiddleware tags every request with a correlation ID, taken from the context or else
+// defaultID: it is sent as X-Request-ID and in the User-Agent, and stored as metadata on the
+// objects written, so that operations can be traced back to whatever triggered them. Presigned
+// requests only get the User-Agent suffix: a header added before signing would be part of the
+// signed headers, and every client using the URL would have to send it.
+func requestIDMiddleware(defaultID string) func(*middleware.Stack) error {
+	requestID := func(ctx context.Context) string {
+		if id := RequestIDFromContext(ctx); id != "" {
+			return id
+		}
+		return defaultID
+	}
+	tag := middleware.InitializeMiddlewareFunc("RequestIDMetadata", func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+		if id := requestID(ctx); id != "" {
+			switch params := in.Parameters.(type) {
+			case *s3.PutObjectInput:
+				params.Metadata = withRequestIDMetadata(params.Metadata, id)
+			case *s3.CreateMultipartUploadInput:
+				params.Metadata = withRequestIDMetadata(params.Metadata, id)
+			}
+		}
+		return next.HandleInitialize(ctx, in)
+	})
+	header := middleware.BuildMiddlewareFunc("RequestIDHeader", func(ctx context.Context, in middleware.BuildInput, next middleware.BuildHandler) (middleware.BuildOutput, middleware.Metadata, error) {
+		if req, ok := in.Request.(*smithyhttp.Request); ok {
+			if id := requestID(ctx); id != "" {
+				req.Header.Set("X-Request-ID", id)
+				req.Header.Set("User-Agent", strings.TrimSpace(req.Header.Get("User-Agent")+" request-id/"+id))
+			}
+		}
+		return next.HandleBuild(ctx, in)
+	})
+	userAgent := middleware.BuildMiddlewareFunc("RequestIDHeader", func(ctx context.Context, in middleware.BuildInput, next middleware.BuildHandler) (middleware.BuildOutput, middleware.Metadata, error) {
+		if req, ok := in.Request.(*smithyhttp.Request); ok {
+			if id := requestID(ctx); id != "" {
+				req.Header.Set("User-Agent", strings.TrimSpace(req.Header.Get("User-Agent")+" request-id/"+id))
+			}
+		}
+		return next.HandleBuild(ctx, in)
+	})
+	return func(stack *middleware.Stack) error {
+		// The presign client adds its middleware before the API options of the client run.
+		if _, presigning := stack.Build.Get(presignExpiresMiddlewareID); presigning {
+			return stack.Build.Add(userAgent, middleware.After)
+		}
+		if err := stack.Initialize.Add(tag, middleware.After); err != nil {
+			return err
+		}
+		return stack.Build.Add(header, middleware.After)
+	}
+}
+
+// presignExpiresMiddlewareID is the ID of the middleware the S3 presign client adds to set the
+// expiry of a URL, by which presigning stacks are recognized.
+const presignExpiresMiddlewareID = "S3:AddExpiresOnPresignedURL"
+
+// withRequestIDMetadata returns a copy of metadata with the request ID added, keeping an ID
+// already present, e.g. on an object copied with its metadata.
+func withRequestIDMetadata(metadata map[string]string, id string) map[string]string {
+	if _, ok := metadata[RequestIDMetadataKey]; ok {
+		return metadata
+	}
+	tagged := maps.Clone(metadata)
+	if tagged == nil {
+		tagged = map[string]string{}
+	}
+	tagged[RequestIDMetadataKey] = id
+	return tagged
+}
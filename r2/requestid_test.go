@@ -0,0 +1,60 @@
+package r2
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go/middleware"
+)
+
+func TestValidRequestID(t *testing.T) {
+	tests := []struct {
+		id   string
+		want bool
+	}{
+		{"deploy-1234", true},
+		{"ci:run.42_a", true},
+		{"", false},
+		{"has space", false},
+		{"line\nbreak", false},
+		{string(make([]byte, 129)), false},
+	}
+	for _, tt := range tests {
+		if got := ValidRequestID(tt.id); got != tt.want {
+			t.Errorf("ValidRequestID(%q) = %v, want %v", tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestRequestIDMiddlewareKeepsPresignedHeadersUnsigned(t *testing.T) {
+	client := s3.New(s3.Options{
+		Region:       "auto",
+		BaseEndpoint: aws.String("https://account.r2.cloudflarestorage.com"),
+		Credentials:  credentials.NewStaticCredentialsProvider("key", "secret", ""),
+		APIOptions:   []func(*middleware.Stack) error{requestIDMiddleware("ci-run-7")},
+	})
+	presigner := s3.NewPresignClient(client)
+	ctx := context.Background()
+
+	get, err := presigner.PresignGetObject(ctx, &s3.GetObjectInput{Bucket: aws.String("b"), Key: aws.String("k")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	put, err := presigner.PresignPutObject(ctx, &s3.PutObjectInput{Bucket: aws.String("b"), Key: aws.String("k")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, req := range []string{get.URL, put.URL} {
+		u, err := url.Parse(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if signed := u.Query().Get("X-Amz-SignedHeaders"); signed != "host" {
+			t.Errorf("presigned URL %s signs headers %q, want only host", req, signed)
+		}
+	}
+}
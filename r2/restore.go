@@ -0,0 +1,179 @@
+package r2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// RestoreClass is one priority class of a restore plan.
+type RestoreClass struct {
+	Name string `toml:"name"`
+	// Patterns are path.Match globs matched against the key relative to the restore prefix.
+	// A pattern ending in "/" matches every key below that directory. See
+	// RestoreOptions.MatchFileNames for patterns without a "/".
+	Patterns []string `toml:"patterns"`
+	// Concurrency is the number of parallel downloads for this class. Zero uses the default.
+	Concurrency int `toml:"concurrency"`
+}
+
+// RestoreOptions configures a recursive download of a bucket prefix into a local directory.
+type RestoreOptions struct {
+	LocalDir string
+	Bucket   string
+	Prefix   string
+	// Classes are restored one after another, in order. Each object belongs to the first class with
+	// a matching pattern; objects matching none are restored last.
+	Classes []RestoreClass
+	// Concurrency is the number of parallel downloads for classes that do not set their own,
+	// and for the objects matching no class.
+	Concurrency int
+	// MatchFileNames matches class patterns without a "/" against the file name at any depth, as
+	// sync exclude patterns are, instead of against the whole relative key.
+	MatchFileNames bool
+	// PreservePerms restores the mode, ownership and extended attributes recorded at upload.
+	PreservePerms bool
+	DryRun        bool
+}
+
+// RestoreResult summarises a restore.
+type RestoreResult struct {
+	Downloaded int
+	Failed     int
+}
+
+// Restore downloads every object below the prefix into the local directory, class by class, so that
+// the most important data is available first.
+func Restore(ctx context.Context, client *s3.Client, opts RestoreOptions) (RestoreResult, error) {
+	log := logger(client)
+	var result RestoreResult
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 1
+	}
+
+	objects, err := ListObjectsWithPrefix(ctx, client, opts.Bucket, opts.Prefix)
+	if err != nil {
+		return result, err
+	}
+
+	classes := append(append([]RestoreClass(nil), opts.Classes...), RestoreClass{Name: "other"})
+	keys := make([][]string, len(classes))
+	for _, obj := range objects {
+		if IsDirectoryMarker(obj) {
+			continue
+		}
+		i := classifyRestoreKey(strings.TrimPrefix(*obj.Key, opts.Prefix), opts.Classes, opts.MatchFileNames)
+		keys[i] = append(keys[i], *obj.Key)
+	}
+
+	syncOpts := SyncOptions{
+		LocalDir:      opts.LocalDir,
+		Bucket:        opts.Bucket,
+		Prefix:        opts.Prefix,
+		PreservePerms: opts.PreservePerms,
+	}
+	var errs []error
+	for i, class := range classes {
+		if len(keys[i]) == 0 {
+			continue
+		}
+		concurrency := class.Concurrency
+		if concurrency <= 0 {
+			concurrency = opts.Concurrency
+		}
+		log.Printf("Restoring class '%s': %d object(s), %d at a time...\n", class.Name, len(keys[i]), concurrency)
+		if opts.DryRun {
+			for _, key := range keys[i] {
+				log.Printf("(dry run) download '%s'\n", key)
+			}
+			continue
+		}
+		warmForBatch(ctx, client, opts.Bucket, concurrency, len(keys[i]))
+		if err := restoreClass(ctx, client, syncOpts, keys[i], concurrency, &result); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return result, errors.Join(errs...)
+}
+
+// classifyRestoreKey returns the index of the first class matching a relative key,
+// or len(classes) if none does.
+func classifyRestoreKey(rel string, classes []RestoreClass, matchFileNames bool) int {
+	for i, class := range classes {
+		for _, pattern := range class.Patterns {
+			if matchFileNames {
+				if matchKeyPattern(pattern, rel) {
+					return i
+				}
+				continue
+			}
+			if strings.HasSuffix(pattern, "/") {
+				if strings.HasPrefix(rel, pattern) {
+					return i
+				}
+				continue
+			}
+			if ok, _ := path.Match(pattern, rel); ok {
+				return i
+			}
+		}
+	}
+	return len(classes)
+}
+
+// restoreClass downloads the keys of one class with a bounded worker pool. Failures are reported
+// and counted but do not stop the remaining downloads.
+func restoreClass(ctx context.Context, client *s3.Client, opts SyncOptions, keys []string, concurrency int, result *RestoreResult) error {
+	log := logger(client)
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		errs []error
+	)
+	queue := make(chan string)
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range queue {
+				err := restoreObject(ctx, client, opts, key)
+
+				mu.Lock()
+				if err != nil {
+					result.Failed++
+					errs = append(errs, err)
+					log.Printf("Failed: %v\n", err)
+				} else {
+					result.Downloaded++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, key := range keys {
+		queue <- key
+	}
+	close(queue)
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+func restoreObject(ctx context.Context, client *s3.Client, opts SyncOptions, key string) error {
+	log := logger(client)
+	localPath, ok := localPathForKey(opts.LocalDir, opts.Prefix, key)
+	if !ok {
+		return fmt.Errorf("refusing to restore '%s': key does not map to a path inside '%s'", key, opts.LocalDir)
+	}
+	log.Printf("Downloading '%s' to '%s'...\n", key, localPath)
+	_, err := downloadToFile(ctx, client, opts, key, localPath)
+	return err
+}
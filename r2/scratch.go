@@ -0,0 +1,213 @@
+package r2
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// scratchNamePrefix starts the name of every scratch bucket, and of every scratch prefix.
+const scratchNamePrefix = "cfr2-scratch-"
+
+// scratchMarkerName is the object at the root of a scratch space recording when it expires, so
+// that any machine can clean it up, not just the one that created it.
+const scratchMarkerName = ".cfr2-scratch.json"
+
+// ScratchSpace is a throwaway bucket, or a prefix in an existing bucket, that is removed once it
+// expires.
+type ScratchSpace struct {
+	Bucket string `json:"bucket"`
+	// Prefix is empty when the whole bucket was created for scratch use.
+	Prefix  string    `json:"prefix,omitempty"`
+	Created time.Time `json:"created"`
+	// Expires is zero if the space has no readable marker, e.g. because creating it was interrupted.
+	Expires time.Time `json:"expires"`
+}
+
+// String returns the bucket, or the bucket and prefix, of the scratch space.
+func (s ScratchSpace) String() string {
+	if s.Prefix == "" {
+		return s.Bucket
+	}
+	return s.Bucket + "/" + s.Prefix
+}
+
+func (s ScratchSpace) markerKey() string {
+	return s.Prefix + scratchMarkerName
+}
+
+// CreateScratchSpace creates a uniquely named scratch space that expires after ttl, and records
+// its expiry in a marker object inside it. With an empty bucketName a new bucket is created;
+// otherwise the space is a new prefix in that bucket.
+func CreateScratchSpace(ctx context.Context, client *s3.Client, bucketName string, ttl time.Duration) (ScratchSpace, error) {
+	name, err := newScratchName()
+	if err != nil {
+		return ScratchSpace{}, err
+	}
+	now := time.Now().UTC()
+	space := ScratchSpace{Bucket: bucketName, Created: now, Expires: now.Add(ttl)}
+	if bucketName == "" {
+		space.Bucket = name
+		if _, err := client.CreateBucket(ctx, &s3.CreateBucketInput{Bucket: &space.Bucket}); err != nil {
+			return ScratchSpace{}, fmt.Errorf("failed to create bucket '%s': %w", space.Bucket, err)
+		}
+	} else {
+		space.Prefix = name + "/"
+	}
+
+	data, err := json.Marshal(space)
+	if err != nil {
+		return ScratchSpace{}, err
+	}
+	_, err = client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      &space.Bucket,
+		Key:         aws.String(space.markerKey()),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/json"),
+	})
+	if err != nil {
+		if space.Prefix == "" {
+			client.DeleteBucket(ctx, &s3.DeleteBucketInput{Bucket: &space.Bucket})
+		}
+		return ScratchSpace{}, fmt.Errorf("failed to record the expiry of scratch space '%s': %w", space, err)
+	}
+	return space, nil
+}
+
+// ListScratchSpaces returns the scratch buckets of the account and the scratch prefixes of the
+// given buckets, with the expiry read from their markers.
+func ListScratchSpaces(ctx context.Context, client *s3.Client, buckets []string) ([]ScratchSpace, error) {
+	var spaces []ScratchSpace
+	input := &s3.ListBucketsInput{Prefix: aws.String(scratchNamePrefix)}
+	for {
+		resp, err := client.ListBuckets(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list buckets: %w", err)
+		}
+		for _, bucket := range resp.Buckets {
+			// The prefix is only a hint to the server.
+			if !strings.HasPrefix(aws.ToString(bucket.Name), scratchNamePrefix) {
+				continue
+			}
+			space, err := readScratchSpace(ctx, client, aws.ToString(bucket.Name), "")
+			if err != nil {
+				return nil, err
+			}
+			if space.Created.IsZero() {
+				space.Created = aws.ToTime(bucket.CreationDate)
+			}
+			spaces = append(spaces, space)
+		}
+		if aws.ToString(resp.ContinuationToken) == "" {
+			break
+		}
+		input.ContinuationToken = resp.ContinuationToken
+	}
+
+	for _, bucketName := range buckets {
+		listInput := &s3.ListObjectsV2Input{
+			Bucket:    aws.String(bucketName),
+			Prefix:    aws.String(scratchNamePrefix),
+			Delimiter: aws.String("/"),
+		}
+		paginator := s3.NewListObjectsV2Paginator(client, listInput)
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to list scratch prefixes in bucket '%s': %w", bucketName, err)
+			}
+			for _, prefix := range page.CommonPrefixes {
+				space, err := readScratchSpace(ctx, client, bucketName, aws.ToString(prefix.Prefix))
+				if err != nil {
+					return nil, err
+				}
+				spaces = append(spaces, space)
+			}
+		}
+	}
+	return spaces, nil
+}
+
+// readScratchSpace reads the marker of the scratch space at bucketName and prefix. A space without
+// a marker is returned with zero times.
+func readScratchSpace(ctx context.Context, client *s3.Client, bucketName, prefix string) (ScratchSpace, error) {
+	space := ScratchSpace{Bucket: bucketName, Prefix: prefix}
+	resp, err := client.GetObject(ctx, &s3.GetObjectInput{Bucket: &bucketName, Key: aws.String(space.markerKey())})
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return space, nil
+	}
+	if err != nil {
+		return space, fmt.Errorf("failed to read the marker of scratch space '%s': %w", space, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return space, fmt.Errorf("failed to read the marker of scratch space '%s': %w", space, err)
+	}
+	var marker ScratchSpace
+	if err := json.Unmarshal(data, &marker); err != nil {
+		return space, fmt.Errorf("failed to parse the marker of scratch space '%s': %w", space, err)
+	}
+	space.Created, space.Expires = marker.Created, marker.Expires
+	return space, nil
+}
+
+// RemoveScratchSpace deletes the objects and multipart uploads of a scratch space, then its marker,
+// and then the bucket itself if it was created for it. The marker goes last, so a removal that
+// fails is retried by the next cleanup. A bucket that no longer exists counts as removed.
+func RemoveScratchSpace(ctx context.Context, client *s3.Client, space ScratchSpace) error {
+	marker := space.markerKey()
+	result, err := EmptyBucket(ctx, client, EmptyBucketOptions{
+		Bucket:             space.Bucket,
+		Prefix:             space.Prefix,
+		Keep:               func(key string) bool { return key == marker },
+		OverrideProtection: true,
+	})
+	if isNoSuchBucket(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if result.Failed > 0 {
+		return fmt.Errorf("failed to delete %d object(s) in scratch space '%s'", result.Failed, space)
+	}
+
+	if _, err := client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: &space.Bucket, Key: &marker}); err != nil {
+		return fmt.Errorf("failed to delete the marker of scratch space '%s': %w", space, err)
+	}
+	if space.Prefix == "" {
+		if _, err := client.DeleteBucket(ctx, &s3.DeleteBucketInput{Bucket: &space.Bucket}); err != nil && !isNoSuchBucket(err) {
+			return fmt.Errorf("failed to delete bucket '%s': %w", space.Bucket, err)
+		}
+	}
+	return nil
+}
+
+// newScratchName returns a name, valid as a bucket name, that carries the creation date and a
+// random suffix so concurrent CI jobs never collide.
+func newScratchName() (string, error) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", fmt.Errorf("failed to generate scratch name: %w", err)
+	}
+	return scratchNamePrefix + time.Now().UTC().Format("20060102") + "-" + hex.EncodeToString(suffix), nil
+}
+
+func isNoSuchBucket(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchBucket"
+}
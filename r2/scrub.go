@@ -0,0 +1,196 @@
+package r2
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math/rand"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// md5ETagPattern matches the ETag of an object uploaded in a single part, which is the MD5 digest
+// of its content. Multipart ETags carry a "-<parts>" suffix and cannot be checked this way.
+var md5ETagPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// IsMD5ETag reports whether an unquoted ETag is the hex MD5 digest of the object's content.
+func IsMD5ETag(etag string) bool {
+	return md5ETagPattern.MatchString(etag)
+}
+
+// ScrubOptions configures an integrity scrub.
+type ScrubOptions struct {
+	Bucket string
+	Prefix string
+	// Sample is the share of objects, between 0 and 1, that are checked. Objects are picked at
+	// random; 1 checks every object.
+	Sample float64
+	// Concurrency is the number of objects downloaded at the same time.
+	Concurrency int
+}
+
+// ScrubFailure is an object that failed a scrub.
+type ScrubFailure struct {
+	Key     string
+	Message string
+}
+
+// ScrubResult summarizes a scrub.
+type ScrubResult struct {
+	// Checked counts the objects that were downloaded.
+	Checked int
+	// Verified counts the objects whose content matched their checksum.
+	Verified int
+	// Unverifiable counts the objects that were read in full but carry no usable checksum.
+	Unverifiable int
+	// Skipped counts the hard link stubs, which have no content of their own.
+	Skipped int
+	// Corrupt lists the objects whose content did not match their checksum.
+	Corrupt []ScrubFailure
+	// Failed lists the objects that could not be read.
+	Failed []ScrubFailure
+}
+
+// Scrub downloads a sample of the objects below a prefix and checks their content against the
+// SHA-256 digest recorded in their metadata at upload or, failing that, an MD5 ETag. Hard link
+// stubs are skipped, since their target is checked on its own. Corrupt and unreadable objects are
+// reported in the result; the returned error is only set if the listing fails.
+func Scrub(ctx context.Context, client *s3.Client, opts ScrubOptions) (ScrubResult, error) {
+	log := logger(client)
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 1
+	}
+
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		result ScrubResult
+	)
+	queue := make(chan string)
+	for i := 0; i < opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range queue {
+				outcome, err := scrubObject(ctx, client, opts.Bucket, key)
+
+				mu.Lock()
+				var corrupt *scrubMismatchError
+				switch {
+				case errors.As(err, &corrupt):
+					result.Checked++
+					result.Corrupt = append(result.Corrupt, ScrubFailure{Key: key, Message: err.Error()})
+					log.Printf("Corrupt: '%s': %v\n", key, err)
+				case err != nil:
+					result.Failed = append(result.Failed, ScrubFailure{Key: key, Message: err.Error()})
+					log.Printf("Failed: %v\n", err)
+				case outcome == scrubSkipped:
+					result.Skipped++
+				case outcome == scrubVerified:
+					result.Checked++
+					result.Verified++
+				default:
+					result.Checked++
+					result.Unverifiable++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	sampler := rand.New(rand.NewSource(rand.Int63()))
+	err := WalkObjects(ctx, client, opts.Bucket, opts.Prefix, func(obj types.Object) error {
+		if opts.Sample < 1 && sampler.Float64() >= opts.Sample {
+			return nil
+		}
+		queue <- *obj.Key
+		return nil
+	})
+	close(queue)
+	wg.Wait()
+
+	return result, err
+}
+
+// scrubOutcome is the result of checking one readable object.
+type scrubOutcome int
+
+const (
+	scrubVerified scrubOutcome = iota
+	scrubUnverifiable
+	scrubSkipped
+)
+
+// scrubMismatchError reports content that does not match its checksum.
+type scrubMismatchError struct {
+	algorithm string
+	expected  string
+	actual    string
+}
+
+func (e *scrubMismatchError) Error() string {
+	return fmt.Sprintf("%s mismatch: expected %s, got %s", e.algorithm, e.expected, e.actual)
+}
+
+// scrubObject reads an object in full and checks its content.
+func scrubObject(ctx context.Context, client *s3.Client, bucketName, objectKey string) (scrubOutcome, error) {
+	resp, err := client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: &bucketName,
+		Key:    &objectKey,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to get object '%s' from bucket '%s': %w", objectKey, bucketName, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.Metadata[MetadataHardlink] != "" {
+		// The stub is empty; drain it so the connection can be reused.
+		_, err := io.Copy(io.Discard, resp.Body)
+		return scrubSkipped, err
+	}
+
+	var (
+		h         hash.Hash
+		expected  string
+		algorithm string
+	)
+	etag := ""
+	if resp.ETag != nil {
+		etag = strings.Trim(*resp.ETag, `"`)
+	}
+	switch {
+	case resp.Metadata[MetadataSHA256] != "":
+		h, expected, algorithm = sha256.New(), resp.Metadata[MetadataSHA256], "SHA-256"
+	case md5ETagPattern.MatchString(etag) && resp.Metadata[MetadataSparse] == "":
+		h, expected, algorithm = md5.New(), etag, "MD5"
+	default:
+		if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+			return 0, fmt.Errorf("failed to read object '%s': %w", objectKey, err)
+		}
+		return scrubUnverifiable, nil
+	}
+
+	if sparseMap := resp.Metadata[MetadataSparse]; sparseMap != "" && algorithm == "SHA-256" {
+		// The recorded digest covers the file with its holes, not the compacted content.
+		err = hashSparse(h, resp.Body, sparseMap)
+	} else {
+		_, err = io.Copy(h, resp.Body)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read object '%s': %w", objectKey, err)
+	}
+
+	if actual := hex.EncodeToString(h.Sum(nil)); actual != expected {
+		return 0, &scrubMismatchError{algorithm: algorithm, expected: expected, actual: actual}
+	}
+	return scrubVerified, nil
+}
@@ -0,0 +1,140 @@
+package r2
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+)
+
+// redactedHeaders lists request headers whose values never end up in a session capture.
+var redactedHeaders = map[string]bool{
+	"Authorization":        true,
+	"X-Amz-Security-Token": true,
+	"Cookie":               true,
+	// SSE-C keys, and their digests, of the object and of a copy source.
+	"X-Amz-Server-Side-Encryption-Customer-Key":                 true,
+	"X-Amz-Server-Side-Encryption-Customer-Key-Md5":             true,
+	"X-Amz-Copy-Source-Server-Side-Encryption-Customer-Key":     true,
+	"X-Amz-Copy-Source-Server-Side-Encryption-Customer-Key-Md5": true,
+}
+
+// redactedQueryParams lists query parameters of presigned requests whose values are redacted.
+var redactedQueryParams = []string{"X-Amz-Signature", "X-Amz-Credential", "X-Amz-Security-Token"}
+
+// SessionRequest is one HTTP request recorded in a session capture. Retries and multipart parts
+// are recorded as separate requests.
+type SessionRequest struct {
+	Time            time.Time           `json:"time"`
+	Operation       string              `json:"operation,omitempty"`
+	Method          string              `json:"method"`
+	URL             string              `json:"url"`
+	RequestHeaders  map[string][]string `json:"request_headers"`
+	RequestBytes    int64               `json:"request_bytes"`
+	Status          int                 `json:"status,omitempty"`
+	ResponseHeaders map[string][]string `json:"response_headers,omitempty"`
+	DurationMS      int64               `json:"duration_ms"`
+	Error           string              `json:"error,omitempty"`
+}
+
+// SessionCapture records every request of a run, with credentials redacted, for attaching to bug
+// reports. It is safe for concurrent use.
+type SessionCapture struct {
+	mu         sync.Mutex
+	Command    []string         `json:"command"`
+	Started    time.Time        `json:"started"`
+	Finished   time.Time        `json:"finished"`
+	DurationMS int64            `json:"duration_ms"`
+	Error      string           `json:"error,omitempty"`
+	Requests   []SessionRequest `json:"requests"`
+}
+
+// NewSessionCapture starts recording a run of the given command line.
+func NewSessionCapture(args []string) *SessionCapture {
+	return &SessionCapture{Command: args, Started: time.Now(), Requests: []SessionRequest{}}
+}
+
+// Save finishes the capture with the run's error message, empty on success, and writes it to path.
+func (s *SessionCapture) Save(path, errMsg string) error {
+	s.mu.Lock()
+	s.Finished = time.Now()
+	s.DurationMS = s.Finished.Sub(s.Started).Milliseconds()
+	s.Error = errMsg
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false) // Keep URLs readable.
+	encoder.SetIndent("", "  ")
+	err := encoder.Encode(s)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o600); err != nil {
+		return fmt.Errorf("failed to write session capture '%s': %w", path, err)
+	}
+	return nil
+}
+
+func (s *SessionCapture) record(req SessionRequest) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Requests = append(s.Requests, req)
+}
+
+// capturingHTTPClient records every request it sends in a session capture.
+type capturingHTTPClient struct {
+	client  aws.HTTPClient
+	session *SessionCapture
+}
+
+func (c *capturingHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	entry := SessionRequest{
+		Time:           time.Now(),
+		Operation:      awsmiddleware.GetOperationName(req.Context()),
+		Method:         req.Method,
+		URL:            redactURL(req.URL),
+		RequestHeaders: redactHeaders(req.Header),
+		RequestBytes:   req.ContentLength,
+	}
+	resp, err := c.client.Do(req)
+	entry.DurationMS = time.Since(entry.Time).Milliseconds()
+	if err != nil {
+		entry.Error = err.Error()
+	} else {
+		entry.Status = resp.StatusCode
+		entry.ResponseHeaders = redactHeaders(resp.Header)
+	}
+	c.session.record(entry)
+	return resp, err
+}
+
+func redactHeaders(header http.Header) map[string][]string {
+	redacted := make(map[string][]string, len(header))
+	for name, values := range header {
+		if redactedHeaders[http.CanonicalHeaderKey(name)] {
+			redacted[name] = []string{"REDACTED"}
+			continue
+		}
+		redacted[name] = values
+	}
+	return redacted
+}
+
+func redactURL(u *url.URL) string {
+	redacted := *u
+	query := redacted.Query()
+	for _, param := range redactedQueryParams {
+		if query.Has(param) {
+			query.Set(param, "REDACTED")
+		}
+	}
+	redacted.RawQuery = query.Encode()
+	return redacted.String()
+}
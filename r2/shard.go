@@ -0,0 +1,167 @@
+package r2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// DefaultShardBoundaries splits the keyspace below a prefix at every digit and ASCII letter,
+// which spreads hashed, numbered and most human-named keys over many shards.
+var DefaultShardBoundaries = func() []string {
+	var boundaries []string
+	for _, r := range "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz" {
+		boundaries = append(boundaries, string(r))
+	}
+	return boundaries
+}()
+
+// ShardOptions configures how a listing is split into shards that are listed concurrently.
+type ShardOptions struct {
+	// Prefixes lists only the keys below each of these prefixes, relative to the listing prefix.
+	// Keys outside all of them are not listed, and overlapping prefixes list the same key twice.
+	Prefixes []string
+	// Boundaries splits the whole keyspace below the listing prefix into consecutive key ranges at
+	// these key suffixes. DefaultShardBoundaries is used when neither Prefixes nor Boundaries is set.
+	Boundaries []string
+	// Concurrency is the number of shards listed at the same time.
+	Concurrency int
+}
+
+// listShard is one part of a sharded listing: the keys below prefix that sort after startAfter
+// and, if end is non-empty, not after end.
+type listShard struct {
+	prefix     string
+	startAfter string
+	end        string
+}
+
+// ListObjectsSharded lists the objects below prefix by listing several shards of the keyspace
+// concurrently. The result is sorted by key.
+func ListObjectsSharded(ctx context.Context, client *s3.Client, bucketName, prefix string, opts ShardOptions) ([]types.Object, error) {
+	var allObjects []types.Object
+	err := WalkObjectsSharded(ctx, client, bucketName, prefix, opts, func(obj types.Object) error {
+		allObjects = append(allObjects, obj)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(allObjects, func(i, j int) bool { return *allObjects[i].Key < *allObjects[j].Key })
+	return allObjects, nil
+}
+
+// WalkObjectsSharded is WalkObjects with the listing split into shards that are listed concurrently.
+// fn is never called concurrently, but objects arrive in no particular order.
+func WalkObjectsSharded(ctx context.Context, client *s3.Client, bucketName, prefix string, opts ShardOptions, fn func(types.Object) error) error {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		errs []error
+	)
+	queue := make(chan listShard)
+	for i := 0; i < opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for shard := range queue {
+				if ctx.Err() != nil {
+					// Another shard already failed.
+					continue
+				}
+				err := walkObjectRange(ctx, client, bucketName, shard, func(obj types.Object) error {
+					mu.Lock()
+					defer mu.Unlock()
+					return fn(obj)
+				})
+				if err != nil {
+					mu.Lock()
+					errs = append(errs, err)
+					mu.Unlock()
+					cancel()
+				}
+			}
+		}()
+	}
+
+	for _, shard := range planShards(prefix, opts) {
+		queue <- shard
+	}
+	close(queue)
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// planShards turns the shard options into the list of shards to walk.
+func planShards(prefix string, opts ShardOptions) []listShard {
+	if len(opts.Prefixes) > 0 {
+		shards := make([]listShard, 0, len(opts.Prefixes))
+		for _, p := range opts.Prefixes {
+			shards = append(shards, listShard{prefix: prefix + p})
+		}
+		return shards
+	}
+
+	boundaries := opts.Boundaries
+	if len(boundaries) == 0 {
+		boundaries = DefaultShardBoundaries
+	}
+	boundaries = append([]string(nil), boundaries...)
+	sort.Strings(boundaries)
+
+	// Each shard covers the keys after the previous boundary up to and including the next one,
+	// since StartAfter is exclusive.
+	shards := make([]listShard, 0, len(boundaries)+1)
+	previous := ""
+	for _, boundary := range boundaries {
+		shards = append(shards, listShard{prefix: prefix, startAfter: previous, end: prefix + boundary})
+		previous = prefix + boundary
+	}
+	return append(shards, listShard{prefix: prefix, startAfter: previous})
+}
+
+// walkObjectRange calls fn for every object in a shard, one listing page at a time.
+func walkObjectRange(ctx context.Context, client *s3.Client, bucketName string, shard listShard, fn func(types.Object) error) error {
+	input := &s3.ListObjectsV2Input{
+		Bucket: &bucketName,
+	}
+	if shard.prefix != "" {
+		input.Prefix = &shard.prefix
+	}
+	if shard.startAfter != "" {
+		input.StartAfter = &shard.startAfter
+	}
+
+	paginator := s3.NewListObjectsV2Paginator(client, input)
+
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list objects after '%s': %w", shard.startAfter, err)
+		}
+		for _, obj := range output.Contents {
+			if shard.end != "" && *obj.Key > shard.end {
+				return nil
+			}
+			if err := fn(obj); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
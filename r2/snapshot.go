@@ -0,0 +1,253 @@
+package r2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// ErrTaggingUnsupported is returned when the bucket's endpoint does not implement object tagging.
+var ErrTaggingUnsupported = errors.New("object tagging is not supported")
+
+// ObjectSnapshot is the configuration of one object that a copy to another bucket or account can
+// lose: its user-defined metadata, content headers and tags.
+type ObjectSnapshot struct {
+	Key                string            `json:"key"`
+	Size               int64             `json:"size"`
+	ETag               string            `json:"etag"`
+	LastModified       time.Time         `json:"last_modified"`
+	Metadata           map[string]string `json:"metadata,omitempty"`
+	ContentType        string            `json:"content_type,omitempty"`
+	CacheControl       string            `json:"cache_control,omitempty"`
+	ContentDisposition string            `json:"content_disposition,omitempty"`
+	ContentEncoding    string            `json:"content_encoding,omitempty"`
+	ContentLanguage    string            `json:"content_language,omitempty"`
+	// Tags is empty for an object without tags, and nil if its tags were not captured.
+	Tags map[string]string `json:"tags"`
+}
+
+// SnapshotObject captures the metadata of an object, and its tags if withTags is set. It returns
+// an error wrapping ErrTaggingUnsupported, along with the snapshot without tags, if the endpoint
+// does not implement tagging.
+func SnapshotObject(ctx context.Context, client *s3.Client, bucketName, objectKey string, withTags bool) (ObjectSnapshot, error) {
+	head, err := client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: &bucketName,
+		Key:    &objectKey,
+	})
+	if err != nil {
+		return ObjectSnapshot{}, fmt.Errorf("failed to get metadata of object '%s' in bucket '%s': %w", objectKey, bucketName, err)
+	}
+
+	snapshot := ObjectSnapshot{
+		Key:                objectKey,
+		Size:               aws.ToInt64(head.ContentLength),
+		ETag:               strings.Trim(aws.ToString(head.ETag), `"`),
+		LastModified:       aws.ToTime(head.LastModified).UTC(),
+		Metadata:           head.Metadata,
+		ContentType:        aws.ToString(head.ContentType),
+		CacheControl:       aws.ToString(head.CacheControl),
+		ContentDisposition: aws.ToString(head.ContentDisposition),
+		ContentEncoding:    aws.ToString(head.ContentEncoding),
+		ContentLanguage:    aws.ToString(head.ContentLanguage),
+	}
+	if !withTags {
+		return snapshot, nil
+	}
+
+	tags, err := getObjectTags(ctx, client, bucketName, objectKey)
+	snapshot.Tags = tags
+	return snapshot, err
+}
+
+// ErrObjectChanged is returned by RestoreObjectMetadataWithOptions with RequireETag set if the
+// content of the object changed since the snapshot was taken, and whenever it changes between
+// reading the current metadata and copying the object onto itself.
+var ErrObjectChanged = errors.New("object content changed since the snapshot")
+
+// MetadataRestoreOptions holds optional settings for RestoreObjectMetadataWithOptions.
+type MetadataRestoreOptions struct {
+	// DryRun reports the changes without making them.
+	DryRun bool
+	// RequireETag refuses to touch objects whose ETag differs from the snapshot's, as their
+	// content was replaced since.
+	RequireETag bool
+}
+
+// RestoreObjectMetadata reapplies the metadata and tags of a snapshot to the object with the same
+// key, by copying the object onto itself. It returns false, and changes nothing, if the object
+// already carries them.
+func RestoreObjectMetadata(ctx context.Context, client *s3.Client, bucketName string, snapshot ObjectSnapshot) (bool, error) {
+	changes, err := RestoreObjectMetadataWithOptions(ctx, client, bucketName, snapshot, MetadataRestoreOptions{})
+	return len(changes) > 0, err
+}
+
+// RestoreObjectMetadataWithOptions is RestoreObjectMetadata with custom options. It returns a
+// description of every change, made or, with DryRun, to be made.
+func RestoreObjectMetadataWithOptions(ctx context.Context, client *s3.Client, bucketName string, snapshot ObjectSnapshot, opts MetadataRestoreOptions) ([]string, error) {
+	current, err := SnapshotObject(ctx, client, bucketName, snapshot.Key, snapshot.Tags != nil)
+	if errors.Is(err, ErrTaggingUnsupported) && len(snapshot.Tags) == 0 {
+		// Without tagging the object has no tags either, as the snapshot wants.
+		current.Tags, err = snapshot.Tags, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if opts.RequireETag && snapshot.ETag != "" && current.ETag != snapshot.ETag {
+		return nil, fmt.Errorf("object '%s' in bucket '%s': %w", snapshot.Key, bucketName, ErrObjectChanged)
+	}
+
+	var changes []string
+	etag := current.ETag
+	if !sameObjectMetadata(current, snapshot) {
+		changes = describeMetadataChanges(current, snapshot)
+		if !opts.DryRun {
+			// The copy must not bring back older content replaced since the HEAD request.
+			resp, err := client.CopyObject(ctx, &s3.CopyObjectInput{
+				Bucket:             &bucketName,
+				CopySource:         aws.String(CopySource(bucketName, snapshot.Key)),
+				CopySourceIfMatch:  aws.String(`"` + current.ETag + `"`),
+				Key:                &snapshot.Key,
+				Metadata:           snapshot.Metadata,
+				MetadataDirective:  types.MetadataDirectiveReplace,
+				ContentType:        optionalString(snapshot.ContentType),
+				CacheControl:       optionalString(snapshot.CacheControl),
+				ContentDisposition: optionalString(snapshot.ContentDisposition),
+				ContentEncoding:    optionalString(snapshot.ContentEncoding),
+				ContentLanguage:    optionalString(snapshot.ContentLanguage),
+			})
+			if IsPreconditionFailed(err) {
+				return nil, fmt.Errorf("object '%s' in bucket '%s': %w", snapshot.Key, bucketName, ErrObjectChanged)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to update metadata of object '%s' in bucket '%s': %w", snapshot.Key, bucketName, err)
+			}
+			// Copying a multipart object onto itself gives it a new ETag.
+			if resp.CopyObjectResult != nil && resp.CopyObjectResult.ETag != nil {
+				etag = strings.Trim(aws.ToString(resp.CopyObjectResult.ETag), `"`)
+			}
+		}
+	}
+
+	if snapshot.Tags != nil && !maps.Equal(current.Tags, snapshot.Tags) {
+		tagChanges := describeMapChanges("tag", current.Tags, snapshot.Tags)
+		if !opts.DryRun {
+			// Tags apply to whatever content the key holds, so with RequireETag they are only
+			// set on the content that was checked.
+			var precondition []func(*s3.Options)
+			if opts.RequireETag {
+				precondition = append(precondition, func(o *s3.Options) {
+					o.APIOptions = append(o.APIOptions, smithyhttp.SetHeaderValue("If-Match", `"`+etag+`"`))
+				})
+			}
+			if len(snapshot.Tags) == 0 {
+				_, err = client.DeleteObjectTagging(ctx, &s3.DeleteObjectTaggingInput{
+					Bucket: &bucketName,
+					Key:    &snapshot.Key,
+				}, precondition...)
+			} else {
+				tagSet := make([]types.Tag, 0, len(snapshot.Tags))
+				for k, v := range snapshot.Tags {
+					tagSet = append(tagSet, types.Tag{Key: aws.String(k), Value: aws.String(v)})
+				}
+				_, err = client.PutObjectTagging(ctx, &s3.PutObjectTaggingInput{
+					Bucket:  &bucketName,
+					Key:     &snapshot.Key,
+					Tagging: &types.Tagging{TagSet: tagSet},
+				}, precondition...)
+			}
+			if IsPreconditionFailed(err) {
+				return changes, fmt.Errorf("object '%s' in bucket '%s': %w", snapshot.Key, bucketName, ErrObjectChanged)
+			}
+			if err != nil {
+				return changes, fmt.Errorf("failed to set tags of object '%s' in bucket '%s': %w", snapshot.Key, bucketName, err)
+			}
+		}
+		changes = append(changes, tagChanges...)
+	}
+	return changes, nil
+}
+
+// describeMetadataChanges lists how the metadata and content headers of desired differ from current.
+func describeMetadataChanges(current, desired ObjectSnapshot) []string {
+	changes := describeMapChanges("metadata", current.Metadata, desired.Metadata)
+	for _, header := range []struct{ name, old, new string }{
+		{"Content-Type", current.ContentType, desired.ContentType},
+		{"Cache-Control", current.CacheControl, desired.CacheControl},
+		{"Content-Disposition", current.ContentDisposition, desired.ContentDisposition},
+		{"Content-Encoding", current.ContentEncoding, desired.ContentEncoding},
+		{"Content-Language", current.ContentLanguage, desired.ContentLanguage},
+	} {
+		if header.old != header.new {
+			changes = append(changes, fmt.Sprintf("%s: '%s' → '%s'", header.name, header.old, header.new))
+		}
+	}
+	return changes
+}
+
+// describeMapChanges lists the entries added, changed and removed between two maps, in key order.
+func describeMapChanges(kind string, current, desired map[string]string) []string {
+	var changes []string
+	for _, k := range slices.Sorted(maps.Keys(desired)) {
+		old, ok := current[k]
+		switch {
+		case !ok:
+			changes = append(changes, fmt.Sprintf("%s %s: + '%s'", kind, k, desired[k]))
+		case old != desired[k]:
+			changes = append(changes, fmt.Sprintf("%s %s: '%s' → '%s'", kind, k, old, desired[k]))
+		}
+	}
+	for _, k := range slices.Sorted(maps.Keys(current)) {
+		if _, ok := desired[k]; !ok {
+			changes = append(changes, fmt.Sprintf("%s %s: - '%s'", kind, k, current[k]))
+		}
+	}
+	return changes
+}
+
+// getObjectTags returns the tags of an object.
+func getObjectTags(ctx context.Context, client *s3.Client, bucketName, objectKey string) (map[string]string, error) {
+	resp, err := client.GetObjectTagging(ctx, &s3.GetObjectTaggingInput{
+		Bucket: &bucketName,
+		Key:    &objectKey,
+	})
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "NotImplemented" {
+		return nil, ErrTaggingUnsupported
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tags of object '%s' in bucket '%s': %w", objectKey, bucketName, err)
+	}
+
+	tags := make(map[string]string, len(resp.TagSet))
+	for _, tag := range resp.TagSet {
+		tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	return tags, nil
+}
+
+// sameObjectMetadata reports whether two snapshots carry the same metadata and content headers.
+func sameObjectMetadata(a, b ObjectSnapshot) bool {
+	return maps.Equal(a.Metadata, b.Metadata) &&
+		a.ContentType == b.ContentType &&
+		a.CacheControl == b.CacheControl &&
+		a.ContentDisposition == b.ContentDisposition &&
+		a.ContentEncoding == b.ContentEncoding &&
+		a.ContentLanguage == b.ContentLanguage
+}
+
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
@@ -0,0 +1,108 @@
+package r2
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// taggedObjectServer serves one object with the ETag "abc" and one tag, and records the tagging
+// requests it receives. With replaced set, the object changes after every HEAD request.
+type taggedObjectServer struct {
+	replaced bool
+	mu       sync.Mutex
+	requests []string
+	ifMatch  []string
+}
+
+func (s *taggedObjectServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	_, tagging := r.URL.Query()["tagging"]
+	switch {
+	case r.Method == http.MethodHead:
+		w.Header().Set("ETag", `"abc"`)
+		w.Header().Set("Content-Length", "3")
+	case r.Method == http.MethodGet && tagging:
+		w.Write([]byte(`<Tagging><TagSet><Tag><Key>team</Key><Value>a</Value></Tag></TagSet></Tagging>`))
+	case tagging:
+		s.mu.Lock()
+		s.requests = append(s.requests, r.Method)
+		s.ifMatch = append(s.ifMatch, r.Header.Get("If-Match"))
+		s.mu.Unlock()
+		if match := r.Header.Get("If-Match"); match != "" && (s.replaced || match != `"abc"`) {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			w.Write([]byte(`<Error><Code>PreconditionFailed</Code></Error>`))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func newTestS3Client(url string) *s3.Client {
+	return s3.New(s3.Options{
+		Region:       "auto",
+		BaseEndpoint: aws.String(url),
+		UsePathStyle: true,
+		Credentials:  credentials.NewStaticCredentialsProvider("key", "secret", ""),
+		HTTPClient:   &stateHTTPClient{client: awshttp.NewBuildableClient(), state: &clientState{log: NewWriterLogger(nil)}},
+	})
+}
+
+func TestRestoreObjectMetadataTags(t *testing.T) {
+	tests := []struct {
+		name        string
+		tags        map[string]string
+		opts        MetadataRestoreOptions
+		wantMethod  string
+		wantIfMatch string
+	}{
+		{"empty tag set deletes the tags", map[string]string{}, MetadataRestoreOptions{}, http.MethodDelete, ""},
+		{"tags are set", map[string]string{"team": "b"}, MetadataRestoreOptions{}, http.MethodPut, ""},
+		{"RequireETag is a precondition", map[string]string{"team": "b"}, MetadataRestoreOptions{RequireETag: true}, http.MethodPut, `"abc"`},
+		{"tags not captured", nil, MetadataRestoreOptions{}, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := &taggedObjectServer{}
+			ts := httptest.NewServer(srv)
+			defer ts.Close()
+
+			snapshot := ObjectSnapshot{Key: "k", ETag: "abc", Tags: tt.tags}
+			if _, err := RestoreObjectMetadataWithOptions(context.Background(), newTestS3Client(ts.URL), "b", snapshot, tt.opts); err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantMethod == "" {
+				if len(srv.requests) != 0 {
+					t.Errorf("tagging requests %v, want none", srv.requests)
+				}
+				return
+			}
+			if len(srv.requests) != 1 || srv.requests[0] != tt.wantMethod {
+				t.Fatalf("tagging requests %v, want one %s", srv.requests, tt.wantMethod)
+			}
+			if srv.ifMatch[0] != tt.wantIfMatch {
+				t.Errorf("If-Match %q, want %q", srv.ifMatch[0], tt.wantIfMatch)
+			}
+		})
+	}
+}
+
+func TestRestoreObjectMetadataTagsPreconditionFailed(t *testing.T) {
+	ts := httptest.NewServer(&taggedObjectServer{replaced: true})
+	defer ts.Close()
+
+	snapshot := ObjectSnapshot{Key: "k", ETag: "abc", Tags: map[string]string{"team": "b"}}
+	_, err := RestoreObjectMetadataWithOptions(context.Background(), newTestS3Client(ts.URL), "b", snapshot, MetadataRestoreOptions{RequireETag: true})
+	if !errors.Is(err, ErrObjectChanged) {
+		t.Errorf("err = %v, want ErrObjectChanged", err)
+	}
+}
@@ -0,0 +1,126 @@
+package r2
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// MetadataSparse marks an object uploaded from a sparse file. Only the data extents are stored,
+// back to back; the value records the logical file size and the extents as
+// "size;offset+length,offset+length,...".
+const MetadataSparse = "sparse"
+
+// maxSparseMapLen keeps the extent map well inside the metadata size limit. Files fragmented into
+// more extents than fit are uploaded in full.
+const maxSparseMapLen = 2048
+
+// extent is a range of a file that holds data.
+type extent struct {
+	offset int64
+	length int64
+}
+
+// encodeSparseMap returns the metadata value describing the extents of a file of the given size.
+// It returns false if the file has no holes or the map would be too large.
+func encodeSparseMap(size int64, extents []extent) (string, bool) {
+	if extentsLength(extents) >= size {
+		return "", false
+	}
+
+	parts := make([]string, len(extents))
+	for i, e := range extents {
+		parts[i] = strconv.FormatInt(e.offset, 10) + "+" + strconv.FormatInt(e.length, 10)
+	}
+	encoded := strconv.FormatInt(size, 10) + ";" + strings.Join(parts, ",")
+	if len(encoded) > maxSparseMapLen {
+		return "", false
+	}
+	return encoded, true
+}
+
+// parseSparseMap decodes a MetadataSparse value.
+func parseSparseMap(value string) (int64, []extent, error) {
+	sizeStr, list, ok := strings.Cut(value, ";")
+	size, err := strconv.ParseInt(sizeStr, 10, 64)
+	if !ok || err != nil {
+		return 0, nil, fmt.Errorf("invalid sparse map '%s'", value)
+	}
+
+	var extents []extent
+	if list != "" {
+		for _, part := range strings.Split(list, ",") {
+			offStr, lenStr, ok := strings.Cut(part, "+")
+			offset, err1 := strconv.ParseInt(offStr, 10, 64)
+			length, err2 := strconv.ParseInt(lenStr, 10, 64)
+			if !ok || err1 != nil || err2 != nil || offset < 0 || length < 0 || offset+length > size {
+				return 0, nil, fmt.Errorf("invalid sparse map '%s'", value)
+			}
+			extents = append(extents, extent{offset: offset, length: length})
+		}
+	}
+	return size, extents, nil
+}
+
+func extentsLength(extents []extent) int64 {
+	var total int64
+	for _, e := range extents {
+		total += e.length
+	}
+	return total
+}
+
+// newExtentReader returns a reader over the data extents of a file, back to back.
+func newExtentReader(file io.ReaderAt, extents []extent) io.Reader {
+	readers := make([]io.Reader, len(extents))
+	for i, e := range extents {
+		readers[i] = io.NewSectionReader(file, e.offset, e.length)
+	}
+	return io.MultiReader(readers...)
+}
+
+// restoreSparse writes the extents read back to back from body to their offsets in dst and
+// extends dst to the logical size, leaving the gaps as holes.
+func restoreSparse(dst *os.File, body io.Reader, sparseMap string) error {
+	size, extents, err := parseSparseMap(sparseMap)
+	if err != nil {
+		return err
+	}
+	for _, e := range extents {
+		if _, err := io.CopyN(io.NewOffsetWriter(dst, e.offset), body, e.length); err != nil {
+			return fmt.Errorf("failed to write sparse extent at offset %d: %w", e.offset, err)
+		}
+	}
+	return dst.Truncate(size)
+}
+
+// hashSparse feeds h the logical content of a sparse file whose data extents body holds, with
+// zeros for the holes.
+func hashSparse(h io.Writer, body io.Reader, sparseMap string) error {
+	size, extents, err := parseSparseMap(sparseMap)
+	if err != nil {
+		return err
+	}
+	var position int64
+	for _, e := range extents {
+		if _, err := io.CopyN(h, zeroReader{}, e.offset-position); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(h, body, e.length); err != nil {
+			return fmt.Errorf("failed to read sparse extent at offset %d: %w", e.offset, err)
+		}
+		position = e.offset + e.length
+	}
+	_, err = io.CopyN(h, zeroReader{}, size-position)
+	return err
+}
+
+// zeroReader reads an endless stream of zero bytes.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
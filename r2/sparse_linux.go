@@ -0,0 +1,42 @@
+//go:build linux
+
+package r2
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// Linux lseek whence values for locating data and holes.
+const (
+	seekData = 3
+	seekHole = 4
+)
+
+// dataExtents returns the ranges of a file that hold data, skipping holes.
+// File systems without hole support report the whole file as one extent.
+func dataExtents(file *os.File, size int64) ([]extent, error) {
+	var extents []extent
+	offset := int64(0)
+	for offset < size {
+		start, err := file.Seek(offset, seekData)
+		if errors.Is(err, syscall.ENXIO) {
+			// No data after offset, the rest of the file is a hole.
+			break
+		}
+		if err != nil {
+			return []extent{{offset: 0, length: size}}, nil
+		}
+		end, err := file.Seek(start, seekHole)
+		if err != nil {
+			return []extent{{offset: 0, length: size}}, nil
+		}
+		extents = append(extents, extent{offset: start, length: end - start})
+		offset = end
+	}
+	if _, err := file.Seek(0, 0); err != nil {
+		return nil, err
+	}
+	return extents, nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package r2
+
+import "os"
+
+// dataExtents reports the whole file as data on platforms where holes cannot be located.
+func dataExtents(file *os.File, size int64) ([]extent, error) {
+	return []extent{{offset: 0, length: size}}, nil
+}
@@ -0,0 +1,47 @@
+package r2
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"os"
+)
+
+// SSECustomerKey is a customer-provided key that R2 encrypts an object with at rest (SSE-C). R2
+// does not store the key, so every read of the object needs the same key.
+type SSECustomerKey struct {
+	algorithm string
+	key       string
+	keyMD5    string
+}
+
+// LoadSSECustomerKey reads a 256-bit key from a file holding either the 32 raw bytes or their
+// base64 encoding.
+func LoadSSECustomerKey(path string) (*SSECustomerKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read encryption key '%s': %w", path, err)
+	}
+	key := data
+	if len(data) != 32 {
+		key, err = base64.StdEncoding.DecodeString(string(bytes.TrimSpace(data)))
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("encryption key '%s' must hold 32 bytes, raw or base64-encoded", path)
+		}
+	}
+	sum := md5.Sum(key)
+	return &SSECustomerKey{
+		algorithm: "AES256",
+		key:       base64.StdEncoding.EncodeToString(key),
+		keyMD5:    base64.StdEncoding.EncodeToString(sum[:]),
+	}, nil
+}
+
+// params returns the request parameters carrying the key, all nil for a nil key.
+func (k *SSECustomerKey) params() (algorithm, key, keyMD5 *string) {
+	if k == nil {
+		return nil, nil, nil
+	}
+	return &k.algorithm, &k.key, &k.keyMD5
+}
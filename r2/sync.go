@@ -0,0 +1,1168 @@
+package r2
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/baowuhe/go-cfr2/utils"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// Metadata keys written by sync uploads.
+const (
+	// MetadataSHA256 holds the hex-encoded SHA-256 digest of the object content.
+	MetadataSHA256 = "sha256"
+	// MetadataMtime holds the modification time of the source file in Unix seconds.
+	MetadataMtime = "mtime"
+	// MetadataHardlink marks an empty stub object standing for a hard link; it holds the key of the
+	// object with the shared content.
+	MetadataHardlink = "hardlink"
+)
+
+// Conflict resolutions returned by SyncOptions.AskConflict.
+const (
+	ResolveLocal  = "local"
+	ResolveRemote = "remote"
+	ResolveSkip   = "skip"
+)
+
+// SyncOptions configures a sync between a local directory and a bucket prefix.
+type SyncOptions struct {
+	LocalDir string
+	Bucket   string
+	Prefix   string
+	// Delete removes remote objects under the prefix that no longer exist locally.
+	Delete bool
+	// MaxDelete aborts the sync before anything is transferred when it would delete more than this many
+	// files, locally and remotely combined. Nil means no limit, and zero allows no deletions.
+	MaxDelete *int
+	// MaxDeletePercent is the same limit as a percentage of the files known on either side.
+	MaxDeletePercent float64
+	// CleanMarkers deletes zero-byte directory marker objects below the prefix. Markers are otherwise
+	// left alone and never compared with local files.
+	CleanMarkers bool
+	// PreserveHardlinks uploads the content of hard-linked files once and every further link as an
+	// empty stub pointing at it, and recreates the links when downloading stubs.
+	PreserveHardlinks bool
+	// PreservePerms records the mode, ownership and extended attributes of uploaded files in metadata
+	// and restores them on download. Changes to them alone do not make a file differ.
+	PreservePerms bool
+	// Manifest, when set, turns uploads of content already stored under another key into server-side
+	// copies, and records every upload. The caller saves it.
+	Manifest *HashManifest
+	// AppendOnlyPrefixes lists key prefixes below which objects are never deleted or overwritten.
+	AppendOnlyPrefixes []string
+	// OverrideProtection allows deleting and overwriting objects marked as protected.
+	OverrideProtection bool
+	// ArchivePrefix, when set, makes deletions move objects below this prefix instead of destroying them.
+	// The part of the key below Prefix is kept.
+	ArchivePrefix string
+	// Deleted, when set, is called with the key of every object the sync deleted, and the key it
+	// was archived to if ArchivePrefix is set. It is never called concurrently.
+	Deleted func(key, archivedTo string)
+	// AcquireSlot, when set, is called before every action and waits until it may start, such as for
+	// a slot of a transfer queue shared with other processes. The returned function is called once
+	// the action is done.
+	AcquireSlot func(ctx context.Context, key string) (release func(), err error)
+	// Checksum compares SHA-256 content hashes stored in object metadata instead of size and modification time.
+	Checksum bool
+	// DryRun prints the planned actions without executing them.
+	DryRun bool
+	// Concurrency is the number of transfers run in parallel.
+	Concurrency int
+	// Deadline, when set, stops launching transfers once it has passed. Transfers already running are
+	// finished and the rest is left for the next run.
+	Deadline time.Time
+	// ResumePath, when set, receives the actions left over at the deadline. The next run performs
+	// them first, and removes the file once it completes.
+	ResumePath string
+
+	// Incremental compares local files with the state file written by the previous run instead of
+	// listing the remote prefix. The prefix is still listed when no state exists yet.
+	Incremental bool
+	// Rescan lists the remote prefix in incremental mode anyway and rebuilds the state file from it.
+	Rescan bool
+	// Bidirectional propagates changes and deletions in both directions, using the state file
+	// written by the previous run to tell which side changed.
+	Bidirectional bool
+	// StatePath is the state file used by incremental and bidirectional syncs.
+	StatePath string
+	// Prefer resolves files changed on both sides: "newer", "local", "remote" or "ask".
+	// Conflicts are reported and left untouched when Prefer is empty.
+	Prefer string
+	// AskConflict is called for every conflict when Prefer is "ask" and returns
+	// ResolveLocal, ResolveRemote or ResolveSkip.
+	AskConflict func(key string) string
+
+	// Exclude lists patterns, relative to Prefix, of files and objects the sync ignores on both sides.
+	// See matchKeyPattern for the pattern syntax.
+	Exclude []string
+	// CacheRules set the Cache-Control header of uploaded files. The first matching rule applies.
+	CacheRules []CacheRule
+	// Fingerprint lists patterns of files uploaded under names carrying a hash of their content, such
+	// as app.3f9a2c1d.js, so they can be cached forever. A JSON manifest mapping original to
+	// fingerprinted names is uploaded to FingerprintManifest, or DefaultFingerprintManifest, below Prefix.
+	Fingerprint         []string
+	FingerprintManifest string
+	// Precompress lists the encodings, PrecompressGzip or PrecompressBrotli, of precompressed variants
+	// uploaded next to every uploaded text file. Variants of existing local files are never deleted.
+	Precompress []string
+	// Paths, when non-nil, limits the sync to these keys and skips listing the bucket: those that
+	// exist locally are uploaded, and the others deleted if Delete is set.
+	Paths map[string]bool
+}
+
+// CacheRule sets the Cache-Control header of uploads whose key, relative to the sync prefix,
+// matches Pattern.
+type CacheRule struct {
+	Pattern      string
+	CacheControl string
+}
+
+// excluded reports whether a key is ignored by the sync.
+func (opts SyncOptions) excluded(key string) bool {
+	return matchAnyKeyPattern(opts.Exclude, strings.TrimPrefix(key, opts.Prefix))
+}
+
+// cacheControl returns the Cache-Control header for an uploaded key, or nil if no rule matches.
+func (opts SyncOptions) cacheControl(key string) *string {
+	rel := strings.TrimPrefix(key, opts.Prefix)
+	for _, rule := range opts.CacheRules {
+		if matchKeyPattern(rule.Pattern, rel) {
+			return &rule.CacheControl
+		}
+	}
+	return nil
+}
+
+// SyncResult summarises the work done by Sync.
+type SyncResult struct {
+	Uploaded     int
+	Downloaded   int
+	Deleted      int
+	DeletedLocal int
+	Unchanged    int
+	Conflicts    int
+	Failed       int
+	// Remaining counts the actions not started because the deadline passed.
+	Remaining int
+}
+
+type syncActionKind int
+
+const (
+	syncUpload syncActionKind = iota
+	syncDelete
+	syncDownload
+	syncDeleteLocal
+)
+
+// syncAction is a single planned transfer.
+type syncAction struct {
+	kind      syncActionKind
+	key       string
+	localPath string
+	sha256    string
+	// exists is set for uploads that replace an existing object.
+	exists bool
+	// linkTarget is set for uploads of a hard link stub and holds the key of the linked content.
+	linkTarget string
+}
+
+// localFile describes a regular file found while walking the local directory.
+type localFile struct {
+	path  string
+	size  int64
+	mtime int64
+	// linkID identifies the inode of a file with several hard links.
+	linkID string
+	// linkTarget is the key of the primary link when this file is a further hard link to it.
+	linkTarget string
+}
+
+// Sync makes the bucket prefix mirror the local directory, uploading new and changed files
+// and, if requested, deleting remote objects that have no local counterpart. In bidirectional
+// mode remote changes are propagated to the local directory as well.
+func Sync(ctx context.Context, client *s3.Client, opts SyncOptions) (*SyncResult, error) {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 1
+	}
+	if opts.Prefix != "" && !strings.HasSuffix(opts.Prefix, "/") {
+		opts.Prefix += "/"
+	}
+	if opts.ArchivePrefix != "" && !strings.HasSuffix(opts.ArchivePrefix, "/") {
+		opts.ArchivePrefix += "/"
+	}
+	// Both prefixes end in "/", so they are compared on a path segment boundary.
+	if opts.ArchivePrefix != "" && opts.Prefix != "" && strings.HasPrefix(opts.ArchivePrefix, opts.Prefix) {
+		return nil, fmt.Errorf("archive prefix '%s' must not be inside the sync prefix '%s'", opts.ArchivePrefix, opts.Prefix)
+	}
+	if opts.ArchivePrefix != "" && opts.Prefix == "" && !opts.excluded(opts.ArchivePrefix) {
+		return nil, fmt.Errorf("archive prefix '%s' is among the synced keys and must be excluded", opts.ArchivePrefix)
+	}
+	if (opts.Bidirectional || opts.Incremental) && opts.StatePath == "" {
+		return nil, fmt.Errorf("incremental and bidirectional syncs require a state file")
+	}
+
+	log := logger(client)
+	local, err := walkLocalDir(log, opts.LocalDir, opts.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	for key := range local {
+		if opts.excluded(key) {
+			delete(local, key)
+		}
+	}
+	if len(opts.Precompress) > 0 && opts.Bidirectional {
+		return nil, fmt.Errorf("precompressed variants cannot be uploaded by bidirectional syncs")
+	}
+	for _, encoding := range opts.Precompress {
+		if _, ok := precompressSuffixes[encoding]; !ok {
+			return nil, fmt.Errorf("unsupported precompression encoding '%s'", encoding)
+		}
+	}
+	var fingerprints map[string]string
+	if len(opts.Fingerprint) > 0 {
+		if opts.Bidirectional || opts.Paths != nil {
+			return nil, fmt.Errorf("fingerprinting cannot be combined with bidirectional or path-limited syncs")
+		}
+		fingerprints, err = fingerprintLocal(opts, local)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if opts.PreserveHardlinks {
+		assignHardLinkTargets(local)
+	}
+
+	var state *syncState
+	if opts.Bidirectional || opts.Incremental {
+		mode := syncModeIncremental
+		if opts.Bidirectional {
+			mode = syncModeBidirectional
+		}
+		state, err = loadSyncState(opts.StatePath, mode)
+		if err != nil {
+			return nil, err
+		}
+		for key := range state.Entries {
+			if opts.excluded(key) {
+				delete(state.Entries, key)
+			}
+		}
+	}
+
+	result := &SyncResult{}
+	var actions []syncAction
+	known := len(local)
+	if opts.Paths != nil {
+		actions = planPathsSync(opts, local)
+	} else if opts.Incremental && !opts.Rescan && len(state.Entries) > 0 {
+		known = max(known, len(state.Entries))
+		actions, err = planIncrementalSync(opts, local, state, result)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		remoteObjects, err := ListObjectsWithPrefix(ctx, client, opts.Bucket, opts.Prefix)
+		if err != nil {
+			return nil, err
+		}
+		remote := make(map[string]types.Object, len(remoteObjects))
+		var markers []string
+		for _, obj := range remoteObjects {
+			if IsDirectoryMarker(obj) {
+				markers = append(markers, *obj.Key)
+				continue
+			}
+			if opts.excluded(*obj.Key) {
+				continue
+			}
+			if fingerprints != nil && *obj.Key == fingerprintManifestKey(opts) {
+				// Uploaded separately below, never deleted.
+				continue
+			}
+			if isPrecompressedVariant(opts, *obj.Key, local) {
+				continue
+			}
+			remote[*obj.Key] = obj
+		}
+		known = max(known, len(remote))
+
+		if opts.Bidirectional {
+			sameContent, err := compareUnsyncedContent(ctx, client, opts, local, remote, state)
+			if err != nil {
+				return nil, err
+			}
+			actions = planBidirectionalSync(log, opts, local, remote, state, sameContent, result)
+		} else {
+			if state != nil {
+				// The listing is authoritative, rebuild the snapshot from it.
+				state.Entries = map[string]syncStateEntry{}
+			}
+			actions, err = planSync(ctx, client, opts, local, remote, state, result)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if opts.CleanMarkers {
+			for _, key := range markers {
+				actions = append(actions, syncAction{kind: syncDelete, key: key})
+			}
+		}
+	}
+
+	if err := checkMaxDelete(opts, actions, known); err != nil {
+		return result, err
+	}
+
+	if opts.DryRun {
+		for _, action := range actions {
+			switch action.kind {
+			case syncUpload:
+				log.Printf("(dry run) upload '%s' as '%s'\n", action.localPath, action.key)
+				result.Uploaded++
+			case syncDelete:
+				if opts.ArchivePrefix != "" {
+					log.Printf("(dry run) archive '%s' to '%s'\n", action.key, archiveKey(opts, action.key))
+				} else {
+					log.Printf("(dry run) delete '%s'\n", action.key)
+				}
+				result.Deleted++
+			case syncDownload:
+				log.Printf("(dry run) download '%s' to '%s'\n", action.key, action.localPath)
+				result.Downloaded++
+			case syncDeleteLocal:
+				log.Printf("(dry run) delete local '%s'\n", action.localPath)
+				result.DeletedLocal++
+			}
+		}
+		if fingerprints != nil {
+			log.Printf("(dry run) upload asset manifest '%s'\n", fingerprintManifestKey(opts))
+		}
+		return result, nil
+	}
+
+	if opts.ResumePath != "" {
+		resume, err := loadSyncResume(opts.ResumePath)
+		if err != nil {
+			return result, err
+		}
+		resume.prioritize(actions)
+	}
+
+	warmForBatch(ctx, client, opts.Bucket, opts.Concurrency, len(actions))
+	remaining, runErr := runSyncActions(ctx, client, opts, actions, state, result)
+	result.Remaining = len(remaining)
+	if fingerprints != nil && runErr == nil && len(remaining) == 0 {
+		// Only publish the manifest once every asset it points at is in place.
+		runErr = uploadFingerprintManifest(ctx, client, opts, fingerprints)
+	}
+	if state != nil {
+		if err := state.save(opts.StatePath); err != nil {
+			return result, errors.Join(runErr, err)
+		}
+	}
+	if opts.ResumePath != "" {
+		if len(remaining) > 0 {
+			if err := saveSyncResume(opts.ResumePath, remaining); err != nil {
+				return result, errors.Join(runErr, err)
+			}
+		} else if err := os.Remove(opts.ResumePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return result, errors.Join(runErr, err)
+		}
+	}
+	return result, runErr
+}
+
+// checkMaxDelete refuses a plan that deletes more files than the configured limits allow, which
+// usually means the source directory is empty or not mounted.
+func checkMaxDelete(opts SyncOptions, actions []syncAction, known int) error {
+	deletions := 0
+	for _, action := range actions {
+		if action.kind == syncDelete || action.kind == syncDeleteLocal {
+			deletions++
+		}
+	}
+	if opts.MaxDelete != nil && deletions > *opts.MaxDelete {
+		return fmt.Errorf("sync would delete %d file(s), more than the limit of %d; nothing was changed", deletions, *opts.MaxDelete)
+	}
+	if opts.MaxDeletePercent > 0 && known > 0 {
+		percent := float64(deletions) * 100 / float64(known)
+		if percent > opts.MaxDeletePercent {
+			return fmt.Errorf("sync would delete %d of %d file(s) (%.1f%%), more than the limit of %g%%; nothing was changed", deletions, known, percent, opts.MaxDeletePercent)
+		}
+	}
+	return nil
+}
+
+// walkLocalDir returns the regular files below dir keyed by their object key.
+// Symbolic links and other special files are skipped with a warning.
+func walkLocalDir(log Logger, dir, prefix string) (map[string]localFile, error) {
+	files := map[string]localFile{}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			log.Warnf("skipping '%s', not a regular file.", path)
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files[prefix+filepath.ToSlash(rel)] = localFile{
+			path:   path,
+			size:   info.Size(),
+			mtime:  info.ModTime().Unix(),
+			linkID: hardLinkID(info),
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk local directory '%s': %w", dir, err)
+	}
+	return files, nil
+}
+
+// assignHardLinkTargets picks, for every group of hard links to the same file, the lexically smallest
+// key as the primary whose content is uploaded, and points the other links at it.
+func assignHardLinkTargets(local map[string]localFile) {
+	primaries := map[string]string{}
+	for key, file := range local {
+		if file.linkID == "" {
+			continue
+		}
+		if primary, ok := primaries[file.linkID]; !ok || key < primary {
+			primaries[file.linkID] = key
+		}
+	}
+	for key, file := range local {
+		if primary := primaries[file.linkID]; file.linkID != "" && primary != key {
+			file.linkTarget = primary
+			local[key] = file
+		}
+	}
+}
+
+// planSync compares the local and remote file sets and returns the actions needed to bring the
+// remote side up to date. Unchanged files are counted in result and, when state is non-nil,
+// recorded in it.
+func planSync(ctx context.Context, client *s3.Client, opts SyncOptions, local map[string]localFile, remote map[string]types.Object, state *syncState, result *SyncResult) ([]syncAction, error) {
+	keys := make([]string, 0, len(local))
+	for key := range local {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var actions []syncAction
+	unchanged := func(action syncAction) {
+		result.Unchanged++
+		if state != nil {
+			file := local[action.key]
+			state.Entries[action.key] = syncStateEntry{Size: file.size, Mtime: file.mtime, ETag: objectETag(remote[action.key]), SHA256: action.sha256}
+		}
+	}
+	// Same-sized files compared by checksum wait for the stored hash, fetched concurrently below.
+	pending := map[string]syncAction{}
+	for _, key := range keys {
+		file := local[key]
+		action := syncAction{kind: syncUpload, key: key, localPath: file.path, linkTarget: file.linkTarget}
+
+		obj, exists := remote[key]
+		action.exists = exists
+		changed := !exists || obj.Size == nil || *obj.Size != file.size
+		if file.linkTarget != "" {
+			// Hard links are stored as empty stubs, which the listing cannot tell apart further.
+			changed = !exists || aws.ToInt64(obj.Size) != 0
+		} else if opts.Checksum {
+			hash, err := utils.FileSHA256(file.path)
+			if err != nil {
+				return nil, err
+			}
+			action.sha256 = hash
+			if !changed {
+				pending[key] = action
+				continue
+			}
+		} else if !changed && obj.LastModified != nil {
+			changed = file.mtime > obj.LastModified.Unix()
+		}
+
+		if changed {
+			actions = append(actions, action)
+		} else {
+			unchanged(action)
+		}
+	}
+
+	if len(pending) > 0 {
+		pipeline := NewHeadPipeline(ctx, client, opts.Bucket, 0, func(key string, head *s3.HeadObjectOutput, err error) error {
+			if err != nil {
+				return err
+			}
+			action := pending[key]
+			if head.Metadata[MetadataSHA256] != action.sha256 {
+				actions = append(actions, action)
+			} else {
+				unchanged(action)
+			}
+			return nil
+		})
+		for _, key := range keys {
+			if _, ok := pending[key]; ok {
+				if err := pipeline.Add(key); err != nil {
+					break
+				}
+			}
+		}
+		if err := pipeline.Wait(); err != nil {
+			return nil, err
+		}
+		sort.Slice(actions, func(i, j int) bool { return actions[i].key < actions[j].key })
+	}
+
+	// Objects without a local file are not recorded in state: the state lists what this directory
+	// put in the bucket, not whatever else is stored below the prefix.
+	var deletions []string
+	for key := range remote {
+		if _, ok := local[key]; ok {
+			continue
+		}
+		if opts.Delete {
+			deletions = append(deletions, key)
+		}
+	}
+	if opts.Delete {
+		sort.Strings(deletions)
+		for _, key := range deletions {
+			actions = append(actions, syncAction{kind: syncDelete, key: key})
+		}
+	}
+
+	return actions, nil
+}
+
+// planIncrementalSync compares the local files with the state recorded by the previous run, without
+// contacting the bucket. Files whose size and modification time match the state are unchanged; a file
+// whose modification time changed but whose content hash matches only has its state refreshed.
+// Deletions are planned for keys in the state that no longer exist locally.
+func planIncrementalSync(opts SyncOptions, local map[string]localFile, state *syncState, result *SyncResult) ([]syncAction, error) {
+	keys := make([]string, 0, len(local))
+	for key := range local {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var actions []syncAction
+	for _, key := range keys {
+		file := local[key]
+		prev, known := state.Entries[key]
+		if known && prev.Size == file.size && prev.Mtime == file.mtime {
+			result.Unchanged++
+			continue
+		}
+
+		action := syncAction{kind: syncUpload, key: key, localPath: file.path, exists: known, linkTarget: file.linkTarget}
+		if known && prev.SHA256 != "" && prev.Size == file.size && file.linkTarget == "" {
+			hash, err := utils.FileSHA256(file.path)
+			if err != nil {
+				return nil, err
+			}
+			if hash == prev.SHA256 {
+				prev.Mtime = file.mtime
+				state.Entries[key] = prev
+				result.Unchanged++
+				continue
+			}
+			action.sha256 = hash
+		}
+		actions = append(actions, action)
+	}
+
+	if opts.Delete {
+		var deletions []string
+		for key := range state.Entries {
+			if _, ok := local[key]; !ok {
+				deletions = append(deletions, key)
+			}
+		}
+		sort.Strings(deletions)
+		for _, key := range deletions {
+			actions = append(actions, syncAction{kind: syncDelete, key: key})
+		}
+	}
+
+	return actions, nil
+}
+
+// planPathsSync plans the upload or deletion of every key listed in opts.Paths, which are known to
+// have changed, without comparing them with the bucket.
+func planPathsSync(opts SyncOptions, local map[string]localFile) []syncAction {
+	keys := make([]string, 0, len(opts.Paths))
+	for key := range opts.Paths {
+		if !opts.excluded(key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var actions []syncAction
+	for _, key := range keys {
+		if file, ok := local[key]; ok {
+			// The object may or may not exist, so it is checked like an overwrite.
+			actions = append(actions, syncAction{kind: syncUpload, key: key, localPath: file.path, exists: true, linkTarget: file.linkTarget})
+		} else if opts.Delete {
+			actions = append(actions, syncAction{kind: syncDelete, key: key})
+		}
+	}
+	return actions
+}
+
+// compareUnsyncedContent checks which keys present on both sides, but not yet recorded in state,
+// have the same content, and returns their SHA-256 digests. Only files of the object's size are
+// compared, against the digest stored in the object's metadata or, failing that, an MD5 ETag.
+// Objects offering neither cannot be compared and are left out, as if they differed.
+func compareUnsyncedContent(ctx context.Context, client *s3.Client, opts SyncOptions, local map[string]localFile, remote map[string]types.Object, state *syncState) (map[string]string, error) {
+	var keys []string
+	for key, file := range local {
+		obj, inRemote := remote[key]
+		if _, inState := state.Entries[key]; inRemote && !inState && aws.ToInt64(obj.Size) == file.size {
+			keys = append(keys, key)
+		}
+	}
+	sameContent := map[string]string{}
+	if len(keys) == 0 {
+		return sameContent, nil
+	}
+	sort.Strings(keys)
+
+	pipeline := NewHeadPipeline(ctx, client, opts.Bucket, 0, func(key string, head *s3.HeadObjectOutput, err error) error {
+		if err != nil {
+			return err
+		}
+		path := local[key].path
+		hash, err := utils.FileSHA256(path)
+		if err != nil {
+			return err
+		}
+		etag := strings.Trim(aws.ToString(head.ETag), `"`)
+		switch {
+		case head.Metadata[MetadataSHA256] != "":
+			if head.Metadata[MetadataSHA256] == hash {
+				sameContent[key] = hash
+			}
+		case IsMD5ETag(etag) && head.Metadata[MetadataSparse] == "":
+			md5sum, err := utils.FileMD5(path)
+			if err != nil {
+				return err
+			}
+			if md5sum == etag {
+				sameContent[key] = hash
+			}
+		}
+		return nil
+	})
+	for _, key := range keys {
+		if err := pipeline.Add(key); err != nil {
+			break
+		}
+	}
+	if err := pipeline.Wait(); err != nil {
+		return nil, err
+	}
+	return sameContent, nil
+}
+
+// planBidirectionalSync decides, for every key seen locally, remotely or in the previous state,
+// which side changed since the last run and which way the change has to travel. Keys present on
+// both sides without history are in sync when sameContent holds their digest, as found by
+// compareUnsyncedContent, and conflicts otherwise.
+func planBidirectionalSync(log Logger, opts SyncOptions, local map[string]localFile, remote map[string]types.Object, state *syncState, sameContent map[string]string, result *SyncResult) []syncAction {
+	seen := map[string]bool{}
+	for key := range local {
+		seen[key] = true
+	}
+	for key := range remote {
+		seen[key] = true
+	}
+	for key := range state.Entries {
+		seen[key] = true
+	}
+	keys := make([]string, 0, len(seen))
+	for key := range seen {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var actions []syncAction
+	for _, key := range keys {
+		file, inLocal := local[key]
+		obj, inRemote := remote[key]
+		prev, inState := state.Entries[key]
+
+		localPath, ok := localPathForKey(opts.LocalDir, opts.Prefix, key)
+		if !ok {
+			log.Warnf("skipping '%s', the key cannot be mapped to a local file.", key)
+			continue
+		}
+
+		upload := syncAction{kind: syncUpload, key: key, localPath: localPath, exists: inRemote, linkTarget: file.linkTarget}
+		download := syncAction{kind: syncDownload, key: key, localPath: localPath}
+		deleteRemote := syncAction{kind: syncDelete, key: key}
+		deleteLocal := syncAction{kind: syncDeleteLocal, key: key, localPath: localPath}
+
+		localChanged := inLocal && (!inState || file.size != prev.Size || file.mtime != prev.Mtime)
+		remoteChanged := inRemote && (!inState || objectETag(obj) != prev.ETag)
+
+		var planned *syncAction
+		conflict := false
+		switch {
+		case inLocal && inRemote && !inState:
+			// Present on both sides without history: in sync only if the content is known to match.
+			if hash, ok := sameContent[key]; ok {
+				state.Entries[key] = syncStateEntry{Size: file.size, Mtime: file.mtime, ETag: objectETag(obj), SHA256: hash}
+			} else {
+				conflict = true
+			}
+		case inLocal && inRemote:
+			switch {
+			case localChanged && remoteChanged:
+				conflict = true
+			case localChanged:
+				planned = &upload
+			case remoteChanged:
+				planned = &download
+			}
+		case inLocal:
+			switch {
+			case !inState:
+				planned = &upload
+			case localChanged:
+				conflict = true
+			default:
+				planned = &deleteLocal
+			}
+		case inRemote:
+			switch {
+			case !inState:
+				planned = &download
+			case remoteChanged:
+				conflict = true
+			default:
+				planned = &deleteRemote
+			}
+		default:
+			// Deleted on both sides.
+			delete(state.Entries, key)
+		}
+
+		if conflict {
+			result.Conflicts++
+			switch resolveConflict(opts, key, file, obj, inLocal, inRemote) {
+			case ResolveLocal:
+				log.Printf("Conflict on '%s': keeping the local version.\n", key)
+				if inLocal {
+					planned = &upload
+				} else {
+					planned = &deleteRemote
+				}
+			case ResolveRemote:
+				log.Printf("Conflict on '%s': keeping the remote version.\n", key)
+				if inRemote {
+					planned = &download
+				} else {
+					planned = &deleteLocal
+				}
+			default:
+				log.Printf("Conflict on '%s': changed on both sides, skipped.\n", key)
+			}
+		}
+
+		if planned != nil {
+			actions = append(actions, *planned)
+		} else if !conflict {
+			result.Unchanged++
+		}
+	}
+
+	return actions
+}
+
+// resolveConflict applies the configured conflict preference to a key changed on both sides.
+func resolveConflict(opts SyncOptions, key string, file localFile, obj types.Object, inLocal, inRemote bool) string {
+	switch opts.Prefer {
+	case ResolveLocal, ResolveRemote:
+		return opts.Prefer
+	case "newer":
+		// A version that was modified wins over one that was deleted.
+		if !inLocal {
+			return ResolveRemote
+		}
+		if !inRemote || obj.LastModified == nil {
+			return ResolveLocal
+		}
+		if file.mtime > obj.LastModified.Unix() {
+			return ResolveLocal
+		}
+		return ResolveRemote
+	case "ask":
+		if opts.AskConflict != nil {
+			return opts.AskConflict(key)
+		}
+	}
+	return ResolveSkip
+}
+
+// localPathForKey maps an object key below prefix to a path inside dir, refusing keys that
+// would escape the directory or that name a directory rather than a file.
+func localPathForKey(dir, prefix, key string) (string, bool) {
+	rel := strings.TrimPrefix(key, prefix)
+	if rel == "" || strings.HasSuffix(rel, "/") || !filepath.IsLocal(filepath.FromSlash(rel)) {
+		return "", false
+	}
+	return filepath.Join(dir, filepath.FromSlash(rel)), true
+}
+
+// archiveKey returns the key a deleted object is moved to when an archive prefix is configured.
+func archiveKey(opts SyncOptions, key string) string {
+	return opts.ArchivePrefix + strings.TrimPrefix(key, opts.Prefix)
+}
+
+func objectETag(obj types.Object) string {
+	if obj.ETag == nil {
+		return ""
+	}
+	return strings.Trim(*obj.ETag, `"`)
+}
+
+// runSyncActions executes the planned actions with a bounded worker pool. Failures are reported and
+// counted but do not stop the remaining transfers. When state is non-nil it is updated with the
+// outcome of every successful action. It returns the actions not started before the deadline.
+func runSyncActions(ctx context.Context, client *s3.Client, opts SyncOptions, actions []syncAction, state *syncState, result *SyncResult) ([]syncAction, error) {
+	log := logger(client)
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		errs []error
+	)
+	queue := make(chan syncAction)
+
+	for i := 0; i < opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for action := range queue {
+				var entry syncStateEntry
+				var err error
+				release := func() {}
+				if opts.AcquireSlot != nil {
+					release, err = opts.AcquireSlot(ctx, action.key)
+				}
+				if err == nil {
+					entry, err = runSyncAction(ctx, client, opts, action)
+					release()
+				}
+
+				mu.Lock()
+				if err != nil {
+					result.Failed++
+					errs = append(errs, err)
+					log.Printf("Failed: %v\n", err)
+				} else {
+					switch action.kind {
+					case syncUpload:
+						result.Uploaded++
+					case syncDelete:
+						result.Deleted++
+						if opts.Deleted != nil {
+							archivedTo := ""
+							if opts.ArchivePrefix != "" {
+								archivedTo = archiveKey(opts, action.key)
+							}
+							opts.Deleted(action.key, archivedTo)
+						}
+					case syncDownload:
+						result.Downloaded++
+					case syncDeleteLocal:
+						result.DeletedLocal++
+					}
+					if state != nil {
+						if action.kind == syncUpload || action.kind == syncDownload {
+							state.Entries[action.key] = entry
+						} else {
+							delete(state.Entries, action.key)
+						}
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	var remaining []syncAction
+	for i, action := range actions {
+		if !opts.Deadline.IsZero() && time.Now().After(opts.Deadline) {
+			remaining = actions[i:]
+			log.Printf("Time budget reached, finishing transfers in progress...\n")
+			break
+		}
+		queue <- action
+	}
+	close(queue)
+	wg.Wait()
+
+	return remaining, errors.Join(errs...)
+}
+
+// runSyncAction performs one action and returns the resulting state of the file for uploads and downloads.
+func runSyncAction(ctx context.Context, client *s3.Client, opts SyncOptions, action syncAction) (syncStateEntry, error) {
+	log := logger(client)
+	if action.kind == syncDelete || (action.kind == syncUpload && action.exists) {
+		for _, prefix := range opts.AppendOnlyPrefixes {
+			if prefix != "" && strings.HasPrefix(action.key, prefix) {
+				return syncStateEntry{}, fmt.Errorf("refusing to modify '%s': prefix '%s' is append-only", action.key, prefix)
+			}
+		}
+	}
+	if !opts.OverrideProtection && (action.kind == syncDelete || (action.kind == syncUpload && action.exists)) {
+		if err := CheckNotProtected(ctx, client, opts.Bucket, action.key); err != nil {
+			return syncStateEntry{}, err
+		}
+	}
+
+	switch action.kind {
+	case syncDelete:
+		if opts.ArchivePrefix != "" {
+			target := archiveKey(opts, action.key)
+			log.Printf("Archiving '%s' to '%s'...\n", action.key, target)
+			return syncStateEntry{}, RenameObject(ctx, client, opts.Bucket, action.key, target)
+		}
+		log.Printf("Deleting '%s'...\n", action.key)
+		return syncStateEntry{}, DeleteObject(ctx, client, opts.Bucket, action.key)
+	case syncDeleteLocal:
+		log.Printf("Deleting local '%s'...\n", action.localPath)
+		if err := os.Remove(action.localPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return syncStateEntry{}, fmt.Errorf("failed to delete local file '%s': %w", action.localPath, err)
+		}
+		return syncStateEntry{}, nil
+	case syncDownload:
+		log.Printf("Downloading '%s' to '%s'...\n", action.key, action.localPath)
+		etag, err := downloadToFile(ctx, client, opts, action.key, action.localPath)
+		if err != nil {
+			return syncStateEntry{}, err
+		}
+		info, err := os.Stat(action.localPath)
+		if err != nil {
+			return syncStateEntry{}, err
+		}
+		return syncStateEntry{Size: info.Size(), Mtime: info.ModTime().Unix(), ETag: etag}, nil
+	default:
+		info, err := os.Stat(action.localPath)
+		if err != nil {
+			return syncStateEntry{}, fmt.Errorf("failed to get file info for '%s': %w", action.localPath, err)
+		}
+		if action.linkTarget != "" {
+			log.Printf("Linking '%s' to '%s'...\n", action.key, action.linkTarget)
+			etag, err := putHardLinkStub(ctx, client, opts.Bucket, action.key, action.linkTarget, info.ModTime())
+			if err != nil {
+				return syncStateEntry{}, err
+			}
+			return syncStateEntry{Size: info.Size(), Mtime: info.ModTime().Unix(), ETag: etag}, nil
+		}
+		if action.sha256 == "" {
+			hash, err := utils.FileSHA256(action.localPath)
+			if err != nil {
+				return syncStateEntry{}, err
+			}
+			action.sha256 = hash
+		}
+		metadata := map[string]string{
+			MetadataSHA256: action.sha256,
+			MetadataMtime:  strconv.FormatInt(info.ModTime().Unix(), 10),
+		}
+		if opts.PreservePerms {
+			for k, v := range capturePerms(log, action.localPath, info) {
+				metadata[k] = v
+			}
+		}
+		contentType, err := fileContentType(action.key, action.localPath)
+		if err != nil {
+			return syncStateEntry{}, err
+		}
+		uploadOpts := UploadOptions{
+			Metadata:     metadata,
+			Quiet:        true,
+			CacheControl: opts.cacheControl(action.key),
+			ContentType:  &contentType,
+		}
+		entry := syncStateEntry{Size: info.Size(), Mtime: info.ModTime().Unix(), SHA256: action.sha256}
+
+		if opts.Manifest != nil {
+			etag, copied, err := CopyDuplicate(ctx, client, opts.Bucket, action.key, action.sha256, opts.Manifest, uploadOpts)
+			if err != nil {
+				return syncStateEntry{}, err
+			}
+			if copied {
+				log.Printf("Copied duplicate content to '%s'.\n", action.key)
+				entry.ETag = etag
+				if len(opts.Precompress) > 0 {
+					err = uploadPrecompressed(ctx, client, opts, action.key, action.localPath)
+				}
+				return entry, err
+			}
+		}
+
+		log.Printf("Uploading '%s' as '%s'...\n", action.localPath, action.key)
+		output, err := uploadFile(ctx, client, opts.Bucket, action.key, action.localPath, uploadOpts)
+		if err != nil {
+			return syncStateEntry{}, err
+		}
+		if len(opts.Precompress) > 0 {
+			if err := uploadPrecompressed(ctx, client, opts, action.key, action.localPath); err != nil {
+				return syncStateEntry{}, err
+			}
+		}
+		if output.ETag != nil {
+			entry.ETag = strings.Trim(*output.ETag, `"`)
+		}
+		if opts.Manifest != nil {
+			opts.Manifest.Record(action.sha256, action.key)
+		}
+		return entry, nil
+	}
+}
+
+// putHardLinkStub stores a hard link as an empty object whose metadata points at the key holding the content.
+func putHardLinkStub(ctx context.Context, client *s3.Client, bucketName, objectKey, target string, mtime time.Time) (string, error) {
+	resp, err := client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:        &bucketName,
+		Key:           &objectKey,
+		Body:          bytes.NewReader(nil),
+		ContentLength: aws.Int64(0),
+		Metadata: map[string]string{
+			MetadataHardlink: target,
+			MetadataMtime:    strconv.FormatInt(mtime.Unix(), 10),
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to upload hard link stub '%s' to bucket '%s': %w", objectKey, bucketName, err)
+	}
+	return strings.Trim(aws.ToString(resp.ETag), `"`), nil
+}
+
+// downloadToFile downloads an object into a temporary file next to path and renames it into place,
+// so readers never see a partially written file. The file's modification time is set to the
+// object's LastModified time. Hard link stubs are recreated as links to their target when
+// PreserveHardlinks is set and the target exists locally, and are filled with the target's
+// content otherwise. It returns the object's ETag.
+func downloadToFile(ctx context.Context, client *s3.Client, opts SyncOptions, objectKey, path string) (string, error) {
+	resp, err := client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: &opts.Bucket,
+		Key:    &objectKey,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to get object '%s' from bucket '%s': %w", objectKey, opts.Bucket, err)
+	}
+	defer resp.Body.Close()
+	etag := strings.Trim(aws.ToString(resp.ETag), `"`)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", fmt.Errorf("failed to create directory for '%s': %w", path, err)
+	}
+
+	body := resp.Body
+	sparseMap := resp.Metadata[MetadataSparse]
+	if target := resp.Metadata[MetadataHardlink]; target != "" {
+		if opts.PreserveHardlinks {
+			if targetPath, ok := localPathForKey(opts.LocalDir, opts.Prefix, target); ok {
+				if _, err := os.Stat(targetPath); err == nil {
+					return etag, replaceWithLink(targetPath, path)
+				}
+			}
+		}
+		targetResp, err := client.GetObject(ctx, &s3.GetObjectInput{
+			Bucket: &opts.Bucket,
+			Key:    &target,
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to get object '%s' from bucket '%s': %w", target, opts.Bucket, err)
+		}
+		defer targetResp.Body.Close()
+		body = targetResp.Body
+		sparseMap = targetResp.Metadata[MetadataSparse]
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".cfr2-download-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create local file for '%s': %w", path, err)
+	}
+	defer os.Remove(tmp.Name())
+	tmp.Chmod(0o644)
+
+	if sparseMap != "" {
+		err = restoreSparse(tmp, body, sparseMap)
+	} else {
+		_, err = io.Copy(tmp, body)
+	}
+	if err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("failed to write object content to file '%s': %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("failed to write object content to file '%s': %w", path, err)
+	}
+	if opts.PreservePerms {
+		if err := restorePerms(logger(client), tmp.Name(), resp.Metadata); err != nil {
+			return "", err
+		}
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", fmt.Errorf("failed to move download into place at '%s': %w", path, err)
+	}
+	if resp.LastModified != nil {
+		os.Chtimes(path, time.Now(), *resp.LastModified)
+	}
+
+	return etag, nil
+}
+
+// replaceWithLink makes path a hard link to target, atomically replacing any existing file.
+func replaceWithLink(target, path string) error {
+	tmp := filepath.Join(filepath.Dir(path), ".cfr2-link-"+filepath.Base(path))
+	os.Remove(tmp)
+	if err := os.Link(target, tmp); err != nil {
+		return fmt.Errorf("failed to link '%s' to '%s': %w", path, target, err)
+	}
+	err := os.Rename(tmp, path)
+	// Renaming onto a link to the same file succeeds without removing tmp.
+	os.Remove(tmp)
+	if err != nil {
+		return fmt.Errorf("failed to link '%s' to '%s': %w", path, target, err)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package r2
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func TestPlanBidirectionalSync(t *testing.T) {
+	dir := t.TempDir()
+	mtime := time.Unix(1700000000, 0)
+	file := func(size, mtime int64) *localFile {
+		return &localFile{path: filepath.Join(dir, "a.txt"), size: size, mtime: mtime}
+	}
+	object := func(size int64, etag string) *types.Object {
+		return &types.Object{Key: aws.String("a.txt"), Size: aws.Int64(size), ETag: aws.String(`"` + etag + `"`), LastModified: aws.Time(mtime)}
+	}
+	synced := &syncStateEntry{Size: 3, Mtime: mtime.Unix(), ETag: "e1"}
+
+	tests := []struct {
+		name         string
+		local        *localFile
+		remote       *types.Object
+		state        *syncStateEntry
+		sameContent  bool
+		prefer       string
+		want         []syncActionKind
+		wantConflict bool
+		wantState    bool
+	}{
+		{name: "first run, same content", local: file(3, mtime.Unix()), remote: object(3, "e1"), sameContent: true, wantState: true},
+		{name: "first run, same size but different content", local: file(3, mtime.Unix()), remote: object(3, "e1"), wantConflict: true},
+		{name: "first run, different sizes", local: file(4, mtime.Unix()), remote: object(3, "e1"), wantConflict: true},
+		{name: "first run, local only", local: file(3, mtime.Unix()), want: []syncActionKind{syncUpload}},
+		{name: "first run, remote only", remote: object(3, "e1"), want: []syncActionKind{syncDownload}},
+		{name: "unchanged", local: file(3, mtime.Unix()), remote: object(3, "e1"), state: synced, wantState: true},
+		{name: "edited locally", local: file(4, mtime.Unix()+60), remote: object(3, "e1"), state: synced, want: []syncActionKind{syncUpload}, wantState: true},
+		{name: "edited remotely", local: file(3, mtime.Unix()), remote: object(5, "e2"), state: synced, want: []syncActionKind{syncDownload}, wantState: true},
+		{name: "edited on both sides", local: file(4, mtime.Unix()+60), remote: object(5, "e2"), state: synced, wantConflict: true, wantState: true},
+		{name: "edited on both sides, local preferred", local: file(4, mtime.Unix()+60), remote: object(5, "e2"), state: synced, prefer: ResolveLocal, want: []syncActionKind{syncUpload}, wantConflict: true, wantState: true},
+		{name: "deleted locally", remote: object(3, "e1"), state: synced, want: []syncActionKind{syncDelete}, wantState: true},
+		{name: "deleted remotely", local: file(3, mtime.Unix()), state: synced, want: []syncActionKind{syncDeleteLocal}, wantState: true},
+		{name: "deleted locally, edited remotely", remote: object(5, "e2"), state: synced, wantConflict: true, wantState: true},
+		{name: "deleted remotely, edited locally", local: file(4, mtime.Unix()+60), state: synced, wantConflict: true, wantState: true},
+		{name: "deleted on both sides", state: synced},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			local := map[string]localFile{}
+			if tt.local != nil {
+				local["a.txt"] = *tt.local
+			}
+			remote := map[string]types.Object{}
+			if tt.remote != nil {
+				remote["a.txt"] = *tt.remote
+			}
+			state := &syncState{Mode: syncModeBidirectional, Entries: map[string]syncStateEntry{}}
+			if tt.state != nil {
+				state.Entries["a.txt"] = *tt.state
+			}
+			sameContent := map[string]string{}
+			if tt.sameContent {
+				sameContent["a.txt"] = "hash"
+			}
+			result := &SyncResult{}
+
+			actions := planBidirectionalSync(NewWriterLogger(nil), SyncOptions{LocalDir: dir, Bidirectional: true, Prefer: tt.prefer}, local, remote, state, sameContent, result)
+			var kinds []syncActionKind
+			for _, action := range actions {
+				kinds = append(kinds, action.kind)
+			}
+			if len(kinds) != len(tt.want) || len(kinds) > 0 && kinds[0] != tt.want[0] {
+				t.Errorf("planned %v, want %v", kinds, tt.want)
+			}
+			if got := result.Conflicts > 0; got != tt.wantConflict {
+				t.Errorf("conflict = %v, want %v", got, tt.wantConflict)
+			}
+			if _, got := state.Entries["a.txt"]; got != tt.wantState {
+				t.Errorf("state records the key: %v, want %v", got, tt.wantState)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package r2
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// syncResumeEntry is one action left over when a sync stopped at its deadline.
+type syncResumeEntry struct {
+	Action string `json:"action"`
+	Key    string `json:"key"`
+	Path   string `json:"path,omitempty"`
+}
+
+// syncResume is the resume file written when a sync stops at its deadline. The next run plans
+// from scratch as usual, but performs the recorded actions first.
+type syncResume struct {
+	Remaining []syncResumeEntry `json:"remaining"`
+}
+
+func (k syncActionKind) String() string {
+	switch k {
+	case syncDelete:
+		return "delete"
+	case syncDownload:
+		return "download"
+	case syncDeleteLocal:
+		return "delete-local"
+	default:
+		return "upload"
+	}
+}
+
+// loadSyncResume reads a resume file. A missing file yields an empty resume.
+func loadSyncResume(path string) (*syncResume, error) {
+	resume := &syncResume{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return resume, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sync resume file '%s': %w", path, err)
+	}
+	if err := json.Unmarshal(data, resume); err != nil {
+		return nil, fmt.Errorf("failed to parse sync resume file '%s': %w", path, err)
+	}
+	return resume, nil
+}
+
+// saveSyncResume records the actions a sync did not get to.
+func saveSyncResume(path string, actions []syncAction) error {
+	resume := syncResume{Remaining: make([]syncResumeEntry, 0, len(actions))}
+	for _, action := range actions {
+		resume.Remaining = append(resume.Remaining, syncResumeEntry{
+			Action: action.kind.String(),
+			Key:    action.key,
+			Path:   action.localPath,
+		})
+	}
+	data, err := json.MarshalIndent(resume, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write sync resume file '%s': %w", path, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write sync resume file '%s': %w", path, err)
+	}
+	return nil
+}
+
+// prioritize moves the actions on keys recorded in the resume file to the front of the plan,
+// so that consecutive time-boxed runs make progress instead of repeating the same work.
+func (r *syncResume) prioritize(actions []syncAction) {
+	if len(r.Remaining) == 0 {
+		return
+	}
+	pending := make(map[string]bool, len(r.Remaining))
+	for _, entry := range r.Remaining {
+		pending[entry.Key] = true
+	}
+	sort.SliceStable(actions, func(i, j int) bool {
+		return pending[actions[i].key] && !pending[actions[j].key]
+	})
+}
@@ -0,0 +1,77 @@
+package r2
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// syncStateEntry records a file as it was on both sides at the end of the previous sync.
+type syncStateEntry struct {
+	Size   int64  `json:"size"`
+	Mtime  int64  `json:"mtime"`
+	ETag   string `json:"etag"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// Sync modes recorded in state files.
+const (
+	syncModeIncremental   = "incremental"
+	syncModeBidirectional = "bidirectional"
+)
+
+// syncState is the state file shared by consecutive sync runs of the same directory, prefix and
+// mode.
+type syncState struct {
+	// Mode is the sync mode that wrote the state. The modes record different things: incremental
+	// state lists what was uploaded, bidirectional state what both sides had in common, and a
+	// bidirectional sync would take a key missing from the directory but listed in incremental
+	// state as deleted locally.
+	Mode    string                    `json:"mode"`
+	Entries map[string]syncStateEntry `json:"entries"`
+}
+
+// loadSyncState reads the state file of a sync mode. A missing file yields an empty state; state
+// written by another mode, or without a mode, is refused.
+func loadSyncState(path, mode string) (*syncState, error) {
+	state := &syncState{Mode: mode, Entries: map[string]syncStateEntry{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sync state '%s': %w", path, err)
+	}
+	state.Mode = ""
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("failed to parse sync state '%s': %w", path, err)
+	}
+	if state.Mode != mode {
+		written := state.Mode
+		if written == "" {
+			written = "unknown"
+		}
+		return nil, fmt.Errorf("sync state '%s' was written in %s mode, not %s mode; remove it to start over", path, written, mode)
+	}
+	if state.Entries == nil {
+		state.Entries = map[string]syncStateEntry{}
+	}
+	return state, nil
+}
+
+// save writes the state file atomically so an interrupted run never leaves it truncated.
+func (s *syncState) save(path string) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write sync state '%s': %w", path, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write sync state '%s': %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package r2
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func TestLoadSyncStateModes(t *testing.T) {
+	tests := []struct {
+		name    string
+		written string
+		load    string
+		wantErr bool
+	}{
+		{"same mode", syncModeIncremental, syncModeIncremental, false},
+		{"incremental read as bidirectional", syncModeIncremental, syncModeBidirectional, true},
+		{"bidirectional read as incremental", syncModeBidirectional, syncModeIncremental, true},
+		{"missing mode", "", syncModeBidirectional, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "state.json")
+			state := &syncState{Mode: tt.written, Entries: map[string]syncStateEntry{"a.txt": {Size: 1}}}
+			if err := state.save(path); err != nil {
+				t.Fatal(err)
+			}
+			_, err := loadSyncState(path, tt.load)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadSyncState() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadSyncStateMissingFile(t *testing.T) {
+	state, err := loadSyncState(filepath.Join(t.TempDir(), "state.json"), syncModeBidirectional)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Mode != syncModeBidirectional || len(state.Entries) != 0 {
+		t.Fatalf("got mode %q with %d entries, want an empty bidirectional state", state.Mode, len(state.Entries))
+	}
+}
+
+// An incremental run followed by a bidirectional run of the same directory must never delete the
+// objects the directory does not have.
+func TestIncrementalThenBidirectionalSync(t *testing.T) {
+	dir := t.TempDir()
+	mtime := time.Unix(1700000000, 0)
+	local := map[string]localFile{
+		"a.txt": {path: filepath.Join(dir, "a.txt"), size: 1, mtime: mtime.Unix()},
+	}
+	remote := map[string]types.Object{
+		"a.txt":      {Key: aws.String("a.txt"), Size: aws.Int64(1), ETag: aws.String(`"a"`), LastModified: aws.Time(mtime)},
+		"remote.txt": {Key: aws.String("remote.txt"), Size: aws.Int64(2), ETag: aws.String(`"r"`), LastModified: aws.Time(mtime)},
+	}
+	opts := SyncOptions{LocalDir: dir, Incremental: true}
+
+	incremental := &syncState{Mode: syncModeIncremental, Entries: map[string]syncStateEntry{}}
+	actions, err := planSync(context.Background(), nil, opts, local, remote, incremental, &SyncResult{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actions) != 0 {
+		t.Fatalf("incremental run planned %d action(s), want none", len(actions))
+	}
+	if _, ok := incremental.Entries["remote.txt"]; ok {
+		t.Fatal("incremental state records 'remote.txt', which has no local file")
+	}
+	path := filepath.Join(dir, "state.json")
+	if err := incremental.save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := loadSyncState(path, syncModeBidirectional); err == nil {
+		t.Fatal("bidirectional sync accepted the state of an incremental sync")
+	}
+
+	opts = SyncOptions{LocalDir: dir, Bidirectional: true}
+	bidirectional := &syncState{Mode: syncModeBidirectional, Entries: map[string]syncStateEntry{}}
+	sameContent := map[string]string{"a.txt": "hash"}
+	for _, action := range planBidirectionalSync(NewWriterLogger(nil), opts, local, remote, bidirectional, sameContent, &SyncResult{}) {
+		if action.kind == syncDelete {
+			t.Fatalf("bidirectional run deletes '%s'", action.key)
+		}
+		if action.key == "remote.txt" && action.kind != syncDownload {
+			t.Fatalf("bidirectional run plans %v for 'remote.txt', want a download", action.kind)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package r2
+
+import (
+	"runtime/debug"
+	"strings"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// Version is the version reported in the User-Agent of requests. Release builds set it with
+// -ldflags "-X github.com/baowuhe/go-cfr2/r2.Version=v1.2.3"; otherwise the module version is used.
+var Version = ""
+
+// toolVersion returns Version, or the version of the main module the binary was built from.
+func toolVersion() string {
+	if Version != "" {
+		return Version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return "dev"
+}
+
+// userAgentOptions identify the tool, its version and the command in the User-Agent of requests,
+// followed by the words of suffix, so that traffic can be attributed on the Cloudflare side.
+// Words of the form name/value are added as such.
+func userAgentOptions(command, suffix string) []func(*middleware.Stack) error {
+	options := []func(*middleware.Stack) error{awsmiddleware.AddUserAgentKeyValue("go-cfr2", toolVersion())}
+	if command != "" {
+		options = append(options, awsmiddleware.AddUserAgentKeyValue("cmd", command))
+	}
+	for _, word := range strings.Fields(suffix) {
+		if name, value, ok := strings.Cut(word, "/"); ok {
+			options = append(options, awsmiddleware.AddUserAgentKeyValue(name, value))
+		} else {
+			options = append(options, awsmiddleware.AddUserAgentKey(word))
+		}
+	}
+	return options
+}
@@ -0,0 +1,46 @@
+package r2
+
+import (
+	"context"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// maxIdleConnsPerHost is how many idle connections to the endpoint are kept open for reuse. It is
+// well above the SDK default of 10, so that connections of highly concurrent batch transfers stay
+// alive between queued objects instead of paying for a new TLS handshake each time.
+const maxIdleConnsPerHost = 128
+
+// WarmConnections opens n connections to the endpoint of a bucket ahead of a batch of transfers, by
+// sending n concurrent HeadBucket requests, so that the first transfers do not all wait for a TLS
+// handshake at once. It is best-effort: failures are left for the transfers to report. Offline, it
+// sends nothing.
+func WarmConnections(ctx context.Context, client *s3.Client, bucketName string, n int) {
+	if Offline(client) {
+		return
+	}
+	if n > maxIdleConnsPerHost {
+		n = maxIdleConnsPerHost
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: &bucketName})
+		}()
+	}
+	wg.Wait()
+}
+
+// warmUpThreshold is the number of transfers per worker above which a batch warms up its
+// connections first. Smaller batches would spend more on the extra requests than they save.
+const warmUpThreshold = 4
+
+// warmForBatch warms up one connection per worker if a batch of the given size is worth it.
+func warmForBatch(ctx context.Context, client *s3.Client, bucketName string, concurrency, transfers int) {
+	if concurrency > 1 && transfers >= concurrency*warmUpThreshold {
+		WarmConnections(ctx, client, bucketName, concurrency)
+	}
+}
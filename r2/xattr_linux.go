@@ -0,0 +1,55 @@
+//go:build linux
+
+package r2
+
+import (
+	"bytes"
+	"errors"
+	"syscall"
+)
+
+// readXattrs returns the extended attributes of a file. File systems without extended
+// attribute support yield none.
+func readXattrs(path string) (map[string][]byte, error) {
+	size, err := syscall.Listxattr(path, nil)
+	if errors.Is(err, syscall.ENOTSUP) || size == 0 {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	names := make([]byte, size)
+	size, err = syscall.Listxattr(path, names)
+	if err != nil {
+		return nil, err
+	}
+
+	xattrs := map[string][]byte{}
+	for _, name := range bytes.Split(names[:size], []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+		valueSize, err := syscall.Getxattr(path, string(name), nil)
+		if err != nil {
+			return nil, err
+		}
+		value := make([]byte, valueSize)
+		valueSize, err = syscall.Getxattr(path, string(name), value)
+		if err != nil {
+			return nil, err
+		}
+		xattrs[string(name)] = value[:valueSize]
+	}
+	return xattrs, nil
+}
+
+// writeXattrs sets extended attributes on a file.
+func writeXattrs(path string, xattrs map[string][]byte) error {
+	var errs []error
+	for name, value := range xattrs {
+		if err := syscall.Setxattr(path, name, value, 0); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package r2
+
+// readXattrs reports no extended attributes on platforms where they are not supported.
+func readXattrs(path string) (map[string][]byte, error) {
+	return nil, nil
+}
+
+// writeXattrs ignores extended attributes on platforms where they are not supported.
+func writeXattrs(path string, xattrs map[string][]byte) error {
+	return nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// deletedObject is an object listed in a restore manifest. Size, ETag and LastModified are left out
+// where the deletion did not look at the object, as for sync. R2 keeps no versions of objects, so
+// the ETag is what tells a copy of the deleted content apart.
+type deletedObject struct {
+	Key string `json:"key"`
+	// Path is where the restore script looks for a copy of the object, relative to the directory
+	// it is given. It is left out when that is the key, and holds the key relative to the sync
+	// prefix for sync, whose local directory has the copies.
+	Path         string     `json:"path,omitempty"`
+	Size         *int64     `json:"size,omitempty"`
+	ETag         string     `json:"etag,omitempty"`
+	LastModified *time.Time `json:"last_modified,omitempty"`
+	// ArchivedTo is the key the object was moved to instead of being deleted, see sync --archive-to.
+	ArchivedTo string `json:"archived_to,omitempty"`
+}
+
+// restoreManifest lists the objects a command deleted, written by --restore-manifest together with
+// a shell script that puts them back.
+type restoreManifest struct {
+	Bucket  string          `json:"bucket"`
+	Deleted time.Time       `json:"deleted"`
+	Objects []deletedObject `json:"objects"`
+
+	mu    sync.Mutex
+	saved bool
+}
+
+func newRestoreManifest(bucketName string) *restoreManifest {
+	return &restoreManifest{Bucket: bucketName, Deleted: time.Now().UTC().Truncate(time.Second), Objects: []deletedObject{}}
+}
+
+// add records a deleted object.
+func (m *restoreManifest) add(obj deletedObject) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Objects = append(m.Objects, obj)
+}
+
+// addObject records a deleted object as listed or fetched with a HEAD request.
+func (m *restoreManifest) addObject(obj types.Object) {
+	m.add(deletedObject{
+		Key:          aws.ToString(obj.Key),
+		Size:         obj.Size,
+		ETag:         strings.Trim(aws.ToString(obj.ETag), `"`),
+		LastModified: obj.LastModified,
+	})
+}
+
+// restoreScriptPath returns the path of the script written next to a restore manifest: restore.sh
+// for restore.json.
+func restoreScriptPath(manifestPath string) string {
+	return strings.TrimSuffix(manifestPath, filepath.Ext(manifestPath)) + ".sh"
+}
+
+// checkRestoreManifestPath returns an error if the manifest or the script would overwrite an
+// existing file, so that a command refuses to start rather than lose the manifest once it deleted
+// the objects.
+func checkRestoreManifestPath(path string) error {
+	for _, name := range []string{path, restoreScriptPath(path)} {
+		if _, err := os.Lstat(name); err == nil {
+			return fmt.Errorf("'%s' already exists; choose another restore manifest", name)
+		}
+	}
+	return nil
+}
+
+// save writes the manifest and its restore script, once. It is written even when the command
+// failed part way, since the objects deleted until then are gone all the same. Existing files are
+// never overwritten, as they may be the only record of an earlier deletion.
+func (m *restoreManifest) save(path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.saved {
+		return nil
+	}
+	m.saved = true
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode restore manifest: %w", err)
+	}
+	if err := writeNewFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write restore manifest '%s': %w", path, err)
+	}
+	scriptPath := restoreScriptPath(path)
+	if err := writeNewFile(scriptPath, []byte(m.script(path)), 0o755); err != nil {
+		return fmt.Errorf("failed to write restore script '%s': %w", scriptPath, err)
+	}
+	fmt.Printf("Listed %d deleted object(s) in '%s'; '%s' restores them.\n", len(m.Objects), path, scriptPath)
+	return nil
+}
+
+// writeNewFile writes data to a file that must not exist yet.
+func writeNewFile(path string, data []byte, perm os.FileMode) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// script returns a shell script restoring the objects of the manifest with 'go-cfr2 cp'. Archived
+// objects are copied back from the archive; deleted ones are uploaded from a directory holding
+// copies of them under their keys, such as a backup or the output of a re-run build.
+func (m *restoreManifest) script(manifestPath string) string {
+	var b strings.Builder
+	b.WriteString("#!/bin/sh\n")
+	fmt.Fprintf(&b, "# Restores the %d object(s) go-cfr2 deleted from bucket '%s' on %s, listed in %s.\n",
+		len(m.Objects), m.Bucket, m.Deleted.Format(time.RFC3339), filepath.Base(manifestPath))
+	b.WriteString("# Archived objects are copied back from the archive. Deleted objects are uploaded again from\n")
+	b.WriteString("# the directory given as the first argument, which holds copies of them under their keys, or,\n")
+	b.WriteString("# for a sync, under their paths in the synced directory.\n")
+	b.WriteString("dir=\"${1:-.}\"\n")
+	fmt.Fprintf(&b, "bucket=%s\n", shellQuote(m.Bucket))
+	b.WriteString("failed=0\n\n")
+	b.WriteString("copy_back() {\n")
+	b.WriteString("\tgo-cfr2 cp \"r2://$bucket/$1\" \"r2://$bucket/$2\" || failed=1\n")
+	b.WriteString("}\n\n")
+	b.WriteString("upload() {\n")
+	b.WriteString("\tif [ -f \"$dir/$2\" ]; then\n")
+	b.WriteString("\t\tgo-cfr2 cp \"$dir/$2\" \"r2://$bucket/$1\" || failed=1\n")
+	b.WriteString("\telse\n")
+	b.WriteString("\t\techo \"No copy of '$1' at '$dir/$2'.\" >&2\n")
+	b.WriteString("\t\tfailed=1\n")
+	b.WriteString("\tfi\n")
+	b.WriteString("}\n\n")
+	for _, obj := range m.Objects {
+		if obj.ArchivedTo != "" {
+			fmt.Fprintf(&b, "copy_back %s %s\n", shellQuote(obj.ArchivedTo), shellQuote(obj.Key))
+		} else {
+			path := obj.Path
+			if path == "" {
+				path = obj.Key
+			}
+			fmt.Fprintf(&b, "upload %s %s\n", shellQuote(obj.Key), shellQuote(path))
+		}
+	}
+	b.WriteString("\nexit $failed\n")
+	return b.String()
+}
+
+// shellQuote quotes a word for a POSIX shell.
+func shellQuote(word string) string {
+	return "'" + strings.ReplaceAll(word, "'", `'\''`) + "'"
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRestoreScriptPaths(t *testing.T) {
+	manifest := newRestoreManifest("b")
+	manifest.add(deletedObject{Key: "site/a.html", Path: "a.html"})
+	manifest.add(deletedObject{Key: "logs/x.log"})
+	manifest.add(deletedObject{Key: "site/b.html", ArchivedTo: "archive/b.html"})
+
+	script := manifest.script("restore.json")
+	for _, line := range []string{
+		"upload 'site/a.html' 'a.html'\n",
+		"upload 'logs/x.log' 'logs/x.log'\n",
+		"copy_back 'archive/b.html' 'site/b.html'\n",
+	} {
+		if !strings.Contains(script, line) {
+			t.Errorf("script lacks %q:\n%s", line, script)
+		}
+	}
+}
+
+func TestRestoreManifestNeverOverwrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "restore.json")
+	if err := checkRestoreManifestPath(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("earlier"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkRestoreManifestPath(path); err == nil {
+		t.Error("checkRestoreManifestPath accepted an existing manifest")
+	}
+	if err := newRestoreManifest("b").save(path); err == nil {
+		t.Error("save overwrote an existing manifest")
+	}
+	if data, _ := os.ReadFile(path); string(data) != "earlier" {
+		t.Errorf("manifest changed to %q", data)
+	}
+
+	path = filepath.Join(t.TempDir(), "restore.json")
+	manifest := newRestoreManifest("b")
+	if err := manifest.save(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := manifest.save(path); err != nil {
+		t.Errorf("saving again failed: %v", err)
+	}
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/baowuhe/go-cfr2/config"
+	"github.com/baowuhe/go-cfr2/r2"
+)
+
+// accessLogEntry is one line of a server's JSON access log.
+type accessLogEntry struct {
+	Time      string  `json:"time"`
+	RequestID string  `json:"request_id"`
+	ClientIP  string  `json:"client_ip"`
+	User      string  `json:"user,omitempty"`
+	Method    string  `json:"method"`
+	Path      string  `json:"path"`
+	Status    int     `json:"status"`
+	Bytes     int64   `json:"bytes"`
+	LatencyMS float64 `json:"latency_ms"`
+	UserAgent string  `json:"user_agent,omitempty"`
+}
+
+// statusRecorder remembers the status code and body size written through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(p []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(p)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// serverOperations are the request methods the server modes answer, which scoped tokens may be
+// restricted to.
+var serverOperations = []string{http.MethodGet, http.MethodHead}
+
+// serverGuard applies the [server] configuration to the handler of a server mode: it refuses
+// clients outside AllowIPs, authenticates with basic auth or bearer tokens, keeps scoped tokens to
+// their buckets and prefix, and logs every request.
+type serverGuard struct {
+	cfg     config.ServerConfig
+	allowed []netip.Prefix
+	trusted []netip.Prefix
+	// resolve maps a request path to the bucket and key it reads, for checking scoped tokens.
+	resolve  func(path string) (bucket, key string)
+	log      io.Writer
+	logMu    sync.Mutex
+	closeLog func() error
+
+	// active counts the requests served with the guard. Once it is retired, the access log is
+	// closed as soon as the last of them finishes.
+	activeMu sync.Mutex
+	active   int
+	retired  bool
+	closed   bool
+}
+
+// newServerGuard validates the server configuration and opens the access log.
+func newServerGuard(cfg config.ServerConfig, resolve func(path string) (bucket, key string)) (*serverGuard, error) {
+	g := &serverGuard{cfg: cfg, resolve: resolve, log: os.Stdout, closeLog: func() error { return nil }}
+	var err error
+	if g.allowed, err = parseAddrRanges("AllowIPs", cfg.AllowIPs); err != nil {
+		return nil, err
+	}
+	if g.trusted, err = parseAddrRanges("TrustedProxies", cfg.TrustedProxies); err != nil {
+		return nil, err
+	}
+	for name, password := range cfg.Users {
+		if digest, ok := strings.CutPrefix(password, "sha256:"); ok {
+			if b, err := hex.DecodeString(digest); err != nil || len(b) != sha256.Size {
+				return nil, fmt.Errorf("invalid sha256 password of user '%s'", name)
+			}
+		}
+	}
+
+	// The tokens are normalized on a copy, as the configuration is shared with the caller.
+	g.cfg.Tokens = slices.Clone(cfg.Tokens)
+	for i := range g.cfg.Tokens {
+		token := &g.cfg.Tokens[i]
+		if token.Token == "" {
+			return nil, fmt.Errorf("[[server.token]] entry %d has no Token", i+1)
+		}
+		if _, err := path.Match(token.Bucket, ""); err != nil {
+			return nil, fmt.Errorf("invalid Bucket glob '%s' of [[server.token]] entry %d", token.Bucket, i+1)
+		}
+		if token.Prefix != "" && !strings.HasSuffix(token.Prefix, "/") {
+			token.Prefix += "/"
+		}
+		token.Operations = slices.Clone(token.Operations)
+		for j, operation := range token.Operations {
+			token.Operations[j] = strings.ToUpper(operation)
+			if !slices.Contains(serverOperations, token.Operations[j]) {
+				return nil, fmt.Errorf("unknown operation '%s' of [[server.token]] entry %d, use %s", operation, i+1, strings.Join(serverOperations, " or "))
+			}
+		}
+	}
+
+	switch cfg.AccessLog {
+	case "":
+	case "off":
+		g.log = io.Discard
+	default:
+		f, err := os.OpenFile(cfg.AccessLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open access log: %w", err)
+		}
+		g.log = f
+		g.closeLog = f.Close
+	}
+	return g, nil
+}
+
+// Close retires the guard: its access log is closed once the requests still using it finish.
+func (g *serverGuard) Close() error {
+	g.activeMu.Lock()
+	defer g.activeMu.Unlock()
+	g.retired = true
+	if g.active > 0 || g.closed {
+		return nil
+	}
+	g.closed = true
+	return g.closeLog()
+}
+
+// acquire registers a request about to be served with the guard. It fails once the guard is
+// closed, and the request must then be served by its replacement.
+func (g *serverGuard) acquire() bool {
+	g.activeMu.Lock()
+	defer g.activeMu.Unlock()
+	if g.closed {
+		return false
+	}
+	g.active++
+	return true
+}
+
+// release marks a request registered with acquire as finished.
+func (g *serverGuard) release() {
+	g.activeMu.Lock()
+	defer g.activeMu.Unlock()
+	g.active--
+	if g.retired && g.active == 0 && !g.closed {
+		g.closed = true
+		g.closeLog()
+	}
+}
+
+// Wrap returns next guarded by the configured address allowlist and authentication, with logging.
+func (g *serverGuard) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		requestID := r.Header.Get("X-Request-ID")
+		if !r2.ValidRequestID(requestID) {
+			requestID = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", requestID)
+		r = r.WithContext(r2.WithRequestID(r.Context(), requestID))
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		clientIP := g.clientIP(r)
+		user, scope, ok := "", (*config.ServerToken)(nil), g.allows(clientIP)
+		if !ok {
+			http.Error(rec, "forbidden", http.StatusForbidden)
+		} else if user, scope, ok = g.authenticate(r); !ok {
+			rec.Header().Set("WWW-Authenticate", `Basic realm="go-cfr2", charset="UTF-8"`)
+			http.Error(rec, "unauthorized", http.StatusUnauthorized)
+		} else if scope != nil && !g.inScope(scope, r.Method, r.URL.Path) {
+			http.Error(rec, "forbidden", http.StatusForbidden)
+		} else {
+			next.ServeHTTP(rec, r)
+		}
+
+		line, _ := json.Marshal(accessLogEntry{
+			Time:      start.UTC().Format(time.RFC3339Nano),
+			RequestID: requestID,
+			ClientIP:  clientIP,
+			User:      user,
+			Method:    r.Method,
+			Path:      r.URL.RequestURI(),
+			Status:    rec.status,
+			Bytes:     rec.bytes,
+			LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+			UserAgent: r.UserAgent(),
+		})
+		g.logMu.Lock()
+		g.log.Write(append(line, '\n'))
+		g.logMu.Unlock()
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// parseAddrRanges parses a list of addresses and CIDR ranges of the setting name.
+func parseAddrRanges(name string, entries []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, entry := range entries {
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(entry)
+			if addrErr != nil {
+				return nil, fmt.Errorf("invalid %s entry '%s', use an address or CIDR range", name, entry)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// clientIP returns the address of the client. That is the peer address, unless the peer is a
+// trusted proxy: then X-Forwarded-For is walked from the right, past the trusted proxies, and the
+// first hop that is not one of them is the client. Hops further left were written by the client
+// itself and are never believed.
+func (g *serverGuard) clientIP(r *http.Request) string {
+	client, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		client = r.RemoteAddr
+	}
+	if !containsAddr(g.trusted, client) {
+		return client
+	}
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(header, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		client = hops[i]
+		if !containsAddr(g.trusted, client) {
+			break
+		}
+	}
+	return client
+}
+
+func (g *serverGuard) allows(clientIP string) bool {
+	return len(g.allowed) == 0 || containsAddr(g.allowed, clientIP)
+}
+
+// containsAddr reports whether the address ip lies in one of prefixes.
+func containsAddr(prefixes []netip.Prefix, ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticate checks the request's credentials, returning the user or token name for the log and,
+// for a scoped token, its scope. Requests need none when no users or tokens are configured.
+func (g *serverGuard) authenticate(r *http.Request) (string, *config.ServerToken, bool) {
+	if len(g.cfg.Users) == 0 && len(g.cfg.BearerTokens) == 0 && len(g.cfg.Tokens) == 0 {
+		return "", nil, true
+	}
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		for _, candidate := range g.cfg.BearerTokens {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(candidate)) == 1 {
+				return "", nil, true
+			}
+		}
+		for i, candidate := range g.cfg.Tokens {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(candidate.Token)) == 1 {
+				return candidate.Name, &g.cfg.Tokens[i], true
+			}
+		}
+		return "", nil, false
+	}
+	name, password, ok := r.BasicAuth()
+	if !ok {
+		return "", nil, false
+	}
+	want, known := g.cfg.Users[name]
+	if !known {
+		return name, nil, false
+	}
+	if digest, ok := strings.CutPrefix(want, "sha256:"); ok {
+		sum := sha256.Sum256([]byte(password))
+		return name, nil, subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(strings.ToLower(digest))) == 1
+	}
+	return name, nil, subtle.ConstantTimeCompare([]byte(password), []byte(want)) == 1
+}
+
+// inScope reports whether a scoped token may use a request method on the object a request path
+// resolves to.
+func (g *serverGuard) inScope(token *config.ServerToken, method, requestPath string) bool {
+	if len(token.Operations) > 0 && !slices.Contains(token.Operations, method) {
+		return false
+	}
+	bucket, key := g.resolve(requestPath)
+	if token.Bucket != "" {
+		if ok, _ := path.Match(token.Bucket, bucket); !ok {
+			return false
+		}
+	}
+	// A local directory resolves dot segments, which would lead out of the prefix.
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "." || segment == ".." {
+			return false
+		}
+	}
+	return strings.HasPrefix(key, token.Prefix)
+}
+
+// readinessInterval is how long the result of a readiness check is reused, so that frequent
+// probes do not turn into a request to R2 each.
+const readinessInterval = 10 * time.Second
+
+// readinessCheck runs a check at most once per readinessInterval.
+type readinessCheck struct {
+	check   func(context.Context) error
+	mu      sync.Mutex
+	checked time.Time
+	err     error
+}
+
+func (c *readinessCheck) result() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Since(c.checked) >= readinessInterval {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		c.err = c.check(ctx)
+		c.checked = time.Now()
+	}
+	return c.err
+}
+
+// defaultHealthPrefix is where the health endpoints of a server live by default. It is reserved, so
+// that objects named healthz or readyz at the root stay reachable.
+const defaultHealthPrefix = "/_cfr2/"
+
+// withHealthEndpoints answers <prefix>healthz while the process runs and <prefix>readyz while check
+// succeeds, for orchestrators, and passes every other request to next. The endpoints need no
+// authentication. An empty prefix disables them.
+func withHealthEndpoints(prefix string, check func(context.Context) error, next http.Handler) http.Handler {
+	if prefix == "" {
+		return next
+	}
+	ready := &readinessCheck{check: check}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case prefix + "healthz":
+			fmt.Fprintln(w, "ok")
+		case prefix + "readyz":
+			if err := ready.result(); err != nil {
+				http.Error(w, fmt.Sprintf("not ready: %v", err), http.StatusServiceUnavailable)
+				return
+			}
+			fmt.Fprintln(w, "ok")
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/baowuhe/go-cfr2/config"
+)
+
+func TestServerGuardClientIP(t *testing.T) {
+	g, err := newServerGuard(config.ServerConfig{AccessLog: "off", TrustedProxies: []string{"10.0.0.0/8", "192.0.2.1"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{"203.0.113.5:1234", nil, "203.0.113.5"},
+		// Only trusted proxies may set the client address.
+		{"203.0.113.5:1234", []string{"198.51.100.7"}, "203.0.113.5"},
+		{"10.0.0.1:1234", nil, "10.0.0.1"},
+		{"10.0.0.1:1234", []string{"198.51.100.7"}, "198.51.100.7"},
+		// A client prepending its own hops does not get past the rightmost untrusted one.
+		{"10.0.0.1:1234", []string{"127.0.0.1, 198.51.100.7"}, "198.51.100.7"},
+		{"10.0.0.1:1234", []string{"127.0.0.1, 198.51.100.7, 192.0.2.1, 10.2.3.4"}, "198.51.100.7"},
+		{"10.0.0.1:1234", []string{"127.0.0.1", "198.51.100.7, 10.2.3.4"}, "198.51.100.7"},
+		{"10.0.0.1:1234", []string{"10.9.9.9, 10.2.3.4"}, "10.9.9.9"},
+		{"[::ffff:10.0.0.1]:1234", []string{"198.51.100.7"}, "198.51.100.7"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.remoteAddr
+		for _, value := range tt.forwarded {
+			r.Header.Add("X-Forwarded-For", value)
+		}
+		if got := g.clientIP(r); got != tt.want {
+			t.Errorf("clientIP(%s, %q) = %q, want %q", tt.remoteAddr, tt.forwarded, got, tt.want)
+		}
+	}
+}
+
+func TestNewServerGuardRejectsInvalidRanges(t *testing.T) {
+	for _, cfg := range []config.ServerConfig{
+		{AllowIPs: []string{"10.0.0.0/33"}},
+		{TrustedProxies: []string{"proxy.local"}},
+	} {
+		if _, err := newServerGuard(cfg, nil); err == nil {
+			t.Errorf("newServerGuard(%+v) succeeded, want error", cfg)
+		}
+	}
+}
+
+func TestServerGuardClosesLogAfterLastRequest(t *testing.T) {
+	g, err := newServerGuard(config.ServerConfig{AccessLog: "off"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := 0
+	g.closeLog = func() error { closed++; return nil }
+
+	if !g.acquire() {
+		t.Fatal("acquire failed on an open guard")
+	}
+	g.Close()
+	if closed != 0 {
+		t.Fatal("access log closed while a request was in flight")
+	}
+	// Still open, so a request that loaded the old handler may use it.
+	if !g.acquire() {
+		t.Fatal("acquire failed on a retired guard with requests in flight")
+	}
+	g.release()
+	g.release()
+	if closed != 1 {
+		t.Fatalf("access log closed %d times, want 1", closed)
+	}
+	if g.acquire() {
+		t.Error("acquire succeeded on a closed guard")
+	}
+	g.Close()
+	if closed != 1 {
+		t.Errorf("access log closed %d times after a second Close, want 1", closed)
+	}
+}
+
+func TestWithHealthEndpoints(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusTeapot) })
+	notReady := func(context.Context) error { return errors.New("unreachable") }
+	tests := []struct {
+		prefix, path string
+		want         int
+	}{
+		{defaultHealthPrefix, "/_cfr2/healthz", http.StatusOK},
+		{defaultHealthPrefix, "/_cfr2/readyz", http.StatusServiceUnavailable},
+		// Objects with the names of the endpoints are not shadowed.
+		{defaultHealthPrefix, "/healthz", http.StatusTeapot},
+		{defaultHealthPrefix, "/readyz", http.StatusTeapot},
+		{"/ops/", "/ops/healthz", http.StatusOK},
+		{"", "/_cfr2/healthz", http.StatusTeapot},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		withHealthEndpoints(tt.prefix, notReady, next).ServeHTTP(rec, httptest.NewRequest("GET", tt.path, nil))
+		if rec.Code != tt.want {
+			t.Errorf("prefix %q, GET %s: status %d, want %d", tt.prefix, tt.path, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestServerGuardScopedTokens(t *testing.T) {
+	cfg := config.ServerConfig{AccessLog: "off", Tokens: []config.ServerToken{
+		{Name: "team-a", Token: "a", Bucket: "team-a-*", Prefix: "team-a"},
+		{Name: "probe", Token: "p", Operations: []string{"head"}},
+	}}
+	resolve := func(requestPath string) (string, string) {
+		bucket, key, _ := strings.Cut(strings.TrimPrefix(requestPath, "/"), "/")
+		return bucket, key
+	}
+	g, err := newServerGuard(cfg, resolve)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Tokens[0].Prefix != "team-a" || cfg.Tokens[1].Operations[0] != "head" {
+		t.Error("newServerGuard changed the caller's configuration")
+	}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	tests := []struct {
+		token, method, path string
+		want                int
+	}{
+		{"a", "GET", "/team-a-www/team-a/index.html", http.StatusOK},
+		{"a", "HEAD", "/team-a-www/team-a/index.html", http.StatusOK},
+		// The prefix ends on a segment boundary.
+		{"a", "GET", "/team-a-www/team-ab/index.html", http.StatusForbidden},
+		{"a", "GET", "/team-a-www/team-a", http.StatusForbidden},
+		{"a", "GET", "/team-a-www/team-a/../secret", http.StatusForbidden},
+		{"a", "GET", "/team-b-www/team-a/index.html", http.StatusForbidden},
+		{"p", "HEAD", "/any/key", http.StatusOK},
+		{"p", "GET", "/any/key", http.StatusForbidden},
+		{"x", "GET", "/team-a-www/team-a/index.html", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		r.Header.Set("Authorization", "Bearer "+tt.token)
+		rec := httptest.NewRecorder()
+		g.Wrap(next).ServeHTTP(rec, r)
+		if rec.Code != tt.want {
+			t.Errorf("token %q, %s %s: status %d, want %d", tt.token, tt.method, tt.path, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestNewServerGuardRejectsUnknownOperations(t *testing.T) {
+	cfg := config.ServerConfig{Tokens: []config.ServerToken{{Token: "t", Operations: []string{"PUT"}}}}
+	if _, err := newServerGuard(cfg, nil); err == nil {
+		t.Error("newServerGuard accepted the PUT operation, which the server does not answer")
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/baowuhe/go-cfr2/cfapi"
+	"github.com/baowuhe/go-cfr2/config"
+)
+
+// shortLinkAlphabet avoids characters that are easily confused when a link is read out or retyped.
+const shortLinkAlphabet = "23456789abcdefghijkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ"
+
+const shortLinkCodeLen = 8
+
+// shortenURL stores longURL under a random code in the configured Workers KV namespace, expiring
+// together with the URL, and returns the short link served by the redirect Worker.
+func shortenURL(ctx context.Context, cfg *config.R2Config, longURL string, ttl time.Duration) (string, error) {
+	if cfg.APIToken == "" || cfg.ShortLinkNamespaceID == "" || cfg.ShortLinkBaseURL == "" {
+		return "", fmt.Errorf("short links need APIToken, ShortLinkNamespaceID and ShortLinkBaseURL to be set in config")
+	}
+
+	code := shortLinkCode()
+	client := cfapi.NewClient(cfg.AccountID, cfg.APIToken)
+	if err := client.PutKVValue(ctx, cfg.ShortLinkNamespaceID, code, longURL, ttl); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(cfg.ShortLinkBaseURL, "/") + "/" + code, nil
+}
+
+// shortLinkCode returns a random code of shortLinkCodeLen characters from shortLinkAlphabet.
+func shortLinkCode() string {
+	buf := make([]byte, shortLinkCodeLen)
+	rand.Read(buf)
+	for i, b := range buf {
+		// The modulo bias over 56 characters is negligible for link codes.
+		buf[i] = shortLinkAlphabet[int(b)%len(shortLinkAlphabet)]
+	}
+	return string(buf)
+}
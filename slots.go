@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/baowuhe/go-cfr2/r2"
+	"github.com/baowuhe/go-cfr2/utils"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// deploySlots are the slots 'deploy --slot' uploads a target to. One of them is active at a time,
+// so the other can be deployed and checked before 'deploy switch' makes it live.
+var deploySlots = []string{"blue", "green"}
+
+// slotPointer records the active slot of a target. Workers written by 'worker generate' serve the
+// objects below Prefix while the pointer exists, and below their own prefix otherwise.
+type slotPointer struct {
+	Target   string    `json:"target"`
+	Slot     string    `json:"slot"`
+	Prefix   string    `json:"prefix"`
+	Switched time.Time `json:"switched"`
+}
+
+// slotPrefixFor returns the prefix a slot of the live prefix is deployed to: a sibling such as
+// site.blue/ for site/, or _blue/ for the root of the bucket.
+func slotPrefixFor(prefix, slot string) string {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix == "" {
+		return "_" + slot + "/"
+	}
+	return prefix + "." + slot + "/"
+}
+
+// slotPointerKeyFor returns the key of the pointer to the active slot of the live prefix: a sibling
+// such as site.slot.json for site/, or _slot.json for the root of the bucket.
+func slotPointerKeyFor(prefix string) string {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix == "" {
+		return "_slot.json"
+	}
+	return prefix + ".slot.json"
+}
+
+// slotKeys returns the slot prefixes and the pointer of the live prefix, which are inside it if it
+// is the root of the bucket.
+func slotKeys(prefix string) []string {
+	keys := []string{slotPointerKeyFor(prefix)}
+	for _, slot := range deploySlots {
+		keys = append(keys, slotPrefixFor(prefix, slot))
+	}
+	return keys
+}
+
+// parseSlot checks a slot name given on the command line.
+func parseSlot(value string) (string, error) {
+	slot := strings.ToLower(strings.TrimSpace(value))
+	for _, known := range deploySlots {
+		if slot == known {
+			return slot, nil
+		}
+	}
+	return "", fmt.Errorf("Invalid slot '%s'. Use %s.", value, strings.Join(deploySlots, " or "))
+}
+
+// readSlotPointer returns the pointer to the active slot of the live prefix, or nil if no slot was
+// ever switched to.
+func readSlotPointer(ctx context.Context, client *s3.Client, bucketName, livePrefix string) (*slotPointer, error) {
+	key := slotPointerKeyFor(livePrefix)
+	body, err := r2.OpenObject(ctx, client, bucketName, key)
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	var pointer slotPointer
+	if err := json.NewDecoder(body).Decode(&pointer); err != nil {
+		return nil, fmt.Errorf("failed to parse slot pointer '%s': %w", key, err)
+	}
+	return &pointer, nil
+}
+
+// writeSlotPointer makes a slot the active one. The pointer is a single small object, so readers
+// see either the old slot or the new one.
+func writeSlotPointer(ctx context.Context, client *s3.Client, bucketName, livePrefix string, pointer slotPointer) error {
+	data, err := json.Marshal(pointer)
+	if err != nil {
+		return fmt.Errorf("failed to encode slot pointer: %w", err)
+	}
+	_, err = r2.UploadStreamWithOptions(ctx, client, bucketName, slotPointerKeyFor(livePrefix), bytes.NewReader(data), r2.UploadOptions{
+		ContentType:  aws.String("application/json"),
+		CacheControl: aws.String("no-store"),
+		Quiet:        true,
+	})
+	return err
+}
+
+// deploySlot deploys a target to one of its slots. The active slot is refused, so that the live
+// version only changes with 'deploy switch'.
+func deploySlot(ctx context.Context, client *s3.Client, targetName string, opts r2.SyncOptions, livePrefix, slot string) {
+	current, err := readSlotPointer(ctx, client, opts.Bucket, livePrefix)
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to read the active slot of target '%s': %v", targetName, err))
+	}
+	if current != nil && current.Slot == slot {
+		other := deploySlots[0]
+		if other == slot {
+			other = deploySlots[1]
+		}
+		utils.ExitWithError(fmt.Sprintf("Slot '%s' of target '%s' is live. Deploy to slot '%s' and switch to it instead.", slot, targetName, other))
+	}
+
+	opts.Prefix = slotPrefixFor(livePrefix, slot)
+	fmt.Printf("Deploying '%s' to slot '%s' below '%s' in bucket '%s' (target '%s')...\n", opts.LocalDir, slot, opts.Prefix, opts.Bucket, targetName)
+	result, err := r2.Sync(ctx, client, opts)
+	if result != nil {
+		fmt.Printf("Deploy complete: %d uploaded, %d deleted, %d unchanged, %d failed.\n", result.Uploaded, result.Deleted, result.Unchanged, result.Failed)
+	}
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to deploy slot '%s' of target '%s': %v", slot, targetName, err))
+	}
+	if !opts.DryRun {
+		fmt.Printf("Make it live with 'go-cfr2 deploy switch -t %s %s'.\n", targetName, slot)
+	}
+}
+
+// refuseWhileSlotActive exits if a slot of the live prefix is active, since Workers then serve the
+// slot and changes to the live prefix would not show.
+func refuseWhileSlotActive(ctx context.Context, client *s3.Client, bucketName, livePrefix, targetName string) {
+	current, err := readSlotPointer(ctx, client, bucketName, livePrefix)
+	if err != nil {
+		utils.ExitWithError(fmt.Sprintf("Failed to read the active slot of target '%s': %v", targetName, err))
+	}
+	if current != nil {
+		utils.ExitWithError(fmt.Sprintf("Target '%s' serves slot '%s', not '%s'. Deploy with --slot and make it live with 'deploy switch' instead.", targetName, current.Slot, livePrefix))
+	}
+}
@@ -0,0 +1,53 @@
+// Package storage abstracts where objects are kept, so that commands can work against an R2
+// bucket or a local directory alike, and other backends can be added later.
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrNotExist is returned for a key that holds no object.
+var ErrNotExist = errors.New("object does not exist")
+
+// ObjectInfo describes an object in a backend.
+type ObjectInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+	// ETag identifies the content of the object. It is quoted, as in HTTP.
+	ETag string
+	// ContentType and CacheControl are empty when unknown, which Walk may leave them.
+	ContentType  string
+	CacheControl string
+	Metadata     map[string]string
+}
+
+// PutOptions holds optional settings for Backend.Put. Backends that cannot store a setting
+// ignore it.
+type PutOptions struct {
+	ContentType  string
+	CacheControl string
+	Metadata     map[string]string
+}
+
+// Backend stores objects under slash-separated keys. Implementations are safe for concurrent use.
+type Backend interface {
+	// Stat describes the object under key, or returns ErrNotExist.
+	Stat(ctx context.Context, key string) (ObjectInfo, error)
+	// Open opens the object under key for reading, or returns ErrNotExist. The caller must close
+	// the reader.
+	Open(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error)
+	// OpenRange opens length bytes of the object under key, starting at offset.
+	OpenRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+	// Put stores the content of body under key, replacing any object there.
+	Put(ctx context.Context, key string, body io.Reader, opts PutOptions) error
+	// Delete removes the object under key. Deleting a missing object succeeds.
+	Delete(ctx context.Context, key string) error
+	// Walk calls fn for every object below prefix, in key order, until fn returns an error.
+	Walk(ctx context.Context, prefix string, fn func(ObjectInfo) error) error
+	// String describes the backend in messages, e.g. "bucket 'www'".
+	String() string
+}
@@ -0,0 +1,362 @@
+package storage
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults of CacheOptions.
+const (
+	DefaultCacheTTL           = time.Minute
+	DefaultCacheMemoryBytes   = 64 << 20
+	DefaultCacheMaxObjectSize = 8 << 20
+	DefaultCacheDiskBytes     = 1 << 30
+	DefaultCacheEntries       = 10000
+)
+
+// cacheFileSuffix marks the files of the disk tier, which are removed when a cache starts.
+const cacheFileSuffix = ".cfr2cache"
+
+// CacheOptions configures a Cache. Zero values select the defaults.
+type CacheOptions struct {
+	// TTL is how long a cached description is trusted. After that it is revalidated with a Stat,
+	// and cached content is kept if the ETag is unchanged.
+	TTL time.Duration
+	// MaxMemoryBytes bounds the content held in memory.
+	MaxMemoryBytes int64
+	// MaxObjectSize is the size of the largest object whose content is cached.
+	MaxObjectSize int64
+	// DiskDir, when set, holds content evicted from memory as a second tier.
+	DiskDir string
+	// MaxDiskBytes bounds the content held in DiskDir.
+	MaxDiskBytes int64
+	// MaxEntries bounds the number of cached keys.
+	MaxEntries int
+}
+
+// CacheStats counts the outcomes of reads through a Cache.
+type CacheStats struct {
+	MemoryHits  int64
+	DiskHits    int64
+	Revalidated int64
+	Misses      int64
+}
+
+// Cache is a Backend that keeps recently read descriptions and content of another Backend in an
+// LRU cache, in memory and optionally on disk, for servers fronting hot objects. Writes and
+// deletions through the cache invalidate the key; changes made elsewhere are noticed after TTL.
+type Cache struct {
+	backend Backend
+	opts    CacheOptions
+
+	mu        sync.Mutex
+	entries   map[string]*list.Element
+	lru       *list.List // of *cacheEntry, most recently used first
+	memBytes  int64
+	diskBytes int64
+	stats     CacheStats
+}
+
+type cacheEntry struct {
+	key     string
+	info    ObjectInfo
+	checked time.Time
+	// data is the content held in memory, nil if it is on disk or not cached.
+	data   []byte
+	onDisk bool
+}
+
+// NewCache wraps a backend in a cache. Leftover files of a previous cache in opts.DiskDir are
+// removed.
+func NewCache(backend Backend, opts CacheOptions) (*Cache, error) {
+	if opts.TTL <= 0 {
+		opts.TTL = DefaultCacheTTL
+	}
+	if opts.MaxMemoryBytes <= 0 {
+		opts.MaxMemoryBytes = DefaultCacheMemoryBytes
+	}
+	if opts.MaxObjectSize <= 0 {
+		opts.MaxObjectSize = DefaultCacheMaxObjectSize
+	}
+	if opts.MaxDiskBytes <= 0 {
+		opts.MaxDiskBytes = DefaultCacheDiskBytes
+	}
+	if opts.MaxEntries <= 0 {
+		opts.MaxEntries = DefaultCacheEntries
+	}
+	if opts.DiskDir != "" {
+		if err := os.MkdirAll(opts.DiskDir, 0o700); err != nil {
+			return nil, err
+		}
+		leftovers, _ := filepath.Glob(filepath.Join(opts.DiskDir, "*"+cacheFileSuffix))
+		for _, path := range leftovers {
+			os.Remove(path)
+		}
+	}
+	return &Cache{backend: backend, opts: opts, entries: map[string]*list.Element{}, lru: list.New()}, nil
+}
+
+// SetBackend makes the cache read from backend from now on, keeping what it holds. It is for
+// reloading a server with new credentials for the same objects.
+func (c *Cache) SetBackend(backend Backend) {
+	c.mu.Lock()
+	c.backend = backend
+	c.mu.Unlock()
+}
+
+func (c *Cache) source() Backend {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.backend
+}
+
+func (c *Cache) String() string {
+	return c.source().String()
+}
+
+// Stats returns the hit and miss counts so far.
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// Invalidate drops everything cached for key.
+func (c *Cache) Invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.remove(e)
+	}
+}
+
+// InvalidatePrefix drops everything cached for the keys below prefix; an empty prefix empties
+// the cache.
+func (c *Cache) InvalidatePrefix(prefix string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, e := range c.entries {
+		if strings.HasPrefix(key, prefix) {
+			c.remove(e)
+		}
+	}
+}
+
+func (c *Cache) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok {
+		ent := e.Value.(*cacheEntry)
+		if time.Since(ent.checked) < c.opts.TTL {
+			c.lru.MoveToFront(e)
+			c.mu.Unlock()
+			return ent.info, nil
+		}
+	}
+	c.mu.Unlock()
+
+	info, err := c.source().Stat(ctx, key)
+	if err != nil {
+		if errors.Is(err, ErrNotExist) {
+			c.Invalidate(key)
+		}
+		return ObjectInfo{}, err
+	}
+	c.store(info, nil)
+	return info, nil
+}
+
+func (c *Cache) Open(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error) {
+	if data, file, info, ok := c.cached(ctx, key); ok {
+		if file != nil {
+			return file, info, nil
+		}
+		return io.NopCloser(bytes.NewReader(data)), info, nil
+	}
+
+	body, info, err := c.source().Open(ctx, key)
+	if err != nil {
+		if errors.Is(err, ErrNotExist) {
+			c.Invalidate(key)
+		}
+		return nil, ObjectInfo{}, err
+	}
+	c.mu.Lock()
+	c.stats.Misses++
+	c.mu.Unlock()
+	if info.Size < 0 || info.Size > c.opts.MaxObjectSize {
+		c.store(info, nil)
+		return body, info, nil
+	}
+
+	defer body.Close()
+	data, err := io.ReadAll(io.LimitReader(body, c.opts.MaxObjectSize+1))
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+	if int64(len(data)) == info.Size {
+		c.store(info, data)
+	}
+	return io.NopCloser(bytes.NewReader(data)), info, nil
+}
+
+// cached returns the cached content of key, in memory or as an open file of the disk tier,
+// revalidating it first if it is older than the TTL.
+func (c *Cache) cached(ctx context.Context, key string) ([]byte, *os.File, ObjectInfo, bool) {
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	if !ok {
+		c.mu.Unlock()
+		return nil, nil, ObjectInfo{}, false
+	}
+	ent := e.Value.(*cacheEntry)
+	if ent.data == nil && !ent.onDisk {
+		c.mu.Unlock()
+		return nil, nil, ObjectInfo{}, false
+	}
+	stale := time.Since(ent.checked) >= c.opts.TTL
+	info := ent.info
+	c.mu.Unlock()
+
+	if stale {
+		current, err := c.source().Stat(ctx, key)
+		if err != nil || current.ETag != info.ETag {
+			c.Invalidate(key)
+			return nil, nil, ObjectInfo{}, false
+		}
+		c.mu.Lock()
+		c.stats.Revalidated++
+		ent.checked = time.Now()
+		c.mu.Unlock()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries[key] != e {
+		// Invalidated or replaced meanwhile.
+		return nil, nil, ObjectInfo{}, false
+	}
+	c.lru.MoveToFront(e)
+	if ent.data != nil {
+		c.stats.MemoryHits++
+		return ent.data, nil, info, true
+	}
+	file, err := os.Open(c.diskPath(key))
+	if err != nil {
+		c.remove(e)
+		return nil, nil, ObjectInfo{}, false
+	}
+	c.stats.DiskHits++
+	return nil, file, info, true
+}
+
+// OpenRange serves ranges of cached content from the cache and passes other requests through.
+func (c *Cache) OpenRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	if data, file, _, ok := c.cached(ctx, key); ok {
+		if file != nil {
+			return struct {
+				io.Reader
+				io.Closer
+			}{io.NewSectionReader(file, offset, length), file}, nil
+		}
+		end := min(offset+length, int64(len(data)))
+		offset = min(offset, end)
+		return io.NopCloser(bytes.NewReader(data[offset:end])), nil
+	}
+	return c.source().OpenRange(ctx, key, offset, length)
+}
+
+func (c *Cache) Put(ctx context.Context, key string, body io.Reader, opts PutOptions) error {
+	defer c.Invalidate(key)
+	return c.source().Put(ctx, key, body, opts)
+}
+
+func (c *Cache) Delete(ctx context.Context, key string) error {
+	defer c.Invalidate(key)
+	return c.source().Delete(ctx, key)
+}
+
+func (c *Cache) Walk(ctx context.Context, prefix string, fn func(ObjectInfo) error) error {
+	return c.source().Walk(ctx, prefix, fn)
+}
+
+// store records the description of an object and, when data is non-nil, its content. Content
+// cached under the same ETag is kept when only the description is refreshed.
+func (c *Cache) store(info ObjectInfo, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[info.Key]
+	if ok {
+		ent := e.Value.(*cacheEntry)
+		if ent.info.ETag != info.ETag || data != nil {
+			c.dropContent(ent)
+		}
+		ent.info = info
+		ent.checked = time.Now()
+		c.lru.MoveToFront(e)
+	} else {
+		e = c.lru.PushFront(&cacheEntry{key: info.Key, info: info, checked: time.Now()})
+		c.entries[info.Key] = e
+	}
+	if data != nil {
+		e.Value.(*cacheEntry).data = data
+		c.memBytes += int64(len(data))
+	}
+	c.evict()
+}
+
+// evict enforces the limits, demoting content from memory to disk and dropping the least
+// recently used entries.
+func (c *Cache) evict() {
+	for e := c.lru.Back(); e != nil && c.memBytes > c.opts.MaxMemoryBytes; e = e.Prev() {
+		ent := e.Value.(*cacheEntry)
+		if ent.data == nil {
+			continue
+		}
+		data := ent.data
+		c.dropContent(ent)
+		if c.opts.DiskDir != "" && os.WriteFile(c.diskPath(ent.key), data, 0o600) == nil {
+			ent.onDisk = true
+			c.diskBytes += int64(len(data))
+		}
+	}
+	for e := c.lru.Back(); e != nil && c.diskBytes > c.opts.MaxDiskBytes; e = e.Prev() {
+		c.dropContent(e.Value.(*cacheEntry))
+	}
+	for c.lru.Len() > c.opts.MaxEntries {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *Cache) remove(e *list.Element) {
+	ent := e.Value.(*cacheEntry)
+	c.dropContent(ent)
+	c.lru.Remove(e)
+	delete(c.entries, ent.key)
+}
+
+func (c *Cache) dropContent(ent *cacheEntry) {
+	if ent.data != nil {
+		c.memBytes -= int64(len(ent.data))
+		ent.data = nil
+	}
+	if ent.onDisk {
+		c.diskBytes -= ent.info.Size
+		os.Remove(c.diskPath(ent.key))
+		ent.onDisk = false
+	}
+}
+
+func (c *Cache) diskPath(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.opts.DiskDir, hex.EncodeToString(sum[:])+cacheFileSuffix)
+}
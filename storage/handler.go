@@ -0,0 +1,190 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HandlerOptions configures NewHandler.
+type HandlerOptions struct {
+	// IndexDocument is served for paths ending in a slash, e.g. "index.html". Empty disables it.
+	IndexDocument string
+	// CacheControl is sent for objects that have no Cache-Control of their own. Empty sends none.
+	CacheControl string
+}
+
+// NewHandler returns an HTTP handler serving the objects of a backend read-only, with the request
+// path as key. It answers conditional requests with 304 Not Modified and range requests with
+// 206 Partial Content, and sends each object's Cache-Control, so that browsers and CDNs in front
+// of it cache correctly.
+func NewHandler(backend Backend, opts HandlerOptions) http.Handler {
+	return &handler{backend: backend, opts: opts}
+}
+
+type handler struct {
+	backend Backend
+	opts    HandlerOptions
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	if key == "" || strings.HasSuffix(key, "/") {
+		if h.opts.IndexDocument == "" {
+			http.NotFound(w, r)
+			return
+		}
+		key += h.opts.IndexDocument
+	}
+
+	info, err := h.backend.Stat(r.Context(), key)
+	if errors.Is(err, ErrNotExist) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "bad gateway", http.StatusBadGateway)
+		return
+	}
+
+	header := w.Header()
+	header.Set("Accept-Ranges", "bytes")
+	if info.ETag != "" {
+		header.Set("ETag", info.ETag)
+	}
+	if !info.ModTime.IsZero() {
+		header.Set("Last-Modified", info.ModTime.UTC().Format(http.TimeFormat))
+	}
+	if cacheControl := valueOr(info.CacheControl, h.opts.CacheControl); cacheControl != "" {
+		header.Set("Cache-Control", cacheControl)
+	}
+	if notModified(r, info) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	header.Set("Content-Type", valueOr(info.ContentType, "application/octet-stream"))
+
+	offset, length, partial, ok := parseRange(r, info)
+	if !ok {
+		header.Set("Content-Range", fmt.Sprintf("bytes */%d", info.Size))
+		http.Error(w, "requested range not satisfiable", http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	header.Set("Content-Length", strconv.FormatInt(length, 10))
+	status := http.StatusOK
+	if partial {
+		header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, info.Size))
+		status = http.StatusPartialContent
+	}
+	if r.Method == http.MethodHead {
+		w.WriteHeader(status)
+		return
+	}
+
+	var body io.ReadCloser
+	switch {
+	case partial:
+		body, err = h.backend.OpenRange(r.Context(), key, offset, length)
+	case length == 0:
+		body = io.NopCloser(strings.NewReader(""))
+	default:
+		body, _, err = h.backend.Open(r.Context(), key)
+	}
+	if err != nil {
+		header.Del("Content-Length")
+		header.Del("Content-Range")
+		http.Error(w, "bad gateway", http.StatusBadGateway)
+		return
+	}
+	defer body.Close()
+	w.WriteHeader(status)
+	io.CopyN(w, body, length)
+}
+
+// notModified evaluates If-None-Match or, without it, If-Modified-Since.
+func notModified(r *http.Request, info ObjectInfo) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagMatches(inm, info.ETag)
+	}
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil || info.ModTime.IsZero() {
+		return false
+	}
+	return !info.ModTime.Truncate(time.Second).After(ims)
+}
+
+// etagMatches reports whether a list of entity tags, as sent in If-None-Match or If-Range,
+// matches etag by weak comparison.
+func etagMatches(list, etag string) bool {
+	if etag == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRange returns the part of the object a request asks for. A missing, multi-part or
+// outdated (If-Range) range selects the whole object; ok is false for an unsatisfiable range.
+func parseRange(r *http.Request, info ObjectInfo) (offset, length int64, partial, ok bool) {
+	spec, found := strings.CutPrefix(r.Header.Get("Range"), "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, info.Size, false, true
+	}
+	if ifRange := r.Header.Get("If-Range"); ifRange != "" {
+		if t, err := http.ParseTime(ifRange); err == nil {
+			if info.ModTime.Truncate(time.Second).After(t) {
+				return 0, info.Size, false, true
+			}
+		} else if strings.HasPrefix(ifRange, "W/") || ifRange != info.ETag {
+			return 0, info.Size, false, true
+		}
+	}
+
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, info.Size, false, true
+	}
+	if first == "" {
+		// A suffix range: the last n bytes.
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 {
+			return 0, 0, false, false
+		}
+		n = min(n, info.Size)
+		return info.Size - n, n, true, info.Size > 0
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 || start >= info.Size {
+		return 0, 0, false, false
+	}
+	end := info.Size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, false, false
+		}
+		end = min(end, info.Size-1)
+	}
+	return start, end - start + 1, true, true
+}
+
+func valueOr(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
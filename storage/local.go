@@ -0,0 +1,172 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Local is the Backend of a directory on the local filesystem, for testing and offline work.
+// Keys map to files below the directory; metadata other than the content type is not stored.
+type Local struct {
+	dir string
+}
+
+// NewLocal returns the backend of a directory, which is created on the first Put if needed.
+func NewLocal(dir string) *Local {
+	return &Local{dir: dir}
+}
+
+func (b *Local) String() string {
+	return fmt.Sprintf("directory '%s'", b.dir)
+}
+
+// path maps a key to a file below the directory, refusing keys that would escape it.
+func (b *Local) path(key string) (string, error) {
+	if key == "" || strings.HasSuffix(key, "/") || !filepath.IsLocal(filepath.FromSlash(key)) {
+		return "", fmt.Errorf("key '%s' cannot be stored in %s", key, b)
+	}
+	return filepath.Join(b.dir, filepath.FromSlash(key)), nil
+}
+
+func (b *Local) info(key string, fi fs.FileInfo) ObjectInfo {
+	return ObjectInfo{
+		Key:     key,
+		Size:    fi.Size(),
+		ModTime: fi.ModTime(),
+		// Like common web servers, derive the ETag from the modification time and size.
+		ETag:        fmt.Sprintf("\"%x-%x\"", fi.ModTime().UnixNano(), fi.Size()),
+		ContentType: mime.TypeByExtension(path.Ext(key)),
+	}
+}
+
+func (b *Local) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	p, err := b.path(key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	fi, err := os.Stat(p)
+	if errors.Is(err, fs.ErrNotExist) || err == nil && !fi.Mode().IsRegular() {
+		return ObjectInfo{}, fmt.Errorf("object '%s' in %s: %w", key, b, ErrNotExist)
+	}
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return b.info(key, fi), nil
+}
+
+func (b *Local) Open(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error) {
+	info, err := b.Stat(ctx, key)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+	p, _ := b.path(key)
+	file, err := os.Open(p)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+	return file, info, nil
+}
+
+func (b *Local) OpenRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	file, _, err := b.Open(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	f := file.(*os.File)
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.NewSectionReader(f, offset, length), f}, nil
+}
+
+// Put writes a temporary file and renames it into place, so readers never see partial content.
+func (b *Local) Put(ctx context.Context, key string, body io.Reader, opts PutOptions) error {
+	p, err := b.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return fmt.Errorf("failed to create directory for '%s': %w", key, err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".cfr2-*")
+	if err != nil {
+		return fmt.Errorf("failed to write '%s' to %s: %w", key, b, err)
+	}
+	_, err = io.Copy(tmp, body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), p)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write '%s' to %s: %w", key, b, err)
+	}
+	return nil
+}
+
+func (b *Local) Delete(ctx context.Context, key string) error {
+	p, err := b.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to delete '%s' from %s: %w", key, b, err)
+	}
+	return nil
+}
+
+// Walk lists the regular files below the directory. Temporary files of unfinished Puts and
+// anything that is not a regular file are skipped.
+func (b *Local) Walk(ctx context.Context, prefix string, fn func(ObjectInfo) error) error {
+	var infos []ObjectInfo
+	err := filepath.WalkDir(b.dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == b.dir && errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if !d.Type().IsRegular() || strings.HasPrefix(d.Name(), ".cfr2-") {
+			return nil
+		}
+		rel, err := filepath.Rel(b.dir, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		infos = append(infos, b.info(key, fi))
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to walk %s: %w", b, err)
+	}
+
+	slices.SortFunc(infos, func(x, y ObjectInfo) int { return strings.Compare(x.Key, y.Key) })
+	for _, info := range infos {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/baowuhe/go-cfr2/r2"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// R2 is the Backend of a prefix in an R2 bucket. Keys are relative to the prefix.
+type R2 struct {
+	client *s3.Client
+	bucket string
+	prefix string
+}
+
+// NewR2 returns the backend of the keys below prefix in a bucket.
+func NewR2(client *s3.Client, bucketName, prefix string) *R2 {
+	return &R2{client: client, bucket: bucketName, prefix: prefix}
+}
+
+func (b *R2) String() string {
+	if b.prefix == "" {
+		return fmt.Sprintf("bucket '%s'", b.bucket)
+	}
+	return fmt.Sprintf("bucket '%s' prefix '%s'", b.bucket, b.prefix)
+}
+
+func (b *R2) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	objectKey := b.prefix + key
+	resp, err := b.client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: &b.bucket, Key: &objectKey})
+	if err != nil {
+		return ObjectInfo{}, b.wrap(err, key)
+	}
+	return ObjectInfo{
+		Key:          key,
+		Size:         aws.ToInt64(resp.ContentLength),
+		ModTime:      aws.ToTime(resp.LastModified),
+		ETag:         aws.ToString(resp.ETag),
+		ContentType:  aws.ToString(resp.ContentType),
+		CacheControl: aws.ToString(resp.CacheControl),
+		Metadata:     resp.Metadata,
+	}, nil
+}
+
+func (b *R2) Open(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error) {
+	objectKey := b.prefix + key
+	resp, err := b.client.GetObject(ctx, &s3.GetObjectInput{Bucket: &b.bucket, Key: &objectKey})
+	if err != nil {
+		return nil, ObjectInfo{}, b.wrap(err, key)
+	}
+	return resp.Body, ObjectInfo{
+		Key:          key,
+		Size:         aws.ToInt64(resp.ContentLength),
+		ModTime:      aws.ToTime(resp.LastModified),
+		ETag:         aws.ToString(resp.ETag),
+		ContentType:  aws.ToString(resp.ContentType),
+		CacheControl: aws.ToString(resp.CacheControl),
+		Metadata:     resp.Metadata,
+	}, nil
+}
+
+func (b *R2) OpenRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	objectKey := b.prefix + key
+	byteRange := fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)
+	resp, err := b.client.GetObject(ctx, &s3.GetObjectInput{Bucket: &b.bucket, Key: &objectKey, Range: &byteRange})
+	if err != nil {
+		return nil, b.wrap(err, key)
+	}
+	return resp.Body, nil
+}
+
+func (b *R2) Put(ctx context.Context, key string, body io.Reader, opts PutOptions) error {
+	objectKey := b.prefix + key
+	input := &s3.PutObjectInput{Bucket: &b.bucket, Key: &objectKey, Body: body, Metadata: opts.Metadata}
+	if opts.ContentType != "" {
+		input.ContentType = &opts.ContentType
+	}
+	if opts.CacheControl != "" {
+		input.CacheControl = &opts.CacheControl
+	}
+	if _, err := manager.NewUploader(b.client).Upload(ctx, input); err != nil {
+		return fmt.Errorf("failed to upload object '%s' to bucket '%s': %w", objectKey, b.bucket, err)
+	}
+	return nil
+}
+
+func (b *R2) Delete(ctx context.Context, key string) error {
+	return r2.DeleteObject(ctx, b.client, b.bucket, b.prefix+key)
+}
+
+func (b *R2) Walk(ctx context.Context, prefix string, fn func(ObjectInfo) error) error {
+	return r2.WalkObjects(ctx, b.client, b.bucket, b.prefix+prefix, func(obj types.Object) error {
+		return fn(ObjectInfo{
+			Key:     aws.ToString(obj.Key)[len(b.prefix):],
+			Size:    aws.ToInt64(obj.Size),
+			ModTime: aws.ToTime(obj.LastModified),
+			ETag:    aws.ToString(obj.ETag),
+		})
+	})
+}
+
+// wrap adds context to an API error, turning a missing object into ErrNotExist.
+func (b *R2) wrap(err error, key string) error {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && (apiErr.ErrorCode() == "NotFound" || apiErr.ErrorCode() == "NoSuchKey") {
+		return fmt.Errorf("object '%s' in %s: %w", key, b, ErrNotExist)
+	}
+	return fmt.Errorf("failed to get object '%s' from %s: %w", key, b, err)
+}
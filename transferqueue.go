@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/baowuhe/go-cfr2/config"
+	"github.com/baowuhe/go-cfr2/utils"
+)
+
+// Transfer priorities of the daemon's queue. A free slot goes to the longest waiting interactive
+// transfer, and to a background transfer only when no interactive one is waiting.
+const (
+	priorityInteractive = "interactive"
+	priorityBackground  = "background"
+)
+
+// daemonSocketName is the Unix socket of the daemon, in the state directory.
+const daemonSocketName = "daemon.sock"
+
+// queueRequest is the single line of JSON a client sends after connecting to the daemon.
+type queueRequest struct {
+	// Op is "acquire" to wait for a transfer slot or "status" to list the queue.
+	Op       string `json:"op"`
+	Priority string `json:"priority,omitempty"`
+	Label    string `json:"label,omitempty"`
+	PID      int    `json:"pid,omitempty"`
+}
+
+// queueEntry is a transfer holding or waiting for a slot.
+type queueEntry struct {
+	ID       int       `json:"id"`
+	Priority string    `json:"priority"`
+	Label    string    `json:"label"`
+	PID      int       `json:"pid"`
+	Since    time.Time `json:"since"`
+}
+
+// queueStatus is the daemon's answer to a status request.
+type queueStatus struct {
+	Slots   int          `json:"slots"`
+	Running []queueEntry `json:"running"`
+	Waiting []queueEntry `json:"waiting"`
+}
+
+// transferQueue hands out a fixed number of transfer slots, interactive transfers first.
+type transferQueue struct {
+	mu      sync.Mutex
+	slots   int
+	nextID  int
+	running map[int]queueEntry
+	waiting []*queueWaiter
+}
+
+type queueWaiter struct {
+	entry   queueEntry
+	granted chan struct{}
+}
+
+func newTransferQueue(slots int) *transferQueue {
+	return &transferQueue{slots: slots, running: map[int]queueEntry{}}
+}
+
+// acquire waits until a slot is granted to the transfer and returns its ID, or returns the error of
+// ctx if it is done first.
+func (q *transferQueue) acquire(ctx context.Context, priority, label string, pid int) (int, error) {
+	q.mu.Lock()
+	q.nextID++
+	w := &queueWaiter{
+		entry:   queueEntry{ID: q.nextID, Priority: priority, Label: label, PID: pid, Since: time.Now()},
+		granted: make(chan struct{}),
+	}
+	q.waiting = append(q.waiting, w)
+	q.grant()
+	q.mu.Unlock()
+
+	select {
+	case <-w.granted:
+		return w.entry.ID, nil
+	case <-ctx.Done():
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		select {
+		case <-w.granted:
+			// Granted while giving up; hand the slot on.
+			q.releaseLocked(w.entry.ID)
+		default:
+			for i, waiting := range q.waiting {
+				if waiting == w {
+					q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+					break
+				}
+			}
+		}
+		return 0, ctx.Err()
+	}
+}
+
+// release frees the slot of a transfer and grants it to the next one waiting.
+func (q *transferQueue) release(id int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.releaseLocked(id)
+}
+
+func (q *transferQueue) releaseLocked(id int) {
+	delete(q.running, id)
+	q.grant()
+}
+
+// grant hands free slots to waiting transfers. It is called with q.mu held.
+func (q *transferQueue) grant() {
+	for len(q.running) < q.slots && len(q.waiting) > 0 {
+		next := 0
+		for i, w := range q.waiting {
+			if w.entry.Priority == priorityInteractive {
+				next = i
+				break
+			}
+		}
+		w := q.waiting[next]
+		q.waiting = append(q.waiting[:next], q.waiting[next+1:]...)
+		w.entry.Since = time.Now()
+		q.running[w.entry.ID] = w.entry
+		close(w.granted)
+	}
+}
+
+// status lists the running transfers in the order they started and the waiting ones in the order
+// they will be granted a slot.
+func (q *transferQueue) status() queueStatus {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	status := queueStatus{Slots: q.slots, Running: []queueEntry{}, Waiting: []queueEntry{}}
+	for _, entry := range q.running {
+		status.Running = append(status.Running, entry)
+	}
+	sort.Slice(status.Running, func(i, j int) bool { return status.Running[i].Since.Before(status.Running[j].Since) })
+	for _, priority := range []string{priorityInteractive, priorityBackground} {
+		for _, w := range q.waiting {
+			if w.entry.Priority == priority {
+				status.Waiting = append(status.Waiting, w.entry)
+			}
+		}
+	}
+	return status
+}
+
+// serveQueueConn answers one client. A transfer keeps its connection open while it runs, and its
+// slot is released when the connection closes, so a client that crashes never holds on to it.
+func (q *transferQueue) serveQueueConn(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		return
+	}
+	var req queueRequest
+	if err := json.Unmarshal(line, &req); err != nil {
+		return
+	}
+
+	switch req.Op {
+	case "status":
+		_ = json.NewEncoder(conn).Encode(q.status())
+	case "acquire":
+		if req.Priority != priorityInteractive {
+			req.Priority = priorityBackground
+		}
+		// Any read returning means the client is gone, waiting or not.
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			_, _ = io.Copy(io.Discard, reader)
+			cancel()
+		}()
+		id, err := q.acquire(ctx, req.Priority, req.Label, req.PID)
+		if err != nil {
+			return
+		}
+		defer q.release(id)
+		if _, err := conn.Write([]byte("granted\n")); err != nil {
+			return
+		}
+		<-ctx.Done()
+	}
+}
+
+// daemonSocketPath returns the path of the daemon's socket.
+func daemonSocketPath() (string, error) {
+	return config.StatePath(daemonSocketName)
+}
+
+// dialDaemon connects to the daemon. It returns a nil connection and no error if no daemon runs.
+func dialDaemon() (net.Conn, error) {
+	path, err := daemonSocketPath()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.Dial("unix", path)
+	if errors.Is(err, os.ErrNotExist) || errors.Is(err, syscall.ECONNREFUSED) {
+		// No daemon, or a killed one that left its socket behind.
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the daemon at '%s': %w", path, err)
+	}
+	return conn, nil
+}
+
+// acquireTransferSlot waits for the daemon, if one is running, to grant a transfer slot of the given
+// priority, and returns the function that releases it. Without a daemon it returns at once.
+func acquireTransferSlot(ctx context.Context, priority, label string) (func(), error) {
+	conn, err := dialDaemon()
+	if err != nil || conn == nil {
+		return func() {}, err
+	}
+	req, _ := json.Marshal(queueRequest{Op: "acquire", Priority: priority, Label: label, PID: os.Getpid()})
+	if _, err := conn.Write(append(req, '\n')); err != nil {
+		conn.Close()
+		return func() {}, fmt.Errorf("failed to queue '%s' with the daemon: %w", label, err)
+	}
+
+	granted := make(chan error, 1)
+	go func() {
+		_, err := bufio.NewReader(conn).ReadString('\n')
+		granted <- err
+	}()
+	select {
+	case err := <-granted:
+		if err != nil {
+			conn.Close()
+			// The daemon stopped; transfers go ahead without it.
+			return func() {}, nil
+		}
+		return func() { conn.Close() }, nil
+	case <-ctx.Done():
+		conn.Close()
+		return func() {}, ctx.Err()
+	}
+}
+
+// waitForTransferSlot takes an interactive transfer slot for a command run by the user and returns
+// the function that releases it. If the daemon cannot be asked, the transfer goes ahead unqueued.
+func waitForTransferSlot(ctx context.Context, label string) func() {
+	release, err := acquireTransferSlot(ctx, priorityInteractive, label)
+	if err != nil {
+		utils.Warnf("%v; transferring without queueing.", err)
+	}
+	return release
+}
+
+// queueStatusFromDaemon asks the daemon for its queue. It returns nil if no daemon runs.
+func queueStatusFromDaemon() (*queueStatus, error) {
+	conn, err := dialDaemon()
+	if err != nil || conn == nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(`{"op":"status"}` + "\n")); err != nil {
+		return nil, fmt.Errorf("failed to ask the daemon for its queue: %w", err)
+	}
+	var status queueStatus
+	if err := json.NewDecoder(conn).Decode(&status); err != nil {
+		return nil, fmt.Errorf("failed to read the daemon's queue: %w", err)
+	}
+	return &status, nil
+}
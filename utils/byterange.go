@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseByteRange parses a range of bytes given as "start-end" with both offsets inclusive,
+// "start-" for everything from start on, or "-n" for the last n bytes, and returns it as the value
+// of an HTTP Range header, e.g. "bytes=0-1023". A leading "bytes=" is accepted as well.
+func ParseByteRange(s string) (string, error) {
+	spec := strings.TrimPrefix(strings.TrimSpace(s), "bytes=")
+	first, last, ok := strings.Cut(spec, "-")
+	if !ok || (first == "" && last == "") {
+		return "", fmt.Errorf("invalid range '%s'", s)
+	}
+
+	offset := func(v string) (int64, bool) {
+		n, err := strconv.ParseInt(v, 10, 64)
+		return n, err == nil && n >= 0
+	}
+	switch {
+	case first == "":
+		if n, ok := offset(last); !ok || n == 0 {
+			return "", fmt.Errorf("invalid range '%s'", s)
+		}
+	case last == "":
+		if _, ok := offset(first); !ok {
+			return "", fmt.Errorf("invalid range '%s'", s)
+		}
+	default:
+		start, okStart := offset(first)
+		end, okEnd := offset(last)
+		if !okStart || !okEnd {
+			return "", fmt.Errorf("invalid range '%s'", s)
+		}
+		if end < start {
+			return "", fmt.Errorf("invalid range '%s': the end comes before the start", s)
+		}
+	}
+	return "bytes=" + spec, nil
+}
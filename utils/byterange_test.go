@@ -0,0 +1,34 @@
+package utils
+
+import "testing"
+
+func TestParseByteRange(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"0-1023", "bytes=0-1023", false},
+		{"1024-", "bytes=1024-", false},
+		{"-1024", "bytes=-1024", false},
+		{"bytes=0-0", "bytes=0-0", false},
+		{" 5-10 ", "bytes=5-10", false},
+		{"", "", true},
+		{"-", "", true},
+		{"10", "", true},
+		{"-0", "", true},
+		{"10-5", "", true},
+		{"a-b", "", true},
+		{"-5-10", "", true},
+	}
+	for _, tt := range tests {
+		got, err := ParseByteRange(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseByteRange(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseByteRange(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// nopWriteCloser wraps an io.Writer with a no-op Close method.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// NewCompressWriter returns a writer that compresses data written to w according to the extension of name.
+// Names ending in .gz or .gzip are gzip-compressed and names ending in .zst or .zstd are
+// zstd-compressed; any other name is passed through unchanged. The returned writer must be closed to flush the compressed stream; closing it does not close w.
+func NewCompressWriter(w io.Writer, name string) (io.WriteCloser, error) {
+	switch {
+	case strings.HasSuffix(name, ".gz"), strings.HasSuffix(name, ".gzip"):
+		return gzip.NewWriter(w), nil
+	case strings.HasSuffix(name, ".zst"), strings.HasSuffix(name, ".zstd"):
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			return nil, fmt.Errorf("failed to start zstd stream of '%s': %w", name, err)
+		}
+		return zw, nil
+	default:
+		return nopWriteCloser{w}, nil
+	}
+}
+
+// NewDecompressReader returns a reader that decompresses r according to the extension of name.
+// Names ending in .gz or .gzip are gunzipped and names ending in .zst or .zstd are decompressed
+// with zstd; any other name is passed through unchanged.
+func NewDecompressReader(r io.Reader, name string) (io.ReadCloser, error) {
+	switch {
+	case strings.HasSuffix(name, ".gz"), strings.HasSuffix(name, ".gzip"):
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read gzip stream of '%s': %w", name, err)
+		}
+		return gz, nil
+	case strings.HasSuffix(name, ".zst"), strings.HasSuffix(name, ".zstd"):
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read zstd stream of '%s': %w", name, err)
+		}
+		return zr.IOReadCloser(), nil
+	default:
+		return io.NopCloser(r), nil
+	}
+}
+
+// TrimCompressionExt removes a compression extension handled by this package from name.
+func TrimCompressionExt(name string) string {
+	for _, ext := range []string{".gz", ".gzip", ".zst", ".zstd"} {
+		if strings.HasSuffix(name, ext) {
+			return strings.TrimSuffix(name, ext)
+		}
+	}
+	return name
+}
@@ -3,10 +3,56 @@ package utils
 import (
 	"fmt"
 	"os"
+	"sync/atomic"
 )
 
-// ExitWithError prints an error message to stderr and exits the program with status code 1.
+var (
+	exitHooks     []func(msg string)
+	errorExitCode = 1
+	strict        bool
+	warnings      atomic.Int64
+)
+
+// OnExitWithError registers fn to be called with the error message after ExitWithError printed it,
+// before it exits.
+func OnExitWithError(fn func(msg string)) {
+	exitHooks = append(exitHooks, fn)
+}
+
+// SetErrorExitCode sets the status code ExitWithError exits with, for commands that give status
+// code 1 a meaning of its own.
+func SetErrorExitCode(code int) {
+	errorExitCode = code
+}
+
+// SetStrict makes ExitOnWarnings fail the command if Warnf was called, for runs that must not
+// finish with a partial result.
+func SetStrict(enabled bool) {
+	strict = enabled
+}
+
+// Warnf prints a warning about a condition the command can continue after to stderr, and counts
+// it for ExitOnWarnings. It never exits itself, so that it is safe to call from worker goroutines
+// and from work, such as a sync, that must get to save its state.
+func Warnf(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, "Warning: %s\n", fmt.Sprintf(format, args...))
+	warnings.Add(1)
+}
+
+// ExitOnWarnings exits through ExitWithError if SetStrict enabled strict mode and Warnf was called.
+// The command layer calls it once the command has finished.
+func ExitOnWarnings() {
+	if count := warnings.Load(); strict && count > 0 {
+		ExitWithError(fmt.Sprintf("The command finished with %d warning(s) (failing because of --strict).", count))
+	}
+}
+
+// ExitWithError prints an error message to stderr and exits the program with status code 1, or the
+// one set with SetErrorExitCode.
 func ExitWithError(msg string) {
 	fmt.Fprintf(os.Stderr, "× %s\n", msg)
-	os.Exit(1)
+	for _, fn := range exitHooks {
+		fn(msg)
+	}
+	os.Exit(errorExitCode)
 }
@@ -0,0 +1,336 @@
+package utils
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ParquetType is the type of a Parquet column.
+type ParquetType int
+
+const (
+	// ParquetString columns hold UTF-8 strings.
+	ParquetString ParquetType = iota
+	// ParquetInt64 columns hold int64 values.
+	ParquetInt64
+	// ParquetTimestamp columns hold time.Time values, stored as milliseconds since the epoch in UTC.
+	ParquetTimestamp
+)
+
+// ParquetColumn describes a required column of a Parquet file.
+type ParquetColumn struct {
+	Name string
+	Type ParquetType
+}
+
+// parquetRowGroupRows is the number of rows a ParquetWriter keeps in memory before writing them
+// out as a row group.
+const parquetRowGroupRows = 1 << 17
+
+// ParquetWriter writes rows as a Parquet file. Every row group holds one plain-encoded,
+// GZIP-compressed data page per column, which any Parquet reader understands. Only the current row
+// group is kept in memory, so large files can be streamed.
+type ParquetWriter struct {
+	w       io.Writer
+	offset  int64
+	columns []ParquetColumn
+	// values holds the plain-encoded values of the current row group, per column.
+	values    []bytes.Buffer
+	rows      int
+	numRows   int64
+	rowGroups []parquetRowGroup
+	err       error
+}
+
+type parquetRowGroup struct {
+	chunks  []parquetChunk
+	numRows int64
+}
+
+type parquetChunk struct {
+	offset           int64
+	uncompressedSize int64
+	compressedSize   int64
+}
+
+// NewParquetWriter starts a Parquet file with the given columns on w. Close must be called to
+// finish the file; it does not close w.
+func NewParquetWriter(w io.Writer, columns []ParquetColumn) *ParquetWriter {
+	pw := &ParquetWriter{w: w, columns: columns, values: make([]bytes.Buffer, len(columns))}
+	pw.write([]byte("PAR1"))
+	return pw
+}
+
+// Write adds a row, with a string, int64 or time.Time value per column as its type requires.
+func (pw *ParquetWriter) Write(row ...any) error {
+	if pw.err != nil {
+		return pw.err
+	}
+	if len(row) != len(pw.columns) {
+		return fmt.Errorf("parquet row has %d values for %d columns", len(row), len(pw.columns))
+	}
+	// The whole row is checked first, so that a bad value does not leave the columns uneven.
+	for i, column := range pw.columns {
+		var ok bool
+		switch column.Type {
+		case ParquetString:
+			_, ok = row[i].(string)
+		case ParquetInt64:
+			_, ok = row[i].(int64)
+		case ParquetTimestamp:
+			_, ok = row[i].(time.Time)
+		}
+		if !ok {
+			return fmt.Errorf("unsupported %T value for parquet column '%s'", row[i], column.Name)
+		}
+	}
+	for i := range pw.columns {
+		buf := &pw.values[i]
+		switch v := row[i].(type) {
+		case string:
+			buf.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(v))))
+			buf.WriteString(v)
+		case int64:
+			buf.Write(binary.LittleEndian.AppendUint64(nil, uint64(v)))
+		case time.Time:
+			buf.Write(binary.LittleEndian.AppendUint64(nil, uint64(v.UnixMilli())))
+		}
+	}
+	pw.rows++
+	if pw.rows == parquetRowGroupRows {
+		pw.flush()
+	}
+	return pw.err
+}
+
+// Close writes the remaining rows and the footer of the file.
+func (pw *ParquetWriter) Close() error {
+	if pw.rows > 0 {
+		pw.flush()
+	}
+	footer := pw.footer()
+	pw.write(footer)
+	pw.write(binary.LittleEndian.AppendUint32(nil, uint32(len(footer))))
+	pw.write([]byte("PAR1"))
+	return pw.err
+}
+
+func (pw *ParquetWriter) write(p []byte) {
+	if pw.err != nil {
+		return
+	}
+	n, err := pw.w.Write(p)
+	pw.offset += int64(n)
+	pw.err = err
+}
+
+// flush writes the buffered rows as a row group.
+func (pw *ParquetWriter) flush() {
+	group := parquetRowGroup{numRows: int64(pw.rows)}
+	for i := range pw.columns {
+		values := pw.values[i].Bytes()
+		var compressed bytes.Buffer
+		gz := gzip.NewWriter(&compressed)
+		gz.Write(values)
+		gz.Close()
+
+		// Required columns that are not nested have no repetition or definition levels, so the
+		// page holds nothing but the values.
+		var t thriftWriter
+		t.begin()
+		t.i32(1, 0) // DATA_PAGE
+		t.i32(2, int32(len(values)))
+		t.i32(3, int32(compressed.Len()))
+		t.beginStruct(5)
+		t.i32(1, int32(pw.rows))
+		t.i32(2, 0) // PLAIN
+		t.i32(3, 3) // RLE
+		t.i32(4, 3) // RLE
+		t.endStruct()
+		t.end()
+
+		group.chunks = append(group.chunks, parquetChunk{
+			offset:           pw.offset,
+			uncompressedSize: int64(len(t.buf) + len(values)),
+			compressedSize:   int64(len(t.buf) + compressed.Len()),
+		})
+		pw.write(t.buf)
+		pw.write(compressed.Bytes())
+		pw.values[i].Reset()
+	}
+	pw.rowGroups = append(pw.rowGroups, group)
+	pw.numRows += int64(pw.rows)
+	pw.rows = 0
+}
+
+// footer encodes the FileMetaData of the file.
+func (pw *ParquetWriter) footer() []byte {
+	var t thriftWriter
+	t.begin()
+	t.i32(1, 1)
+	t.beginList(2, thriftStruct, len(pw.columns)+1)
+	t.beginElement()
+	t.binary(4, "schema")
+	t.i32(5, int32(len(pw.columns)))
+	t.endStruct()
+	for _, column := range pw.columns {
+		t.beginElement()
+		t.i32(1, column.physicalType())
+		t.i32(3, 0) // REQUIRED
+		t.binary(4, column.Name)
+		switch column.Type {
+		case ParquetString:
+			t.i32(6, 0) // UTF8
+			t.beginStruct(10)
+			t.beginStruct(1) // STRING
+			t.endStruct()
+			t.endStruct()
+		case ParquetTimestamp:
+			t.i32(6, 9) // TIMESTAMP_MILLIS
+			t.beginStruct(10)
+			t.beginStruct(8) // TIMESTAMP
+			t.boolean(1, true)
+			t.beginStruct(2)
+			t.beginStruct(1) // MILLIS
+			t.endStruct()
+			t.endStruct()
+			t.endStruct()
+			t.endStruct()
+		}
+		t.endStruct()
+	}
+	t.i64(3, pw.numRows)
+	t.beginList(4, thriftStruct, len(pw.rowGroups))
+	for _, group := range pw.rowGroups {
+		t.beginElement()
+		t.beginList(1, thriftStruct, len(group.chunks))
+		var totalSize int64
+		for i, chunk := range group.chunks {
+			column := pw.columns[i]
+			t.beginElement()
+			t.i64(2, chunk.offset)
+			t.beginStruct(3)
+			t.i32(1, column.physicalType())
+			t.beginList(2, thriftI32, 1)
+			t.appendVarint(0) // PLAIN
+			t.beginList(3, thriftBinary, 1)
+			t.appendBinary(column.Name)
+			t.i32(4, 2) // GZIP
+			t.i64(5, group.numRows)
+			t.i64(6, chunk.uncompressedSize)
+			t.i64(7, chunk.compressedSize)
+			t.i64(9, chunk.offset)
+			t.endStruct()
+			t.endStruct()
+			totalSize += chunk.uncompressedSize
+		}
+		t.i64(2, totalSize)
+		t.i64(3, group.numRows)
+		t.endStruct()
+	}
+	t.binary(6, "go-cfr2")
+	t.end()
+	return t.buf
+}
+
+func (c ParquetColumn) physicalType() int32 {
+	if c.Type == ParquetString {
+		return 6 // BYTE_ARRAY
+	}
+	return 2 // INT64
+}
+
+// Types of the Thrift compact protocol.
+const (
+	thriftTrue   = 1
+	thriftFalse  = 2
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes the Thrift structures of Parquet metadata in the compact protocol.
+type thriftWriter struct {
+	buf []byte
+	// lastField holds the last field ID written in each open struct, which field headers are
+	// relative to.
+	lastField []int16
+}
+
+func (t *thriftWriter) begin() { t.lastField = append(t.lastField, 0) }
+
+func (t *thriftWriter) end() { t.endStruct() }
+
+func (t *thriftWriter) field(id int16, typ byte) {
+	last := &t.lastField[len(t.lastField)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		t.buf = append(t.buf, byte(delta)<<4|typ)
+	} else {
+		t.buf = append(t.buf, typ)
+		t.appendVarint(int64(id))
+	}
+	*last = id
+}
+
+// appendVarint appends a zigzag-encoded integer, as i16, i32 and i64 values are written.
+func (t *thriftWriter) appendVarint(v int64) {
+	t.buf = binary.AppendUvarint(t.buf, uint64(v<<1^v>>63))
+}
+
+func (t *thriftWriter) appendBinary(s string) {
+	t.buf = binary.AppendUvarint(t.buf, uint64(len(s)))
+	t.buf = append(t.buf, s...)
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.appendVarint(int64(v))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.appendVarint(v)
+}
+
+func (t *thriftWriter) binary(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.appendBinary(s)
+}
+
+func (t *thriftWriter) boolean(id int16, v bool) {
+	if v {
+		t.field(id, thriftTrue)
+	} else {
+		t.field(id, thriftFalse)
+	}
+}
+
+func (t *thriftWriter) beginStruct(id int16) {
+	t.field(id, thriftStruct)
+	t.begin()
+}
+
+func (t *thriftWriter) endStruct() {
+	t.buf = append(t.buf, 0)
+	t.lastField = t.lastField[:len(t.lastField)-1]
+}
+
+// beginList starts a list field of n elements, which are then appended. Struct elements are
+// written between beginElement and endStruct.
+func (t *thriftWriter) beginList(id int16, elemType byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.buf = append(t.buf, byte(n)<<4|elemType)
+	} else {
+		t.buf = append(t.buf, 0xf0|elemType)
+		t.buf = binary.AppendUvarint(t.buf, uint64(n))
+	}
+}
+
+func (t *thriftWriter) beginElement() { t.begin() }
@@ -0,0 +1,143 @@
+package utils
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+)
+
+// thriftValue is a decoded Thrift compact protocol value: an int64, []byte, bool, []thriftValue
+// or map[int16]thriftValue for structs.
+type thriftValue any
+
+// readThrift decodes a value of a compact protocol type from r.
+func readThrift(t *testing.T, r *bytes.Reader, typ byte) thriftValue {
+	t.Helper()
+	varint := func() int64 {
+		u, err := binary.ReadUvarint(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return int64(u>>1) ^ -int64(u&1)
+	}
+	switch typ {
+	case thriftTrue, thriftFalse:
+		return typ == thriftTrue
+	case thriftI32, thriftI64:
+		return varint()
+	case thriftBinary:
+		n, _ := binary.ReadUvarint(r)
+		b := make([]byte, n)
+		io.ReadFull(r, b)
+		return b
+	case thriftList:
+		header, _ := r.ReadByte()
+		n := int(header >> 4)
+		if n == 15 {
+			u, _ := binary.ReadUvarint(r)
+			n = int(u)
+		}
+		list := make([]thriftValue, n)
+		for i := range list {
+			list[i] = readThrift(t, r, header&0x0f)
+		}
+		return list
+	case thriftStruct:
+		fields := map[int16]thriftValue{}
+		var id int16
+		for {
+			header, err := r.ReadByte()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if header == 0 {
+				return fields
+			}
+			if delta := int16(header >> 4); delta != 0 {
+				id += delta
+			} else {
+				id = int16(varint())
+			}
+			fields[id] = readThrift(t, r, header&0x0f)
+		}
+	}
+	t.Fatalf("unexpected thrift type %d", typ)
+	return nil
+}
+
+func TestParquetWriter(t *testing.T) {
+	modTime := time.Date(2025, 1, 15, 10, 30, 0, 0, time.UTC)
+	var buf bytes.Buffer
+	pw := NewParquetWriter(&buf, []ParquetColumn{
+		{Name: "key", Type: ParquetString},
+		{Name: "size", Type: ParquetInt64},
+		{Name: "last_modified", Type: ParquetTimestamp},
+	})
+	if err := pw.Write("a.txt", int64(3), modTime); err != nil {
+		t.Fatal(err)
+	}
+	if err := pw.Write("b/c.txt", int64(1<<40), modTime.Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := pw.Write("d.txt", int64(1), "yesterday"); err == nil {
+		t.Error("Write accepted a string for a timestamp column")
+	}
+	if err := pw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data := buf.Bytes()
+	if !bytes.HasPrefix(data, []byte("PAR1")) || !bytes.HasSuffix(data, []byte("PAR1")) {
+		t.Fatal("file does not start and end with the PAR1 magic")
+	}
+	footerLen := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	footer := readThrift(t, bytes.NewReader(data[len(data)-8-footerLen:len(data)-8]), thriftStruct).(map[int16]thriftValue)
+	if footer[3] != int64(2) {
+		t.Errorf("num_rows = %v, want 2", footer[3])
+	}
+	schema := footer[2].([]thriftValue)
+	if len(schema) != 4 || string(schema[1].(map[int16]thriftValue)[4].([]byte)) != "key" {
+		t.Fatalf("unexpected schema %v", schema)
+	}
+
+	rowGroups := footer[4].([]thriftValue)
+	if len(rowGroups) != 1 {
+		t.Fatalf("%d row groups, want 1", len(rowGroups))
+	}
+	chunks := rowGroups[0].(map[int16]thriftValue)[1].([]thriftValue)
+	var columns [][]byte
+	for _, chunk := range chunks {
+		meta := chunk.(map[int16]thriftValue)[3].(map[int16]thriftValue)
+		if meta[4] != int64(2) || meta[5] != int64(2) {
+			t.Errorf("codec %v with %v values, want GZIP with 2", meta[4], meta[5])
+		}
+		page := bytes.NewReader(data[meta[9].(int64):])
+		header := readThrift(t, page, thriftStruct).(map[int16]thriftValue)
+		compressed := make([]byte, header[3].(int64))
+		io.ReadFull(page, compressed)
+		gz, err := gzip.NewReader(bytes.NewReader(compressed))
+		if err != nil {
+			t.Fatal(err)
+		}
+		values, _ := io.ReadAll(gz)
+		if int64(len(values)) != header[2].(int64) {
+			t.Errorf("page holds %d bytes, header says %v", len(values), header[2])
+		}
+		columns = append(columns, values)
+	}
+
+	wantKeys := append(binary.LittleEndian.AppendUint32(nil, 5), "a.txt"...)
+	wantKeys = append(binary.LittleEndian.AppendUint32(wantKeys, 7), "b/c.txt"...)
+	if !bytes.Equal(columns[0], wantKeys) {
+		t.Errorf("key column = %q, want %q", columns[0], wantKeys)
+	}
+	if got := binary.LittleEndian.Uint64(columns[1][8:]); got != 1<<40 {
+		t.Errorf("second size = %d, want %d", got, int64(1<<40))
+	}
+	if got := int64(binary.LittleEndian.Uint64(columns[2])); got != modTime.UnixMilli() {
+		t.Errorf("first last_modified = %d, want %d", got, modTime.UnixMilli())
+	}
+}
@@ -0,0 +1,26 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseSize parses a byte count with an optional binary unit suffix, such as "512", "100K", "100M"
+// or "1.5GiB". Units are case-insensitive and may end in "B" or "iB".
+func ParseSize(s string) (int64, error) {
+	number := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B"), "I")
+	multiplier := int64(1)
+	for i, unit := range "KMGTP" {
+		if n, ok := strings.CutSuffix(number, string(unit)); ok {
+			number, multiplier = n, int64(1)<<(10*(i+1))
+			break
+		}
+	}
+
+	value, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size '%s'", s)
+	}
+	return int64(value * float64(multiplier)), nil
+}
@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// KeyTemplateData holds the fields available to object key templates such as 'pg/{{.Date}}.sql.gz'.
+type KeyTemplateData struct {
+	Date      string // 2006-01-02
+	Time      string // 150405
+	Timestamp string // 20060102T150405
+	Unix      int64
+	Year      string
+	Month     string
+	Day       string
+	Hour      string
+	Minute    string
+	Host      string
+}
+
+// NewKeyTemplateData builds the template fields for the given point in time.
+func NewKeyTemplateData(now time.Time) KeyTemplateData {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "localhost"
+	}
+	return KeyTemplateData{
+		Date:      now.Format("2006-01-02"),
+		Time:      now.Format("150405"),
+		Timestamp: now.Format("20060102T150405"),
+		Unix:      now.Unix(),
+		Year:      now.Format("2006"),
+		Month:     now.Format("01"),
+		Day:       now.Format("02"),
+		Hour:      now.Format("15"),
+		Minute:    now.Format("04"),
+		Host:      host,
+	}
+}
+
+// ExpandKeyTemplate renders an object key template for the given point in time.
+func ExpandKeyTemplate(keyTemplate string, now time.Time) (string, error) {
+	tmpl, err := template.New("key").Option("missingkey=error").Parse(keyTemplate)
+	if err != nil {
+		return "", fmt.Errorf("invalid key template '%s': %w", keyTemplate, err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, NewKeyTemplateData(now)); err != nil {
+		return "", fmt.Errorf("failed to expand key template '%s': %w", keyTemplate, err)
+	}
+	return buf.String(), nil
+}
+
+var templateActionRegexp = regexp.MustCompile(`\{\{.*?\}\}`)
+
+// KeyTemplatePattern returns the static prefix of a key template (everything before the first action)
+// and a regular expression matching any key the template can produce.
+func KeyTemplatePattern(keyTemplate string) (string, *regexp.Regexp) {
+	prefix := keyTemplate
+	if idx := strings.Index(keyTemplate, "{{"); idx >= 0 {
+		prefix = keyTemplate[:idx]
+	}
+
+	var pattern strings.Builder
+	pattern.WriteString("^")
+	last := 0
+	for _, loc := range templateActionRegexp.FindAllStringIndex(keyTemplate, -1) {
+		pattern.WriteString(regexp.QuoteMeta(keyTemplate[last:loc[0]]))
+		pattern.WriteString(".+")
+		last = loc[1]
+	}
+	pattern.WriteString(regexp.QuoteMeta(keyTemplate[last:]))
+	pattern.WriteString("$")
+
+	return prefix, regexp.MustCompile(pattern.String())
+}